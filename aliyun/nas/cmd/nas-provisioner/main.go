@@ -0,0 +1,91 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/aliyun/nas/pkg/provision"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	provisioner        = flag.String("provisioner", "example.com/nas", "Name of the provisioner. The provisioner will only provision volumes for claims that request a StorageClass with a provisioner field set equal to this name.")
+	master             = flag.String("master", "", "Master URL")
+	kubeconfig         = flag.String("kubeconfig", "", "Absolute path to the kubeconfig")
+	id                 = flag.String("id", "", "Unique provisioner identity")
+	endpoint           = flag.String("endpoint", provision.DefaultEndpoint, "URL of the NAS API. %s is replaced with the class's region.")
+	mountRoot          = flag.String("mount-root", "/nas", "Directory under which existing file systems are mounted, each path at <mount-root>/<server>/<path>.")
+	mountTargetTimeout = flag.Duration("mount-target-timeout", 5*time.Minute, "How long to wait for a mount target to be created or deleted before giving up.")
+)
+
+func main() {
+	flag.Parse()
+	flag.Set("logtostderr", "true")
+
+	accessKeyID := os.Getenv("ALIBABA_CLOUD_ACCESS_KEY_ID")
+	accessKeySecret := os.Getenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET")
+
+	var config *rest.Config
+	var err error
+	if *master != "" || *kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		glog.Fatalf("Failed to create config: %v", err)
+	}
+	prID := string(uuid.NewUUID())
+	if *id != "" {
+		prID = *id
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create client: %v", err)
+	}
+
+	// The controller needs to know what the server version is because out-of-tree
+	// provisioners aren't officially supported until 1.5
+	serverVersion, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		glog.Fatalf("Error getting server version: %v", err)
+	}
+
+	// Create the provisioner: it implements the Provisioner interface expected by
+	// the controller
+	nasProvisioner := provision.NewNASProvisioner(prID, *endpoint, accessKeyID, accessKeySecret, *mountRoot, *mountTargetTimeout)
+
+	// Start the provision controller which will dynamically provision NAS NFS
+	// PVs
+	pc := controller.NewProvisionController(
+		clientset,
+		*provisioner,
+		nasProvisioner,
+		serverVersion.GitVersion,
+	)
+
+	pc.Run(wait.NeverStop)
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	nasAPIVersion = "2017-06-26"
+	// pageSize is the most file systems or mount targets a describe returns
+	pageSize = 100
+	// maxErrorBodyLength is how much of a non-JSON error body is reported
+	maxErrorBodyLength = 512
+
+	// the error codes of missing resources
+	errFileSystemNotFound  = "InvalidFileSystem.NotFound"
+	errMountTargetNotFound = "InvalidMountTarget.NotFound"
+
+	mountTargetActive = "Active"
+)
+
+// fileSystem is a NAS file system.
+type fileSystem struct {
+	FileSystemID string `json:"FileSystemId"`
+	Description  string `json:"Description"`
+}
+
+// mountTarget is a NAS mount target, the NFS server of a file system in a
+// VPC.
+type mountTarget struct {
+	MountTargetDomain string `json:"MountTargetDomain"`
+	Status            string `json:"Status"`
+}
+
+// nasError is the body of a NAS error response.
+type nasError struct {
+	Code    string `json:"Code"`
+	Message string `json:"Message"`
+}
+
+// nasAPIError is an error returned by the NAS API.
+type nasAPIError struct {
+	action string
+	status int
+	nasError
+}
+
+func (e *nasAPIError) Error() string {
+	return fmt.Sprintf("%s failed with status %d: %s: %s", e.action, e.status, e.Code, e.Message)
+}
+
+// isErrorCode returns whether the error is a NAS API error with the code.
+func isErrorCode(err error, code string) bool {
+	apiErr, ok := err.(*nasAPIError)
+	return ok && apiErr.Code == code
+}
+
+// nasClient manages file systems and mount targets with the NAS API of a
+// region, signing requests with an access key.
+type nasClient struct {
+	endpoint        string
+	region          string
+	accessKeyID     string
+	accessKeySecret string
+	client          *http.Client
+}
+
+func newNASClient(endpoint, region, accessKeyID, accessKeySecret string) *nasClient {
+	return &nasClient{
+		endpoint:        strings.TrimSuffix(endpoint, "/"),
+		region:          region,
+		accessKeyID:     accessKeyID,
+		accessKeySecret: accessKeySecret,
+		client:          &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// percentEncode encodes s as the signature algorithm needs: like a URL query
+// but with spaces as %20, * as %2A and ~ unencoded.
+func percentEncode(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.Replace(s, "+", "%20", -1)
+	s = strings.Replace(s, "*", "%2A", -1)
+	return strings.Replace(s, "%7E", "~", -1)
+}
+
+// sign returns the signature of a GET of the query: the HMAC-SHA1, keyed by
+// the secret, of the canonicalized query.
+func (c *nasClient) sign(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, percentEncode(k)+"="+percentEncode(query.Get(k)))
+	}
+	stringToSign := "GET&" + percentEncode("/") + "&" + percentEncode(strings.Join(pairs, "&"))
+	mac := hmac.New(sha1.New, []byte(c.accessKeySecret+"&"))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// do calls the action with the parameters and decodes the response body into
+// out, if not nil.
+func (c *nasClient) do(action string, params url.Values, out interface{}) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	query := url.Values{}
+	for k, v := range params {
+		query[k] = v
+	}
+	query.Set("Action", action)
+	query.Set("RegionId", c.region)
+	query.Set("Format", "JSON")
+	query.Set("Version", nasAPIVersion)
+	query.Set("AccessKeyId", c.accessKeyID)
+	query.Set("SignatureMethod", "HMAC-SHA1")
+	query.Set("SignatureVersion", "1.0")
+	query.Set("SignatureNonce", hex.EncodeToString(nonce))
+	query.Set("Timestamp", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	query.Set("Signature", c.sign(query))
+
+	resp, err := c.client.Get(c.endpoint + "/?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK {
+		if out != nil {
+			if err := json.Unmarshal(body, out); err != nil {
+				return fmt.Errorf("error parsing response of %s: %v", action, err)
+			}
+		}
+		return nil
+	}
+	apiErr := &nasAPIError{action: action, status: resp.StatusCode}
+	if json.Unmarshal(body, &apiErr.nasError) == nil && apiErr.Code != "" {
+		return apiErr
+	}
+	if len(body) > maxErrorBodyLength {
+		body = body[:maxErrorBodyLength]
+	}
+	return fmt.Errorf("%s failed with status %d: %s", action, resp.StatusCode, body)
+}
+
+// createFileSystem creates an NFS file system and returns its ID.
+func (c *nasClient) createFileSystem(storageType, zone, description string) (string, error) {
+	params := url.Values{
+		"ProtocolType": {"NFS"},
+		"StorageType":  {storageType},
+		"Description":  {description},
+	}
+	if zone != "" {
+		params.Set("ZoneId", zone)
+	}
+	result := &struct {
+		FileSystemID string `json:"FileSystemId"`
+	}{}
+	if err := c.do("CreateFileSystem", params, result); err != nil {
+		return "", err
+	}
+	return result.FileSystemID, nil
+}
+
+// findFileSystem returns the ID of the file system with the description, or
+// "" if there's none. File systems can't be named, so the description names
+// the ones the provisioner creates, to find them again when retrying.
+func (c *nasClient) findFileSystem(description string) (string, error) {
+	for page := 1; ; page++ {
+		result := &struct {
+			TotalCount  int `json:"TotalCount"`
+			FileSystems struct {
+				FileSystem []fileSystem `json:"FileSystem"`
+			} `json:"FileSystems"`
+		}{}
+		params := url.Values{"PageNumber": {fmt.Sprint(page)}, "PageSize": {fmt.Sprint(pageSize)}}
+		if err := c.do("DescribeFileSystems", params, result); err != nil {
+			return "", err
+		}
+		for _, fs := range result.FileSystems.FileSystem {
+			if fs.Description == description {
+				return fs.FileSystemID, nil
+			}
+		}
+		if page*pageSize >= result.TotalCount {
+			return "", nil
+		}
+	}
+}
+
+// deleteFileSystem deletes the file system, which must have no mount
+// targets. It is not an error if the file system doesn't exist.
+func (c *nasClient) deleteFileSystem(id string) error {
+	err := c.do("DeleteFileSystem", url.Values{"FileSystemId": {id}}, nil)
+	if isErrorCode(err, errFileSystemNotFound) {
+		return nil
+	}
+	return err
+}
+
+// createMountTarget creates a mount target of the file system in the VPC's
+// vSwitch, allowing the access group, and returns its domain.
+func (c *nasClient) createMountTarget(id, vpcID, vSwitchID, accessGroup string) (string, error) {
+	params := url.Values{
+		"FileSystemId":    {id},
+		"NetworkType":     {"Vpc"},
+		"VpcId":           {vpcID},
+		"VSwitchId":       {vSwitchID},
+		"AccessGroupName": {accessGroup},
+	}
+	result := &struct {
+		MountTargetDomain string `json:"MountTargetDomain"`
+	}{}
+	if err := c.do("CreateMountTarget", params, result); err != nil {
+		return "", err
+	}
+	return result.MountTargetDomain, nil
+}
+
+// describeMountTargets returns the mount targets of the file system. A
+// missing file system has none.
+func (c *nasClient) describeMountTargets(id string) ([]mountTarget, error) {
+	targets := []mountTarget{}
+	for page := 1; ; page++ {
+		result := &struct {
+			TotalCount   int `json:"TotalCount"`
+			MountTargets struct {
+				MountTarget []mountTarget `json:"MountTarget"`
+			} `json:"MountTargets"`
+		}{}
+		params := url.Values{"FileSystemId": {id}, "PageNumber": {fmt.Sprint(page)}, "PageSize": {fmt.Sprint(pageSize)}}
+		err := c.do("DescribeMountTargets", params, result)
+		if isErrorCode(err, errFileSystemNotFound) {
+			return targets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, result.MountTargets.MountTarget...)
+		if page*pageSize >= result.TotalCount {
+			return targets, nil
+		}
+	}
+}
+
+// deleteMountTarget deletes the mount target. It is not an error if the
+// mount target doesn't exist.
+func (c *nasClient) deleteMountTarget(id, domain string) error {
+	err := c.do("DeleteMountTarget", url.Values{"FileSystemId": {id}, "MountTargetDomain": {domain}}, nil)
+	if isErrorCode(err, errMountTargetNotFound) || isErrorCode(err, errFileSystemNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,382 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	provisionerIDAnn = "nasProvisionerIdentity"
+	// fileSystemIDAnn and regionAnn are the ID and region of the file system
+	// of a PV backed by its own file system. PVs without them are directories
+	// of an existing one.
+	fileSystemIDAnn = "nasFileSystemId"
+	regionAnn       = "nasRegionId"
+
+	fileSystemPrefix = "kubernetes-dynamic-"
+
+	// DefaultEndpoint is the URL of the NAS API of a region, given the region
+	DefaultEndpoint = "https://nas.%s.aliyuncs.com"
+
+	defaultStorageType = "Performance"
+	defaultAccessGroup = "DEFAULT_VPC_GROUP_NAME"
+	// defaultMountOptions are the NFS mount options Alibaba Cloud recommends
+	// for NAS
+	defaultMountOptions = "vers=4.0,noresvport"
+)
+
+type nasProvisionOptions struct {
+	// a file system per volume
+	region      string
+	zone        string
+	storageType string
+	vpcID       string
+	vSwitchID   string
+	accessGroup string
+	// a directory per volume on an existing file system
+	server string
+	path   string
+
+	mountOptions *string
+}
+
+type nasProvisioner struct {
+	// Identity of this nasProvisioner. Used to identify "this" provisioner's
+	// PVs.
+	identity string
+	// endpoint is the URL of the NAS API, formatted with the region if it
+	// contains %s
+	endpoint        string
+	accessKeyID     string
+	accessKeySecret string
+	// mountRoot is where the existing file systems are mounted, each path at
+	// <mountRoot>/<server>/<path>
+	mountRoot string
+	// pollInterval and pollTimeout determine how often and how long to wait
+	// for a mount target to be created or deleted
+	pollInterval time.Duration
+	pollTimeout  time.Duration
+}
+
+// NewNASProvisioner creates a new Alibaba Cloud NAS provisioner creating file
+// systems with the NAS API at the given endpoint and access key, and
+// directories on the existing file systems mounted under mountRoot.
+func NewNASProvisioner(id, endpoint, accessKeyID, accessKeySecret, mountRoot string, mountTargetTimeout time.Duration) controller.Provisioner {
+	return &nasProvisioner{
+		identity:        id,
+		endpoint:        endpoint,
+		accessKeyID:     accessKeyID,
+		accessKeySecret: accessKeySecret,
+		mountRoot:       mountRoot,
+		pollInterval:    5 * time.Second,
+		pollTimeout:     mountTargetTimeout,
+	}
+}
+
+var _ controller.Provisioner = &nasProvisioner{}
+
+// getClient returns a client of the NAS API of the region.
+func (p *nasProvisioner) getClient(region string) *nasClient {
+	endpoint := p.endpoint
+	if strings.Contains(endpoint, "%s") {
+		endpoint = fmt.Sprintf(endpoint, region)
+	}
+	return newNASClient(endpoint, region, p.accessKeyID, p.accessKeySecret)
+}
+
+// Provision creates a NAS file system and a mount target in the class's VPC
+// for the claim, or a directory for it on the class's existing file system,
+// and returns an NFS PV pointing at the mount target.
+func (p *nasProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	opts, err := parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := map[string]string{
+		provisionerIDAnn: p.identity,
+	}
+	var server, nfsPath string
+	if opts.server != "" {
+		if err := p.createDirectory(opts.server, opts.path, options.PVName); err != nil {
+			return nil, err
+		}
+		server, nfsPath = opts.server, path.Join("/", opts.path, options.PVName)
+	} else {
+		id, domain, err := p.createFileSystem(options.PVName, opts)
+		if err != nil {
+			return nil, err
+		}
+		annotations[fileSystemIDAnn] = id
+		annotations[regionAnn] = opts.region
+		server, nfsPath = domain, "/"
+	}
+	if opts.mountOptions != nil {
+		if *opts.mountOptions != "" {
+			annotations[v1.MountOptionAnnotation] = *opts.mountOptions
+		}
+	} else {
+		annotations[v1.MountOptionAnnotation] = defaultMountOptions
+	}
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        options.PVName,
+			Annotations: annotations,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			// NAS file systems grow as needed and directories have no quota,
+			// the capacity is only nominal
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				NFS: &v1.NFSVolumeSource{
+					Server:   server,
+					Path:     nfsPath,
+					ReadOnly: false,
+				},
+			},
+		},
+	}
+
+	return pv, nil
+}
+
+// createFileSystem creates a file system described with the PV's name, unless
+// an earlier attempt did, and a mount target of it in the class's VPC, and
+// waits for the mount target to be active. It returns the file system's ID
+// and the mount target's domain.
+func (p *nasProvisioner) createFileSystem(pvName string, opts *nasProvisionOptions) (string, string, error) {
+	nas := p.getClient(opts.region)
+	description := fileSystemPrefix + pvName
+	id, err := nas.findFileSystem(description)
+	if err != nil {
+		return "", "", err
+	}
+	if id == "" {
+		id, err = nas.createFileSystem(opts.storageType, opts.zone, description)
+		if err != nil {
+			glog.Errorf("failed to create file system %q in region %s: %v", description, opts.region, err)
+			return "", "", err
+		}
+	}
+
+	targets, err := nas.describeMountTargets(id)
+	if err != nil {
+		return "", "", err
+	}
+	domain := ""
+	if len(targets) > 0 {
+		domain = targets[0].MountTargetDomain
+	} else {
+		domain, err = nas.createMountTarget(id, opts.vpcID, opts.vSwitchID, opts.accessGroup)
+		if err != nil {
+			glog.Errorf("failed to create mount target of file system %s: %v", id, err)
+			p.rollback(nas, id)
+			return "", "", err
+		}
+	}
+
+	err = wait.Poll(p.pollInterval, p.pollTimeout, func() (bool, error) {
+		targets, err := nas.describeMountTargets(id)
+		if err != nil {
+			return false, err
+		}
+		for _, target := range targets {
+			if target.MountTargetDomain == domain {
+				return target.Status == mountTargetActive, nil
+			}
+		}
+		return false, fmt.Errorf("mount target %s disappeared", domain)
+	})
+	if err != nil {
+		glog.Errorf("mount target %s of file system %s did not become active: %v", domain, id, err)
+		p.rollback(nas, id)
+		return "", "", err
+	}
+	glog.Infof("successfully created file system %s with mount target %s in region %s", id, domain, opts.region)
+	return id, domain, nil
+}
+
+// rollback deletes a file system whose creation failed.
+func (p *nasProvisioner) rollback(nas *nasClient, id string) {
+	if err := p.deleteFileSystem(nas, id); err != nil {
+		glog.Errorf("failed to roll back creation of file system %s: %v", id, err)
+	}
+}
+
+// createDirectory creates a directory named after the PV on the existing file
+// system, which must be mounted under mountRoot.
+func (p *nasProvisioner) createDirectory(server, nfsPath, pvName string) error {
+	root, err := p.getFileSystemRoot(server, nfsPath)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(root, pvName)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return fmt.Errorf("error creating directory %s: %v", dir, err)
+	}
+	// the mode of MkdirAll is masked by the umask
+	if err := os.Chmod(dir, 0777); err != nil {
+		return fmt.Errorf("error setting mode of directory %s: %v", dir, err)
+	}
+	glog.Infof("successfully created directory %s on %s:/%s", pvName, server, nfsPath)
+	return nil
+}
+
+// getFileSystemRoot returns where the file system's path is mounted, failing
+// if it isn't, so that directories aren't created on, or deleted from, the
+// container's own filesystem.
+func (p *nasProvisioner) getFileSystemRoot(server, nfsPath string) (string, error) {
+	root := filepath.Join(p.mountRoot, server, nfsPath)
+	if _, err := os.Stat(root); err != nil {
+		return "", fmt.Errorf("file system %s:/%s is not mounted at %s: %v", server, nfsPath, root, err)
+	}
+	return root, nil
+}
+
+// Delete deletes the file system or the directory that was created by
+// Provision represented by the given PV.
+func (p *nasProvisioner) Delete(volume *v1.PersistentVolume) error {
+	ann, ok := volume.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != p.identity {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	if volume.Spec.NFS == nil {
+		return errors.New("PV is not an NFS volume")
+	}
+
+	if id, ok := volume.Annotations[fileSystemIDAnn]; ok {
+		if err := p.deleteFileSystem(p.getClient(volume.Annotations[regionAnn]), id); err != nil {
+			return err
+		}
+		glog.Infof("successfully deleted file system %s", id)
+		return nil
+	}
+
+	// the path is /<path>/<pv name>
+	nfsPath, dir := path.Split(strings.TrimPrefix(volume.Spec.NFS.Path, "/"))
+	nfsPath = strings.TrimSuffix(nfsPath, "/")
+	root, err := p.getFileSystemRoot(volume.Spec.NFS.Server, nfsPath)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(root, dir)); err != nil {
+		return fmt.Errorf("error deleting directory %s: %v", dir, err)
+	}
+	glog.Infof("successfully deleted directory %s on %s:/%s", dir, volume.Spec.NFS.Server, nfsPath)
+	return nil
+}
+
+// deleteFileSystem deletes the file system's mount targets, waits for them to
+// be gone, and deletes the file system.
+func (p *nasProvisioner) deleteFileSystem(nas *nasClient, id string) error {
+	targets, err := nas.describeMountTargets(id)
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		if err := nas.deleteMountTarget(id, target.MountTargetDomain); err != nil {
+			return err
+		}
+	}
+	if len(targets) > 0 {
+		err = wait.Poll(p.pollInterval, p.pollTimeout, func() (bool, error) {
+			targets, err := nas.describeMountTargets(id)
+			return len(targets) == 0, err
+		})
+		if err != nil {
+			return fmt.Errorf("error waiting for the mount targets of file system %s to be deleted: %v", id, err)
+		}
+	}
+	return nas.deleteFileSystem(id)
+}
+
+func parseParameters(parameters map[string]string) (*nasProvisionOptions, error) {
+	opts := &nasProvisionOptions{}
+
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "regionid":
+			opts.region = v
+		case "zoneid":
+			opts.zone = v
+		case "storagetype":
+			opts.storageType = v
+		case "vpcid":
+			opts.vpcID = v
+		case "vswitchid":
+			opts.vSwitchID = v
+		case "accessgroup":
+			opts.accessGroup = v
+		case "server":
+			opts.server = v
+		case "path":
+			opts.path = strings.Trim(v, "/")
+		case "mountoptions":
+			mountOptions := v
+			opts.mountOptions = &mountOptions
+		default:
+			return nil, fmt.Errorf("invalid option %q", k)
+		}
+	}
+	// sanity check
+	if opts.server != "" || opts.path != "" {
+		if opts.server == "" {
+			return nil, fmt.Errorf("path needs server")
+		}
+		if opts.region != "" || opts.zone != "" || opts.storageType != "" || opts.vpcID != "" || opts.vSwitchID != "" || opts.accessGroup != "" {
+			return nil, fmt.Errorf("server can't be given with file system parameters")
+		}
+		return opts, nil
+	}
+	if opts.region == "" || opts.vpcID == "" || opts.vSwitchID == "" {
+		return nil, fmt.Errorf("missing regionId, vpcId or vSwitchId, or server")
+	}
+	if opts.storageType == "" {
+		opts.storageType = defaultStorageType
+	}
+	if opts.storageType != "Performance" && opts.storageType != "Capacity" {
+		return nil, fmt.Errorf("invalid storageType %q, must be Performance or Capacity", opts.storageType)
+	}
+	if opts.accessGroup == "" {
+		opts.accessGroup = defaultAccessGroup
+	}
+	return opts, nil
+}
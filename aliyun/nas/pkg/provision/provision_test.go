@@ -0,0 +1,304 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	utiltesting "k8s.io/client-go/util/testing"
+)
+
+type fakeFileSystem struct {
+	fileSystem
+	storageType string
+	targets     map[string]*mountTarget
+}
+
+// fakeNAS serves the NAS API from memory, checking requests are signed by the
+// test access key. Mount targets become active, and deleted mount targets go
+// away, the next time they're described.
+type fakeNAS struct {
+	region      string
+	fileSystems map[string]*fakeFileSystem
+}
+
+func (f *fakeNAS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	signature := query.Get("Signature")
+	query.Del("Signature")
+	client := newNASClient("", "", "id", "secret")
+	if query.Get("AccessKeyId") != "id" || signature != client.sign(query) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(nasError{Code: "SignatureDoesNotMatch", Message: "bad signature"})
+		return
+	}
+	if query.Get("RegionId") != f.region {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	fs := f.fileSystems[query.Get("FileSystemId")]
+	if fs == nil && query.Get("FileSystemId") != "" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(nasError{Code: errFileSystemNotFound, Message: "not found"})
+		return
+	}
+	switch query.Get("Action") {
+	case "DescribeFileSystems":
+		page := []fileSystem{}
+		if query.Get("PageNumber") == "1" {
+			for _, fs := range f.fileSystems {
+				page = append(page, fs.fileSystem)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"TotalCount": len(f.fileSystems), "FileSystems": map[string]interface{}{"FileSystem": page}})
+	case "CreateFileSystem":
+		id := fmt.Sprintf("fs%d", len(f.fileSystems))
+		f.fileSystems[id] = &fakeFileSystem{
+			fileSystem:  fileSystem{FileSystemID: id, Description: query.Get("Description")},
+			storageType: query.Get("StorageType"),
+			targets:     map[string]*mountTarget{},
+		}
+		json.NewEncoder(w).Encode(map[string]string{"FileSystemId": id})
+	case "CreateMountTarget":
+		domain := fmt.Sprintf("%s-%s.%s.nas.aliyuncs.com", fs.FileSystemID, query.Get("VSwitchId"), f.region)
+		fs.targets[domain] = &mountTarget{MountTargetDomain: domain, Status: "Pending"}
+		json.NewEncoder(w).Encode(map[string]string{"MountTargetDomain": domain})
+	case "DescribeMountTargets":
+		targets := []mountTarget{}
+		for domain, target := range fs.targets {
+			switch target.Status {
+			case "Pending":
+				target.Status = mountTargetActive
+			case "Deleting":
+				delete(fs.targets, domain)
+				continue
+			}
+			targets = append(targets, *target)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"TotalCount": len(targets), "MountTargets": map[string]interface{}{"MountTarget": targets}})
+	case "DeleteMountTarget":
+		fs.targets[query.Get("MountTargetDomain")].Status = "Deleting"
+		w.Write([]byte("{}"))
+	case "DeleteFileSystem":
+		if len(fs.targets) > 0 {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(nasError{Code: "Forbidden.NotEmpty", Message: "file system has mount targets"})
+			return
+		}
+		delete(f.fileSystems, fs.FileSystemID)
+		w.Write([]byte("{}"))
+	}
+}
+
+func newTestProvisioner(endpoint, mountRoot string) *nasProvisioner {
+	p := NewNASProvisioner("id", endpoint, "id", "secret", mountRoot, time.Second).(*nasProvisioner)
+	p.pollInterval = time.Millisecond
+	return p
+}
+
+func newClaimOptions(pvName string, parameters map[string]string) controller.VolumeOptions {
+	return controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        pvName,
+		Parameters:                    parameters,
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "claim"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Gi"),
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestProvisionDeleteFileSystem(t *testing.T) {
+	nas := &fakeNAS{region: "cn-hangzhou", fileSystems: map[string]*fakeFileSystem{}}
+	server := httptest.NewServer(nas)
+	defer server.Close()
+	p := newTestProvisioner(server.URL, "")
+
+	parameters := map[string]string{"regionId": "cn-hangzhou", "vpcId": "vpc-1", "vSwitchId": "vsw-1", "storageType": "Capacity"}
+	if _, err := p.Provision(newClaimOptions("pvc-0", parameters)); err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	options := newClaimOptions("pvc-1", parameters)
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	id := pv.Annotations[fileSystemIDAnn]
+	fs, ok := nas.fileSystems[id]
+	if !ok {
+		t.Fatalf("expected file system %q to be created", id)
+	}
+	if fs.Description != "kubernetes-dynamic-pvc-1" || fs.storageType != "Capacity" {
+		t.Errorf("expected Capacity file system kubernetes-dynamic-pvc-1 but got %+v", fs)
+	}
+	domain := id + "-vsw-1.cn-hangzhou.nas.aliyuncs.com"
+	if pv.Spec.NFS.Server != domain || pv.Spec.NFS.Path != "/" {
+		t.Errorf("expected NFS volume %s:/ but got %v", domain, pv.Spec.NFS)
+	}
+	if pv.Annotations[v1.MountOptionAnnotation] != defaultMountOptions {
+		t.Errorf("expected mount options %q but got %q", defaultMountOptions, pv.Annotations[v1.MountOptionAnnotation])
+	}
+
+	// a retry finds the file system created by the first attempt
+	retried, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error retrying provisioning: %v", err)
+	}
+	if retried.Annotations[fileSystemIDAnn] != id || len(nas.fileSystems) != 2 || len(fs.targets) != 1 {
+		t.Errorf("expected retry to return file system %q but got %v", id, retried.Annotations)
+	}
+
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if _, ok := nas.fileSystems[id]; ok {
+		t.Errorf("expected file system %q to be deleted", id)
+	}
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("expected deleting a deleted volume to succeed but got: %v", err)
+	}
+}
+
+func TestProvisionDeleteDirectory(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nasProvisionTest")
+	defer os.RemoveAll(tmpDir)
+	p := newTestProvisioner("http://unused", tmpDir)
+
+	server := "fs-vsw.cn-hangzhou.nas.aliyuncs.com"
+	options := newClaimOptions("pvc-1", map[string]string{"server": server, "path": "/kubernetes", "mountOptions": ""})
+	if _, err := p.Provision(options); err == nil {
+		t.Errorf("expected provisioning on an unmounted file system to fail")
+	}
+
+	root := filepath.Join(tmpDir, server, "kubernetes")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatalf("Error creating file system root: %v", err)
+	}
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	if pv.Spec.NFS.Server != server || pv.Spec.NFS.Path != "/kubernetes/pvc-1" {
+		t.Errorf("expected NFS volume %s:/kubernetes/pvc-1 but got %v", server, pv.Spec.NFS)
+	}
+	if _, ok := pv.Annotations[v1.MountOptionAnnotation]; ok {
+		t.Errorf("expected no mount options but got %v", pv.Annotations)
+	}
+	dir := filepath.Join(root, "pvc-1")
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("expected directory %s to be created but got: %v", dir, err)
+	}
+
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected directory %s to be deleted but got: %v", dir, err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// the example of the RPC signature documentation
+	client := newNASClient("", "", "testid", "testsecret")
+	query := map[string][]string{
+		"Action":           {"DescribeRegions"},
+		"Format":           {"XML"},
+		"Version":          {"2014-05-26"},
+		"AccessKeyId":      {"testid"},
+		"SignatureMethod":  {"HMAC-SHA1"},
+		"SignatureVersion": {"1.0"},
+		"SignatureNonce":   {"3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf"},
+		"Timestamp":        {"2016-02-23T12:46:24Z"},
+	}
+	expected := "OLeaidS1JvxuMvnyHOwuJ+uX5qY="
+	if signature := client.sign(query); signature != expected {
+		t.Errorf("expected signature %s but got %s", expected, signature)
+	}
+}
+
+func TestParseParameters(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		expectError bool
+	}{
+		{
+			name:       "file system",
+			parameters: map[string]string{"regionId": "cn-hangzhou", "vpcId": "vpc-1", "vSwitchId": "vsw-1"},
+		},
+		{
+			name:       "directory",
+			parameters: map[string]string{"server": "fs.cn-hangzhou.nas.aliyuncs.com", "path": "/kubernetes", "mountOptions": "vers=3"},
+		},
+		{
+			name:        "nothing",
+			parameters:  map[string]string{},
+			expectError: true,
+		},
+		{
+			name:        "no vSwitch",
+			parameters:  map[string]string{"regionId": "cn-hangzhou", "vpcId": "vpc-1"},
+			expectError: true,
+		},
+		{
+			name:        "invalid storage type",
+			parameters:  map[string]string{"regionId": "cn-hangzhou", "vpcId": "vpc-1", "vSwitchId": "vsw-1", "storageType": "Extreme"},
+			expectError: true,
+		},
+		{
+			name:        "path without server",
+			parameters:  map[string]string{"path": "/kubernetes"},
+			expectError: true,
+		},
+		{
+			name:        "directory and file system",
+			parameters:  map[string]string{"server": "fs.cn-hangzhou.nas.aliyuncs.com", "regionId": "cn-hangzhou"},
+			expectError: true,
+		},
+		{
+			name:        "unknown parameter",
+			parameters:  map[string]string{"server": "fs.cn-hangzhou.nas.aliyuncs.com", "foo": "bar"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		_, err := parseParameters(test.parameters)
+		if test.expectError && err == nil {
+			t.Errorf("Test %s: expected error but got none", test.name)
+		} else if !test.expectError && err != nil {
+			t.Errorf("Test %s: expected no error but got: %v", test.name, err)
+		}
+	}
+}
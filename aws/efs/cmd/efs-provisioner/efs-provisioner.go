@@ -43,6 +43,9 @@ const (
 	provisionerNameKey = "PROVISIONER_NAME"
 	fileSystemIDKey    = "FILE_SYSTEM_ID"
 	awsRegionKey       = "AWS_REGION"
+
+	// defaultMountOptions are the NFS mount options AWS recommends for EFS
+	defaultMountOptions = "nfsvers=4.1,rsize=1048576,wsize=1048576,hard,timeo=600,retrans=2"
 )
 
 type efsProvisioner struct {
@@ -152,10 +155,25 @@ func (p *efsProvisioner) Provision(options controller.VolumeOptions) (*v1.Persis
 			},
 		},
 	}
+	if mountOptions := getMountOptions(options.Parameters); mountOptions != "" {
+		pv.Annotations[v1.MountOptionAnnotation] = mountOptions
+	}
 
 	return pv, nil
 }
 
+// getMountOptions returns the mount options to give the class's PVs: the
+// class's mountOptions parameter if it has one, which may be empty to use the
+// nodes' defaults, else the options AWS recommends for EFS.
+func getMountOptions(parameters map[string]string) string {
+	for k, v := range parameters {
+		if strings.ToLower(k) == "mountoptions" {
+			return v
+		}
+	}
+	return defaultMountOptions
+}
+
 func (p *efsProvisioner) createVolume(path string, gid int) error {
 	perm := os.FileMode(0771 | os.ModeSetgid)
 
@@ -68,6 +68,34 @@ func TestGetLocalPathToDelete(t *testing.T) {
 	}
 }
 
+func TestGetMountOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   string
+	}{
+		{
+			name:       "default",
+			parameters: map[string]string{"gidMin": "2000"},
+			expected:   defaultMountOptions,
+		},
+		{
+			name:       "class mount options",
+			parameters: map[string]string{"mountOptions": "nfsvers=4.1,soft"},
+			expected:   "nfsvers=4.1,soft",
+		},
+		{
+			name:       "class disables mount options",
+			parameters: map[string]string{"mountOptions": ""},
+			expected:   "",
+		},
+	}
+	for _, test := range tests {
+		got := getMountOptions(test.parameters)
+		evaluate(t, test.name, false, nil, test.expected, got, "mount options")
+	}
+}
+
 func newTestEFSProvisioner() *efsProvisioner {
 	return &efsProvisioner{
 		dnsName:    dnsName,
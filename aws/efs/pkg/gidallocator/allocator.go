@@ -88,7 +88,10 @@ func (a *Allocator) AllocateNext(options controller.VolumeOptions) (int, error)
 // Release releases the given volume's allocated GID from the appropriate GID
 // table.
 func (a *Allocator) Release(volume *v1.PersistentVolume) error {
-	class, err := a.client.Storage().StorageClasses().Get(helper.GetPersistentVolumeClass(volume), metav1.GetOptions{})
+	class, err := a.client.StorageV1().StorageClasses().Get(helper.GetPersistentVolumeClass(volume), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
 	gidMin, gidMax, err := parseClassParameters(class.Parameters)
 	if err != nil {
 		return err
@@ -203,7 +206,7 @@ func (a *Allocator) collectGids(className string, gidTable *allocator.MinMaxAllo
 
 		_, err = gidTable.Allocate(gid)
 		if err == allocator.ErrConflict {
-			glog.Warningf("gid %v found in pv %v was already allocated", gid)
+			glog.Warningf("gid %v found in pv %v was already allocated", gid, pvName)
 		} else if err != nil {
 			glog.Errorf("failed to store gid %v found in pv '%v': %v", gid, pvName, err)
 			return err
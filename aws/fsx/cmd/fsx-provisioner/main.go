@@ -0,0 +1,95 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/aws/fsx/pkg/fsx"
+	"github.com/kubernetes-incubator/external-storage/aws/fsx/pkg/provision"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	provisioner       = flag.String("provisioner", "example.com/fsx", "Name of the provisioner. The provisioner will only provision volumes for claims that request a StorageClass with a provisioner field set equal to this name.")
+	master            = flag.String("master", "", "Master URL")
+	kubeconfig        = flag.String("kubeconfig", "", "Absolute path to the kubeconfig")
+	id                = flag.String("id", "", "Unique provisioner identity")
+	mountRoot         = flag.String("mount-root", "/fsx", "Directory under which existing file systems are mounted, each at <mount-root>/<dns name>/<mount name>.")
+	fileSystemTimeout = flag.Duration("file-system-timeout", 20*time.Minute, "How long to wait for a file system to be created or deleted before giving up.")
+)
+
+func main() {
+	flag.Parse()
+	flag.Set("logtostderr", "true")
+
+	var config *rest.Config
+	var err error
+	if *master != "" || *kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		glog.Fatalf("Failed to create config: %v", err)
+	}
+	prID := string(uuid.NewUUID())
+	if *id != "" {
+		prID = *id
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create client: %v", err)
+	}
+
+	// The controller needs to know what the server version is because out-of-tree
+	// provisioners aren't officially supported until 1.5
+	serverVersion, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		glog.Fatalf("Error getting server version: %v", err)
+	}
+
+	// The region and credentials come from the environment, e.g. AWS_REGION,
+	// or the instance's role
+	sess, err := session.NewSession()
+	if err != nil {
+		glog.Fatalf("Failed to create an AWS session: %v", err)
+	}
+
+	// Create the provisioner: it implements the Provisioner interface expected by
+	// the controller
+	fsxProvisioner := provision.NewFSxProvisioner(fsx.New(sess), prID, *mountRoot, *fileSystemTimeout)
+
+	// Start the provision controller which will dynamically provision FSx for
+	// Lustre flexvolume PVs
+	pc := controller.NewProvisionController(
+		clientset,
+		*provisioner,
+		fsxProvisioner,
+		serverVersion.GitVersion,
+	)
+
+	pc.Run(wait.NeverStop)
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fsx
+
+import (
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+const (
+	opCreateFileSystem    = "CreateFileSystem"
+	opDeleteFileSystem    = "DeleteFileSystem"
+	opDescribeFileSystems = "DescribeFileSystems"
+)
+
+const (
+	// FileSystemTypeLustre is a FileSystemType enum value
+	FileSystemTypeLustre = "LUSTRE"
+
+	// FileSystemLifecycleAvailable is a FileSystemLifecycle enum value
+	FileSystemLifecycleAvailable = "AVAILABLE"
+	// FileSystemLifecycleCreating is a FileSystemLifecycle enum value
+	FileSystemLifecycleCreating = "CREATING"
+	// FileSystemLifecycleFailed is a FileSystemLifecycle enum value
+	FileSystemLifecycleFailed = "FAILED"
+	// FileSystemLifecycleDeleting is a FileSystemLifecycle enum value
+	FileSystemLifecycleDeleting = "DELETING"
+
+	// ErrCodeFileSystemNotFound is returned when no file system has the ID
+	ErrCodeFileSystemNotFound = "FileSystemNotFound"
+)
+
+// CreateFileSystem creates a file system. Requests with the same
+// ClientRequestToken create it only once.
+func (c *FSx) CreateFileSystem(input *CreateFileSystemInput) (*CreateFileSystemOutput, error) {
+	op := &request.Operation{
+		Name:       opCreateFileSystem,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &CreateFileSystemOutput{}
+	return output, c.NewRequest(op, input, output).Send()
+}
+
+// DeleteFileSystem starts deleting a file system, whose lifecycle is DELETING
+// until describing it fails with FileSystemNotFound.
+func (c *FSx) DeleteFileSystem(input *DeleteFileSystemInput) (*DeleteFileSystemOutput, error) {
+	op := &request.Operation{
+		Name:       opDeleteFileSystem,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &DeleteFileSystemOutput{}
+	return output, c.NewRequest(op, input, output).Send()
+}
+
+// DescribeFileSystems describes the file systems with the given IDs.
+func (c *FSx) DescribeFileSystems(input *DescribeFileSystemsInput) (*DescribeFileSystemsOutput, error) {
+	op := &request.Operation{
+		Name:       opDescribeFileSystems,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &DescribeFileSystemsOutput{}
+	return output, c.NewRequest(op, input, output).Send()
+}
+
+// CreateFileSystemInput is the input of CreateFileSystem.
+type CreateFileSystemInput struct {
+	_ struct{} `type:"structure"`
+
+	ClientRequestToken  *string                              `min:"1" type:"string"`
+	FileSystemType      *string                              `type:"string" required:"true" enum:"FileSystemType"`
+	LustreConfiguration *CreateFileSystemLustreConfiguration `type:"structure"`
+	SecurityGroupIds    []*string                            `type:"list"`
+	StorageCapacity     *int64                               `type:"integer" required:"true"`
+	SubnetIds           []*string                            `type:"list" required:"true"`
+	Tags                []*Tag                               `min:"1" type:"list"`
+}
+
+// CreateFileSystemLustreConfiguration is the Lustre configuration of a file
+// system being created.
+type CreateFileSystemLustreConfiguration struct {
+	_ struct{} `type:"structure"`
+
+	DeploymentType           *string `type:"string" enum:"LustreDeploymentType"`
+	ExportPath               *string `min:"3" type:"string"`
+	ImportPath               *string `min:"3" type:"string"`
+	PerUnitStorageThroughput *int64  `min:"50" type:"integer"`
+}
+
+// CreateFileSystemOutput is the output of CreateFileSystem.
+type CreateFileSystemOutput struct {
+	_ struct{} `type:"structure"`
+
+	FileSystem *FileSystem `type:"structure"`
+}
+
+// DeleteFileSystemInput is the input of DeleteFileSystem.
+type DeleteFileSystemInput struct {
+	_ struct{} `type:"structure"`
+
+	ClientRequestToken *string `min:"1" type:"string"`
+	FileSystemId       *string `min:"11" type:"string" required:"true"`
+}
+
+// DeleteFileSystemOutput is the output of DeleteFileSystem.
+type DeleteFileSystemOutput struct {
+	_ struct{} `type:"structure"`
+
+	FileSystemId *string `min:"11" type:"string"`
+	Lifecycle    *string `type:"string" enum:"FileSystemLifecycle"`
+}
+
+// DescribeFileSystemsInput is the input of DescribeFileSystems.
+type DescribeFileSystemsInput struct {
+	_ struct{} `type:"structure"`
+
+	FileSystemIds []*string `type:"list"`
+	MaxResults    *int64    `min:"1" type:"integer"`
+	NextToken     *string   `min:"1" type:"string"`
+}
+
+// DescribeFileSystemsOutput is the output of DescribeFileSystems.
+type DescribeFileSystemsOutput struct {
+	_ struct{} `type:"structure"`
+
+	FileSystems []*FileSystem `type:"list"`
+	NextToken   *string       `min:"1" type:"string"`
+}
+
+// FileSystem is the description of a file system.
+type FileSystem struct {
+	_ struct{} `type:"structure"`
+
+	DNSName             *string                        `min:"16" type:"string"`
+	FailureDetails      *FileSystemFailureDetails      `type:"structure"`
+	FileSystemId        *string                        `min:"11" type:"string"`
+	FileSystemType      *string                        `type:"string" enum:"FileSystemType"`
+	Lifecycle           *string                        `type:"string" enum:"FileSystemLifecycle"`
+	LustreConfiguration *LustreFileSystemConfiguration `type:"structure"`
+	StorageCapacity     *int64                         `type:"integer"`
+	Tags                []*Tag                         `min:"1" type:"list"`
+}
+
+// FileSystemFailureDetails says why a file system failed to be created.
+type FileSystemFailureDetails struct {
+	_ struct{} `type:"structure"`
+
+	Message *string `min:"1" type:"string"`
+}
+
+// LustreFileSystemConfiguration is the Lustre configuration of a file system.
+type LustreFileSystemConfiguration struct {
+	_ struct{} `type:"structure"`
+
+	DeploymentType *string `type:"string" enum:"LustreDeploymentType"`
+	MountName      *string `min:"1" type:"string"`
+}
+
+// Tag is a key-value pair of a resource.
+type Tag struct {
+	_ struct{} `type:"structure"`
+
+	Key   *string `min:"1" type:"string"`
+	Value *string `type:"string"`
+}
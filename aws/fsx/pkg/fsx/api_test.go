@@ -0,0 +1,86 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fsx
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*FSx, func()) {
+	server := httptest.NewServer(handler)
+	sess, err := session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("us-east-1"),
+		MaxRetries:  aws.Int(0),
+	})
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	return New(sess), server.Close
+}
+
+func TestDescribeFileSystems(t *testing.T) {
+	client, done := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); target != "AWSSimbaAPIService_v20180301.DescribeFileSystems" {
+			t.Errorf("expected DescribeFileSystems target but got %q", target)
+		}
+		if !strings.Contains(r.Header.Get("Authorization"), "/us-east-1/fsx/aws4_request") {
+			t.Errorf("expected request signed for fsx in us-east-1 but got %q", r.Header.Get("Authorization"))
+		}
+		input := map[string][]string{}
+		json.NewDecoder(r.Body).Decode(&input)
+		if len(input["FileSystemIds"]) != 1 || input["FileSystemIds"][0] != "fs-1" {
+			t.Errorf("expected FileSystemIds [fs-1] but got %v", input)
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Write([]byte(`{"FileSystems":[{"FileSystemId":"fs-1","Lifecycle":"AVAILABLE","StorageCapacity":1200,"LustreConfiguration":{"MountName":"abcdefgh"}}]}`))
+	})
+	defer done()
+
+	output, err := client.DescribeFileSystems(&DescribeFileSystemsInput{FileSystemIds: aws.StringSlice([]string{"fs-1"})})
+	if err != nil {
+		t.Fatalf("Error describing file system: %v", err)
+	}
+	fs := output.FileSystems[0]
+	if aws.StringValue(fs.Lifecycle) != FileSystemLifecycleAvailable || aws.Int64Value(fs.StorageCapacity) != 1200 || aws.StringValue(fs.LustreConfiguration.MountName) != "abcdefgh" {
+		t.Errorf("expected available 1200GiB file system with mount name abcdefgh but got %v", fs)
+	}
+}
+
+func TestFileSystemNotFound(t *testing.T) {
+	client, done := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"FileSystemNotFound","Message":"File system 'fs-1' does not exist."}`))
+	})
+	defer done()
+
+	_, err := client.DeleteFileSystem(&DeleteFileSystemInput{FileSystemId: aws.String("fs-1")})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != ErrCodeFileSystemNotFound {
+		t.Errorf("expected %s error but got: %v", ErrCodeFileSystemNotFound, err)
+	}
+}
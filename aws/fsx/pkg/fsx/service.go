@@ -0,0 +1,73 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fsx is a client of the Amazon FSx API, built like the aws-sdk-go
+// service clients, with only the operations the provisioner uses. The
+// vendored aws-sdk-go predates FSx.
+package fsx
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/private/protocol/jsonrpc"
+)
+
+// FSx provides the API operation methods for making requests to Amazon FSx.
+type FSx struct {
+	*client.Client
+}
+
+const (
+	// ServiceName is the service endpoint prefix API calls are made to.
+	ServiceName = "fsx"
+	// EndpointsID is the service ID for regions and endpoints metadata.
+	EndpointsID = ServiceName
+)
+
+// New creates a new instance of the FSx client with a session.
+func New(p client.ConfigProvider, cfgs ...*aws.Config) *FSx {
+	c := p.ClientConfig(EndpointsID, cfgs...)
+	return newClient(*c.Config, c.Handlers, c.Endpoint, c.SigningRegion, c.SigningName)
+}
+
+func newClient(cfg aws.Config, handlers request.Handlers, endpoint, signingRegion, signingName string) *FSx {
+	svc := &FSx{
+		Client: client.New(
+			cfg,
+			metadata.ClientInfo{
+				ServiceName:   ServiceName,
+				SigningName:   signingName,
+				SigningRegion: signingRegion,
+				Endpoint:      endpoint,
+				APIVersion:    "2018-03-01",
+				JSONVersion:   "1.1",
+				TargetPrefix:  "AWSSimbaAPIService_v20180301",
+			},
+			handlers,
+		),
+	}
+
+	svc.Handlers.Sign.PushBackNamed(v4.SignRequestHandler)
+	svc.Handlers.Build.PushBackNamed(jsonrpc.BuildHandler)
+	svc.Handlers.Unmarshal.PushBackNamed(jsonrpc.UnmarshalHandler)
+	svc.Handlers.UnmarshalMeta.PushBackNamed(jsonrpc.UnmarshalMetaHandler)
+	svc.Handlers.UnmarshalError.PushBackNamed(jsonrpc.UnmarshalErrorHandler)
+
+	return svc
+}
@@ -0,0 +1,451 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/aws/fsx/pkg/fsx"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/util"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	provisionerIDAnn = "fsxProvisionerIdentity"
+	// fileSystemIDAnn is the ID of the file system of a PV backed by its own
+	// file system. PVs without it are directories of an existing one.
+	fileSystemIDAnn = "fsxFileSystemId"
+	// the Lustre mount info of the PVs
+	dnsNameAnn     = "fsxDNSName"
+	mountNameAnn   = "fsxMountName"
+	mountTargetAnn = "fsxMountTarget"
+
+	fileSystemPrefix = "kubernetes-dynamic-"
+
+	defaultDriver = "aws/fsx-lustre"
+	// defaultMountName is the mount name of SCRATCH_1 file systems, which
+	// have none in their description
+	defaultMountName = "fsx"
+
+	deploymentTypeScratch1 = "SCRATCH_1"
+
+	// the flexvolume options of the PVs, named like the FSx CSI driver's
+	// volume attributes
+	dnsNameOption   = "dnsname"
+	mountNameOption = "mountname"
+	pathOption      = "path"
+)
+
+type fsxProvisionOptions struct {
+	// a file system per volume
+	subnetID                 string
+	securityGroupIDs         []string
+	deploymentType           string
+	perUnitStorageThroughput int64
+	importPath               string
+	exportPath               string
+	// a directory per volume on an existing file system
+	dnsName   string
+	mountName string
+
+	driver string
+}
+
+// fsxAPI is the subset of the FSx API the provisioner uses.
+type fsxAPI interface {
+	CreateFileSystem(input *fsx.CreateFileSystemInput) (*fsx.CreateFileSystemOutput, error)
+	DescribeFileSystems(input *fsx.DescribeFileSystemsInput) (*fsx.DescribeFileSystemsOutput, error)
+	DeleteFileSystem(input *fsx.DeleteFileSystemInput) (*fsx.DeleteFileSystemOutput, error)
+}
+
+type fsxProvisioner struct {
+	fsx fsxAPI
+	// Identity of this fsxProvisioner. Used to identify "this" provisioner's
+	// PVs.
+	identity string
+	// mountRoot is where the existing file systems are mounted, each at
+	// <mountRoot>/<dns name>/<mount name>
+	mountRoot string
+	// pollInterval and pollTimeout determine how often and how long to wait
+	// for a file system to be created or deleted
+	pollInterval time.Duration
+	pollTimeout  time.Duration
+}
+
+// NewFSxProvisioner creates a new FSx for Lustre provisioner creating file
+// systems with the given FSx client, and directories on the existing file
+// systems mounted under mountRoot.
+func NewFSxProvisioner(client *fsx.FSx, id, mountRoot string, fileSystemTimeout time.Duration) controller.Provisioner {
+	return &fsxProvisioner{
+		fsx:          client,
+		identity:     id,
+		mountRoot:    mountRoot,
+		pollInterval: 15 * time.Second,
+		pollTimeout:  fileSystemTimeout,
+	}
+}
+
+var _ controller.Provisioner = &fsxProvisioner{}
+
+// Provision creates an FSx for Lustre file system for the claim, or a
+// directory for it on the class's existing file system, and returns a
+// flexvolume PV for a Lustre driver to mount it. The Lustre mount info is
+// also in the PV's annotations.
+func (p *fsxProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	opts, err := parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	annotations := map[string]string{
+		provisionerIDAnn: p.identity,
+	}
+	dnsName, mountName, path := opts.dnsName, opts.mountName, ""
+	if opts.dnsName != "" {
+		path = "/" + options.PVName
+		if err := p.createDirectory(dnsName, mountName, options.PVName); err != nil {
+			return nil, err
+		}
+	} else {
+		fs, err := p.createFileSystem(options, opts)
+		if err != nil {
+			return nil, err
+		}
+		dnsName = aws.StringValue(fs.DNSName)
+		mountName = defaultMountName
+		if fs.LustreConfiguration != nil && aws.StringValue(fs.LustreConfiguration.MountName) != "" {
+			mountName = aws.StringValue(fs.LustreConfiguration.MountName)
+		}
+		annotations[fileSystemIDAnn] = aws.StringValue(fs.FileSystemId)
+		capacity = resource.MustParse(fmt.Sprintf("%dGi", aws.Int64Value(fs.StorageCapacity)))
+	}
+	annotations[dnsNameAnn] = dnsName
+	annotations[mountNameAnn] = mountName
+	annotations[mountTargetAnn] = dnsName + "@tcp:/" + mountName + path
+
+	flexOptions := map[string]string{
+		dnsNameOption:   dnsName,
+		mountNameOption: mountName,
+	}
+	if path != "" {
+		flexOptions[pathOption] = path
+	}
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        options.PVName,
+			Annotations: annotations,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			// directories have no quota, their capacity is only nominal
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): capacity,
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				FlexVolume: &v1.FlexVolumeSource{
+					Driver:   opts.driver,
+					ReadOnly: false,
+					Options:  flexOptions,
+				},
+			},
+		},
+	}
+
+	return pv, nil
+}
+
+// createFileSystem creates a file system of the claim's size rounded up to
+// a size FSx allows, and waits for it to be available.
+func (p *fsxProvisioner) createFileSystem(options controller.VolumeOptions, opts *fsxProvisionOptions) (*fsx.FileSystem, error) {
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	capacityGiB := getStorageCapacity(util.RoundUpSize(capacity.Value(), 1024*1024*1024), opts.deploymentType)
+
+	input := &fsx.CreateFileSystemInput{
+		// makes retries idempotent
+		ClientRequestToken: aws.String(options.PVName),
+		FileSystemType:     aws.String(fsx.FileSystemTypeLustre),
+		StorageCapacity:    aws.Int64(capacityGiB),
+		SubnetIds:          aws.StringSlice([]string{opts.subnetID}),
+		SecurityGroupIds:   aws.StringSlice(opts.securityGroupIDs),
+		Tags:               getTags(options),
+	}
+	if opts.deploymentType != "" || opts.perUnitStorageThroughput != 0 || opts.importPath != "" || opts.exportPath != "" {
+		input.LustreConfiguration = &fsx.CreateFileSystemLustreConfiguration{}
+		if opts.deploymentType != "" {
+			input.LustreConfiguration.DeploymentType = aws.String(opts.deploymentType)
+		}
+		if opts.perUnitStorageThroughput != 0 {
+			input.LustreConfiguration.PerUnitStorageThroughput = aws.Int64(opts.perUnitStorageThroughput)
+		}
+		if opts.importPath != "" {
+			input.LustreConfiguration.ImportPath = aws.String(opts.importPath)
+		}
+		if opts.exportPath != "" {
+			input.LustreConfiguration.ExportPath = aws.String(opts.exportPath)
+		}
+	}
+	output, err := p.fsx.CreateFileSystem(input)
+	if err != nil {
+		glog.Errorf("failed to create file system for %q: %v", options.PVName, err)
+		return nil, err
+	}
+	id := aws.StringValue(output.FileSystem.FileSystemId)
+
+	var fs *fsx.FileSystem
+	err = wait.Poll(p.pollInterval, p.pollTimeout, func() (bool, error) {
+		fs, err = p.getFileSystem(id)
+		if err != nil {
+			return false, err
+		}
+		if fs == nil {
+			return false, fmt.Errorf("file system %s disappeared", id)
+		}
+		switch aws.StringValue(fs.Lifecycle) {
+		case fsx.FileSystemLifecycleAvailable:
+			return true, nil
+		case fsx.FileSystemLifecycleFailed:
+			reason := ""
+			if fs.FailureDetails != nil {
+				reason = aws.StringValue(fs.FailureDetails.Message)
+			}
+			return false, fmt.Errorf("file system %s failed: %s", id, reason)
+		}
+		return false, nil
+	})
+	if err != nil {
+		glog.Errorf("file system %s did not become available: %v", id, err)
+		if _, err := p.fsx.DeleteFileSystem(&fsx.DeleteFileSystemInput{FileSystemId: aws.String(id)}); err != nil {
+			glog.Errorf("failed to roll back creation of file system %s: %v", id, err)
+		}
+		return nil, err
+	}
+	glog.Infof("successfully created file system %s of %dGi for %q", id, capacityGiB, options.PVName)
+	return fs, nil
+}
+
+// getFileSystem returns the file system with the ID, or nil if it doesn't
+// exist.
+func (p *fsxProvisioner) getFileSystem(id string) (*fsx.FileSystem, error) {
+	output, err := p.fsx.DescribeFileSystems(&fsx.DescribeFileSystemsInput{FileSystemIds: aws.StringSlice([]string{id})})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == fsx.ErrCodeFileSystemNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(output.FileSystems) == 0 {
+		return nil, nil
+	}
+	return output.FileSystems[0], nil
+}
+
+// getStorageCapacity returns the smallest file system size FSx allows that
+// holds the given size: 1200GiB, 2400GiB, then multiples of 2400GiB, or of
+// 3600GiB for SCRATCH_1 file systems.
+func getStorageCapacity(sizeGiB int64, deploymentType string) int64 {
+	if sizeGiB <= 1200 {
+		return 1200
+	}
+	if sizeGiB <= 2400 {
+		return 2400
+	}
+	increment := int64(2400)
+	if deploymentType == deploymentTypeScratch1 {
+		increment = 3600
+	}
+	return util.RoundUpSize(sizeGiB, increment) * increment
+}
+
+// createDirectory creates a directory named after the PV on the existing file
+// system, which must be mounted under mountRoot.
+func (p *fsxProvisioner) createDirectory(dnsName, mountName, pvName string) error {
+	root, err := p.getFileSystemRoot(dnsName, mountName)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(root, pvName)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return fmt.Errorf("error creating directory %s: %v", dir, err)
+	}
+	// the mode of MkdirAll is masked by the umask
+	if err := os.Chmod(dir, 0777); err != nil {
+		return fmt.Errorf("error setting mode of directory %s: %v", dir, err)
+	}
+	glog.Infof("successfully created directory %s on %s@tcp:/%s", pvName, dnsName, mountName)
+	return nil
+}
+
+// getFileSystemRoot returns where the file system is mounted, failing if it
+// isn't, so that directories aren't created on, or deleted from, the
+// container's own filesystem.
+func (p *fsxProvisioner) getFileSystemRoot(dnsName, mountName string) (string, error) {
+	root := filepath.Join(p.mountRoot, dnsName, mountName)
+	if _, err := os.Stat(root); err != nil {
+		return "", fmt.Errorf("file system %s@tcp:/%s is not mounted at %s: %v", dnsName, mountName, root, err)
+	}
+	return root, nil
+}
+
+// Delete deletes the file system or the directory that was created by
+// Provision represented by the given PV.
+func (p *fsxProvisioner) Delete(volume *v1.PersistentVolume) error {
+	ann, ok := volume.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != p.identity {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+
+	if id, ok := volume.Annotations[fileSystemIDAnn]; ok {
+		return p.deleteFileSystem(id)
+	}
+
+	dnsName, mountName := volume.Annotations[dnsNameAnn], volume.Annotations[mountNameAnn]
+	if dnsName == "" || mountName == "" {
+		return fmt.Errorf("PV has no %s or %s annotation", dnsNameAnn, mountNameAnn)
+	}
+	root, err := p.getFileSystemRoot(dnsName, mountName)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(root, volume.Name)); err != nil {
+		return fmt.Errorf("error deleting directory %s: %v", volume.Name, err)
+	}
+	glog.Infof("successfully deleted directory %s on %s@tcp:/%s", volume.Name, dnsName, mountName)
+	return nil
+}
+
+// deleteFileSystem deletes the file system and waits for it to be gone.
+func (p *fsxProvisioner) deleteFileSystem(id string) error {
+	fs, err := p.getFileSystem(id)
+	if err != nil {
+		return err
+	}
+	if fs == nil {
+		return nil
+	}
+	// a retry finds the file system still being deleted
+	if aws.StringValue(fs.Lifecycle) != fsx.FileSystemLifecycleDeleting {
+		_, err := p.fsx.DeleteFileSystem(&fsx.DeleteFileSystemInput{FileSystemId: aws.String(id)})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == fsx.ErrCodeFileSystemNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = wait.Poll(p.pollInterval, p.pollTimeout, func() (bool, error) {
+		fs, err := p.getFileSystem(id)
+		return fs == nil, err
+	})
+	if err != nil {
+		return fmt.Errorf("error waiting for file system %s to be deleted: %v", id, err)
+	}
+	glog.Infof("successfully deleted file system %s", id)
+	return nil
+}
+
+// getTags returns the tags identifying the claim a file system is created
+// for, the same the in-tree cloud providers give their volumes.
+func getTags(options controller.VolumeOptions) []*fsx.Tag {
+	tags := map[string]string{
+		"Name": fileSystemPrefix + options.PVName,
+		"kubernetes.io/created-for/pvc/namespace": options.PVC.Namespace,
+		"kubernetes.io/created-for/pvc/name":      options.PVC.Name,
+		"kubernetes.io/created-for/pv/name":       options.PVName,
+	}
+	result := []*fsx.Tag{}
+	for k, v := range tags {
+		result = append(result, &fsx.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return result
+}
+
+func parseParameters(parameters map[string]string) (*fsxProvisionOptions, error) {
+	opts := &fsxProvisionOptions{
+		driver: defaultDriver,
+	}
+
+	var err error
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "subnetid":
+			opts.subnetID = v
+		case "securitygroupids":
+			for _, id := range strings.Split(v, ",") {
+				if id = strings.TrimSpace(id); id != "" {
+					opts.securityGroupIDs = append(opts.securityGroupIDs, id)
+				}
+			}
+		case "deploymenttype":
+			opts.deploymentType = strings.ToUpper(v)
+		case "perunitstoragethroughput":
+			opts.perUnitStorageThroughput, err = strconv.ParseInt(v, 10, 64)
+			if err != nil || opts.perUnitStorageThroughput <= 0 {
+				return nil, fmt.Errorf("invalid perUnitStorageThroughput %q", v)
+			}
+		case "importpath":
+			opts.importPath = v
+		case "exportpath":
+			opts.exportPath = v
+		case "dnsname":
+			opts.dnsName = v
+		case "mountname":
+			opts.mountName = strings.Trim(v, "/")
+		case "driver":
+			opts.driver = v
+		default:
+			return nil, fmt.Errorf("invalid option %q", k)
+		}
+	}
+	// sanity check
+	if opts.dnsName != "" || opts.mountName != "" {
+		if opts.dnsName == "" || opts.mountName == "" {
+			return nil, fmt.Errorf("dnsName and mountName must be given together")
+		}
+		if opts.subnetID != "" || len(opts.securityGroupIDs) > 0 || opts.deploymentType != "" || opts.perUnitStorageThroughput != 0 || opts.importPath != "" || opts.exportPath != "" {
+			return nil, fmt.Errorf("dnsName and mountName can't be given with file system parameters")
+		}
+		return opts, nil
+	}
+	if opts.subnetID == "" {
+		return nil, fmt.Errorf("missing subnetId, or dnsName and mountName")
+	}
+	if opts.exportPath != "" && opts.importPath == "" {
+		return nil, fmt.Errorf("exportPath needs importPath")
+	}
+	return opts, nil
+}
@@ -0,0 +1,310 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/kubernetes-incubator/external-storage/aws/fsx/pkg/fsx"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	utiltesting "k8s.io/client-go/util/testing"
+)
+
+// fakeFSx keeps file systems in memory. File systems become available, and
+// deleted file systems go away, the second time they're described.
+type fakeFSx struct {
+	fileSystems map[string]*fsx.FileSystem
+	inputs      map[string]*fsx.CreateFileSystemInput
+	describes   map[string]int
+	tokens      map[string]string
+}
+
+func newFakeFSx() *fakeFSx {
+	return &fakeFSx{
+		fileSystems: map[string]*fsx.FileSystem{},
+		inputs:      map[string]*fsx.CreateFileSystemInput{},
+		describes:   map[string]int{},
+		tokens:      map[string]string{},
+	}
+}
+
+var _ fsxAPI = &fakeFSx{}
+
+func (f *fakeFSx) CreateFileSystem(input *fsx.CreateFileSystemInput) (*fsx.CreateFileSystemOutput, error) {
+	token := aws.StringValue(input.ClientRequestToken)
+	if id, ok := f.tokens[token]; ok {
+		return &fsx.CreateFileSystemOutput{FileSystem: f.fileSystems[id]}, nil
+	}
+	id := fmt.Sprintf("fs-%017d", len(f.tokens))
+	f.tokens[token] = id
+	f.inputs[id] = input
+	fs := &fsx.FileSystem{
+		DNSName:         aws.String(id + ".fsx.us-east-1.amazonaws.com"),
+		FileSystemId:    aws.String(id),
+		Lifecycle:       aws.String(fsx.FileSystemLifecycleCreating),
+		StorageCapacity: input.StorageCapacity,
+	}
+	if input.LustreConfiguration != nil && aws.StringValue(input.LustreConfiguration.DeploymentType) != deploymentTypeScratch1 {
+		fs.LustreConfiguration = &fsx.LustreFileSystemConfiguration{MountName: aws.String("abcdefgh")}
+	}
+	f.fileSystems[id] = fs
+	return &fsx.CreateFileSystemOutput{FileSystem: fs}, nil
+}
+
+func (f *fakeFSx) DescribeFileSystems(input *fsx.DescribeFileSystemsInput) (*fsx.DescribeFileSystemsOutput, error) {
+	id := aws.StringValue(input.FileSystemIds[0])
+	fs, ok := f.fileSystems[id]
+	if !ok {
+		return nil, awserr.New(fsx.ErrCodeFileSystemNotFound, "file system not found", nil)
+	}
+	f.describes[id]++
+	if f.describes[id] > 1 {
+		switch aws.StringValue(fs.Lifecycle) {
+		case fsx.FileSystemLifecycleCreating:
+			fs.Lifecycle = aws.String(fsx.FileSystemLifecycleAvailable)
+		case fsx.FileSystemLifecycleDeleting:
+			delete(f.fileSystems, id)
+		}
+	}
+	return &fsx.DescribeFileSystemsOutput{FileSystems: []*fsx.FileSystem{fs}}, nil
+}
+
+func (f *fakeFSx) DeleteFileSystem(input *fsx.DeleteFileSystemInput) (*fsx.DeleteFileSystemOutput, error) {
+	id := aws.StringValue(input.FileSystemId)
+	fs, ok := f.fileSystems[id]
+	if !ok {
+		return nil, awserr.New(fsx.ErrCodeFileSystemNotFound, "file system not found", nil)
+	}
+	fs.Lifecycle = aws.String(fsx.FileSystemLifecycleDeleting)
+	f.describes[id] = 0
+	return &fsx.DeleteFileSystemOutput{FileSystemId: fs.FileSystemId, Lifecycle: fs.Lifecycle}, nil
+}
+
+func newTestProvisioner(api fsxAPI, mountRoot string) *fsxProvisioner {
+	return &fsxProvisioner{
+		fsx:          api,
+		identity:     "id",
+		mountRoot:    mountRoot,
+		pollInterval: time.Millisecond,
+		pollTimeout:  time.Second,
+	}
+}
+
+func newClaimOptions(parameters map[string]string, size string) controller.VolumeOptions {
+	return controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        "pvc-1",
+		Parameters:                    parameters,
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "claim"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): resource.MustParse(size),
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestProvisionDeleteFileSystem(t *testing.T) {
+	api := newFakeFSx()
+	p := newTestProvisioner(api, "")
+
+	parameters := map[string]string{"subnetId": "subnet-1", "securityGroupIds": "sg-1, sg-2", "deploymentType": "scratch_2", "importPath": "s3://bucket"}
+	options := newClaimOptions(parameters, "3000Gi")
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	id := pv.Annotations[fileSystemIDAnn]
+	input, ok := api.inputs[id]
+	if !ok {
+		t.Fatalf("expected file system %q to be created", id)
+	}
+	if aws.Int64Value(input.StorageCapacity) != 4800 || aws.StringValue(input.LustreConfiguration.DeploymentType) != "SCRATCH_2" {
+		t.Errorf("expected 4800GiB SCRATCH_2 file system but got %v", input)
+	}
+	if len(input.SecurityGroupIds) != 2 || aws.StringValue(input.SubnetIds[0]) != "subnet-1" || aws.StringValue(input.LustreConfiguration.ImportPath) != "s3://bucket" {
+		t.Errorf("expected file system in subnet-1 with 2 security groups importing s3://bucket but got %v", input)
+	}
+	tags := map[string]string{}
+	for _, tag := range input.Tags {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	if tags["kubernetes.io/created-for/pvc/namespace"] != "ns" || tags["kubernetes.io/created-for/pvc/name"] != "claim" {
+		t.Errorf("expected file system tagged with claim ns/claim but got %v", tags)
+	}
+
+	dnsName := id + ".fsx.us-east-1.amazonaws.com"
+	flex := pv.Spec.FlexVolume
+	if flex.Driver != defaultDriver || flex.Options[dnsNameOption] != dnsName || flex.Options[mountNameOption] != "abcdefgh" {
+		t.Errorf("expected %s flexvolume of %s@tcp:/abcdefgh but got %v", defaultDriver, dnsName, flex)
+	}
+	if pv.Annotations[mountTargetAnn] != dnsName+"@tcp:/abcdefgh" {
+		t.Errorf("expected mount target %s@tcp:/abcdefgh but got %q", dnsName, pv.Annotations[mountTargetAnn])
+	}
+	capacity := pv.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	if capacity.Cmp(resource.MustParse("4800Gi")) != 0 {
+		t.Errorf("expected capacity 4800Gi but got %s", capacity.String())
+	}
+
+	// a retry gets the same file system
+	retried, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error retrying provisioning: %v", err)
+	}
+	if retried.Annotations[fileSystemIDAnn] != id || len(api.fileSystems) != 1 {
+		t.Errorf("expected retry to return file system %q but got %v", id, retried.Annotations)
+	}
+
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if _, ok := api.fileSystems[id]; ok {
+		t.Errorf("expected file system %q to be deleted", id)
+	}
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("expected deleting a deleted volume to succeed but got: %v", err)
+	}
+}
+
+func TestProvisionDeleteDirectory(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("fsxProvisionTest")
+	defer os.RemoveAll(tmpDir)
+	p := newTestProvisioner(newFakeFSx(), tmpDir)
+
+	dnsName := "fs-1.fsx.us-east-1.amazonaws.com"
+	options := newClaimOptions(map[string]string{"dnsName": dnsName, "mountName": "fsx"}, "1Gi")
+	if _, err := p.Provision(options); err == nil {
+		t.Errorf("expected provisioning on an unmounted file system to fail")
+	}
+
+	root := filepath.Join(tmpDir, dnsName, "fsx")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatalf("Error creating file system root: %v", err)
+	}
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	if pv.Spec.FlexVolume.Options[pathOption] != "/pvc-1" || pv.Annotations[mountTargetAnn] != dnsName+"@tcp:/fsx/pvc-1" {
+		t.Errorf("expected PV of %s@tcp:/fsx/pvc-1 but got %v %v", dnsName, pv.Spec.FlexVolume.Options, pv.Annotations)
+	}
+	dir := filepath.Join(root, "pvc-1")
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("expected directory %s to be created but got: %v", dir, err)
+	}
+
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected directory %s to be deleted but got: %v", dir, err)
+	}
+}
+
+func TestGetStorageCapacity(t *testing.T) {
+	tests := []struct {
+		sizeGiB        int64
+		deploymentType string
+		expected       int64
+	}{
+		{1, "", 1200},
+		{1200, "", 1200},
+		{1201, "", 2400},
+		{2401, "", 4800},
+		{2401, "SCRATCH_1", 3600},
+		{7201, "SCRATCH_1", 10800},
+		{9600, "PERSISTENT_1", 9600},
+	}
+	for _, test := range tests {
+		if capacity := getStorageCapacity(test.sizeGiB, test.deploymentType); capacity != test.expected {
+			t.Errorf("expected %dGiB %s file system for %dGiB but got %dGiB", test.expected, test.deploymentType, test.sizeGiB, capacity)
+		}
+	}
+}
+
+func TestParseParameters(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		expectError bool
+	}{
+		{
+			name:       "file system",
+			parameters: map[string]string{"subnetId": "subnet-1"},
+		},
+		{
+			name:       "persistent file system",
+			parameters: map[string]string{"subnetId": "subnet-1", "deploymentType": "PERSISTENT_1", "perUnitStorageThroughput": "200"},
+		},
+		{
+			name:       "directory",
+			parameters: map[string]string{"dnsName": "fs-1.fsx.us-east-1.amazonaws.com", "mountName": "fsx"},
+		},
+		{
+			name:        "nothing",
+			parameters:  map[string]string{},
+			expectError: true,
+		},
+		{
+			name:        "export without import",
+			parameters:  map[string]string{"subnetId": "subnet-1", "exportPath": "s3://bucket"},
+			expectError: true,
+		},
+		{
+			name:        "invalid throughput",
+			parameters:  map[string]string{"subnetId": "subnet-1", "perUnitStorageThroughput": "fast"},
+			expectError: true,
+		},
+		{
+			name:        "dnsName without mountName",
+			parameters:  map[string]string{"dnsName": "fs-1.fsx.us-east-1.amazonaws.com"},
+			expectError: true,
+		},
+		{
+			name:        "directory and file system",
+			parameters:  map[string]string{"dnsName": "fs-1.fsx.us-east-1.amazonaws.com", "mountName": "fsx", "subnetId": "subnet-1"},
+			expectError: true,
+		},
+		{
+			name:        "unknown parameter",
+			parameters:  map[string]string{"subnetId": "subnet-1", "foo": "bar"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		_, err := parseParameters(test.parameters)
+		if test.expectError && err == nil {
+			t.Errorf("Test %s: expected error but got none", test.name)
+		} else if !test.expectError && err != nil {
+			t.Errorf("Test %s: expected no error but got: %v", test.name, err)
+		}
+	}
+}
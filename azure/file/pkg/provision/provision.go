@@ -0,0 +1,252 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/helper"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	provisionerIDAnn  = "azureFileProvisionerIdentity"
+	storageAccountAnn = "azureFileStorageAccount"
+
+	sharePrefix = "kubernetes-dynamic-"
+
+	// the keys the azureFile volume plugin reads from the secret
+	accountNameKey = "azurestorageaccountname"
+	accountKeyKey  = "azurestorageaccountkey"
+)
+
+type azureFileProvisionOptions struct {
+	storageAccount  string
+	secretName      string
+	secretNamespace string
+}
+
+type azureFileProvisioner struct {
+	// Kubernetes Client. Use to retrieve storage account keys and manage the
+	// shares' secrets
+	client kubernetes.Interface
+	// Identity of this azureFileProvisioner. Used to identify "this"
+	// provisioner's PVs.
+	identity string
+	// endpointSuffix is the storage endpoint suffix of the Azure cloud
+	endpointSuffix string
+	// newShareClient is replaced by tests
+	newShareClient func(account, key, endpointSuffix string) (*shareClient, error)
+}
+
+// NewAzureFileProvisioner creates a new Azure File provisioner creating shares
+// in storage accounts under the given endpoint suffix, e.g. core.windows.net.
+func NewAzureFileProvisioner(client kubernetes.Interface, id, endpointSuffix string) controller.Provisioner {
+	return &azureFileProvisioner{
+		client:         client,
+		identity:       id,
+		endpointSuffix: endpointSuffix,
+		newShareClient: newShareClient,
+	}
+}
+
+var _ controller.Provisioner = &azureFileProvisioner{}
+
+// Provision creates a share in the class's storage account, and a secret with
+// the account's key for it in the claim's namespace, and returns a PV object
+// representing it.
+func (p *azureFileProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	opts, err := parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	account, key, err := p.getAccountKey(opts)
+	if err != nil {
+		return nil, err
+	}
+	if account != opts.storageAccount {
+		return nil, fmt.Errorf("secret %s/%s is for storage account %q, not %q", opts.secretNamespace, opts.secretName, account, opts.storageAccount)
+	}
+	shares, err := p.newShareClient(account, key, p.endpointSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	// quotas are in GiB, round up
+	quotaGiB := int((capacity.Value() + 1024*1024*1024 - 1) / (1024 * 1024 * 1024))
+	if quotaGiB < 1 {
+		quotaGiB = 1
+	}
+	if quotaGiB > maxShareQuotaGiB {
+		return nil, fmt.Errorf("requested capacity %s is larger than the maximum share size %dGi", capacity.String(), maxShareQuotaGiB)
+	}
+
+	share := sharePrefix + options.PVName
+	if err := shares.createShare(share, quotaGiB); err != nil {
+		glog.Errorf("failed to create share %q in storage account %q: %v", share, account, err)
+		return nil, err
+	}
+	glog.Infof("successfully created share %q in storage account %q", share, account)
+
+	// the azureFile volume plugin reads the secret from the pod's namespace
+	secretName := getSecretName(options.PVName)
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: options.PVC.Namespace,
+			Name:      secretName,
+		},
+		Data: map[string][]byte{
+			accountNameKey: []byte(account),
+			accountKeyKey:  []byte(key),
+		},
+		Type: "Opaque",
+	}
+	if _, err := p.client.Core().Secrets(options.PVC.Namespace).Create(secret); err != nil && !apierrors.IsAlreadyExists(err) {
+		shares.deleteShare(share)
+		return nil, fmt.Errorf("failed to create secret %s/%s: %v", options.PVC.Namespace, secretName, err)
+	}
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				provisionerIDAnn:  p.identity,
+				storageAccountAnn: account,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): capacity,
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				AzureFile: &v1.AzureFileVolumeSource{
+					SecretName: secretName,
+					ShareName:  share,
+					ReadOnly:   false,
+				},
+			},
+		},
+	}
+
+	return pv, nil
+}
+
+// Delete removes the share that was created by Provision represented by the
+// given PV, and its secret.
+func (p *azureFileProvisioner) Delete(volume *v1.PersistentVolume) error {
+	ann, ok := volume.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != p.identity {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	if volume.Spec.AzureFile == nil {
+		return errors.New("PV is not an azureFile volume")
+	}
+
+	class, err := p.client.StorageV1().StorageClasses().Get(helper.GetPersistentVolumeClass(volume), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	opts, err := parseParameters(class.Parameters)
+	if err != nil {
+		return err
+	}
+	account, key, err := p.getAccountKey(opts)
+	if err != nil {
+		return err
+	}
+	if account != volume.Annotations[storageAccountAnn] {
+		return fmt.Errorf("class's storage account %q is not the PV's %q", account, volume.Annotations[storageAccountAnn])
+	}
+	shares, err := p.newShareClient(account, key, p.endpointSuffix)
+	if err != nil {
+		return err
+	}
+	if err := shares.deleteShare(volume.Spec.AzureFile.ShareName); err != nil {
+		return err
+	}
+
+	if volume.Spec.ClaimRef == nil {
+		return nil
+	}
+	err = p.client.Core().Secrets(volume.Spec.ClaimRef.Namespace).Delete(volume.Spec.AzureFile.SecretName, nil)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleted share %q but failed to delete secret: %v", volume.Spec.AzureFile.ShareName, err)
+	}
+
+	return nil
+}
+
+func getSecretName(pvName string) string {
+	return "azurefile-" + pvName
+}
+
+// getAccountKey returns the storage account name and key from the class's
+// secret.
+func (p *azureFileProvisioner) getAccountKey(opts *azureFileProvisionOptions) (string, string, error) {
+	secret, err := p.client.Core().Secrets(opts.secretNamespace).Get(opts.secretName, metav1.GetOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get storage account secret %s/%s: %v", opts.secretNamespace, opts.secretName, err)
+	}
+	account, key := string(secret.Data[accountNameKey]), string(secret.Data[accountKeyKey])
+	if account == "" || key == "" {
+		return "", "", fmt.Errorf("storage account secret %s/%s must have keys %s and %s", opts.secretNamespace, opts.secretName, accountNameKey, accountKeyKey)
+	}
+	return account, key, nil
+}
+
+func parseParameters(parameters map[string]string) (*azureFileProvisionOptions, error) {
+	opts := &azureFileProvisionOptions{
+		secretNamespace: "default",
+	}
+
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "storageaccount":
+			opts.storageAccount = v
+		case "secretname":
+			opts.secretName = v
+		case "secretnamespace":
+			opts.secretNamespace = v
+		default:
+			return nil, fmt.Errorf("invalid option %q", k)
+		}
+	}
+	// sanity check
+	if opts.storageAccount == "" {
+		return nil, fmt.Errorf("missing storageAccount")
+	}
+	if opts.secretName == "" {
+		return nil, fmt.Errorf("missing secretName")
+	}
+	return opts, nil
+}
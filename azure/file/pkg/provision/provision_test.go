@@ -0,0 +1,155 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	storage "k8s.io/client-go/pkg/apis/storage/v1"
+)
+
+var testKey = base64.StdEncoding.EncodeToString([]byte("key"))
+
+// fakeFileService serves share creation and deletion, checking requests are
+// signed with testKey.
+type fakeFileService struct {
+	account string
+	shares  map[string]string
+}
+
+func (f *fakeFileService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client, _ := newShareClient(f.account, testKey, "")
+	if r.Header.Get("Authorization") != "SharedKey "+f.account+":"+client.sign(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	share := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case "PUT":
+		if _, ok := f.shares[share]; ok {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("ShareAlreadyExists"))
+			return
+		}
+		f.shares[share] = r.Header.Get("x-ms-share-quota")
+		w.WriteHeader(http.StatusCreated)
+	case "DELETE":
+		if _, ok := f.shares[share]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.shares, share)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func TestCanonicalizedResource(t *testing.T) {
+	req, _ := http.NewRequest("PUT", "https://account.file.core.windows.net/share?restype=share&Comp=metadata", nil)
+	expected := "/account/share\ncomp:metadata\nrestype:share"
+	if got := canonicalizedResource("account", req.URL); got != expected {
+		t.Errorf("expected canonicalized resource %q but got %q", expected, got)
+	}
+
+	req.Header.Set("x-ms-version", "2016-05-31")
+	req.Header.Set("X-Ms-Date", "Mon, 02 Jan 2017 15:04:05 GMT")
+	req.Header.Set("Content-Type", "text/plain")
+	expected = "x-ms-date:Mon, 02 Jan 2017 15:04:05 GMT\nx-ms-version:2016-05-31\n"
+	if got := canonicalizedHeaders(req); got != expected {
+		t.Errorf("expected canonicalized headers %q but got %q", expected, got)
+	}
+}
+
+func TestProvisionDelete(t *testing.T) {
+	fileService := &fakeFileService{account: "account", shares: map[string]string{}}
+	server := httptest.NewServer(fileService)
+	defer server.Close()
+
+	accountSecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "azure-account"},
+		Data:       map[string][]byte{accountNameKey: []byte("account"), accountKeyKey: []byte(testKey)},
+	}
+	class := &storage.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "azurefile"},
+		Parameters: map[string]string{"storageAccount": "account", "secretName": "azure-account", "secretNamespace": "kube-system"},
+	}
+	client := fake.NewSimpleClientset(accountSecret, class)
+	p := NewAzureFileProvisioner(client, "id", "core.windows.net").(*azureFileProvisioner)
+	p.newShareClient = func(account, key, endpointSuffix string) (*shareClient, error) {
+		c, err := newShareClient(account, key, endpointSuffix)
+		if err == nil {
+			c.baseURL = server.URL
+		}
+		return c, err
+	}
+
+	options := controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        "pvc-1",
+		Parameters:                    class.Parameters,
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "claim"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): resource.MustParse("1500Mi"),
+					},
+				},
+			},
+		},
+	}
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	if quota := fileService.shares["kubernetes-dynamic-pvc-1"]; quota != "2" {
+		t.Errorf("expected share kubernetes-dynamic-pvc-1 with quota 2 but got %q", quota)
+	}
+	secret, err := client.Core().Secrets("ns").Get(pv.Spec.AzureFile.SecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting share secret: %v", err)
+	}
+	if string(secret.Data[accountKeyKey]) != testKey {
+		t.Errorf("expected share secret to have the account key but got %v", secret.Data)
+	}
+
+	pv.Annotations[v1.BetaStorageClassAnnotation] = "azurefile"
+	pv.Spec.ClaimRef = &v1.ObjectReference{Namespace: "ns", Name: "claim"}
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if _, ok := fileService.shares["kubernetes-dynamic-pvc-1"]; ok {
+		t.Errorf("expected share kubernetes-dynamic-pvc-1 to be deleted")
+	}
+	if _, err := client.Core().Secrets("ns").Get(pv.Spec.AzureFile.SecretName, metav1.GetOptions{}); err == nil {
+		t.Errorf("expected share secret to be deleted")
+	}
+
+	options.Parameters = map[string]string{"storageAccount": "other", "secretName": "azure-account", "secretNamespace": "kube-system"}
+	if _, err := p.Provision(options); err == nil {
+		t.Errorf("expected error provisioning with secret of another account but got none")
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// fileServiceVersion is the version of the Azure Files REST API used
+	fileServiceVersion = "2016-05-31"
+	// maxShareQuotaGiB is the largest quota a share can have
+	maxShareQuotaGiB = 5120
+)
+
+// shareClient creates and deletes file shares in a storage account with the
+// Azure Files REST API, authorizing requests with the account's key.
+type shareClient struct {
+	account string
+	key     []byte
+	// baseURL is the account's file service endpoint, e.g.
+	// https://account.file.core.windows.net
+	baseURL string
+	client  *http.Client
+}
+
+func newShareClient(account, key, endpointSuffix string) (*shareClient, error) {
+	decodedKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding key of storage account %s: %v", account, err)
+	}
+	return &shareClient{
+		account: account,
+		key:     decodedKey,
+		baseURL: fmt.Sprintf("https://%s.file.%s", account, endpointSuffix),
+		client:  &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// createShare creates the named share with the given quota. It's not an error
+// if the share already exists.
+func (c *shareClient) createShare(name string, quotaGiB int) error {
+	headers := map[string]string{"x-ms-share-quota": strconv.Itoa(quotaGiB)}
+	status, body, err := c.do("PUT", name, headers)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusCreated && !(status == http.StatusConflict && strings.Contains(body, "ShareAlreadyExists")) {
+		return fmt.Errorf("error creating share %s: status %d: %s", name, status, body)
+	}
+	return nil
+}
+
+// deleteShare deletes the named share. It's not an error if the share doesn't
+// exist.
+func (c *shareClient) deleteShare(name string) error {
+	status, body, err := c.do("DELETE", name, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusAccepted && status != http.StatusNotFound {
+		return fmt.Errorf("error deleting share %s: status %d: %s", name, status, body)
+	}
+	return nil
+}
+
+func (c *shareClient) do(method, share string, headers map[string]string) (int, string, error) {
+	u, err := url.Parse(c.baseURL + "/" + share + "?restype=share")
+	if err != nil {
+		return 0, "", err
+	}
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return 0, "", err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", fileServiceVersion)
+	req.Header.Set("Authorization", "SharedKey "+c.account+":"+c.sign(req))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("error calling %s %s: %v", method, u.Path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), nil
+}
+
+// sign returns the Shared Key signature of the given request, see
+// https://docs.microsoft.com/en-us/rest/api/storageservices/authentication-for-the-azure-storage-services
+func (c *shareClient) sign(req *http.Request) string {
+	contentLength := req.Header.Get("Content-Length")
+	if contentLength == "0" {
+		contentLength = ""
+	}
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		req.Header.Get("Date"),
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}, "\n") + "\n" + canonicalizedHeaders(req) + canonicalizedResource(c.account, req.URL)
+
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func canonicalizedHeaders(req *http.Request) string {
+	var names []string
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-ms-") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	headers := ""
+	for _, name := range names {
+		headers += name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n"
+	}
+	return headers
+}
+
+func canonicalizedResource(account string, u *url.URL) string {
+	resource := "/" + account + u.EscapedPath()
+	query := u.Query()
+	var names []string
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := query[name]
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+	return resource
+}
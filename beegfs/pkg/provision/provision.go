@@ -0,0 +1,253 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/pkg/mount"
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/aws/efs/pkg/gidallocator"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/helper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	provisionerIDAnn = "beegfsProvisionerIdentity"
+
+	// the type of BeeGFS mounts
+	fsType = "beegfs"
+
+	// the directories are writable by their group, whose files count against
+	// its quota, and new files inherit the group
+	dirMode = os.FileMode(0771) | os.ModeSetgid
+)
+
+// gidAllocator allocates the groups of the volumes, a gidallocator.Allocator
+// but for tests.
+type gidAllocator interface {
+	AllocateNext(options controller.VolumeOptions) (int, error)
+	Release(volume *v1.PersistentVolume) error
+}
+
+type beegfsProvisionOptions struct {
+	// the most files the volume may have, 0 if unlimited
+	inodeLimit int64
+}
+
+type beegfsProvisioner struct {
+	// Identity of this beegfsProvisioner. Used to identify "this"
+	// provisioner's PVs.
+	identity string
+	// mountPoint is where the BeeGFS file system is mounted, both in the
+	// provisioner's container and on the nodes
+	mountPoint string
+	quotaer    Quotaer
+	allocator  gidAllocator
+}
+
+// NewBeeGFSProvisioner creates a new BeeGFS provisioner creating directories
+// on the BeeGFS file system mounted at mountPoint, limited to the claims'
+// sizes by quotas on groups allocated to them. It fails if no BeeGFS file
+// system is mounted there, so that directories aren't created on the
+// container's, or node's, own filesystem.
+func NewBeeGFSProvisioner(client kubernetes.Interface, id, mountPoint string) (controller.Provisioner, error) {
+	mountPoint = filepath.Clean(mountPoint)
+	entries, err := mount.GetMounts()
+	if err != nil {
+		return nil, fmt.Errorf("error listing mounts: %v", err)
+	}
+	mounted := false
+	for _, e := range entries {
+		if e.Mountpoint == mountPoint && e.Fstype == fsType {
+			mounted = true
+			break
+		}
+	}
+	if !mounted {
+		return nil, fmt.Errorf("no %s file system mounted at %s", fsType, mountPoint)
+	}
+
+	allocator := gidallocator.New(client)
+	return &beegfsProvisioner{
+		identity:   id,
+		mountPoint: mountPoint,
+		quotaer:    NewQuotaer(mountPoint),
+		allocator:  &allocator,
+	}, nil
+}
+
+var _ controller.Provisioner = &beegfsProvisioner{}
+
+// Provision creates a directory for the claim owned by a group allocated to
+// it, limits the group's usage of the file system to the claim's size, and
+// returns a hostPath PV pointing at the directory. The file system must be
+// mounted at the same path on the nodes.
+func (p *beegfsProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	opts, err := parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	if capacity.Value() <= 0 {
+		return nil, fmt.Errorf("claim must request a positive size")
+	}
+
+	gid, err := p.allocator.AllocateNext(options)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(p.mountPoint, getDirectoryName(options))
+	if err := p.createDirectory(dir, gid, capacity.Value(), opts.inodeLimit); err != nil {
+		p.releaseGid(options, gid)
+		return nil, err
+	}
+	glog.Infof("successfully created directory %s with a quota of %d bytes for gid %d", dir, capacity.Value(), gid)
+
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				provisionerIDAnn:                    p.identity,
+				gidallocator.VolumeGidAnnotationKey: strconv.Itoa(gid),
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): capacity,
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				HostPath: &v1.HostPathVolumeSource{
+					Path: dir,
+				},
+			},
+		},
+	}, nil
+}
+
+// createDirectory creates the directory owned by the group and sets the
+// group's quota, removing the directory again on failure.
+func (p *beegfsProvisioner) createDirectory(dir string, gid int, sizeBytes, inodes int64) error {
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return fmt.Errorf("the path %s already exists", dir)
+	}
+	if err := os.Mkdir(dir, dirMode); err != nil {
+		return fmt.Errorf("error creating directory %s: %v", dir, err)
+	}
+	// the mode of Mkdir is masked by the umask
+	if err := os.Chmod(dir, dirMode); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("error setting mode of directory %s: %v", dir, err)
+	}
+	if err := os.Chown(dir, -1, gid); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("error setting group of directory %s: %v", dir, err)
+	}
+	if err := p.quotaer.SetGroupQuota(gid, sizeBytes, inodes); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+// releaseGid releases the gid allocated to a claim whose volume couldn't be
+// created.
+func (p *beegfsProvisioner) releaseGid(options controller.VolumeOptions, gid int) {
+	// the allocator finds the gid and its class in the PV
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        options.PVName,
+			Annotations: map[string]string{gidallocator.VolumeGidAnnotationKey: strconv.Itoa(gid)},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			StorageClassName: helper.GetPersistentVolumeClaimClass(options.PVC),
+		},
+	}
+	if err := p.allocator.Release(pv); err != nil {
+		glog.Errorf("failed to release gid %d: %v", gid, err)
+	}
+}
+
+// getDirectoryName returns the name of the claim's directory, identifying the
+// claim to administrators.
+func getDirectoryName(options controller.VolumeOptions) string {
+	return strings.Join([]string{options.PVC.Namespace, options.PVC.Name, options.PVName}, "-")
+}
+
+// Delete removes the directory that was created by Provision represented by
+// the given PV and releases its group. The group's quota is left to be
+// overwritten when the group is allocated again: no files count against it.
+func (p *beegfsProvisioner) Delete(volume *v1.PersistentVolume) error {
+	ann, ok := volume.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != p.identity {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	if volume.Spec.HostPath == nil {
+		return errors.New("PV is not a hostPath volume")
+	}
+
+	dir := volume.Spec.HostPath.Path
+	if filepath.Dir(dir) != filepath.Clean(p.mountPoint) {
+		return fmt.Errorf("path %s isn't in BeeGFS mount point %s, refusing to delete it", dir, p.mountPoint)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("error deleting directory %s: %v", dir, err)
+	}
+	if err := p.allocator.Release(volume); err != nil {
+		return err
+	}
+	glog.Infof("successfully deleted directory %s", dir)
+	return nil
+}
+
+func parseParameters(parameters map[string]string) (*beegfsProvisionOptions, error) {
+	opts := &beegfsProvisionOptions{}
+
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "inodelimit":
+			inodes, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || inodes <= 0 {
+				return nil, fmt.Errorf("invalid inodeLimit %q, must be a positive integer", v)
+			}
+			opts.inodeLimit = inodes
+		case "gidmin", "gidmax":
+			// parsed by the gid allocator
+		default:
+			return nil, fmt.Errorf("invalid option %q", k)
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/aws/efs/pkg/gidallocator"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	utiltesting "k8s.io/client-go/util/testing"
+)
+
+type quota struct {
+	sizeBytes int64
+	inodes    int64
+}
+
+type fakeQuotaer struct {
+	quotas map[int]quota
+	err    error
+}
+
+func (q *fakeQuotaer) SetGroupQuota(gid int, sizeBytes, inodes int64) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.quotas[gid] = quota{sizeBytes, inodes}
+	return nil
+}
+
+// fakeAllocator allocates the gid of the test, which it may chown to
+type fakeAllocator struct {
+	allocated map[int]bool
+}
+
+func (a *fakeAllocator) AllocateNext(options controller.VolumeOptions) (int, error) {
+	gid := os.Getgid()
+	if a.allocated[gid] {
+		return 0, errors.New("gid already allocated")
+	}
+	a.allocated[gid] = true
+	return gid, nil
+}
+
+func (a *fakeAllocator) Release(volume *v1.PersistentVolume) error {
+	gid, _ := strconv.Atoi(volume.Annotations[gidallocator.VolumeGidAnnotationKey])
+	delete(a.allocated, gid)
+	return nil
+}
+
+func TestParseParameters(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   *beegfsProvisionOptions
+		expectErr  bool
+	}{
+		{
+			name:       "defaults",
+			parameters: map[string]string{},
+			expected:   &beegfsProvisionOptions{},
+		},
+		{
+			name:       "inode limit and gid range",
+			parameters: map[string]string{"inodeLimit": "1000", "gidMin": "3000", "gidMax": "4000"},
+			expected:   &beegfsProvisionOptions{inodeLimit: 1000},
+		},
+		{
+			name:       "invalid inode limit",
+			parameters: map[string]string{"inodeLimit": "0"},
+			expectErr:  true,
+		},
+		{
+			name:       "invalid option",
+			parameters: map[string]string{"foo": "bar"},
+			expectErr:  true,
+		},
+	}
+	for _, test := range tests {
+		opts, err := parseParameters(test.parameters)
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("test %q: expected error but got none", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %q: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(test.expected, opts) {
+			t.Errorf("test %q: expected %+v but got %+v", test.name, test.expected, opts)
+		}
+	}
+}
+
+func TestProvisionDelete(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("beegfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	quotaer := &fakeQuotaer{quotas: map[int]quota{}}
+	allocator := &fakeAllocator{allocated: map[int]bool{}}
+	p := &beegfsProvisioner{
+		identity:   "beegfs-1",
+		mountPoint: tmpDir,
+		quotaer:    quotaer,
+		allocator:  allocator,
+	}
+	options := controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        "pvc-1",
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "ns"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Gi"),
+					},
+				},
+			},
+		},
+		Parameters: map[string]string{"inodeLimit": "100"},
+	}
+
+	quotaer.err = errors.New("quota not enabled")
+	if _, err := p.Provision(options); err == nil {
+		t.Errorf("expected error provisioning volume failing to set quota but got none")
+	}
+	dir := filepath.Join(tmpDir, "ns-claim-pvc-1")
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected directory %s of volume failing to set quota to be removed but got %v", dir, err)
+	}
+	if len(allocator.allocated) != 0 {
+		t.Errorf("expected gid of volume failing to set quota to be released but got %v", allocator.allocated)
+	}
+	quotaer.err = nil
+
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	gid := os.Getgid()
+	if pv.Spec.HostPath == nil || pv.Spec.HostPath.Path != dir {
+		t.Errorf("expected hostPath %s but got %+v", dir, pv.Spec.PersistentVolumeSource)
+	}
+	if pv.Annotations[gidallocator.VolumeGidAnnotationKey] != strconv.Itoa(gid) {
+		t.Errorf("expected gid annotation %d but got %q", gid, pv.Annotations[gidallocator.VolumeGidAnnotationKey])
+	}
+	if fi, err := os.Stat(dir); err != nil || fi.Mode()&(os.ModePerm|os.ModeSetgid) != dirMode {
+		t.Errorf("expected directory %s with mode %v but got %v, %v", dir, dirMode, fi, err)
+	}
+	expected := quota{sizeBytes: 1024 * 1024 * 1024, inodes: 100}
+	if quotaer.quotas[gid] != expected {
+		t.Errorf("expected quota %+v for gid %d but got %+v", expected, gid, quotaer.quotas[gid])
+	}
+
+	other := &beegfsProvisioner{identity: "beegfs-2", mountPoint: tmpDir}
+	if err := other.Delete(pv); err == nil {
+		t.Errorf("expected error deleting volume of another provisioner but got none")
+	} else if _, ok := err.(*controller.IgnoredError); !ok {
+		t.Errorf("expected IgnoredError deleting volume of another provisioner but got %v", err)
+	}
+
+	outside := *pv
+	outside.Spec.PersistentVolumeSource = v1.PersistentVolumeSource{
+		HostPath: &v1.HostPathVolumeSource{Path: "/etc"},
+	}
+	if err := p.Delete(&outside); err == nil {
+		t.Errorf("expected error deleting path outside mount point but got none")
+	}
+
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected directory %s to be removed but got %v", dir, err)
+	}
+	if len(allocator.allocated) != 0 {
+		t.Errorf("expected gid of deleted volume to be released but got %v", allocator.allocated)
+	}
+}
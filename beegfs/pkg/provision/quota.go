@@ -0,0 +1,63 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// unlimited is beegfs-ctl's value for no limit
+const unlimited = "unlimited"
+
+// Quotaer sets the quota limits of groups on a BeeGFS file system.
+type Quotaer interface {
+	// SetGroupQuota limits the space and number of files, or inodes, used by
+	// the files of the group gid. An inode limit of 0 means no limit.
+	SetGroupQuota(gid int, sizeBytes, inodes int64) error
+}
+
+type ctlQuotaer struct {
+	mountPoint string
+}
+
+// NewQuotaer returns a Quotaer running beegfs-ctl with the configuration of the
+// BeeGFS file system mounted at mountPoint.
+func NewQuotaer(mountPoint string) Quotaer {
+	return &ctlQuotaer{mountPoint: mountPoint}
+}
+
+var _ Quotaer = &ctlQuotaer{}
+
+func (q *ctlQuotaer) SetGroupQuota(gid int, sizeBytes, inodes int64) error {
+	inodeLimit := unlimited
+	if inodes > 0 {
+		inodeLimit = strconv.FormatInt(inodes, 10)
+	}
+	cmd := exec.Command("beegfs-ctl",
+		"--mount="+q.mountPoint,
+		"--setquota",
+		"--gid", strconv.Itoa(gid),
+		"--sizelimit="+strconv.FormatInt(sizeBytes, 10),
+		"--inodelimit="+inodeLimit)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("beegfs-ctl setquota failed with error: %v, output: %s", err, out)
+	}
+	return nil
+}
@@ -22,11 +22,13 @@ import (
 	"flag"
 	"fmt"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	"github.com/golang/glog"
 	"github.com/kubernetes-incubator/external-storage/lib/controller"
 	"github.com/kubernetes-incubator/external-storage/lib/helper"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -79,9 +81,11 @@ func (p *cephFSProvisioner) Provision(options controller.VolumeOptions) (*v1.Per
 	share := fmt.Sprintf("kubernetes-dynamic-pvc-%s", uuid.NewUUID())
 	// create random user id
 	user := fmt.Sprintf("kubernetes-dynamic-user-%s", uuid.NewUUID())
+	// the requested capacity is enforced by a ceph quota on the share
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
 	// provision share
 	// create cmd
-	cmd := exec.Command(provisionCmd, "-n", share, "-u", user)
+	cmd := exec.Command(provisionCmd, "-n", share, "-u", user, "-s", strconv.FormatInt(capacity.Value(), 10))
 	// set env
 	cmd.Env = []string{
 		"CEPH_CLUSTER_NAME=" + cluster,
@@ -116,14 +120,11 @@ func (p *cephFSProvisioner) Provision(options controller.VolumeOptions) (*v1.Per
 
 	_, err = p.client.Core().Secrets(nameSpace).Create(secret)
 	if err != nil {
+		glog.Errorf("Cephfs Provisioner: create secret for share %q failed, err: %v", share, err)
+		p.deleteShare(cluster, adminID, adminSecret, mon, share, user)
 		return nil, fmt.Errorf("failed to create secret")
 	}
 
-	if err != nil {
-		glog.Errorf("Cephfs Provisioner: create volume failed, err: %v", err)
-		return nil, err
-	}
-
 	pv := &v1.PersistentVolume{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: options.PVName,
@@ -135,8 +136,8 @@ func (p *cephFSProvisioner) Provision(options controller.VolumeOptions) (*v1.Per
 		Spec: v1.PersistentVolumeSpec{
 			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
 			AccessModes:                   options.PVC.Spec.AccessModes,
-			Capacity: v1.ResourceList{ //FIXME: kernel cephfs doesn't enforce quota, capacity is not meaningless here.
-				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): capacity,
 			},
 			PersistentVolumeSource: v1.PersistentVolumeSource{
 				CephFS: &v1.CephFSVolumeSource{
@@ -171,9 +172,7 @@ func (p *cephFSProvisioner) Delete(volume *v1.PersistentVolume) error {
 		return errors.New("ceph share annotation not found on PV")
 	}
 	// delete CephFS
-	// TODO when beta is removed, have to check kube version and pick v1/beta
-	// accordingly: maybe the controller lib should offer a function for that
-	class, err := p.client.StorageV1beta1().StorageClasses().Get(helper.GetPersistentVolumeClass(volume), metav1.GetOptions{})
+	class, err := p.client.StorageV1().StorageClasses().Get(helper.GetPersistentVolumeClass(volume), metav1.GetOptions{})
 	if err != nil {
 		return err
 	}
@@ -182,6 +181,24 @@ func (p *cephFSProvisioner) Delete(volume *v1.PersistentVolume) error {
 		return err
 	}
 	user := volume.Spec.PersistentVolumeSource.CephFS.User
+	if err := p.deleteShare(cluster, adminID, adminSecret, mon, share, user); err != nil {
+		return err
+	}
+
+	// delete the user's secret, created in the claim's namespace
+	if volume.Spec.ClaimRef == nil || volume.Spec.CephFS.SecretRef == nil {
+		return nil
+	}
+	err = p.client.Core().Secrets(volume.Spec.ClaimRef.Namespace).Delete(volume.Spec.CephFS.SecretRef.Name, nil)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleted share %q but failed to delete secret: %v", share, err)
+	}
+
+	return nil
+}
+
+// deleteShare deauthorizes the given ceph user and deletes the given share.
+func (p *cephFSProvisioner) deleteShare(cluster, adminID, adminSecret string, mon []string, share, user string) error {
 	// create cmd
 	cmd := exec.Command(provisionCmd, "-r", "-n", share, "-u", user)
 	// set env
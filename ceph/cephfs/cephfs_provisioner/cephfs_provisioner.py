@@ -239,11 +239,12 @@ def main():
     create = True
     share = ""
     user = ""
+    size = None
     cephfs = CephFSNativeDriver()
     try:
-        opts, args = getopt.getopt(sys.argv[1:], "rn:u:", ["remove"])
+        opts, args = getopt.getopt(sys.argv[1:], "rn:u:s:", ["remove"])
     except getopt.GetoptError:
-        print "Usage: " + sys.argv[0] + " --remove -n share_name -u ceph_user_id"
+        print "Usage: " + sys.argv[0] + " --remove -n share_name -u ceph_user_id -s size_in_bytes"
         sys.exit(1)
 
     for opt, arg in opts:
@@ -251,15 +252,17 @@ def main():
             share = arg
         elif opt == '-u':
             user = arg
+        elif opt == '-s':
+            size = int(arg)
         elif opt in ("-r", "--remove"):
             create = False
 
     if share == "" or user == "":
-        print "Usage: " + sys.argv[0] + " --remove -n share_name -u ceph_user_id"
+        print "Usage: " + sys.argv[0] + " --remove -n share_name -u ceph_user_id -s size_in_bytes"
         sys.exit(1)
 
     if create == True:
-        print cephfs.create_share(share, user)    
+        print cephfs.create_share(share, user, size)
     else:
         cephfs.delete_share(share, user)    
         
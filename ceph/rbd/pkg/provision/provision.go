@@ -0,0 +1,254 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/helper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	provisionerIDAnn = "rbdProvisionerIdentity"
+
+	imagePrefix   = "kubernetes-dynamic-pvc-"
+	archivePrefix = "archived-"
+)
+
+type rbdProvisionOptions struct {
+	monitors        []string
+	pool            string
+	adminID         string
+	adminSecret     string
+	userID          string
+	userSecretName  string
+	imageFormat     string
+	imageFeatures   []string
+	fsType          string
+	archiveOnDelete bool
+}
+
+type rbdProvisioner struct {
+	// Kubernetes Client. Use to retrieve Ceph admin secret
+	client kubernetes.Interface
+	// Identity of this rbdProvisioner, generated. Used to identify "this"
+	// provisioner's PVs.
+	identity string
+	rbdUtil  *rbdUtil
+}
+
+// NewRBDProvisioner creates a new rbd provisioner
+func NewRBDProvisioner(client kubernetes.Interface, id string) controller.Provisioner {
+	return &rbdProvisioner{
+		client:   client,
+		identity: id,
+		rbdUtil:  &rbdUtil{},
+	}
+}
+
+var _ controller.Provisioner = &rbdProvisioner{}
+
+// Provision creates an image in the class's pool and returns a PV object
+// representing it.
+func (p *rbdProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	opts, err := p.parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	// the user's secret is referenced by the PV, so it must be in the claim's
+	// namespace
+	if _, err := p.client.Core().Secrets(options.PVC.Namespace).Get(opts.userSecretName, metav1.GetOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to get user secret %q in namespace %q: %v", opts.userSecretName, options.PVC.Namespace, err)
+	}
+
+	image := imagePrefix + string(uuid.NewUUID())
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	// rbd sizes are in MB, round up
+	sizeMB := (capacity.Value() + 1024*1024 - 1) / (1024 * 1024)
+	if err := p.rbdUtil.createImage(image, sizeMB, opts); err != nil {
+		glog.Errorf("failed to create rbd image %q, err: %v", image, err)
+		return nil, err
+	}
+	glog.Infof("successfully created rbd image %q in pool %q", image, opts.pool)
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				provisionerIDAnn: p.identity,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): capacity,
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				RBD: &v1.RBDVolumeSource{
+					CephMonitors: opts.monitors,
+					RBDImage:     image,
+					RBDPool:      opts.pool,
+					RadosUser:    opts.userID,
+					SecretRef: &v1.LocalObjectReference{
+						Name: opts.userSecretName,
+					},
+					FSType:   opts.fsType,
+					ReadOnly: false,
+				},
+			},
+		},
+	}
+
+	return pv, nil
+}
+
+// Delete removes, or archives if the class says so, the image that was created
+// by Provision represented by the given PV.
+func (p *rbdProvisioner) Delete(volume *v1.PersistentVolume) error {
+	ann, ok := volume.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != p.identity {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	if volume.Spec.RBD == nil {
+		return errors.New("PV is not an rbd volume")
+	}
+	image := volume.Spec.RBD.RBDImage
+	class, err := p.client.StorageV1().StorageClasses().Get(helper.GetPersistentVolumeClass(volume), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	opts, err := p.parseParameters(class.Parameters)
+	if err != nil {
+		return err
+	}
+	// the class may have changed since the image was created
+	opts.pool = volume.Spec.RBD.RBDPool
+
+	if opts.archiveOnDelete {
+		archived := archivePrefix + image
+		glog.Infof("archiving rbd image %q as %q", image, archived)
+		return p.rbdUtil.renameImage(image, archived, opts)
+	}
+	return p.rbdUtil.deleteImage(image, opts)
+}
+
+func (p *rbdProvisioner) parseParameters(parameters map[string]string) (*rbdProvisionOptions, error) {
+	opts := &rbdProvisionOptions{
+		pool:        "rbd",
+		adminID:     "admin",
+		imageFormat: "2",
+		fsType:      "ext4",
+	}
+	adminSecretName := ""
+	adminSecretNamespace := "default"
+
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "monitors":
+			for _, m := range strings.Split(v, ",") {
+				opts.monitors = append(opts.monitors, strings.TrimSpace(m))
+			}
+		case "adminid":
+			opts.adminID = v
+		case "adminsecretname":
+			adminSecretName = v
+		case "adminsecretnamespace":
+			adminSecretNamespace = v
+		case "pool":
+			opts.pool = v
+		case "userid":
+			opts.userID = v
+		case "usersecretname":
+			opts.userSecretName = v
+		case "imageformat":
+			if v != "1" && v != "2" {
+				return nil, fmt.Errorf("invalid imageFormat %q, must be 1 or 2", v)
+			}
+			opts.imageFormat = v
+		case "imagefeatures":
+			for _, f := range strings.Split(v, ",") {
+				if f = strings.TrimSpace(f); f != "" {
+					opts.imageFeatures = append(opts.imageFeatures, f)
+				}
+			}
+		case "fstype":
+			opts.fsType = v
+		case "archiveondelete":
+			archive, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid archiveOnDelete %q: %v", v, err)
+			}
+			opts.archiveOnDelete = archive
+		default:
+			return nil, fmt.Errorf("invalid option %q", k)
+		}
+	}
+	// sanity check
+	if len(opts.monitors) < 1 {
+		return nil, fmt.Errorf("missing Ceph monitors")
+	}
+	if adminSecretName == "" {
+		return nil, fmt.Errorf("missing Ceph admin secret name")
+	}
+	if opts.imageFormat == "1" && len(opts.imageFeatures) > 0 {
+		return nil, fmt.Errorf("imageFeatures are only supported with imageFormat 2")
+	}
+	if opts.userSecretName == "" {
+		return nil, fmt.Errorf("missing user secret name")
+	}
+	if opts.userID == "" {
+		opts.userID = opts.adminID
+	}
+	secret, err := p.parseSecret(adminSecretNamespace, adminSecretName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get admin secret from [%q/%q]: %v", adminSecretNamespace, adminSecretName, err)
+	}
+	opts.adminSecret = secret
+
+	return opts, nil
+}
+
+func (p *rbdProvisioner) parseSecret(namespace, secretName string) (string, error) {
+	if p.client == nil {
+		return "", fmt.Errorf("Cannot get kube client")
+	}
+	secret, err := p.client.Core().Secrets(namespace).Get(secretName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, data := range secret.Data {
+		return string(data), nil
+	}
+
+	return "", fmt.Errorf("no secret found")
+}
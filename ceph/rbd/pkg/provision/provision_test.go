@@ -0,0 +1,189 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	storage "k8s.io/client-go/pkg/apis/storage/v1"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+)
+
+func newSecret(namespace, name string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Data:       map[string][]byte{"key": []byte(name + "-key")},
+	}
+}
+
+func TestParseParameters(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		expectError bool
+		expected    *rbdProvisionOptions
+	}{
+		{
+			name: "defaults",
+			parameters: map[string]string{
+				"monitors":        "10.0.0.1:6789, 10.0.0.2:6789",
+				"adminSecretName": "admin",
+				"userSecretName":  "user",
+			},
+			expected: &rbdProvisionOptions{
+				monitors:       []string{"10.0.0.1:6789", "10.0.0.2:6789"},
+				pool:           "rbd",
+				adminID:        "admin",
+				adminSecret:    "admin-key",
+				userID:         "admin",
+				userSecretName: "user",
+				imageFormat:    "2",
+				fsType:         "ext4",
+			},
+		},
+		{
+			name: "all parameters",
+			parameters: map[string]string{
+				"monitors":             "10.0.0.1:6789",
+				"pool":                 "kube",
+				"adminId":              "kube-admin",
+				"adminSecretName":      "admin",
+				"adminSecretNamespace": "default",
+				"userId":               "kube",
+				"userSecretName":       "user",
+				"imageFormat":          "2",
+				"imageFeatures":        "layering,exclusive-lock",
+				"fsType":               "xfs",
+				"archiveOnDelete":      "true",
+			},
+			expected: &rbdProvisionOptions{
+				monitors:        []string{"10.0.0.1:6789"},
+				pool:            "kube",
+				adminID:         "kube-admin",
+				adminSecret:     "admin-key",
+				userID:          "kube",
+				userSecretName:  "user",
+				imageFormat:     "2",
+				imageFeatures:   []string{"layering", "exclusive-lock"},
+				fsType:          "xfs",
+				archiveOnDelete: true,
+			},
+		},
+		{
+			name:        "missing monitors",
+			parameters:  map[string]string{"adminSecretName": "admin", "userSecretName": "user"},
+			expectError: true,
+		},
+		{
+			name:        "missing admin secret",
+			parameters:  map[string]string{"monitors": "10.0.0.1:6789", "adminSecretName": "nonexistent", "userSecretName": "user"},
+			expectError: true,
+		},
+		{
+			name:        "features with format 1",
+			parameters:  map[string]string{"monitors": "10.0.0.1:6789", "adminSecretName": "admin", "userSecretName": "user", "imageFormat": "1", "imageFeatures": "layering"},
+			expectError: true,
+		},
+		{
+			name:        "invalid option",
+			parameters:  map[string]string{"monitors": "10.0.0.1:6789", "adminSecretName": "admin", "userSecretName": "user", "foo": "bar"},
+			expectError: true,
+		},
+	}
+	client := fake.NewSimpleClientset(newSecret("default", "admin"))
+	p := NewRBDProvisioner(client, "id").(*rbdProvisioner)
+	for _, test := range tests {
+		opts, err := p.parseParameters(test.parameters)
+		if test.expectError != (err != nil) {
+			t.Errorf("test case %s: expected error %v but got %v", test.name, test.expectError, err)
+			continue
+		}
+		if !test.expectError && !reflect.DeepEqual(test.expected, opts) {
+			t.Errorf("test case %s: expected options %+v but got %+v", test.name, test.expected, opts)
+		}
+	}
+}
+
+func TestProvisionDelete(t *testing.T) {
+	class := &storage.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "rbd"},
+		Parameters: map[string]string{
+			"monitors":        "10.0.0.1:6789",
+			"adminSecretName": "admin",
+			"userSecretName":  "user",
+			"archiveOnDelete": "true",
+		},
+	}
+	client := fake.NewSimpleClientset(newSecret("default", "admin"), newSecret("ns", "user"), class)
+	p := NewRBDProvisioner(client, "id").(*rbdProvisioner)
+	var commands []string
+	p.rbdUtil.run = func(args ...string) ([]byte, error) {
+		// drop the connection args
+		commands = append(commands, strings.Join(args[:len(args)-5], " "))
+		return nil, nil
+	}
+
+	options := controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        "pvc-1",
+		Parameters:                    class.Parameters,
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "claim"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): resource.MustParse("1500Ki"),
+					},
+				},
+			},
+		},
+	}
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	image := pv.Spec.RBD.RBDImage
+	if pv.Spec.RBD.SecretRef.Name != "user" || pv.Spec.RBD.RadosUser != "admin" || pv.Spec.RBD.RBDPool != "rbd" {
+		t.Errorf("unexpected rbd volume source %+v", pv.Spec.RBD)
+	}
+
+	pv.Annotations[v1.BetaStorageClassAnnotation] = "rbd"
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+
+	expected := []string{
+		"create " + image + " --size 2 --pool rbd --image-format 2",
+		"mv rbd/" + image + " rbd/archived-" + image,
+	}
+	if !reflect.DeepEqual(expected, commands) {
+		t.Errorf("expected commands %v but got %v", expected, commands)
+	}
+
+	options.PVC.Namespace = "other"
+	if _, err := p.Provision(options); err == nil {
+		t.Errorf("expected error provisioning without user secret in claim namespace but got none")
+	}
+}
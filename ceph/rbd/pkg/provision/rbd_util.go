@@ -0,0 +1,68 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const rbdCmd = "rbd"
+
+// rbdUtil runs the rbd command line tool against the cluster given by the
+// options.
+type rbdUtil struct {
+	// run runs the rbd command with the given args. Tests can replace it.
+	run func(args ...string) ([]byte, error)
+}
+
+func (u *rbdUtil) rbd(opts *rbdProvisionOptions, args ...string) error {
+	args = append(args, "--id", opts.adminID, "-m", strings.Join(opts.monitors, ","), "--key="+opts.adminSecret)
+	run := u.run
+	if run == nil {
+		run = func(args ...string) ([]byte, error) {
+			return exec.Command(rbdCmd, args...).CombinedOutput()
+		}
+	}
+	output, err := run(args...)
+	if err != nil {
+		// don't log args, they contain the admin key
+		glog.Errorf("failed to run %s %s, err: %v, output: %v", rbdCmd, args[0], err, string(output))
+		return fmt.Errorf("failed to run %s %s: %v, output: %s", rbdCmd, args[0], err, string(output))
+	}
+	return nil
+}
+
+func (u *rbdUtil) createImage(image string, sizeMB int64, opts *rbdProvisionOptions) error {
+	args := []string{"create", image, "--size", strconv.FormatInt(sizeMB, 10), "--pool", opts.pool, "--image-format", opts.imageFormat}
+	if len(opts.imageFeatures) > 0 {
+		args = append(args, "--image-feature", strings.Join(opts.imageFeatures, ","))
+	}
+	return u.rbd(opts, args...)
+}
+
+func (u *rbdUtil) deleteImage(image string, opts *rbdProvisionOptions) error {
+	return u.rbd(opts, "rm", image, "--pool", opts.pool)
+}
+
+func (u *rbdUtil) renameImage(image, newImage string, opts *rbdProvisionOptions) error {
+	return u.rbd(opts, "mv", opts.pool+"/"+image, opts.pool+"/"+newImage)
+}
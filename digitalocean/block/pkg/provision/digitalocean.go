@@ -0,0 +1,191 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultAPIURL is the URL of DigitalOcean's public API.
+	DefaultAPIURL = "https://api.digitalocean.com"
+
+	// maxErrorBodyLength is how much of a non-JSON error body is reported
+	maxErrorBodyLength = 512
+)
+
+// doVolume is a DigitalOcean block storage volume.
+type doVolume struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Region struct {
+		Slug string `json:"slug"`
+	} `json:"region"`
+	SizeGigabytes int64    `json:"size_gigabytes"`
+	DropletIDs    []int    `json:"droplet_ids"`
+	Tags          []string `json:"tags"`
+}
+
+// createVolumeRequest is the body of a volume creation request.
+type createVolumeRequest struct {
+	Name           string   `json:"name"`
+	Description    string   `json:"description,omitempty"`
+	SizeGigabytes  int64    `json:"size_gigabytes"`
+	Region         string   `json:"region"`
+	FilesystemType string   `json:"filesystem_type,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+}
+
+// doError is the body of a DigitalOcean error response.
+type doError struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+// doClient manages volumes with the DigitalOcean v2 API, authorizing requests
+// with a personal access token.
+type doClient struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newDOClient(apiURL, token string) *doClient {
+	return &doClient{
+		url:    strings.TrimSuffix(apiURL, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// do sends a request with the JSON encoding of in, if not nil, as body and
+// decodes the response body into out, if not nil, if the status is 2xx. It
+// returns the status so callers can tell e.g. missing volumes apart.
+func (c *doClient) do(method, path string, query url.Values, in, out interface{}) (int, error) {
+	u := c.url + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode/100 == 2 {
+		if out != nil {
+			if err := json.Unmarshal(respBody, out); err != nil {
+				return resp.StatusCode, fmt.Errorf("error parsing response of %s %s: %v", method, path, err)
+			}
+		}
+		return resp.StatusCode, nil
+	}
+	doErr := &doError{}
+	if json.Unmarshal(respBody, doErr) == nil && doErr.Message != "" {
+		return resp.StatusCode, fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode, doErr.Message)
+	}
+	if len(respBody) > maxErrorBodyLength {
+		respBody = respBody[:maxErrorBodyLength]
+	}
+	return resp.StatusCode, fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode, respBody)
+}
+
+// createVolume creates a volume. If a volume with the same name already
+// exists in the region, e.g. when retrying, it is returned instead: volume
+// names are unique per region and account.
+func (c *doClient) createVolume(request *createVolumeRequest) (*doVolume, error) {
+	result := &struct {
+		Volume doVolume `json:"volume"`
+	}{}
+	_, err := c.do("POST", "/v2/volumes", nil, request, result)
+	if err == nil {
+		return &result.Volume, nil
+	}
+	existing, getErr := c.getVolumeByName(request.Name, request.Region)
+	if getErr != nil || existing == nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// getVolumeByName returns the volume with the name in the region, or nil if
+// there's none.
+func (c *doClient) getVolumeByName(name, region string) (*doVolume, error) {
+	result := &struct {
+		Volumes []doVolume `json:"volumes"`
+	}{}
+	if _, err := c.do("GET", "/v2/volumes", url.Values{"name": {name}, "region": {region}}, nil, result); err != nil {
+		return nil, err
+	}
+	for i := range result.Volumes {
+		if result.Volumes[i].Name == name {
+			return &result.Volumes[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// getVolume returns the volume with the ID, or nil if it doesn't exist.
+func (c *doClient) getVolume(id string) (*doVolume, error) {
+	result := &struct {
+		Volume doVolume `json:"volume"`
+	}{}
+	status, err := c.do("GET", "/v2/volumes/"+id, nil, nil, result)
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result.Volume, nil
+}
+
+// deleteVolume deletes the volume, which must be detached. It is not an error
+// if the volume doesn't exist.
+func (c *doClient) deleteVolume(id string) error {
+	status, err := c.do("DELETE", "/v2/volumes/"+id, nil, nil, nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
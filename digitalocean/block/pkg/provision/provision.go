@@ -0,0 +1,228 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/util"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	provisionerIDAnn = "doBlockProvisionerIdentity"
+
+	volumePrefix = "kubernetes-dynamic-"
+
+	defaultDriver = "digitalocean/dobs"
+	defaultFSType = "ext4"
+
+	// the smallest and largest volumes, in GiB
+	minVolumeGiB = 1
+	maxVolumeGiB = 16 * 1024
+
+	// the flexvolume options of the PVs
+	volumeIDOption   = "volumeID"
+	volumeNameOption = "volumeName"
+	regionOption     = "region"
+
+	// regionLabel is the label scheduling pods using the PVs to nodes in the
+	// volumes' region
+	regionLabel = "failure-domain.beta.kubernetes.io/region"
+)
+
+type doProvisionOptions struct {
+	region string
+	fsType string
+	driver string
+}
+
+type doProvisioner struct {
+	// Identity of this doProvisioner. Used to identify "this" provisioner's
+	// PVs.
+	identity string
+	do       *doClient
+}
+
+// NewDOProvisioner creates a new DigitalOcean block storage provisioner using
+// the API at the given URL with the given access token.
+func NewDOProvisioner(id, apiURL, token string) controller.Provisioner {
+	return &doProvisioner{
+		identity: id,
+		do:       newDOClient(apiURL, token),
+	}
+}
+
+var _ controller.Provisioner = &doProvisioner{}
+
+// Provision creates a DigitalOcean volume of the claim's size, tagged with the
+// claim's namespace and name, and returns a flexvolume PV for the DigitalOcean
+// driver to attach it.
+func (p *doProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	for _, mode := range options.PVC.Spec.AccessModes {
+		if mode != v1.ReadWriteOnce {
+			return nil, fmt.Errorf("access mode %s is not supported, volumes can only be attached to one droplet", mode)
+		}
+	}
+	opts, err := parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	sizeGiB := util.RoundUpSize(capacity.Value(), 1024*1024*1024)
+	if sizeGiB < minVolumeGiB {
+		sizeGiB = minVolumeGiB
+	}
+	if sizeGiB > maxVolumeGiB {
+		return nil, fmt.Errorf("requested capacity %s is larger than the maximum volume size %dGi", capacity.String(), maxVolumeGiB)
+	}
+
+	name := volumePrefix + options.PVName
+	volume, err := p.do.createVolume(&createVolumeRequest{
+		Name:           name,
+		Description:    fmt.Sprintf("Kubernetes volume for claim %s/%s", options.PVC.Namespace, options.PVC.Name),
+		SizeGigabytes:  sizeGiB,
+		Region:         opts.region,
+		FilesystemType: opts.fsType,
+		Tags:           getTags(options),
+	})
+	if err != nil {
+		glog.Errorf("failed to create volume %q in region %s: %v", name, opts.region, err)
+		return nil, err
+	}
+	glog.Infof("successfully created volume %q (%s) of %dGi in region %s", name, volume.ID, sizeGiB, opts.region)
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+			Labels: map[string]string{
+				regionLabel: opts.region,
+			},
+			Annotations: map[string]string{
+				provisionerIDAnn: p.identity,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): resource.MustParse(fmt.Sprintf("%dGi", sizeGiB)),
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				FlexVolume: &v1.FlexVolumeSource{
+					Driver:   opts.driver,
+					FSType:   opts.fsType,
+					ReadOnly: false,
+					Options: map[string]string{
+						volumeIDOption:   volume.ID,
+						volumeNameOption: name,
+						regionOption:     opts.region,
+					},
+				},
+			},
+		},
+	}
+
+	return pv, nil
+}
+
+// Delete deletes the volume that was created by Provision represented by the
+// given PV. The volume must have been detached from its droplet.
+func (p *doProvisioner) Delete(volume *v1.PersistentVolume) error {
+	ann, ok := volume.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != p.identity {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	if volume.Spec.FlexVolume == nil {
+		return errors.New("PV is not a flexvolume")
+	}
+	id := volume.Spec.FlexVolume.Options[volumeIDOption]
+	if id == "" {
+		return fmt.Errorf("PV has no %s option", volumeIDOption)
+	}
+
+	existing, err := p.do.getVolume(id)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return nil
+	}
+	if len(existing.DropletIDs) > 0 {
+		return fmt.Errorf("volume %s is still attached to droplets %v", id, existing.DropletIDs)
+	}
+	if err := p.do.deleteVolume(id); err != nil {
+		return err
+	}
+	glog.Infof("successfully deleted volume %q (%s)", existing.Name, id)
+
+	return nil
+}
+
+// getTags returns the tags identifying the claim a volume is provisioned for.
+// Tags may only contain letters, numbers, colons, dashes and underscores, all
+// of which are valid in namespace and claim names except dots.
+func getTags(options controller.VolumeOptions) []string {
+	escape := strings.NewReplacer(".", "_")
+	return []string{
+		"kubernetes",
+		"kubernetes-namespace:" + escape.Replace(options.PVC.Namespace),
+		"kubernetes-claim:" + escape.Replace(options.PVC.Name),
+		"kubernetes-pv:" + escape.Replace(options.PVName),
+	}
+}
+
+func parseParameters(parameters map[string]string) (*doProvisionOptions, error) {
+	opts := &doProvisionOptions{
+		fsType: defaultFSType,
+		driver: defaultDriver,
+	}
+
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "region":
+			opts.region = v
+		case "fstype":
+			opts.fsType = v
+		case "driver":
+			opts.driver = v
+		default:
+			return nil, fmt.Errorf("invalid option %q", k)
+		}
+	}
+	// sanity check
+	if opts.region == "" {
+		return nil, fmt.Errorf("missing region")
+	}
+	if opts.fsType != "ext4" && opts.fsType != "xfs" {
+		return nil, fmt.Errorf("invalid fsType %q, must be ext4 or xfs", opts.fsType)
+	}
+	return opts, nil
+}
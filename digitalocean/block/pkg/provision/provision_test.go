@@ -0,0 +1,254 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// fakeDO serves the volumes API from memory, checking requests carry the
+// test token.
+type fakeDO struct {
+	volumes  map[string]*doVolume
+	requests map[string]*createVolumeRequest
+	nextID   int
+}
+
+func (f *fakeDO) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/v2/volumes/")
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/v2/volumes":
+		request := &createVolumeRequest{}
+		json.NewDecoder(r.Body).Decode(request)
+		for _, volume := range f.volumes {
+			if volume.Name == request.Name && volume.Region.Slug == request.Region {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(doError{ID: "conflict", Message: "a volume with that name already exists"})
+				return
+			}
+		}
+		f.nextID++
+		volume := &doVolume{ID: fmt.Sprintf("vol-%d", f.nextID), Name: request.Name, SizeGigabytes: request.SizeGigabytes, Tags: request.Tags}
+		volume.Region.Slug = request.Region
+		f.volumes[volume.ID] = volume
+		f.requests[volume.ID] = request
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"volume": volume})
+	case r.Method == "GET" && r.URL.Path == "/v2/volumes":
+		volumes := []*doVolume{}
+		for _, volume := range f.volumes {
+			if volume.Name == r.URL.Query().Get("name") && volume.Region.Slug == r.URL.Query().Get("region") {
+				volumes = append(volumes, volume)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"volumes": volumes})
+	case r.Method == "GET":
+		volume, ok := f.volumes[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"volume": volume})
+	case r.Method == "DELETE":
+		volume, ok := f.volumes[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if len(volume.DropletIDs) > 0 {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		delete(f.volumes, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newClaimOptions(pvName, size string, accessModes ...v1.PersistentVolumeAccessMode) controller.VolumeOptions {
+	return controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        pvName,
+		Parameters:                    map[string]string{"region": "nyc1"},
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "my.claim"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: accessModes,
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): resource.MustParse(size),
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestProvisionDelete(t *testing.T) {
+	do := &fakeDO{volumes: map[string]*doVolume{}, requests: map[string]*createVolumeRequest{}}
+	server := httptest.NewServer(do)
+	defer server.Close()
+	p := NewDOProvisioner("id", server.URL, "token")
+
+	options := newClaimOptions("pvc-1", "1500Mi", v1.ReadWriteOnce)
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	flex := pv.Spec.FlexVolume
+	id := flex.Options[volumeIDOption]
+	volume, ok := do.volumes[id]
+	if !ok {
+		t.Fatalf("expected volume %q to be created", id)
+	}
+	if volume.Name != "kubernetes-dynamic-pvc-1" || volume.Region.Slug != "nyc1" || volume.SizeGigabytes != 2 {
+		t.Errorf("expected 2GiB volume kubernetes-dynamic-pvc-1 in nyc1 but got %+v", volume)
+	}
+	expectedTags := []string{"kubernetes", "kubernetes-namespace:ns", "kubernetes-claim:my_claim", "kubernetes-pv:pvc-1"}
+	if !reflect.DeepEqual(volume.Tags, expectedTags) {
+		t.Errorf("expected tags %v but got %v", expectedTags, volume.Tags)
+	}
+	if do.requests[id].FilesystemType != "ext4" {
+		t.Errorf("expected volume to be formatted with ext4 but got %q", do.requests[id].FilesystemType)
+	}
+	capacity := pv.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	if capacity.Cmp(resource.MustParse("2Gi")) != 0 {
+		t.Errorf("expected capacity 2Gi but got %s", capacity.String())
+	}
+	if flex.Driver != defaultDriver || flex.FSType != "ext4" || flex.Options[regionOption] != "nyc1" {
+		t.Errorf("expected %s ext4 flexvolume in nyc1 but got %v", defaultDriver, flex)
+	}
+	if pv.Labels[regionLabel] != "nyc1" {
+		t.Errorf("expected region label nyc1 but got %v", pv.Labels)
+	}
+
+	// a retry gets the same volume
+	retried, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error retrying provisioning: %v", err)
+	}
+	if retried.Spec.FlexVolume.Options[volumeIDOption] != id || len(do.volumes) != 1 {
+		t.Errorf("expected retry to return volume %q but got %v", id, retried.Spec.FlexVolume.Options)
+	}
+
+	volume.DropletIDs = []int{1}
+	if err := p.Delete(pv); err == nil {
+		t.Errorf("expected deleting an attached volume to fail")
+	}
+	volume.DropletIDs = nil
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if _, ok := do.volumes[id]; ok {
+		t.Errorf("expected volume %q to be deleted", id)
+	}
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("expected deleting a deleted volume to succeed but got: %v", err)
+	}
+
+	pv.Annotations[provisionerIDAnn] = "other"
+	if err := p.Delete(pv); err == nil {
+		t.Errorf("expected deleting another provisioner's volume to fail")
+	} else if _, ok := err.(*controller.IgnoredError); !ok {
+		t.Errorf("expected IgnoredError deleting another provisioner's volume but got: %v", err)
+	}
+}
+
+func TestProvisionInvalid(t *testing.T) {
+	do := &fakeDO{volumes: map[string]*doVolume{}, requests: map[string]*createVolumeRequest{}}
+	server := httptest.NewServer(do)
+	defer server.Close()
+	p := NewDOProvisioner("id", server.URL, "token")
+
+	tests := []struct {
+		name    string
+		options controller.VolumeOptions
+	}{
+		{
+			name:    "ReadWriteMany",
+			options: newClaimOptions("pvc-1", "1Gi", v1.ReadWriteMany),
+		},
+		{
+			name:    "too large",
+			options: newClaimOptions("pvc-1", "17Ti", v1.ReadWriteOnce),
+		},
+	}
+	for _, test := range tests {
+		if _, err := p.Provision(test.options); err == nil {
+			t.Errorf("Test %s: expected error but got none", test.name)
+		}
+	}
+	if len(do.volumes) != 0 {
+		t.Errorf("expected no volumes to be created but got %v", do.volumes)
+	}
+}
+
+func TestParseParameters(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		expectError bool
+	}{
+		{
+			name:       "minimal",
+			parameters: map[string]string{"region": "nyc1"},
+		},
+		{
+			name:       "xfs",
+			parameters: map[string]string{"region": "nyc1", "fsType": "xfs", "driver": "example.com/do"},
+		},
+		{
+			name:        "no region",
+			parameters:  map[string]string{},
+			expectError: true,
+		},
+		{
+			name:        "invalid fsType",
+			parameters:  map[string]string{"region": "nyc1", "fsType": "btrfs"},
+			expectError: true,
+		},
+		{
+			name:        "unknown parameter",
+			parameters:  map[string]string{"region": "nyc1", "foo": "bar"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		_, err := parseParameters(test.parameters)
+		if test.expectError && err == nil {
+			t.Errorf("Test %s: expected error but got none", test.name)
+		} else if !test.expectError && err != nil {
+			t.Errorf("Test %s: expected no error but got: %v", test.name, err)
+		}
+	}
+}
@@ -49,17 +49,22 @@ ismounted() {
     echo 0
 }
 
-# deletes a provisioned volume
+# deletes a provisioned volume. $1 is the JSON request, e.g.
+# {"pvName":"pvc-1","volume":{"flexVolume":{...}},"annotations":{...}}
 delete(){
-    debug "delete() called"
+    debug "delete() called: $1"
     log "{\"status\": \"Success\"}"
     exit 0
 }
 
-# provisions a volume
+# provisions a volume. $1 is the JSON request, e.g.
+# {"pvName":"pvc-1","pvcName":"claim","pvcNamespace":"default",
+#  "capacity":"1Mi","accessModes":["ReadWriteMany"],"parameters":{...}}
+# and the result may include the PV's "volume" source, "capacity" and
+# "annotations"
 provision(){
-    debug "provision() called"
-    log "{\"status\": \"Success\"}"
+    debug "provision() called: $1"
+    log "{\"status\": \"Success\", \"volume\": {\"flexVolume\": {\"driver\": \"flex\"}}}"
     exit 0
 
 }
@@ -96,8 +101,6 @@ unmount() {
 # log CLI
 # debug $@
 
-log "hello"
-
 op=$1
 
 if [ "$op" = "init" ]; then
@@ -114,11 +117,11 @@ case "$op" in
 		detach $*
 		;;
 	provision)
-		provision $*
+		provision "$@"
 		;;
 	delete)
-        delete $*
-        ;;
+		delete "$@"
+		;;
 	mount)
 		domount $*
 		;;
@@ -18,7 +18,6 @@ package volume
 
 import (
 	"fmt"
-	"os/exec"
 
 	"github.com/golang/glog"
 	"github.com/kubernetes-incubator/external-storage/lib/controller"
@@ -26,7 +25,7 @@ import (
 )
 
 func (p *flexProvisioner) Delete(volume *v1.PersistentVolume) error {
-	glog.Infof("Delete called for volume:", volume.Name)
+	glog.Infof("Delete called for volume: %s", volume.Name)
 
 	provisioned, err := p.provisioned(volume)
 	if err != nil {
@@ -37,10 +36,13 @@ func (p *flexProvisioner) Delete(volume *v1.PersistentVolume) error {
 		return &controller.IgnoredError{Reason: strerr}
 	}
 
-	cmd := exec.Command(p.execCommand, "delete")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		glog.Errorf("Failed to delete volume %s, output: %s, error: %s", volume, output, err.Error())
+	request := &deleteRequest{
+		PVName:      volume.Name,
+		Volume:      &volume.Spec.PersistentVolumeSource,
+		Annotations: volume.Annotations,
+	}
+	if _, err := runScript(p.execCommand, "delete", request); err != nil {
+		glog.Errorf("Failed to delete volume %s, error: %s", volume.Name, err.Error())
 		return err
 	}
 	return nil
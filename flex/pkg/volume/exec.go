@@ -0,0 +1,96 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// statusSuccess is the status a script reports on success, as flexvolume
+// drivers do
+const statusSuccess = "Success"
+
+// provisionRequest is the JSON the script is given with the provision
+// command.
+type provisionRequest struct {
+	PVName       string                          `json:"pvName"`
+	PVCName      string                          `json:"pvcName"`
+	PVCNamespace string                          `json:"pvcNamespace"`
+	Capacity     resource.Quantity               `json:"capacity"`
+	AccessModes  []v1.PersistentVolumeAccessMode `json:"accessModes"`
+	// Parameters are the class's parameters, for the script to interpret
+	Parameters map[string]string `json:"parameters"`
+}
+
+// deleteRequest is the JSON the script is given with the delete command.
+type deleteRequest struct {
+	PVName string                     `json:"pvName"`
+	Volume *v1.PersistentVolumeSource `json:"volume"`
+	// Annotations are the PV's annotations, including those the script
+	// returned from provision
+	Annotations map[string]string `json:"annotations"`
+}
+
+// result is the JSON the script prints on stdout.
+type result struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	// Volume, Capacity and Annotations are only read from provision results.
+	// Volume is the PV's source, a flexvolume of driver "flex" if nil.
+	Volume *v1.PersistentVolumeSource `json:"volume,omitempty"`
+	// Capacity is the PV's capacity, the claim's request if nil.
+	Capacity *resource.Quantity `json:"capacity,omitempty"`
+	// Annotations are added to the PV, e.g. to remember the storage asset's ID
+	// for delete.
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// execScript runs the script with the given command and JSON-encoded request
+// and returns its decoded result, which is an error unless its status is
+// Success.
+func runScript(script, command string, request interface{}) (*result, error) {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding %s request: %v", command, err)
+	}
+	cmd := exec.Command(script, command, string(payload))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	res := &result{}
+	if err := json.Unmarshal(stdout.Bytes(), res); err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("%s %s failed: %v, stderr: %s", script, command, runErr, stderr.String())
+		}
+		return nil, fmt.Errorf("error decoding %s %s output %q: %v", script, command, stdout.String(), err)
+	}
+	if res.Status != statusSuccess {
+		return nil, fmt.Errorf("%s %s returned status %q: %s", script, command, res.Status, res.Message)
+	}
+	if runErr != nil {
+		return nil, fmt.Errorf("%s %s reported success but failed: %v, stderr: %s", script, command, runErr, stderr.String())
+	}
+	return res, nil
+}
@@ -17,8 +17,6 @@ limitations under the License.
 package volume
 
 import (
-	"os/exec"
-
 	"github.com/golang/glog"
 	"github.com/kubernetes-incubator/external-storage/lib/controller"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -64,19 +62,39 @@ var _ controller.Provisioner = &flexProvisioner{}
 // Provision creates a volume i.e. the storage asset and returns a PV object for
 // the volume.
 func (p *flexProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
-	err := p.createVolume(options)
+	res, err := p.createVolume(options)
 	if err != nil {
 		return nil, err
 	}
 
 	annotations := make(map[string]string)
+	for k, v := range res.Annotations {
+		annotations[k] = v
+	}
 	annotations[annCreatedBy] = createdBy
 
 	annotations[annProvisionerID] = string(p.identity)
+
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	if res.Capacity != nil {
+		capacity = *res.Capacity
+	}
 	/*
-		This PV won't work since there's nothing backing it.  the flex script
-		is in flex/flex/flex  (that many layers are required for the flex volume plugin)
+		If the script returns no volume, this PV won't work since there's
+		nothing backing it.  the flex script is in flex/flex/flex  (that many
+		layers are required for the flex volume plugin)
 	*/
+	source := v1.PersistentVolumeSource{
+		FlexVolume: &v1.FlexVolumeSource{
+			Driver:  "flex",
+			Options: map[string]string{},
+
+			ReadOnly: false,
+		},
+	}
+	if res.Volume != nil {
+		source = *res.Volume
+	}
 	pv := &v1.PersistentVolume{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        options.PVName,
@@ -87,30 +105,29 @@ func (p *flexProvisioner) Provision(options controller.VolumeOptions) (*v1.Persi
 			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
 			AccessModes:                   options.PVC.Spec.AccessModes,
 			Capacity: v1.ResourceList{
-				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
-			},
-			PersistentVolumeSource: v1.PersistentVolumeSource{
-
-				FlexVolume: &v1.FlexVolumeSource{
-					Driver:  "flex",
-					Options: map[string]string{},
-
-					ReadOnly: false,
-				},
+				v1.ResourceName(v1.ResourceStorage): capacity,
 			},
+			PersistentVolumeSource: source,
 		},
 	}
 
 	return pv, nil
 }
 
-func (p *flexProvisioner) createVolume(volumeOptions controller.VolumeOptions) error {
-	cmd := exec.Command(p.execCommand, "provision")
-	output, err := cmd.CombinedOutput()
+func (p *flexProvisioner) createVolume(volumeOptions controller.VolumeOptions) (*result, error) {
+	request := &provisionRequest{
+		PVName:       volumeOptions.PVName,
+		PVCName:      volumeOptions.PVC.Name,
+		PVCNamespace: volumeOptions.PVC.Namespace,
+		Capacity:     volumeOptions.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
+		AccessModes:  volumeOptions.PVC.Spec.AccessModes,
+		Parameters:   volumeOptions.Parameters,
+	}
+	res, err := runScript(p.execCommand, "provision", request)
 	if err != nil {
-		glog.Errorf("Failed to create volume %s, output: %s, error: %s", volumeOptions, output, err.Error())
-		return err
+		glog.Errorf("Failed to create volume %s, error: %s", volumeOptions.PVName, err.Error())
+		return nil, err
 	}
 
-	return nil
+	return res, nil
 }
@@ -0,0 +1,160 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	utiltesting "k8s.io/client-go/util/testing"
+)
+
+// writeScript writes a script to dir that saves its arguments to dir/args and
+// prints output.
+func writeScript(t *testing.T, dir, output string) string {
+	script := path.Join(dir, "provision.sh")
+	content := "#!/bin/sh\necho \"$@\" > " + path.Join(dir, "args") + "\necho '" + output + "'\n"
+	if err := ioutil.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Error writing script: %v", err)
+	}
+	return script
+}
+
+func TestProvision(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("flexProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	options := controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        "pvc-1",
+		Parameters:                    map[string]string{"pool": "gold"},
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "claim"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Gi"),
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name               string
+		output             string
+		expectError        bool
+		expectedServer     string
+		expectedCapacity   string
+		expectedAnnotation string
+	}{
+		{
+			name:             "default volume",
+			output:           `{"status": "Success"}`,
+			expectedCapacity: "1Gi",
+		},
+		{
+			name:               "nfs volume",
+			output:             `{"status": "Success", "volume": {"nfs": {"server": "1.2.3.4", "path": "/gold/pvc-1"}}, "capacity": "2Gi", "annotations": {"example.com/id": "42"}}`,
+			expectedServer:     "1.2.3.4",
+			expectedCapacity:   "2Gi",
+			expectedAnnotation: "42",
+		},
+		{
+			name:        "failure",
+			output:      `{"status": "Failure", "message": "pool gold is full"}`,
+			expectError: true,
+		},
+		{
+			name:        "not json",
+			output:      "created pvc-1",
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		p := newFlexProvisionerInternal(nil, writeScript(t, tmpDir, test.output))
+		pv, err := p.Provision(options)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Test %s: expected error but got none", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %s: expected no error but got: %v", test.name, err)
+			continue
+		}
+
+		args, _ := ioutil.ReadFile(path.Join(tmpDir, "args"))
+		expectedArgs := `provision {"pvName":"pvc-1","pvcName":"claim","pvcNamespace":"ns","capacity":"1Gi","accessModes":["ReadWriteOnce"],"parameters":{"pool":"gold"}}` + "\n"
+		if string(args) != expectedArgs {
+			t.Errorf("Test %s: expected script args %q but got %q", test.name, expectedArgs, string(args))
+		}
+		if test.expectedServer == "" && (pv.Spec.FlexVolume == nil || pv.Spec.FlexVolume.Driver != "flex") {
+			t.Errorf("Test %s: expected flex flexvolume but got %v", test.name, pv.Spec.PersistentVolumeSource)
+		}
+		if test.expectedServer != "" && (pv.Spec.NFS == nil || pv.Spec.NFS.Server != test.expectedServer) {
+			t.Errorf("Test %s: expected nfs volume of server %s but got %v", test.name, test.expectedServer, pv.Spec.PersistentVolumeSource)
+		}
+		capacity := pv.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		if capacity.String() != test.expectedCapacity {
+			t.Errorf("Test %s: expected capacity %s but got %s", test.name, test.expectedCapacity, capacity.String())
+		}
+		if pv.Annotations["example.com/id"] != test.expectedAnnotation {
+			t.Errorf("Test %s: expected annotation %q but got %q", test.name, test.expectedAnnotation, pv.Annotations["example.com/id"])
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("flexProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	volume := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pvc-1",
+			Annotations: map[string]string{annProvisionerID: "", "example.com/id": "42"},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				NFS: &v1.NFSVolumeSource{Server: "1.2.3.4", Path: "/gold/pvc-1"},
+			},
+		},
+	}
+
+	p := newFlexProvisionerInternal(nil, writeScript(t, tmpDir, `{"status": "Success"}`))
+	if err := p.Delete(volume); err != nil {
+		t.Errorf("Expected no error but got: %v", err)
+	}
+	args, _ := ioutil.ReadFile(path.Join(tmpDir, "args"))
+	expectedArgs := `delete {"pvName":"pvc-1","volume":{"nfs":{"server":"1.2.3.4","path":"/gold/pvc-1"}},"annotations":{"Provisioner_Id":"","example.com/id":"42"}}` + "\n"
+	if string(args) != expectedArgs {
+		t.Errorf("Expected script args %q but got %q", expectedArgs, string(args))
+	}
+
+	p = newFlexProvisionerInternal(nil, writeScript(t, tmpDir, `{"status": "Not supported"}`))
+	if err := p.Delete(volume); err == nil {
+		t.Errorf("Expected error deleting with a script not supporting delete but got none")
+	}
+}
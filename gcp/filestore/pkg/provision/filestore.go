@@ -0,0 +1,247 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAPIURL is the URL of the Filestore API.
+	DefaultAPIURL = "https://file.googleapis.com"
+	// DefaultMetadataURL is the URL of the GCE metadata server, which gives
+	// the project and the tokens of the instance's service account.
+	DefaultMetadataURL = "http://metadata.google.internal"
+
+	// tokenExpiryDelta is how long before it expires a token is renewed
+	tokenExpiryDelta = time.Minute
+	// maxErrorBodyLength is how much of a non-JSON error body is reported
+	maxErrorBodyLength = 512
+)
+
+// the states of an instance
+const (
+	stateReady    = "READY"
+	stateDeleting = "DELETING"
+	stateError    = "ERROR"
+)
+
+// instance is a Filestore instance.
+type instance struct {
+	Name       string            `json:"name,omitempty"`
+	State      string            `json:"state,omitempty"`
+	Tier       string            `json:"tier"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	FileShares []fileShare       `json:"fileShares"`
+	Networks   []network         `json:"networks"`
+}
+
+type fileShare struct {
+	Name string `json:"name"`
+	// CapacityGB is a JSON encoded int64, i.e. a string
+	CapacityGB int64 `json:"capacityGb,string"`
+}
+
+type network struct {
+	Network         string   `json:"network"`
+	Modes           []string `json:"modes"`
+	ReservedIPRange string   `json:"reservedIpRange,omitempty"`
+	IPAddresses     []string `json:"ipAddresses,omitempty"`
+}
+
+// googleError is the body of a Google API error response.
+type googleError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+// filestoreClient manages instances with the Filestore v1 API, authorizing
+// requests with tokens of the service account of the GCE instance it runs on.
+type filestoreClient struct {
+	apiURL      string
+	metadataURL string
+	client      *http.Client
+
+	mutex  sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func newFilestoreClient(apiURL, metadataURL string) *filestoreClient {
+	return &filestoreClient{
+		apiURL:      strings.TrimSuffix(apiURL, "/"),
+		metadataURL: strings.TrimSuffix(metadataURL, "/"),
+		client:      &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// getMetadata returns the value of the metadata server's path.
+func (c *filestoreClient) getMetadata(path string) ([]byte, error) {
+	req, err := http.NewRequest("GET", c.metadataURL+"/computeMetadata/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getting metadata %s failed with status %d", path, resp.StatusCode)
+	}
+	return body, nil
+}
+
+// getProject returns the ID of the project of the GCE instance.
+func (c *filestoreClient) getProject() (string, error) {
+	project, err := c.getMetadata("project/project-id")
+	if err != nil {
+		return "", err
+	}
+	return string(project), nil
+}
+
+// getToken returns an access token of the GCE instance's service account,
+// getting a new one from the metadata server if the last one is expiring.
+func (c *filestoreClient) getToken() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.token != "" && time.Now().Add(tokenExpiryDelta).Before(c.expiry) {
+		return c.token, nil
+	}
+	body, err := c.getMetadata("instance/service-accounts/default/token")
+	if err != nil {
+		return "", err
+	}
+	token := &struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{}
+	if err := json.Unmarshal(body, token); err != nil {
+		return "", fmt.Errorf("error parsing token: %v", err)
+	}
+	c.token = token.AccessToken
+	c.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return c.token, nil
+}
+
+// do sends a request with the JSON encoding of in, if not nil, as body and
+// decodes the response body into out, if not nil, if the status is 200. It
+// returns the status so callers can tell e.g. missing instances apart.
+func (c *filestoreClient) do(method, path string, in, out interface{}) (int, error) {
+	token, err := c.getToken()
+	if err != nil {
+		return 0, fmt.Errorf("error getting access token: %v", err)
+	}
+	var body []byte
+	if in != nil {
+		if body, err = json.Marshal(in); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequest(method, c.apiURL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		if out != nil {
+			if err := json.Unmarshal(respBody, out); err != nil {
+				return resp.StatusCode, fmt.Errorf("error parsing response of %s %s: %v", method, path, err)
+			}
+		}
+		return resp.StatusCode, nil
+	}
+	googleErr := &googleError{}
+	if json.Unmarshal(respBody, googleErr) == nil && googleErr.Error.Message != "" {
+		return resp.StatusCode, fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode, googleErr.Error.Message)
+	}
+	if len(respBody) > maxErrorBodyLength {
+		respBody = respBody[:maxErrorBodyLength]
+	}
+	return resp.StatusCode, fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode, respBody)
+}
+
+// instanceName returns the resource name of an instance.
+func instanceName(project, location, id string) string {
+	return fmt.Sprintf("projects/%s/locations/%s/instances/%s", project, location, id)
+}
+
+// createInstance starts creating an instance, which is ready once its state
+// is READY. It is not an error if the instance already exists, e.g. when
+// retrying.
+func (c *filestoreClient) createInstance(project, location, id string, inst *instance) error {
+	path := fmt.Sprintf("/v1/projects/%s/locations/%s/instances?instanceId=%s", project, location, id)
+	status, err := c.do("POST", path, inst, nil)
+	if status == http.StatusConflict {
+		return nil
+	}
+	return err
+}
+
+// getInstance returns the instance with the resource name, or nil if it
+// doesn't exist.
+func (c *filestoreClient) getInstance(name string) (*instance, error) {
+	inst := &instance{}
+	status, err := c.do("GET", "/v1/"+name, nil, inst)
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+// deleteInstance starts deleting the instance with the resource name, which
+// is deleted once getting it finds nothing. It is not an error if the
+// instance doesn't exist.
+func (c *filestoreClient) deleteInstance(name string) error {
+	status, err := c.do("DELETE", "/v1/"+name, nil, nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,407 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/util"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	provisionerIDAnn = "filestoreProvisionerIdentity"
+	// instanceAnn is the resource name of the instance of a PV backed by its
+	// own instance. PVs without it are directories of an existing share.
+	instanceAnn = "filestoreInstance"
+
+	instancePrefix = "kubernetes-dynamic-"
+
+	tierBasicHDD = "BASIC_HDD"
+	tierBasicSSD = "BASIC_SSD"
+
+	defaultTier      = tierBasicHDD
+	defaultNetwork   = "default"
+	defaultShareName = "share"
+)
+
+// minCapacityGiB is the smallest instance of each tier
+var minCapacityGiB = map[string]int64{
+	tierBasicHDD: 1024,
+	tierBasicSSD: 2560,
+}
+
+type filestoreProvisionOptions struct {
+	// an instance per volume
+	location        string
+	tier            string
+	network         string
+	reservedIPRange string
+	shareName       string
+	// a directory per volume on an existing share
+	ip    string
+	share string
+}
+
+type filestoreProvisioner struct {
+	filestore *filestoreClient
+	// Identity of this filestoreProvisioner. Used to identify "this"
+	// provisioner's PVs.
+	identity string
+	// mountRoot is where the existing shares are mounted, each at
+	// <mountRoot>/<ip>/<share>
+	mountRoot string
+	// pollInterval and pollTimeout determine how often and how long to wait
+	// for an instance to be created or deleted
+	pollInterval time.Duration
+	pollTimeout  time.Duration
+
+	mutex   sync.Mutex
+	project string
+}
+
+// NewFilestoreProvisioner creates a new Filestore provisioner creating
+// instances in the given project, or the project of the GCE instance it runs
+// on if empty, with the Filestore API at the given URL, and directories on
+// the existing shares mounted under mountRoot.
+func NewFilestoreProvisioner(id, project, apiURL, metadataURL, mountRoot string, instanceTimeout time.Duration) controller.Provisioner {
+	return &filestoreProvisioner{
+		filestore:    newFilestoreClient(apiURL, metadataURL),
+		identity:     id,
+		mountRoot:    mountRoot,
+		pollInterval: 10 * time.Second,
+		pollTimeout:  instanceTimeout,
+		project:      project,
+	}
+}
+
+var _ controller.Provisioner = &filestoreProvisioner{}
+
+// Provision creates a Filestore instance for the claim, or a directory for it
+// on the class's existing share, and returns an NFS PV pointing at the
+// instance's IP.
+func (p *filestoreProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	opts, err := parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	var pv *v1.PersistentVolume
+	if opts.ip != "" {
+		pv, err = p.provisionDirectory(options, opts)
+	} else {
+		pv, err = p.provisionInstance(options, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pv.Annotations[provisionerIDAnn] = p.identity
+	return pv, nil
+}
+
+// provisionInstance creates an instance with a single share of the claim's
+// size, or the tier's minimum, and waits for it to be ready.
+func (p *filestoreProvisioner) provisionInstance(options controller.VolumeOptions, opts *filestoreProvisionOptions) (*v1.PersistentVolume, error) {
+	project, err := p.getProject()
+	if err != nil {
+		return nil, fmt.Errorf("error getting project: %v", err)
+	}
+
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	capacityGiB := util.RoundUpSize(capacity.Value(), 1024*1024*1024)
+	if capacityGiB < minCapacityGiB[opts.tier] {
+		capacityGiB = minCapacityGiB[opts.tier]
+	}
+
+	id := instancePrefix + options.PVName
+	name := instanceName(project, opts.location, id)
+	err = p.filestore.createInstance(project, opts.location, id, &instance{
+		Tier:   opts.tier,
+		Labels: getLabels(options),
+		FileShares: []fileShare{
+			{Name: opts.shareName, CapacityGB: capacityGiB},
+		},
+		Networks: []network{
+			{Network: opts.network, Modes: []string{"MODE_IPV4"}, ReservedIPRange: opts.reservedIPRange},
+		},
+	})
+	if err != nil {
+		glog.Errorf("failed to create instance %q: %v", name, err)
+		return nil, err
+	}
+	inst, err := p.waitForInstance(name)
+	if err != nil {
+		glog.Errorf("instance %q did not become ready: %v", name, err)
+		if err := p.filestore.deleteInstance(name); err != nil {
+			glog.Errorf("failed to roll back creation of instance %q: %v", name, err)
+		}
+		return nil, err
+	}
+	if len(inst.Networks) == 0 || len(inst.Networks[0].IPAddresses) == 0 || len(inst.FileShares) == 0 {
+		return nil, fmt.Errorf("instance %q has no IP address or share", name)
+	}
+	glog.Infof("successfully created instance %q of %dGi", name, inst.FileShares[0].CapacityGB)
+
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				instanceAnn: name,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): resource.MustParse(fmt.Sprintf("%dGi", inst.FileShares[0].CapacityGB)),
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				NFS: &v1.NFSVolumeSource{
+					Server:   inst.Networks[0].IPAddresses[0],
+					Path:     "/" + inst.FileShares[0].Name,
+					ReadOnly: false,
+				},
+			},
+		},
+	}, nil
+}
+
+// waitForInstance waits for the instance to be ready and returns it.
+func (p *filestoreProvisioner) waitForInstance(name string) (*instance, error) {
+	var inst *instance
+	err := wait.Poll(p.pollInterval, p.pollTimeout, func() (bool, error) {
+		var err error
+		inst, err = p.filestore.getInstance(name)
+		if err != nil {
+			return false, err
+		}
+		if inst == nil {
+			return false, nil
+		}
+		switch inst.State {
+		case stateReady:
+			return true, nil
+		case stateError:
+			return false, fmt.Errorf("instance %s is in error state", name)
+		}
+		return false, nil
+	})
+	return inst, err
+}
+
+// provisionDirectory creates a directory named after the PV on the existing
+// share, which must be mounted under mountRoot. The claim's size is not
+// enforced.
+func (p *filestoreProvisioner) provisionDirectory(options controller.VolumeOptions, opts *filestoreProvisionOptions) (*v1.PersistentVolume, error) {
+	root, err := p.getShareRoot(opts.ip, opts.share)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(root, options.PVName)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, fmt.Errorf("error creating directory %s: %v", dir, err)
+	}
+	// the mode of MkdirAll is masked by the umask
+	if err := os.Chmod(dir, 0777); err != nil {
+		return nil, fmt.Errorf("error setting mode of directory %s: %v", dir, err)
+	}
+	glog.Infof("successfully created directory %s on %s:/%s", options.PVName, opts.ip, opts.share)
+
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        options.PVName,
+			Annotations: map[string]string{},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			// directories have no quota, the capacity is only nominal
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				NFS: &v1.NFSVolumeSource{
+					Server:   opts.ip,
+					Path:     path.Join("/", opts.share, options.PVName),
+					ReadOnly: false,
+				},
+			},
+		},
+	}, nil
+}
+
+// getShareRoot returns where the share is mounted, failing if it isn't, so
+// that directories aren't created on, or deleted from, the container's own
+// filesystem.
+func (p *filestoreProvisioner) getShareRoot(ip, share string) (string, error) {
+	root := filepath.Join(p.mountRoot, ip, share)
+	if _, err := os.Stat(root); err != nil {
+		return "", fmt.Errorf("share %s:/%s is not mounted at %s: %v", ip, share, root, err)
+	}
+	return root, nil
+}
+
+// Delete deletes the instance or the directory that was created by Provision
+// represented by the given PV.
+func (p *filestoreProvisioner) Delete(volume *v1.PersistentVolume) error {
+	ann, ok := volume.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != p.identity {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	if volume.Spec.NFS == nil {
+		return errors.New("PV is not an NFS volume")
+	}
+
+	if name, ok := volume.Annotations[instanceAnn]; ok {
+		return p.deleteInstance(name)
+	}
+
+	// the path is /<share>/<pv name>
+	share, dir := path.Split(strings.TrimPrefix(volume.Spec.NFS.Path, "/"))
+	root, err := p.getShareRoot(volume.Spec.NFS.Server, strings.TrimSuffix(share, "/"))
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(root, dir)); err != nil {
+		return fmt.Errorf("error deleting directory %s: %v", dir, err)
+	}
+	glog.Infof("successfully deleted directory %s on %s:%s", dir, volume.Spec.NFS.Server, share)
+	return nil
+}
+
+// deleteInstance deletes the instance and waits for it to be gone.
+func (p *filestoreProvisioner) deleteInstance(name string) error {
+	inst, err := p.filestore.getInstance(name)
+	if err != nil {
+		return err
+	}
+	if inst == nil {
+		return nil
+	}
+	// a retry finds the instance still being deleted
+	if inst.State != stateDeleting {
+		if err := p.filestore.deleteInstance(name); err != nil {
+			return err
+		}
+	}
+	err = wait.Poll(p.pollInterval, p.pollTimeout, func() (bool, error) {
+		inst, err := p.filestore.getInstance(name)
+		return inst == nil, err
+	})
+	if err != nil {
+		return fmt.Errorf("error waiting for instance %s to be deleted: %v", name, err)
+	}
+	glog.Infof("successfully deleted instance %q", name)
+	return nil
+}
+
+// getProject returns the project to create instances in.
+func (p *filestoreProvisioner) getProject() (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.project != "" {
+		return p.project, nil
+	}
+	project, err := p.filestore.getProject()
+	if err != nil {
+		return "", err
+	}
+	p.project = project
+	return project, nil
+}
+
+// getLabels returns the labels identifying the claim an instance is created
+// for. Labels may only contain lowercase letters, numbers, dashes and
+// underscores, all of which are valid in namespace and claim names except
+// dots.
+func getLabels(options controller.VolumeOptions) map[string]string {
+	escape := strings.NewReplacer(".", "_")
+	return map[string]string{
+		"kubernetes-pvc-namespace": escape.Replace(options.PVC.Namespace),
+		"kubernetes-pvc-name":      escape.Replace(options.PVC.Name),
+		"kubernetes-pv":            escape.Replace(options.PVName),
+	}
+}
+
+func parseParameters(parameters map[string]string) (*filestoreProvisionOptions, error) {
+	opts := &filestoreProvisionOptions{}
+
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "location":
+			opts.location = v
+		case "tier":
+			opts.tier = strings.ToUpper(v)
+		case "network":
+			opts.network = v
+		case "reservediprange":
+			opts.reservedIPRange = v
+		case "sharename":
+			opts.shareName = v
+		case "ip":
+			opts.ip = v
+		case "share":
+			opts.share = strings.Trim(v, "/")
+		default:
+			return nil, fmt.Errorf("invalid option %q", k)
+		}
+	}
+	// sanity check
+	if opts.ip != "" || opts.share != "" {
+		if opts.ip == "" || opts.share == "" {
+			return nil, fmt.Errorf("ip and share must be given together")
+		}
+		if opts.location != "" || opts.tier != "" || opts.network != "" || opts.reservedIPRange != "" || opts.shareName != "" {
+			return nil, fmt.Errorf("ip and share can't be given with instance parameters")
+		}
+		return opts, nil
+	}
+	if opts.location == "" {
+		return nil, fmt.Errorf("missing location, or ip and share")
+	}
+	if opts.tier == "" {
+		opts.tier = defaultTier
+	}
+	if _, ok := minCapacityGiB[opts.tier]; !ok {
+		return nil, fmt.Errorf("invalid tier %q, must be %s or %s", opts.tier, tierBasicHDD, tierBasicSSD)
+	}
+	if opts.network == "" {
+		opts.network = defaultNetwork
+	}
+	if opts.shareName == "" {
+		opts.shareName = defaultShareName
+	}
+	return opts, nil
+}
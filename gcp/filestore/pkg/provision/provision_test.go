@@ -0,0 +1,267 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	utiltesting "k8s.io/client-go/util/testing"
+)
+
+// fakeGCP serves the metadata server and the instances API from memory.
+// Instances become ready, and deleted instances go away, the second time
+// they're got.
+type fakeGCP struct {
+	instances map[string]*instance
+	gets      map[string]int
+}
+
+func (f *fakeGCP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/computeMetadata/") {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/computeMetadata/v1/project/project-id":
+			w.Write([]byte("my-project"))
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "expires_in": 3600})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/v1/")
+	switch r.Method {
+	case "POST":
+		name += "/" + r.URL.Query().Get("instanceId")
+		if _, ok := f.instances[name]; ok {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		inst := &instance{}
+		json.NewDecoder(r.Body).Decode(inst)
+		inst.Name = name
+		inst.State = "CREATING"
+		inst.Networks[0].IPAddresses = []string{"10.0.0.2"}
+		f.instances[name] = inst
+		json.NewEncoder(w).Encode(map[string]string{"name": name + "/operations/create"})
+	case "GET":
+		inst, ok := f.instances[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": 404, "message": "not found"}})
+			return
+		}
+		f.gets[name]++
+		if f.gets[name] > 1 {
+			if inst.State == stateDeleting {
+				delete(f.instances, name)
+			} else {
+				inst.State = stateReady
+			}
+		}
+		json.NewEncoder(w).Encode(inst)
+	case "DELETE":
+		inst, ok := f.instances[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		inst.State = stateDeleting
+		f.gets[name] = 0
+		json.NewEncoder(w).Encode(map[string]string{"name": name + "/operations/delete"})
+	}
+}
+
+func newTestProvisioner(url, mountRoot string) *filestoreProvisioner {
+	p := NewFilestoreProvisioner("id", "", url, url, mountRoot, time.Second).(*filestoreProvisioner)
+	p.pollInterval = time.Millisecond
+	return p
+}
+
+func newClaimOptions(parameters map[string]string) controller.VolumeOptions {
+	return controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        "pvc-1",
+		Parameters:                    parameters,
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "my.claim"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): resource.MustParse("100Gi"),
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestProvisionDeleteInstance(t *testing.T) {
+	gcp := &fakeGCP{instances: map[string]*instance{}, gets: map[string]int{}}
+	server := httptest.NewServer(gcp)
+	defer server.Close()
+	p := newTestProvisioner(server.URL, "")
+
+	pv, err := p.Provision(newClaimOptions(map[string]string{"location": "us-central1-c", "tier": "basic_ssd"}))
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	name := "projects/my-project/locations/us-central1-c/instances/kubernetes-dynamic-pvc-1"
+	inst, ok := gcp.instances[name]
+	if !ok {
+		t.Fatalf("expected instance %q to be created", name)
+	}
+	if inst.Tier != tierBasicSSD || inst.FileShares[0].CapacityGB != 2560 || inst.Networks[0].Network != "default" {
+		t.Errorf("expected 2560GiB BASIC_SSD instance on network default but got %+v", inst)
+	}
+	if inst.Labels["kubernetes-pvc-namespace"] != "ns" || inst.Labels["kubernetes-pvc-name"] != "my_claim" {
+		t.Errorf("expected instance labelled with claim ns/my_claim but got %v", inst.Labels)
+	}
+	if pv.Annotations[instanceAnn] != name {
+		t.Errorf("expected instance annotation %q but got %q", name, pv.Annotations[instanceAnn])
+	}
+	if pv.Spec.NFS.Server != "10.0.0.2" || pv.Spec.NFS.Path != "/share" {
+		t.Errorf("expected NFS volume 10.0.0.2:/share but got %v", pv.Spec.NFS)
+	}
+	capacity := pv.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	if capacity.Cmp(resource.MustParse("2560Gi")) != 0 {
+		t.Errorf("expected capacity 2560Gi but got %s", capacity.String())
+	}
+
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if _, ok := gcp.instances[name]; ok {
+		t.Errorf("expected instance %q to be deleted", name)
+	}
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("expected deleting a deleted volume to succeed but got: %v", err)
+	}
+}
+
+func TestProvisionDeleteDirectory(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("filestoreProvisionTest")
+	defer os.RemoveAll(tmpDir)
+	p := newTestProvisioner("http://unused", tmpDir)
+
+	options := newClaimOptions(map[string]string{"ip": "10.0.0.2", "share": "/share"})
+	if _, err := p.Provision(options); err == nil {
+		t.Errorf("expected provisioning on an unmounted share to fail")
+	}
+
+	root := filepath.Join(tmpDir, "10.0.0.2", "share")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatalf("Error creating share root: %v", err)
+	}
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	if pv.Spec.NFS.Server != "10.0.0.2" || pv.Spec.NFS.Path != "/share/pvc-1" {
+		t.Errorf("expected NFS volume 10.0.0.2:/share/pvc-1 but got %v", pv.Spec.NFS)
+	}
+	if _, ok := pv.Annotations[instanceAnn]; ok {
+		t.Errorf("expected no instance annotation but got %v", pv.Annotations)
+	}
+	dir := filepath.Join(root, "pvc-1")
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("expected directory %s to be created but got: %v", dir, err)
+	}
+
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected directory %s to be deleted but got: %v", dir, err)
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Errorf("expected share root %s to be kept but got: %v", root, err)
+	}
+}
+
+func TestParseParameters(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		expectError bool
+	}{
+		{
+			name:       "instance",
+			parameters: map[string]string{"location": "us-central1-c"},
+		},
+		{
+			name:       "instance with everything",
+			parameters: map[string]string{"location": "us-central1-c", "tier": "BASIC_SSD", "network": "vpc", "reservedIPRange": "10.0.0.0/29", "shareName": "vol1"},
+		},
+		{
+			name:       "directory",
+			parameters: map[string]string{"ip": "10.0.0.2", "share": "share"},
+		},
+		{
+			name:        "nothing",
+			parameters:  map[string]string{},
+			expectError: true,
+		},
+		{
+			name:        "invalid tier",
+			parameters:  map[string]string{"location": "us-central1-c", "tier": "PREMIUM_ULTRA"},
+			expectError: true,
+		},
+		{
+			name:        "ip without share",
+			parameters:  map[string]string{"ip": "10.0.0.2"},
+			expectError: true,
+		},
+		{
+			name:        "directory and instance",
+			parameters:  map[string]string{"ip": "10.0.0.2", "share": "share", "location": "us-central1-c"},
+			expectError: true,
+		},
+		{
+			name:        "unknown parameter",
+			parameters:  map[string]string{"location": "us-central1-c", "foo": "bar"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		_, err := parseParameters(test.parameters)
+		if test.expectError && err == nil {
+			t.Errorf("Test %s: expected error but got none", test.name)
+		} else if !test.expectError && err != nil {
+			t.Errorf("Test %s: expected no error but got: %v", test.name, err)
+		}
+	}
+}
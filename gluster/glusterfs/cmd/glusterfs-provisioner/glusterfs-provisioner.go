@@ -0,0 +1,492 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	dstrings "strings"
+
+	"github.com/golang/glog"
+	gcli "github.com/heketi/heketi/client/api/go-client"
+	gapi "github.com/heketi/heketi/pkg/glusterfs/api"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/helper"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	provisionerName    = "gluster.org/glusterfs"
+	secretKeyName      = "key"
+	provisionerNameKey = "PROVISIONER_NAME"
+	volumeIDAnn        = "glusterVolumeID"
+	provisionerIDAnn   = "glusterFSProvIdentity"
+	creatorAnn         = "kubernetes.io/createdby"
+	volumeTypeAnn      = "gluster.org/type"
+	descAnn            = "Gluster-external: Dynamically provisioned PV"
+	heketiAnn          = "heketi-dynamic-provisioner"
+	endpointPrefix     = "glusterfs-dynamic-"
+	// glusterfsPort is a dummy port: endpoints must have one, but the
+	// glusterfs volume plugin only uses their addresses
+	glusterfsPort = 1
+)
+
+type glusterFSProvisioner struct {
+	// Kubernetes Client. Use to retrieve Gluster admin secret and manage the
+	// volumes' endpoints
+	client kubernetes.Interface
+
+	// Identity of this glusterFSProvisioner, generated. Used to identify "this"
+	// provisioner's PVs.
+	identity string
+}
+
+type provisionerConfig struct {
+	// Required: heketi's REST URL
+	url string
+
+	// Optional: heketi user and key, or a secret holding the key
+	user                string
+	userKey             string
+	restSecretName      string
+	restSecretNamespace string
+	restSecretValue     string
+
+	// Optional: comma-separated clusters to create volumes in
+	clusterIDs []string
+
+	// Optional: volume durability, default replicate with 3 replicas
+	volumeType gapi.VolumeDurabilityInfo
+}
+
+// NewGlusterFSProvisioner creates a new glusterfs provisioner
+func NewGlusterFSProvisioner(client kubernetes.Interface, id string) controller.Provisioner {
+	return &glusterFSProvisioner{
+		client:   client,
+		identity: id,
+	}
+}
+
+var _ controller.Provisioner = &glusterFSProvisioner{}
+
+// Provision creates a gluster volume via heketi, and endpoints for it in the
+// claim's namespace, and returns a PV object representing it.
+func (p *glusterFSProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	glog.V(4).Infof("glusterfs: Provision VolumeOptions %v", options)
+
+	cfg, err := parseClassParameters(options.Parameters, p.client)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	// heketi sizes are in GB, round up
+	sizeGB := int((capacity.Value() + 1024*1024*1024 - 1) / (1024 * 1024 * 1024))
+
+	cli := gcli.NewClient(cfg.url, cfg.user, cfg.restSecretValue)
+	if cli == nil {
+		return nil, fmt.Errorf("failed to create glusterfs rest client")
+	}
+	volumeReq := &gapi.VolumeCreateRequest{
+		Size:       sizeGB,
+		Clusters:   cfg.clusterIDs,
+		Durability: cfg.volumeType,
+	}
+	volume, err := cli.VolumeCreate(volumeReq)
+	if err != nil {
+		glog.Errorf("glusterfs: error creating volume %v", err)
+		return nil, fmt.Errorf("error creating volume %v", err)
+	}
+	glog.V(1).Infof("glusterfs: volume with size: %d and name: %s created", volume.Size, volume.Name)
+
+	addresses, err := getClusterNodes(cli, volume.Cluster)
+	if err != nil {
+		p.deleteVolume(cli, volume.Id)
+		return nil, fmt.Errorf("error getting storage addresses of cluster %s: %v", volume.Cluster, err)
+	}
+
+	endpointName := endpointPrefix + options.PVC.Name
+	if err := p.createEndpointService(options.PVC.Namespace, endpointName, addresses, options.PVC.Name); err != nil {
+		p.deleteVolume(cli, volume.Id)
+		return nil, fmt.Errorf("error creating endpoints %s/%s: %v", options.PVC.Namespace, endpointName, err)
+	}
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				provisionerIDAnn: p.identity,
+				volumeIDAnn:      volume.Id,
+				creatorAnn:       heketiAnn,
+				volumeTypeAnn:    "file",
+				"Description":    descAnn,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): capacity,
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				Glusterfs: &v1.GlusterfsVolumeSource{
+					EndpointsName: endpointName,
+					Path:          volume.Name,
+					ReadOnly:      false,
+				},
+			},
+		},
+	}
+
+	return pv, nil
+}
+
+// Delete removes the gluster volume that was created by Provision represented
+// by the given PV, and its endpoints.
+func (p *glusterFSProvisioner) Delete(volume *v1.PersistentVolume) error {
+	ann, ok := volume.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != p.identity {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	volumeID, ok := volume.Annotations[volumeIDAnn]
+	if !ok {
+		return errors.New("gluster volume id annotation not found on PV")
+	}
+
+	class, err := p.client.StorageV1().StorageClasses().Get(helper.GetPersistentVolumeClass(volume), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	cfg, err := parseClassParameters(class.Parameters, p.client)
+	if err != nil {
+		return err
+	}
+
+	cli := gcli.NewClient(cfg.url, cfg.user, cfg.restSecretValue)
+	if cli == nil {
+		return fmt.Errorf("failed to create glusterfs rest client")
+	}
+	if err := p.deleteVolume(cli, volumeID); err != nil {
+		return err
+	}
+
+	if volume.Spec.ClaimRef == nil || volume.Spec.Glusterfs == nil {
+		return nil
+	}
+	if err := p.deleteEndpointService(volume.Spec.ClaimRef.Namespace, volume.Spec.Glusterfs.EndpointsName); err != nil {
+		return fmt.Errorf("deleted volume %s but error deleting endpoints: %v", volumeID, err)
+	}
+
+	return nil
+}
+
+func (p *glusterFSProvisioner) deleteVolume(cli *gcli.Client, volumeID string) error {
+	glog.V(1).Infof("glusterfs: deleting volume %v", volumeID)
+	if err := cli.VolumeDelete(volumeID); err != nil {
+		glog.Errorf("glusterfs: error deleting volume %v: %v", volumeID, err)
+		return fmt.Errorf("error deleting volume %v: %v", volumeID, err)
+	}
+	return nil
+}
+
+// getClusterNodes returns the storage addresses of the nodes of the given
+// cluster, which the glusterfs volume plugin mounts volumes from.
+func getClusterNodes(cli *gcli.Client, cluster string) ([]string, error) {
+	clusterInfo, err := cli.ClusterInfo(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []string
+	for _, node := range clusterInfo.Nodes {
+		nodeInfo, err := cli.NodeInfo(node)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, nodeInfo.NodeAddRequest.Hostnames.Storage...)
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no storage addresses found")
+	}
+	return addresses, nil
+}
+
+// createEndpointService creates endpoints with the given addresses, and a
+// service without a selector so they are not garbage collected, for the
+// claim's PV to refer to.
+func (p *glusterFSProvisioner) createEndpointService(namespace, name string, addresses []string, claimName string) error {
+	labels := map[string]string{"gluster.kubernetes.io/provisioned-for-pvc": claimName}
+	endpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    labels,
+		},
+		Subsets: []v1.EndpointSubset{
+			{
+				Ports: []v1.EndpointPort{{Port: glusterfsPort}},
+			},
+		},
+	}
+	for _, address := range addresses {
+		endpoints.Subsets[0].Addresses = append(endpoints.Subsets[0].Addresses, v1.EndpointAddress{IP: address})
+	}
+	if _, err := p.client.Core().Endpoints(namespace).Create(endpoints); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    labels,
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{Protocol: v1.ProtocolTCP, Port: glusterfsPort}},
+		},
+	}
+	if _, err := p.client.Core().Services(namespace).Create(service); err != nil && !apierrors.IsAlreadyExists(err) {
+		p.client.Core().Endpoints(namespace).Delete(name, nil)
+		return err
+	}
+	return nil
+}
+
+func (p *glusterFSProvisioner) deleteEndpointService(namespace, name string) error {
+	// deleting the service deletes its endpoints too
+	err := p.client.Core().Services(namespace).Delete(name, nil)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	err = p.client.Core().Endpoints(namespace).Delete(name, nil)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func parseClassParameters(params map[string]string, kubeclient kubernetes.Interface) (*provisionerConfig, error) {
+	var cfg provisionerConfig
+	var err error
+
+	authEnabled := true
+	volumeType := ""
+
+	for k, v := range params {
+		switch dstrings.ToLower(k) {
+		case "resturl":
+			cfg.url = v
+		case "restuser":
+			cfg.user = v
+		case "restuserkey":
+			cfg.userKey = v
+		case "restsecretname":
+			cfg.restSecretName = v
+		case "restsecretnamespace":
+			cfg.restSecretNamespace = v
+		case "clusterid":
+			if len(v) != 0 {
+				cfg.clusterIDs = dstrings.Split(v, ",")
+			}
+		case "restauthenabled":
+			authEnabled = dstrings.ToLower(v) == "true"
+		case "volumetype":
+			volumeType = v
+		default:
+			return nil, fmt.Errorf("glusterfs: invalid option %q for volume plugin %s", k, "glusterfs")
+		}
+	}
+
+	if len(cfg.url) == 0 {
+		return nil, fmt.Errorf("StorageClass for provisioner %s must contain 'resturl' parameter", "glusterfs")
+	}
+
+	cfg.volumeType, err = parseVolumeType(volumeType)
+	if err != nil {
+		return nil, err
+	}
+
+	if !authEnabled {
+		cfg.user = ""
+		cfg.restSecretName = ""
+		cfg.restSecretNamespace = ""
+		cfg.userKey = ""
+		cfg.restSecretValue = ""
+	}
+
+	if len(cfg.restSecretName) != 0 || len(cfg.restSecretNamespace) != 0 {
+		// restSecretName + Namespace has precedence over userKey
+		if len(cfg.restSecretName) != 0 && len(cfg.restSecretNamespace) != 0 {
+			cfg.restSecretValue, err = parseSecret(cfg.restSecretNamespace, cfg.restSecretName, kubeclient)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, fmt.Errorf("StorageClass for provisioner %q must have restSecretNamespace and restSecretName either both set or both empty", "glusterfs")
+		}
+	} else {
+		cfg.restSecretValue = cfg.userKey
+	}
+
+	return &cfg, nil
+}
+
+// parseVolumeType parses a volumetype parameter of the form "replicate:3",
+// "disperse:4:2" or "none".
+func parseVolumeType(volumeType string) (gapi.VolumeDurabilityInfo, error) {
+	if len(volumeType) == 0 {
+		return gapi.VolumeDurabilityInfo{Type: gapi.DurabilityReplicate, Replicate: gapi.ReplicaDurability{Replica: 3}}, nil
+	}
+
+	parseVolumeType := dstrings.Split(volumeType, ":")
+	switch parseVolumeType[0] {
+	case "replicate":
+		if len(parseVolumeType) >= 2 {
+			replica, err := strconv.Atoi(parseVolumeType[1])
+			if err != nil || replica < 1 {
+				return gapi.VolumeDurabilityInfo{}, fmt.Errorf("error parsing volumetype %q: invalid replica count", volumeType)
+			}
+			return gapi.VolumeDurabilityInfo{Type: gapi.DurabilityReplicate, Replicate: gapi.ReplicaDurability{Replica: replica}}, nil
+		}
+		return gapi.VolumeDurabilityInfo{}, fmt.Errorf("error parsing volumetype %q: replica count is required", volumeType)
+	case "disperse":
+		if len(parseVolumeType) >= 3 {
+			data, err := strconv.Atoi(parseVolumeType[1])
+			if err != nil || data < 1 {
+				return gapi.VolumeDurabilityInfo{}, fmt.Errorf("error parsing volumetype %q: invalid data count", volumeType)
+			}
+			redundancy, err := strconv.Atoi(parseVolumeType[2])
+			if err != nil || redundancy < 1 {
+				return gapi.VolumeDurabilityInfo{}, fmt.Errorf("error parsing volumetype %q: invalid redundancy count", volumeType)
+			}
+			return gapi.VolumeDurabilityInfo{Type: gapi.DurabilityEC, Disperse: gapi.DisperseDurability{Data: data, Redundancy: redundancy}}, nil
+		}
+		return gapi.VolumeDurabilityInfo{}, fmt.Errorf("error parsing volumetype %q: data and redundancy counts are required", volumeType)
+	case "none":
+		return gapi.VolumeDurabilityInfo{Type: gapi.DurabilityDistributeOnly}, nil
+	default:
+		return gapi.VolumeDurabilityInfo{}, fmt.Errorf("error parsing volumetype %q: unknown type", volumeType)
+	}
+}
+
+// parseSecret finds a given Secret instance and reads user password from it.
+func parseSecret(namespace, secretName string, kubeClient kubernetes.Interface) (string, error) {
+	if kubeClient == nil {
+		return "", fmt.Errorf("Cannot get kube client")
+	}
+	secrets, err := kubeClient.Core().Secrets(namespace).Get(secretName, metav1.GetOptions{})
+	if err != nil {
+		glog.Errorf("failed to get secret %s/%s: %v", namespace, secretName, err)
+		return "", fmt.Errorf("failed to get secret %s/%s: %v", namespace, secretName, err)
+	}
+	if secrets.Type != v1.SecretType(provisionerName) {
+		return "", fmt.Errorf("Cannot get secret of type %s", provisionerName)
+	}
+	if len(secrets.Data) == 0 {
+		return "", fmt.Errorf("empty secret map")
+	}
+	secret := ""
+	for k, v := range secrets.Data {
+		if k == secretKeyName {
+			return string(v), nil
+		}
+		secret = string(v)
+	}
+	// If not found, the last secret in the map wins as done before
+	return secret, nil
+}
+
+var (
+	master     = flag.String("master", "", "Master URL")
+	kubeconfig = flag.String("kubeconfig", "", "Absolute path to the kubeconfig")
+	id         = flag.String("id", "", "Unique provisioner identity")
+)
+
+func main() {
+	flag.Parse()
+	flag.Set("logtostderr", "true")
+
+	// Create an InClusterConfig and use it to create a client for the controller
+	// to use to communicate with Kubernetes
+
+	var config *rest.Config
+	var err error
+	if *master != "" || *kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+
+	if err != nil {
+		glog.Fatalf("Failed to create config: %v", err)
+	}
+
+	prName := provisionerName
+	provName := os.Getenv(provisionerNameKey)
+
+	// Precedence is given for ProvisionerNameKey
+	if provName != "" {
+		prName = provName
+	}
+
+	prID := prName
+	if *id != "" {
+		prID = *id
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create client: %v", err)
+	}
+
+	// The controller needs to know what the server version is because out-of-tree
+	// provisioners aren't officially supported until 1.5
+	serverVersion, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		glog.Fatalf("Error getting server version: %v", err)
+	}
+
+	// Create the provisioner: it implements the Provisioner interface expected by
+	// the controller
+	glusterFSProvisioner := NewGlusterFSProvisioner(clientset, prID)
+
+	// Start the provision controller which will dynamically provision glusterfs
+	// PVs
+	pc := controller.NewProvisionController(
+		clientset,
+		prName,
+		glusterFSProvisioner,
+		serverVersion.GitVersion,
+	)
+
+	pc.Run(wait.NeverStop)
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	gapi "github.com/heketi/heketi/pkg/glusterfs/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestParseVolumeType(t *testing.T) {
+	tests := []struct {
+		volumeType  string
+		expectError bool
+		expected    gapi.VolumeDurabilityInfo
+	}{
+		{"", false, gapi.VolumeDurabilityInfo{Type: gapi.DurabilityReplicate, Replicate: gapi.ReplicaDurability{Replica: 3}}},
+		{"replicate:2", false, gapi.VolumeDurabilityInfo{Type: gapi.DurabilityReplicate, Replicate: gapi.ReplicaDurability{Replica: 2}}},
+		{"disperse:4:2", false, gapi.VolumeDurabilityInfo{Type: gapi.DurabilityEC, Disperse: gapi.DisperseDurability{Data: 4, Redundancy: 2}}},
+		{"none", false, gapi.VolumeDurabilityInfo{Type: gapi.DurabilityDistributeOnly}},
+		{"replicate", true, gapi.VolumeDurabilityInfo{}},
+		{"replicate:0", true, gapi.VolumeDurabilityInfo{}},
+		{"disperse:4", true, gapi.VolumeDurabilityInfo{}},
+		{"stripe:2", true, gapi.VolumeDurabilityInfo{}},
+	}
+	for _, test := range tests {
+		got, err := parseVolumeType(test.volumeType)
+		if test.expectError != (err != nil) {
+			t.Errorf("volumetype %q: expected error %v but got %v", test.volumeType, test.expectError, err)
+			continue
+		}
+		if !reflect.DeepEqual(test.expected, got) {
+			t.Errorf("volumetype %q: expected %+v but got %+v", test.volumeType, test.expected, got)
+		}
+	}
+}
+
+func TestParseClassParameters(t *testing.T) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "heketi-secret"},
+		Type:       v1.SecretType(provisionerName),
+		Data:       map[string][]byte{"key": []byte("password")},
+	}
+	client := fake.NewSimpleClientset(secret)
+
+	tests := []struct {
+		name        string
+		params      map[string]string
+		expectError bool
+		expectedKey string
+	}{
+		{
+			name:        "secret",
+			params:      map[string]string{"resturl": "http://heketi", "restuser": "admin", "restsecretnamespace": "default", "restsecretname": "heketi-secret"},
+			expectedKey: "password",
+		},
+		{
+			name:        "user key",
+			params:      map[string]string{"resturl": "http://heketi", "restuser": "admin", "restuserkey": "key"},
+			expectedKey: "key",
+		},
+		{
+			name:        "auth disabled",
+			params:      map[string]string{"resturl": "http://heketi", "restauthenabled": "false", "restuserkey": "key"},
+			expectedKey: "",
+		},
+		{
+			name:        "missing resturl",
+			params:      map[string]string{"restuser": "admin"},
+			expectError: true,
+		},
+		{
+			name:        "secret name without namespace",
+			params:      map[string]string{"resturl": "http://heketi", "restsecretname": "heketi-secret"},
+			expectError: true,
+		},
+		{
+			name:        "invalid option",
+			params:      map[string]string{"resturl": "http://heketi", "foo": "bar"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		cfg, err := parseClassParameters(test.params, client)
+		if test.expectError != (err != nil) {
+			t.Errorf("test case %s: expected error %v but got %v", test.name, test.expectError, err)
+			continue
+		}
+		if !test.expectError && cfg.restSecretValue != test.expectedKey {
+			t.Errorf("test case %s: expected key %q but got %q", test.name, test.expectedKey, cfg.restSecretValue)
+		}
+	}
+}
+
+func TestEndpointService(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	p := NewGlusterFSProvisioner(client, "id").(*glusterFSProvisioner)
+
+	if err := p.createEndpointService("ns", "glusterfs-dynamic-claim", []string{"10.0.0.1", "10.0.0.2"}, "claim"); err != nil {
+		t.Fatalf("Error creating endpoints: %v", err)
+	}
+	endpoints, err := client.Core().Endpoints("ns").Get("glusterfs-dynamic-claim", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting endpoints: %v", err)
+	}
+	expected := []v1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}}
+	if !reflect.DeepEqual(expected, endpoints.Subsets[0].Addresses) {
+		t.Errorf("expected addresses %v but got %v", expected, endpoints.Subsets[0].Addresses)
+	}
+	if _, err := client.Core().Services("ns").Get("glusterfs-dynamic-claim", metav1.GetOptions{}); err != nil {
+		t.Errorf("Error getting service: %v", err)
+	}
+
+	if err := p.deleteEndpointService("ns", "glusterfs-dynamic-claim"); err != nil {
+		t.Errorf("Error deleting endpoints: %v", err)
+	}
+	if _, err := client.Core().Endpoints("ns").Get("glusterfs-dynamic-claim", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected endpoints to be deleted")
+	}
+	// deleting again is a no-op
+	if err := p.deleteEndpointService("ns", "glusterfs-dynamic-claim"); err != nil {
+		t.Errorf("Error deleting deleted endpoints: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/helper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// A PV annotation for the name of the node whose hostPath provisioner
+	// provisioned it, the only one that can delete it
+	annProvisionerNode = "hostPathProvisionerNode"
+
+	// This annotation is added to a PVC by the scheduler when the PVC's
+	// StorageClass has volumeBindingMode WaitForFirstConsumer. Its value is
+	// the name of the node the first pod consuming the PVC has been
+	// scheduled to.
+	annSelectedNode = "volume.kubernetes.io/selected-node"
+
+	// The node label the PVs' node affinity selects the node by
+	hostnameLabel = "kubernetes.io/hostname"
+)
+
+// NewHostPathProvisioner creates a Provisioner that provisions hostPath PVs
+// backed by directories created in baseDir on the node named nodeName. The PVs
+// have node affinity to the node so that pods using them are scheduled to it.
+// hostname is the node's kubernetes.io/hostname label, usually its name.
+func NewHostPathProvisioner(baseDir, nodeName, hostname string) controller.Provisioner {
+	return &hostPathProvisioner{
+		baseDir:  baseDir,
+		nodeName: nodeName,
+		hostname: hostname,
+	}
+}
+
+type hostPathProvisioner struct {
+	// The directory to create PV-backing directories in. It must be mounted
+	// at the same path in the provisioner's container as on the node
+	baseDir string
+
+	// The name and kubernetes.io/hostname label of the node the provisioner
+	// is running on
+	nodeName string
+	hostname string
+}
+
+var _ controller.Provisioner = &hostPathProvisioner{}
+var _ controller.Qualifier = &hostPathProvisioner{}
+
+// ShouldProvision returns false for claims that the scheduler has selected
+// another node for, so that only the provisioner on the selected node
+// provisions them.
+func (p *hostPathProvisioner) ShouldProvision(claim *v1.PersistentVolumeClaim) bool {
+	selectedNode, found := claim.Annotations[annSelectedNode]
+	return !found || selectedNode == p.nodeName
+}
+
+// Provision creates a volume i.e. the storage asset and returns a PV object for
+// the volume.
+func (p *hostPathProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+
+	directory := path.Join(p.baseDir, options.PVName)
+	if _, err := os.Stat(directory); !os.IsNotExist(err) {
+		return nil, fmt.Errorf("the path %s already exists", directory)
+	}
+	if err := os.MkdirAll(directory, 0777); err != nil {
+		return nil, fmt.Errorf("error creating directory %s: %v", directory, err)
+	}
+	// Due to umask, need to chmod
+	if err := os.Chmod(directory, 0777); err != nil {
+		os.RemoveAll(directory)
+		return nil, fmt.Errorf("error setting permissions of directory %s: %v", directory, err)
+	}
+
+	annotations := map[string]string{annProvisionerNode: p.nodeName}
+	affinity := &v1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+			NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{
+					MatchExpressions: []v1.NodeSelectorRequirement{
+						{
+							Key:      hostnameLabel,
+							Operator: v1.NodeSelectorOpIn,
+							Values:   []string{p.hostname},
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := helper.StorageNodeAffinityToAlphaAnnotation(annotations, affinity); err != nil {
+		os.RemoveAll(directory)
+		return nil, fmt.Errorf("error converting node affinity to alpha annotation: %v", err)
+	}
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        options.PVName,
+			Annotations: annotations,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				HostPath: &v1.HostPathVolumeSource{
+					Path: directory,
+				},
+			},
+		},
+	}
+
+	glog.Infof("Created directory %s for volume %q on node %s", directory, options.PVName, p.nodeName)
+	return pv, nil
+}
+
+// Delete removes the directory that was created by Provision backing the given
+// PV, if it was created on this provisioner's node.
+func (p *hostPathProvisioner) Delete(volume *v1.PersistentVolume) error {
+	node, ok := volume.Annotations[annProvisionerNode]
+	if !ok {
+		return fmt.Errorf("PV doesn't have an annotation %s", annProvisionerNode)
+	}
+	if node != p.nodeName {
+		return &controller.IgnoredError{Reason: fmt.Sprintf("volume %q is on node %s, not this provisioner's node %s", volume.Name, node, p.nodeName)}
+	}
+	if volume.Spec.HostPath == nil {
+		return fmt.Errorf("PV isn't a hostPath volume")
+	}
+
+	directory := volume.Spec.HostPath.Path
+	if path.Dir(directory) != path.Clean(p.baseDir) {
+		return fmt.Errorf("path %s isn't in base directory %s, refusing to delete it", directory, p.baseDir)
+	}
+	if err := os.RemoveAll(directory); err != nil {
+		return fmt.Errorf("error removing directory %s: %v", directory, err)
+	}
+
+	return nil
+}
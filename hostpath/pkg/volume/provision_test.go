@@ -0,0 +1,124 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/helper"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	utiltesting "k8s.io/client-go/util/testing"
+)
+
+func TestShouldProvision(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    bool
+	}{
+		{
+			name:        "no selected node",
+			annotations: map[string]string{},
+			expected:    true,
+		},
+		{
+			name:        "this node selected",
+			annotations: map[string]string{annSelectedNode: "node-1"},
+			expected:    true,
+		},
+		{
+			name:        "other node selected",
+			annotations: map[string]string{annSelectedNode: "node-2"},
+			expected:    false,
+		},
+	}
+	p := NewHostPathProvisioner("/tmp", "node-1", "node-1").(*hostPathProvisioner)
+	for _, test := range tests {
+		claim := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations}}
+		if got := p.ShouldProvision(claim); got != test.expected {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected should provision %v but got %v", test.expected, got)
+		}
+	}
+}
+
+func TestProvisionDelete(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("hostPathProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	p := NewHostPathProvisioner(tmpDir, "node-1", "host-1")
+	options := controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        "pvc-1",
+		PVC: &v1.PersistentVolumeClaim{
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Mi"),
+					},
+				},
+			},
+		},
+	}
+
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	directory := path.Join(tmpDir, "pvc-1")
+	if pv.Spec.HostPath == nil || pv.Spec.HostPath.Path != directory {
+		t.Errorf("expected hostPath %s but got %+v", directory, pv.Spec.PersistentVolumeSource)
+	}
+	if fi, err := os.Stat(directory); err != nil || fi.Mode().Perm() != 0777 {
+		t.Errorf("expected directory %s with permissions 0777 but got %v, %v", directory, fi, err)
+	}
+	affinity, err := helper.GetStorageNodeAffinityFromAnnotation(pv.Annotations)
+	if err != nil {
+		t.Errorf("Error getting node affinity: %v", err)
+	} else {
+		expected := []v1.NodeSelectorRequirement{{Key: hostnameLabel, Operator: v1.NodeSelectorOpIn, Values: []string{"host-1"}}}
+		got := affinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("expected node affinity %v but got %v", expected, got)
+		}
+	}
+
+	if _, err := p.Provision(options); err == nil {
+		t.Errorf("expected error provisioning existing volume but got none")
+	}
+
+	other := NewHostPathProvisioner(tmpDir, "node-2", "host-2")
+	if err := other.Delete(pv); err == nil {
+		t.Errorf("expected error deleting volume of another node but got none")
+	} else if _, ok := err.(*controller.IgnoredError); !ok {
+		t.Errorf("expected IgnoredError deleting volume of another node but got %v", err)
+	}
+
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if _, err := os.Stat(directory); !os.IsNotExist(err) {
+		t.Errorf("expected directory %s to be removed but got %v", directory, err)
+	}
+}
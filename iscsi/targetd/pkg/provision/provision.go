@@ -0,0 +1,293 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	provisionerIDAnn = "iscsiProvisionerIdentity"
+	poolAnn          = "iscsiProvisionerPool"
+
+	// maxLun is the highest LUN LIO supports per initiator
+	maxLun = 255
+)
+
+type iscsiProvisionOptions struct {
+	targetPortal      string
+	portals           []string
+	iqn               string
+	iscsiInterface    string
+	initiators        []string
+	pool              string
+	fsType            string
+	chapAuthDiscovery bool
+	chapAuthSession   bool
+	chapSecretName    string
+}
+
+type iscsiProvisioner struct {
+	// Kubernetes Client. Use to check the CHAP secrets exist
+	client kubernetes.Interface
+	// Identity of this iscsiProvisioner. Used to identify "this"
+	// provisioner's PVs.
+	identity string
+	targetd  targetd
+
+	// mutex serializes picking a LUN and exporting at it
+	mutex sync.Mutex
+}
+
+// NewISCSIProvisioner creates a new iscsi provisioner that provisions volumes
+// with the targetd API at the given URL.
+func NewISCSIProvisioner(client kubernetes.Interface, id, targetdURL, targetdUsername, targetdPassword string) controller.Provisioner {
+	return &iscsiProvisioner{
+		client:   client,
+		identity: id,
+		targetd:  newTargetdClient(targetdURL, targetdUsername, targetdPassword),
+	}
+}
+
+var _ controller.Provisioner = &iscsiProvisioner{}
+
+// Provision creates a logical volume in the class's pool, exports it as a LUN
+// to the class's initiators, and returns a PV object representing it.
+func (p *iscsiProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	opts, err := parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	// the CHAP secret is referenced by the PV, so it must be in the claim's
+	// namespace
+	if opts.chapSecretName != "" {
+		if _, err := p.client.Core().Secrets(options.PVC.Namespace).Get(opts.chapSecretName, metav1.GetOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to get CHAP secret %q in namespace %q: %v", opts.chapSecretName, options.PVC.Namespace, err)
+		}
+	}
+
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	lun, err := p.createVolume(options.PVName, capacity.Value(), opts)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("successfully created volume %q in pool %q exported at lun %d", options.PVName, opts.pool, lun)
+
+	source := &v1.ISCSIVolumeSource{
+		TargetPortal:      opts.targetPortal,
+		Portals:           opts.portals,
+		IQN:               opts.iqn,
+		ISCSIInterface:    opts.iscsiInterface,
+		Lun:               lun,
+		FSType:            opts.fsType,
+		ReadOnly:          false,
+		DiscoveryCHAPAuth: opts.chapAuthDiscovery,
+		SessionCHAPAuth:   opts.chapAuthSession,
+	}
+	if opts.chapSecretName != "" {
+		source.SecretRef = &v1.LocalObjectReference{Name: opts.chapSecretName}
+	}
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				provisionerIDAnn: p.identity,
+				poolAnn:          opts.pool,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): capacity,
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				ISCSI: source,
+			},
+		},
+	}
+
+	return pv, nil
+}
+
+// createVolume creates the volume and exports it to all the initiators at the
+// lowest LUN none of them uses yet.
+func (p *iscsiProvisioner) createVolume(name string, size int64, opts *iscsiProvisionOptions) (int32, error) {
+	if err := p.targetd.VolumeCreate(opts.pool, name, size); err != nil {
+		return 0, fmt.Errorf("error creating volume %q: %v", name, err)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	exports, err := p.targetd.ExportList()
+	if err != nil {
+		p.targetd.VolumeDestroy(opts.pool, name)
+		return 0, fmt.Errorf("error listing exports: %v", err)
+	}
+	lun, err := getFreeLun(exports, opts.initiators)
+	if err != nil {
+		p.targetd.VolumeDestroy(opts.pool, name)
+		return 0, err
+	}
+
+	for i, initiator := range opts.initiators {
+		if err := p.targetd.ExportCreate(opts.pool, name, initiator, lun); err != nil {
+			for _, exported := range opts.initiators[:i] {
+				p.targetd.ExportDestroy(opts.pool, name, exported)
+			}
+			p.targetd.VolumeDestroy(opts.pool, name)
+			return 0, fmt.Errorf("error exporting volume %q to initiator %q: %v", name, initiator, err)
+		}
+	}
+
+	return lun, nil
+}
+
+// getFreeLun returns the lowest LUN that isn't exported to any of the given
+// initiators.
+func getFreeLun(exports []export, initiators []string) (int32, error) {
+	isInitiator := map[string]bool{}
+	for _, initiator := range initiators {
+		isInitiator[initiator] = true
+	}
+	used := map[int32]bool{}
+	for _, e := range exports {
+		if isInitiator[e.InitiatorWwn] {
+			used[e.Lun] = true
+		}
+	}
+	for lun := int32(0); lun <= maxLun; lun++ {
+		if !used[lun] {
+			return lun, nil
+		}
+	}
+	return 0, fmt.Errorf("no free lun left for initiators %v", initiators)
+}
+
+// Delete removes the exports and the volume that were created by Provision
+// represented by the given PV.
+func (p *iscsiProvisioner) Delete(volume *v1.PersistentVolume) error {
+	ann, ok := volume.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != p.identity {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	pool, ok := volume.Annotations[poolAnn]
+	if !ok {
+		return errors.New("pool annotation not found on PV")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	exports, err := p.targetd.ExportList()
+	if err != nil {
+		return fmt.Errorf("error listing exports: %v", err)
+	}
+	for _, e := range exports {
+		if e.Pool != pool || e.VolName != volume.Name {
+			continue
+		}
+		if err := p.targetd.ExportDestroy(pool, volume.Name, e.InitiatorWwn); err != nil {
+			return fmt.Errorf("error removing export of volume %q to initiator %q: %v", volume.Name, e.InitiatorWwn, err)
+		}
+	}
+
+	if err := p.targetd.VolumeDestroy(pool, volume.Name); err != nil {
+		return fmt.Errorf("removed exports but error destroying volume %q: %v", volume.Name, err)
+	}
+	return nil
+}
+
+func parseParameters(parameters map[string]string) (*iscsiProvisionOptions, error) {
+	opts := &iscsiProvisionOptions{
+		pool:   "vg-targetd",
+		fsType: "ext4",
+	}
+	var err error
+
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "targetportal":
+			opts.targetPortal = v
+		case "portals":
+			for _, portal := range strings.Split(v, ",") {
+				if portal = strings.TrimSpace(portal); portal != "" {
+					opts.portals = append(opts.portals, portal)
+				}
+			}
+		case "iqn":
+			opts.iqn = v
+		case "iscsiinterface":
+			opts.iscsiInterface = v
+		case "initiators":
+			for _, initiator := range strings.Split(v, ",") {
+				if initiator = strings.TrimSpace(initiator); initiator != "" {
+					opts.initiators = append(opts.initiators, initiator)
+				}
+			}
+		case "volumegroup", "pool":
+			opts.pool = v
+		case "fstype":
+			opts.fsType = v
+		case "chapauthdiscovery":
+			if opts.chapAuthDiscovery, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("invalid chapAuthDiscovery %q: %v", v, err)
+			}
+		case "chapauthsession":
+			if opts.chapAuthSession, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("invalid chapAuthSession %q: %v", v, err)
+			}
+		case "chapsecretname":
+			opts.chapSecretName = v
+		default:
+			return nil, fmt.Errorf("invalid option %q", k)
+		}
+	}
+	// sanity check
+	if opts.targetPortal == "" {
+		return nil, fmt.Errorf("missing targetPortal")
+	}
+	if opts.iqn == "" {
+		return nil, fmt.Errorf("missing iqn")
+	}
+	if len(opts.initiators) == 0 {
+		return nil, fmt.Errorf("missing initiators")
+	}
+	if (opts.chapAuthDiscovery || opts.chapAuthSession) && opts.chapSecretName == "" {
+		return nil, fmt.Errorf("chapSecretName is required if chapAuthDiscovery or chapAuthSession is true")
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// fakeTargetd serves the targetd API, keeping volumes and exports in memory.
+type fakeTargetd struct {
+	volumes map[string]int64
+	exports []export
+}
+
+func (f *fakeTargetd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if username, password, ok := r.BasicAuth(); !ok || username != "admin" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		ID     int                    `json:"id"`
+		Method string                 `json:"method"`
+		Params map[string]interface{} `json:"params"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": nil}
+	name := func(key string) string { s, _ := req.Params[key].(string); return s }
+	switch req.Method {
+	case "vol_create":
+		f.volumes[name("pool")+"/"+name("name")] = int64(req.Params["size"].(float64))
+	case "vol_destroy":
+		delete(f.volumes, name("pool")+"/"+name("name"))
+	case "export_create":
+		f.exports = append(f.exports, export{InitiatorWwn: name("initiator_wwn"), Lun: int32(req.Params["lun"].(float64)), VolName: name("vol"), Pool: name("pool")})
+	case "export_destroy":
+		for i, e := range f.exports {
+			if e.Pool == name("pool") && e.VolName == name("vol") && e.InitiatorWwn == name("initiator_wwn") {
+				f.exports = append(f.exports[:i], f.exports[i+1:]...)
+				break
+			}
+		}
+	case "export_list":
+		resp["result"] = f.exports
+	default:
+		resp["error"] = map[string]interface{}{"code": -32601, "message": "method not found"}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestGetFreeLun(t *testing.T) {
+	exports := []export{
+		{InitiatorWwn: "iqn.a", Lun: 0},
+		{InitiatorWwn: "iqn.a", Lun: 1},
+		{InitiatorWwn: "iqn.b", Lun: 2},
+		{InitiatorWwn: "iqn.c", Lun: 3},
+	}
+	tests := []struct {
+		initiators []string
+		expected   int32
+	}{
+		{[]string{"iqn.a"}, 2},
+		{[]string{"iqn.b"}, 0},
+		{[]string{"iqn.a", "iqn.b"}, 3},
+		{[]string{"iqn.a", "iqn.b", "iqn.c"}, 4},
+		{[]string{"iqn.d"}, 0},
+	}
+	for _, test := range tests {
+		lun, err := getFreeLun(exports, test.initiators)
+		if err != nil || lun != test.expected {
+			t.Errorf("initiators %v: expected lun %d but got %d, %v", test.initiators, test.expected, lun, err)
+		}
+	}
+
+	var full []export
+	for lun := int32(0); lun <= maxLun; lun++ {
+		full = append(full, export{InitiatorWwn: "iqn.a", Lun: lun})
+	}
+	if _, err := getFreeLun(full, []string{"iqn.a"}); err == nil {
+		t.Errorf("expected error getting lun with all luns used but got none")
+	}
+}
+
+func TestParseParameters(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		expectError bool
+		expected    *iscsiProvisionOptions
+	}{
+		{
+			name: "defaults",
+			parameters: map[string]string{
+				"targetPortal": "192.168.1.10:3260",
+				"iqn":          "iqn.2003-01.org.linux-iscsi.target:targetd",
+				"initiators":   "iqn.node1, iqn.node2",
+			},
+			expected: &iscsiProvisionOptions{
+				targetPortal: "192.168.1.10:3260",
+				iqn:          "iqn.2003-01.org.linux-iscsi.target:targetd",
+				initiators:   []string{"iqn.node1", "iqn.node2"},
+				pool:         "vg-targetd",
+				fsType:       "ext4",
+			},
+		},
+		{
+			name: "chap",
+			parameters: map[string]string{
+				"targetPortal":    "192.168.1.10:3260",
+				"portals":         "192.168.1.11:3260,192.168.1.12:3260",
+				"iqn":             "iqn.target",
+				"initiators":      "iqn.node1",
+				"volumeGroup":     "vg",
+				"chapAuthSession": "true",
+				"chapSecretName":  "chap-secret",
+			},
+			expected: &iscsiProvisionOptions{
+				targetPortal:    "192.168.1.10:3260",
+				portals:         []string{"192.168.1.11:3260", "192.168.1.12:3260"},
+				iqn:             "iqn.target",
+				initiators:      []string{"iqn.node1"},
+				pool:            "vg",
+				fsType:          "ext4",
+				chapAuthSession: true,
+				chapSecretName:  "chap-secret",
+			},
+		},
+		{
+			name:        "missing initiators",
+			parameters:  map[string]string{"targetPortal": "192.168.1.10:3260", "iqn": "iqn.target"},
+			expectError: true,
+		},
+		{
+			name:        "chap without secret",
+			parameters:  map[string]string{"targetPortal": "192.168.1.10:3260", "iqn": "iqn.target", "initiators": "iqn.node1", "chapAuthDiscovery": "true"},
+			expectError: true,
+		},
+		{
+			name:        "invalid option",
+			parameters:  map[string]string{"targetPortal": "192.168.1.10:3260", "iqn": "iqn.target", "initiators": "iqn.node1", "foo": "bar"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		opts, err := parseParameters(test.parameters)
+		if test.expectError != (err != nil) {
+			t.Errorf("test case %s: expected error %v but got %v", test.name, test.expectError, err)
+			continue
+		}
+		if !test.expectError && !reflect.DeepEqual(test.expected, opts) {
+			t.Errorf("test case %s: expected options %+v but got %+v", test.name, test.expected, opts)
+		}
+	}
+}
+
+func TestProvisionDelete(t *testing.T) {
+	fakeTd := &fakeTargetd{volumes: map[string]int64{}, exports: []export{{InitiatorWwn: "iqn.node1", Lun: 0, VolName: "other", Pool: "vg"}}}
+	server := httptest.NewServer(fakeTd)
+	defer server.Close()
+
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "chap-secret"}}
+	p := NewISCSIProvisioner(fake.NewSimpleClientset(secret), "id", server.URL, "admin", "secret")
+
+	options := controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        "pvc-1",
+		Parameters: map[string]string{
+			"targetPortal":    "192.168.1.10:3260",
+			"iqn":             "iqn.target",
+			"initiators":      "iqn.node1,iqn.node2",
+			"volumeGroup":     "vg",
+			"chapAuthSession": "true",
+			"chapSecretName":  "chap-secret",
+		},
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "claim"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Gi"),
+					},
+				},
+			},
+		},
+	}
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	if size := fakeTd.volumes["vg/pvc-1"]; size != 1024*1024*1024 {
+		t.Errorf("expected volume vg/pvc-1 of size 1Gi but got %d", size)
+	}
+	if pv.Spec.ISCSI.Lun != 1 || pv.Spec.ISCSI.SecretRef == nil || pv.Spec.ISCSI.SecretRef.Name != "chap-secret" || !pv.Spec.ISCSI.SessionCHAPAuth {
+		t.Errorf("unexpected iscsi volume source %+v", pv.Spec.ISCSI)
+	}
+	if len(fakeTd.exports) != 3 {
+		t.Errorf("expected 3 exports but got %v", fakeTd.exports)
+	}
+
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if _, ok := fakeTd.volumes["vg/pvc-1"]; ok {
+		t.Errorf("expected volume vg/pvc-1 to be destroyed")
+	}
+	expected := []export{{InitiatorWwn: "iqn.node1", Lun: 0, VolName: "other", Pool: "vg"}}
+	if !reflect.DeepEqual(expected, fakeTd.exports) {
+		t.Errorf("expected exports %v but got %v", expected, fakeTd.exports)
+	}
+
+	options.PVC.Namespace = "other"
+	if _, err := p.Provision(options); err == nil {
+		t.Errorf("expected error provisioning without CHAP secret in claim namespace but got none")
+	}
+
+	unauthorized := NewISCSIProvisioner(fake.NewSimpleClientset(secret), "id", server.URL, "admin", "wrong")
+	options.PVC.Namespace = "ns"
+	if _, err := unauthorized.Provision(options); err == nil {
+		t.Errorf("expected error provisioning with wrong targetd password but got none")
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// export is an entry of targetd's export_list.
+type export struct {
+	InitiatorWwn string `json:"initiator_wwn"`
+	Lun          int32  `json:"lun"`
+	VolName      string `json:"vol_name"`
+	Pool         string `json:"pool"`
+}
+
+// targetd is the subset of the targetd API the provisioner uses.
+type targetd interface {
+	VolumeCreate(pool, name string, size int64) error
+	VolumeDestroy(pool, name string) error
+	ExportCreate(pool, vol, initiatorWwn string, lun int32) error
+	ExportDestroy(pool, vol, initiatorWwn string) error
+	ExportList() ([]export, error)
+}
+
+// targetdClient calls targetd's JSON-RPC 2.0 API over HTTP.
+type targetdClient struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+
+	mutex sync.Mutex
+	id    int
+}
+
+// newTargetdClient creates a client of the targetd API at the given URL, e.g.
+// http://192.168.1.10:18700/targetrpc.
+func newTargetdClient(url, username, password string) *targetdClient {
+	return &targetdClient{
+		url:      url,
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+var _ targetd = &targetdClient{}
+
+type rpcRequest struct {
+	Version string      `json:"jsonrpc"`
+	ID      int         `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	ID     int              `json:"id"`
+	Result *json.RawMessage `json:"result"`
+	Error  *rpcError        `json:"error"`
+}
+
+func (c *targetdClient) call(method string, params interface{}, result interface{}) error {
+	c.mutex.Lock()
+	c.id++
+	id := c.id
+	c.mutex.Unlock()
+
+	body, err := json.Marshal(rpcRequest{Version: "2.0", ID: id, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(c.username, c.password)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling targetd %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error calling targetd %s: %s", method, resp.Status)
+	}
+
+	var rpcResp rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return fmt.Errorf("error decoding targetd %s response: %v", method, err)
+	}
+	if rpcResp.Error != nil {
+		return fmt.Errorf("targetd %s failed: %s (code %d)", method, rpcResp.Error.Message, rpcResp.Error.Code)
+	}
+	if result != nil && rpcResp.Result != nil {
+		return json.Unmarshal(*rpcResp.Result, result)
+	}
+	return nil
+}
+
+func (c *targetdClient) VolumeCreate(pool, name string, size int64) error {
+	return c.call("vol_create", map[string]interface{}{"pool": pool, "name": name, "size": size}, nil)
+}
+
+func (c *targetdClient) VolumeDestroy(pool, name string) error {
+	return c.call("vol_destroy", map[string]interface{}{"pool": pool, "name": name}, nil)
+}
+
+func (c *targetdClient) ExportCreate(pool, vol, initiatorWwn string, lun int32) error {
+	return c.call("export_create", map[string]interface{}{"pool": pool, "vol": vol, "initiator_wwn": initiatorWwn, "lun": lun}, nil)
+}
+
+func (c *targetdClient) ExportDestroy(pool, vol, initiatorWwn string) error {
+	return c.call("export_destroy", map[string]interface{}{"pool": pool, "vol": vol, "initiator_wwn": initiatorWwn}, nil)
+}
+
+func (c *targetdClient) ExportList() ([]export, error) {
+	var exports []export
+	if err := c.call("export_list", nil, &exports); err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
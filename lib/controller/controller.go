@@ -17,6 +17,7 @@ limitations under the License.
 package controller
 
 import (
+	"encoding/json"
 	"fmt"
 	"os/exec"
 	"reflect"
@@ -28,7 +29,9 @@ import (
 	"github.com/kubernetes-incubator/external-storage/lib/helper"
 	"github.com/kubernetes-incubator/external-storage/lib/leaderelection"
 	rl "github.com/kubernetes-incubator/external-storage/lib/leaderelection/resourcelock"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/uuid"
@@ -64,6 +67,20 @@ const annDynamicallyProvisioned = "pv.kubernetes.io/provisioned-by"
 
 const annStorageProvisioner = "volume.beta.kubernetes.io/storage-provisioner"
 
+// This annotation is added to a PVC by the scheduler when the PVC's
+// StorageClass has volumeBindingMode WaitForFirstConsumer. Its value is the
+// name of the node the first pod consuming the PVC has been scheduled to.
+const annSelectedNode = "volume.kubernetes.io/selected-node"
+
+// volumeBindingWaitForFirstConsumer is the StorageClass volumeBindingMode
+// that delays provisioning until a pod using the PVC is scheduled.
+const volumeBindingWaitForFirstConsumer = "WaitForFirstConsumer"
+
+// provisionedVolumeTimeout is how long a provisioned volume keeps counting
+// against maxVolumesPerNamespace among the volumes being provisioned if the
+// volumes cache doesn't get it, e.g. because it was deleted in the meantime.
+const provisionedVolumeTimeout = 5 * time.Minute
+
 // ProvisionController is a controller that provisions PersistentVolumes for
 // PersistentVolumeClaims.
 type ProvisionController struct {
@@ -93,7 +110,7 @@ type ProvisionController struct {
 	volumeSource     cache.ListerWatcher
 	volumeController cache.Controller
 	classSource      cache.ListerWatcher
-	classReflector   *cache.Reflector
+	classController  cache.Controller
 
 	volumes cache.Store
 	claims  cache.Store
@@ -119,15 +136,63 @@ type ProvisionController struct {
 	failedProvisionStats, failedDeleteStats           map[types.UID]int
 	failedProvisionStatsMutex, failedDeleteStatsMutex *sync.Mutex
 
+	// Set of volumes whose storage has been deleted but whose PVs haven't yet
+	// gone, e.g. held by the kubernetes.io/pv-protection finalizer, so that
+	// their storage isn't deleted again every resync meanwhile
+	deletedVolumes      map[types.UID]bool
+	deletedVolumesMutex *sync.Mutex
+
+	// Map of class name to the fields of the class that must be fetched raw,
+	// so that they are fetched once per version of the class rather than for
+	// every claim on every pass
+	rawClasses      map[string]*rawStorageClass
+	rawClassesMutex *sync.Mutex
+
 	// Parameters of leaderelection.LeaderElectionConfig. Leader election is for
 	// when multiple controllers are running: they race to lock (lead) every PVC
 	// so that only one calls Provision for it (saving API calls, CPU cycles...)
 	leaseDuration, renewDeadline, retryPeriod, termLimit time.Duration
+	// Whether to lock claims with an annotation alongside their Leases
+	claimLock bool
 	// Map of claim UID to LeaderElector: for checking if this controller
 	// is the leader of a given claim
 	leaderElectors      map[types.UID]*leaderelection.LeaderElector
 	leaderElectorsMutex *sync.Mutex
 
+	// Semaphore bounding the number of operations running at once, nil if
+	// unbounded
+	operationSlots chan struct{}
+	// Semaphore bounding the number of Delete operations running at once
+	// apart from operationSlots, nil if they share operationSlots
+	deleteSlots chan struct{}
+
+	// How often to record an event for an operation failing over and over,
+	// 0 for every failure, and the streaks of failures being aggregated by
+	// object UID and reason
+	failureEventInterval time.Duration
+	failureStreaks       map[string]*failureStreak
+	failureStreaksMutex  *sync.Mutex
+
+	// Reclaim policy of volumes whose StorageClass doesn't declare one
+	reclaimPolicy v1.PersistentVolumeReclaimPolicy
+
+	// Sets of the namespaces whose claims to provision for, nil for all, and
+	// whose claims not to
+	watchNamespaces, denyNamespaces map[string]bool
+
+	// Selector of the labels of the claims to provision for, nil for all
+	claimSelector labels.Selector
+
+	// Set of the StorageClasses whose claims to provision for, nil for all
+	storageClasses map[string]bool
+
+	// Maximum number of volumes to provision for the claims of a namespace, 0
+	// for no limit, and the claims' namespaces of the volumes being
+	// provisioned, or provisioned but not yet in the volumes cache, by name
+	maxVolumesPerNamespace  int
+	provisioningVolumes     map[string]string
+	provisioningVolumesLock *sync.Mutex
+
 	hasRun     bool
 	hasRunLock *sync.Mutex
 }
@@ -153,6 +218,14 @@ const (
 	DefaultRetryPeriod = 2 * time.Second
 	// DefaultTermLimit is used when option function TermLimit is omitted
 	DefaultTermLimit = 30 * time.Second
+	// DefaultClaimLock is used when option function ClaimLock is omitted
+	DefaultClaimLock = true
+	// DefaultThreadiness is used when option function Threadiness is omitted
+	DefaultThreadiness = 0
+	// DefaultReclaimPolicy is used when option function ReclaimPolicy is omitted
+	DefaultReclaimPolicy = v1.PersistentVolumeReclaimDelete
+	// DefaultFailureEventInterval is used when option function FailureEventInterval is omitted
+	DefaultFailureEventInterval = 10 * time.Minute
 )
 
 var errRuntime = fmt.Errorf("cannot call option functions after controller has Run")
@@ -275,7 +348,191 @@ func TermLimit(termLimit time.Duration) func(*ProvisionController) error {
 	}
 }
 
-// NewProvisionController creates a new provision controller
+// ClaimLock is whether to also lock a claim to provision for with an
+// annotation on it where it's locked with a Lease, i.e. on 1.14+ servers, so
+// that controllers of older versions, which only lock the claim, don't race
+// this one for it. Set it false once none of them remain. Defaults to true.
+func ClaimLock(claimLock bool) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.claimLock = claimLock
+		return nil
+	}
+}
+
+// Threadiness is the maximum number of Provision and Delete operations, plus
+// the leader election that precedes provisioning, to run at once. 0 for no
+// limit. Defaults to 0.
+func Threadiness(threadiness int) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		if threadiness < 0 {
+			return fmt.Errorf("threadiness must be non-negative")
+		}
+		c.operationSlots = nil
+		if threadiness > 0 {
+			c.operationSlots = make(chan struct{}, threadiness)
+		}
+		return nil
+	}
+}
+
+// DeleteThreadiness is the maximum number of Delete operations to run at once,
+// apart from those counted by Threadiness, so that e.g. tearing down a
+// namespace's volumes neither waits for nor holds up provisioning. 0 for
+// Delete operations to be counted by Threadiness. Defaults to 0.
+func DeleteThreadiness(threadiness int) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		if threadiness < 0 {
+			return fmt.Errorf("delete threadiness must be non-negative")
+		}
+		c.deleteSlots = nil
+		if threadiness > 0 {
+			c.deleteSlots = make(chan struct{}, threadiness)
+		}
+		return nil
+	}
+}
+
+// ReclaimPolicy is the reclaim policy, Delete or Retain, of the volumes
+// provisioned for claims whose StorageClass doesn't declare a reclaimPolicy,
+// i.e. against Kubernetes < 1.8. Otherwise volumes get the class's. Defaults
+// to Delete.
+func ReclaimPolicy(reclaimPolicy v1.PersistentVolumeReclaimPolicy) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		if reclaimPolicy != v1.PersistentVolumeReclaimDelete && reclaimPolicy != v1.PersistentVolumeReclaimRetain {
+			return fmt.Errorf("reclaim policy must be %s or %s", v1.PersistentVolumeReclaimDelete, v1.PersistentVolumeReclaimRetain)
+		}
+		c.reclaimPolicy = reclaimPolicy
+		return nil
+	}
+}
+
+// WatchNamespaces restricts the controller to provisioning, expanding and
+// deleting volumes for claims in the given namespaces, e.g. to run one
+// controller per group of tenants. If it is a single namespace, only claims in
+// it are watched. Defaults to all namespaces.
+func WatchNamespaces(namespaces []string) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.watchNamespaces = namespaceSet(namespaces)
+		return nil
+	}
+}
+
+// DenyNamespaces stops the controller from provisioning, expanding and
+// deleting volumes for claims in the given namespaces, even if they are among
+// WatchNamespaces. Defaults to none.
+func DenyNamespaces(namespaces []string) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.denyNamespaces = namespaceSet(namespaces)
+		return nil
+	}
+}
+
+// ClaimSelector restricts the controller to provisioning and expanding volumes
+// for claims whose labels match the given selector, e.g. to roll out a new
+// version of a provisioner to some claims of a StorageClass before the rest.
+// Only matching claims are watched. Defaults to all claims.
+func ClaimSelector(selector labels.Selector) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		if selector != nil && selector.Empty() {
+			selector = nil
+		}
+		c.claimSelector = selector
+		return nil
+	}
+}
+
+// StorageClasses restricts the controller to provisioning, expanding and
+// deleting volumes of the given StorageClasses, e.g. to divide the classes of
+// one provisioner name between several controllers serving different disk
+// tiers. Defaults to all classes.
+func StorageClasses(classes []string) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.storageClasses = namespaceSet(classes)
+		return nil
+	}
+}
+
+// MaxVolumesPerNamespace is the maximum number of volumes the controller
+// provisions for the claims of each namespace, as a guard against e.g. an
+// operator creating claims in a loop. Claims beyond it get a warning event
+// and are provisioned for once volumes of the namespace are deleted. Defaults
+// to 0, no limit.
+func MaxVolumesPerNamespace(maxVolumesPerNamespace int) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.maxVolumesPerNamespace = maxVolumesPerNamespace
+		return nil
+	}
+}
+
+func namespaceSet(namespaces []string) map[string]bool {
+	if len(namespaces) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		set[namespace] = true
+	}
+	return set
+}
+
+// FailureEventInterval is how often to record an event for an operation that
+// fails over and over, e.g. provisioning for a claim: the first failure is
+// recorded as is, then one event per interval at most, saying how many times it
+// failed since and the last error. 0 records an event for every failure.
+// Defaults to 10 minutes.
+func FailureEventInterval(failureEventInterval time.Duration) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.failureEventInterval = failureEventInterval
+		return nil
+	}
+}
+
+// EventRecorder is the recorder the controller records events on claims and
+// volumes with, e.g. to record them under a different component or to drop
+// them. Defaults to a recorder that sends events to the API server under the
+// component "<provisionerName> <hostname> <identity>".
+func EventRecorder(eventRecorder record.EventRecorder) func(*ProvisionController) error {
+	return func(c *ProvisionController) error {
+		if c.HasRun() {
+			return errRuntime
+		}
+		c.eventRecorder = eventRecorder
+		return nil
+	}
+}
+
+// NewProvisionController creates a new provision controller. It exits if any
+// of the options is invalid.
 func NewProvisionController(
 	client kubernetes.Interface,
 	provisionerName string,
@@ -284,15 +541,22 @@ func NewProvisionController(
 	options ...func(*ProvisionController) error,
 ) *ProvisionController {
 	identity := uuid.NewUUID()
+	version := utilversion.MustParseSemantic(kubeVersion)
+	instance := string(identity)
+	if out, err := exec.Command("hostname").Output(); err == nil {
+		instance = fmt.Sprintf("%s %s", strings.TrimSpace(string(out)), string(identity))
+	}
 	broadcaster := record.NewBroadcaster()
-	broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: client.Core().Events(v1.NamespaceAll)})
-	var eventRecorder record.EventRecorder
-	out, err := exec.Command("hostname").Output()
-	if err != nil {
-		eventRecorder = broadcaster.NewRecorder(api.Scheme, v1.EventSource{Component: fmt.Sprintf("%s %s", provisionerName, string(identity))})
+	if version.AtLeast(utilversion.MustParseSemantic("v1.19.0")) {
+		broadcaster.StartRecordingToSink(&eventsV1Sink{
+			client:              client,
+			reportingController: provisionerName,
+			reportingInstance:   instance,
+		})
 	} else {
-		eventRecorder = broadcaster.NewRecorder(api.Scheme, v1.EventSource{Component: fmt.Sprintf("%s %s %s", provisionerName, strings.TrimSpace(string(out)), string(identity))})
+		broadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: client.Core().Events(v1.NamespaceAll)})
 	}
+	eventRecorder := broadcaster.NewRecorder(api.Scheme, v1.EventSource{Component: fmt.Sprintf("%s %s", provisionerName, instance)})
 
 	// TODO: GetReference fails otherwise
 	v1.AddToScheme(api.Scheme)
@@ -301,7 +565,7 @@ func NewProvisionController(
 		client:                        client,
 		provisionerName:               provisionerName,
 		provisioner:                   provisioner,
-		kubeVersion:                   utilversion.MustParseSemantic(kubeVersion),
+		kubeVersion:                   version,
 		identity:                      identity,
 		eventRecorder:                 eventRecorder,
 		resyncPeriod:                  DefaultResyncPeriod,
@@ -314,26 +578,51 @@ func NewProvisionController(
 		failedDeleteStats:             make(map[types.UID]int),
 		failedProvisionStatsMutex:     &sync.Mutex{},
 		failedDeleteStatsMutex:        &sync.Mutex{},
+		deletedVolumes:                make(map[types.UID]bool),
+		deletedVolumesMutex:           &sync.Mutex{},
+		rawClasses:                    make(map[string]*rawStorageClass),
+		rawClassesMutex:               &sync.Mutex{},
 		leaseDuration:                 DefaultLeaseDuration,
 		renewDeadline:                 DefaultRenewDeadline,
 		retryPeriod:                   DefaultRetryPeriod,
 		termLimit:                     DefaultTermLimit,
+		claimLock:                     DefaultClaimLock,
 		leaderElectors:                make(map[types.UID]*leaderelection.LeaderElector),
 		leaderElectorsMutex:           &sync.Mutex{},
+		reclaimPolicy:                 DefaultReclaimPolicy,
+		failureEventInterval:          DefaultFailureEventInterval,
+		failureStreaks:                make(map[string]*failureStreak),
+		failureStreaksMutex:           &sync.Mutex{},
+		provisioningVolumes:           make(map[string]string),
+		provisioningVolumesLock:       &sync.Mutex{},
 		hasRun:                        false,
 		hasRunLock:                    &sync.Mutex{},
 	}
 
 	for _, option := range options {
-		option(controller)
+		if err := option(controller); err != nil {
+			glog.Fatalf("Error creating provision controller: %v", err)
+		}
 	}
 
+	claimNamespace := v1.NamespaceAll
+	if len(controller.watchNamespaces) == 1 {
+		for namespace := range controller.watchNamespaces {
+			claimNamespace = namespace
+		}
+	}
+	claimSelector := ""
+	if controller.claimSelector != nil {
+		claimSelector = controller.claimSelector.String()
+	}
 	controller.claimSource = &cache.ListWatch{
 		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-			return client.Core().PersistentVolumeClaims(v1.NamespaceAll).List(options)
+			options.LabelSelector = claimSelector
+			return client.Core().PersistentVolumeClaims(claimNamespace).List(options)
 		},
 		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-			return client.Core().PersistentVolumeClaims(v1.NamespaceAll).Watch(options)
+			options.LabelSelector = claimSelector
+			return client.Core().PersistentVolumeClaims(claimNamespace).Watch(options)
 		},
 	}
 	controller.claims, controller.claimController = cache.NewInformer(
@@ -343,7 +632,7 @@ func NewProvisionController(
 		cache.ResourceEventHandlerFuncs{
 			AddFunc:    controller.addClaim,
 			UpdateFunc: controller.updateClaim,
-			DeleteFunc: nil,
+			DeleteFunc: controller.deleteClaim,
 		},
 	)
 
@@ -360,13 +649,17 @@ func NewProvisionController(
 		&v1.PersistentVolume{},
 		controller.resyncPeriod,
 		cache.ResourceEventHandlerFuncs{
-			AddFunc:    nil,
+			AddFunc:    controller.addVolume,
 			UpdateFunc: controller.updateVolume,
-			DeleteFunc: nil,
+			DeleteFunc: controller.deleteVolume,
 		},
 	)
 
-	controller.classes = cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+	classHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    nil,
+		UpdateFunc: controller.updateClass,
+		DeleteFunc: controller.deleteClass,
+	}
 	if controller.kubeVersion.AtLeast(utilversion.MustParseSemantic("v1.6.0")) {
 		controller.classSource = &cache.ListWatch{
 			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
@@ -376,11 +669,11 @@ func NewProvisionController(
 				return client.StorageV1().StorageClasses().Watch(options)
 			},
 		}
-		controller.classReflector = cache.NewReflector(
+		controller.classes, controller.classController = cache.NewInformer(
 			controller.classSource,
 			&storage.StorageClass{},
-			controller.classes,
 			controller.resyncPeriod,
+			classHandler,
 		)
 	} else {
 		controller.classSource = &cache.ListWatch{
@@ -391,11 +684,11 @@ func NewProvisionController(
 				return client.StorageV1beta1().StorageClasses().Watch(options)
 			},
 		}
-		controller.classReflector = cache.NewReflector(
+		controller.classes, controller.classController = cache.NewInformer(
 			controller.classSource,
 			&storagebeta.StorageClass{},
-			controller.classes,
 			controller.resyncPeriod,
+			classHandler,
 		)
 	}
 
@@ -410,7 +703,7 @@ func (ctrl *ProvisionController) Run(stopCh <-chan struct{}) {
 	ctrl.hasRunLock.Unlock()
 	go ctrl.claimController.Run(stopCh)
 	go ctrl.volumeController.Run(stopCh)
-	go ctrl.classReflector.RunUntil(stopCh)
+	go ctrl.classController.Run(stopCh)
 	<-stopCh
 }
 
@@ -462,6 +755,11 @@ func (ctrl *ProvisionController) addClaim(obj interface{}) {
 				return nil
 			})
 		}
+	} else if ctrl.shouldExpand(claim) {
+		opName := fmt.Sprintf("expand-%s[%s]", claimToClaimKey(claim), string(claim.UID))
+		ctrl.scheduleOperation(opName, func() error {
+			return ctrl.expandClaimOperation(claim)
+		})
 	}
 }
 
@@ -499,6 +797,35 @@ func (ctrl *ProvisionController) updateClaim(oldObj, newObj interface{}) {
 	}
 }
 
+// On delete claim, forget its provisioning failures. Generic ephemeral volumes
+// create and delete a claim per pod, so the stats of claims that are gone must
+// not pile up.
+func (ctrl *ProvisionController) deleteClaim(obj interface{}) {
+	if unknown, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = unknown.Obj
+	}
+	claim, ok := obj.(*v1.PersistentVolumeClaim)
+	if !ok {
+		glog.Errorf("Expected PersistentVolumeClaim but deleteClaim received %+v", obj)
+		return
+	}
+
+	ctrl.failedProvisionStatsMutex.Lock()
+	delete(ctrl.failedProvisionStats, claim.UID)
+	ctrl.failedProvisionStatsMutex.Unlock()
+}
+
+// On add volume, stop counting it among the volumes being provisioned, now
+// that the volumes cache counts it.
+func (ctrl *ProvisionController) addVolume(obj interface{}) {
+	volume, ok := obj.(*v1.PersistentVolume)
+	if !ok {
+		glog.Errorf("Expected PersistentVolume but handler received %#v", obj)
+		return
+	}
+	ctrl.releaseNamespaceVolume(volume.Name)
+}
+
 // On update volume, check if the updated volume should be deleted and delete if
 // so. Updates occur at least every resyncPeriod.
 func (ctrl *ProvisionController) updateVolume(oldObj, newObj interface{}) {
@@ -510,7 +837,7 @@ func (ctrl *ProvisionController) updateVolume(oldObj, newObj interface{}) {
 
 	if ctrl.shouldDelete(volume) {
 		opName := fmt.Sprintf("delete-%s[%s]", volume.Name, string(volume.UID))
-		ctrl.scheduleOperation(opName, func() error {
+		ctrl.scheduleDeleteOperation(opName, func() error {
 			err := ctrl.deleteVolumeOperation(volume)
 			ctrl.updateDeleteStats(volume, err)
 			return err
@@ -518,6 +845,76 @@ func (ctrl *ProvisionController) updateVolume(oldObj, newObj interface{}) {
 	}
 }
 
+// On delete volume, forget its deletion failures and that its storage was
+// deleted.
+func (ctrl *ProvisionController) deleteVolume(obj interface{}) {
+	if unknown, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = unknown.Obj
+	}
+	volume, ok := obj.(*v1.PersistentVolume)
+	if !ok {
+		glog.Errorf("Expected PersistentVolume but deleteVolume received %+v", obj)
+		return
+	}
+
+	ctrl.failedDeleteStatsMutex.Lock()
+	delete(ctrl.failedDeleteStats, volume.UID)
+	ctrl.failedDeleteStatsMutex.Unlock()
+
+	ctrl.deletedVolumesMutex.Lock()
+	delete(ctrl.deletedVolumes, volume.UID)
+	ctrl.deletedVolumesMutex.Unlock()
+}
+
+// On update class, forget its raw fields if it changed. Updates occur at least
+// every resyncPeriod.
+func (ctrl *ProvisionController) updateClass(oldObj, newObj interface{}) {
+	name, oldVersion, ok := getClassNameAndVersion(oldObj)
+	if !ok {
+		glog.Errorf("Expected StorageClass but updateClass received %+v", oldObj)
+		return
+	}
+	_, newVersion, ok := getClassNameAndVersion(newObj)
+	if !ok {
+		glog.Errorf("Expected StorageClass but updateClass received %+v", newObj)
+		return
+	}
+	if oldVersion != newVersion {
+		ctrl.forgetRawStorageClass(name)
+	}
+}
+
+// On delete class, forget its raw fields.
+func (ctrl *ProvisionController) deleteClass(obj interface{}) {
+	if unknown, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = unknown.Obj
+	}
+	name, _, ok := getClassNameAndVersion(obj)
+	if !ok {
+		glog.Errorf("Expected StorageClass but deleteClass received %+v", obj)
+		return
+	}
+	ctrl.forgetRawStorageClass(name)
+}
+
+func (ctrl *ProvisionController) forgetRawStorageClass(name string) {
+	ctrl.rawClassesMutex.Lock()
+	delete(ctrl.rawClasses, name)
+	ctrl.rawClassesMutex.Unlock()
+}
+
+// getClassNameAndVersion returns the name and resourceVersion of the given GA
+// or beta StorageClass.
+func getClassNameAndVersion(obj interface{}) (string, string, bool) {
+	switch class := obj.(type) {
+	case *storage.StorageClass:
+		return class.Name, class.ResourceVersion, true
+	case *storagebeta.StorageClass:
+		return class.Name, class.ResourceVersion, true
+	}
+	return "", "", false
+}
+
 // isOnlyRecordUpdate checks if the only update between the old & new claim is
 // the leader election record annotation.
 func (ctrl *ProvisionController) isOnlyRecordUpdate(oldClaim, newClaim *v1.PersistentVolumeClaim) (bool, error) {
@@ -569,10 +966,25 @@ func (ctrl *ProvisionController) shouldProvision(claim *v1.PersistentVolumeClaim
 		return false
 	}
 
+	if !ctrl.isNamespaceWatched(claim.Namespace) || !ctrl.isClaimSelected(claim) {
+		return false
+	}
+
+	if !ctrl.isClassServed(helper.GetPersistentVolumeClaimClass(claim)) {
+		return false
+	}
+
+	// A claim being deleted no longer needs a volume, even if it is held by the
+	// kubernetes.io/pvc-protection finalizer, e.g. a generic ephemeral volume's
+	// claim whose pod went away before it was bound
+	if claim.DeletionTimestamp != nil {
+		return false
+	}
+
 	// Kubernetes 1.5 provisioning with annStorageProvisioner
 	if provisioner, found := claim.Annotations[annStorageProvisioner]; found {
 		if provisioner == ctrl.provisionerName {
-			return true
+			return ctrl.qualifies(claim)
 		}
 		return false
 	}
@@ -588,6 +1000,45 @@ func (ctrl *ProvisionController) shouldProvision(claim *v1.PersistentVolumeClaim
 		return false
 	}
 
+	// Kubernetes 1.9 delayed binding: the PV controller sets
+	// annStorageProvisioner only once the scheduler has selected a node, so
+	// don't jump the gun here before it has
+	if _, found := claim.Annotations[annSelectedNode]; !found && ctrl.kubeVersion.AtLeast(utilversion.MustParseSemantic("v1.9.0")) {
+		class, err := ctrl.getRawStorageClass(claimClass)
+		if err != nil {
+			glog.Errorf("Error getting claim %q's StorageClass's volumeBindingMode: %v", claimToClaimKey(claim), err)
+			return false
+		}
+		if class.VolumeBindingMode == volumeBindingWaitForFirstConsumer {
+			glog.V(4).Infof("claim %q's StorageClass %q has volumeBindingMode %s, waiting for a node to be selected", claimToClaimKey(claim), claimClass, class.VolumeBindingMode)
+			return false
+		}
+	}
+
+	return ctrl.qualifies(claim)
+}
+
+// qualifies checks that the provisioner can populate the given claim's volume
+// from its dataSourceRef, if any, and asks the provisioner, if it is a
+// Qualifier, whether it wants to provision for the claim.
+func (ctrl *ProvisionController) qualifies(claim *v1.PersistentVolumeClaim) bool {
+	// Kubernetes 1.22 dataSourceRef: a claim to be populated from a custom
+	// resource is left to a provisioner that can populate it. Claims to be
+	// cloned or restored are this provisioner's to fail if it can't.
+	if ctrl.kubeVersion.AtLeast(utilversion.MustParseSemantic("v1.22.0")) {
+		dataSourceRef, err := ctrl.getDataSourceRef(claim)
+		if err != nil {
+			glog.Errorf("Error getting claim %q's dataSourceRef: %v", claimToClaimKey(claim), err)
+			return false
+		}
+		if dataSourceRef != nil && isPopulatorSource(dataSourceRef) && !ctrl.supportsDataSource(dataSourceRef) {
+			glog.V(4).Infof("claim %q's dataSourceRef of kind %s in API group %s is not supported, leaving it to its populator", claimToClaimKey(claim), dataSourceRef.Kind, dataSourceRef.APIGroup)
+			return false
+		}
+	}
+	if qualifier, ok := ctrl.provisioner.(Qualifier); ok {
+		return qualifier.ShouldProvision(claim)
+	}
 	return true
 }
 
@@ -602,6 +1053,13 @@ func (ctrl *ProvisionController) shouldDelete(volume *v1.PersistentVolume) bool
 	}
 	ctrl.failedDeleteStatsMutex.Unlock()
 
+	// The volume's storage is deleted and its PV is terminating, waiting only
+	// for its finalizers, e.g. kubernetes.io/pv-protection, to be removed
+	if volume.DeletionTimestamp != nil && ctrl.isStorageDeleted(volume) {
+		glog.V(4).Infof("volume %q is terminating, waiting for finalizers %v", volume.Name, volume.Finalizers)
+		return false
+	}
+
 	// In 1.5+ we delete only if the volume is in state Released. In 1.4 we must
 	// delete if the volume is in state Failed too.
 	if ctrl.kubeVersion.AtLeast(utilversion.MustParseSemantic("v1.5.0")) {
@@ -626,9 +1084,102 @@ func (ctrl *ProvisionController) shouldDelete(volume *v1.PersistentVolume) bool
 		return false
 	}
 
+	// Volumes are deleted by the controller watching their claims' namespace
+	if volume.Spec.ClaimRef != nil && !ctrl.isNamespaceWatched(volume.Spec.ClaimRef.Namespace) {
+		return false
+	}
+
+	if !ctrl.isClassServed(helper.GetPersistentVolumeClass(volume)) {
+		return false
+	}
+
+	return true
+}
+
+// isNamespaceWatched returns whether the controller serves claims in the given
+// namespace, per WatchNamespaces and DenyNamespaces.
+func (ctrl *ProvisionController) isNamespaceWatched(namespace string) bool {
+	if ctrl.denyNamespaces[namespace] {
+		return false
+	}
+	return ctrl.watchNamespaces == nil || ctrl.watchNamespaces[namespace]
+}
+
+// isClaimSelected returns whether the given claim's labels match the
+// ClaimSelector.
+func (ctrl *ProvisionController) isClaimSelected(claim *v1.PersistentVolumeClaim) bool {
+	return ctrl.claimSelector == nil || ctrl.claimSelector.Matches(labels.Set(claim.Labels))
+}
+
+// isClassServed returns whether the controller serves the given StorageClass,
+// per StorageClasses.
+func (ctrl *ProvisionController) isClassServed(class string) bool {
+	return ctrl.storageClasses == nil || ctrl.storageClasses[class]
+}
+
+// shouldExpand returns whether the given claim's volume is one of ours that
+// the provisioner can expand and should, because the claim requests more than
+// it has and its StorageClass allows expansion.
+func (ctrl *ProvisionController) shouldExpand(claim *v1.PersistentVolumeClaim) bool {
+	if _, ok := ctrl.provisioner.(Expander); !ok {
+		return false
+	}
+
+	// Kubernetes 1.11 volume expansion, with the claim conditions that report
+	// its progress, is beta
+	if !ctrl.kubeVersion.AtLeast(utilversion.MustParseSemantic("v1.11.0")) {
+		return false
+	}
+
+	if claim.Spec.VolumeName == "" || claim.Status.Phase != v1.ClaimBound {
+		return false
+	}
+	if !ctrl.isNamespaceWatched(claim.Namespace) || !ctrl.isClaimSelected(claim) {
+		return false
+	}
+	if !ctrl.isClassServed(helper.GetPersistentVolumeClaimClass(claim)) {
+		return false
+	}
+	obj, found, err := ctrl.volumes.GetByKey(claim.Spec.VolumeName)
+	if err != nil || !found {
+		return false
+	}
+	volume, ok := obj.(*v1.PersistentVolume)
+	if !ok || !needsExpansion(claim, volume) {
+		return false
+	}
+	if ann := volume.Annotations[annDynamicallyProvisioned]; ann != ctrl.provisionerName {
+		return false
+	}
+
+	claimClass := helper.GetPersistentVolumeClaimClass(claim)
+	class, err := ctrl.getRawStorageClass(claimClass)
+	if err != nil {
+		glog.Errorf("Error getting claim %q's StorageClass's allowVolumeExpansion: %v", claimToClaimKey(claim), err)
+		return false
+	}
+	if class.AllowVolumeExpansion == nil || !*class.AllowVolumeExpansion {
+		glog.V(4).Infof("claim %q requests more storage but its StorageClass %q doesn't allow volume expansion", claimToClaimKey(claim), claimClass)
+		return false
+	}
+
 	return true
 }
 
+// needsExpansion returns whether the given claim requests more storage than
+// its volume has.
+func needsExpansion(claim *v1.PersistentVolumeClaim, volume *v1.PersistentVolume) bool {
+	requested := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	capacity := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	if requested.Cmp(capacity) > 0 {
+		return true
+	}
+	// The volume may have been expanded without the claim's status being
+	// updated to match
+	claimCapacity := claim.Status.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	return requested.Cmp(claimCapacity) > 0
+}
+
 // lockProvisionClaimOperation wraps provisionClaimOperation. In case other
 // controllers are serving the same claims, to prevent them all from creating
 // volumes for a claim & racing to submit their PV, each controller creates a
@@ -636,16 +1187,32 @@ func (ctrl *ProvisionController) shouldDelete(volume *v1.PersistentVolume) bool
 // leader is tasked with provisioning & may try to do so
 func (ctrl *ProvisionController) lockProvisionClaimOperation(claim *v1.PersistentVolumeClaim) {
 	stoppedLeading := false
-	rl := rl.ProvisionPVCLock{
-		PVCMeta: claim.ObjectMeta,
-		Client:  ctrl.client,
-		LockConfig: rl.Config{
-			Identity:      string(ctrl.identity),
-			EventRecorder: ctrl.eventRecorder,
-		},
+	lockConfig := rl.Config{
+		Identity:      string(ctrl.identity),
+		EventRecorder: ctrl.eventRecorder,
+	}
+	// Leases spare racing controllers from updating the claim, and everything
+	// watching it from seeing every renewal, once no controller that only
+	// locks the claim remains to race
+	var lock rl.Interface = &rl.ProvisionPVCLock{
+		PVCMeta:    claim.ObjectMeta,
+		Client:     ctrl.client,
+		LockConfig: lockConfig,
+	}
+	if ctrl.kubeVersion.AtLeast(utilversion.MustParseSemantic("v1.14.0")) {
+		leaseLock := &rl.ProvisionLeaseLock{
+			PVCMeta:    claim.ObjectMeta,
+			Client:     ctrl.client,
+			LockConfig: lockConfig,
+		}
+		if ctrl.claimLock {
+			lock = &rl.MultiLock{Primary: leaseLock, Secondary: lock}
+		} else {
+			lock = leaseLock
+		}
 	}
 	le, err := leaderelection.NewLeaderElector(leaderelection.Config{
-		Lock:          &rl,
+		Lock:          lock,
 		LeaseDuration: ctrl.leaseDuration,
 		RenewDeadline: ctrl.renewDeadline,
 		RetryPeriod:   ctrl.retryPeriod,
@@ -759,6 +1326,15 @@ func (ctrl *ProvisionController) provisionClaimOperation(claim *v1.PersistentVol
 		return nil
 	}
 
+	// The claim may also have been deleted while we were waiting, e.g. a
+	// generic ephemeral volume's claim along with its pod. A volume provisioned
+	// for it now would only be released and deleted again.
+	latestClaim, err := ctrl.client.Core().PersistentVolumeClaims(claim.Namespace).Get(claim.Name, metav1.GetOptions{})
+	if err != nil && errors.IsNotFound(err) || err == nil && (latestClaim.UID != claim.UID || latestClaim.DeletionTimestamp != nil) {
+		glog.V(4).Infof("provisionClaimOperation [%s]: claim is deleted, skipping", claimToClaimKey(claim))
+		return nil
+	}
+
 	// Prepare a claimRef to the claim early (to fail before a volume is
 	// provisioned)
 	claimRef, err := ref.GetReference(api.Scheme, claim)
@@ -780,12 +1356,83 @@ func (ctrl *ProvisionController) provisionClaimOperation(claim *v1.PersistentVol
 		return nil
 	}
 
+	var selectedNode *v1.Node
+	if nodeName, found := claim.Annotations[annSelectedNode]; found {
+		selectedNode, err = ctrl.client.Core().Nodes().Get(nodeName, metav1.GetOptions{})
+		if err != nil {
+			strerr := fmt.Sprintf("Failed to get selected node %q: %v", nodeName, err)
+			glog.Errorf("Failed to get selected node %q for claim %q: %v", nodeName, claimToClaimKey(claim), err)
+			ctrl.recordFailure(claim, claim.UID, "ProvisioningFailed", strerr)
+			return err
+		}
+	}
+
+	// Kubernetes 1.8 added reclaimPolicy to StorageClass and 1.11
+	// allowedTopologies
+	reclaimPolicy := ctrl.reclaimPolicy
+	var allowedTopologies []v1.NodeSelectorTerm
+	if ctrl.kubeVersion.AtLeast(utilversion.MustParseSemantic("v1.8.0")) {
+		class, err := ctrl.getRawStorageClass(claimClass)
+		if err != nil {
+			glog.Errorf("Error getting claim %q's StorageClass's reclaimPolicy and allowedTopologies: %v", claimToClaimKey(claim), err)
+			return err
+		}
+		reclaimPolicy = class.reclaimPolicy(ctrl.reclaimPolicy)
+		if ctrl.kubeVersion.AtLeast(utilversion.MustParseSemantic("v1.11.0")) {
+			allowedTopologies = class.nodeSelectorTerms()
+		}
+	}
+
+	var dataSourceRef *DataSourceRef
+	if ctrl.kubeVersion.AtLeast(utilversion.MustParseSemantic("v1.22.0")) {
+		dataSourceRef, err = ctrl.getDataSourceRef(claim)
+		if err != nil {
+			glog.Errorf("Error getting claim %q's dataSourceRef: %v", claimToClaimKey(claim), err)
+			return err
+		}
+		if dataSourceRef != nil && !ctrl.supportsDataSource(dataSourceRef) {
+			// Provisioning an empty volume for a claim to be cloned or
+			// restored from a snapshot would silently lose its data
+			strerr := fmt.Sprintf("Data source of kind %s in API group %q is not supported by external provisioner %q", dataSourceRef.Kind, dataSourceRef.APIGroup, ctrl.provisionerName)
+			glog.Errorf("Claim %q's dataSourceRef of kind %s in API group %q is not supported", claimToClaimKey(claim), dataSourceRef.Kind, dataSourceRef.APIGroup)
+			ctrl.recordFailure(claim, claim.UID, "ProvisioningFailed", strerr)
+			return nil
+		}
+		// Kubernetes 1.26 cross-namespace data sources: the source's
+		// namespace must grant the claim's access to it
+		if dataSourceRef != nil && dataSourceRef.Namespace != "" && dataSourceRef.Namespace != claim.Namespace {
+			granted, err := ctrl.isReferenceGranted(claim, dataSourceRef)
+			if err != nil {
+				glog.Errorf("Error checking ReferenceGrants for claim %q's dataSourceRef: %v", claimToClaimKey(claim), err)
+				return err
+			}
+			if !granted {
+				strerr := fmt.Sprintf("Data source %s %s/%s is not accessible from namespace %s: no ReferenceGrant in namespace %s permits it", dataSourceRef.Kind, dataSourceRef.Namespace, dataSourceRef.Name, claim.Namespace, dataSourceRef.Namespace)
+				glog.Errorf("Claim %q's dataSourceRef %s %s/%s is not permitted by any ReferenceGrant", claimToClaimKey(claim), dataSourceRef.Kind, dataSourceRef.Namespace, dataSourceRef.Name)
+				ctrl.recordFailure(claim, claim.UID, "ProvisioningFailed", strerr)
+				// The grant may yet be created
+				return fmt.Errorf("dataSourceRef not permitted by any ReferenceGrant")
+			}
+		}
+	}
+
 	options := VolumeOptions{
-		// TODO SHOULD be set to `Delete` unless user manually congiures other reclaim policy.
-		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
-		PVName:     pvName,
-		PVC:        claim,
-		Parameters: parameters,
+		PersistentVolumeReclaimPolicy: reclaimPolicy,
+		PVName:            pvName,
+		PVC:               claim,
+		Parameters:        parameters,
+		SelectedNode:      selectedNode,
+		AllowedTopologies: allowedTopologies,
+		DataSourceRef:     dataSourceRef,
+	}
+
+	if !ctrl.reserveNamespaceVolume(claim.Namespace, pvName) {
+		strerr := fmt.Sprintf("Namespace %s already has the maximum of %d volumes provisioned by external provisioner %q", claim.Namespace, ctrl.maxVolumesPerNamespace, ctrl.provisionerName)
+		glog.Errorf("Not provisioning volume for claim %q: namespace has the maximum of %d volumes", claimToClaimKey(claim), ctrl.maxVolumesPerNamespace)
+		ctrl.recordFailure(claim, claim.UID, "ProvisioningFailed", strerr)
+		// Not a failure: the claim is provisioned for once the namespace has
+		// room for it again
+		return nil
 	}
 
 	ctrl.eventRecorder.Event(claim, v1.EventTypeNormal, "Provisioning", fmt.Sprintf("External provisioner is provisioning volume for claim %q", claimToClaimKey(claim)))
@@ -794,7 +1441,8 @@ func (ctrl *ProvisionController) provisionClaimOperation(claim *v1.PersistentVol
 	if err != nil {
 		strerr := fmt.Sprintf("Failed to provision volume with StorageClass %q: %v", claimClass, err)
 		glog.Errorf("Failed to provision volume for claim %q with StorageClass %q: %v", claimToClaimKey(claim), claimClass, err)
-		ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningFailed", strerr)
+		ctrl.recordFailure(claim, claim.UID, "ProvisioningFailed", strerr)
+		ctrl.releaseNamespaceVolume(pvName)
 		return err
 	}
 
@@ -830,7 +1478,8 @@ func (ctrl *ProvisionController) provisionClaimOperation(claim *v1.PersistentVol
 		// times.
 		strerr := fmt.Sprintf("Error creating provisioned PV object for claim %s: %v. Deleting the volume.", claimToClaimKey(claim), err)
 		glog.Error(strerr)
-		ctrl.eventRecorder.Event(claim, v1.EventTypeWarning, "ProvisioningFailed", strerr)
+		ctrl.recordFailure(claim, claim.UID, "ProvisioningFailed", strerr)
+		ctrl.releaseNamespaceVolume(pvName)
 
 		for i := 0; i < ctrl.createProvisionedPVRetryCount; i++ {
 			if err = ctrl.provisioner.Delete(volume); err == nil {
@@ -852,13 +1501,170 @@ func (ctrl *ProvisionController) provisionClaimOperation(claim *v1.PersistentVol
 		}
 	} else {
 		glog.Infof("volume %q provisioned for claim %q", volume.Name, claimToClaimKey(claim))
+		// The volume keeps counting until the volumes cache counts it, or
+		// it's deleted before the cache sees it
+		time.AfterFunc(provisionedVolumeTimeout, func() {
+			ctrl.releaseNamespaceVolume(pvName)
+		})
 		msg := fmt.Sprintf("Successfully provisioned volume %s", volume.Name)
+		ctrl.clearFailures(claim.UID, "ProvisioningFailed")
 		ctrl.eventRecorder.Event(claim, v1.EventTypeNormal, "ProvisioningSucceeded", msg)
 	}
 
 	return nil
 }
 
+// reserveNamespaceVolume returns whether the named volume may be provisioned
+// for a claim in the given namespace without exceeding
+// maxVolumesPerNamespace, counting it among those being provisioned if so,
+// until releaseNamespaceVolume is called. Volumes being provisioned are only
+// counted until the volumes cache has them, so that none is counted twice.
+func (ctrl *ProvisionController) reserveNamespaceVolume(namespace, name string) bool {
+	if ctrl.maxVolumesPerNamespace <= 0 {
+		return true
+	}
+	ctrl.provisioningVolumesLock.Lock()
+	defer ctrl.provisioningVolumesLock.Unlock()
+	count := 0
+	for provisioning, provisioningNamespace := range ctrl.provisioningVolumes {
+		if provisioningNamespace != namespace {
+			continue
+		}
+		if _, found, _ := ctrl.volumes.GetByKey(provisioning); !found {
+			count++
+		}
+	}
+	for _, obj := range ctrl.volumes.List() {
+		volume, ok := obj.(*v1.PersistentVolume)
+		if !ok || volume.Spec.ClaimRef == nil || volume.Spec.ClaimRef.Namespace != namespace {
+			continue
+		}
+		if volume.Annotations[annDynamicallyProvisioned] == ctrl.provisionerName {
+			count++
+		}
+	}
+	if count >= ctrl.maxVolumesPerNamespace {
+		return false
+	}
+	ctrl.provisioningVolumes[name] = namespace
+	return true
+}
+
+// releaseNamespaceVolume stops counting the named volume reserved with
+// reserveNamespaceVolume among those being provisioned, once it has failed to
+// be provisioned or the volumes cache has it.
+func (ctrl *ProvisionController) releaseNamespaceVolume(name string) {
+	if ctrl.maxVolumesPerNamespace <= 0 {
+		return
+	}
+	ctrl.provisioningVolumesLock.Lock()
+	defer ctrl.provisioningVolumesLock.Unlock()
+	delete(ctrl.provisioningVolumes, name)
+}
+
+// expandClaimOperation expands the given claim's volume to the size the claim
+// requests. Like in-tree plugins, it reports its progress with the claim's
+// Resizing condition, which it clears along with updating the claim's capacity
+// once the volume is expanded: NFS volumes need no file system resize on the
+// nodes. If expanding fails, the condition is set to False until the next
+// attempt.
+func (ctrl *ProvisionController) expandClaimOperation(claim *v1.PersistentVolumeClaim) error {
+	glog.V(4).Infof("expandClaimOperation [%s] started", claimToClaimKey(claim))
+
+	volume, err := ctrl.client.Core().PersistentVolumes().Get(claim.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		glog.Errorf("Error getting claim %q's volume %q: %v", claimToClaimKey(claim), claim.Spec.VolumeName, err)
+		return err
+	}
+	if !needsExpansion(claim, volume) {
+		glog.V(4).Infof("expandClaimOperation [%s]: volume %q no longer needs expansion, skipping", claimToClaimKey(claim), volume.Name)
+		return nil
+	}
+	size := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+
+	capacity := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	if size.Cmp(capacity) > 0 {
+		err = ctrl.patchClaimStatus(claim, map[string]interface{}{
+			"conditions": []map[string]interface{}{
+				{
+					"type":               "Resizing",
+					"status":             "True",
+					"lastTransitionTime": metav1.Now(),
+				},
+			},
+		})
+		if err != nil {
+			glog.Errorf("Error setting claim %q's Resizing condition: %v", claimToClaimKey(claim), err)
+			return err
+		}
+
+		expanded, err := ctrl.provisioner.(Expander).ExpandVolume(volume, size)
+		if err != nil {
+			strerr := fmt.Sprintf("Failed to expand volume %s to %s: %v", volume.Name, size.String(), err)
+			glog.Errorf("Failed to expand volume %q for claim %q to %s: %v", volume.Name, claimToClaimKey(claim), size.String(), err)
+			ctrl.recordFailure(claim, claim.UID, "VolumeResizeFailed", strerr)
+			ctrl.setResizingFailed(claim, strerr)
+			return err
+		}
+		volume, err = ctrl.client.Core().PersistentVolumes().Update(expanded)
+		if err != nil {
+			// The volume is expanded, the next attempt will expand it again to
+			// the same size and retry saving it
+			glog.Errorf("Error saving expanded volume %q for claim %q: %v", expanded.Name, claimToClaimKey(claim), err)
+			ctrl.setResizingFailed(claim, fmt.Sprintf("Failed to save expanded volume %s: %v", expanded.Name, err))
+			return err
+		}
+		glog.Infof("volume %q for claim %q expanded to %s", volume.Name, claimToClaimKey(claim), size.String())
+	}
+
+	capacity = volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	err = ctrl.patchClaimStatus(claim, map[string]interface{}{
+		"capacity":   map[string]interface{}{string(v1.ResourceStorage): capacity.String()},
+		"conditions": nil,
+	})
+	if err != nil {
+		glog.Errorf("Error updating claim %q's capacity: %v", claimToClaimKey(claim), err)
+		return err
+	}
+
+	msg := fmt.Sprintf("Successfully expanded volume %s to %s", volume.Name, capacity.String())
+	ctrl.clearFailures(claim.UID, "VolumeResizeFailed")
+	ctrl.eventRecorder.Event(claim, v1.EventTypeNormal, "VolumeResizeSuccessful", msg)
+	return nil
+}
+
+// setResizingFailed sets the given claim's Resizing condition to False with
+// the given message, so that the claim doesn't look like it's still being
+// resized until the next attempt sets it to True again.
+func (ctrl *ProvisionController) setResizingFailed(claim *v1.PersistentVolumeClaim, message string) {
+	err := ctrl.patchClaimStatus(claim, map[string]interface{}{
+		"conditions": []map[string]interface{}{
+			{
+				"type":               "Resizing",
+				"status":             "False",
+				"reason":             "VolumeResizeFailed",
+				"message":            message,
+				"lastTransitionTime": metav1.Now(),
+			},
+		},
+	})
+	if err != nil {
+		glog.Errorf("Error setting claim %q's Resizing condition to False: %v", claimToClaimKey(claim), err)
+	}
+}
+
+// patchClaimStatus merges the given fields into the given claim's status. The
+// claim's conditions aren't in the vendored claim type, so they can't be
+// updated with it.
+func (ctrl *ProvisionController) patchClaimStatus(claim *v1.PersistentVolumeClaim, status map[string]interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{"status": status})
+	if err != nil {
+		return err
+	}
+	_, err = ctrl.client.Core().PersistentVolumeClaims(claim.Namespace).Patch(claim.Name, types.MergePatchType, patch, "status")
+	return err
+}
+
 // watchProvisioning returns a channel to which it sends the results of all
 // provisioning attempts for the given claim. The PVC being modified to no
 // longer need provisioning is considered a success.
@@ -1028,20 +1834,37 @@ func (ctrl *ProvisionController) deleteVolumeOperation(volume *v1.PersistentVolu
 		return nil
 	}
 
-	err = ctrl.provisioner.Delete(volume)
-	if err != nil {
-		if ierr, ok := err.(*IgnoredError); ok {
-			// Delete ignored, do nothing and hope another provisioner will delete it.
-			glog.Infof("deletion of volume %q ignored: %v", volume.Name, ierr)
-			return nil
+	// The storage may have been deleted already by an earlier operation that
+	// failed to delete the PV
+	if !ctrl.isStorageDeleted(volume) {
+		err = ctrl.provisioner.Delete(volume)
+		if err != nil {
+			if ierr, ok := err.(*IgnoredError); ok {
+				// Delete ignored, do nothing and hope another provisioner will delete it.
+				glog.Infof("deletion of volume %q ignored: %v", volume.Name, ierr)
+				return nil
+			}
+			// Delete failed, emit an event.
+			glog.Errorf("Deletion of volume %q failed: %v", volume.Name, err)
+			ctrl.recordFailure(volume, volume.UID, "VolumeFailedDelete", err.Error())
+			return err
 		}
-		// Delete failed, emit an event.
-		glog.Errorf("Deletion of volume %q failed: %v", volume.Name, err)
-		ctrl.eventRecorder.Event(volume, v1.EventTypeWarning, "VolumeFailedDelete", err.Error())
-		return err
+
+		glog.Infof("volume %q deleted", volume.Name)
+
+		ctrl.deletedVolumesMutex.Lock()
+		ctrl.deletedVolumes[volume.UID] = true
+		ctrl.deletedVolumesMutex.Unlock()
+		ctrl.clearFailures(volume.UID, "VolumeFailedDelete")
 	}
 
-	glog.Infof("volume %q deleted", volume.Name)
+	// Someone, e.g. the user, deleted the PV already and it only waits for its
+	// finalizers, e.g. kubernetes.io/pv-protection, to be removed. It goes
+	// once they are, there's no need to delete it again.
+	if newVolume.DeletionTimestamp != nil {
+		glog.Infof("volume %q is terminating, waiting for finalizers %v", volume.Name, newVolume.Finalizers)
+		return nil
+	}
 
 	glog.V(4).Infof("deleteVolumeOperation [%s]: success", volume.Name)
 	// Delete the volume
@@ -1056,6 +1879,14 @@ func (ctrl *ProvisionController) deleteVolumeOperation(volume *v1.PersistentVolu
 	return nil
 }
 
+// isStorageDeleted returns whether the given volume's storage has been deleted
+// while its PV is still around.
+func (ctrl *ProvisionController) isStorageDeleted(volume *v1.PersistentVolume) bool {
+	ctrl.deletedVolumesMutex.Lock()
+	defer ctrl.deletedVolumesMutex.Unlock()
+	return ctrl.deletedVolumes[volume.UID]
+}
+
 // getProvisionedVolumeNameForClaim returns PV.Name for the provisioned volume.
 // The name must be unique.
 func (ctrl *ProvisionController) getProvisionedVolumeNameForClaim(claim *v1.PersistentVolumeClaim) string {
@@ -1065,8 +1896,33 @@ func (ctrl *ProvisionController) getProvisionedVolumeNameForClaim(claim *v1.Pers
 // scheduleOperation starts given asynchronous operation on given volume. It
 // makes sure the operation is already not running.
 func (ctrl *ProvisionController) scheduleOperation(operationName string, operation func() error) {
+	ctrl.scheduleBoundedOperation(operationName, ctrl.operationSlots, operation)
+}
+
+// scheduleDeleteOperation starts given asynchronous Delete operation like
+// scheduleOperation, bounded by deleteSlots if set.
+func (ctrl *ProvisionController) scheduleDeleteOperation(operationName string, operation func() error) {
+	slots := ctrl.deleteSlots
+	if slots == nil {
+		slots = ctrl.operationSlots
+	}
+	ctrl.scheduleBoundedOperation(operationName, slots, operation)
+}
+
+// scheduleBoundedOperation starts given asynchronous operation, waiting for one
+// of the given slots first unless they are nil.
+func (ctrl *ProvisionController) scheduleBoundedOperation(operationName string, slots chan struct{}, operation func() error) {
 	glog.Infof("scheduleOperation[%s]", operationName)
 
+	if slots != nil {
+		unbounded := operation
+		operation = func() error {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			return unbounded()
+		}
+	}
+
 	err := ctrl.runningOperations.Run(operationName, operation)
 	if err != nil {
 		if goroutinemap.IsAlreadyExists(err) {
@@ -1098,6 +1954,193 @@ func (ctrl *ProvisionController) getStorageClassFields(name string) (string, map
 	return "", nil, fmt.Errorf("Cannot convert object to StorageClass: %+v", classObj)
 }
 
+// rawStorageClass holds the StorageClass fields that are newer than the
+// vendored StorageClass type and so must be read from the raw object instead of
+// from the classes cache.
+type rawStorageClass struct {
+	ReclaimPolicy        string                    `json:"reclaimPolicy"`
+	VolumeBindingMode    string                    `json:"volumeBindingMode"`
+	AllowedTopologies    []rawTopologySelectorTerm `json:"allowedTopologies"`
+	AllowVolumeExpansion *bool                     `json:"allowVolumeExpansion"`
+}
+
+// reclaimPolicy returns the class's reclaimPolicy, or the given default if it
+// doesn't declare one.
+func (class *rawStorageClass) reclaimPolicy(defaultPolicy v1.PersistentVolumeReclaimPolicy) v1.PersistentVolumeReclaimPolicy {
+	if class.ReclaimPolicy == "" {
+		return defaultPolicy
+	}
+	return v1.PersistentVolumeReclaimPolicy(class.ReclaimPolicy)
+}
+
+type rawTopologySelectorTerm struct {
+	MatchLabelExpressions []struct {
+		Key    string   `json:"key"`
+		Values []string `json:"values"`
+	} `json:"matchLabelExpressions"`
+}
+
+// nodeSelectorTerms converts the class's allowedTopologies to the equivalent
+// node selector terms, for use in PV node affinity.
+func (class *rawStorageClass) nodeSelectorTerms() []v1.NodeSelectorTerm {
+	var terms []v1.NodeSelectorTerm
+	for _, topology := range class.AllowedTopologies {
+		term := v1.NodeSelectorTerm{}
+		for _, expression := range topology.MatchLabelExpressions {
+			term.MatchExpressions = append(term.MatchExpressions, v1.NodeSelectorRequirement{
+				Key:      expression.Key,
+				Operator: v1.NodeSelectorOpIn,
+				Values:   expression.Values,
+			})
+		}
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+// getRawStorageClass gets the named StorageClass's fields that aren't in the
+// vendored StorageClass type, from the API the first time after the class was
+// created or changed.
+func (ctrl *ProvisionController) getRawStorageClass(name string) (*rawStorageClass, error) {
+	ctrl.rawClassesMutex.Lock()
+	class, ok := ctrl.rawClasses[name]
+	ctrl.rawClassesMutex.Unlock()
+	if ok {
+		return class, nil
+	}
+
+	raw, err := ctrl.client.StorageV1().RESTClient().Get().Resource("storageclasses").Name(name).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	class = &rawStorageClass{}
+	if err := json.Unmarshal(raw, class); err != nil {
+		return nil, fmt.Errorf("error decoding StorageClass %q: %v", name, err)
+	}
+
+	ctrl.rawClassesMutex.Lock()
+	ctrl.rawClasses[name] = class
+	ctrl.rawClassesMutex.Unlock()
+	return class, nil
+}
+
+// getDataSourceRef gets the given claim's dataSourceRef, which isn't in the
+// vendored claim type, nil if it has none.
+func (ctrl *ProvisionController) getDataSourceRef(claim *v1.PersistentVolumeClaim) (*DataSourceRef, error) {
+	raw, err := ctrl.client.Core().RESTClient().Get().Namespace(claim.Namespace).Resource("persistentvolumeclaims").Name(claim.Name).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	return decodeDataSourceRef(raw)
+}
+
+// rawClaim holds the claim fields that are newer than the vendored claim type.
+type rawClaim struct {
+	Spec struct {
+		DataSourceRef *struct {
+			APIGroup  string `json:"apiGroup"`
+			Kind      string `json:"kind"`
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"dataSourceRef"`
+	} `json:"spec"`
+}
+
+// decodeDataSourceRef decodes the dataSourceRef of the given raw claim, nil if
+// it has none.
+func decodeDataSourceRef(raw []byte) (*DataSourceRef, error) {
+	claim := &rawClaim{}
+	if err := json.Unmarshal(raw, claim); err != nil {
+		return nil, fmt.Errorf("error decoding claim: %v", err)
+	}
+	ref := claim.Spec.DataSourceRef
+	if ref == nil {
+		return nil, nil
+	}
+	return &DataSourceRef{
+		APIGroup:  ref.APIGroup,
+		Kind:      ref.Kind,
+		Name:      ref.Name,
+		Namespace: ref.Namespace,
+	}, nil
+}
+
+// isPopulatorSource returns whether the given data source is a custom resource
+// for some populator to populate volumes from. Core objects, i.e. claims, and
+// volume snapshots are sources of cloning and restoring, which it is up to the
+// claim's provisioner to do.
+func isPopulatorSource(ref *DataSourceRef) bool {
+	return ref.APIGroup != "" && ref.APIGroup != "snapshot.storage.k8s.io"
+}
+
+// isReferenceGranted returns whether a ReferenceGrant in the namespace of the
+// given claim's data source permits the claim to refer to it.
+func (ctrl *ProvisionController) isReferenceGranted(claim *v1.PersistentVolumeClaim, ref *DataSourceRef) (bool, error) {
+	raw, err := ctrl.client.Core().RESTClient().Get().AbsPath("/apis/gateway.networking.k8s.io/v1beta1", "namespaces", ref.Namespace, "referencegrants").DoRaw()
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// The ReferenceGrant CRD isn't installed, so nothing is granted
+			return false, nil
+		}
+		return false, err
+	}
+	return referenceGranted(raw, claim.Namespace, ref)
+}
+
+// rawReferenceGrantList is a list of ReferenceGrants, from the Gateway API.
+type rawReferenceGrantList struct {
+	Items []struct {
+		Spec struct {
+			From []struct {
+				Group     string `json:"group"`
+				Kind      string `json:"kind"`
+				Namespace string `json:"namespace"`
+			} `json:"from"`
+			To []struct {
+				Group string `json:"group"`
+				Kind  string `json:"kind"`
+				Name  string `json:"name"`
+			} `json:"to"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+// referenceGranted returns whether any of the given raw list of ReferenceGrants
+// permits claims in the given namespace to refer to the given data source.
+func referenceGranted(raw []byte, claimNamespace string, ref *DataSourceRef) (bool, error) {
+	grants := &rawReferenceGrantList{}
+	if err := json.Unmarshal(raw, grants); err != nil {
+		return false, fmt.Errorf("error decoding ReferenceGrants: %v", err)
+	}
+	for _, grant := range grants.Items {
+		fromClaims := false
+		for _, from := range grant.Spec.From {
+			if from.Group == "" && from.Kind == "PersistentVolumeClaim" && from.Namespace == claimNamespace {
+				fromClaims = true
+				break
+			}
+		}
+		if !fromClaims {
+			continue
+		}
+		for _, to := range grant.Spec.To {
+			// A grant without a name permits referring to all objects of
+			// the kind
+			if to.Group == ref.APIGroup && to.Kind == ref.Kind && (to.Name == "" || to.Name == ref.Name) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// supportsDataSource returns whether the provisioner is a Populator that can
+// populate volumes from, clone or restore the given data source.
+func (ctrl *ProvisionController) supportsDataSource(ref *DataSourceRef) bool {
+	populator, ok := ctrl.provisioner.(Populator)
+	return ok && populator.SupportsDataSource(ref.APIGroup, ref.Kind)
+}
+
 func claimToClaimKey(claim *v1.PersistentVolumeClaim) string {
 	return fmt.Sprintf("%s/%s", claim.Namespace, claim.Name)
 }
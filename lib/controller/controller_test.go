@@ -17,10 +17,12 @@ limitations under the License.
 package controller
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -29,6 +31,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
@@ -40,6 +43,8 @@ import (
 	"k8s.io/client-go/pkg/api/v1/ref"
 	storagebeta "k8s.io/client-go/pkg/apis/storage/v1beta1"
 	testclient "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	fcache "k8s.io/client-go/tools/cache/testing"
 )
 
@@ -72,6 +77,28 @@ func TestController(t *testing.T) {
 				*newProvisionedVolume(newStorageClass("class-1", "foo.bar/baz"), newClaim("claim-1", "uid-1-1", "class-1", "", nil)),
 			},
 		},
+		{
+			name: "provision for ephemeral claim pod-1-scratch",
+			objs: []runtime.Object{
+				newStorageClass("class-1", "foo.bar/baz"),
+				newEphemeralClaim("pod-1", "uid-1-1", "class-1", false),
+			},
+			provisionerName: "foo.bar/baz",
+			provisioner:     newTestProvisioner(),
+			expectedVolumes: []v1.PersistentVolume{
+				*newProvisionedVolume(newStorageClass("class-1", "foo.bar/baz"), newEphemeralClaim("pod-1", "uid-1-1", "class-1", false)),
+			},
+		},
+		{
+			name: "don't provision for ephemeral claim pod-1-scratch because it's being deleted with its pod",
+			objs: []runtime.Object{
+				newStorageClass("class-1", "foo.bar/baz"),
+				newEphemeralClaim("pod-1", "uid-1-1", "class-1", true),
+			},
+			provisionerName: "foo.bar/baz",
+			provisioner:     newTestProvisioner(),
+			expectedVolumes: []v1.PersistentVolume(nil),
+		},
 		{
 			name: "delete volume-1 but not volume-2",
 			objs: []runtime.Object{
@@ -198,6 +225,80 @@ func TestController(t *testing.T) {
 	}
 }
 
+func TestEphemeralClaimChurn(t *testing.T) {
+	const numPods = 10
+
+	// Every pod gets a claim that fails to be provisioned and a released
+	// volume that fails to be deleted, then goes away along with them
+	objs := []runtime.Object{newStorageClass("class-1", "foo.bar/baz")}
+	claims := []*v1.PersistentVolumeClaim{}
+	volumes := []*v1.PersistentVolume{}
+	for i := 0; i < numPods; i++ {
+		claim := newEphemeralClaim("pod-"+strconv.Itoa(i), "uid-"+strconv.Itoa(i), "class-1", false)
+		volume := newVolume("volume-"+strconv.Itoa(i), v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"})
+		volume.UID = types.UID("volume-uid-" + strconv.Itoa(i))
+		claims = append(claims, claim)
+		volumes = append(volumes, volume)
+		objs = append(objs, claim, volume)
+	}
+	client := fake.NewSimpleClientset(objs...)
+	ctrl := newTestProvisionController(client, "foo.bar/baz", newBadTestProvisioner(), "v1.5.0")
+	ctrl.SetFailedProvisionThreshold(3)
+	ctrl.SetFailedDeleteThreshold(3)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go ctrl.Run(stopCh)
+
+	time.Sleep(2 * resyncPeriod)
+
+	ctrl.failedProvisionStatsMutex.Lock()
+	failedProvisions := len(ctrl.failedProvisionStats)
+	ctrl.failedProvisionStatsMutex.Unlock()
+	if failedProvisions != numPods {
+		t.Errorf("expected %d claims with failed provisions but got %d", numPods, failedProvisions)
+	}
+	ctrl.failedDeleteStatsMutex.Lock()
+	failedDeletes := len(ctrl.failedDeleteStats)
+	ctrl.failedDeleteStatsMutex.Unlock()
+	if failedDeletes != numPods {
+		t.Errorf("expected %d volumes with failed deletes but got %d", numPods, failedDeletes)
+	}
+
+	// The fake client's watches don't deliver deletions, so deliver them by
+	// hand, half of them as tombstones as if the watch had missed them
+	for i := 0; i < numPods; i++ {
+		client.Core().PersistentVolumeClaims(claims[i].Namespace).Delete(claims[i].Name, nil)
+		client.Core().PersistentVolumes().Delete(volumes[i].Name, nil)
+		if i%2 == 0 {
+			ctrl.deleteClaim(claims[i])
+			ctrl.deleteVolume(volumes[i])
+		} else {
+			ctrl.deleteClaim(cache.DeletedFinalStateUnknown{Key: claimToClaimKey(claims[i]), Obj: claims[i]})
+			ctrl.deleteVolume(cache.DeletedFinalStateUnknown{Key: volumes[i].Name, Obj: volumes[i]})
+		}
+	}
+
+	// Nothing is left behind for the claims and volumes that are gone, and
+	// the claims aren't provisioned for even if they are seen again
+	ctrl.failedProvisionStatsMutex.Lock()
+	failedProvisions = len(ctrl.failedProvisionStats)
+	ctrl.failedProvisionStatsMutex.Unlock()
+	if failedProvisions != 0 {
+		t.Errorf("expected no claims with failed provisions but got %d", failedProvisions)
+	}
+	ctrl.failedDeleteStatsMutex.Lock()
+	failedDeletes = len(ctrl.failedDeleteStats)
+	ctrl.failedDeleteStatsMutex.Unlock()
+	if failedDeletes != 0 {
+		t.Errorf("expected no volumes with failed deletes but got %d", failedDeletes)
+	}
+	for _, claim := range claims {
+		if err := ctrl.provisionClaimOperation(claim); err != nil {
+			t.Errorf("expected provisioning for deleted claim %q to be skipped but got error: %v", claimToClaimKey(claim), err)
+		}
+	}
+}
+
 func TestMultipleControllers(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -274,8 +375,13 @@ func TestShouldProvision(t *testing.T) {
 	tests := []struct {
 		name            string
 		provisionerName string
+		provisioner     Provisioner
 		class           *storagebeta.StorageClass
 		claim           *v1.PersistentVolumeClaim
+		watchNamespaces []string
+		denyNamespaces  []string
+		claimSelector   string
+		storageClasses  []string
 		expectedShould  bool
 	}{
 		{
@@ -285,6 +391,63 @@ func TestShouldProvision(t *testing.T) {
 			claim:           newClaim("claim-1", "1-1", "class-1", "", nil),
 			expectedShould:  true,
 		},
+		{
+			name:            "claim in watched namespace",
+			provisionerName: "foo.bar/baz",
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim:           newClaim("claim-1", "1-1", "class-1", "", nil),
+			watchNamespaces: []string{"tenant-1", v1.NamespaceDefault},
+			expectedShould:  true,
+		},
+		{
+			name:            "claim not in watched namespaces",
+			provisionerName: "foo.bar/baz",
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim:           newClaim("claim-1", "1-1", "class-1", "", nil),
+			watchNamespaces: []string{"tenant-1", "tenant-2"},
+			expectedShould:  false,
+		},
+		{
+			name:            "claim in denied namespace",
+			provisionerName: "foo.bar/baz",
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim:           newClaim("claim-1", "1-1", "class-1", "", nil),
+			denyNamespaces:  []string{v1.NamespaceDefault},
+			expectedShould:  false,
+		},
+		{
+			name:            "claim in watched but denied namespace",
+			provisionerName: "foo.bar/baz",
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim:           newClaim("claim-1", "1-1", "class-1", "", nil),
+			watchNamespaces: []string{v1.NamespaceDefault},
+			denyNamespaces:  []string{v1.NamespaceDefault},
+			expectedShould:  false,
+		},
+		{
+			name:            "claim matching selector",
+			provisionerName: "foo.bar/baz",
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim:           newClaimWithLabels("claim-1", "1-1", "class-1", map[string]string{"team": "a", "track": "green"}),
+			claimSelector:   "track=green",
+			expectedShould:  true,
+		},
+		{
+			name:            "claim not matching selector",
+			provisionerName: "foo.bar/baz",
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim:           newClaimWithLabels("claim-1", "1-1", "class-1", map[string]string{"team": "a", "track": "blue"}),
+			claimSelector:   "track=green",
+			expectedShould:  false,
+		},
+		{
+			name:            "claim without labels not matching selector",
+			provisionerName: "foo.bar/baz",
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim:           newClaim("claim-1", "1-1", "class-1", "", nil),
+			claimSelector:   "team in (a,b)",
+			expectedShould:  false,
+		},
 		{
 			name:            "claim already bound",
 			provisionerName: "foo.bar/baz",
@@ -299,6 +462,20 @@ func TestShouldProvision(t *testing.T) {
 			claim:           newClaim("claim-1", "1-1", "class-2", "", nil),
 			expectedShould:  false,
 		},
+		{
+			name:            "class in spec.storageClassName",
+			provisionerName: "foo.bar/baz",
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim:           newClaimWithStorageClassName("claim-1", "1-1", "class-1", nil),
+			expectedShould:  true,
+		},
+		{
+			name:            "spec.storageClassName takes precedence over annotation",
+			provisionerName: "foo.bar/baz",
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim:           newClaimWithStorageClassName("claim-1", "1-1", "class-1", map[string]string{annClass: "class-2"}),
+			expectedShould:  true,
+		},
 		{
 			name:            "not this provisioner's job",
 			provisionerName: "foo.bar/baz",
@@ -308,6 +485,22 @@ func TestShouldProvision(t *testing.T) {
 		},
 		// Kubernetes 1.5 provisioning - annStorageProvisioner is set
 		// and only this annotation is evaluated
+		{
+			name:            "claim of served class",
+			provisionerName: "foo.bar/baz",
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim:           newClaim("claim-1", "1-1", "class-1", "", nil),
+			storageClasses:  []string{"class-1", "class-2"},
+			expectedShould:  true,
+		},
+		{
+			name:            "claim of class served by another controller",
+			provisionerName: "foo.bar/baz",
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim:           newClaim("claim-1", "1-1", "class-1", "", nil),
+			storageClasses:  []string{"class-2"},
+			expectedShould:  false,
+		},
 		{
 			name:            "should provision 1.5",
 			provisionerName: "foo.bar/baz",
@@ -324,13 +517,49 @@ func TestShouldProvision(t *testing.T) {
 				map[string]string{annStorageProvisioner: "abc.def/ghi"}),
 			expectedShould: false,
 		},
+		{
+			name:            "qualifier says should provision",
+			provisionerName: "foo.bar/baz",
+			provisioner:     newQualifierTestProvisioner(true),
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim:           newClaim("claim-1", "1-1", "class-1", "", nil),
+			expectedShould:  true,
+		},
+		{
+			name:            "qualifier says shouldn't provision",
+			provisionerName: "foo.bar/baz",
+			provisioner:     newQualifierTestProvisioner(false),
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim:           newClaim("claim-1", "1-1", "class-1", "", nil),
+			expectedShould:  false,
+		},
+		{
+			name:            "qualifier says shouldn't provision 1.5",
+			provisionerName: "foo.bar/baz",
+			provisioner:     newQualifierTestProvisioner(false),
+			class:           newStorageClass("class-1", "foo.bar/baz"),
+			claim: newClaim("claim-1", "1-1", "class-1", "",
+				map[string]string{annStorageProvisioner: "foo.bar/baz"}),
+			expectedShould: false,
+		},
 	}
 	for _, test := range tests {
 		client := fake.NewSimpleClientset(test.claim)
-		provisioner := newTestProvisioner()
+		provisioner := test.provisioner
+		if provisioner == nil {
+			provisioner = newTestProvisioner()
+		}
 		ctrl := newTestProvisionController(client, test.provisionerName, provisioner, "v1.5.0")
+		WatchNamespaces(test.watchNamespaces)(ctrl)
+		DenyNamespaces(test.denyNamespaces)(ctrl)
+		selector, err := labels.Parse(test.claimSelector)
+		if err != nil {
+			t.Fatalf("%s: error parsing selector: %v", test.name, err)
+		}
+		ClaimSelector(selector)(ctrl)
+		StorageClasses(test.storageClasses)(ctrl)
 
-		err := ctrl.classes.Add(test.class)
+		err = ctrl.classes.Add(test.class)
 		if err != nil {
 			t.Logf("test case: %s", test.name)
 			t.Errorf("error adding class %v to cache: %v", test.class, err)
@@ -344,12 +573,434 @@ func TestShouldProvision(t *testing.T) {
 	}
 }
 
+func TestStorageClassNodeSelectorTerms(t *testing.T) {
+	tests := []struct {
+		name          string
+		raw           string
+		expectedTerms []v1.NodeSelectorTerm
+	}{
+		{
+			name:          "no allowed topologies",
+			raw:           `{"kind":"StorageClass","provisioner":"foo.bar/baz"}`,
+			expectedTerms: nil,
+		},
+		{
+			name: "two allowed zones",
+			raw: `{"kind":"StorageClass","provisioner":"foo.bar/baz","allowedTopologies":[` +
+				`{"matchLabelExpressions":[{"key":"failure-domain.beta.kubernetes.io/zone","values":["zone-a"]}]},` +
+				`{"matchLabelExpressions":[{"key":"failure-domain.beta.kubernetes.io/zone","values":["zone-b","zone-c"]}]}]}`,
+			expectedTerms: []v1.NodeSelectorTerm{
+				{
+					MatchExpressions: []v1.NodeSelectorRequirement{
+						{Key: "failure-domain.beta.kubernetes.io/zone", Operator: v1.NodeSelectorOpIn, Values: []string{"zone-a"}},
+					},
+				},
+				{
+					MatchExpressions: []v1.NodeSelectorRequirement{
+						{Key: "failure-domain.beta.kubernetes.io/zone", Operator: v1.NodeSelectorOpIn, Values: []string{"zone-b", "zone-c"}},
+					},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		class := &rawStorageClass{}
+		if err := json.Unmarshal([]byte(test.raw), class); err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("error decoding class: %v", err)
+			continue
+		}
+
+		terms := class.nodeSelectorTerms()
+		if !reflect.DeepEqual(test.expectedTerms, terms) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected terms %v but got %v\n", test.expectedTerms, terms)
+		}
+	}
+}
+
+func TestStorageClassReclaimPolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		raw            string
+		defaultPolicy  v1.PersistentVolumeReclaimPolicy
+		expectedPolicy v1.PersistentVolumeReclaimPolicy
+	}{
+		{
+			name:           "no reclaim policy",
+			raw:            `{"kind":"StorageClass","provisioner":"foo.bar/baz"}`,
+			defaultPolicy:  v1.PersistentVolumeReclaimRetain,
+			expectedPolicy: v1.PersistentVolumeReclaimRetain,
+		},
+		{
+			name:           "retain",
+			raw:            `{"kind":"StorageClass","provisioner":"foo.bar/baz","reclaimPolicy":"Retain"}`,
+			defaultPolicy:  v1.PersistentVolumeReclaimDelete,
+			expectedPolicy: v1.PersistentVolumeReclaimRetain,
+		},
+		{
+			name:           "delete",
+			raw:            `{"kind":"StorageClass","provisioner":"foo.bar/baz","reclaimPolicy":"Delete"}`,
+			defaultPolicy:  v1.PersistentVolumeReclaimRetain,
+			expectedPolicy: v1.PersistentVolumeReclaimDelete,
+		},
+	}
+	for _, test := range tests {
+		class := &rawStorageClass{}
+		if err := json.Unmarshal([]byte(test.raw), class); err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("error decoding class: %v", err)
+			continue
+		}
+
+		policy := class.reclaimPolicy(test.defaultPolicy)
+		if policy != test.expectedPolicy {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected reclaim policy %v but got %v\n", test.expectedPolicy, policy)
+		}
+	}
+}
+
+func TestForgetRawStorageClass(t *testing.T) {
+	tests := []struct {
+		name          string
+		update        bool
+		newVersion    string
+		expectedCache bool
+	}{
+		{
+			name:          "resync",
+			update:        true,
+			newVersion:    "1",
+			expectedCache: true,
+		},
+		{
+			name:          "class updated",
+			update:        true,
+			newVersion:    "2",
+			expectedCache: false,
+		},
+		{
+			name:          "class deleted",
+			expectedCache: false,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset()
+		ctrl := newTestProvisionController(client, "foo.bar/baz", newTestProvisioner(), "v1.8.0")
+		ctrl.rawClasses["class-1"] = &rawStorageClass{ReclaimPolicy: "Retain"}
+
+		oldClass := newStorageClass("class-1", "foo.bar/baz")
+		oldClass.ResourceVersion = "1"
+		if test.update {
+			newClass := newStorageClass("class-1", "foo.bar/baz")
+			newClass.ResourceVersion = test.newVersion
+			ctrl.updateClass(oldClass, newClass)
+		} else {
+			ctrl.deleteClass(cache.DeletedFinalStateUnknown{Key: "class-1", Obj: oldClass})
+		}
+
+		_, cached := ctrl.rawClasses["class-1"]
+		if cached != test.expectedCache {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected raw class cached %v but got %v", test.expectedCache, cached)
+		}
+	}
+}
+
+func TestDecodeDataSourceRef(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         string
+		expectedRef *DataSourceRef
+	}{
+		{
+			name:        "no data source",
+			raw:         `{"kind":"PersistentVolumeClaim","spec":{}}`,
+			expectedRef: nil,
+		},
+		{
+			name:        "clone of a claim",
+			raw:         `{"kind":"PersistentVolumeClaim","spec":{"dataSourceRef":{"kind":"PersistentVolumeClaim","name":"claim-2"}}}`,
+			expectedRef: &DataSourceRef{Kind: "PersistentVolumeClaim", Name: "claim-2"},
+		},
+		{
+			name:        "restore of a snapshot",
+			raw:         `{"kind":"PersistentVolumeClaim","spec":{"dataSourceRef":{"apiGroup":"snapshot.storage.k8s.io","kind":"VolumeSnapshot","name":"snapshot-1"}}}`,
+			expectedRef: &DataSourceRef{APIGroup: "snapshot.storage.k8s.io", Kind: "VolumeSnapshot", Name: "snapshot-1"},
+		},
+		{
+			name:        "clone of a claim in another namespace",
+			raw:         `{"kind":"PersistentVolumeClaim","spec":{"dataSourceRef":{"kind":"PersistentVolumeClaim","name":"claim-2","namespace":"ns-2"}}}`,
+			expectedRef: &DataSourceRef{Kind: "PersistentVolumeClaim", Name: "claim-2", Namespace: "ns-2"},
+		},
+		{
+			name:        "custom resource",
+			raw:         `{"kind":"PersistentVolumeClaim","spec":{"dataSourceRef":{"apiGroup":"foo.bar","kind":"Baz","name":"baz-1"}}}`,
+			expectedRef: &DataSourceRef{APIGroup: "foo.bar", Kind: "Baz", Name: "baz-1"},
+		},
+		{
+			name:        "custom resource in another namespace",
+			raw:         `{"kind":"PersistentVolumeClaim","spec":{"dataSourceRef":{"apiGroup":"foo.bar","kind":"Baz","name":"baz-1","namespace":"ns-2"}}}`,
+			expectedRef: &DataSourceRef{APIGroup: "foo.bar", Kind: "Baz", Name: "baz-1", Namespace: "ns-2"},
+		},
+	}
+	for _, test := range tests {
+		ref, err := decodeDataSourceRef([]byte(test.raw))
+		if err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("error decoding dataSourceRef: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(test.expectedRef, ref) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected dataSourceRef %+v but got %+v\n", test.expectedRef, ref)
+		}
+	}
+}
+
+func TestReferenceGranted(t *testing.T) {
+	claimRef := &DataSourceRef{Kind: "PersistentVolumeClaim", Name: "claim-2", Namespace: "ns-2"}
+	tests := []struct {
+		name            string
+		raw             string
+		ref             *DataSourceRef
+		expectedGranted bool
+	}{
+		{
+			name:            "no grants",
+			raw:             `{"items":[]}`,
+			ref:             claimRef,
+			expectedGranted: false,
+		},
+		{
+			name:            "grant for all claims",
+			raw:             `{"items":[{"spec":{"from":[{"group":"","kind":"PersistentVolumeClaim","namespace":"default"}],"to":[{"group":"","kind":"PersistentVolumeClaim"}]}}]}`,
+			ref:             claimRef,
+			expectedGranted: true,
+		},
+		{
+			name:            "grant for the claim by name",
+			raw:             `{"items":[{"spec":{"from":[{"group":"","kind":"PersistentVolumeClaim","namespace":"default"}],"to":[{"group":"","kind":"PersistentVolumeClaim","name":"claim-2"}]}}]}`,
+			ref:             claimRef,
+			expectedGranted: true,
+		},
+		{
+			name:            "grant for another claim",
+			raw:             `{"items":[{"spec":{"from":[{"group":"","kind":"PersistentVolumeClaim","namespace":"default"}],"to":[{"group":"","kind":"PersistentVolumeClaim","name":"claim-3"}]}}]}`,
+			ref:             claimRef,
+			expectedGranted: false,
+		},
+		{
+			name:            "grant to claims in another namespace",
+			raw:             `{"items":[{"spec":{"from":[{"group":"","kind":"PersistentVolumeClaim","namespace":"ns-3"}],"to":[{"group":"","kind":"PersistentVolumeClaim"}]}}]}`,
+			ref:             claimRef,
+			expectedGranted: false,
+		},
+		{
+			name:            "grant to gateways",
+			raw:             `{"items":[{"spec":{"from":[{"group":"gateway.networking.k8s.io","kind":"Gateway","namespace":"default"}],"to":[{"group":"","kind":"PersistentVolumeClaim"}]}}]}`,
+			ref:             claimRef,
+			expectedGranted: false,
+		},
+		{
+			name:            "grant for claims but not snapshots",
+			raw:             `{"items":[{"spec":{"from":[{"group":"","kind":"PersistentVolumeClaim","namespace":"default"}],"to":[{"group":"","kind":"PersistentVolumeClaim"}]}}]}`,
+			ref:             &DataSourceRef{APIGroup: "snapshot.storage.k8s.io", Kind: "VolumeSnapshot", Name: "snapshot-1", Namespace: "ns-2"},
+			expectedGranted: false,
+		},
+		{
+			name:            "second grant for snapshots",
+			raw:             `{"items":[{"spec":{"from":[{"group":"","kind":"PersistentVolumeClaim","namespace":"default"}],"to":[{"group":"","kind":"PersistentVolumeClaim"}]}},{"spec":{"from":[{"group":"","kind":"PersistentVolumeClaim","namespace":"default"}],"to":[{"group":"snapshot.storage.k8s.io","kind":"VolumeSnapshot"}]}}]}`,
+			ref:             &DataSourceRef{APIGroup: "snapshot.storage.k8s.io", Kind: "VolumeSnapshot", Name: "snapshot-1", Namespace: "ns-2"},
+			expectedGranted: true,
+		},
+	}
+	for _, test := range tests {
+		granted, err := referenceGranted([]byte(test.raw), "default", test.ref)
+		if err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("error checking ReferenceGrants: %v", err)
+			continue
+		}
+		if test.expectedGranted != granted {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected granted %v but got %v", test.expectedGranted, granted)
+		}
+	}
+}
+
+func TestDeleteThreadiness(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctrl := NewProvisionController(client, "foo.bar/baz", newTestProvisioner(), "v1.5.0", Threadiness(1), DeleteThreadiness(1))
+
+	// With all the operation slots taken, e.g. by provisioning, a Delete
+	// operation still runs in its own
+	ctrl.operationSlots <- struct{}{}
+	defer func() { <-ctrl.operationSlots }()
+
+	ran := make(chan struct{})
+	ctrl.scheduleDeleteOperation("delete-pv-1", func() error {
+		close(ran)
+		return nil
+	})
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Errorf("expected delete operation to run")
+	}
+
+	// A negative value is rejected and the pool kept
+	slots := ctrl.deleteSlots
+	if err := DeleteThreadiness(-1)(ctrl); err == nil {
+		t.Errorf("expected error setting negative delete threadiness but got none")
+	}
+	if ctrl.deleteSlots != slots {
+		t.Errorf("expected delete slots to be kept after rejecting negative delete threadiness")
+	}
+}
+
+func TestMaxVolumesPerNamespace(t *testing.T) {
+	tests := []struct {
+		name                   string
+		maxVolumesPerNamespace int
+		volumes                []*v1.PersistentVolume
+		provisioning           int
+		expectedReserved       bool
+	}{
+		{
+			name:                   "no limit",
+			maxVolumesPerNamespace: 0,
+			volumes:                []*v1.PersistentVolume{newVolumeForClaim("volume-1", v1.NamespaceDefault)},
+			expectedReserved:       true,
+		},
+		{
+			name:                   "below limit",
+			maxVolumesPerNamespace: 2,
+			volumes:                []*v1.PersistentVolume{newVolumeForClaim("volume-1", v1.NamespaceDefault)},
+			expectedReserved:       true,
+		},
+		{
+			name:                   "at limit",
+			maxVolumesPerNamespace: 1,
+			volumes:                []*v1.PersistentVolume{newVolumeForClaim("volume-1", v1.NamespaceDefault)},
+			expectedReserved:       false,
+		},
+		{
+			name:                   "at limit with volume being provisioned",
+			maxVolumesPerNamespace: 2,
+			volumes:                []*v1.PersistentVolume{newVolumeForClaim("volume-1", v1.NamespaceDefault)},
+			provisioning:           1,
+			expectedReserved:       false,
+		},
+		{
+			name:                   "volume being provisioned in the cache counts once",
+			maxVolumesPerNamespace: 2,
+			volumes:                []*v1.PersistentVolume{newVolumeForClaim("provisioning-0", v1.NamespaceDefault)},
+			provisioning:           1,
+			expectedReserved:       true,
+		},
+		{
+			name:                   "volumes of other namespaces don't count",
+			maxVolumesPerNamespace: 1,
+			volumes:                []*v1.PersistentVolume{newVolumeForClaim("volume-1", "other")},
+			expectedReserved:       true,
+		},
+		{
+			name:                   "volumes of other provisioners don't count",
+			maxVolumesPerNamespace: 1,
+			volumes: []*v1.PersistentVolume{
+				newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "abc.def/ghi"}),
+			},
+			expectedReserved: true,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset()
+		ctrl := newTestProvisionController(client, "foo.bar/baz", newTestProvisioner(), "v1.5.0")
+		MaxVolumesPerNamespace(test.maxVolumesPerNamespace)(ctrl)
+		for _, volume := range test.volumes {
+			ctrl.volumes.Add(volume)
+		}
+		for i := 0; i < test.provisioning; i++ {
+			ctrl.reserveNamespaceVolume(v1.NamespaceDefault, fmt.Sprintf("provisioning-%d", i))
+		}
+
+		reserved := ctrl.reserveNamespaceVolume(v1.NamespaceDefault, "volume-2")
+		if reserved != test.expectedReserved {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected reserved %v but got %v", test.expectedReserved, reserved)
+		}
+
+		// Once the volumes being provisioned are done, they no longer count
+		if reserved {
+			ctrl.releaseNamespaceVolume("volume-2")
+		}
+		for i := 0; i < test.provisioning; i++ {
+			ctrl.releaseNamespaceVolume(fmt.Sprintf("provisioning-%d", i))
+		}
+		if len(ctrl.provisioningVolumes) != 0 {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected no volumes being provisioned but got %v", ctrl.provisioningVolumes)
+		}
+	}
+}
+
+func TestMaxVolumesPerNamespaceConcurrent(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	ctrl := newTestProvisionController(client, "foo.bar/baz", newTestProvisioner(), "v1.5.0")
+	MaxVolumesPerNamespace(1)(ctrl)
+	ctrl.classes.Add(newStorageClass("class-1", "foo.bar/baz"))
+	var claims []*v1.PersistentVolumeClaim
+	for i := 0; i < 3; i++ {
+		claim := newClaim(fmt.Sprintf("claim-%d", i), fmt.Sprintf("uid-%d", i), "class-1", "", nil)
+		client.Core().PersistentVolumeClaims(claim.Namespace).Create(claim)
+		claims = append(claims, claim)
+	}
+	provision := func() {
+		var wg sync.WaitGroup
+		for _, claim := range claims {
+			wg.Add(1)
+			go func(claim *v1.PersistentVolumeClaim) {
+				defer wg.Done()
+				ctrl.provisionClaimOperation(claim)
+			}(claim)
+		}
+		wg.Wait()
+	}
+
+	// The claims are provisioned for concurrently, and again as if retried,
+	// before the volumes cache gets the volume provisioned for one of them:
+	// that volume still counts
+	provision()
+	provision()
+	volumes, _ := client.Core().PersistentVolumes().List(metav1.ListOptions{})
+	if len(volumes.Items) != 1 {
+		t.Fatalf("expected 1 volume provisioned but got %d", len(volumes.Items))
+	}
+
+	// Once the cache gets it, it's counted there instead
+	volume := &volumes.Items[0]
+	ctrl.volumes.Add(volume)
+	ctrl.addVolume(volume)
+	if len(ctrl.provisioningVolumes) != 0 {
+		t.Errorf("expected no volumes being provisioned but got %v", ctrl.provisioningVolumes)
+	}
+	provision()
+	volumes, _ = client.Core().PersistentVolumes().List(metav1.ListOptions{})
+	if len(volumes.Items) != 1 {
+		t.Errorf("expected 1 volume provisioned but got %d", len(volumes.Items))
+	}
+}
+
 func TestShouldDelete(t *testing.T) {
 	tests := []struct {
 		name             string
 		provisionerName  string
 		volume           *v1.PersistentVolume
 		serverGitVersion string
+		denyNamespaces   []string
+		storageClasses   []string
 		expectedShould   bool
 	}{
 		{
@@ -387,6 +1038,30 @@ func TestShouldDelete(t *testing.T) {
 			serverGitVersion: "v1.5.0",
 			expectedShould:   false,
 		},
+		{
+			name:             "claim in denied namespace",
+			provisionerName:  "foo.bar/baz",
+			volume:           newVolumeForClaim("volume-1", v1.NamespaceDefault),
+			serverGitVersion: "v1.5.0",
+			denyNamespaces:   []string{v1.NamespaceDefault},
+			expectedShould:   false,
+		},
+		{
+			name:             "claim in another namespace than the denied one",
+			provisionerName:  "foo.bar/baz",
+			volume:           newVolumeForClaim("volume-1", "tenant-1"),
+			serverGitVersion: "v1.5.0",
+			denyNamespaces:   []string{v1.NamespaceDefault},
+			expectedShould:   true,
+		},
+		{
+			name:             "volume of class served by another controller",
+			provisionerName:  "foo.bar/baz",
+			volume:           newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}),
+			serverGitVersion: "v1.5.0",
+			storageClasses:   []string{"class-2"},
+			expectedShould:   false,
+		},
 		{
 			name:             "not this provisioner's job",
 			provisionerName:  "foo.bar/baz",
@@ -399,6 +1074,8 @@ func TestShouldDelete(t *testing.T) {
 		client := fake.NewSimpleClientset()
 		provisioner := newTestProvisioner()
 		ctrl := newTestProvisionController(client, test.provisionerName, provisioner, test.serverGitVersion)
+		DenyNamespaces(test.denyNamespaces)(ctrl)
+		StorageClasses(test.storageClasses)(ctrl)
 
 		should := ctrl.shouldDelete(test.volume)
 		if test.expectedShould != should {
@@ -408,6 +1085,254 @@ func TestShouldDelete(t *testing.T) {
 	}
 }
 
+func TestDeleteProtectedVolume(t *testing.T) {
+	tests := []struct {
+		name                string
+		volume              *v1.PersistentVolume
+		verbs               []string
+		reaction            testclient.ReactionFunc
+		expectedDeleteCalls int
+		expectedVolumes     int
+	}{
+		{
+			name:                "delete volume-1",
+			volume:              newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}),
+			expectedDeleteCalls: 1,
+			expectedVolumes:     0,
+		},
+		{
+			name:                "delete terminating volume-1's storage once and leave the pv to its finalizer",
+			volume:              newTerminatingVolume("volume-1", v1.VolumeReleased),
+			expectedDeleteCalls: 1,
+			expectedVolumes:     1,
+		},
+		{
+			name:                "don't delete terminating volume-1's storage because it's still bound",
+			volume:              newTerminatingVolume("volume-1", v1.VolumeBound),
+			expectedDeleteCalls: 0,
+			expectedVolumes:     1,
+		},
+		{
+			name:   "delete volume-1's storage once even though deleting the pv object fails",
+			volume: newVolume("volume-1", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}),
+			verbs:  []string{"delete"},
+			reaction: func(action testclient.Action) (handled bool, ret runtime.Object, err error) {
+				return true, nil, errors.New("fake error")
+			},
+			expectedDeleteCalls: 1,
+			expectedVolumes:     1,
+		},
+	}
+	for _, test := range tests {
+		test.volume.UID = "uid-1"
+		client := fake.NewSimpleClientset(test.volume)
+		for _, v := range test.verbs {
+			client.Fake.PrependReactor(v, "persistentvolumes", test.reaction)
+		}
+		provisioner := newTestProvisioner()
+		ctrl := newTestProvisionController(client, "foo.bar/baz", provisioner, "v1.5.0")
+
+		// Every resync, the volume is seen again as it was
+		for i := 0; i < 3; i++ {
+			if ctrl.shouldDelete(test.volume) {
+				ctrl.deleteVolumeOperation(test.volume)
+			}
+		}
+
+		if test.expectedDeleteCalls != provisioner.deleteCalls {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected %d delete calls but got %d", test.expectedDeleteCalls, provisioner.deleteCalls)
+		}
+		pvList, _ := client.Core().PersistentVolumes().List(metav1.ListOptions{})
+		if test.expectedVolumes != len(pvList.Items) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected %d PVs but got %d", test.expectedVolumes, len(pvList.Items))
+		}
+
+		// Once the PV is gone for good, it's forgotten
+		ctrl.deleteVolume(test.volume)
+		if ctrl.isStorageDeleted(test.volume) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected deleted volume to be forgotten")
+		}
+	}
+}
+
+func TestShouldExpand(t *testing.T) {
+	tests := []struct {
+		name             string
+		provisioner      Provisioner
+		claim            *v1.PersistentVolumeClaim
+		volume           *v1.PersistentVolume
+		serverGitVersion string
+		expectedShould   bool
+	}{
+		{
+			name:             "provisioner can't expand",
+			provisioner:      newTestProvisioner(),
+			claim:            newBoundClaim("claim-1", "volume-1", "2Mi", "1Mi"),
+			volume:           newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}),
+			serverGitVersion: "v1.11.0",
+			expectedShould:   false,
+		},
+		{
+			name:             "1.10: expansion isn't beta yet",
+			provisioner:      newExpanderTestProvisioner(nil),
+			claim:            newBoundClaim("claim-1", "volume-1", "2Mi", "1Mi"),
+			volume:           newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}),
+			serverGitVersion: "v1.10.0",
+			expectedShould:   false,
+		},
+		{
+			name:             "claim requests no more than it has",
+			provisioner:      newExpanderTestProvisioner(nil),
+			claim:            newBoundClaim("claim-1", "volume-1", "1Mi", "1Mi"),
+			volume:           newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}),
+			serverGitVersion: "v1.11.0",
+			expectedShould:   false,
+		},
+		{
+			name:             "claim not bound",
+			provisioner:      newExpanderTestProvisioner(nil),
+			claim:            newClaim("claim-1", "uid-1-1", "class-1", "", nil),
+			volume:           newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"}),
+			serverGitVersion: "v1.11.0",
+			expectedShould:   false,
+		},
+		{
+			name:             "not this provisioner's volume",
+			provisioner:      newExpanderTestProvisioner(nil),
+			claim:            newBoundClaim("claim-1", "volume-1", "2Mi", "1Mi"),
+			volume:           newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "abc.def/ghi"}),
+			serverGitVersion: "v1.11.0",
+			expectedShould:   false,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset()
+		ctrl := newTestProvisionController(client, "foo.bar/baz", test.provisioner, test.serverGitVersion)
+		ctrl.volumes.Add(test.volume)
+
+		should := ctrl.shouldExpand(test.claim)
+		if test.expectedShould != should {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected should expand %v but got %v\n", test.expectedShould, should)
+		}
+	}
+}
+
+func TestExpandClaimOperation(t *testing.T) {
+	tests := []struct {
+		name             string
+		claim            *v1.PersistentVolumeClaim
+		volumeCapacity   string
+		expandErr        error
+		updateErr        error
+		expectedCapacity string
+		expectedPatches  []string
+		expectedEvent    string
+		expectError      bool
+	}{
+		{
+			name:             "expand volume-1",
+			claim:            newBoundClaim("claim-1", "volume-1", "2Mi", "1Mi"),
+			volumeCapacity:   "1Mi",
+			expectedCapacity: "2Mi",
+			expectedPatches:  []string{`{"status":{"conditions":[{"status":"True","type":"Resizing"}]}}`, `{"status":{"capacity":{"storage":"2Mi"},"conditions":null}}`},
+			expectedEvent:    "VolumeResizeSuccessful",
+		},
+		{
+			name:             "volume-1 expanded already, only update the claim",
+			claim:            newBoundClaim("claim-1", "volume-1", "2Mi", "1Mi"),
+			volumeCapacity:   "2Mi",
+			expectedCapacity: "2Mi",
+			expectedPatches:  []string{`{"status":{"capacity":{"storage":"2Mi"},"conditions":null}}`},
+			expectedEvent:    "VolumeResizeSuccessful",
+		},
+		{
+			name:             "provisioner fails to expand volume-1: claim no longer resizing",
+			claim:            newBoundClaim("claim-1", "volume-1", "2Mi", "1Mi"),
+			volumeCapacity:   "1Mi",
+			expandErr:        errors.New("fake error"),
+			expectedCapacity: "1Mi",
+			expectedPatches: []string{
+				`{"status":{"conditions":[{"status":"True","type":"Resizing"}]}}`,
+				`{"status":{"conditions":[{"message":"Failed to expand volume volume-1 to 2Mi: fake error","reason":"VolumeResizeFailed","status":"False","type":"Resizing"}]}}`,
+			},
+			expectedEvent: "VolumeResizeFailed",
+			expectError:   true,
+		},
+		{
+			name:             "saving expanded volume-1 fails: claim no longer resizing",
+			claim:            newBoundClaim("claim-1", "volume-1", "2Mi", "1Mi"),
+			volumeCapacity:   "1Mi",
+			updateErr:        errors.New("fake error"),
+			expectedCapacity: "1Mi",
+			expectedPatches: []string{
+				`{"status":{"conditions":[{"status":"True","type":"Resizing"}]}}`,
+				`{"status":{"conditions":[{"message":"Failed to save expanded volume volume-1: fake error","reason":"VolumeResizeFailed","status":"False","type":"Resizing"}]}}`,
+			},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		volume := newVolume("volume-1", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"})
+		volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)] = resource.MustParse(test.volumeCapacity)
+		client := fake.NewSimpleClientset(volume)
+		patches := []string{}
+		client.PrependReactor("patch", "persistentvolumeclaims", func(action testclient.Action) (bool, runtime.Object, error) {
+			// Drop the condition's lastTransitionTime to compare the rest
+			patch := map[string]interface{}{}
+			json.Unmarshal(action.(testclient.PatchActionImpl).GetPatch(), &patch)
+			if conditions, ok := patch["status"].(map[string]interface{})["conditions"].([]interface{}); ok {
+				for _, condition := range conditions {
+					delete(condition.(map[string]interface{}), "lastTransitionTime")
+				}
+			}
+			raw, _ := json.Marshal(patch)
+			patches = append(patches, string(raw))
+			return true, test.claim, nil
+		})
+		if test.updateErr != nil {
+			client.PrependReactor("update", "persistentvolumes", func(action testclient.Action) (bool, runtime.Object, error) {
+				return true, nil, test.updateErr
+			})
+		}
+		ctrl := newTestProvisionController(client, "foo.bar/baz", newExpanderTestProvisioner(test.expandErr), "v1.11.0")
+		eventRecorder := record.NewFakeRecorder(1)
+		ctrl.eventRecorder = eventRecorder
+
+		err := ctrl.expandClaimOperation(test.claim)
+
+		if test.expectError != (err != nil) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected error %v but got %v", test.expectError, err)
+		}
+		if !reflect.DeepEqual(test.expectedPatches, patches) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected claim status patches %v but got %v", test.expectedPatches, patches)
+		}
+		newVolume, _ := client.Core().PersistentVolumes().Get("volume-1", metav1.GetOptions{})
+		capacity := newVolume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		if capacity.Cmp(resource.MustParse(test.expectedCapacity)) != 0 {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected volume capacity %s but got %s", test.expectedCapacity, capacity.String())
+		}
+		select {
+		case event := <-eventRecorder.Events:
+			if test.expectedEvent == "" || !strings.Contains(event, test.expectedEvent) {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected event %q but got %s", test.expectedEvent, event)
+			}
+		default:
+			if test.expectedEvent != "" {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected event %s but got none", test.expectedEvent)
+			}
+		}
+	}
+}
+
 func TestIsOnlyRecordUpdate(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -512,6 +1437,47 @@ func newClaim(name, claimUID, provisioner, volumeName string, annotations map[st
 	return claim
 }
 
+// newClaimWithLabels returns a claim with the given labels.
+func newClaimWithLabels(name, claimUID, provisioner string, claimLabels map[string]string) *v1.PersistentVolumeClaim {
+	claim := newClaim(name, claimUID, provisioner, "", nil)
+	claim.Labels = claimLabels
+	return claim
+}
+
+// newClaimWithStorageClassName returns a claim requesting its class in
+// spec.storageClassName rather than in the beta annotation.
+func newClaimWithStorageClassName(name, claimUID, class string, annotations map[string]string) *v1.PersistentVolumeClaim {
+	claim := newClaim(name, claimUID, class, "", nil)
+	delete(claim.Annotations, annClass)
+	claim.Spec.StorageClassName = &class
+	for k, v := range annotations {
+		claim.Annotations[k] = v
+	}
+	return claim
+}
+
+// newEphemeralClaim returns the claim created for the generic ephemeral volume
+// "scratch" of the given pod, owned by the pod. If deleting, the claim is being
+// deleted along with the pod.
+func newEphemeralClaim(podName, claimUID, class string, deleting bool) *v1.PersistentVolumeClaim {
+	claim := newClaim(podName+"-scratch", claimUID, class, "", nil)
+	isController := true
+	claim.OwnerReferences = []metav1.OwnerReference{
+		{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Name:       podName,
+			UID:        types.UID(podName + "-uid"),
+			Controller: &isController,
+		},
+	}
+	if deleting {
+		deletionTimestamp := metav1.Now()
+		claim.DeletionTimestamp = &deletionTimestamp
+	}
+	return claim
+}
+
 func newVolume(name string, phase v1.PersistentVolumePhase, policy v1.PersistentVolumeReclaimPolicy, annotations map[string]string) *v1.PersistentVolume {
 	pv := &v1.PersistentVolume{
 		ObjectMeta: metav1.ObjectMeta{
@@ -541,6 +1507,38 @@ func newVolume(name string, phase v1.PersistentVolumePhase, policy v1.Persistent
 	return pv
 }
 
+// newBoundClaim returns a claim of class-1 bound to the given volume, requesting
+// the given size and having the given capacity.
+func newBoundClaim(name, volumeName, request, capacity string) *v1.PersistentVolumeClaim {
+	claim := newClaim(name, "uid-1-1", "class-1", volumeName, nil)
+	claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)] = resource.MustParse(request)
+	claim.Status = v1.PersistentVolumeClaimStatus{
+		Phase: v1.ClaimBound,
+		Capacity: v1.ResourceList{
+			v1.ResourceName(v1.ResourceStorage): resource.MustParse(capacity),
+		},
+	}
+	return claim
+}
+
+// newVolumeForClaim returns a released volume provisioned by foo.bar/baz for a
+// claim in the given namespace.
+func newVolumeForClaim(name, claimNamespace string) *v1.PersistentVolume {
+	volume := newVolume(name, v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"})
+	volume.Spec.ClaimRef = &v1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: claimNamespace, Name: "claim-1"}
+	return volume
+}
+
+// newTerminatingVolume returns a volume provisioned by foo.bar/baz that has been
+// deleted but is held by the kubernetes.io/pv-protection finalizer.
+func newTerminatingVolume(name string, phase v1.PersistentVolumePhase) *v1.PersistentVolume {
+	volume := newVolume(name, phase, v1.PersistentVolumeReclaimDelete, map[string]string{annDynamicallyProvisioned: "foo.bar/baz"})
+	deletionTimestamp := metav1.Now()
+	volume.DeletionTimestamp = &deletionTimestamp
+	volume.Finalizers = []string{"kubernetes.io/pv-protection"}
+	return volume
+}
+
 // newProvisionedVolume returns the volume the test controller should provision for the
 // given claim with the given class
 func newProvisionedVolume(storageClass *storagebeta.StorageClass, claim *v1.PersistentVolumeClaim) *v1.PersistentVolume {
@@ -568,11 +1566,14 @@ func newProvisionedVolume(storageClass *storagebeta.StorageClass, claim *v1.Pers
 }
 
 func newTestProvisioner() *testProvisioner {
-	return &testProvisioner{make(chan bool, 16)}
+	return &testProvisioner{provisionCalls: make(chan bool, 16)}
 }
 
 type testProvisioner struct {
 	provisionCalls chan bool
+
+	deleteCallsMutex sync.Mutex
+	deleteCalls      int
 }
 
 var _ Provisioner = &testProvisioner{}
@@ -611,9 +1612,48 @@ func (p *testProvisioner) Provision(options VolumeOptions) (*v1.PersistentVolume
 }
 
 func (p *testProvisioner) Delete(volume *v1.PersistentVolume) error {
+	p.deleteCallsMutex.Lock()
+	p.deleteCalls++
+	p.deleteCallsMutex.Unlock()
 	return nil
 }
 
+func newQualifierTestProvisioner(answer bool) *qualifierTestProvisioner {
+	return &qualifierTestProvisioner{newTestProvisioner(), answer}
+}
+
+type qualifierTestProvisioner struct {
+	*testProvisioner
+	answer bool
+}
+
+var _ Provisioner = &qualifierTestProvisioner{}
+var _ Qualifier = &qualifierTestProvisioner{}
+
+func (p *qualifierTestProvisioner) ShouldProvision(claim *v1.PersistentVolumeClaim) bool {
+	return p.answer
+}
+
+func newExpanderTestProvisioner(err error) *expanderTestProvisioner {
+	return &expanderTestProvisioner{newTestProvisioner(), err}
+}
+
+type expanderTestProvisioner struct {
+	*testProvisioner
+	err error
+}
+
+var _ Provisioner = &expanderTestProvisioner{}
+var _ Expander = &expanderTestProvisioner{}
+
+func (p *expanderTestProvisioner) ExpandVolume(volume *v1.PersistentVolume, size resource.Quantity) (*v1.PersistentVolume, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)] = size
+	return volume, nil
+}
+
 func newBadTestProvisioner() Provisioner {
 	return &badTestProvisioner{}
 }
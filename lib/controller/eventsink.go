@@ -0,0 +1,171 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	eventsPath = "/apis/events.k8s.io/v1"
+
+	// RFC3339 with microseconds, the format of events.k8s.io/v1 Events' times
+	rfc3339Micro = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// eventsV1Sink is a record.EventSink writing the events the broadcaster
+// records as events.k8s.io/v1 Events, the successor of core v1 Events, on
+// servers that have it, 1.19 and later. The vendored client-go has neither
+// the Event type nor a recorder for it, so core Events are converted.
+type eventsV1Sink struct {
+	client kubernetes.Interface
+	// reportingController and reportingInstance identify the controller
+	// recording the events, e.g. the provisioner's name and the controller's
+	// host and identity
+	reportingController string
+	reportingInstance   string
+}
+
+var _ record.EventSink = &eventsV1Sink{}
+
+// eventV1 is the subset of the events.k8s.io/v1 Event the sink writes.
+type eventV1 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	EventTime                string             `json:"eventTime"`
+	Series                   *eventSeries       `json:"series,omitempty"`
+	ReportingController      string             `json:"reportingController"`
+	ReportingInstance        string             `json:"reportingInstance"`
+	Action                   string             `json:"action"`
+	Reason                   string             `json:"reason"`
+	Regarding                v1.ObjectReference `json:"regarding"`
+	Note                     string             `json:"note,omitempty"`
+	Type                     string             `json:"type"`
+	DeprecatedSource         v1.EventSource     `json:"deprecatedSource,omitempty"`
+	DeprecatedFirstTimestamp metav1.Time        `json:"deprecatedFirstTimestamp,omitempty"`
+	DeprecatedLastTimestamp  metav1.Time        `json:"deprecatedLastTimestamp,omitempty"`
+	DeprecatedCount          int32              `json:"deprecatedCount,omitempty"`
+}
+
+type eventSeries struct {
+	Count            int32  `json:"count"`
+	LastObservedTime string `json:"lastObservedTime"`
+}
+
+// Create creates the event.
+func (s *eventsV1Sink) Create(event *v1.Event) (*v1.Event, error) {
+	body, err := json.Marshal(s.toEventV1(event))
+	if err != nil {
+		return nil, err
+	}
+	request := s.client.Core().RESTClient().Post().AbsPath(eventsPath, "namespaces", event.Namespace, "events")
+	return s.do(request, "application/json", body, event)
+}
+
+// Update replaces the event.
+func (s *eventsV1Sink) Update(event *v1.Event) (*v1.Event, error) {
+	body, err := json.Marshal(s.toEventV1(event))
+	if err != nil {
+		return nil, err
+	}
+	request := s.client.Core().RESTClient().Put().AbsPath(eventsPath, "namespaces", event.Namespace, "events", event.Name)
+	return s.do(request, "application/json", body, event)
+}
+
+// Patch records that the event happened again. The broadcaster's patch is of
+// the core Event's fields, so the sink patches the series the event's count
+// and last timestamp are instead.
+func (s *eventsV1Sink) Patch(event *v1.Event, data []byte) (*v1.Event, error) {
+	patch := map[string]interface{}{
+		"series":                  toSeries(event),
+		"note":                    event.Message,
+		"deprecatedLastTimestamp": event.LastTimestamp,
+		"deprecatedCount":         event.Count,
+	}
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	request := s.client.Core().RESTClient().Patch(types.MergePatchType).AbsPath(eventsPath, "namespaces", event.Namespace, "events", event.Name)
+	return s.do(request, string(types.MergePatchType), body, event)
+}
+
+// do sends the request and returns the core event with the metadata of the
+// event the server returns, for the broadcaster to correlate later events
+// with.
+func (s *eventsV1Sink) do(request *rest.Request, contentType string, body []byte, event *v1.Event) (*v1.Event, error) {
+	raw, err := request.SetHeader("Content-Type", contentType).Body(body).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	written := &eventV1{}
+	if err := json.Unmarshal(raw, written); err != nil {
+		return nil, fmt.Errorf("error decoding event %s/%s: %v", event.Namespace, event.Name, err)
+	}
+	result := *event
+	result.ObjectMeta = written.ObjectMeta
+	return &result, nil
+}
+
+func (s *eventsV1Sink) toEventV1(event *v1.Event) *eventV1 {
+	eventTime := event.FirstTimestamp.Time
+	if eventTime.IsZero() {
+		eventTime = time.Now()
+	}
+	e := &eventV1{
+		TypeMeta: metav1.TypeMeta{APIVersion: "events.k8s.io/v1", Kind: "Event"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            event.Name,
+			Namespace:       event.Namespace,
+			ResourceVersion: event.ResourceVersion,
+		},
+		EventTime:           eventTime.UTC().Format(rfc3339Micro),
+		ReportingController: s.reportingController,
+		ReportingInstance:   s.reportingInstance,
+		// the controller's reasons name what it was doing, e.g. Provisioning
+		Action:                   event.Reason,
+		Reason:                   event.Reason,
+		Regarding:                event.InvolvedObject,
+		Note:                     event.Message,
+		Type:                     event.Type,
+		DeprecatedSource:         event.Source,
+		DeprecatedFirstTimestamp: event.FirstTimestamp,
+		DeprecatedLastTimestamp:  event.LastTimestamp,
+		DeprecatedCount:          event.Count,
+	}
+	if event.Count > 1 {
+		e.Series = toSeries(event)
+	}
+	return e
+}
+
+func toSeries(event *v1.Event) *eventSeries {
+	return &eventSeries{
+		Count:            event.Count,
+		LastObservedTime: event.LastTimestamp.UTC().Format(rfc3339Micro),
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestToEventV1(t *testing.T) {
+	first := time.Date(2017, 6, 1, 12, 0, 0, 123456789, time.UTC)
+	sink := &eventsV1Sink{reportingController: "foo.bar/baz", reportingInstance: "host-1 uid-1"}
+	event := &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "claim-1.abc", Namespace: "default"},
+		InvolvedObject: v1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: "default", Name: "claim-1"},
+		Reason:         "ProvisioningFailed",
+		Message:        "failed",
+		Type:           v1.EventTypeWarning,
+		FirstTimestamp: metav1.NewTime(first),
+		LastTimestamp:  metav1.NewTime(first),
+		Count:          1,
+	}
+
+	e := sink.toEventV1(event)
+	if e.EventTime != "2017-06-01T12:00:00.123456Z" {
+		t.Errorf("expected event time with microseconds but got %s", e.EventTime)
+	}
+	if e.ReportingController != "foo.bar/baz" || e.ReportingInstance != "host-1 uid-1" {
+		t.Errorf("expected reporting controller and instance of the sink but got %q, %q", e.ReportingController, e.ReportingInstance)
+	}
+	if e.Regarding != event.InvolvedObject || e.Note != event.Message || e.Reason != event.Reason || e.Action == "" {
+		t.Errorf("expected event converted from %+v but got %+v", event, e)
+	}
+	if e.Series != nil {
+		t.Errorf("expected no series for a first event but got %+v", e.Series)
+	}
+
+	event.Count = 2
+	event.LastTimestamp = metav1.NewTime(first.Add(time.Minute))
+	e = sink.toEventV1(event)
+	if e.Series == nil || e.Series.Count != 2 || e.Series.LastObservedTime != "2017-06-01T12:01:00.123456Z" {
+		t.Errorf("expected series of 2 last observed a minute later but got %+v", e.Series)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// failureStreak is a run of failures of the same operation on the same object
+// whose events are being aggregated.
+type failureStreak struct {
+	// The number of failures, of the first and last and of the last one an
+	// event was recorded for
+	count                  int
+	first, last, lastEvent time.Time
+}
+
+// recordFailure records a Warning event with the given reason and message on
+// the given object, whose UID is uid, for a failed operation. Failures of the
+// same reason on the same object are aggregated: only the first of a streak is
+// recorded as is, then at most one event per failureEventInterval, saying how
+// many times it failed since and the last error, so that an operation retried
+// over and over doesn't record an event every time. A streak ends with
+// clearFailures, once the operation succeeds, or once it hasn't failed for
+// failureEventInterval.
+func (ctrl *ProvisionController) recordFailure(object runtime.Object, uid types.UID, reason, message string) {
+	if ctrl.failureEventInterval == 0 {
+		ctrl.eventRecorder.Event(object, v1.EventTypeWarning, reason, message)
+		return
+	}
+
+	now := time.Now()
+	key := string(uid) + "/" + reason
+	ctrl.failureStreaksMutex.Lock()
+	// Streaks of objects that stopped failing, e.g. because they were
+	// deleted, are forgotten
+	for k, streak := range ctrl.failureStreaks {
+		if now.Sub(streak.last) >= ctrl.failureEventInterval {
+			delete(ctrl.failureStreaks, k)
+		}
+	}
+	streak, ok := ctrl.failureStreaks[key]
+	if !ok {
+		ctrl.failureStreaks[key] = &failureStreak{count: 1, first: now, last: now, lastEvent: now}
+		ctrl.failureStreaksMutex.Unlock()
+		ctrl.eventRecorder.Event(object, v1.EventTypeWarning, reason, message)
+		return
+	}
+	streak.count++
+	streak.last = now
+	if now.Sub(streak.lastEvent) < ctrl.failureEventInterval {
+		ctrl.failureStreaksMutex.Unlock()
+		return
+	}
+	streak.lastEvent = now
+	count, since := streak.count, now.Sub(streak.first)
+	ctrl.failureStreaksMutex.Unlock()
+
+	since = since / time.Second * time.Second
+	ctrl.eventRecorder.Event(object, v1.EventTypeWarning, reason, fmt.Sprintf("Failed %d times in the last %v, last error: %s", count, since, message))
+}
+
+// clearFailures ends the streaks of failures with the given reasons of the
+// object whose UID is uid, e.g. once the operation succeeds, so that its next
+// failure is recorded as is.
+func (ctrl *ProvisionController) clearFailures(uid types.UID, reasons ...string) {
+	ctrl.failureStreaksMutex.Lock()
+	defer ctrl.failureStreaksMutex.Unlock()
+	for _, reason := range reasons {
+		delete(ctrl.failureStreaks, string(uid)+"/"+reason)
+	}
+}
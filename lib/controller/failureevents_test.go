@@ -0,0 +1,112 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRecordFailure(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	ctrl := &ProvisionController{
+		eventRecorder:        recorder,
+		failureEventInterval: 10 * time.Minute,
+		failureStreaks:       make(map[string]*failureStreak),
+		failureStreaksMutex:  &sync.Mutex{},
+	}
+	claim := newClaim("claim-1", "uid-1-1", "class-1", "", nil)
+	key := string(claim.UID) + "/ProvisioningFailed"
+	// ago moves the claim's streak back in time by d, as if it started, last
+	// failed and last recorded an event d earlier
+	ago := func(d time.Duration) {
+		streak := ctrl.failureStreaks[key]
+		streak.first = streak.first.Add(-d)
+		streak.last = streak.last.Add(-d)
+		streak.lastEvent = streak.lastEvent.Add(-d)
+	}
+
+	tests := []struct {
+		name          string
+		before        func()
+		message       string
+		expectedEvent string
+	}{
+		{
+			name:          "first failure is recorded as is",
+			message:       "error 1",
+			expectedEvent: "Warning ProvisioningFailed error 1",
+		},
+		{
+			name:    "failure within the interval is not recorded",
+			before:  func() { ago(time.Minute) },
+			message: "error 2",
+		},
+		{
+			name:          "failure after the interval is recorded with the count",
+			before:        func() { ago(9 * time.Minute) },
+			message:       "error 3",
+			expectedEvent: "Warning ProvisioningFailed Failed 3 times in the last 10m0s, last error: error 3",
+		},
+		{
+			name:          "failure after success is recorded as is",
+			before:        func() { ctrl.clearFailures(claim.UID, "ProvisioningFailed") },
+			message:       "error 4",
+			expectedEvent: "Warning ProvisioningFailed error 4",
+		},
+		{
+			name:          "failure after none for the interval is recorded as is",
+			before:        func() { ago(10 * time.Minute) },
+			message:       "error 5",
+			expectedEvent: "Warning ProvisioningFailed error 5",
+		},
+	}
+	for _, test := range tests {
+		if test.before != nil {
+			test.before()
+		}
+		ctrl.recordFailure(claim, claim.UID, "ProvisioningFailed", test.message)
+		event := ""
+		select {
+		case event = <-recorder.Events:
+		default:
+		}
+		if event != test.expectedEvent {
+			t.Errorf("test case: %s: expected event %q but got %q", test.name, test.expectedEvent, event)
+		}
+	}
+
+	// Failures of other objects and reasons are streaks of their own, and
+	// with no interval every failure is recorded
+	ctrl.recordFailure(claim, types.UID("uid-2"), "ProvisioningFailed", "error")
+	ctrl.recordFailure(claim, claim.UID, "VolumeResizeFailed", "error")
+	ctrl.failureEventInterval = 0
+	ctrl.recordFailure(claim, claim.UID, "ProvisioningFailed", "error")
+	if events := len(recorder.Events); events != 3 {
+		t.Errorf("expected 3 events but got %d", events)
+	}
+	for len(recorder.Events) > 0 {
+		if event := <-recorder.Events; event[:len(v1.EventTypeWarning)] != v1.EventTypeWarning {
+			t.Errorf("unexpected event %q", event)
+		}
+	}
+}
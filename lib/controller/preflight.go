@@ -0,0 +1,115 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+	authorization "k8s.io/client-go/pkg/apis/authorization/v1"
+)
+
+// permission is an API access the controller needs.
+type permission struct {
+	group      string
+	resource   string
+	verb       string
+	namespaced bool
+}
+
+// requiredPermissions are the accesses the controller can't provision and
+// delete volumes without.
+var requiredPermissions = []permission{
+	{"", "persistentvolumeclaims", "get", true},
+	{"", "persistentvolumeclaims", "list", true},
+	{"", "persistentvolumeclaims", "watch", true},
+	{"", "persistentvolumes", "list", false},
+	{"", "persistentvolumes", "watch", false},
+	{"", "persistentvolumes", "create", false},
+	{"", "persistentvolumes", "delete", false},
+	{"storage.k8s.io", "storageclasses", "get", false},
+	{"storage.k8s.io", "storageclasses", "list", false},
+	{"storage.k8s.io", "storageclasses", "watch", false},
+	{"", "events", "create", true},
+}
+
+func (p permission) String() string {
+	resource := p.resource
+	if p.group != "" {
+		resource = p.resource + "." + p.group
+	}
+	return p.verb + " " + resource
+}
+
+// MissingPermissionsError is the error CheckPermissions returns to list the
+// permissions the controller is missing.
+type MissingPermissionsError struct {
+	Missing []string
+}
+
+func (e *MissingPermissionsError) Error() string {
+	return fmt.Sprintf("missing permissions to %s; grant them with RBAC rules, e.g. of the provisioner's ClusterRole bound to its service account", strings.Join(e.Missing, ", "))
+}
+
+// CheckPermissions checks with SelfSubjectAccessReviews that the client's user,
+// e.g. the provisioner's service account, has the permissions the controller
+// needs, in the given namespaces or in all namespaces if none are given, so
+// that missing RBAC rules are reported at startup rather than as failures deep
+// in the resync loop. It returns a MissingPermissionsError listing all the
+// permissions that are missing, or the error of the first review that fails,
+// e.g. because the server doesn't support them.
+func CheckPermissions(client kubernetes.Interface, namespaces []string) error {
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	var missing []string
+	for _, p := range requiredPermissions {
+		scopes := []string{""}
+		if p.namespaced {
+			scopes = namespaces
+		}
+		for _, namespace := range scopes {
+			review := &authorization.SelfSubjectAccessReview{
+				Spec: authorization.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorization.ResourceAttributes{
+						Namespace: namespace,
+						Verb:      p.verb,
+						Group:     p.group,
+						Resource:  p.resource,
+					},
+				},
+			}
+			result, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(review)
+			if err != nil {
+				return fmt.Errorf("error reviewing permission to %s: %v", p, err)
+			}
+			if result.Status.Allowed {
+				continue
+			}
+			if namespace == "" {
+				missing = append(missing, p.String())
+			} else {
+				missing = append(missing, fmt.Sprintf("%s in namespace %s", p, namespace))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingPermissionsError{Missing: missing}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	authorization "k8s.io/client-go/pkg/apis/authorization/v1"
+	testclient "k8s.io/client-go/testing"
+)
+
+func TestCheckPermissions(t *testing.T) {
+	tests := []struct {
+		name          string
+		namespaces    []string
+		denied        map[string]bool
+		reviewErr     error
+		expectedError string
+	}{
+		{
+			name: "all permissions",
+		},
+		{
+			name:          "missing permissions",
+			denied:        map[string]bool{"/persistentvolumes/delete": true, "/events/create": true},
+			expectedError: "missing permissions to delete persistentvolumes, create events; grant them with RBAC rules, e.g. of the provisioner's ClusterRole bound to its service account",
+		},
+		{
+			name:          "missing permissions in namespace",
+			namespaces:    []string{"tenant-a", "tenant-b"},
+			denied:        map[string]bool{"tenant-b/persistentvolumeclaims/watch": true, "/storageclasses/list": true},
+			expectedError: "missing permissions to watch persistentvolumeclaims in namespace tenant-b, list storageclasses.storage.k8s.io; grant them with RBAC rules, e.g. of the provisioner's ClusterRole bound to its service account",
+		},
+		{
+			name:          "review fails",
+			reviewErr:     errors.New("not found"),
+			expectedError: "error reviewing permission to get persistentvolumeclaims: not found",
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset()
+		client.Fake.PrependReactor("create", "selfsubjectaccessreviews", func(action testclient.Action) (handled bool, ret runtime.Object, err error) {
+			if test.reviewErr != nil {
+				return true, &authorization.SelfSubjectAccessReview{}, test.reviewErr
+			}
+			review := action.(testclient.CreateAction).GetObject().(*authorization.SelfSubjectAccessReview)
+			attributes := review.Spec.ResourceAttributes
+			review.Status.Allowed = !test.denied[attributes.Namespace+"/"+attributes.Resource+"/"+attributes.Verb]
+			return true, review, nil
+		})
+
+		err := CheckPermissions(client, test.namespaces)
+		if test.expectedError == "" && err != nil {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error: %v", err)
+		} else if test.expectedError != "" && (err == nil || err.Error() != test.expectedError) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected error %q but got %v", test.expectedError, err)
+		}
+	}
+}
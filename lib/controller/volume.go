@@ -19,6 +19,7 @@ package controller
 import (
 	"fmt"
 
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/client-go/pkg/api/v1"
 )
 
@@ -38,6 +39,42 @@ type Provisioner interface {
 	Delete(*v1.PersistentVolume) error
 }
 
+// Qualifier is an optional interface implemented by provisioners to determine
+// whether a claim should be provisioned as early as possible (e.g. prior to
+// leader election), before the controller's own checks have all passed.
+type Qualifier interface {
+	// ShouldProvision returns whether provisioning for the claim should be
+	// attempted.
+	ShouldProvision(*v1.PersistentVolumeClaim) bool
+}
+
+// Populator is an optional interface implemented by provisioners that can
+// populate the volumes they provision from a data source, the object a claim's
+// dataSourceRef points to, per the volume populator pattern. A claim whose
+// dataSourceRef points to a custom resource is only provisioned by a
+// provisioner that supports its kind, which gets it in VolumeOptions and must
+// populate the volume before returning it from Provision. A claim to be cloned
+// from another claim or restored from a volume snapshot fails to be
+// provisioned, rather than get an empty volume, unless the provisioner supports
+// the kind: "PersistentVolumeClaim" in the core API group "", or
+// "VolumeSnapshot" in "snapshot.storage.k8s.io".
+type Populator interface {
+	// SupportsDataSource returns whether the provisioner can populate volumes
+	// from objects of the given API group and kind.
+	SupportsDataSource(apiGroup, kind string) bool
+}
+
+// Expander is an optional interface implemented by provisioners that can
+// expand the volumes they provisioned, without any help from the nodes. A
+// bound claim's volume is expanded when the claim requests more storage than
+// it has, if its StorageClass has allowVolumeExpansion: true.
+type Expander interface {
+	// ExpandVolume expands the given volume to at least the given size. It
+	// returns the volume with its capacity set to its new size and any other
+	// fields it changed, e.g. annotations, for the controller to save.
+	ExpandVolume(volume *v1.PersistentVolume, size resource.Quantity) (*v1.PersistentVolume, error)
+}
+
 // IgnoredError is the value for Delete to return to indicate that the call has
 // been ignored and no action taken. In case multiple provisioners are serving
 // the same storage class, provisioners may ignore PVs they are not responsible
@@ -66,4 +103,28 @@ type VolumeOptions struct {
 	PVC *v1.PersistentVolumeClaim
 	// Volume provisioning parameters from StorageClass
 	Parameters map[string]string
+	// Node selected by the scheduler for the claim's first consumer when the
+	// StorageClass has volumeBindingMode WaitForFirstConsumer, nil otherwise.
+	// Provisioners of topology-constrained volumes should place the volume
+	// where it is accessible from this node.
+	SelectedNode *v1.Node
+	// Topologies from the StorageClass's allowedTopologies, converted to node
+	// selector terms. Provisioners of topology-constrained volumes should only
+	// place the volume where it is accessible from nodes matching one of the
+	// terms and set the PV's node affinity accordingly.
+	AllowedTopologies []v1.NodeSelectorTerm
+	// Object the volume is to be populated from, from the claim's
+	// dataSourceRef, if the provisioner is a Populator supporting its kind,
+	// nil otherwise.
+	DataSourceRef *DataSourceRef
+}
+
+// DataSourceRef refers to the object a claim's volume is to be populated from.
+type DataSourceRef struct {
+	APIGroup string
+	Kind     string
+	Name     string
+	// Namespace of the object, empty if it is the claim's. If it is another,
+	// a ReferenceGrant there permits the claim to refer to the object.
+	Namespace string
 }
@@ -462,43 +462,39 @@ func GetAffinityFromPodAnnotations(annotations map[string]string) (*v1.Affinity,
 	return nil, nil
 }
 
-// GetPersistentVolumeClass returns StorageClassName.
+// GetPersistentVolumeClass returns StorageClassName, falling back to the beta
+// annotation for PVs created before the field existed.
 func GetPersistentVolumeClass(volume *v1.PersistentVolume) string {
-	// Use beta annotation first
-	if class, found := volume.Annotations[v1.BetaStorageClassAnnotation]; found {
-		return class
+	if volume.Spec.StorageClassName != "" {
+		return volume.Spec.StorageClassName
 	}
 
-	return volume.Spec.StorageClassName
+	return volume.Annotations[v1.BetaStorageClassAnnotation]
 }
 
-// GetPersistentVolumeClaimClass returns StorageClassName. If no storage class was
+// GetPersistentVolumeClaimClass returns StorageClassName, falling back to the
+// beta annotation for claims created by older clients. If no storage class was
 // requested, it returns "".
 func GetPersistentVolumeClaimClass(claim *v1.PersistentVolumeClaim) string {
-	// Use beta annotation first
-	if class, found := claim.Annotations[v1.BetaStorageClassAnnotation]; found {
-		return class
-	}
-
 	if claim.Spec.StorageClassName != nil {
 		return *claim.Spec.StorageClassName
 	}
 
+	if class, found := claim.Annotations[v1.BetaStorageClassAnnotation]; found {
+		return class
+	}
+
 	return ""
 }
 
 // PersistentVolumeClaimHasClass returns true if given claim has set StorageClassName field.
 func PersistentVolumeClaimHasClass(claim *v1.PersistentVolumeClaim) bool {
-	// Use beta annotation first
-	if _, found := claim.Annotations[v1.BetaStorageClassAnnotation]; found {
-		return true
-	}
-
 	if claim.Spec.StorageClassName != nil {
 		return true
 	}
 
-	return false
+	_, found := claim.Annotations[v1.BetaStorageClassAnnotation]
+	return found
 }
 
 // GetStorageNodeAffinityFromAnnotation gets the json serialized data from PersistentVolume.Annotations
@@ -128,10 +128,12 @@ func (le *LeaderElector) Run(task <-chan bool) {
 	stop := make(chan struct{})
 	go le.config.Callbacks.OnStartedLeading(stop)
 	timeout := make(chan bool, 1)
-	go func() {
-		time.Sleep(le.config.TermLimit)
-		timeout <- true
-	}()
+	if le.config.TermLimit > 0 {
+		go func() {
+			time.Sleep(le.config.TermLimit)
+			timeout <- true
+		}()
+	}
 	le.renew(task, timeout)
 	close(stop)
 	le.config.Callbacks.OnStoppedLeading()
@@ -148,6 +150,30 @@ func (le *LeaderElector) IsLeader() bool {
 	return le.observedRecord.HolderIdentity == le.config.Lock.Identity()
 }
 
+// Release gives up the lease if this client holds it, so that another client
+// can acquire it without waiting for it to expire, and returns true on
+// success. It must only be called once Run has returned, i.e. the lease is no
+// longer being renewed.
+func (le *LeaderElector) Release() bool {
+	if !le.IsLeader() {
+		return true
+	}
+	now := metav1.Now()
+	leaderElectionRecord := rl.LeaderElectionRecord{
+		LeaseDurationSeconds: 1,
+		RenewTime:            now,
+		AcquireTime:          now,
+		LeaderTransitions:    le.observedRecord.LeaderTransitions,
+	}
+	if err := le.config.Lock.Update(leaderElectionRecord); err != nil {
+		glog.Errorf("Failed to release lock: %v", err)
+		return false
+	}
+	le.observedRecord = leaderElectionRecord
+	le.observedTime = time.Now()
+	return true
+}
+
 // acquire loops calling tryAcquireOrRenew and returns immediately when tryAcquireOrRenew succeeds
 // or the task has successfully finished in which case there is no longer a need to acquire
 func (le *LeaderElector) acquire(task <-chan bool) bool {
@@ -254,7 +280,9 @@ func (le *LeaderElector) tryAcquireOrRenew() bool {
 		le.observedRecord = *oldLeaderElectionRecord
 		le.observedTime = time.Now()
 	}
-	if le.observedTime.Add(le.config.LeaseDuration).After(now.Time) &&
+	// A lock without a holder was released and may be acquired at once
+	if oldLeaderElectionRecord.HolderIdentity != "" &&
+		le.observedTime.Add(le.config.LeaseDuration).After(now.Time) &&
 		oldLeaderElectionRecord.HolderIdentity != le.config.Lock.Identity() {
 		glog.V(4).Infof("lock is held by %v and has not yet expired", oldLeaderElectionRecord.HolderIdentity)
 		return false
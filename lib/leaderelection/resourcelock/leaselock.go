@@ -0,0 +1,100 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resourcelock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+)
+
+// LeaseLock is a lock held in a coordination.k8s.io/v1 Lease of the given
+// name, created if it doesn't exist, e.g. for replicas of a provisioner to
+// elect the one that is active. Unlike ProvisionLeaseLock it is not owned by
+// anything, so it outlives its holders. Servers older than 1.14 don't have
+// Leases.
+type LeaseLock struct {
+	// LeaseMeta should contain a Name and a Namespace of the Lease.
+	LeaseMeta  metav1.ObjectMeta
+	Client     clientset.Interface
+	LockConfig Config
+	l          *lease
+}
+
+// Get returns the LeaderElectionRecord
+func (ll *LeaseLock) Get() (*LeaderElectionRecord, error) {
+	raw, err := ll.Client.Core().RESTClient().Get().AbsPath(leasesPath, "namespaces", ll.LeaseMeta.Namespace, "leases", ll.LeaseMeta.Name).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	l := &lease{}
+	if err := json.Unmarshal(raw, l); err != nil {
+		return nil, fmt.Errorf("error decoding lease %s/%s: %v", ll.LeaseMeta.Namespace, ll.LeaseMeta.Name, err)
+	}
+	ll.l = l
+	return leaseSpecToRecord(&l.Spec), nil
+}
+
+// Create attempts to create a Lease
+func (ll *LeaseLock) Create(ler LeaderElectionRecord) error {
+	l := &lease{
+		TypeMeta: metav1.TypeMeta{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ll.LeaseMeta.Name,
+			Namespace: ll.LeaseMeta.Namespace,
+		},
+		Spec: recordToLeaseSpec(&ler),
+	}
+	created, err := sendLease(ll.Client.Core().RESTClient().Post().AbsPath(leasesPath, "namespaces", ll.LeaseMeta.Namespace, "leases"), l)
+	if err != nil {
+		return err
+	}
+	ll.l = created
+	return nil
+}
+
+// Update will update the existing Lease, failing if it changed since Get.
+func (ll *LeaseLock) Update(ler LeaderElectionRecord) error {
+	if ll.l == nil {
+		return errors.New("lease not initialized, call get first")
+	}
+	ll.l.Spec = recordToLeaseSpec(&ler)
+	updated, err := sendLease(ll.Client.Core().RESTClient().Put().AbsPath(leasesPath, "namespaces", ll.LeaseMeta.Namespace, "leases", ll.LeaseMeta.Name), ll.l)
+	if err != nil {
+		return err
+	}
+	ll.l = updated
+	return nil
+}
+
+// RecordEvent in leader election while adding meta-data
+func (ll *LeaseLock) RecordEvent(s string) {
+}
+
+// Describe is used to convert details on current resource lock
+// into a string
+func (ll *LeaseLock) Describe() string {
+	return fmt.Sprintf("%v/%v", ll.LeaseMeta.Namespace, ll.LeaseMeta.Name)
+}
+
+// Identity returns the Identity of the lock
+func (ll *LeaseLock) Identity() string {
+	return ll.LockConfig.Identity
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resourcelock
+
+import (
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// MultiLock is a lock held in two locks at once, for migrating from the
+// Secondary lock to the Primary: while replicas that only take the Secondary
+// lock may still be running, it's only acquired once both are free, and both
+// are updated, so that neither kind of replica acquires it while the other
+// holds it. The Secondary lock must be one that exists already, e.g. a
+// ProvisionPVCLock, since it's only ever updated.
+type MultiLock struct {
+	Primary   Interface
+	Secondary Interface
+	// Whether the Primary lock existed at the last Get, so that Update creates
+	// it if not
+	primaryExists bool
+}
+
+// Get returns the LeaderElectionRecord of the Secondary lock if it's held by
+// another than the Primary, e.g. by a replica that only takes the Secondary,
+// and of the Primary otherwise, or of the Secondary if there is no Primary.
+func (ml *MultiLock) Get() (*LeaderElectionRecord, error) {
+	secondary, err := ml.Secondary.Get()
+	if err != nil {
+		return nil, err
+	}
+	primary, err := ml.Primary.Get()
+	if errors.IsNotFound(err) {
+		ml.primaryExists = false
+		return secondary, nil
+	} else if err != nil {
+		return nil, err
+	}
+	ml.primaryExists = true
+	if secondary.HolderIdentity != "" && secondary.HolderIdentity != primary.HolderIdentity {
+		return secondary, nil
+	}
+	return primary, nil
+}
+
+// Create creates the Primary lock and updates the Secondary.
+func (ml *MultiLock) Create(ler LeaderElectionRecord) error {
+	if err := ml.Primary.Create(ler); err != nil {
+		return err
+	}
+	ml.primaryExists = true
+	return ml.Secondary.Update(ler)
+}
+
+// Update updates both locks, creating the Primary if it didn't exist at the
+// last Get.
+func (ml *MultiLock) Update(ler LeaderElectionRecord) error {
+	if !ml.primaryExists {
+		return ml.Create(ler)
+	}
+	if err := ml.Primary.Update(ler); err != nil {
+		return err
+	}
+	return ml.Secondary.Update(ler)
+}
+
+// RecordEvent in leader election while adding meta-data
+func (ml *MultiLock) RecordEvent(s string) {
+	ml.Primary.RecordEvent(s)
+}
+
+// Describe is used to convert details on current resource lock
+// into a string
+func (ml *MultiLock) Describe() string {
+	return ml.Primary.Describe()
+}
+
+// Identity returns the Identity of the lock
+func (ml *MultiLock) Identity() string {
+	return ml.Primary.Identity()
+}
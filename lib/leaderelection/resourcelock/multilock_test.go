@@ -0,0 +1,98 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resourcelock
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// testLock is a lock held in memory, which doesn't exist until created if
+// record is nil.
+type testLock struct {
+	record  *LeaderElectionRecord
+	creates int
+	updates int
+}
+
+var _ Interface = &testLock{}
+
+func (l *testLock) Get() (*LeaderElectionRecord, error) {
+	if l.record == nil {
+		return nil, errors.NewNotFound(schema.GroupResource{Resource: "leases"}, "test")
+	}
+	record := *l.record
+	return &record, nil
+}
+
+func (l *testLock) Create(ler LeaderElectionRecord) error {
+	l.creates++
+	l.record = &ler
+	return nil
+}
+
+func (l *testLock) Update(ler LeaderElectionRecord) error {
+	l.updates++
+	l.record = &ler
+	return nil
+}
+
+func (l *testLock) RecordEvent(string) {}
+
+func (l *testLock) Describe() string { return "test" }
+
+func (l *testLock) Identity() string { return "controller-1" }
+
+func TestMultiLock(t *testing.T) {
+	primary := &testLock{}
+	secondary := &testLock{record: &LeaderElectionRecord{}}
+	lock := &MultiLock{Primary: primary, Secondary: secondary}
+
+	// Without a Primary, the Secondary's record is returned, and updating
+	// creates the Primary
+	record, err := lock.Get()
+	if err != nil || record.HolderIdentity != "" {
+		t.Fatalf("expected the secondary's empty record but got %+v, %v", record, err)
+	}
+	if err := lock.Update(LeaderElectionRecord{HolderIdentity: "controller-1"}); err != nil {
+		t.Fatalf("unexpected error updating lock: %v", err)
+	}
+	if primary.creates != 1 || primary.record.HolderIdentity != "controller-1" || secondary.record.HolderIdentity != "controller-1" {
+		t.Errorf("expected primary created and both held by controller-1 but got primary %+v, secondary %+v", primary.record, secondary.record)
+	}
+
+	// Both are renewed
+	if _, err := lock.Get(); err != nil {
+		t.Fatalf("unexpected error getting lock: %v", err)
+	}
+	if err := lock.Update(LeaderElectionRecord{HolderIdentity: "controller-1"}); err != nil {
+		t.Fatalf("unexpected error updating lock: %v", err)
+	}
+	if primary.creates != 1 || primary.updates != 1 || secondary.updates != 2 {
+		t.Errorf("expected primary updated once and secondary twice but got %d creates, %d updates and %d updates", primary.creates, primary.updates, secondary.updates)
+	}
+
+	// A replica that only takes the Secondary holding it is seen as the
+	// holder, despite the Primary's stale record
+	secondary.record = &LeaderElectionRecord{HolderIdentity: "old-controller"}
+	record, err = lock.Get()
+	if err != nil || record.HolderIdentity != "old-controller" {
+		t.Errorf("expected lock held by old-controller but got %+v, %v", record, err)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resourcelock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	leasesPath = "/apis/coordination.k8s.io/v1"
+
+	// RFC3339 with microseconds, the format of the Lease's times
+	rfc3339Micro = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// ProvisionLeaseLock is a lock on an existing PVC to provision a PV for, held
+// in a coordination.k8s.io/v1 Lease in the PVC's namespace rather than in an
+// annotation on the PVC, so that racing for it doesn't update the PVC. The
+// Lease is owned by the PVC and so deleted with it. Servers older than 1.14
+// don't have Leases.
+type ProvisionLeaseLock struct {
+	// PVCMeta should contain a Name, a Namespace and a UID of a PVC
+	// object that the LeaderElector will attempt to lead.
+	PVCMeta    metav1.ObjectMeta
+	Client     clientset.Interface
+	LockConfig Config
+	l          *lease
+}
+
+// lease is the subset of the coordination.k8s.io/v1 Lease the lock uses. The
+// vendored client-go has no Lease type.
+type lease struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              leaseSpec `json:"spec"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       *string    `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds *int32     `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *microTime `json:"acquireTime,omitempty"`
+	RenewTime            *microTime `json:"renewTime,omitempty"`
+	LeaseTransitions     *int32     `json:"leaseTransitions,omitempty"`
+}
+
+// microTime is a time serialized with microseconds, as the server requires
+// of Leases' times.
+type microTime struct {
+	time.Time
+}
+
+func (t microTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.UTC().Format(rfc3339Micro))
+}
+
+func (t *microTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.Parse(rfc3339Micro, s)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed.Local()
+	return nil
+}
+
+// Get returns the LeaderElectionRecord
+func (ll *ProvisionLeaseLock) Get() (*LeaderElectionRecord, error) {
+	raw, err := ll.Client.Core().RESTClient().Get().AbsPath(leasesPath, "namespaces", ll.PVCMeta.Namespace, "leases", ll.leaseName()).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	l := &lease{}
+	if err := json.Unmarshal(raw, l); err != nil {
+		return nil, fmt.Errorf("error decoding lease %s/%s: %v", ll.PVCMeta.Namespace, ll.leaseName(), err)
+	}
+	ll.l = l
+	return leaseSpecToRecord(&l.Spec), nil
+}
+
+// Create attempts to create a Lease owned by the PVC
+func (ll *ProvisionLeaseLock) Create(ler LeaderElectionRecord) error {
+	if ll.PVCMeta.UID == "" {
+		return errors.New("PVC UID not set, the lease can't be owned by it")
+	}
+	l := &lease{
+		TypeMeta: metav1.TypeMeta{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ll.leaseName(),
+			Namespace: ll.PVCMeta.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: "v1",
+					Kind:       "PersistentVolumeClaim",
+					Name:       ll.PVCMeta.Name,
+					UID:        ll.PVCMeta.UID,
+				},
+			},
+		},
+		Spec: recordToLeaseSpec(&ler),
+	}
+	return ll.do(ll.Client.Core().RESTClient().Post().AbsPath(leasesPath, "namespaces", ll.PVCMeta.Namespace, "leases"), l)
+}
+
+// Update will update the existing Lease, failing if it changed since Get.
+func (ll *ProvisionLeaseLock) Update(ler LeaderElectionRecord) error {
+	if ll.l == nil {
+		return errors.New("lease not initialized, call get first")
+	}
+	ll.l.Spec = recordToLeaseSpec(&ler)
+	return ll.do(ll.Client.Core().RESTClient().Put().AbsPath(leasesPath, "namespaces", ll.PVCMeta.Namespace, "leases", ll.leaseName()), ll.l)
+}
+
+// do sends the Lease in the request and keeps the Lease the server returns.
+func (ll *ProvisionLeaseLock) do(request *rest.Request, l *lease) error {
+	updated, err := sendLease(request, l)
+	if err != nil {
+		return err
+	}
+	ll.l = updated
+	return nil
+}
+
+// RecordEvent in leader election while adding meta-data
+func (ll *ProvisionLeaseLock) RecordEvent(s string) {
+}
+
+// Describe is used to convert details on current resource lock
+// into a string
+func (ll *ProvisionLeaseLock) Describe() string {
+	return fmt.Sprintf("to provision for pvc %v/%v", ll.PVCMeta.Namespace, ll.PVCMeta.Name)
+}
+
+// Identity returns the Identity of the lock
+func (ll *ProvisionLeaseLock) Identity() string {
+	return ll.LockConfig.Identity
+}
+
+// leaseName returns the name of the PVC's Lease. It is named after the PVC's
+// UID so that a PVC recreated with the same name doesn't find the old one's.
+func (ll *ProvisionLeaseLock) leaseName() string {
+	return "provision-" + string(ll.PVCMeta.UID)
+}
+
+func leaseSpecToRecord(spec *leaseSpec) *LeaderElectionRecord {
+	r := &LeaderElectionRecord{}
+	if spec.HolderIdentity != nil {
+		r.HolderIdentity = *spec.HolderIdentity
+	}
+	if spec.LeaseDurationSeconds != nil {
+		r.LeaseDurationSeconds = int(*spec.LeaseDurationSeconds)
+	}
+	if spec.LeaseTransitions != nil {
+		r.LeaderTransitions = int(*spec.LeaseTransitions)
+	}
+	if spec.AcquireTime != nil {
+		r.AcquireTime = metav1.NewTime(spec.AcquireTime.Time)
+	}
+	if spec.RenewTime != nil {
+		r.RenewTime = metav1.NewTime(spec.RenewTime.Time)
+	}
+	return r
+}
+
+func recordToLeaseSpec(ler *LeaderElectionRecord) leaseSpec {
+	leaseDurationSeconds := int32(ler.LeaseDurationSeconds)
+	leaseTransitions := int32(ler.LeaderTransitions)
+	return leaseSpec{
+		HolderIdentity:       &ler.HolderIdentity,
+		LeaseDurationSeconds: &leaseDurationSeconds,
+		AcquireTime:          &microTime{ler.AcquireTime.Time},
+		RenewTime:            &microTime{ler.RenewTime.Time},
+		LeaseTransitions:     &leaseTransitions,
+	}
+}
+
+// sendLease sends the Lease in the request and returns the Lease the server
+// returns.
+func sendLease(request *rest.Request, l *lease) (*lease, error) {
+	body, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := request.SetHeader("Content-Type", "application/json").Body(body).DoRaw()
+	if err != nil {
+		return nil, err
+	}
+	updated := &lease{}
+	if err := json.Unmarshal(raw, updated); err != nil {
+		return nil, fmt.Errorf("error decoding lease %s/%s: %v", l.Namespace, l.Name, err)
+	}
+	return updated, nil
+}
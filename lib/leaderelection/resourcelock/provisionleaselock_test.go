@@ -0,0 +1,74 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resourcelock
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLeaseRecordRoundTrip(t *testing.T) {
+	acquired := time.Date(2017, 6, 1, 12, 0, 0, 123456789, time.UTC)
+	record := LeaderElectionRecord{
+		HolderIdentity:       "controller-1",
+		LeaseDurationSeconds: 15,
+		AcquireTime:          metav1.NewTime(acquired),
+		RenewTime:            metav1.NewTime(acquired.Add(2 * time.Second)),
+		LeaderTransitions:    3,
+	}
+
+	raw, err := json.Marshal(&lease{Spec: recordToLeaseSpec(&record)})
+	if err != nil {
+		t.Fatalf("Error encoding lease: %v", err)
+	}
+	if !strings.Contains(string(raw), `"acquireTime":"2017-06-01T12:00:00.123456Z"`) {
+		t.Errorf("expected acquireTime with microseconds but got %s", raw)
+	}
+
+	l := &lease{}
+	if err := json.Unmarshal(raw, l); err != nil {
+		t.Fatalf("Error decoding lease: %v", err)
+	}
+	got := leaseSpecToRecord(&l.Spec)
+	if got.HolderIdentity != record.HolderIdentity || got.LeaseDurationSeconds != record.LeaseDurationSeconds || got.LeaderTransitions != record.LeaderTransitions {
+		t.Errorf("expected record %+v but got %+v", record, got)
+	}
+	if !got.AcquireTime.Time.Equal(acquired.Truncate(time.Microsecond)) || !got.RenewTime.Time.Equal(acquired.Add(2*time.Second).Truncate(time.Microsecond)) {
+		t.Errorf("expected times %v, %v but got %v, %v", record.AcquireTime, record.RenewTime, got.AcquireTime, got.RenewTime)
+	}
+}
+
+func TestLeaseName(t *testing.T) {
+	lock := &ProvisionLeaseLock{PVCMeta: metav1.ObjectMeta{Name: "claim-1", Namespace: "default", UID: "uid-1"}}
+	if name := lock.leaseName(); name != "provision-uid-1" {
+		t.Errorf("expected lease name provision-uid-1 but got %s", name)
+	}
+}
+
+func TestLeaseLockUpdateBeforeGet(t *testing.T) {
+	lock := &LeaseLock{LeaseMeta: metav1.ObjectMeta{Name: "nfs-provisioner", Namespace: "default"}}
+	if err := lock.Update(LeaderElectionRecord{HolderIdentity: "nfs-provisioner-0"}); err == nil {
+		t.Errorf("expected error updating lease before getting it but got none")
+	}
+	if desc := lock.Describe(); desc != "default/nfs-provisioner" {
+		t.Errorf("expected description default/nfs-provisioner but got %s", desc)
+	}
+}
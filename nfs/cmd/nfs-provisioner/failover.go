@@ -0,0 +1,402 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/leaderelection"
+	rl "github.com/kubernetes-incubator/external-storage/lib/leaderelection/resourcelock"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/runner"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/server"
+	"golang.org/x/net/context"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// The label of the pod of the replica serving the storage, for the
+	// Service to select, "true" while it is active and "false" while on
+	// standby
+	labelActive = "nfs-provisioner/active"
+	// Directory in exportDir the NFS server keeps its clients' state in, so
+	// that the replica taking over lets them reclaim their locks
+	recoveryDir = ".recovery"
+
+	podNameEnv      = "POD_NAME"
+	podNamespaceEnv = "POD_NAMESPACE"
+
+	// Environment variables describing the failover that hooks are run with
+	hookFailoverLock = "FAILOVER_LOCK"
+	hookFailoverVIP  = "FAILOVER_VIP"
+
+	// The rsync module a standby serves exportDir as to receive replication,
+	// and the config of the rsync daemon serving it
+	replicaModule       = "export"
+	replicaDaemonConfig = "/etc/rsyncd.conf"
+
+	// How long a hook may take
+	failoverHookTimeout = 30 * time.Second
+
+	failoverLeaseDuration = 15 * time.Second
+	failoverRenewDeadline = 10 * time.Second
+	failoverRetryPeriod   = 2 * time.Second
+)
+
+// The failover roles of a replica
+const (
+	// Waiting to acquire failover-lock, not serving nor provisioning
+	roleStandby = "standby"
+	// Holding failover-lock, starting to serve
+	roleTakingOver = "taking-over"
+	// Serving and provisioning
+	roleActive = "active"
+	// Told to terminate, serving until another replica takes over
+	roleHandingOver = "handing-over"
+)
+
+var failoverRoles = []string{roleStandby, roleTakingOver, roleActive, roleHandingOver}
+
+// failoverState is the failover role of this replica, served as its status.
+type failoverState struct {
+	mutex  sync.Mutex
+	role   string
+	since  time.Time
+	leader string
+}
+
+// failover is the failover state of this replica.
+var failover = &failoverState{role: roleStandby, since: time.Now()}
+
+// cmdRunner runs the external commands of failover and read replication, e.g.
+// the rsync daemon receiving replication.
+var cmdRunner = runner.New()
+
+// failoverStatus is the status of a replica served at /failover.
+type failoverStatus struct {
+	Role   string    `json:"role"`
+	Since  time.Time `json:"since"`
+	Lock   string    `json:"lock"`
+	Pod    string    `json:"pod"`
+	Leader string    `json:"leader"`
+}
+
+func (s *failoverState) setRole(role string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.role != role {
+		s.role = role
+		s.since = time.Now()
+	}
+}
+
+func (s *failoverState) setLeader(leader string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.leader = leader
+}
+
+// ServeHTTP writes the replica's failoverStatus, with status 200 if it is
+// ready, i.e. on standby or serving, and 503 while it is taking over, so that
+// it can be used as a readiness probe that doesn't fail healthy standbys.
+func (s *failoverState) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	status := failoverStatus{
+		Role:   s.role,
+		Since:  s.since,
+		Lock:   *failoverLock,
+		Pod:    os.Getenv(podNameEnv),
+		Leader: s.leader,
+	}
+	s.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if status.Role == roleTakingOver {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// writeMetrics writes the replica's role, 1 for the role it has and 0 for the
+// others, and how long it has had it, in the Prometheus text format.
+func (s *failoverState) writeMetrics(w io.Writer) {
+	s.mutex.Lock()
+	role, since := s.role, s.since
+	s.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP nfs_provisioner_failover_role Failover role of this replica, 1 for the one it has.\n# TYPE nfs_provisioner_failover_role gauge\n")
+	for _, r := range failoverRoles {
+		value := 0
+		if r == role {
+			value = 1
+		}
+		fmt.Fprintf(w, "nfs_provisioner_failover_role{role=%q} %d\n", r, value)
+	}
+	fmt.Fprintf(w, "# HELP nfs_provisioner_failover_role_seconds Seconds this replica has had its failover role.\n# TYPE nfs_provisioner_failover_role_seconds gauge\nnfs_provisioner_failover_role_seconds %v\n", time.Since(since).Seconds())
+}
+
+// awaitFailoverLock blocks while this replica is on standby, until it holds
+// the Lease of the given name in its namespace, given by the POD_NAMESPACE
+// env, as the pod given by the POD_NAME env. Meanwhile its pod is labeled
+// inactive so the Service doesn't select it. The Lease is renewed in the
+// background and the process exits if it is lost, e.g. because the API
+// server is unreachable, rather than keep serving storage another replica may
+// be taking over.
+func awaitFailoverLock(clientset kubernetes.Interface, name string) {
+	namespace := os.Getenv(podNamespaceEnv)
+	podName := os.Getenv(podNameEnv)
+	if namespace == "" || podName == "" {
+		glog.Fatalf("Invalid flags specified: if failover-lock is set, the %s and %s envs must be.", podNamespaceEnv, podNameEnv)
+	}
+	if err := setActiveLabel(clientset, namespace, podName, false); err != nil {
+		glog.Fatalf("Error labeling pod %s/%s inactive: %v", namespace, podName, err)
+	}
+	// This replica may have been active before it restarted
+	if err := runFailoverHook(*failoverReleaseHook, "released", podName); err != nil {
+		glog.Errorf("Error running failover release hook: %v", err)
+	}
+	if *drbdResource != "" {
+		if err := server.DemoteDRBD(*drbdResource); err != nil {
+			glog.Errorf("Error demoting DRBD resource, the replica taking over may fail to promote it: %v", err)
+		}
+	}
+
+	acquired := make(chan struct{})
+	handover := make(chan struct{})
+	le, err := leaderelection.NewLeaderElector(leaderelection.Config{
+		Lock: &rl.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Client:     clientset,
+			LockConfig: rl.Config{Identity: podName},
+		},
+		LeaseDuration: failoverLeaseDuration,
+		RenewDeadline: failoverRenewDeadline,
+		RetryPeriod:   failoverRetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(_ <-chan struct{}) {
+				failover.setRole(roleTakingOver)
+				close(acquired)
+			},
+			OnStoppedLeading: func() {
+				select {
+				case <-handover:
+					// Stopped renewing to hand over, not lost
+					return
+				default:
+				}
+				if err := runFailoverHook(*failoverReleaseHook, "released", podName); err != nil {
+					glog.Errorf("Error running failover release hook: %v", err)
+				}
+				glog.Fatalf("Lost failover lock %s/%s, exiting so that the standby takes over", namespace, name)
+			},
+			OnNewLeader: func(identity string) {
+				failover.setLeader(identity)
+				glog.Infof("Failover lock %s/%s is held by %s", namespace, name, identity)
+			},
+		},
+	})
+	if err != nil {
+		glog.Fatalf("Error creating failover lock elector: %v", err)
+	}
+
+	// The standby receives the active replica's replication
+	var replica runner.Process
+	if *replicationTarget != "" {
+		replica, err = startReplicaDaemon(exportDir)
+		if err != nil {
+			glog.Fatalf("Error starting rsync daemon to receive replication: %v", err)
+		}
+	}
+
+	glog.Infof("Standing by until the failover lock %s/%s is acquired", namespace, name)
+	task := make(chan bool, 1)
+	done := make(chan struct{})
+	go func() {
+		le.Run(task)
+		close(done)
+	}()
+	<-acquired
+	glog.Infof("Acquired failover lock %s/%s, taking over", namespace, name)
+
+	// Stop receiving replication from the replica this one took over from,
+	// in case it's only paused
+	if replica != nil {
+		replica.Kill()
+		replica.Wait()
+	}
+
+	if *handoverTimeout > 0 {
+		go handOverOnTermination(clientset, le, namespace, name, podName, func() {
+			close(handover)
+			task <- true
+			<-done
+		})
+	}
+}
+
+// handOverOnTermination waits for this replica to be told to terminate, e.g.
+// because its pod is being replaced by a rolling upgrade, then hands over to
+// another replica: it stops renewing the failover lock with the given func and
+// releases it so that the other replica takes over at once, and keeps serving
+// until the other replica's pod is labeled active, i.e. it is serving, or
+// handover-timeout passes. Only then does it label its own pod inactive and
+// exit, taking its exports down with it.
+func handOverOnTermination(clientset kubernetes.Interface, le *leaderelection.LeaderElector, namespace, name, podName string, stopRenewing func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	<-signals
+
+	glog.Infof("Terminating, handing over failover lock %s/%s to another replica", namespace, name)
+	failover.setRole(roleHandingOver)
+	stopRenewing()
+	le.Release()
+	err := wait.PollImmediate(failoverRetryPeriod, *handoverTimeout, func() (bool, error) {
+		return isOtherReplicaActive(clientset, namespace, podName)
+	})
+	if err != nil {
+		glog.Warningf("No other replica took over within %v, exiting anyway", *handoverTimeout)
+	} else {
+		glog.Infof("Another replica took over, exiting")
+	}
+
+	if err := setActiveLabel(clientset, namespace, podName, false); err != nil {
+		glog.Errorf("Error labeling pod %s/%s inactive: %v", namespace, podName, err)
+	}
+	if err := runFailoverHook(*failoverReleaseHook, "released", podName); err != nil {
+		glog.Errorf("Error running failover release hook: %v", err)
+	}
+	glog.Flush()
+	os.Exit(0)
+}
+
+// isOtherReplicaActive returns whether a pod other than the given one in the
+// given namespace is labeled active.
+func isOtherReplicaActive(clientset kubernetes.Interface, namespace, podName string) (bool, error) {
+	pods, err := clientset.Core().Pods(namespace).List(metav1.ListOptions{LabelSelector: labelActive + "=true"})
+	if err != nil {
+		glog.Warningf("Error listing active pods: %v", err)
+		return false, nil
+	}
+	for _, pod := range pods.Items {
+		if pod.Name != podName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// startReplicaDaemon starts an rsync daemon serving the given directory as the
+// module replicaModule, for the active replica to replicate to while this one
+// is on standby, or for a provisioner to replicate reads to.
+func startReplicaDaemon(dir string) (runner.Process, error) {
+	config := fmt.Sprintf("[%s]\n\tpath = %s\n\tread only = false\n\tuid = root\n\tgid = root\n", replicaModule, dir)
+	if err := ioutil.WriteFile(replicaDaemonConfig, []byte(config), 0600); err != nil {
+		return nil, err
+	}
+	daemon, err := cmdRunner.Start("rsync", "--daemon", "--no-detach", "--config="+replicaDaemonConfig)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Receiving replication at rsync module %s", replicaModule)
+	return daemon, nil
+}
+
+// becomeActive runs the failover acquire hook, e.g. to move failover-vip
+// here, and labels this replica's pod active so that the Service selects it,
+// once it is serving the storage. If the hook fails the process exits, so
+// that another replica takes over.
+func becomeActive(clientset kubernetes.Interface) {
+	namespace := os.Getenv(podNamespaceEnv)
+	podName := os.Getenv(podNameEnv)
+	if err := runFailoverHook(*failoverAcquireHook, "acquired", podName); err != nil {
+		glog.Fatalf("Error running failover acquire hook, exiting so that another replica takes over: %v", err)
+	}
+	if err := setActiveLabel(clientset, namespace, podName, true); err != nil {
+		glog.Fatalf("Error labeling pod %s/%s active: %v", namespace, podName, err)
+	}
+	failover.setRole(roleActive)
+}
+
+// setActiveLabel sets the label of whether the given pod is the active
+// replica.
+func setActiveLabel(clientset kubernetes.Interface, namespace, podName string, active bool) error {
+	patch := []byte(fmt.Sprintf(`{"metadata":{"labels":{%q:"%t"}}}`, labelActive, active))
+	_, err := clientset.Core().Pods(namespace).Patch(podName, types.StrategicMergePatchType, patch)
+	return err
+}
+
+// failoverEvent is the body of the request made to an HTTP failover hook.
+type failoverEvent struct {
+	Event string `json:"event"`
+	Lock  string `json:"lock"`
+	Pod   string `json:"pod"`
+	VIP   string `json:"vip,omitempty"`
+}
+
+// runFailoverHook runs the given failover hook, if any, for the given event,
+// "acquired" or "released". A hook that is an http:// or https:// URL is
+// POSTed a failoverEvent and must respond with a 2xx status, any other is a
+// command run with sh with the FAILOVER_LOCK, FAILOVER_VIP and POD_NAME envs.
+// Either fails if it takes longer than failoverHookTimeout.
+func runFailoverHook(hook, event, podName string) error {
+	if hook == "" {
+		return nil
+	}
+	glog.Infof("Running failover hook %q of event %s", hook, event)
+	if strings.HasPrefix(hook, "http://") || strings.HasPrefix(hook, "https://") {
+		body, err := json.Marshal(failoverEvent{Event: event, Lock: *failoverLock, Pod: podName, VIP: *failoverVIP})
+		if err != nil {
+			return err
+		}
+		client := &http.Client{Timeout: failoverHookTimeout}
+		resp, err := client.Post(hook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("hook %q failed with error: %v", hook, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("hook %q failed with status %s", hook, resp.Status)
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), failoverHookTimeout)
+	defer cancel()
+	env := []string{
+		hookFailoverLock + "=" + *failoverLock,
+		hookFailoverVIP + "=" + *failoverVIP,
+		podNameEnv + "=" + podName,
+	}
+	if out, err := cmdRunner.CombinedOutputContext(ctx, env, "sh", "-c", hook); err != nil {
+		return fmt.Errorf("hook %q failed with error: %v, output: %s", hook, err, out)
+	}
+	return nil
+}
@@ -18,33 +18,118 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/csidriver"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/fault"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/runner"
 	"github.com/kubernetes-incubator/external-storage/nfs/pkg/server"
 	vol "github.com/kubernetes-incubator/external-storage/nfs/pkg/volume"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 var (
-	provisioner    = flag.String("provisioner", "example.com/nfs", "Name of the provisioner. The provisioner will only provision volumes for claims that request a StorageClass with a provisioner field set equal to this name.")
-	master         = flag.String("master", "", "Master URL to build a client config from. Either this or kubeconfig needs to be set if the provisioner is being run out of cluster.")
-	kubeconfig     = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file. Either this or master needs to be set if the provisioner is being run out of cluster.")
-	runServer      = flag.Bool("run-server", true, "If the provisioner is responsible for running the NFS server, i.e. starting and stopping NFS Ganesha. Default true.")
-	useGanesha     = flag.Bool("use-ganesha", true, "If the provisioner will create volumes using NFS Ganesha (D-Bus method calls) as opposed to using the kernel NFS server ('exportfs'). If run-server is true, this must be true. Default true.")
-	gracePeriod    = flag.Uint("grace-period", 90, "NFS Ganesha grace period to use in seconds, from 0-180. If the server is not expected to survive restarts, i.e. it is running as a pod & its export directory is not persisted, this can be set to 0. Can only be set if both run-server and use-ganesha are true. Default 90.")
-	enableXfsQuota = flag.Bool("enable-xfs-quota", false, "If the provisioner will set xfs quotas for each volume it provisions. Requires that the directory it creates volumes in ('/export') is xfs mounted with option prjquota/pquota, and that it has the privilege to run xfs_quota. Default false.")
-	serverHostname = flag.String("server-hostname", "", "The hostname for the NFS server to export from. Only applicable when running out-of-cluster i.e. it can only be set if either master or kubeconfig are set. If unset, the first IP output by `hostname -i` is used.")
+	provisioner         = flag.String("provisioner", "example.com/nfs", "Name of the provisioner. The provisioner will only provision volumes for claims that request a StorageClass with a provisioner field set equal to this name.")
+	master              = flag.String("master", "", "Master URL to build a client config from. Either this or kubeconfig needs to be set if the provisioner is being run out of cluster.")
+	kubeconfig          = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file. Either this or master needs to be set if the provisioner is being run out of cluster.")
+	kubeAPIQPS          = flag.Float64("kube-api-qps", 0, "The maximum sustained queries per second of the provisioner's Kubernetes API clients, e.g. raised to provision many volumes at once in a large cluster or lowered to spare a small cluster's API server. 0 means the client-go default of 5. Default 0.")
+	kubeAPIBurst        = flag.Int("kube-api-burst", 0, "The maximum burst of queries of the provisioner's Kubernetes API clients above kube-api-qps. 0 means the client-go default of 10. Default 0.")
+	runServer           = flag.Bool("run-server", true, "If the provisioner is responsible for running the NFS server, i.e. starting and stopping NFS Ganesha. Default true.")
+	useGanesha          = flag.Bool("use-ganesha", true, "If the provisioner will create volumes using NFS Ganesha (D-Bus method calls) as opposed to using the kernel NFS server ('exportfs'). If run-server is true, this must be true. Default true.")
+	gracePeriod         = flag.Uint("grace-period", 90, "NFS Ganesha grace period to use in seconds, from 0-180. If the server is not expected to survive restarts, i.e. it is running as a pod & its export directory is not persisted, this can be set to 0. Can only be set if both run-server and use-ganesha are true. Default 90.")
+	enableNLM           = flag.Bool("enable-nlm", true, "If run-server is true, whether NFS Ganesha serves NLM, i.e. NFSv3 locking, and rpc.statd is run to notify clients to reclaim their locks after the server restarts or fails over. NLM's grace period is grace-period's. If false, NFSv3 clients must mount with nolock or only use local locks. Default true.")
+	statdHostname       = flag.String("statd-hostname", "", "If run-server and enable-nlm are true, the name rpc.statd identifies the server by to clients, passed to rpc.statd -n, e.g. the name clients mount the server by, so that they recognize its notifications to reclaim locks. If unset, failover-vip is used if set, or else the pod's hostname.")
+	enableXfsQuota      = flag.Bool("enable-xfs-quota", false, "If the provisioner will set xfs quotas for each volume it provisions. Requires that the directory it creates volumes in ('/export') is xfs mounted with option prjquota/pquota, and that it has the privilege to run xfs_quota. Default false.")
+	serverHostname      = flag.String("server-hostname", "", "The hostname or IP for the NFS server to export from, put as the server of every provisioned PV. Overrides the node name, service cluster IP or pod IP that would otherwise be used, e.g. when clients reach the server through an external load balancer. If unset and running out-of-cluster, the first IP output by `hostname -i` is used.")
+	serverInterface     = flag.String("server-interface", "", "The network interface whose address to put as the server of every provisioned PV, e.g. eth1 when running with hostNetwork and clients must use a particular node network. Ignored if server-hostname is set.")
+	nodeAffinity        = flag.Bool("node-affinity", false, "If the provisioner will stamp the PVs it provisions with node affinity to the node it is running on, given by the NODE_NAME env. For running as a DaemonSet where each instance exports its own node's local storage, so that pods consuming a PV are scheduled to the node whose storage backs it. Default false.")
+	hostNetwork         = flag.Bool("host-network", false, "If the provisioner is running with hostNetwork, in which case the node's primary IP, from the HOST_IP env (downward API status.hostIP) or else the default route interface, is put as the server of provisioned PVs. Default false.")
+	extraExportDirs     = flag.String("extra-export-dirs", "", "Comma-separated list of directories, e.g. the mount points of additional disks, to create volumes in, besides the directory ('/export') it creates volumes in by default. Each volume is placed in one of them according to placement and the one chosen is recorded in the PV for deletion. Cannot be set if enable-xfs-quota is true.")
+	placement           = flag.String("placement", vol.PlacementMostFree, "How to choose the directory to create each volume in if extra-export-dirs is set: 'most-free' for the one with the most available space, or 'round-robin' for each in turn, skipping those without enough space. Default 'most-free'.")
+	classExportDirs     = flag.String("class-export-dirs", "", "Comma-separated list of class=directory pairs, e.g. 'fast=/export-fast,slow=/export-slow', mapping storage classes to the directory to create their volumes in instead of '/export' and extra-export-dirs, so that one provisioner can serve several disk tiers. Cannot be set if enable-xfs-quota is true.")
+	rebalancePeriod     = flag.Duration("rebalance-period", 0, "How often to check for PVs annotated with nfs-provisioner/migrate-to=<directory> and migrate each, once no pod is using its claim, to the named directory, one of '/export', extra-export-dirs or class-export-dirs. 0 disables rebalancing. Default 0.")
+	remoteSource        = flag.String("remote-source", "", "A remote filesystem, e.g. another NFS server's export 'filer:/vol/k8s' or CephFS 'mon:6789:/k8s', to mount at the directory it creates volumes in ('/export') so that it re-exports per-claim subdirectories of it, acting as a gateway in front of storage it can't run on. Requires that it has the privilege to mount. If unset, nothing is mounted.")
+	remoteFsType        = flag.String("remote-fstype", "nfs", "The filesystem type of remote-source, passed to mount -t. Default nfs.")
+	remoteOptions       = flag.String("remote-options", "", "Comma-separated mount options for remote-source, passed to mount -o, e.g. 'vers=4.1' or 'name=admin,secretfile=/etc/ceph/secret'.")
+	externalServer      = flag.String("external-server", "", "An external NFS server's export, of the form host:/path, to mount at the directory it creates volumes in ('/export') and create per-claim subdirectories of, e.g. on a filer. Provisioned PVs point at the subdirectories on the external server and no NFS server is run, i.e. run-server is ignored. Mount options may be given by remote-options. Cannot be set if remote-source, extra-export-dirs, class-export-dirs or enable-xfs-quota are.")
+	healthPeriod        = flag.Duration("health-period", 30*time.Second, "If external-server is set, how often to probe that its export is reachable and writable by writing and reading back a file. While the latest probe failed, claims are not provisioned and get a warning event instead. 0 disables probing. Default 30s.")
+	healthTimeout       = flag.Duration("health-timeout", 10*time.Second, "How long a probe of external-server may take before it is failed, e.g. because the server is unreachable and I/O on its mount hangs. Default 10s.")
+	healthPort          = flag.Int("health-port", 0, "If external-server is set and health-period is not 0, the port to serve the result of the latest probe on at /healthz, 200 if it succeeded and 503 if not, e.g. for a readiness probe. 0 disables serving. Default 0.")
+	selfTest            = flag.Bool("self-test", false, "If the provisioner will mount each new export, from the external-server if set or else itself via loopback, and check that it can be listed and, unless the class sets a gid, that a file can be written to and read back from it before creating the PV, catching export or permission misconfiguration at provision time. Requires that it has the privilege to mount. Default false.")
+	consolidatedExport  = flag.Bool("consolidated-export", false, "If the provisioner will export the directory it creates volumes in ('/export') once and provision volumes as subdirectories of that one export, rather than exporting each volume separately. This keeps the export table small when there are thousands of volumes, but any client can mount any volume and the rootSquash parameter is not supported. Cannot be set if extra-export-dirs or class-export-dirs are. Default false.")
+	exportsDir          = flag.String("exports-dir", "", "If use-ganesha is false, the directory, e.g. /etc/exports.d, to write the export of each volume to a file of its own in, instead of adding it to /etc/exports. This makes each volume's export independent of the others' and easy to inspect. Exports already in /etc/exports are still removed from there. If unset, /etc/exports is used.")
+	exportfsBatchWindow = flag.Duration("exportfs-batch-window", 0, "If use-ganesha is false, how long to wait after a volume is provisioned or deleted before syncing the kernel's export table with exportfs, so that the export changes of all volumes provisioned or deleted meanwhile are synced by one run of exportfs instead of one per volume. 0 syncs immediately. Default 0.")
+	directExport        = flag.Bool("direct-export", false, "If use-ganesha is false, whether to sync the kernel's export table by writing /var/lib/nfs/etab and flushing the kernel's export caches in /proc/net/rpc directly, as exportfs -r does, instead of running exportfs for every change, sparing a fork and a re-read of every export each time. Requires /proc/net/rpc to be writable, e.g. the pod to be privileged. Default false.")
+	drainTimeout        = flag.Duration("drain-timeout", 0, "If use-ganesha is false, how long to wait, when deleting a volume, for the clients that mounted its export to unmount it, according to mountd's /var/lib/nfs/rmtab, before removing the export and the volume's data, so that pods still terminating don't get I/O errors. The volume is deleted anyway once it has passed. NFSv4 clients aren't recorded in rmtab so aren't waited for. 0 disables waiting. Default 0.")
+	exportTemplate      = flag.String("export-template", "", "Path to a file containing a Go template to create the export block of each volume from, instead of the default ganesha EXPORT block or /etc/exports line, e.g. to restrict clients or add options. It is executed with .ExportID, .Path, .RootSquash and .Squash, and must keep 'Export_Id = {{.ExportID}};' for ganesha or 'fsid={{.ExportID}}' for the kernel. If unset, the default blocks are used.")
+	preProvisionHook    = flag.String("pre-provision-hook", "", "Command to run with sh after creating each volume, before its PV is created, e.g. to register the share elsewhere or set ACLs on it. It is run with the environment variables VOLUME_NAME, VOLUME_PATH, the volume's directory on the server, VOLUME_SIZE in bytes, PVC_NAMESPACE and PVC_NAME. If it fails, the volume is removed and provisioning retried. If unset, nothing is run.")
+	postDeleteHook      = flag.String("post-delete-hook", "", "Command to run with sh after deleting each volume, e.g. to deregister the share, with the same environment variables as pre-provision-hook. If it fails, an event is recorded on the PV. If unset, nothing is run.")
+	standaloneAddress   = flag.String("standalone-address", "", "If set, the address, e.g. :8080, to serve a REST API to create, delete and list shares on instead of provisioning volumes for claims. No Kubernetes client is created, so master, kubeconfig, node-affinity, rebalance-period, repair-period and capacity-period cannot be set. If unset, the provisioner runs in Kubernetes as usual.")
+	repairPeriod        = flag.Duration("repair-period", 0, "How often to check the PVs the provisioner provisioned for conditions that make clients get stale file handles: a missing backing directory, or a missing export block, e.g. after the export config was replaced, which is restored with the PV's persisted fsid and re-exported. Events on the PV describe what was found and fixed. 0 disables checking. Default 0.")
+	faultInjection      = flag.String("fault-injection", "", "For testing only. Comma-separated key=value faults to inject to test that the provisioner retries and recovers from them: exec-failure-rate, the probability 0-1 that an external command fails; api-write-delay, the maximum random delay of API writes; kill-period, how often to kill one of daemons, a colon-separated list of process names. Default empty, no faults.")
+	capacityPeriod      = flag.Duration("capacity-period", 0, "How often to publish an NFSStorageCapacity object in the provisioner's namespace, given by the POD_NAMESPACE env, with the space available to the volumes of each of its storage classes in each directory they may be created in, so that e.g. autoscalers and schedulers can tell how much capacity remains. Requires the NFSStorageCapacity CRD. 0 disables publishing. Default 0.")
+	reclaimPolicy       = flag.String("reclaim-policy", string(controller.DefaultReclaimPolicy), "The reclaim policy, Delete or Retain, of the PVs provisioned for claims whose StorageClass doesn't declare a reclaimPolicy, i.e. against Kubernetes < 1.8. Otherwise PVs get their StorageClass's. Default Delete.")
+	csiEndpoint         = flag.String("csi-endpoint", "", "If set, the unix socket, e.g. unix:///csi/csi.sock, to serve the CSI Identity and Controller services on instead of provisioning volumes for claims, so that the provisioner can be deployed as a CSI driver named after the provisioner name with the standard external-provisioner sidecar. Volumes are created and deleted the same way as for claims and persisted in '/export/.csi'. No Kubernetes client is created, so master, kubeconfig, node-affinity, rebalance-period, repair-period and capacity-period cannot be set. If unset, the provisioner runs in Kubernetes as usual.")
+	csiNodeID           = flag.String("csi-node-id", "", "If set together with csi-endpoint, the ID of the node, e.g. its name, to serve the CSI Identity and Node services for instead of the Controller service, mounting volumes with NFS for the pods on the node, e.g. as a DaemonSet with the node-driver-registrar sidecar. No NFS server is run and nothing is provisioned. If unset, the Controller service is served.")
+	watchNamespaces     = flag.String("watch-namespaces", "", "Comma-separated list of namespaces to provision, expand and delete volumes for claims in, e.g. to run one provisioner per tenant. If exactly one is given, only claims in it are watched. If unset, claims in all namespaces are.")
+	denyNamespaces      = flag.String("deny-namespaces", "", "Comma-separated list of namespaces to never provision, expand or delete volumes for claims in, even if they are in watch-namespaces. If unset, no namespace is denied.")
+	claimSelector       = flag.String("claim-selector", "", "A label selector, e.g. 'track=green' or 'team in (a,b)', that claims must match for volumes to be provisioned or expanded for them, e.g. to roll out a new provisioner to some claims of a StorageClass before the rest or to scope an instance to a team. Only matching claims are watched. If unset, all claims are.")
+	storageClasses      = flag.String("storage-classes", "", "Comma-separated list of StorageClasses of the provisioner to provision, expand and delete volumes of, e.g. to serve the classes of different disk tiers from different instances sharing one provisioner name. Each class should be served by exactly one instance. If unset, all classes are.")
+	parameterOverrides  = flag.String("parameter-overrides", "", "Comma-separated list of StorageClass parameters, of gid, rootSquash, mountOptions and expiresAfter, that claims may override with an nfs-provisioner/<parameter> annotation, e.g. 'gid' to let users pick the group of their volumes. Claims overriding other parameters fail to be provisioned. If unset, no parameter may be overridden.")
+	namespaceDirs       = flag.Bool("namespace-directories", false, "If the provisioner will create each volume's directory in a directory named after its claim's namespace, e.g. '/export/tenant-a/pvc-1234', rather than directly in the directory it creates volumes in, so that each namespace's data is grouped for audits and cleanups. Default false.")
+	namespaceQuota      = flag.String("namespace-quota", "", "If namespace-directories is true, the total capacity, e.g. '100Gi', that the volumes of each namespace may have. Claims that would take their namespace's volumes over it fail to be provisioned or expanded. If unset, there is no limit.")
+	maxVolumesPerNs     = flag.Int("max-volumes-per-namespace", 0, "The maximum number of volumes to provision for the claims of each namespace, as a guard against e.g. an operator creating claims in a loop. Claims beyond it get a warning event and are provisioned for once volumes of their namespace are deleted. 0 means no limit. Default 0.")
+	deleteThreads       = flag.Int("delete-threads", 0, "The maximum number of volumes to delete at once, in a pool of their own apart from provisioning, so that e.g. tearing down a namespace's volumes neither waits for nor holds up provisioning. 0 means deletions are not limited. Default 0.")
+	claimLock           = flag.Bool("claim-lock", true, "If the provisioner will also lock claims it races other replicas to provision for with an annotation on them where it locks them with a Lease, i.e. on Kubernetes 1.14+, so that replicas of older versions, which only lock claims with the annotation, don't provision for the same claims. Set it false once no such replicas remain, to spare claims the updates. Default true.")
+	maxVolumes          = flag.Int("max-volumes", 0, "The maximum number of volumes the provisioner provisions, e.g. to keep its export table and mountd at a size they handle well. Claims beyond it get a warning event and are provisioned for once volumes are deleted. 0 means no limit. Default 0.")
+	metricsPort         = flag.Int("metrics-port", 0, "The port to serve metrics on at /metrics in the Prometheus text format: the number of volumes provisioned, max-volumes and how many times provisioning was refused because of it, and, if enable-xfs-quota is true, the bytes used by each volume, read from the xfs quota accounting, and, if usage-thresholds is set, the number of volumes over each threshold and of times volumes crossed it. If failover-lock is set, also the replica's failover role, served from startup, even on standby, along with its status at /failover. 0 disables serving. Default 0.")
+	clusterKubeconfigs  = flag.String("cluster-kubeconfigs", "", "Comma-separated list of kubeconfig files, each optionally followed by :<context> to use a context other than its current one, of other clusters to provision volumes for the claims of too, on the same storage, e.g. for workload clusters sharing a storage cluster. Requires server-hostname, failover-vip or external-server to be set, since the server must be reachable from the other clusters, and node-affinity to be false. If unset, only claims of the cluster the provisioner runs in, or of master or kubeconfig, are served.")
+	createStorageClass  = flag.String("create-storage-class", "", "The name of a StorageClass for the provisioner to create with storage-class-parameters at startup and keep as configured, optionally followed by ':default' to make it the default class, e.g. 'nfs:default'. A class of the name not created by the provisioner is left as it is. If unset, no class is created.")
+	storageClassParams  = flag.String("storage-class-parameters", "", "If create-storage-class is set, semicolon-separated list of key=value parameters of the class, e.g. 'gid=1001;mountOptions=vers=4.1,hard'. If unset, the class has no parameters.")
+	verifyExportsPeriod = flag.Duration("verify-exports-period", 0, "If use-ganesha is false, how often to compare /etc/exports and the exports-dir with the kernel NFS server's live export table and re-export entries missing from it, e.g. because they were dropped when mountd restarted. 0 disables verifying. Default 0.")
+	quotaProjectPool    = flag.Int("quota-project-pool-size", 0, "If enable-xfs-quota is true, the number of xfs project ids to keep reserved and initialized ahead of volumes, so that setting up each volume's quota takes a single xfs_quota. The pool is refilled in the background once it's used up. 0 disables the pool. Default 0.")
+	failoverLock        = flag.String("failover-lock", "", "The name of a Lease in the provisioner's namespace, given by the POD_NAMESPACE env, for replicas sharing the storage of '/export', e.g. a ReadWriteMany or multi-attach volume, or remote-source, to elect the active one with, the others standing by to take over if it is lost. The active replica mounts remote-source, starts the NFS server with its clients' lock state kept in '/export/.recovery' so they can reclaim their locks in the grace period, restores missing exports from the PVs and only then labels its pod, given by the POD_NAME env, nfs-provisioner/active=true for the Service to select. A replica that loses the Lease exits. If unset, there is a single replica.")
+	failoverVIP         = flag.String("failover-vip", "", "If failover-lock is set, a floating IP, e.g. managed by keepalived, that is moved to the active replica by failover-acquire-hook, put as the server of every provisioned PV instead of server-hostname so that clients follow the active replica. If unset, server-hostname or else the usual server is used.")
+	failoverAcquireHook = flag.String("failover-acquire-hook", "", "If failover-lock is set, a hook to run when the replica takes over, after starting the NFS server and before labeling its pod active, e.g. to move failover-vip to it. An http:// or https:// URL is POSTed a JSON object with the event, 'acquired', lock, pod and vip; anything else is a command run with sh with the FAILOVER_LOCK, FAILOVER_VIP and POD_NAME envs. Either fails if it takes longer than 30 seconds. If it fails, the replica exits so that another takes over. If unset, nothing is run.")
+	failoverReleaseHook = flag.String("failover-release-hook", "", "If failover-lock is set, a hook to run like failover-acquire-hook, with the event 'released', when the replica goes on standby, at startup, and when it loses failover-lock, before it exits, e.g. to give up failover-vip. A failure is logged. If unset, nothing is run.")
+	drbdResource        = flag.String("drbd-resource", "", "If failover-lock is set, a DRBD resource replicating the storage between the replicas' nodes, whose device, e.g. /dev/drbd0, is remote-source. The active replica waits for the resource's local disk to be UpToDate, i.e. resynced from its peer, then promotes it to primary before mounting it; replicas on standby demote it to secondary. Requires drbdadm and the privilege to run it. If unset, the storage is not replicated by the provisioner.")
+	drbdSyncTimeout     = flag.Duration("drbd-sync-timeout", 0, "If drbd-resource is set, how long the replica taking over waits for the resource's local disk to be UpToDate before exiting, so that another replica can take over, rather than serve stale data. 0 means waiting indefinitely. Default 0.")
+	handoverTimeout     = flag.Duration("handover-timeout", 0, "If failover-lock is set, how long the active replica, when told to terminate, e.g. because its pod is replaced by a rolling upgrade, keeps serving after releasing failover-lock, until another replica has taken over and labeled its pod active, so that clients see a switch of seconds rather than an outage until the lock expires. The pods' terminationGracePeriodSeconds must be longer. Cannot be set if drbd-resource is, since the other replica can't promote the resource until this one exits. 0 disables handing over: the replica exits at once. Default 0.")
+	replicationTarget   = flag.String("replication-target", "", "If failover-lock is set, the rsync destination, e.g. 'rsync://nfs-provisioner-standby/export', to replicate the data of '/export' to every replication-period while active, for replicas with storage of their own instead of shared storage, so that a standby taking over loses at most one period of changes. While on standby, each replica serves its '/export' to receive replication with an rsync daemon as the module 'export'. Cannot be set if enable-xfs-quota, extra-export-dirs, class-export-dirs, remote-source, drbd-resource or handover-timeout are. If unset, nothing is replicated.")
+	replicationPeriod   = flag.Duration("replication-period", time.Minute, "If replication-target is set, how often to replicate to it. Default 1m.")
+	readReplicaServer   = flag.String("read-replica-server", "", "The address clients mount read replicas from, e.g. the Service of another provisioner run with serve-read-replica. If set, the volumes of classes with the parameter readReplica 'true' are replicated to read-replica-target every read-replica-period, and once replicated, each gets a read-only PV pointing at its copy there, named after the volume's PV with the suffix '-ro' and labeled nfs-provisioner/read-replica-of=<PV name>, for claims to select. Requires read-replica-target. Cannot be set if extra-export-dirs, class-export-dirs or external-server are. If unset, the readReplica parameter is not supported.")
+	readReplicaTarget   = flag.String("read-replica-target", "", "If read-replica-server is set, the rsync destination, e.g. 'rsync://nfs-read-replica/export', of the provisioner serving the read replicas, to replicate the volumes requesting one to.")
+	readReplicaPeriod   = flag.Duration("read-replica-period", time.Minute, "If read-replica-server is set, how often to replicate to read-replica-target. Default 1m.")
+	serveReadReplica    = flag.Bool("serve-read-replica", false, "If the provisioner serves read replicas instead of provisioning volumes: it receives the volumes another provisioner's read-replica-target replicates in '/export/read-replica' with an rsync daemon as the module 'export' and exports that directory read-only. Requires run-server. Cannot be set if failover-lock, external-server, standalone-address, csi-endpoint or read-replica-server are. Default false.")
+	snapshotPeriod      = flag.Duration("snapshot-period", 0, "How often to check for claims annotated with nfs-provisioner/snapshot-schedule=<cron schedule> whose snapshots are due and snapshot their volumes in '.snapshots' in their export root, keeping the number annotated with nfs-provisioner/snapshot-retention, 7 if not. Should be at most 1m, the resolution of schedules. 0 disables snapshots. Default 0.")
+	usageThresholds     = flag.String("usage-thresholds", "", "If enable-xfs-quota is true, comma-separated list of usage thresholds in percent of capacity, e.g. '80,95', that volumes crossing one of, as read from the xfs quota accounting every usage-period, get a VolumeUsageHigh Warning event on their claim, and are counted in the metrics served on metrics-port. If unset, usage is not monitored.")
+	usagePeriod         = flag.Duration("usage-period", time.Minute, "If usage-thresholds is set, how often to check the usage of volumes against them. Default 1m.")
+	usageReportPeriod   = flag.Duration("usage-report-period", 0, "If enable-xfs-quota is true, how often to annotate each PV with the bytes and inodes its volume uses, read from the xfs quota accounting, as nfs-provisioner/used-bytes and nfs-provisioner/used-inodes. 0 disables reporting. Default 0.")
+	expiryPeriod        = flag.Duration("expiry-period", 0, "How often to check for PVs whose nfs-provisioner/expires-at time, set when provisioned from the expiresAfter parameter, has passed and delete them: a bound PV's claim is deleted, so that the PV is deleted with its data if its reclaim policy is Delete; any other PV that isn't bound is kept, unless expire-retained is true. 0 disables expiry. Default 0.")
+	expireRetained      = flag.Bool("expire-retained", false, "If expiry-period is set, whether the provisioner deletes, along with their data, expired PVs that aren't bound and whose reclaim policy isn't Delete, e.g. Retain volumes whose claims were deleted. If false, their data is kept as their reclaim policy asks. Default false.")
+	scrubSchedule       = flag.String("scrub-schedule", "", "The cron schedule, in UTC, to scrub volumes on, e.g. '0 3 * * *' for every night at 3:00: every file of every volume is read in full and volumes with files that can't be read, including those failing their checksums on filesystems that verify them, get a ScrubFailed Warning event. If unset, volumes are not scrubbed.")
+	dataSourceHosts     = flag.String("data-source-hosts", "", "Comma-separated list of the hosts NFSDataSources may copy from: the rsync daemons of rsync sources, which must be of the form host::module/path or rsync://host/module/path, and the HTTP(S) servers of http sources. If unset, volumes can only be populated by cloning claims.")
+	webhookURL          = flag.String("webhook-url", "", "If set, the http:// or https:// URL to POST a JSON notification to of each volume provisioned, deleted or resized, or that failed to be, e.g. for billing or inventory systems to track volumes without polling the API server. If unset, nothing is notified.")
+	directoryPoolSize   = flag.Int("directory-pool-size", 0, "The number of directories to keep created and exported in '/export' ahead of claims, so that volumes needing nothing more of them, i.e. those of classes with the default gid and rootSquash and without namespace-directories or a data source, are provisioned without waiting for a directory to be created and exported. Such a volume's directory keeps its name from the pool, e.g. '/export/pool-<uuid>', recorded in the PV's Directory annotation. 0 disables the pool. Default 0.")
 )
 
 const (
@@ -52,10 +137,33 @@ const (
 	ganeshaLog    = "/export/ganesha.log"
 	ganeshaPid    = "/var/run/ganesha.pid"
 	ganeshaConfig = "/export/vfs.conf"
+	// Directory in exportDir the shares created in standalone mode are
+	// persisted in
+	sharesDir = ".shares"
+	// Directory in exportDir the volumes created in CSI mode are persisted in
+	csiDir = ".csi"
+	// How often to check that the StorageClass of create-storage-class is as
+	// configured
+	storageClassPeriod = time.Minute
+	// How often to refill the pool of directory-pool-size
+	directoryPoolPeriod = time.Second
 )
 
+// version is set at build time with -ldflags "-X main.version=<version>" and
+// reported as the CSI driver's version.
+var version = "unknown"
+
 func main() {
 	flag.Set("logtostderr", "true")
+
+	if len(os.Args) > 1 && os.Args[1] == "smoke-test" {
+		flag.CommandLine.Parse([]string{})
+		if err := smokeTest(os.Args[2:]); err != nil {
+			glog.Fatalf("Smoke test failed: %v", err)
+		}
+		return
+	}
+
 	flag.Parse()
 
 	if errs := validateProvisioner(*provisioner, field.NewPath("provisioner")); len(errs) != 0 {
@@ -63,10 +171,22 @@ func main() {
 	}
 	glog.Infof("Provisioner %s specified", *provisioner)
 
+	if *csiNodeID != "" {
+		if *csiEndpoint == "" {
+			glog.Fatalf("Invalid flags specified: if csi-node-id is set, csi-endpoint must also be.")
+		}
+		identityServer := csidriver.NewIdentityServer(*provisioner, version, false)
+		glog.Fatalf("Error serving CSI: %v", csidriver.Serve(*csiEndpoint, identityServer, nil, csidriver.NewNodeServer(*csiNodeID)))
+	}
+
 	if *runServer && !*useGanesha {
 		glog.Fatalf("Invalid flags specified: if run-server is true, use-ganesha must also be true.")
 	}
 
+	if (!*enableNLM || *statdHostname != "") && !*runServer {
+		glog.Fatalf("Invalid flags specified: enable-nlm and statd-hostname can only be set if run-server is true.")
+	}
+
 	if *gracePeriod != 90 && (!*runServer || !*useGanesha) {
 		glog.Fatalf("Invalid flags specified: custom grace period can only be set if both run-server and use-ganesha are true.")
 	} else if *gracePeriod > 180 && *runServer && *useGanesha {
@@ -76,16 +196,239 @@ func main() {
 	// Create the client according to whether we are running in or out-of-cluster
 	outOfCluster := *master != "" || *kubeconfig != ""
 
-	if !outOfCluster && *serverHostname != "" {
-		glog.Fatalf("Invalid flags specified: if server-hostname is set, either master or kube-config must also be set.")
+	var exportDirs []string
+	if *extraExportDirs != "" {
+		exportDirs = strings.Split(*extraExportDirs, ",")
+	}
+	if len(exportDirs) > 0 && *enableXfsQuota {
+		glog.Fatalf("Invalid flags specified: extra-export-dirs cannot be set if enable-xfs-quota is true.")
+	}
+	var overrides []string
+	if *parameterOverrides != "" {
+		overrides = strings.Split(*parameterOverrides, ",")
+	}
+	if err := vol.ValidateParameterOverrides(overrides); err != nil {
+		glog.Fatalf("Invalid flags specified: %v", err)
+	}
+	var sourceHosts []string
+	if *dataSourceHosts != "" {
+		sourceHosts = strings.Split(*dataSourceHosts, ",")
+	}
+	var quota int64
+	if *namespaceQuota != "" {
+		q, err := resource.ParseQuantity(*namespaceQuota)
+		if err != nil {
+			glog.Fatalf("Invalid flags specified: namespace-quota: %v", err)
+		}
+		if !*namespaceDirs {
+			glog.Fatalf("Invalid flags specified: namespace-quota can only be set if namespace-directories is true.")
+		}
+		quota = q.Value()
+	}
+	thresholds, err := parseUsageThresholds(*usageThresholds)
+	if err != nil {
+		glog.Fatalf("Invalid flags specified: usage-thresholds: %v", err)
+	}
+	if len(thresholds) > 0 && !*enableXfsQuota {
+		glog.Fatalf("Invalid flags specified: usage-thresholds can only be set if enable-xfs-quota is true.")
+	}
+	if *usageReportPeriod > 0 && !*enableXfsQuota {
+		glog.Fatalf("Invalid flags specified: usage-report-period can only be set if enable-xfs-quota is true.")
+	}
+	if *webhookURL != "" && !strings.HasPrefix(*webhookURL, "http://") && !strings.HasPrefix(*webhookURL, "https://") {
+		glog.Fatalf("Invalid flags specified: webhook-url must be an http:// or https:// URL.")
+	}
+	if *scrubSchedule != "" {
+		if err := vol.ValidateSchedule(*scrubSchedule); err != nil {
+			glog.Fatalf("Invalid flags specified: scrub-schedule: %v", err)
+		}
+	}
+	classDirs, err := parseClassExportDirs(*classExportDirs)
+	if err != nil {
+		glog.Fatalf("Invalid flags specified: %v", err)
+	}
+	if len(classDirs) > 0 && *enableXfsQuota {
+		glog.Fatalf("Invalid flags specified: class-export-dirs cannot be set if enable-xfs-quota is true.")
+	}
+	if *consolidatedExport && (len(exportDirs) > 0 || len(classDirs) > 0) {
+		glog.Fatalf("Invalid flags specified: consolidated-export cannot be set if extra-export-dirs or class-export-dirs are.")
+	}
+	faults, err := fault.ParseConfig(*faultInjection)
+	if err != nil {
+		glog.Fatalf("Invalid flags specified: fault-injection: %v", err)
+	}
+	if *reclaimPolicy != string(v1.PersistentVolumeReclaimDelete) && *reclaimPolicy != string(v1.PersistentVolumeReclaimRetain) {
+		glog.Fatalf("Invalid flags specified: reclaim-policy must be one of %s or %s.", v1.PersistentVolumeReclaimDelete, v1.PersistentVolumeReclaimRetain)
+	}
+	if *placement != vol.PlacementMostFree && *placement != vol.PlacementRoundRobin {
+		glog.Fatalf("Invalid flags specified: placement must be one of %s or %s.", vol.PlacementMostFree, vol.PlacementRoundRobin)
+	}
+
+	if *kubeAPIQPS < 0 || *kubeAPIBurst < 0 {
+		glog.Fatalf("Invalid flags specified: kube-api-qps and kube-api-burst must be non-negative.")
+	}
+
+	if *nodeAffinity && outOfCluster {
+		glog.Fatalf("Invalid flags specified: if node-affinity is true, neither master nor kubeconfig may be set.")
+	}
+
+	if *standaloneAddress != "" && *csiEndpoint != "" {
+		glog.Fatalf("Invalid flags specified: standalone-address and csi-endpoint cannot both be set.")
+	}
+	standalone := *standaloneAddress != "" || *csiEndpoint != ""
+	if standalone && (outOfCluster || *nodeAffinity || *rebalancePeriod > 0 || *repairPeriod > 0 || *capacityPeriod > 0 || *watchNamespaces != "" || *denyNamespaces != "" || *claimSelector != "" || *storageClasses != "" || *namespaceQuota != "" || *maxVolumesPerNs > 0 || *deleteThreads > 0 || *maxVolumes > 0 || *metricsPort != 0 || *createStorageClass != "" || *failoverLock != "" || *readReplicaServer != "" || *snapshotPeriod > 0 || *usageThresholds != "" || *usageReportPeriod > 0 || *expiryPeriod > 0 || *scrubSchedule != "") {
+		glog.Fatalf("Invalid flags specified: if standalone-address or csi-endpoint is set, master, kubeconfig, node-affinity, rebalance-period, repair-period, capacity-period, watch-namespaces, deny-namespaces, claim-selector, storage-classes, namespace-quota, max-volumes-per-namespace, delete-threads, max-volumes, metrics-port, create-storage-class, failover-lock, read-replica-server, snapshot-period, usage-thresholds, usage-report-period, expiry-period and scrub-schedule cannot be.")
+	}
+	if *deleteThreads < 0 {
+		glog.Fatalf("Invalid flags specified: delete-threads cannot be negative.")
+	}
+	selector, err := labels.Parse(*claimSelector)
+	if err != nil {
+		glog.Fatalf("Invalid flags specified: claim-selector: %v", err)
+	}
+	className, classDefault, err := parseCreateStorageClass(*createStorageClass)
+	if err != nil {
+		glog.Fatalf("Invalid flags specified: %v", err)
+	}
+	classParameters, err := parseStorageClassParameters(*storageClassParams)
+	if err != nil {
+		glog.Fatalf("Invalid flags specified: %v", err)
+	}
+	if (*failoverVIP != "" || *failoverAcquireHook != "" || *failoverReleaseHook != "") && *failoverLock == "" {
+		glog.Fatalf("Invalid flags specified: failover-vip, failover-acquire-hook and failover-release-hook can only be set if failover-lock is.")
+	}
+	if *failoverVIP != "" && (*serverHostname != "" || net.ParseIP(*failoverVIP) == nil) {
+		glog.Fatalf("Invalid flags specified: failover-vip must be an IP and server-hostname cannot be set if it is.")
+	}
+	if *drbdResource != "" && (*failoverLock == "" || *remoteSource == "") {
+		glog.Fatalf("Invalid flags specified: if drbd-resource is set, failover-lock and remote-source must be.")
+	}
+	if *expireRetained && *expiryPeriod <= 0 {
+		glog.Fatalf("Invalid flags specified: expire-retained can only be true if expiry-period is set.")
+	}
+	if *handoverTimeout > 0 && (*failoverLock == "" || *drbdResource != "") {
+		glog.Fatalf("Invalid flags specified: handover-timeout can only be set if failover-lock is and drbd-resource isn't.")
+	}
+	if *replicationTarget != "" && (*failoverLock == "" || *enableXfsQuota || len(exportDirs) > 0 || len(classDirs) > 0 || *remoteSource != "" || *drbdResource != "" || *handoverTimeout > 0) {
+		glog.Fatalf("Invalid flags specified: if replication-target is set, failover-lock must be and enable-xfs-quota, extra-export-dirs, class-export-dirs, remote-source, drbd-resource and handover-timeout cannot be.")
+	}
+	// Clients must follow the floating IP to whichever replica is active
+	hostname := *serverHostname
+	if *failoverVIP != "" {
+		hostname = *failoverVIP
+	}
+	if *clusterKubeconfigs != "" && (standalone || *nodeAffinity || (hostname == "" && *externalServer == "")) {
+		glog.Fatalf("Invalid flags specified: if cluster-kubeconfigs is set, server-hostname, failover-vip or external-server must be set and standalone-address, csi-endpoint and node-affinity cannot be.")
+	}
+
+	if (*readReplicaServer != "") != (*readReplicaTarget != "") || (*readReplicaServer != "" && (len(exportDirs) > 0 || len(classDirs) > 0 || *externalServer != "")) {
+		glog.Fatalf("Invalid flags specified: read-replica-server and read-replica-target must be set together and extra-export-dirs, class-export-dirs and external-server cannot be set if they are.")
+	}
+	if *serveReadReplica && (!*runServer || *failoverLock != "" || *externalServer != "" || standalone || *readReplicaServer != "") {
+		glog.Fatalf("Invalid flags specified: if serve-read-replica is set, run-server must be and failover-lock, external-server, standalone-address, csi-endpoint and read-replica-server cannot be.")
+	}
+
+	// In standalone mode there is no client, and the server IP is found like
+	// out-of-cluster
+	var clientset kubernetes.Interface
+	if !standalone {
+		var config *rest.Config
+		if outOfCluster {
+			config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
+		} else {
+			config, err = rest.InClusterConfig()
+		}
+		if err != nil {
+			glog.Fatalf("Failed to create config: %v", err)
+		}
+		setRateLimits(config)
+		if faults.APIWriteDelay > 0 {
+			glog.Warningf("Injecting delays of up to %v into API writes", faults.APIWriteDelay)
+			config.WrapTransport = fault.WrapTransport(faults.APIWriteDelay)
+		}
+		clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			glog.Fatalf("Failed to create client: %v", err)
+		}
+		checkPermissions(clientset, "")
+	}
+
+	if *metricsPort != 0 {
+		http.HandleFunc("/metrics", serveMetrics)
 	}
 
-	if *runServer {
+	// A standby replica waits here to take over mounting and serving the
+	// storage
+	if *failoverLock != "" {
+		// Meanwhile it serves its status, and metrics, as standby
+		if *metricsPort != 0 {
+			http.Handle("/failover", failover)
+			listen(*metricsPort, "metrics")
+		}
+		awaitFailoverLock(clientset, *failoverLock)
+		if *drbdResource != "" {
+			if err := server.PromoteDRBD(*drbdResource, *drbdSyncTimeout); err != nil {
+				glog.Fatalf("Error promoting DRBD resource, exiting so that another replica takes over: %v", err)
+			}
+		}
+	}
+
+	if *externalServer != "" {
+		if _, _, err := vol.ParseExternalServer(*externalServer); err != nil {
+			glog.Fatalf("Invalid flags specified: %v", err)
+		}
+		if *remoteSource != "" || len(exportDirs) > 0 || len(classDirs) > 0 || *enableXfsQuota {
+			glog.Fatalf("Invalid flags specified: if external-server is set, remote-source, extra-export-dirs, class-export-dirs and enable-xfs-quota cannot be.")
+		}
+		glog.Infof("Mounting external NFS server export %s at %s", *externalServer, exportDir)
+		if err := server.MountRemote(*externalServer, "nfs", *remoteOptions, exportDir); err != nil {
+			glog.Fatalf("Error mounting external NFS server export: %v", err)
+		}
+	}
+
+	if *remoteSource != "" {
+		glog.Infof("Mounting remote filesystem %s at %s", *remoteSource, exportDir)
+		if err := server.MountRemote(*remoteSource, *remoteFsType, *remoteOptions, exportDir); err != nil {
+			glog.Fatalf("Error mounting remote filesystem: %v", err)
+		}
+	}
+
+	// Volumes migrated to other export roots are exported from where they
+	// were created, where their directories are bind mounted
+	if err := vol.RestoreBindMounts(exportDir); err != nil {
+		glog.Fatalf("Error restoring bind mounts of migrated volumes: %v", err)
+	}
+
+	if *runServer && *externalServer == "" {
 		glog.Infof("Starting NFS server!")
-		err := server.Setup(ganeshaConfig, *gracePeriod)
+		// With failover, rpc.statd identifies the server by the floating IP
+		// and keeps its clients on the shared storage, so that the replica
+		// taking over notifies the clients of the lost one to reclaim their
+		// locks
+		statdName := *statdHostname
+		if statdName == "" {
+			statdName = *failoverVIP
+		}
+		statdStateDir := ""
+		if *failoverLock != "" {
+			statdStateDir = path.Join(exportDir, recoveryDir, "statd")
+		}
+		err := server.Setup(ganeshaConfig, *gracePeriod, *enableNLM, statdName, statdStateDir)
 		if err != nil {
 			glog.Fatalf("Error setting up NFS server: %v", err)
 		}
+		if *failoverLock != "" {
+			err = server.SetRecoveryRoot(ganeshaConfig, path.Join(exportDir, recoveryDir))
+			if err != nil {
+				glog.Fatalf("Error setting up NFS server: %v", err)
+			}
+		}
+		if *serveReadReplica {
+			err = server.SetReadOnlyExport(ganeshaConfig, path.Join(exportDir, vol.ReadReplicaDir))
+			if err != nil {
+				glog.Fatalf("Error setting up NFS server: %v", err)
+			}
+		}
 		err = server.Start(ganeshaLog, ganeshaPid, ganeshaConfig)
 		if err != nil {
 			glog.Fatalf("Error starting NFS server: %v", err)
@@ -119,21 +462,198 @@ func main() {
 		}()
 	}
 
-	var config *rest.Config
-	var err error
-	if outOfCluster {
-		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
-	} else {
-		config, err = rest.InClusterConfig()
+	if faults.ExecFailureRate > 0 {
+		glog.Warningf("Injecting failures of %v of external commands", faults.ExecFailureRate)
+		server.SetRunner(fault.NewRunner(runner.New(), faults.ExecFailureRate))
+		vol.SetRunner(fault.NewRunner(runner.New(), faults.ExecFailureRate))
+		csidriver.SetRunner(fault.NewRunner(runner.New(), faults.ExecFailureRate))
+		cmdRunner = fault.NewRunner(runner.New(), faults.ExecFailureRate)
 	}
-	if err != nil {
-		glog.Fatalf("Failed to create config: %v", err)
+	if faults.KillPeriod > 0 {
+		glog.Warningf("Injecting faults by killing one of %v every %v", faults.Daemons, faults.KillPeriod)
+		go fault.KillDaemons(faults.KillPeriod, faults.Daemons, wait.NeverStop)
 	}
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		glog.Fatalf("Failed to create client: %v", err)
+
+	// A read replica only receives and serves what another provisioner
+	// replicates to it
+	if *serveReadReplica {
+		daemon, err := startReplicaDaemon(path.Join(exportDir, vol.ReadReplicaDir))
+		if err != nil {
+			glog.Fatalf("Error starting rsync daemon to receive read replicas: %v", err)
+		}
+		glog.Fatalf("rsync daemon receiving read replicas exited: %v", daemon.Wait())
+	}
+
+	// Create the provisioner: it implements the Provisioner interface expected by
+	// the controller
+	nfsProvisioner := vol.NewNFSProvisioner(exportDir, clientset, vol.Config{
+		OutOfCluster:         outOfCluster || standalone,
+		UseGanesha:           *useGanesha,
+		GaneshaConfig:        ganeshaConfig,
+		EnableXfsQuota:       *enableXfsQuota,
+		ServerHostname:       hostname,
+		ServerInterface:      *serverInterface,
+		HostNetwork:          *hostNetwork,
+		NodeAffinity:         *nodeAffinity,
+		ExtraExportDirs:      exportDirs,
+		Placement:            *placement,
+		ClassExportDirs:      classDirs,
+		ExternalServer:       *externalServer,
+		SelfTest:             *selfTest,
+		ConsolidatedExport:   *consolidatedExport,
+		ExportsDir:           *exportsDir,
+		ExportfsBatchWindow:  *exportfsBatchWindow,
+		ExportTemplate:       *exportTemplate,
+		PreProvisionHook:     *preProvisionHook,
+		PostDeleteHook:       *postDeleteHook,
+		ParameterOverrides:   overrides,
+		NamespaceDirectories: *namespaceDirs,
+		NamespaceQuota:       quota,
+		MaxVolumes:           *maxVolumes,
+		QuotaProjectPoolSize: *quotaProjectPool,
+		DirectExport:         *directExport,
+		DrainTimeout:         *drainTimeout,
+		DataSourceHosts:      sourceHosts,
+		ReadReplicaServer:    *readReplicaServer,
+		WebhookURL:           *webhookURL,
+	})
+
+	// Fence off the replica this one took over from, in case it's only paused
+	if *failoverLock != "" {
+		if err := nfsProvisioner.(vol.Fencer).TakeEpoch(); err != nil {
+			glog.Fatalf("Error taking over the storage: %v", err)
+		}
+	}
+
+	if *externalServer != "" && *healthPeriod > 0 {
+		healthMonitor := nfsProvisioner.(vol.HealthMonitor)
+		go healthMonitor.MonitorHealth(*healthPeriod, *healthTimeout, wait.NeverStop)
+		if *healthPort != 0 {
+			http.Handle("/healthz", healthMonitor)
+			listen(*healthPort, "health")
+		}
+	}
+
+	if *metricsPort != 0 {
+		setMetricsServer(nfsProvisioner.(vol.MetricsServer))
+		// The health server serves the metrics too if it's on the same port
+		listen(*metricsPort, "metrics")
+	}
+
+	if *directoryPoolSize > 0 {
+		go nfsProvisioner.(vol.DirectoryPool).MaintainPool(*directoryPoolSize, directoryPoolPeriod, wait.NeverStop)
+	}
+
+	if *repairPeriod > 0 {
+		go nfsProvisioner.(vol.ExportRepairer).RepairExports(*repairPeriod, wait.NeverStop)
+	}
+
+	if *verifyExportsPeriod > 0 {
+		go nfsProvisioner.(vol.ExportVerifier).VerifyExports(*verifyExportsPeriod, wait.NeverStop)
+	}
+
+	if *rebalancePeriod > 0 {
+		go nfsProvisioner.(vol.Rebalancer).Rebalance(*rebalancePeriod, wait.NeverStop)
+	}
+
+	if *readReplicaServer != "" {
+		go nfsProvisioner.(vol.ReadReplicator).ReplicateReads(*readReplicaTarget, *readReplicaPeriod, wait.NeverStop)
 	}
 
+	if len(thresholds) > 0 {
+		go nfsProvisioner.(vol.UsageMonitor).MonitorUsage(thresholds, *usagePeriod, wait.NeverStop)
+	}
+
+	if *usageReportPeriod > 0 {
+		go nfsProvisioner.(vol.UsageReporter).ReportUsage(*usageReportPeriod, wait.NeverStop)
+	}
+
+	if *expiryPeriod > 0 {
+		go nfsProvisioner.(vol.Expirer).ExpireVolumes(*expiryPeriod, *expireRetained, wait.NeverStop)
+	}
+
+	if *snapshotPeriod > 0 {
+		go nfsProvisioner.(vol.Snapshotter).TakeSnapshots(*snapshotPeriod, wait.NeverStop)
+	}
+
+	if *scrubSchedule != "" {
+		go nfsProvisioner.(vol.Scrubber).ScrubVolumes(*scrubSchedule, wait.NeverStop)
+	}
+
+	if *capacityPeriod > 0 {
+		go nfsProvisioner.(vol.CapacityPublisher).PublishCapacity(*provisioner, *capacityPeriod, wait.NeverStop)
+	}
+
+	if className != "" {
+		go nfsProvisioner.(vol.StorageClassReconciler).ReconcileStorageClass(*provisioner, className, classParameters, classDefault, storageClassPeriod, wait.NeverStop)
+	}
+
+	if *csiEndpoint != "" {
+		controllerServer, err := csidriver.NewControllerServer(nfsProvisioner, path.Join(exportDir, csiDir))
+		if err != nil {
+			glog.Fatalf("Error creating CSI Controller service: %v", err)
+		}
+		identityServer := csidriver.NewIdentityServer(*provisioner, version, true)
+		glog.Fatalf("Error serving CSI: %v", csidriver.Serve(*csiEndpoint, identityServer, controllerServer, nil))
+	}
+
+	if standalone {
+		shareHandler, err := vol.NewShareHandler(nfsProvisioner, path.Join(exportDir, sharesDir))
+		if err != nil {
+			glog.Fatalf("Error creating shares API: %v", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/shares", shareHandler)
+		mux.Handle("/shares/", shareHandler)
+		glog.Infof("Serving shares API on %s", *standaloneAddress)
+		glog.Fatalf("Error serving shares API: %v", http.ListenAndServe(*standaloneAddress, mux))
+	}
+
+	options := []func(*controller.ProvisionController) error{
+		controller.ReclaimPolicy(v1.PersistentVolumeReclaimPolicy(*reclaimPolicy)),
+		controller.WatchNamespaces(splitNamespaces(*watchNamespaces)),
+		controller.DenyNamespaces(splitNamespaces(*denyNamespaces)),
+		controller.ClaimSelector(selector),
+		controller.StorageClasses(splitNamespaces(*storageClasses)),
+		controller.MaxVolumesPerNamespace(*maxVolumesPerNs),
+		controller.DeleteThreadiness(*deleteThreads),
+		controller.ClaimLock(*claimLock),
+	}
+
+	// Every other cluster gets a provision controller of its own, provisioning
+	// on the same storage
+	if *clusterKubeconfigs != "" {
+		for _, cluster := range strings.Split(*clusterKubeconfigs, ",") {
+			clusterClientset, err := newClusterClientset(cluster)
+			if err != nil {
+				glog.Fatalf("Failed to create client of cluster %s: %v", cluster, err)
+			}
+			checkPermissions(clusterClientset, cluster)
+			clusterProvisioner := nfsProvisioner.(vol.MultiClusterProvisioner).ForCluster(clusterClientset)
+			if className != "" {
+				go clusterProvisioner.(vol.StorageClassReconciler).ReconcileStorageClass(*provisioner, className, classParameters, classDefault, storageClassPeriod, wait.NeverStop)
+			}
+			pc := newProvisionController(clusterClientset, clusterProvisioner, options)
+			glog.Infof("Provisioning volumes for claims of cluster %s", cluster)
+			go pc.Run(wait.NeverStop)
+		}
+	}
+
+	// Start the provision controller which will dynamically provision NFS PVs
+	pc := newProvisionController(clientset, nfsProvisioner, options)
+	if *failoverLock != "" {
+		nfsProvisioner.(vol.ExportRepairer).RestoreExports()
+		becomeActive(clientset)
+		if *replicationTarget != "" {
+			go nfsProvisioner.(vol.Replicator).Replicate(*replicationTarget, *replicationPeriod, wait.NeverStop)
+		}
+	}
+	pc.Run(wait.NeverStop)
+}
+
+// newProvisionController returns a provision controller for the claims of the
+// cluster of the given client.
+func newProvisionController(clientset kubernetes.Interface, nfsProvisioner controller.Provisioner, options []func(*controller.ProvisionController) error) *controller.ProvisionController {
 	// The controller needs to know what the server version is because out-of-tree
 	// provisioners aren't officially supported until 1.5
 	serverVersion, err := clientset.Discovery().ServerVersion()
@@ -141,19 +661,126 @@ func main() {
 		glog.Fatalf("Error getting server version: %v", err)
 	}
 
-	// Create the provisioner: it implements the Provisioner interface expected by
-	// the controller
-	nfsProvisioner := vol.NewNFSProvisioner(exportDir, clientset, outOfCluster, *useGanesha, ganeshaConfig, *enableXfsQuota, *serverHostname)
-
-	// Start the provision controller which will dynamically provision NFS PVs
-	pc := controller.NewProvisionController(
+	return controller.NewProvisionController(
 		clientset,
 		*provisioner,
 		nfsProvisioner,
 		serverVersion.GitVersion,
+		options...,
 	)
+}
 
-	pc.Run(wait.NeverStop)
+// checkPermissions exits if the client's user lacks any of the permissions
+// the provision controller needs in the watched namespaces of the given
+// cluster, "" for the one the provisioner runs in, listing them all.
+func checkPermissions(clientset kubernetes.Interface, cluster string) {
+	err := controller.CheckPermissions(clientset, splitNamespaces(*watchNamespaces))
+	if _, ok := err.(*controller.MissingPermissionsError); ok {
+		if cluster != "" {
+			glog.Fatalf("Permission check of cluster %s failed: %v", cluster, err)
+		}
+		glog.Fatalf("Permission check failed: %v", err)
+	} else if err != nil {
+		glog.Warningf("Not checking permissions: %v", err)
+	}
+}
+
+// newClusterClientset creates a client from the given kubeconfig file,
+// optionally followed by :<context> to use a context other than its current
+// one.
+func newClusterClientset(cluster string) (kubernetes.Interface, error) {
+	parts := strings.SplitN(cluster, ":", 2)
+	rules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: parts[0]}
+	overrides := &clientcmd.ConfigOverrides{}
+	if len(parts) == 2 {
+		overrides.CurrentContext = parts[1]
+	}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	setRateLimits(config)
+	return kubernetes.NewForConfig(config)
+}
+
+// setRateLimits sets the given client config's rate limits to kube-api-qps and
+// kube-api-burst, if set, instead of the client-go defaults.
+func setRateLimits(config *rest.Config) {
+	if *kubeAPIQPS > 0 {
+		config.QPS = float32(*kubeAPIQPS)
+	}
+	if *kubeAPIBurst > 0 {
+		config.Burst = *kubeAPIBurst
+	}
+}
+
+// listening is the set of ports the default mux is being served on.
+var listening = map[int]bool{}
+
+// listen serves the default mux on the given port in the background, unless
+// it is already being served there, e.g. by the health server serving the
+// metrics too. The process exits if serving fails.
+func listen(port int, what string) {
+	if listening[port] {
+		return
+	}
+	listening[port] = true
+	go func() {
+		glog.Fatalf("Error serving %s: %v", what, http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	}()
+}
+
+var (
+	// metricsServer is the provisioner whose metrics are served, once it is
+	// created
+	metricsServer      vol.MetricsServer
+	metricsServerMutex sync.Mutex
+)
+
+// setMetricsServer sets the provisioner whose metrics are served.
+func setMetricsServer(server vol.MetricsServer) {
+	metricsServerMutex.Lock()
+	defer metricsServerMutex.Unlock()
+	metricsServer = server
+}
+
+// serveMetrics writes the metrics of the provisioner, once it is created, and
+// of failover, if failover-lock is set.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsServerMutex.Lock()
+	server := metricsServer
+	metricsServerMutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if server != nil {
+		server.ServeMetrics(w, r)
+	}
+	if *failoverLock != "" {
+		failover.writeMetrics(w)
+	}
+}
+
+// splitNamespaces splits a comma-separated list of namespaces, or of other
+// names, e.g. StorageClasses, returning nil if it is empty.
+func splitNamespaces(namespaces string) []string {
+	if namespaces == "" {
+		return nil
+	}
+	return strings.Split(namespaces, ",")
+}
+
+// parseUsageThresholds parses a comma-separated list of usage thresholds, each
+// a percent from 1 to 100, returning nil if it is empty.
+func parseUsageThresholds(usageThresholds string) ([]int, error) {
+	var thresholds []int
+	for _, value := range splitNamespaces(usageThresholds) {
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold < 1 || threshold > 100 {
+			return nil, fmt.Errorf("%q is not a percent from 1 to 100", value)
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	return thresholds, nil
 }
 
 // validateProvisioner tests if provisioner is a valid qualified name.
@@ -170,3 +797,56 @@ func validateProvisioner(provisioner string, fldPath *field.Path) field.ErrorLis
 	}
 	return allErrs
 }
+
+// parseClassExportDirs parses a comma-separated list of class=directory pairs
+// into a map of storage class names to export directories.
+func parseClassExportDirs(classExportDirs string) (map[string]string, error) {
+	classDirs := map[string]string{}
+	if classExportDirs == "" {
+		return classDirs, nil
+	}
+	for _, pair := range strings.Split(classExportDirs, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("class-export-dirs entry %q is not of the form class=directory", pair)
+		}
+		if _, ok := classDirs[parts[0]]; ok {
+			return nil, fmt.Errorf("class-export-dirs maps class %q more than once", parts[0])
+		}
+		classDirs[parts[0]] = parts[1]
+	}
+	return classDirs, nil
+}
+
+// parseCreateStorageClass parses a StorageClass name, optionally followed by
+// :default, into the name and whether the class is to be the default.
+func parseCreateStorageClass(createStorageClass string) (string, bool, error) {
+	if createStorageClass == "" {
+		return "", false, nil
+	}
+	parts := strings.SplitN(createStorageClass, ":", 2)
+	if len(parts) == 2 && parts[1] != "default" {
+		return "", false, fmt.Errorf("create-storage-class %q is not of the form name[:default]", createStorageClass)
+	}
+	if errs := validation.IsDNS1123Subdomain(parts[0]); len(errs) > 0 {
+		return "", false, fmt.Errorf("create-storage-class name %q is invalid: %v", parts[0], errs)
+	}
+	return parts[0], len(parts) == 2, nil
+}
+
+// parseStorageClassParameters parses a semicolon-separated list of key=value
+// pairs into a map of StorageClass parameters, nil if it is empty.
+func parseStorageClassParameters(storageClassParameters string) (map[string]string, error) {
+	if storageClassParameters == "" {
+		return nil, nil
+	}
+	parameters := map[string]string{}
+	for _, pair := range strings.Split(storageClassParameters, ";") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("storage-class-parameters entry %q is not of the form key=value", pair)
+		}
+		parameters[parts[0]] = parts[1]
+	}
+	return parameters, nil
+}
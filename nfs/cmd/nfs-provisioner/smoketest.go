@@ -0,0 +1,163 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// smokeTest creates a claim against a class, waits for it to be bound, checks
+// from a pod that its volume can be written to and read back from, then
+// deletes the pod and claim. It returns an error if any step fails.
+func smokeTest(args []string) error {
+	flags := flag.NewFlagSet("smoke-test", flag.ExitOnError)
+	master := flags.String("master", "", "Master URL to build a client config from. If neither this nor kubeconfig is set, the in-cluster config is used.")
+	kubeconfig := flags.String("kubeconfig", "", "Absolute path to the kubeconfig file. If neither this nor master is set, the in-cluster config is used.")
+	class := flags.String("class", "", "Name of the StorageClass to create the claim against. Required.")
+	namespace := flags.String("namespace", "default", "Namespace to create the claim and pod in. Default default.")
+	image := flags.String("image", "busybox", "Image of the pod that writes to and reads back from the volume. Must have sh. Default busybox.")
+	size := flags.String("size", "1Mi", "Size of the claim. Default 1Mi.")
+	timeout := flags.Duration("timeout", 5*time.Minute, "How long to wait for the claim to be bound and for the pod to finish, each. Default 5m.")
+	flags.Parse(args)
+
+	if *class == "" {
+		return fmt.Errorf("class must be set")
+	}
+	quantity, err := resource.ParseQuantity(*size)
+	if err != nil {
+		return fmt.Errorf("invalid size %q: %v", *size, err)
+	}
+
+	var config *rest.Config
+	if *master != "" || *kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create config: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create client: %v", err)
+	}
+
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "nfs-smoke-test-",
+			Annotations:  map[string]string{v1.BetaStorageClassAnnotation: *class},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): quantity,
+				},
+			},
+		},
+	}
+	claim, err = client.Core().PersistentVolumeClaims(*namespace).Create(claim)
+	if err != nil {
+		return fmt.Errorf("error creating claim: %v", err)
+	}
+	glog.Infof("Created claim %s/%s", *namespace, claim.Name)
+	defer func() {
+		if err := client.Core().PersistentVolumeClaims(*namespace).Delete(claim.Name, nil); err != nil {
+			glog.Errorf("Error deleting claim %s/%s: %v", *namespace, claim.Name, err)
+		}
+	}()
+
+	err = wait.PollImmediate(time.Second, *timeout, func() (bool, error) {
+		claim, err = client.Core().PersistentVolumeClaims(*namespace).Get(claim.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return claim.Status.Phase == v1.ClaimBound, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error waiting for claim %s/%s to be bound: %v", *namespace, claim.Name, err)
+	}
+	glog.Infof("Claim %s/%s bound to volume %s", *namespace, claim.Name, claim.Spec.VolumeName)
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "nfs-smoke-test-",
+		},
+		Spec: v1.PodSpec{
+			RestartPolicy: v1.RestartPolicyNever,
+			Containers: []v1.Container{
+				{
+					Name:    "smoke-test",
+					Image:   *image,
+					Command: []string{"sh", "-c", "echo ok > /mnt/smoke-test && grep -q ok /mnt/smoke-test && rm /mnt/smoke-test"},
+					VolumeMounts: []v1.VolumeMount{
+						{
+							Name:      "volume",
+							MountPath: "/mnt",
+						},
+					},
+				},
+			},
+			Volumes: []v1.Volume{
+				{
+					Name: "volume",
+					VolumeSource: v1.VolumeSource{
+						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claim.Name},
+					},
+				},
+			},
+		},
+	}
+	pod, err = client.Core().Pods(*namespace).Create(pod)
+	if err != nil {
+		return fmt.Errorf("error creating pod: %v", err)
+	}
+	glog.Infof("Created pod %s/%s", *namespace, pod.Name)
+	defer func() {
+		if err := client.Core().Pods(*namespace).Delete(pod.Name, nil); err != nil {
+			glog.Errorf("Error deleting pod %s/%s: %v", *namespace, pod.Name, err)
+		}
+	}()
+
+	err = wait.PollImmediate(time.Second, *timeout, func() (bool, error) {
+		pod, err = client.Core().Pods(*namespace).Get(pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error waiting for pod %s/%s to finish: %v", *namespace, pod.Name, err)
+	}
+	if pod.Status.Phase != v1.PodSucceeded {
+		return fmt.Errorf("pod %s/%s failed to write to and read back from the volume", *namespace, pod.Name)
+	}
+
+	glog.Infof("Smoke test of class %s passed", *class)
+	return nil
+}
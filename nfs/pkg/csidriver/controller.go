@@ -0,0 +1,292 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csidriver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	vol "github.com/kubernetes-incubator/external-storage/nfs/pkg/volume"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// Keys of the volume context returned by CreateVolume and passed to
+	// NodePublishVolume
+	contextServer       = "server"
+	contextShare        = "share"
+	contextMountOptions = "mountOptions"
+
+	// Prefix of the parameters the external-provisioner adds to those of the
+	// StorageClass, e.g. the claim's name and namespace if it is run with
+	// --extra-create-metadata. They are not passed to the provisioner.
+	reservedParameterPrefix = "csi.storage.k8s.io/"
+	parameterPVCName        = reservedParameterPrefix + "pvc/name"
+	parameterPVCNamespace   = reservedParameterPrefix + "pvc/namespace"
+)
+
+// controllerServer creates and deletes volumes with a provisioner, without
+// Kubernetes: like the shares API, the PV the provisioner returns for each
+// volume is persisted as JSON in stateDir, because deleting it needs what the
+// provisioner recorded in it.
+type controllerServer struct {
+	provisioner controller.Provisioner
+	stateDir    string
+	mutex       sync.Mutex
+}
+
+var _ csi.ControllerServer = &controllerServer{}
+
+// NewControllerServer returns a Controller service that creates and deletes
+// volumes with the given provisioner, persisting them in stateDir.
+func NewControllerServer(provisioner controller.Provisioner, stateDir string) (csi.ControllerServer, error) {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating CSI state dir %s: %v", stateDir, err)
+	}
+	return &controllerServer{provisioner: provisioner, stateDir: stateDir}, nil
+}
+
+func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	name := req.GetName()
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) != 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume name %q: %v", name, errs)
+	}
+	if len(req.GetVolumeCapabilities()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume capabilities are required")
+	}
+	if err := checkCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	required := req.GetCapacityRange().GetRequiredBytes()
+	limit := req.GetCapacityRange().GetLimitBytes()
+	if limit != 0 && required > limit {
+		return nil, status.Errorf(codes.InvalidArgument, "required bytes %d exceed limit bytes %d", required, limit)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	file := s.getFile(name)
+	volume, err := readVolume(file)
+	if err == nil {
+		// CreateVolume must be idempotent: the sidecar retries it e.g. after
+		// timing out
+		capacity := getCapacity(volume)
+		if capacity < required || (limit != 0 && capacity > limit) {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %q already exists with capacity %d", name, capacity)
+		}
+		return &csi.CreateVolumeResponse{Volume: toCSIVolume(volume)}, nil
+	} else if !os.IsNotExist(err) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceName(v1.ResourceStorage): *resource.NewQuantity(required, resource.BinarySI)},
+			},
+		},
+	}
+	parameters := map[string]string{}
+	for k, v := range req.GetParameters() {
+		switch {
+		case k == parameterPVCName:
+			claim.Name = v
+		case k == parameterPVCNamespace:
+			claim.Namespace = v
+		case strings.HasPrefix(k, reservedParameterPrefix):
+		default:
+			parameters[k] = v
+		}
+	}
+	volume, err = s.provisioner.Provision(controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        name,
+		PVC:                           claim,
+		Parameters:                    parameters,
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error creating volume: %v", err)
+	}
+	if err := writeVolume(file, volume); err != nil {
+		s.provisioner.Delete(volume)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	glog.Infof("Created volume %q", name)
+	return &csi.CreateVolumeResponse{Volume: toCSIVolume(volume)}, nil
+}
+
+func (s *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	name := req.GetVolumeId()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	file := s.getFile(name)
+	volume, err := readVolume(file)
+	if os.IsNotExist(err) {
+		// Already deleted
+		return &csi.DeleteVolumeResponse{}, nil
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.provisioner.Delete(volume); err != nil {
+		return nil, status.Errorf(codes.Internal, "error deleting volume: %v", err)
+	}
+	if err := os.Remove(file); err != nil {
+		return nil, status.Errorf(codes.Internal, "deleted volume but error removing its state file: %v", err)
+	}
+	glog.Infof("Deleted volume %q", name)
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+func (s *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	name := req.GetVolumeId()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+	if len(req.GetVolumeCapabilities()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume capabilities are required")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, err := readVolume(s.getFile(name)); os.IsNotExist(err) {
+		return nil, status.Errorf(codes.NotFound, "volume %q not found", name)
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := checkCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
+	}
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: req.GetVolumeCapabilities(),
+			Parameters:         req.GetParameters(),
+		},
+	}, nil
+}
+
+func (s *controllerServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	return &csi.ControllerGetCapabilitiesResponse{
+		Capabilities: []*csi.ControllerServiceCapability{
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME},
+				},
+			},
+		},
+	}, nil
+}
+
+func (s *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (s *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (s *controllerServer) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (s *controllerServer) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (s *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (s *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (s *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+// getFile returns the path of the file the PV of the named volume is persisted
+// in. The name must not contain a path separator.
+func (s *controllerServer) getFile(name string) string {
+	return path.Join(s.stateDir, path.Base(name)+".json")
+}
+
+func readVolume(file string) (*v1.PersistentVolume, error) {
+	read, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	volume := &v1.PersistentVolume{}
+	if err := json.Unmarshal(read, volume); err != nil {
+		return nil, fmt.Errorf("error decoding volume state file %s: %v", file, err)
+	}
+	return volume, nil
+}
+
+func writeVolume(file string, volume *v1.PersistentVolume) error {
+	data, err := json.Marshal(volume)
+	if err != nil {
+		return fmt.Errorf("error encoding volume: %v", err)
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return fmt.Errorf("error writing volume state file %s: %v", file, err)
+	}
+	return nil
+}
+
+func getCapacity(volume *v1.PersistentVolume) int64 {
+	capacity := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	return capacity.Value()
+}
+
+// toCSIVolume returns the CSI volume for the given PV, with the server and
+// path to mount it from and its mount options in its context.
+func toCSIVolume(volume *v1.PersistentVolume) *csi.Volume {
+	context := map[string]string{}
+	if nfs := volume.Spec.NFS; nfs != nil {
+		context[contextServer] = nfs.Server
+		context[contextShare] = nfs.Path
+	}
+	if mountOptions, ok := volume.Annotations[vol.MountOptionAnnotation]; ok {
+		context[contextMountOptions] = mountOptions
+	}
+	return &csi.Volume{
+		VolumeId:      volume.Name,
+		CapacityBytes: getCapacity(volume),
+		VolumeContext: context,
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package csidriver serves the CSI Identity, Controller and Node services so
+// that the provisioner can be deployed as a CSI driver with the standard
+// sidecars: the Controller service creates and deletes volumes with the same
+// provisioner, hence export logic, as when provisioning for claims, and the
+// Node service mounts them with NFS.
+package csidriver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// Serve serves the given CSI services on endpoint, a unix socket path
+// optionally prefixed with unix://, until it fails. Either of the controller
+// and node services may be nil in which case it is not served.
+func Serve(endpoint string, identity csi.IdentityServer, controller csi.ControllerServer, node csi.NodeServer) error {
+	socket := strings.TrimPrefix(endpoint, "unix://")
+	if strings.Contains(socket, "://") {
+		return fmt.Errorf("endpoint %q is not a unix socket", endpoint)
+	}
+	// A socket left over from a previous run would make listening fail
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing socket %s: %v", socket, err)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %v", socket, err)
+	}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(logErrors))
+	csi.RegisterIdentityServer(server, identity)
+	if controller != nil {
+		csi.RegisterControllerServer(server, controller)
+	}
+	if node != nil {
+		csi.RegisterNodeServer(server, node)
+	}
+	glog.Infof("Serving CSI on %s", socket)
+	return server.Serve(listener)
+}
+
+// logErrors logs the error of every failed call, since the sidecars only
+// surface them as events if at all.
+func logErrors(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	glog.V(4).Infof("%s: %+v", info.FullMethod, req)
+	resp, err := handler(ctx, req)
+	if err != nil {
+		glog.Errorf("%s failed: %v", info.FullMethod, err)
+	}
+	return resp, err
+}
+
+type identityServer struct {
+	name       string
+	version    string
+	controller bool
+}
+
+var _ csi.IdentityServer = &identityServer{}
+
+// NewIdentityServer returns an Identity service for the driver with the given
+// name and version that advertises the Controller service if controller is
+// set.
+func NewIdentityServer(name, version string, controller bool) csi.IdentityServer {
+	return &identityServer{name: name, version: version, controller: controller}
+}
+
+func (s *identityServer) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	return &csi.GetPluginInfoResponse{Name: s.name, VendorVersion: s.version}, nil
+}
+
+func (s *identityServer) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	resp := &csi.GetPluginCapabilitiesResponse{}
+	if s.controller {
+		resp.Capabilities = append(resp.Capabilities, &csi.PluginCapability{
+			Type: &csi.PluginCapability_Service_{
+				Service: &csi.PluginCapability_Service{Type: csi.PluginCapability_Service_CONTROLLER_SERVICE},
+			},
+		})
+	}
+	return resp, nil
+}
+
+func (s *identityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	return &csi.ProbeResponse{}, nil
+}
+
+// checkCapabilities returns an error if any of the given capabilities can't
+// be satisfied: volumes are NFS exports so they can only be mounted, not used
+// as block devices, by any number of nodes.
+func checkCapabilities(capabilities []*csi.VolumeCapability) error {
+	for _, capability := range capabilities {
+		if capability.GetBlock() != nil {
+			return fmt.Errorf("block access type is not supported")
+		}
+		if capability.GetMount() == nil {
+			return fmt.Errorf("access type must be mount")
+		}
+	}
+	return nil
+}
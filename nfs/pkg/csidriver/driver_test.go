@@ -0,0 +1,239 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csidriver
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/runner"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+type fakeProvisioner struct {
+	parameters map[string]string
+	claim      *v1.PersistentVolumeClaim
+	deleted    []string
+}
+
+func (p *fakeProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	p.parameters = options.Parameters
+	p.claim = options.PVC
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        options.PVName,
+			Annotations: map[string]string{"volume.beta.kubernetes.io/mount-options": "vers=4.1"},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				NFS: &v1.NFSVolumeSource{Server: "10.0.0.1", Path: "/export/" + options.PVName},
+			},
+		},
+	}, nil
+}
+
+func (p *fakeProvisioner) Delete(volume *v1.PersistentVolume) error {
+	p.deleted = append(p.deleted, volume.Name)
+	return nil
+}
+
+func mountCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	}
+}
+
+func TestCreateDeleteVolume(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "csi-driver-test")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	provisioner := &fakeProvisioner{}
+	server, err := NewControllerServer(provisioner, path.Join(tmpDir, "state"))
+	if err != nil {
+		t.Fatalf("error creating controller server: %v", err)
+	}
+
+	req := &csi.CreateVolumeRequest{
+		Name:               "pvc-1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		Parameters: map[string]string{
+			"gid":                                "1000",
+			"csi.storage.k8s.io/pvc/name":        "claim",
+			"csi.storage.k8s.io/pvc/namespace":   "default",
+			"csi.storage.k8s.io/pv/name":         "pvc-1",
+			"csi.storage.k8s.io/provisioner-foo": "bar",
+		},
+	}
+	resp, err := server.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	expectedVolume := &csi.Volume{
+		VolumeId:      "pvc-1",
+		CapacityBytes: 1 << 30,
+		VolumeContext: map[string]string{"server": "10.0.0.1", "share": "/export/pvc-1", "mountOptions": "vers=4.1"},
+	}
+	if !reflect.DeepEqual(expectedVolume, resp.GetVolume()) {
+		t.Errorf("expected volume %+v but got %+v", expectedVolume, resp.GetVolume())
+	}
+	if expected := map[string]string{"gid": "1000"}; !reflect.DeepEqual(expected, provisioner.parameters) {
+		t.Errorf("expected parameters %v but got %v", expected, provisioner.parameters)
+	}
+	if provisioner.claim.Namespace != "default" || provisioner.claim.Name != "claim" {
+		t.Errorf("expected claim default/claim but got %s/%s", provisioner.claim.Namespace, provisioner.claim.Name)
+	}
+
+	// Retrying is idempotent but a different capacity conflicts
+	if resp, err := server.CreateVolume(context.Background(), req); err != nil {
+		t.Errorf("retried CreateVolume failed: %v", err)
+	} else if !reflect.DeepEqual(expectedVolume, resp.GetVolume()) {
+		t.Errorf("expected retried volume %+v but got %+v", expectedVolume, resp.GetVolume())
+	}
+	req.CapacityRange.RequiredBytes = 2 << 30
+	if _, err := server.CreateVolume(context.Background(), req); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected AlreadyExists creating volume with a different capacity but got %v", err)
+	}
+
+	if _, err := server.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "pvc-1"}); err != nil {
+		t.Errorf("DeleteVolume failed: %v", err)
+	}
+	if _, err := server.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "pvc-1"}); err != nil {
+		t.Errorf("retried DeleteVolume failed: %v", err)
+	}
+	if expected := []string{"pvc-1"}; !reflect.DeepEqual(expected, provisioner.deleted) {
+		t.Errorf("expected deleted volumes %v but got %v", expected, provisioner.deleted)
+	}
+}
+
+func TestCreateVolumeInvalid(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "csi-driver-test")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	server, err := NewControllerServer(&fakeProvisioner{}, tmpDir)
+	if err != nil {
+		t.Fatalf("error creating controller server: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  *csi.CreateVolumeRequest
+	}{
+		{
+			name: "invalid name",
+			req:  &csi.CreateVolumeRequest{Name: "../pvc-1", VolumeCapabilities: []*csi.VolumeCapability{mountCapability()}},
+		},
+		{
+			name: "no capabilities",
+			req:  &csi.CreateVolumeRequest{Name: "pvc-1"},
+		},
+		{
+			name: "block",
+			req: &csi.CreateVolumeRequest{
+				Name: "pvc-1",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}},
+				},
+			},
+		},
+		{
+			name: "required exceeds limit",
+			req: &csi.CreateVolumeRequest{
+				Name:               "pvc-1",
+				CapacityRange:      &csi.CapacityRange{RequiredBytes: 2, LimitBytes: 1},
+				VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+			},
+		},
+	}
+	for _, test := range tests {
+		if _, err := server.CreateVolume(context.Background(), test.req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("test %q: expected InvalidArgument but got %v", test.name, err)
+		}
+	}
+}
+
+func TestNodePublishUnpublishVolume(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "csi-driver-test")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	isMounted := false
+	defer func(m func(string) (bool, error)) { mounted = m }(mounted)
+	mounted = func(string) (bool, error) { return isMounted, nil }
+	fake := &runner.Fake{}
+	SetRunner(fake)
+	defer SetRunner(runner.New())
+
+	server := NewNodeServer("node-1")
+	target := path.Join(tmpDir, "target")
+	capability := mountCapability()
+	capability.GetMount().MountFlags = []string{"hard"}
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:         "pvc-1",
+		TargetPath:       target,
+		VolumeCapability: capability,
+		VolumeContext:    map[string]string{"server": "10.0.0.1", "share": "/export/pvc-1", "mountOptions": "vers=4.1"},
+		Readonly:         true,
+	}
+	if _, err := server.NodePublishVolume(context.Background(), req); err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+	isMounted = true
+	if _, err := server.NodePublishVolume(context.Background(), req); err != nil {
+		t.Errorf("retried NodePublishVolume failed: %v", err)
+	}
+	if _, err := server.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "pvc-1", TargetPath: target}); err != nil {
+		t.Errorf("NodeUnpublishVolume failed: %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("expected target path to be removed but got %v", err)
+	}
+
+	expected := []string{
+		"mount -t nfs -o hard,vers=4.1,ro 10.0.0.1:/export/pvc-1 " + target,
+		"umount " + target,
+	}
+	if !reflect.DeepEqual(expected, fake.Commands()) {
+		t.Errorf("expected commands %v but got %v", expected, fake.Commands())
+	}
+
+	req.VolumeContext = nil
+	if _, err := server.NodePublishVolume(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument publishing without server and share but got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csidriver
+
+import (
+	"os"
+	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/docker/docker/pkg/mount"
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/runner"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	cmdRunner runner.Runner = runner.New()
+	// mounted reports whether something is mounted at a path, faked in tests
+	mounted = mount.Mounted
+)
+
+// SetRunner sets the Runner that mount and umount are run with.
+func SetRunner(r runner.Runner) {
+	cmdRunner = r
+}
+
+type nodeServer struct {
+	nodeID string
+}
+
+var _ csi.NodeServer = &nodeServer{}
+
+// NewNodeServer returns a Node service for the node with the given ID that
+// mounts volumes with NFS from the server and path in their context.
+func NewNodeServer(nodeID string) csi.NodeServer {
+	return &nodeServer{nodeID: nodeID}
+}
+
+func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+	target := req.GetTargetPath()
+	if target == "" {
+		return nil, status.Error(codes.InvalidArgument, "target path is required")
+	}
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "volume capability is required")
+	}
+	if err := checkCapabilities([]*csi.VolumeCapability{req.GetVolumeCapability()}); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	server := req.GetVolumeContext()[contextServer]
+	share := req.GetVolumeContext()[contextShare]
+	if server == "" || share == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "volume context must contain %s and %s", contextServer, contextShare)
+	}
+
+	if err := os.MkdirAll(target, 0750); err != nil {
+		return nil, status.Errorf(codes.Internal, "error creating target path %s: %v", target, err)
+	}
+	isMounted, err := mounted(target)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error checking if %s is mounted: %v", target, err)
+	}
+	if isMounted {
+		// NodePublishVolume must be idempotent
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	options := req.GetVolumeCapability().GetMount().GetMountFlags()
+	if mountOptions := req.GetVolumeContext()[contextMountOptions]; mountOptions != "" {
+		options = append(options, strings.Split(mountOptions, ",")...)
+	}
+	if req.GetReadonly() {
+		options = append(options, "ro")
+	}
+	args := []string{"-t", "nfs"}
+	if len(options) > 0 {
+		args = append(args, "-o", strings.Join(options, ","))
+	}
+	args = append(args, server+":"+share, target)
+	if out, err := cmdRunner.CombinedOutput("mount", args...); err != nil {
+		return nil, status.Errorf(codes.Internal, "mount %v failed with error: %v, output: %s", args, err, out)
+	}
+	glog.Infof("Mounted volume %q at %s", req.GetVolumeId(), target)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+func (s *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume ID is required")
+	}
+	target := req.GetTargetPath()
+	if target == "" {
+		return nil, status.Error(codes.InvalidArgument, "target path is required")
+	}
+
+	if _, err := os.Stat(target); os.IsNotExist(err) {
+		// Already unpublished
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
+	isMounted, err := mounted(target)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error checking if %s is mounted: %v", target, err)
+	}
+	if isMounted {
+		if out, err := cmdRunner.CombinedOutput("umount", target); err != nil {
+			return nil, status.Errorf(codes.Internal, "umount %s failed with error: %v, output: %s", target, err, out)
+		}
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return nil, status.Errorf(codes.Internal, "error removing target path %s: %v", target, err)
+	}
+	glog.Infof("Unmounted volume %q from %s", req.GetVolumeId(), target)
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+func (s *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{}, nil
+}
+
+func (s *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{NodeId: s.nodeID}, nil
+}
+
+func (s *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (s *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
+
+func (s *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "")
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/runner"
+	"golang.org/x/net/context"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// Config is what faults to inject, for testing that the provisioner retries
+// and recovers from them.
+type Config struct {
+	// ExecFailureRate is the probability in [0, 1] that an external command
+	// fails without being run.
+	ExecFailureRate float64
+	// APIWriteDelay is the maximum random delay of each API request that isn't
+	// a read.
+	APIWriteDelay time.Duration
+	// KillPeriod is how often to kill one of Daemons. 0 never kills.
+	KillPeriod time.Duration
+	// Daemons are the names of the processes to kill.
+	Daemons []string
+}
+
+// ParseConfig parses a comma-separated list of key=value pairs:
+// exec-failure-rate, api-write-delay, kill-period and daemons, the latter a
+// colon-separated list. An empty string injects no faults.
+func ParseConfig(config string) (*Config, error) {
+	c := &Config{Daemons: []string{"ganesha.nfsd", "rpc.mountd", "rpcbind"}}
+	if config == "" {
+		return c, nil
+	}
+	for _, pair := range strings.Split(config, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%q is not of the form key=value", pair)
+		}
+		var err error
+		switch kv[0] {
+		case "exec-failure-rate":
+			c.ExecFailureRate, err = strconv.ParseFloat(kv[1], 64)
+			if err == nil && (c.ExecFailureRate < 0 || c.ExecFailureRate > 1) {
+				err = fmt.Errorf("must be between 0 and 1")
+			}
+		case "api-write-delay":
+			c.APIWriteDelay, err = time.ParseDuration(kv[1])
+		case "kill-period":
+			c.KillPeriod, err = time.ParseDuration(kv[1])
+		case "daemons":
+			c.Daemons = strings.Split(kv[1], ":")
+		default:
+			err = fmt.Errorf("unknown key")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", kv[0], kv[1], err)
+		}
+	}
+	return c, nil
+}
+
+// faultyRunner fails commands at random instead of running them.
+type faultyRunner struct {
+	runner.Runner
+	failureRate float64
+}
+
+var _ runner.Runner = &faultyRunner{}
+
+// NewRunner returns a Runner that fails commands with the given probability
+// and otherwise runs them with r.
+func NewRunner(r runner.Runner, failureRate float64) runner.Runner {
+	return &faultyRunner{Runner: r, failureRate: failureRate}
+}
+
+func (r *faultyRunner) Output(name string, args ...string) ([]byte, error) {
+	if err := r.inject(name, args); err != nil {
+		return nil, err
+	}
+	return r.Runner.Output(name, args...)
+}
+
+func (r *faultyRunner) CombinedOutput(name string, args ...string) ([]byte, error) {
+	if err := r.inject(name, args); err != nil {
+		return nil, err
+	}
+	return r.Runner.CombinedOutput(name, args...)
+}
+
+func (r *faultyRunner) CombinedOutputContext(ctx context.Context, env []string, name string, args ...string) ([]byte, error) {
+	if err := r.inject(name, args); err != nil {
+		return nil, err
+	}
+	return r.Runner.CombinedOutputContext(ctx, env, name, args...)
+}
+
+func (r *faultyRunner) Start(name string, args ...string) (runner.Process, error) {
+	if err := r.inject(name, args); err != nil {
+		return nil, err
+	}
+	return r.Runner.Start(name, args...)
+}
+
+func (r *faultyRunner) inject(name string, args []string) error {
+	if rand.Float64() >= r.failureRate {
+		return nil
+	}
+	glog.Warningf("Injecting failure of command %s %v", name, args)
+	return fmt.Errorf("injected failure")
+}
+
+// delayingRoundTripper delays requests that aren't reads.
+type delayingRoundTripper struct {
+	http.RoundTripper
+	maxDelay time.Duration
+}
+
+// WrapTransport returns a function for rest.Config's WrapTransport that delays
+// every API request that isn't a read by a random duration up to maxDelay.
+func WrapTransport(maxDelay time.Duration) func(http.RoundTripper) http.RoundTripper {
+	return func(rt http.RoundTripper) http.RoundTripper {
+		return &delayingRoundTripper{RoundTripper: rt, maxDelay: maxDelay}
+	}
+}
+
+func (rt *delayingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		delay := time.Duration(rand.Int63n(int64(rt.maxDelay)))
+		glog.Warningf("Injecting delay of %v into %s %s", delay, req.Method, req.URL.Path)
+		time.Sleep(delay)
+	}
+	return rt.RoundTripper.RoundTrip(req)
+}
+
+// KillDaemons kills a random one of the running processes named daemons every
+// period, until stopCh is closed.
+func KillDaemons(period time.Duration, daemons []string, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		pids, err := findProcesses(daemons)
+		if err != nil {
+			glog.Errorf("Error finding daemons to kill: %v", err)
+			return
+		}
+		if len(pids) == 0 {
+			return
+		}
+		pid := pids[rand.Intn(len(pids))]
+		glog.Warningf("Injecting fault by killing process %d", pid)
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+			glog.Errorf("Error killing process %d: %v", pid, err)
+		}
+	}, period, stopCh)
+}
+
+// findProcesses returns the pids of the running processes with any of the
+// given names.
+func findProcesses(names []string) ([]int, error) {
+	comms, err := filepath.Glob("/proc/[0-9]*/comm")
+	if err != nil {
+		return nil, err
+	}
+	pids := []int{}
+	for _, comm := range comms {
+		read, err := ioutil.ReadFile(comm)
+		if err != nil {
+			// The process exited
+			continue
+		}
+		for _, name := range names {
+			// comm is truncated to 15 characters
+			if len(name) > 15 {
+				name = name[:15]
+			}
+			if strings.TrimSpace(string(read)) == name {
+				pid, _ := strconv.Atoi(filepath.Base(filepath.Dir(comm)))
+				pids = append(pids, pid)
+				break
+			}
+		}
+	}
+	return pids, nil
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fs
+
+import (
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Filesystem does the operations on volume directories the provisioner
+// depends on, so that they can be faked in tests that can't create real
+// directories with arbitrary ownership.
+type Filesystem interface {
+	// Stat returns the FileInfo of the named file like os.Stat.
+	Stat(name string) (os.FileInfo, error)
+	// MkdirAll creates a directory and any missing parents like os.MkdirAll.
+	MkdirAll(path string, perm os.FileMode) error
+	// Chmod changes the mode of the named file like os.Chmod.
+	Chmod(name string, mode os.FileMode) error
+	// Chown changes the uid and gid of the named file like os.Chown. A uid or
+	// gid of -1 is left unchanged.
+	Chown(name string, uid, gid int) error
+	// RemoveAll removes path and any children it contains like os.RemoveAll.
+	RemoveAll(path string) error
+	// Rename moves oldpath to newpath like os.Rename.
+	Rename(oldpath, newpath string) error
+	// AvailableBytes returns the space available to unprivileged users in the
+	// filesystem containing path.
+	AvailableBytes(path string) (int64, error)
+}
+
+type osFilesystem struct{}
+
+var _ Filesystem = &osFilesystem{}
+
+// New returns a Filesystem that operates on the real filesystem.
+func New() Filesystem {
+	return &osFilesystem{}
+}
+
+func (f *osFilesystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (f *osFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (f *osFilesystem) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (f *osFilesystem) Chown(name string, uid, gid int) error {
+	return os.Chown(name, uid, gid)
+}
+
+func (f *osFilesystem) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (f *osFilesystem) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (f *osFilesystem) AvailableBytes(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
+
+// FakeFile is a directory in a Fake.
+type FakeFile struct {
+	Mode os.FileMode
+	UID  int
+	GID  int
+}
+
+// Fake is an in-memory Filesystem of directories.
+type Fake struct {
+	// Fail, if set, is called before every operation with its name, e.g.
+	// "Chmod", and path, and a non-nil error it returns is returned instead of
+	// doing the operation.
+	Fail func(op, path string) error
+	// Available is the space AvailableBytes returns for each path, by its
+	// cleaned form, e.g. "/export" for "/export/".
+	Available map[string]int64
+
+	mutex sync.Mutex
+	files map[string]*FakeFile
+}
+
+var _ Filesystem = &Fake{}
+
+// NewFake returns a Fake containing the given directories, e.g. export roots.
+func NewFake(dirs ...string) *Fake {
+	f := &Fake{files: map[string]*FakeFile{}}
+	for _, dir := range dirs {
+		f.files[path.Clean(dir)] = &FakeFile{Mode: os.ModeDir | 0755}
+	}
+	return f
+}
+
+// Get returns the named file, nil if it doesn't exist.
+func (f *Fake) Get(name string) *FakeFile {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if file, ok := f.files[path.Clean(name)]; ok {
+		got := *file
+		return &got
+	}
+	return nil
+}
+
+// Stat returns the FileInfo of the named file.
+func (f *Fake) Stat(name string) (os.FileInfo, error) {
+	if err := f.fail("Stat", name); err != nil {
+		return nil, err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	file, ok := f.files[path.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: syscall.ENOENT}
+	}
+	return &fakeFileInfo{name: path.Base(name), file: *file}, nil
+}
+
+// MkdirAll creates the directory and any missing parents.
+func (f *Fake) MkdirAll(name string, perm os.FileMode) error {
+	if err := f.fail("MkdirAll", name); err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for dir := path.Clean(name); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		if _, ok := f.files[dir]; ok {
+			break
+		}
+		f.files[dir] = &FakeFile{Mode: os.ModeDir | perm}
+	}
+	return nil
+}
+
+// Chmod changes the permission bits of the named file.
+func (f *Fake) Chmod(name string, mode os.FileMode) error {
+	return f.update("Chmod", name, func(file *FakeFile) {
+		file.Mode = file.Mode&os.ModeDir | mode
+	})
+}
+
+// Chown changes the uid and gid of the named file.
+func (f *Fake) Chown(name string, uid, gid int) error {
+	return f.update("Chown", name, func(file *FakeFile) {
+		if uid != -1 {
+			file.UID = uid
+		}
+		if gid != -1 {
+			file.GID = gid
+		}
+	})
+}
+
+// RemoveAll removes path and any children it contains.
+func (f *Fake) RemoveAll(name string) error {
+	if err := f.fail("RemoveAll", name); err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	name = path.Clean(name)
+	for file := range f.files {
+		if file == name || strings.HasPrefix(file, name+"/") {
+			delete(f.files, file)
+		}
+	}
+	return nil
+}
+
+// Rename moves oldpath and any children it contains to newpath, which must
+// not exist.
+func (f *Fake) Rename(oldpath, newpath string) error {
+	if err := f.fail("Rename", oldpath); err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	oldpath, newpath = path.Clean(oldpath), path.Clean(newpath)
+	if _, ok := f.files[oldpath]; !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.ENOENT}
+	}
+	if _, ok := f.files[newpath]; ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EEXIST}
+	}
+	moved := map[string]*FakeFile{}
+	for file, fakeFile := range f.files {
+		if file == oldpath || strings.HasPrefix(file, oldpath+"/") {
+			moved[newpath+strings.TrimPrefix(file, oldpath)] = fakeFile
+			delete(f.files, file)
+		}
+	}
+	for file, fakeFile := range moved {
+		f.files[file] = fakeFile
+	}
+	return nil
+}
+
+// AvailableBytes returns the space set in Available for path.
+func (f *Fake) AvailableBytes(name string) (int64, error) {
+	if err := f.fail("AvailableBytes", name); err != nil {
+		return 0, err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.Available[path.Clean(name)], nil
+}
+
+func (f *Fake) update(op, name string, update func(*FakeFile)) error {
+	if err := f.fail(op, name); err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	file, ok := f.files[path.Clean(name)]
+	if !ok {
+		return &os.PathError{Op: strings.ToLower(op), Path: name, Err: syscall.ENOENT}
+	}
+	update(file)
+	return nil
+}
+
+func (f *Fake) fail(op, name string) error {
+	if f.Fail != nil {
+		return f.Fail(op, name)
+	}
+	return nil
+}
+
+type fakeFileInfo struct {
+	name string
+	file FakeFile
+}
+
+func (i *fakeFileInfo) Name() string       { return i.name }
+func (i *fakeFileInfo) Size() int64        { return 0 }
+func (i *fakeFileInfo) Mode() os.FileMode  { return i.file.Mode }
+func (i *fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (i *fakeFileInfo) IsDir() bool        { return i.file.Mode.IsDir() }
+func (i *fakeFileInfo) Sys() interface{}   { return &i.file }
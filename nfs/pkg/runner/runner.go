@@ -0,0 +1,206 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/net/context"
+)
+
+// Runner runs the external commands the server and provisioner depend on,
+// e.g. rpcbind, exportfs and mount, so that they can be faked in tests or run
+// by some other backend than exec.
+type Runner interface {
+	// Output runs the named command with the given args and returns its
+	// stdout.
+	Output(name string, args ...string) ([]byte, error)
+	// CombinedOutput runs the named command with the given args and returns
+	// its combined stdout and stderr.
+	CombinedOutput(name string, args ...string) ([]byte, error)
+	// CombinedOutputContext runs the named command with the given args like
+	// CombinedOutput, with the given "key=value" env vars added to the
+	// process's, killing it if ctx is done before it exits.
+	CombinedOutputContext(ctx context.Context, env []string, name string, args ...string) ([]byte, error)
+	// LookPath searches for the named executable like exec.LookPath.
+	LookPath(file string) (string, error)
+	// Start starts the named command with the given args, e.g. a daemon,
+	// with the process's stdout and stderr, without waiting for it to exit.
+	Start(name string, args ...string) (Process, error)
+}
+
+// Process is a command started with Start.
+type Process interface {
+	// Wait waits for the command to exit.
+	Wait() error
+	// Kill kills the command.
+	Kill() error
+}
+
+type execRunner struct{}
+
+var _ Runner = &execRunner{}
+
+// New returns a Runner that runs commands with os/exec.
+func New() Runner {
+	return &execRunner{}
+}
+
+func (r *execRunner) Output(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
+
+func (r *execRunner) CombinedOutput(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// CombinedOutputContext runs the command in a process group of its own, so
+// that e.g. the children of a shell it runs are killed along with it rather
+// than keeping its output open.
+func (r *execRunner) CombinedOutputContext(ctx context.Context, env []string, name string, args ...string) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return out.Bytes(), err
+	case <-ctx.Done():
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return out.Bytes(), ctx.Err()
+	}
+}
+
+func (r *execRunner) LookPath(file string) (string, error) {
+	return exec.LookPath(file)
+}
+
+func (r *execRunner) Start(name string, args ...string) (Process, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &execProcess{cmd: cmd}, nil
+}
+
+type execProcess struct {
+	cmd *exec.Cmd
+}
+
+func (p *execProcess) Wait() error {
+	return p.cmd.Wait()
+}
+
+func (p *execProcess) Kill() error {
+	return p.cmd.Process.Kill()
+}
+
+// Fake is a Runner that records the commands it is asked to run instead of
+// running them.
+type Fake struct {
+	// Run, if set, is called for every command and its result returned.
+	// Otherwise commands succeed with no output.
+	Run func(name string, args ...string) ([]byte, error)
+
+	mutex    sync.Mutex
+	commands []string
+}
+
+var _ Runner = &Fake{}
+
+// Output records & fakes running the named command.
+func (f *Fake) Output(name string, args ...string) ([]byte, error) {
+	return f.run(name, args...)
+}
+
+// CombinedOutput records & fakes running the named command.
+func (f *Fake) CombinedOutput(name string, args ...string) ([]byte, error) {
+	return f.run(name, args...)
+}
+
+// CombinedOutputContext records & fakes running the named command, failing
+// if ctx is done.
+func (f *Fake) CombinedOutputContext(ctx context.Context, env []string, name string, args ...string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.run(name, args...)
+}
+
+// LookPath finds every executable.
+func (f *Fake) LookPath(file string) (string, error) {
+	return file, nil
+}
+
+// Start records & fakes starting the named command. The fake process runs
+// until it's killed.
+func (f *Fake) Start(name string, args ...string) (Process, error) {
+	if _, err := f.run(name, args...); err != nil {
+		return nil, err
+	}
+	return &fakeProcess{killed: make(chan struct{})}, nil
+}
+
+type fakeProcess struct {
+	once   sync.Once
+	killed chan struct{}
+}
+
+func (p *fakeProcess) Wait() error {
+	<-p.killed
+	return nil
+}
+
+func (p *fakeProcess) Kill() error {
+	p.once.Do(func() { close(p.killed) })
+	return nil
+}
+
+// Commands returns the commands run so far, each as its name and args joined
+// by spaces.
+func (f *Fake) Commands() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string{}, f.commands...)
+}
+
+func (f *Fake) run(name string, args ...string) ([]byte, error) {
+	f.mutex.Lock()
+	f.commands = append(f.commands, strings.Join(append([]string{name}, args...), " "))
+	f.mutex.Unlock()
+	if f.Run != nil {
+		return f.Run(name, args...)
+	}
+	return nil, nil
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// The disk state of a DRBD resource whose local data is current
+	drbdUpToDate = "UpToDate"
+	// How often to check whether a DRBD resource's local data is current
+	drbdPollInterval = 2 * time.Second
+)
+
+// PromoteDRBD waits until the local disk of the given DRBD resource is up to
+// date, i.e. it has resynced anything it missed from its peer, then makes it
+// primary so that its device can be mounted and served. It gives up after
+// timeout, 0 meaning never, rather than serve stale data.
+func PromoteDRBD(resource string, timeout time.Duration) error {
+	glog.Infof("Waiting for the disk of DRBD resource %s to be %s", resource, drbdUpToDate)
+	condition := func() (bool, error) {
+		state, err := getDRBDDiskState(resource)
+		if err != nil {
+			glog.Warningf("Error getting the disk state of DRBD resource %s: %v", resource, err)
+			return false, nil
+		}
+		glog.V(4).Infof("Disk of DRBD resource %s is %s", resource, state)
+		return state == drbdUpToDate, nil
+	}
+	var err error
+	if timeout > 0 {
+		err = wait.PollImmediate(drbdPollInterval, timeout, condition)
+	} else {
+		err = wait.PollImmediateInfinite(drbdPollInterval, condition)
+	}
+	if err != nil {
+		return fmt.Errorf("disk of DRBD resource %s didn't become %s: %v", resource, drbdUpToDate, err)
+	}
+
+	if out, err := cmdRunner.CombinedOutput("drbdadm", "primary", resource); err != nil {
+		return fmt.Errorf("drbdadm primary %s failed with error: %v, output: %s", resource, err, out)
+	}
+	glog.Infof("Promoted DRBD resource %s to primary", resource)
+	return nil
+}
+
+// DemoteDRBD makes the given DRBD resource secondary, so that its peer can be
+// promoted. Its device must not be mounted.
+func DemoteDRBD(resource string) error {
+	if out, err := cmdRunner.CombinedOutput("drbdadm", "secondary", resource); err != nil {
+		return fmt.Errorf("drbdadm secondary %s failed with error: %v, output: %s", resource, err, out)
+	}
+	glog.Infof("Demoted DRBD resource %s to secondary", resource)
+	return nil
+}
+
+// getDRBDDiskState returns the local disk state of the given DRBD resource,
+// e.g. UpToDate or Inconsistent while resyncing. drbdadm dstate outputs the
+// local state followed by the peers', e.g. UpToDate/Inconsistent.
+func getDRBDDiskState(resource string) (string, error) {
+	out, err := cmdRunner.Output("drbdadm", "dstate", resource)
+	if err != nil {
+		return "", fmt.Errorf("drbdadm dstate %s failed with error: %v", resource, err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("drbdadm dstate %s output nothing", resource)
+	}
+	return strings.SplitN(fields[0], "/", 2)[0], nil
+}
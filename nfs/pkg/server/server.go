@@ -20,14 +20,25 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"regexp"
 	"strings"
 	"syscall"
 
+	"github.com/docker/docker/pkg/mount"
 	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/runner"
 )
 
+// cmdRunner runs the external commands, e.g. rpcbind and ganesha.nfsd, the
+// server depends on. A variable so tests can fake it.
+var cmdRunner = runner.New()
+
+// SetRunner replaces the runner of the external commands the server depends
+// on, e.g. with one that injects faults.
+func SetRunner(r runner.Runner) {
+	cmdRunner = r
+}
+
 var defaultGaneshaConfigContents = []byte(`
 ###################################################
 #
@@ -73,25 +84,38 @@ NFSV4
 `)
 
 // Setup sets up various prerequisites and settings for the server. If an error
-// is encountered at any point it returns it instantly
-func Setup(ganeshaConfig string, gracePeriod uint) error {
+// is encountered at any point it returns it instantly. If enableNLM is false,
+// NLM, i.e. NFSv3 locking, is disabled and rpc.statd isn't started. Otherwise
+// rpc.statd is started with statdHostname, if set, as the name it identifies
+// the server by to clients, e.g. a floating IP clients mount from, and keeps
+// the clients it must notify to reclaim their locks after a restart in
+// statdStateDir, if set, e.g. on storage a replica taking over shares.
+func Setup(ganeshaConfig string, gracePeriod uint, enableNLM bool, statdHostname, statdStateDir string) error {
 	// Start rpcbind if it is not started yet
-	cmd := exec.Command("/usr/sbin/rpcinfo", "127.0.0.1")
-	if err := cmd.Run(); err != nil {
-		cmd = exec.Command("/usr/sbin/rpcbind", "-w")
-		if out, err := cmd.CombinedOutput(); err != nil {
+	if _, err := cmdRunner.CombinedOutput("/usr/sbin/rpcinfo", "127.0.0.1"); err != nil {
+		if out, err := cmdRunner.CombinedOutput("/usr/sbin/rpcbind", "-w"); err != nil {
 			return fmt.Errorf("Starting rpcbind failed with error: %v, output: %s", err, out)
 		}
 	}
 
-	cmd = exec.Command("/usr/sbin/rpc.statd")
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("rpc.statd failed with error: %v, output: %s", err, out)
+	if enableNLM {
+		args := []string{}
+		if statdHostname != "" {
+			args = append(args, "-n", statdHostname)
+		}
+		if statdStateDir != "" {
+			if err := os.MkdirAll(statdStateDir, 0700); err != nil {
+				return fmt.Errorf("error creating rpc.statd state directory %s: %v", statdStateDir, err)
+			}
+			args = append(args, "-P", statdStateDir)
+		}
+		if out, err := cmdRunner.CombinedOutput("/usr/sbin/rpc.statd", args...); err != nil {
+			return fmt.Errorf("rpc.statd failed with error: %v, output: %s", err, out)
+		}
 	}
 
 	// Start dbus, needed for ganesha dynamic exports
-	cmd = exec.Command("dbus-daemon", "--system")
-	if out, err := cmd.CombinedOutput(); err != nil {
+	if out, err := cmdRunner.CombinedOutput("dbus-daemon", "--system"); err != nil {
 		return fmt.Errorf("dbus-daemon failed with error: %v, output: %s", err, out)
 	}
 
@@ -115,6 +139,10 @@ func Setup(ganeshaConfig string, gracePeriod uint) error {
 	if err != nil {
 		return fmt.Errorf("error setting fsid device to ganesha config: %v", err)
 	}
+	err = setEnableNLM(ganeshaConfig, enableNLM)
+	if err != nil {
+		return fmt.Errorf("error setting NLM to ganesha config: %v", err)
+	}
 
 	return nil
 }
@@ -122,8 +150,7 @@ func Setup(ganeshaConfig string, gracePeriod uint) error {
 // Start starts the NFS server.
 func Start(ganeshaLog, ganeshaPid, ganeshaConfig string) error {
 	// Start ganesha.nfsd
-	cmd := exec.Command("ganesha.nfsd", "-L", ganeshaLog, "-p", ganeshaPid, "-f", ganeshaConfig)
-	if out, err := cmd.CombinedOutput(); err != nil {
+	if out, err := cmdRunner.CombinedOutput("ganesha.nfsd", "-L", ganeshaLog, "-p", ganeshaPid, "-f", ganeshaConfig); err != nil {
 		return fmt.Errorf("ganesha.nfsd failed with error: %v, output: %s", err, out)
 	}
 
@@ -189,6 +216,36 @@ func setFsidDevice(ganeshaConfig string, fsidDevice bool) error {
 	return nil
 }
 
+func setEnableNLM(ganeshaConfig string, enableNLM bool) error {
+	newLine := fmt.Sprintf("Enable_NLM = %t;", enableNLM)
+
+	re := regexp.MustCompile("Enable_NLM = (true|false);")
+
+	read, err := ioutil.ReadFile(ganeshaConfig)
+	if err != nil {
+		return err
+	}
+
+	oldLine := re.Find(read)
+
+	var replaced string
+	if oldLine == nil {
+		// Enable_NLM line not there, append it after MNT_Port
+		mntPort := regexp.MustCompile("MNT_Port = 20048;").Find(read)
+		if mntPort == nil {
+			return fmt.Errorf("no MNT_Port in ganesha config %s", ganeshaConfig)
+		}
+		block := string(mntPort) + "\n" +
+			"\t" + newLine
+		replaced = strings.Replace(string(read), string(mntPort), block, -1)
+	} else {
+		// Enable_NLM line there, just replace it
+		replaced = strings.Replace(string(read), string(oldLine), newLine, -1)
+	}
+
+	return ioutil.WriteFile(ganeshaConfig, []byte(replaced), 0)
+}
+
 func setGracePeriod(ganeshaConfig string, gracePeriod uint) error {
 	if gracePeriod > 180 {
 		return fmt.Errorf("grace period cannot be greater than 180")
@@ -234,6 +291,101 @@ func setGracePeriod(ganeshaConfig string, gracePeriod uint) error {
 	return nil
 }
 
+// SetRecoveryRoot sets the directory NFS Ganesha keeps the NFSv4 clients it
+// has granted state, e.g. locks, to in the given config, so that a server
+// started on the same storage elsewhere, e.g. by a standby replica taking
+// over, lets them reclaim it during its grace period. Setup must have been
+// called first.
+func SetRecoveryRoot(ganeshaConfig, recoveryRoot string) error {
+	if err := os.MkdirAll(recoveryRoot, 0700); err != nil {
+		return fmt.Errorf("error creating recovery root %s: %v", recoveryRoot, err)
+	}
+
+	newLine := fmt.Sprintf("RecoveryRoot = %q;", recoveryRoot)
+
+	re := regexp.MustCompile("RecoveryRoot = \"[^\"]*\";")
+
+	read, err := ioutil.ReadFile(ganeshaConfig)
+	if err != nil {
+		return err
+	}
+
+	oldLine := re.Find(read)
+
+	var replaced string
+	if oldLine == nil {
+		// RecoveryRoot line not there, append it after Grace_Period
+		gracePeriod := regexp.MustCompile("Grace_Period = [0-9]+;").Find(read)
+		if gracePeriod == nil {
+			return fmt.Errorf("no Grace_Period in ganesha config %s", ganeshaConfig)
+		}
+		block := string(gracePeriod) + "\n" +
+			"\t" + newLine
+		replaced = strings.Replace(string(read), string(gracePeriod), block, -1)
+	} else {
+		// RecoveryRoot line there, just replace it
+		replaced = strings.Replace(string(read), string(oldLine), newLine, -1)
+	}
+
+	return ioutil.WriteFile(ganeshaConfig, []byte(replaced), 0)
+}
+
+// SetReadOnlyExport adds an export of the given directory to the given config
+// that clients can only read from, e.g. for a read replica of volumes kept in
+// sync by another server, unless the config already has one. Setup must have
+// been called first.
+func SetReadOnlyExport(ganeshaConfig, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating read-only export %s: %v", dir, err)
+	}
+
+	read, err := ioutil.ReadFile(ganeshaConfig)
+	if err != nil {
+		return err
+	}
+
+	if strings.Contains(string(read), "Path = "+dir+";") {
+		return nil
+	}
+
+	block := "\nEXPORT\n{\n" +
+		"\tExport_Id = 1;\n" +
+		"\tPath = " + dir + ";\n" +
+		"\tPseudo = " + dir + ";\n" +
+		"\tAccess_Type = RO;\n" +
+		"\tSquash = no_root_squash;\n" +
+		"\tSecType = sys;\n" +
+		"\tFSAL {\n\t\tName = VFS;\n\t}\n}\n"
+
+	return ioutil.WriteFile(ganeshaConfig, append(read, []byte(block)...), 0)
+}
+
+// MountRemote mounts the given remote filesystem source, e.g. another NFS
+// server's export or CephFS, of the given type with the given comma-separated
+// options at target, so that the server re-exports directories from it. Does
+// nothing if something is already mounted at target.
+func MountRemote(source, fsType, options, target string) error {
+	mounted, err := mount.Mounted(target)
+	if err != nil {
+		return fmt.Errorf("error checking if %s is mounted: %v", target, err)
+	}
+	if mounted {
+		glog.Infof("%s is already mounted, not mounting %s there", target, source)
+		return nil
+	}
+
+	args := []string{"-t", fsType}
+	if options != "" {
+		args = append(args, "-o", options)
+	}
+	args = append(args, source, target)
+	if out, err := cmdRunner.CombinedOutput("mount", args...); err != nil {
+		return fmt.Errorf("mount %v failed with error: %v, output: %s", args, err, out)
+	}
+
+	return nil
+}
+
 // Stop stops the NFS server.
 func Stop() {
 	// /bin/dbus-send --system   --dest=org.ganesha.nfsd --type=method_call /org/ganesha/nfsd/admin org.ganesha.nfsd.admin.shutdown
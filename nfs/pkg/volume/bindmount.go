@@ -0,0 +1,154 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// A PV annotation for the path the volume is exported at if it isn't its
+	// backing directory: the path it was created at, where its backing
+	// directory is bind mounted since it was migrated to another export root,
+	// because a PV's path can't be changed
+	annExportPath = "Export_Path"
+
+	// File in exportDir listing the bind mounts of migrated volumes' backing
+	// directories at their export paths, one "source target" per line, so
+	// that they're mounted again before the NFS server starts
+	bindMountsFile = ".bind-mounts"
+)
+
+// bindMountsMutex serializes changes to bindMountsFile.
+var bindMountsMutex sync.Mutex
+
+// getExportPath returns the path the given PV is exported at, which its PV
+// points at.
+func (p *nfsProvisioner) getExportPath(volume *v1.PersistentVolume) string {
+	if exportPath, ok := volume.Annotations[annExportPath]; ok {
+		return exportPath
+	}
+	return p.getDirectory(volume)
+}
+
+// bindMount bind mounts the directory source at target and records the mount
+// in bindMountsFile.
+func (p *nfsProvisioner) bindMount(source, target string) error {
+	out, err := cmdRunner.CombinedOutput("mount", "--bind", source, target)
+	if err != nil {
+		return fmt.Errorf("mount --bind %s %s failed with error: %v, output: %s", source, target, err, out)
+	}
+	err = p.updateBindMounts(func(mounts map[string]string) {
+		mounts[target] = source
+	})
+	if err != nil {
+		if out, err := cmdRunner.CombinedOutput("umount", target); err != nil {
+			glog.Errorf("umount %s failed with error: %v, output: %s", target, err, out)
+		}
+		return fmt.Errorf("error recording bind mount of %s at %s: %v", source, target, err)
+	}
+	return nil
+}
+
+// unbindMount unmounts the bind mount at target and removes it from
+// bindMountsFile.
+func (p *nfsProvisioner) unbindMount(target string) error {
+	out, err := cmdRunner.CombinedOutput("umount", target)
+	if err != nil {
+		return fmt.Errorf("umount %s failed with error: %v, output: %s", target, err, out)
+	}
+	return p.updateBindMounts(func(mounts map[string]string) {
+		delete(mounts, target)
+	})
+}
+
+// updateBindMounts applies update to the bind mounts recorded in
+// bindMountsFile, by target.
+func (p *nfsProvisioner) updateBindMounts(update func(map[string]string)) error {
+	bindMountsMutex.Lock()
+	defer bindMountsMutex.Unlock()
+
+	file := path.Join(p.exportDir, bindMountsFile)
+	mounts, err := readBindMounts(file)
+	if err != nil {
+		return err
+	}
+	update(mounts)
+
+	targets := make([]string, 0, len(mounts))
+	for target := range mounts {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	var data string
+	for _, target := range targets {
+		data += mounts[target] + " " + target + "\n"
+	}
+	return writeFileAtomic(file, []byte(data))
+}
+
+// RestoreBindMounts bind mounts the backing directories of the volumes
+// migrated from the export roots of the provisioner of the given exportDir at
+// their export paths again, e.g. after the provisioner restarted. It must be
+// called before the NFS server starts, so that it doesn't export the empty
+// mount points instead.
+func RestoreBindMounts(exportDir string) error {
+	mounts, err := readBindMounts(path.Join(exportDir, bindMountsFile))
+	if err != nil {
+		return err
+	}
+	for target, source := range mounts {
+		out, err := cmdRunner.CombinedOutput("mount", "--bind", source, target)
+		if err != nil {
+			return fmt.Errorf("mount --bind %s %s failed with error: %v, output: %s", source, target, err, out)
+		}
+		glog.Infof("Bind mounted migrated volume directory %s at %s", source, target)
+	}
+	return nil
+}
+
+// readBindMounts returns the bind mounts recorded in the given file, by
+// target, none if it doesn't exist.
+func readBindMounts(file string) (map[string]string, error) {
+	mounts := map[string]string{}
+	read, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return mounts, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading bind mounts file: %v", err)
+	}
+	for _, line := range strings.Split(string(read), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("error parsing bind mounts file %s: line %q is not of the form source target", file, line)
+		}
+		mounts[fields[1]] = fields[0]
+	}
+	return mounts, nil
+}
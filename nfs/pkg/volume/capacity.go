@@ -0,0 +1,212 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	storage "k8s.io/client-go/pkg/apis/storage/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// Path of the API group & version of the NFSStorageCapacity CRD
+	capacitiesPath = "/apis/external-storage.k8s.io/v1alpha1"
+	// Resource name of the NFSStorageCapacity CRD
+	capacitiesResource = "nfsstoragecapacities"
+
+	// The label on the NFSStorageCapacity objects a provisioner publishes
+	// with its identity, so it can find and delete the stale ones
+	capacityIdentityLabel = "nfs-provisioner/identity"
+)
+
+// CapacityPublisher is implemented by provisioners that can publish how much
+// space is available to the volumes of each of their storage classes.
+type CapacityPublisher interface {
+	// PublishCapacity periodically publishes the space available to the
+	// volumes of each storage class of the named provisioner, until stopCh is
+	// closed.
+	PublishCapacity(provisionerName string, period time.Duration, stopCh <-chan struct{})
+}
+
+var _ CapacityPublisher = &nfsProvisioner{}
+
+// storageCapacity is an NFSStorageCapacity object. Like a CSIStorageCapacity,
+// it holds the space available to the volumes of a storage class, here in one
+// of the export roots they may be created in, on the nodes matching
+// NodeTopology, or all nodes if it is nil.
+type storageCapacity struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	StorageClassName string                `json:"storageClassName"`
+	ExportRoot       string                `json:"exportRoot"`
+	NodeTopology     *metav1.LabelSelector `json:"nodeTopology,omitempty"`
+	Capacity         resource.Quantity     `json:"capacity"`
+}
+
+type storageCapacityList struct {
+	Items []storageCapacity `json:"items"`
+}
+
+// PublishCapacity periodically publishes an NFSStorageCapacity object in the
+// provisioner's namespace for each export root the volumes of each storage
+// class of the named provisioner may be created in, with the space available
+// there, so that e.g. autoscalers and schedulers can tell how much remains
+// before claims start failing. Objects of classes or export roots that are
+// gone are deleted.
+func (p *nfsProvisioner) PublishCapacity(provisionerName string, period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := p.publishCapacity(provisionerName); err != nil {
+			glog.Errorf("Error publishing storage capacity: %v", err)
+		}
+	}, period, stopCh)
+}
+
+func (p *nfsProvisioner) publishCapacity(provisionerName string) error {
+	namespace := os.Getenv(p.namespaceEnv)
+	if namespace == "" {
+		return fmt.Errorf("env %s must be set to publish storage capacity", p.namespaceEnv)
+	}
+
+	var topology *metav1.LabelSelector
+	if p.nodeAffinity {
+		nodeName := os.Getenv(p.nodeEnv)
+		node, err := p.client.Core().Nodes().Get(nodeName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("error getting node %q: %v", nodeName, err)
+		}
+		nodeValue, found := node.Labels[nodeLabelKey]
+		if !found {
+			nodeValue = nodeName
+		}
+		topology = &metav1.LabelSelector{MatchLabels: map[string]string{nodeLabelKey: nodeValue}}
+	}
+
+	classes, err := p.client.StorageV1().StorageClasses().List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing storage classes: %v", err)
+	}
+	capacities := p.getStorageCapacities(classes.Items, provisionerName, topology)
+
+	raw, err := p.client.Core().RESTClient().Get().AbsPath(capacitiesPath, "namespaces", namespace, capacitiesResource).
+		Param("labelSelector", capacityIdentityLabel+"="+string(p.identity)).DoRaw()
+	if err != nil {
+		return fmt.Errorf("error listing storage capacities: %v", err)
+	}
+	existing := &storageCapacityList{}
+	if err := json.Unmarshal(raw, existing); err != nil {
+		return fmt.Errorf("error decoding storage capacities: %v", err)
+	}
+
+	for _, old := range existing.Items {
+		capacity, found := capacities[old.Name]
+		if !found {
+			err := p.client.Core().RESTClient().Delete().AbsPath(capacitiesPath, "namespaces", namespace, capacitiesResource, old.Name).Do().Error()
+			if err != nil && !errors.IsNotFound(err) {
+				glog.Errorf("Error deleting stale storage capacity %q: %v", old.Name, err)
+			}
+			continue
+		}
+		// The available space of the root couldn't be read, leave as is
+		if capacity == nil {
+			delete(capacities, old.Name)
+			continue
+		}
+		capacity.ResourceVersion = old.ResourceVersion
+		err := p.putStorageCapacity(p.client.Core().RESTClient().Put().AbsPath(capacitiesPath, "namespaces", namespace, capacitiesResource, old.Name), capacity)
+		if err != nil {
+			glog.Errorf("Error updating storage capacity %q: %v", old.Name, err)
+		}
+		delete(capacities, old.Name)
+	}
+	for name, capacity := range capacities {
+		if capacity == nil {
+			continue
+		}
+		capacity.Namespace = namespace
+		err := p.putStorageCapacity(p.client.Core().RESTClient().Post().AbsPath(capacitiesPath, "namespaces", namespace, capacitiesResource), capacity)
+		if err != nil {
+			glog.Errorf("Error creating storage capacity %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// getStorageCapacities returns the NFSStorageCapacity objects to publish for
+// the given storage classes of the named provisioner, by name. The value is
+// nil for those whose export root's available space couldn't be read.
+func (p *nfsProvisioner) getStorageCapacities(classes []storage.StorageClass, provisionerName string, topology *metav1.LabelSelector) map[string]*storageCapacity {
+	capacities := map[string]*storageCapacity{}
+	for _, class := range classes {
+		if class.Provisioner != provisionerName {
+			continue
+		}
+		for _, root := range p.getClassExportRoots(class.Name) {
+			name := p.getStorageCapacityName(class.Name, root)
+			available, err := getAvailableBytes(root)
+			if err != nil {
+				glog.Errorf("Error getting available space of %s for storage capacity of class %q: %v", root, class.Name, err)
+				capacities[name] = nil
+				continue
+			}
+			capacities[name] = &storageCapacity{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: "external-storage.k8s.io/v1alpha1",
+					Kind:       "NFSStorageCapacity",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   name,
+					Labels: map[string]string{capacityIdentityLabel: string(p.identity)},
+				},
+				StorageClassName: class.Name,
+				ExportRoot:       root,
+				NodeTopology:     topology,
+				Capacity:         *resource.NewQuantity(available, resource.BinarySI),
+			}
+		}
+	}
+	return capacities
+}
+
+// getStorageCapacityName returns the name of the NFSStorageCapacity object
+// of the given class and export root: the class name suffixed with a hash of
+// the root and the provisioner's identity, so that the objects of
+// provisioners sharing a namespace, e.g. a DaemonSet's, don't collide.
+func (p *nfsProvisioner) getStorageCapacityName(class, root string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(string(p.identity) + root))
+	return fmt.Sprintf("%s-%08x", class, hash.Sum32())
+}
+
+func (p *nfsProvisioner) putStorageCapacity(request *rest.Request, capacity *storageCapacity) error {
+	body, err := json.Marshal(capacity)
+	if err != nil {
+		return err
+	}
+	_, err = request.SetHeader("Content-Type", "application/json").Body(body).DoRaw()
+	return err
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// MultiClusterProvisioner is implemented by provisioners that can provision
+// volumes for the claims of other clusters than the one they run in, on the
+// same storage, e.g. for workload clusters sharing a storage cluster.
+type MultiClusterProvisioner interface {
+	// ForCluster returns a provisioner for the claims of the cluster of the
+	// given client, to run a controller of its own for.
+	ForCluster(client kubernetes.Interface) controller.Provisioner
+}
+
+var _ MultiClusterProvisioner = &nfsProvisioner{}
+
+// ForCluster returns an nfsProvisioner for the claims of the cluster of the
+// given client. It creates volumes the same way and with the same identity as
+// p, and shares p's health, volume counts and export root rotation, but
+// records events and looks up claims and PVs in its own cluster. Since the
+// node p runs on isn't in that cluster, its PVs get no node affinity to it.
+func (p *nfsProvisioner) ForCluster(client kubernetes.Interface) controller.Provisioner {
+	cluster := &nfsProvisioner{
+		exportDir:            p.exportDir,
+		exportRoots:          p.exportRoots,
+		placement:            p.placement,
+		classExportRoots:     p.classExportRoots,
+		externalHost:         p.externalHost,
+		externalPath:         p.externalPath,
+		consolidatedExport:   p.consolidatedExport,
+		selfTest:             p.selfTest,
+		preProvisionHook:     p.preProvisionHook,
+		postDeleteHook:       p.postDeleteHook,
+		parameterOverrides:   p.parameterOverrides,
+		namespaceDirectories: p.namespaceDirectories,
+		namespaceQuota:       p.namespaceQuota,
+		maxVolumes:           p.maxVolumes,
+		drainTimeout:         p.drainTimeout,
+		readReplicaServer:    p.readReplicaServer,
+		webhooks:             p.webhooks,
+		client:               client,
+		outOfCluster:         p.outOfCluster,
+		exporter:             p.exporter,
+		quotaer:              p.quotaer,
+		serverHostname:       p.serverHostname,
+		serverInterface:      p.serverInterface,
+		hostNetwork:          p.hostNetwork,
+		nodeAffinity:         false,
+		identity:             p.identity,
+		podIPEnv:             p.podIPEnv,
+		serviceEnv:           p.serviceEnv,
+		namespaceEnv:         p.namespaceEnv,
+		nodeEnv:              "",
+		hostIPEnv:            p.hostIPEnv,
+		home:                 p,
+	}
+	p.clusters = append(p.clusters, cluster)
+	return cluster
+}
+
+// shared returns the provisioner whose health, volume counts and export root
+// rotation p shares: the one of the cluster it runs in.
+func (p *nfsProvisioner) shared() *nfsProvisioner {
+	if p.home != nil {
+		return p.home
+	}
+	return p
+}
+
+// listVolumes returns the PVs of the cluster the provisioner runs in and of
+// all the other clusters it provisions for.
+func (p *nfsProvisioner) listVolumes() ([]v1.PersistentVolume, error) {
+	home := p.shared()
+	var volumes []v1.PersistentVolume
+	for _, cluster := range append([]*nfsProvisioner{home}, home.clusters...) {
+		list, err := cluster.client.Core().PersistentVolumes().List(metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error listing PVs: %v", err)
+		}
+		volumes = append(volumes, list.Items...)
+	}
+	return volumes, nil
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// The values of the parameter compression
+const (
+	compressionZstd = "zstd"
+	compressionLz4  = "lz4"
+	compressionOff  = "off"
+)
+
+// setCompression applies the given compression to the given directory of a
+// volume of the given claim, created in the given export root. On btrfs the
+// directory's compression property is set, so that the files written in it
+// are compressed; btrfs has no lz4. On ZFS compression is a property of the
+// dataset, not of a directory, so it's honored only if the root's dataset
+// already has it, e.g. a root of class-export-dirs set aside for the class.
+// Elsewhere compression is ignored. When it's not honored, a
+// CompressionIgnored Warning event is recorded on the claim rather than
+// failing the volume.
+func (p *nfsProvisioner) setCompression(claim *v1.PersistentVolumeClaim, root, path, compression string) error {
+	out, err := cmdRunner.Output("stat", "-f", "-c", "%T", root)
+	if err != nil {
+		return fmt.Errorf("error getting filesystem type of %s: %v", root, err)
+	}
+	fstype := strings.TrimSpace(string(out))
+
+	var ignored string
+	switch fstype {
+	case "btrfs":
+		if compression == compressionLz4 {
+			ignored = "btrfs doesn't support lz4 compression"
+			break
+		}
+		value := compression
+		if compression == compressionOff {
+			value = "none"
+		}
+		out, err := cmdRunner.CombinedOutput("btrfs", "property", "set", path, "compression", value)
+		if err != nil {
+			return fmt.Errorf("btrfs property set failed with error: %v, output: %s", err, out)
+		}
+	case "zfs":
+		out, err := cmdRunner.Output("zfs", "get", "-H", "-o", "value", "compression", root)
+		if err != nil {
+			return fmt.Errorf("zfs get failed with error: %v, output: %s", err, out)
+		}
+		// e.g. zstd-3 is zstd
+		value := strings.TrimSpace(string(out))
+		if value != compression && !strings.HasPrefix(value, compression+"-") {
+			ignored = fmt.Sprintf("the ZFS dataset of %s has compression %s and ZFS can't set it per volume", root, value)
+		}
+	default:
+		ignored = fmt.Sprintf("the %s filesystem of %s doesn't support setting compression per volume", fstype, root)
+	}
+
+	if ignored != "" {
+		msg := fmt.Sprintf("Ignoring parameter compression %s: %s", compression, ignored)
+		glog.Warningf("Volume of claim %s/%s: %s", claim.Namespace, claim.Name, msg)
+		p.getEventRecorder().Event(claim, v1.EventTypeWarning, "CompressionIgnored", msg)
+	}
+	return nil
+}
@@ -29,6 +29,12 @@ import (
 // Delete removes the directory that was created by Provision backing the given
 // PV and removes its export from the NFS server.
 func (p *nfsProvisioner) Delete(volume *v1.PersistentVolume) error {
+	err := p.deleteVolume(volume)
+	p.notify(webhookDelete, volume, nil, err)
+	return err
+}
+
+func (p *nfsProvisioner) deleteVolume(volume *v1.PersistentVolume) error {
 	// Ignore the call if this provisioner was not the one to provision the
 	// volume. It doesn't even attempt to delete it, so it's neither a success
 	// (nil error) nor failure (any other error)
@@ -41,21 +47,50 @@ func (p *nfsProvisioner) Delete(volume *v1.PersistentVolume) error {
 		return &controller.IgnoredError{Reason: strerr}
 	}
 
+	p.drainExport(volume)
+
+	if err := p.checkEpoch(); err != nil {
+		return err
+	}
+
 	err = p.deleteDirectory(volume)
 	if err != nil {
 		return fmt.Errorf("error deleting volume's backing path: %v", err)
 	}
 
+	err = p.deleteSnapshots(volume)
+	if err != nil {
+		return fmt.Errorf("deleted the volume's backing path but error deleting its snapshots: %v", err)
+	}
+
+	root, directory := p.getRootAndDirectory(volume)
+	err = releasePreallocation(root, directory)
+	if err != nil {
+		return fmt.Errorf("deleted the volume's backing path but error releasing its preallocated capacity: %v", err)
+	}
+
 	err = p.deleteExport(volume)
 	if err != nil {
 		return fmt.Errorf("deleted the volume's backing path but error deleting export: %v", err)
 	}
 
+	err = p.deleteMountPoint(volume)
+	if err != nil {
+		return fmt.Errorf("deleted the volume's backing path & export but error deleting the mount point it was exported at: %v", err)
+	}
+
 	err = p.deleteQuota(volume)
 	if err != nil {
 		return fmt.Errorf("deleted the volume's backing path & export but error deleting quota: %v", err)
 	}
 
+	err = p.deleteReadReplica(volume)
+	if err != nil {
+		return fmt.Errorf("deleted the volume's backing path, export & quota but error deleting its read replica: %v", err)
+	}
+
+	p.runPostDeleteHook(volume, p.getDirectory(volume))
+
 	return nil
 }
 
@@ -68,18 +103,43 @@ func (p *nfsProvisioner) provisioned(volume *v1.PersistentVolume) (bool, error)
 	return provisionerID == string(p.identity), nil
 }
 
+// getDirectory returns the path of the given PV's backing directory.
+func (p *nfsProvisioner) getDirectory(volume *v1.PersistentVolume) string {
+	root, directory := p.getRootAndDirectory(volume)
+	return path.Join(root, directory)
+}
+
 func (p *nfsProvisioner) deleteDirectory(volume *v1.PersistentVolume) error {
-	path := path.Join(p.exportDir, volume.ObjectMeta.Name)
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	path := p.getDirectory(volume)
+	if _, err := fileSystem.Stat(path); os.IsNotExist(err) {
 		return nil
 	}
-	if err := os.RemoveAll(path); err != nil {
+	if err := fileSystem.RemoveAll(path); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// deleteMountPoint unmounts and removes the mount point the given PV's
+// backing directory was bind mounted at to be exported, if it was migrated.
+func (p *nfsProvisioner) deleteMountPoint(volume *v1.PersistentVolume) error {
+	exportPath, ok := volume.Annotations[annExportPath]
+	if !ok {
+		return nil
+	}
+	mounts, err := readBindMounts(path.Join(p.exportDir, bindMountsFile))
+	if err != nil {
+		return err
+	}
+	if _, ok := mounts[exportPath]; ok {
+		if err := p.unbindMount(exportPath); err != nil {
+			return err
+		}
+	}
+	return fileSystem.RemoveAll(exportPath)
+}
+
 func (p *nfsProvisioner) deleteExport(volume *v1.PersistentVolume) error {
 	block, exportID, err := getBlockAndID(volume, annExportBlock, annExportID)
 	if err != nil {
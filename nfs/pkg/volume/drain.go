@@ -0,0 +1,100 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// rmtabFile is the file mountd records the clients that have mounted each
+// export in. A variable so tests can fake it.
+var rmtabFile = "/var/lib/nfs/rmtab"
+
+// drainPollInterval is how often to check whether the clients of an export
+// being drained have unmounted it. A variable so tests can shorten it.
+var drainPollInterval = time.Second
+
+// drainExport waits, for up to drainTimeout, for the clients that have mounted
+// the given PV's export to unmount it, so that e.g. pods still terminating
+// don't get I/O errors when the export is removed from under them. The export
+// is removed anyway once drainTimeout has passed.
+func (p *nfsProvisioner) drainExport(volume *v1.PersistentVolume) {
+	if p.drainTimeout == 0 {
+		return
+	}
+	exportPath := p.getExportPath(volume)
+
+	var clients []string
+	err := wait.PollImmediate(drainPollInterval, p.drainTimeout, func() (bool, error) {
+		var err error
+		clients, err = getExportClients(exportPath)
+		if err != nil {
+			glog.Warningf("Error getting clients of export %s to drain: %v", exportPath, err)
+			return false, nil
+		}
+		return len(clients) == 0, nil
+	})
+	if err != nil {
+		glog.Warningf("Clients %v still have export %s of volume %q mounted after %v, removing it anyway", clients, exportPath, volume.Name, p.drainTimeout)
+	}
+}
+
+// getExportClients returns the clients that have mounted the given path
+// according to rmtabFile, whose lines are of the form client:path:count.
+// Clients that unmounted the path have a count of 0. NFSv4 clients don't mount
+// through mountd so aren't in rmtabFile.
+func getExportClients(exportPath string) ([]string, error) {
+	read, err := ioutil.ReadFile(rmtabFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	exportPath = path.Clean(exportPath)
+	var clients []string
+	for _, line := range strings.Split(string(read), "\n") {
+		// The client may be an IPv6 address with colons of its own, so split
+		// off the count first and then the path, which is absolute
+		countIndex := strings.LastIndex(line, ":")
+		if countIndex < 0 {
+			continue
+		}
+		pathIndex := strings.Index(line[:countIndex], ":/")
+		if pathIndex < 0 {
+			continue
+		}
+		if path.Clean(line[pathIndex+1:countIndex]) != exportPath {
+			continue
+		}
+		count, err := strconv.ParseUint(strings.TrimPrefix(line[countIndex+1:], "0x"), 16, 32)
+		if err != nil || count == 0 {
+			continue
+		}
+		clients = append(clients, line[:pathIndex])
+	}
+	return clients, nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// etabFile is the file mountd reads the export table from, re-reading it
+// whenever it changes. A variable so tests can fake it.
+var etabFile = "/var/lib/nfs/etab"
+
+// rpcCacheFlushFiles are the files the kernel NFS server's caches of exports,
+// file handles and client addresses are flushed through, so that it asks
+// mountd about them again. A variable so tests can fake it.
+var rpcCacheFlushFiles = []string{
+	"/proc/net/rpc/auth.unix.ip/flush",
+	"/proc/net/rpc/auth.unix.gid/flush",
+	"/proc/net/rpc/nfsd.fh/flush",
+	"/proc/net/rpc/nfsd.export/flush",
+}
+
+// writeEtab syncs the kernel's export table with /etc/exports and exportsDir
+// like exportfs -r does, without running it: it writes the exports to etabFile
+// and flushes the kernel's caches, so that mountd serves the new table. This
+// spares forking exportfs, which reads and resolves every export each run, for
+// every change.
+func (e *kernelExporter) writeEtab() error {
+	configs := []string{e.config}
+	if e.exportsDir != "" {
+		fragments, err := filepath.Glob(path.Join(e.exportsDir, "*"+exportsFragmentExt))
+		if err != nil {
+			return err
+		}
+		configs = append(configs, fragments...)
+	}
+
+	e.fileMutex.Lock()
+	var entries []string
+	for _, config := range configs {
+		read, err := ioutil.ReadFile(config)
+		if err != nil {
+			e.fileMutex.Unlock()
+			return err
+		}
+		configEntries, err := getEtabEntries(string(read))
+		if err != nil {
+			e.fileMutex.Unlock()
+			return fmt.Errorf("error parsing exports file %s: %v", config, err)
+		}
+		entries = append(entries, configEntries...)
+	}
+	e.fileMutex.Unlock()
+
+	// Replace etabFile in one go so that mountd never reads it half-written
+	tmp := etabFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strings.Join(entries, "")), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, etabFile); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error replacing %s: %v", etabFile, err)
+	}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10) + "\n"
+	for _, flush := range rpcCacheFlushFiles {
+		if _, err := os.Stat(flush); os.IsNotExist(err) {
+			continue
+		}
+		if err := ioutil.WriteFile(flush, []byte(now), 0); err != nil {
+			return fmt.Errorf("error flushing kernel cache %s: %v", flush, err)
+		}
+	}
+
+	return nil
+}
+
+// getEtabEntries converts the given exports(5)-formatted config to etab
+// entries, one line of path, tab, then client and options per client of each
+// export.
+func getEtabEntries(config string) ([]string, error) {
+	entries := []string{}
+	config = strings.Replace(config, "\\\n", " ", -1)
+	for _, line := range strings.Split(config, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var exportPath string
+		var clients []string
+		if line[0] == '"' {
+			end := strings.Index(line[1:], "\"")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted path in %q", line)
+			}
+			exportPath, clients = line[:end+2], strings.Fields(line[end+2:])
+		} else {
+			fields := strings.Fields(line)
+			exportPath, clients = fields[0], fields[1:]
+		}
+
+		// An export without clients is exported to every client with the
+		// default options, as are options without a client
+		if len(clients) == 0 {
+			clients = []string{"*"}
+		}
+		for _, client := range clients {
+			if client[0] == '(' {
+				client = "*" + client
+			}
+			if !strings.Contains(client, "(") {
+				client += "()"
+			}
+			entries = append(entries, exportPath+"\t"+client+"\n")
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+var _ controller.Expander = &nfsProvisioner{}
+
+// ExpandVolume expands the given PV by raising its quota to the given size. If
+// quotas aren't enforced, the volume could always use as much of its export
+// root as is free, so only its capacity changes. Volumes can't be expanded past
+// their namespace's quota. The capacity set aside for preallocated volumes is
+// resized too.
+func (p *nfsProvisioner) ExpandVolume(volume *v1.PersistentVolume, size resource.Quantity) (*v1.PersistentVolume, error) {
+	expanded, err := p.expandVolume(volume, size)
+	p.notify(webhookResize, volume, nil, err)
+	return expanded, err
+}
+
+func (p *nfsProvisioner) expandVolume(volume *v1.PersistentVolume, size resource.Quantity) (*v1.PersistentVolume, error) {
+	provisioned, err := p.provisioned(volume)
+	if err != nil {
+		return nil, fmt.Errorf("error determining if this provisioner was the one to provision volume %q: %v", volume.Name, err)
+	}
+	if !provisioned {
+		return nil, fmt.Errorf("this provisioner id %s didn't provision volume %q and so can't expand it; id %s did & can", p.identity, volume.Name, volume.Annotations[annProvisionerID])
+	}
+
+	err = p.checkNamespaceQuota(volume.Annotations[annNamespaceDirectory], volume.Name, size.Value())
+	if err != nil {
+		return nil, err
+	}
+
+	block, projectID, err := getBlockAndID(volume, annProjectBlock, annProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting block &/or id from annotations: %v", err)
+	}
+
+	if err := p.checkEpoch(); err != nil {
+		return nil, err
+	}
+	limit := strconv.FormatInt(size.Value(), 10)
+	block, err = p.quotaer.ResizeProject(block, projectID, p.getDirectory(volume), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error resizing quota project: %v", err)
+	}
+
+	root, directory := p.getRootAndDirectory(volume)
+	if isPreallocated(root, directory) {
+		if err := preallocate(root, directory, size.Value()); err != nil {
+			return nil, fmt.Errorf("error resizing preallocated capacity: %v", err)
+		}
+	}
+
+	volume.Annotations[annProjectBlock] = block
+	volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)] = size
+
+	return volume, nil
+}
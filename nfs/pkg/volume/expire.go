@@ -0,0 +1,129 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// A PV annotation with the time, in RFC 3339, after which the volume is
+// deleted, set if its class or claim has the parameter expiresAfter. It may be
+// changed to extend or shorten the volume's life
+const AnnExpiresAt = "nfs-provisioner/expires-at"
+
+// Expirer is implemented by provisioners that can delete the volumes they
+// provisioned once they expire.
+type Expirer interface {
+	// ExpireVolumes periodically deletes the volumes that have expired,
+	// until stopCh is closed. The data of volumes whose reclaim policy isn't
+	// Delete is only deleted if deleteRetained is set.
+	ExpireVolumes(period time.Duration, deleteRetained bool, stopCh <-chan struct{})
+}
+
+var _ Expirer = &nfsProvisioner{}
+
+// ExpireVolumes periodically deletes each PV this provisioner provisioned
+// whose AnnExpiresAt time has passed. A bound PV's claim is deleted, so that
+// the PV is released and deleted with its data as usual if its reclaim policy
+// is Delete. A PV that isn't bound and whose reclaim policy isn't Delete,
+// e.g. one whose claim was deleted before, is left alone, since its policy
+// asks for its data to be kept, unless deleteRetained is set, in which case
+// the provisioner deletes its data and object itself.
+func (p *nfsProvisioner) ExpireVolumes(period time.Duration, deleteRetained bool, stopCh <-chan struct{}) {
+	wait.Until(func() { p.expireVolumes(deleteRetained) }, period, stopCh)
+}
+
+func (p *nfsProvisioner) expireVolumes(deleteRetained bool) {
+	volumes, err := p.client.Core().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		glog.Errorf("Error listing PVs to expire: %v", err)
+		return
+	}
+	now := time.Now()
+	for i := range volumes.Items {
+		volume := &volumes.Items[i]
+		value, ok := volume.Annotations[AnnExpiresAt]
+		if !ok {
+			continue
+		}
+		if provisioned, err := p.provisioned(volume); err != nil || !provisioned {
+			continue
+		}
+		expiresAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			glog.Errorf("Error parsing annotation %s of volume %q: %v", AnnExpiresAt, volume.Name, err)
+			continue
+		}
+		if now.Before(expiresAt) {
+			continue
+		}
+		if err := p.expireVolume(volume, expiresAt, deleteRetained); err != nil {
+			glog.Errorf("Error expiring volume %q: %v", volume.Name, err)
+			p.getEventRecorder().Event(volume, v1.EventTypeWarning, "VolumeExpiryFailed", err.Error())
+		}
+	}
+}
+
+// expireVolume deletes the claim of the given expired PV if it's bound. If it
+// isn't, the PV is left to the provision controller if its reclaim policy is
+// Delete, and otherwise the PV and its data are deleted only if
+// deleteRetained is set.
+func (p *nfsProvisioner) expireVolume(volume *v1.PersistentVolume, expiresAt time.Time, deleteRetained bool) error {
+	msg := fmt.Sprintf("Volume %q expired at %s", volume.Name, expiresAt.Format(time.RFC3339))
+	if claimRef := volume.Spec.ClaimRef; claimRef != nil && volume.Status.Phase == v1.VolumeBound {
+		claim, err := p.client.Core().PersistentVolumeClaims(claimRef.Namespace).Get(claimRef.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error getting claim %s/%s: %v", claimRef.Namespace, claimRef.Name, err)
+		}
+		if claim.UID != claimRef.UID || claim.DeletionTimestamp != nil {
+			return nil
+		}
+		glog.Infof("%s, deleting its claim %s/%s", msg, claim.Namespace, claim.Name)
+		p.getEventRecorder().Event(claim, v1.EventTypeNormal, "VolumeExpired", msg+", deleting the claim")
+		err = p.client.Core().PersistentVolumeClaims(claim.Namespace).Delete(claim.Name, &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &claim.UID}})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("error deleting claim %s/%s: %v", claim.Namespace, claim.Name, err)
+		}
+		return nil
+	}
+
+	if volume.Spec.PersistentVolumeReclaimPolicy == v1.PersistentVolumeReclaimDelete {
+		return nil
+	}
+	if !deleteRetained {
+		glog.V(4).Infof("%s, keeping it because its reclaim policy is %s", msg, volume.Spec.PersistentVolumeReclaimPolicy)
+		return nil
+	}
+	glog.Infof("%s, deleting it", msg)
+	if err := p.Delete(volume); err != nil {
+		return err
+	}
+	err := p.client.Core().PersistentVolumes().Delete(volume.Name, &metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("deleted the volume's data but error deleting PV: %v", err)
+	}
+	return nil
+}
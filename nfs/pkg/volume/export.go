@@ -17,18 +17,33 @@ limitations under the License.
 package volume
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
-	"os/exec"
+	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"text/template"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/guelfey/go.dbus"
 	"k8s.io/client-go/pkg/api/v1"
 )
 
+const (
+	// The config file the kernel NFS server reads its exports from
+	kernelExportsConfig = "/etc/exports"
+
+	// The extension exportfs requires of the files in /etc/exports.d
+	exportsFragmentExt = ".exports"
+)
+
 type exporter interface {
 	AddExportBlock(string, bool) (string, uint16, error)
 	RemoveExportBlock(string, uint16) error
@@ -36,6 +51,16 @@ type exporter interface {
 	Unexport(*v1.PersistentVolume) error
 }
 
+// exportBlockRestorer is implemented by exporters that can check for and
+// restore the export blocks they added, e.g. if the config file was replaced.
+type exportBlockRestorer interface {
+	// HasExportBlock returns whether the given export block is in the config
+	HasExportBlock(string) (bool, error)
+	// RestoreExportBlock adds the given export block back to the config,
+	// keeping its exportID
+	RestoreExportBlock(string, uint16) error
+}
+
 type exportBlockCreator interface {
 	CreateExportBlock(string, string, bool) string
 }
@@ -49,6 +74,9 @@ type genericExporter struct {
 	// use it as both fsid and Export_Id.
 	exportIDs map[uint16]bool
 
+	// The in-memory copy of config, through which it is changed
+	table *exportTable
+
 	mapMutex  *sync.Mutex
 	fileMutex *sync.Mutex
 }
@@ -58,6 +86,14 @@ func newGenericExporter(ebc exportBlockCreator, config string, re *regexp.Regexp
 		glog.Fatalf("config %s does not exist!", config)
 	}
 
+	// Load the table first so that the ids of export blocks redone from its
+	// journal are known
+	fileMutex := &sync.Mutex{}
+	table, err := newExportTable(config, fileMutex)
+	if err != nil {
+		glog.Fatalf("Error loading config %s: %v", config, err)
+	}
+
 	exportIDs, err := getExistingIDs(config, re)
 	if err != nil {
 		glog.Errorf("error while populating exportIDs map, there may be errors exporting later if exportIDs are reused: %v", err)
@@ -66,8 +102,9 @@ func newGenericExporter(ebc exportBlockCreator, config string, re *regexp.Regexp
 		ebc:       ebc,
 		config:    config,
 		exportIDs: exportIDs,
+		table:     table,
 		mapMutex:  &sync.Mutex{},
-		fileMutex: &sync.Mutex{},
+		fileMutex: fileMutex,
 	}
 }
 
@@ -78,7 +115,7 @@ func (e *genericExporter) AddExportBlock(path string, rootSquash bool) (string,
 	block := e.ebc.CreateExportBlock(exportIDStr, path, rootSquash)
 
 	// Add the export block to the config file
-	if err := addToFile(e.fileMutex, e.config, block); err != nil {
+	if err := e.table.add(block); err != nil {
 		deleteID(e.mapMutex, e.exportIDs, exportID)
 		return "", 0, fmt.Errorf("error adding export block %s to config %s: %v", block, e.config, err)
 	}
@@ -87,7 +124,24 @@ func (e *genericExporter) AddExportBlock(path string, rootSquash bool) (string,
 
 func (e *genericExporter) RemoveExportBlock(block string, exportID uint16) error {
 	deleteID(e.mapMutex, e.exportIDs, exportID)
-	return removeFromFile(e.fileMutex, e.config, block)
+	return e.table.remove(block)
+}
+
+func (e *genericExporter) HasExportBlock(block string) (bool, error) {
+	e.fileMutex.Lock()
+	defer e.fileMutex.Unlock()
+	read, err := ioutil.ReadFile(e.config)
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(read), block), nil
+}
+
+func (e *genericExporter) RestoreExportBlock(block string, exportID uint16) error {
+	e.mapMutex.Lock()
+	e.exportIDs[exportID] = true
+	e.mapMutex.Unlock()
+	return e.table.add(block)
 }
 
 type ganeshaExporter struct {
@@ -95,10 +149,15 @@ type ganeshaExporter struct {
 }
 
 var _ exporter = &ganeshaExporter{}
+var _ exportBlockRestorer = &ganeshaExporter{}
 
-func newGaneshaExporter(ganeshaConfig string) exporter {
+func newGaneshaExporter(ganeshaConfig string, exportTemplate *template.Template) exporter {
+	var ebc exportBlockCreator = &ganeshaExportBlockCreator{}
+	if exportTemplate != nil {
+		ebc = newTemplateExportBlockCreator(exportTemplate, ebc, "root_id_squash")
+	}
 	return &ganeshaExporter{
-		genericExporter: *newGenericExporter(&ganeshaExportBlockCreator{}, ganeshaConfig, regexp.MustCompile("Export_Id = ([0-9]+);")),
+		genericExporter: *newGenericExporter(ebc, ganeshaConfig, regexp.MustCompile("Export_Id = ([0-9]+);")),
 	}
 }
 
@@ -163,32 +222,257 @@ func (e *ganeshaExportBlockCreator) CreateExportBlock(exportID, path string, roo
 
 type kernelExporter struct {
 	genericExporter
+
+	// The directory to write each export block to a file of its own in,
+	// instead of adding it to /etc/exports, if set
+	exportsDir string
+
+	// Whether to sync the kernel's export table by writing the etab and
+	// flushing the kernel's caches instead of running exportfs -r
+	directExport bool
+
+	// Runs exportfs -r, coalescing concurrent runs
+	batcher *exportfsBatcher
 }
 
 var _ exporter = &kernelExporter{}
+var _ exportBlockRestorer = &kernelExporter{}
+
+func newKernelExporter(exportsDir string, batchWindow time.Duration, exportTemplate *template.Template, directExport bool) exporter {
+	var ebc exportBlockCreator = &kernelExportBlockCreator{}
+	if exportTemplate != nil {
+		ebc = newTemplateExportBlockCreator(exportTemplate, ebc, "root_squash")
+	}
+	re := regexp.MustCompile("fsid=([0-9]+)")
+	e := &kernelExporter{
+		genericExporter: *newGenericExporter(ebc, kernelExportsConfig, re),
+		exportsDir:      exportsDir,
+		directExport:    directExport,
+	}
+	e.batcher = newExportfsBatcher(batchWindow, e.exportfs)
+	if exportsDir == "" {
+		return e
+	}
 
-func newKernelExporter() exporter {
-	return &kernelExporter{
-		genericExporter: *newGenericExporter(&kernelExportBlockCreator{}, "/etc/exports", regexp.MustCompile("fsid=([0-9]+)")),
+	if err := os.MkdirAll(exportsDir, 0755); err != nil {
+		glog.Fatalf("Error creating exports dir %s: %v", exportsDir, err)
 	}
+	fragments, err := filepath.Glob(path.Join(exportsDir, "*"+exportsFragmentExt))
+	if err != nil {
+		glog.Fatalf("Error listing exports dir %s: %v", exportsDir, err)
+	}
+	for _, fragment := range fragments {
+		ids, err := getExistingIDs(fragment, re)
+		if err != nil {
+			glog.Errorf("error while populating exportIDs map from %s, there may be errors exporting later if exportIDs are reused: %v", fragment, err)
+		}
+		for id := range ids {
+			e.exportIDs[id] = true
+		}
+	}
+	return e
 }
 
-// Export exports all directories listed in /etc/exports
-func (e *kernelExporter) Export(_ string) error {
-	// Execute exportfs
-	cmd := exec.Command("exportfs", "-r")
-	out, err := cmd.CombinedOutput()
+// AddExportBlock adds an export block for the given path to /etc/exports or,
+// if exportsDir is set, writes it to a file of its own there.
+func (e *kernelExporter) AddExportBlock(path string, rootSquash bool) (string, uint16, error) {
+	if e.exportsDir == "" {
+		return e.genericExporter.AddExportBlock(path, rootSquash)
+	}
+
+	exportID := generateID(e.mapMutex, e.exportIDs)
+	block := e.ebc.CreateExportBlock(strconv.FormatUint(uint64(exportID), 10), path, rootSquash)
+
+	fragment := e.getFragment(path)
+	file, err := os.OpenFile(fragment, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
-		return fmt.Errorf("exportfs -r failed with error: %v, output: %s", err, out)
+		deleteID(e.mapMutex, e.exportIDs, exportID)
+		return "", 0, fmt.Errorf("error creating exports file %s: %v", fragment, err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(block); err != nil {
+		os.Remove(fragment)
+		deleteID(e.mapMutex, e.exportIDs, exportID)
+		return "", 0, fmt.Errorf("error writing export block %s to exports file %s: %v", block, fragment, err)
 	}
+	return block, exportID, nil
+}
 
-	return nil
+// RemoveExportBlock removes the file of its own the given export block was
+// written to, if any, else removes the block from /etc/exports.
+func (e *kernelExporter) RemoveExportBlock(block string, exportID uint16) error {
+	if e.exportsDir != "" {
+		if fields := strings.Fields(block); len(fields) > 0 {
+			fragment := e.getFragment(fields[0])
+			if _, err := os.Stat(fragment); err == nil {
+				deleteID(e.mapMutex, e.exportIDs, exportID)
+				return os.Remove(fragment)
+			}
+		}
+	}
+	return e.genericExporter.RemoveExportBlock(block, exportID)
+}
+
+// HasExportBlock returns whether the given export block is in the file of its
+// own, if exportsDir is set, else in /etc/exports.
+func (e *kernelExporter) HasExportBlock(block string) (bool, error) {
+	if e.exportsDir == "" {
+		return e.genericExporter.HasExportBlock(block)
+	}
+	fields := strings.Fields(block)
+	if len(fields) == 0 {
+		return false, fmt.Errorf("export block %q has no path", block)
+	}
+	read, err := ioutil.ReadFile(e.getFragment(fields[0]))
+	if os.IsNotExist(err) {
+		return e.genericExporter.HasExportBlock(block)
+	} else if err != nil {
+		return false, err
+	}
+	return strings.Contains(string(read), block), nil
+}
+
+// RestoreExportBlock writes the given export block to a file of its own, if
+// exportsDir is set, else adds it to /etc/exports.
+func (e *kernelExporter) RestoreExportBlock(block string, exportID uint16) error {
+	if e.exportsDir == "" {
+		return e.genericExporter.RestoreExportBlock(block, exportID)
+	}
+	fields := strings.Fields(block)
+	if len(fields) == 0 {
+		return fmt.Errorf("export block %q has no path", block)
+	}
+	e.mapMutex.Lock()
+	e.exportIDs[exportID] = true
+	e.mapMutex.Unlock()
+	return ioutil.WriteFile(e.getFragment(fields[0]), []byte(block), 0644)
+}
+
+// getFragment returns the file in exportsDir to write the export block of the
+// given path to, named after the path since exportfs requires the extension
+// .exports, e.g. export-pvc-1.exports for /export/pvc-1.
+func (e *kernelExporter) getFragment(exportPath string) string {
+	name := strings.Replace(strings.Trim(path.Clean(exportPath), "/"), "/", "-", -1)
+	return path.Join(e.exportsDir, name+exportsFragmentExt)
+}
+
+// Export exports all directories listed in /etc/exports and exportsDir
+func (e *kernelExporter) Export(_ string) error {
+	return e.batcher.run()
 }
 
 func (e *kernelExporter) Unexport(volume *v1.PersistentVolume) error {
+	return e.batcher.run()
+}
+
+// procNFSExports is the file read to get the kernel's live export table. A
+// variable so tests can fake it.
+var procNFSExports = "/proc/fs/nfs/exports"
+
+// VerifyExports compares the paths exported by /etc/exports and exportsDir with
+// the kernel's live export table and, if any are missing from the latter, e.g.
+// because they were dropped when mountd restarted, re-syncs the table with
+// exportfs. Returns the paths that were missing.
+func (e *kernelExporter) VerifyExports() ([]string, error) {
+	missing, err := e.getMissingExports()
+	if err != nil || len(missing) == 0 {
+		return nil, err
+	}
+	if err := e.exportfs(); err != nil {
+		return missing, fmt.Errorf("paths %v are missing from the kernel's export table and error re-exporting them: %v", missing, err)
+	}
+	stillMissing, err := e.getMissingExports()
+	if err != nil {
+		return missing, err
+	}
+	if len(stillMissing) > 0 {
+		return missing, fmt.Errorf("paths %v are still missing from the kernel's export table after re-exporting them", stillMissing)
+	}
+	return missing, nil
+}
+
+// getMissingExports returns the paths exported by /etc/exports and exportsDir
+// that are missing from the kernel's live export table.
+func (e *kernelExporter) getMissingExports() ([]string, error) {
+	configs := []string{e.config}
+	if e.exportsDir != "" {
+		fragments, err := filepath.Glob(path.Join(e.exportsDir, "*"+exportsFragmentExt))
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, fragments...)
+	}
+
+	e.fileMutex.Lock()
+	configured := map[string]bool{}
+	for _, config := range configs {
+		paths, err := getExportsPaths(config)
+		if err != nil {
+			e.fileMutex.Unlock()
+			return nil, err
+		}
+		for _, path := range paths {
+			configured[path] = true
+		}
+	}
+	e.fileMutex.Unlock()
+
+	live, err := getExportsPaths(procNFSExports)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range live {
+		delete(configured, path)
+	}
+
+	missing := []string{}
+	for path := range configured {
+		missing = append(missing, path)
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// getExportsPaths returns the paths in the given exports(5)-formatted file,
+// the first field of each line that isn't blank, a comment or a continuation.
+func getExportsPaths(file string) ([]string, error) {
+	read, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, line := range strings.Split(string(read), "\n") {
+		if line == "" || line[0] == '#' || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		// Paths with spaces are quoted in exports(5)
+		if line[0] == '"' {
+			if end := strings.Index(line[1:], "\""); end >= 0 {
+				paths = append(paths, line[1:end+1])
+				continue
+			}
+		}
+		fields := strings.Fields(line)
+		// The kernel escapes spaces in paths as \040
+		paths = append(paths, strings.Replace(fields[0], "\\040", " ", -1))
+	}
+	return paths, nil
+}
+
+// exportfs syncs the kernel's export table with /etc/exports and exportsDir,
+// directly if directExport is set, else with exportfs.
+func (e *kernelExporter) exportfs() error {
+	if e.directExport {
+		return e.writeEtab()
+	}
+	return exportfs()
+}
+
+// exportfs syncs the kernel's export table with /etc/exports and
+// /etc/exports.d
+func exportfs() error {
 	// Execute exportfs
-	cmd := exec.Command("exportfs", "-r")
-	out, err := cmd.CombinedOutput()
+	out, err := cmdRunner.CombinedOutput("exportfs", "-r")
 	if err != nil {
 		return fmt.Errorf("exportfs -r failed with error: %v, output: %s", err, out)
 	}
@@ -196,6 +480,59 @@ func (e *kernelExporter) Unexport(volume *v1.PersistentVolume) error {
 	return nil
 }
 
+// exportfsBatcher coalesces the runs of exportfs requested within a window of
+// each other into one, so that when many volumes are provisioned or deleted
+// at once exportfs isn't run once per volume.
+type exportfsBatcher struct {
+	// How long to wait after the first request of a batch before running
+	// exportfs for the whole batch. If 0, every request runs it immediately.
+	window time.Duration
+
+	exportfs func() error
+
+	// Channels to send the result of the pending run to, one per request
+	waiters []chan error
+	mutex   sync.Mutex
+}
+
+func newExportfsBatcher(window time.Duration, exportfs func() error) *exportfsBatcher {
+	return &exportfsBatcher{
+		window:   window,
+		exportfs: exportfs,
+	}
+}
+
+// run requests a run of exportfs, returning its result once a run that
+// started after the request has finished.
+func (b *exportfsBatcher) run() error {
+	if b.window == 0 {
+		return b.exportfs()
+	}
+
+	waiter := make(chan error, 1)
+	b.mutex.Lock()
+	b.waiters = append(b.waiters, waiter)
+	if len(b.waiters) == 1 {
+		time.AfterFunc(b.window, b.flush)
+	}
+	b.mutex.Unlock()
+
+	return <-waiter
+}
+
+func (b *exportfsBatcher) flush() {
+	b.mutex.Lock()
+	waiters := b.waiters
+	b.waiters = nil
+	b.mutex.Unlock()
+
+	glog.V(4).Infof("running exportfs for a batch of %d requests", len(waiters))
+	err := b.exportfs()
+	for _, waiter := range waiters {
+		waiter <- err
+	}
+}
+
 type kernelExportBlockCreator struct{}
 
 var _ exportBlockCreator = &kernelExportBlockCreator{}
@@ -208,3 +545,123 @@ func (e *kernelExportBlockCreator) CreateExportBlock(exportID, path string, root
 	}
 	return "\n" + path + " *(rw,insecure," + squash + ",fsid=" + exportID + ")\n"
 }
+
+// exportTemplateData is what an export template is executed with.
+type exportTemplateData struct {
+	// The unique id of the export, to use as the ganesha Export_Id or the
+	// kernel fsid
+	ExportID string
+	// The path of the directory to export
+	Path string
+	// Whether to squash root
+	RootSquash bool
+	// The ganesha Squash or kernel option to use: no_root_squash if RootSquash
+	// is false, else root_id_squash for ganesha or root_squash for the kernel
+	Squash string
+}
+
+// LoadExportTemplate parses the Go template in the given file and checks that
+// it can be executed.
+func LoadExportTemplate(file string) (*template.Template, error) {
+	exportTemplate, err := template.ParseFiles(file)
+	if err != nil {
+		return nil, err
+	}
+	data := exportTemplateData{ExportID: "1", Path: "/export/test", RootSquash: false, Squash: "no_root_squash"}
+	if err := exportTemplate.Execute(ioutil.Discard, data); err != nil {
+		return nil, fmt.Errorf("error executing template %s: %v", file, err)
+	}
+	return exportTemplate, nil
+}
+
+// templateExportBlockCreator creates export blocks from an admin-provided
+// template, e.g. to produce option combinations the defaults can't.
+type templateExportBlockCreator struct {
+	template *template.Template
+	// The creator whose block to fall back to if executing the template fails
+	fallback exportBlockCreator
+	// The Squash to give the template if RootSquash is true
+	rootSquash string
+}
+
+var _ exportBlockCreator = &templateExportBlockCreator{}
+
+func newTemplateExportBlockCreator(exportTemplate *template.Template, fallback exportBlockCreator, rootSquash string) *templateExportBlockCreator {
+	return &templateExportBlockCreator{
+		template:   exportTemplate,
+		fallback:   fallback,
+		rootSquash: rootSquash,
+	}
+}
+
+// CreateExportBlock creates the text block to add to the config file by
+// executing the template. The block is surrounded by newlines, like the
+// default ones, so that it can be found and removed from the file later.
+func (e *templateExportBlockCreator) CreateExportBlock(exportID, path string, rootSquash bool) string {
+	squash := "no_root_squash"
+	if rootSquash {
+		squash = e.rootSquash
+	}
+	data := exportTemplateData{ExportID: exportID, Path: path, RootSquash: rootSquash, Squash: squash}
+	var block bytes.Buffer
+	if err := e.template.Execute(&block, data); err != nil {
+		glog.Errorf("Error executing export template for path %s, falling back to the default export block: %v", path, err)
+		return e.fallback.CreateExportBlock(exportID, path, rootSquash)
+	}
+	return "\n" + strings.Trim(block.String(), "\n") + "\n"
+}
+
+// noopExporter is the exporter used when each volume doesn't need an export
+// of its own: either volumes are created on an external NFS server's export
+// mounted at exportDir, which the external server already exports, or they
+// are subdirectories of a single consolidated export of exportDir.
+type noopExporter struct{}
+
+var _ exporter = &noopExporter{}
+
+func newNoopExporter() exporter {
+	return &noopExporter{}
+}
+
+func (e *noopExporter) AddExportBlock(_ string, _ bool) (string, uint16, error) {
+	return "", 0, nil
+}
+
+func (e *noopExporter) RemoveExportBlock(_ string, _ uint16) error {
+	return nil
+}
+
+func (e *noopExporter) Export(_ string) error {
+	return nil
+}
+
+func (e *noopExporter) Unexport(_ *v1.PersistentVolume) error {
+	return nil
+}
+
+// ensureConsolidatedExport adds an export block for exportDir to the given
+// config using the given exporter and exports it, unless the config already
+// has one, e.g. from a previous run.
+func ensureConsolidatedExport(exp exporter, config, exportDir string) error {
+	exportDir = path.Clean(exportDir)
+	read, err := ioutil.ReadFile(config)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading config %s: %v", config, err)
+	}
+	re := regexp.MustCompile("(?m)^(\\tPath = " + regexp.QuoteMeta(exportDir) + ";|" + regexp.QuoteMeta(exportDir) + " .*)$")
+	if re.Match(read) {
+		glog.Infof("Config %s already exports %s", config, exportDir)
+		return nil
+	}
+
+	block, exportID, err := exp.AddExportBlock(exportDir, false)
+	if err != nil {
+		return fmt.Errorf("error adding export block for path %s: %v", exportDir, err)
+	}
+	if err := exp.Export(exportDir); err != nil {
+		exp.RemoveExportBlock(block, exportID)
+		return fmt.Errorf("error exporting export block %s: %v", block, err)
+	}
+	glog.Infof("Exported %s", exportDir)
+	return nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// Name of the file in exportDir holding the epoch of the provisioner that
+// last took over the storage
+const epochFile = ".epoch"
+
+// Fencer is implemented by provisioners that can be fenced off the storage
+// they provision volumes on by another provisioner taking it over, e.g. a
+// standby replica taking over from one that is paused but not dead, so that
+// only one of them changes exports or deletes volumes.
+type Fencer interface {
+	// TakeEpoch fences off the provisioners that took over the storage
+	// before, then makes this one the provisioner that may change it.
+	TakeEpoch() error
+}
+
+var _ Fencer = &nfsProvisioner{}
+
+// TakeEpoch increments the epoch persisted in exportDir and makes the new
+// epoch this provisioner's. From then on a provisioner with an older epoch
+// fails to create, delete, repair or migrate volumes, since checkEpoch finds
+// the storage has been taken over.
+func (p *nfsProvisioner) TakeEpoch() error {
+	epoch, err := p.readEpoch()
+	if err != nil {
+		return err
+	}
+	epoch++
+	if err := writeFileAtomic(path.Join(p.exportDir, epochFile), []byte(strconv.FormatUint(epoch, 10)+"\n")); err != nil {
+		return fmt.Errorf("error writing epoch file: %v", err)
+	}
+	p.epochMutex.Lock()
+	p.epoch = epoch
+	p.epochMutex.Unlock()
+	glog.Infof("Took over the storage with epoch %d", epoch)
+	return nil
+}
+
+// checkEpoch returns an error if the provisioner took over the storage but
+// another has since, i.e. the persisted epoch is no longer its own. It is
+// checked before every change of exports or volumes' data.
+func (p *nfsProvisioner) checkEpoch() error {
+	home := p.shared()
+	home.epochMutex.Lock()
+	epoch := home.epoch
+	home.epochMutex.Unlock()
+	if epoch == 0 {
+		return nil
+	}
+	current, err := p.readEpoch()
+	if err != nil {
+		return err
+	}
+	if current != epoch {
+		return fmt.Errorf("fenced off: the storage was taken over with epoch %d after this provisioner's %d", current, epoch)
+	}
+	return nil
+}
+
+// readEpoch returns the epoch persisted in exportDir, 0 if none is.
+func (p *nfsProvisioner) readEpoch() (uint64, error) {
+	file := path.Join(p.exportDir, epochFile)
+	read, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("error reading epoch file: %v", err)
+	}
+	epoch, err := strconv.ParseUint(strings.TrimSpace(string(read)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing epoch file %s: %v", file, err)
+	}
+	return epoch, nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Name of the file an nfsProvisioner writes & reads back to probe its export
+// directory
+const healthProbeFile = ".nfs-provisioner-health"
+
+// HealthMonitor is implemented by provisioners that can probe the storage they
+// provision volumes on, refusing to provision while it is unhealthy. It serves
+// the result of the latest probe over HTTP, e.g. for a readiness probe.
+type HealthMonitor interface {
+	http.Handler
+	// MonitorHealth probes the storage every period, failing a probe that
+	// takes longer than timeout, until stopCh is closed.
+	MonitorHealth(period, timeout time.Duration, stopCh <-chan struct{})
+}
+
+var _ HealthMonitor = &nfsProvisioner{}
+
+// MonitorHealth periodically checks that exportDir, e.g. the mounted export
+// of an external server, is reachable and writable by writing and reading
+// back a file in it.
+func (p *nfsProvisioner) MonitorHealth(period, timeout time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		p.setHealth(p.probe(timeout))
+	}, period, stopCh)
+}
+
+// probe writes and reads back a file in exportDir, returning an error if
+// either fails or they don't finish within timeout, e.g. because an external
+// server is unreachable and I/O on its mount hangs.
+func (p *nfsProvisioner) probe(timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		probePath := path.Join(p.exportDir, healthProbeFile)
+		data := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+		if err := ioutil.WriteFile(probePath, data, 0600); err != nil {
+			done <- fmt.Errorf("error writing probe file %s: %v", probePath, err)
+			return
+		}
+		read, err := ioutil.ReadFile(probePath)
+		if err != nil {
+			done <- fmt.Errorf("error reading probe file %s: %v", probePath, err)
+			return
+		}
+		if !bytes.Equal(read, data) {
+			done <- fmt.Errorf("read %q from probe file %s but wrote %q", read, probePath, data)
+			return
+		}
+		done <- os.Remove(probePath)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("probing %s timed out after %v", p.exportDir, timeout)
+	}
+}
+
+func (p *nfsProvisioner) setHealth(err error) {
+	p.healthMutex.Lock()
+	defer p.healthMutex.Unlock()
+	if err != nil && p.healthErr == nil {
+		glog.Errorf("Export directory %s is unhealthy, not provisioning until it is healthy again: %v", p.exportDir, err)
+	} else if err == nil && p.healthErr != nil {
+		glog.Infof("Export directory %s is healthy again", p.exportDir)
+	}
+	p.healthErr = err
+}
+
+// getHealth returns the error of the latest probe, nil if it succeeded or
+// there has been none.
+func (p *nfsProvisioner) getHealth() error {
+	shared := p.shared()
+	shared.healthMutex.RLock()
+	defer shared.healthMutex.RUnlock()
+	return shared.healthErr
+}
+
+// ServeHTTP responds 200 if the latest probe succeeded and 503 with its error
+// otherwise.
+func (p *nfsProvisioner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := p.getHealth(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// recordUnhealthy records on the given claim that it isn't being provisioned
+// because of the given probe error.
+func (p *nfsProvisioner) recordUnhealthy(claim *v1.PersistentVolumeClaim, err error) {
+	p.getEventRecorder().Event(claim, v1.EventTypeWarning, "ProvisioningDeferred", fmt.Sprintf("Not provisioning volume for claim while storage is unhealthy: %v", err))
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/golang/glog"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Environment variables describing the volume that hooks are run with
+const (
+	hookVolumeName   = "VOLUME_NAME"
+	hookVolumePath   = "VOLUME_PATH"
+	hookVolumeSize   = "VOLUME_SIZE"
+	hookPVCNamespace = "PVC_NAMESPACE"
+	hookPVCName      = "PVC_NAME"
+)
+
+// runHook runs the given hook command with sh, with the given environment
+// variables set in addition to the provisioner's own.
+func runHook(hook string, env map[string]string) error {
+	args := []string{}
+	for k, v := range env {
+		args = append(args, k+"="+v)
+	}
+	// For deterministic command lines
+	sort.Strings(args)
+	args = append(args, "sh", "-c", hook)
+	glog.V(4).Infof("Running hook %q with %v", hook, env)
+	if out, err := cmdRunner.CombinedOutput("env", args...); err != nil {
+		return fmt.Errorf("hook %q failed with error: %v, output: %s", hook, err, out)
+	}
+	return nil
+}
+
+// runPostDeleteHook runs the post-delete hook, if any, for the given deleted
+// volume whose backing directory was at path. A failure is recorded as an
+// event on the volume but doesn't fail the deletion, since the volume is
+// already gone.
+func (p *nfsProvisioner) runPostDeleteHook(volume *v1.PersistentVolume, path string) {
+	if p.postDeleteHook == "" {
+		return
+	}
+	capacity := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	env := map[string]string{
+		hookVolumeName: volume.Name,
+		hookVolumePath: path,
+		hookVolumeSize: strconv.FormatInt(capacity.Value(), 10),
+	}
+	if volume.Spec.ClaimRef != nil {
+		env[hookPVCNamespace] = volume.Spec.ClaimRef.Namespace
+		env[hookPVCName] = volume.Spec.ClaimRef.Name
+	}
+	if err := runHook(p.postDeleteHook, env); err != nil {
+		glog.Errorf("Error running post-delete hook of volume %q: %v", volume.Name, err)
+		p.getEventRecorder().Event(volume, v1.EventTypeWarning, "PostDeleteHookFailed", err.Error())
+	}
+}
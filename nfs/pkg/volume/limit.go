@@ -0,0 +1,72 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+)
+
+// reserveVolume returns an error if maxVolumes volumes provisioned by this
+// provisioner, in any of the clusters it provisions for, already exist or are
+// being provisioned. Otherwise it counts one more volume as being provisioned
+// until releaseVolume is called.
+func (p *nfsProvisioner) reserveVolume() error {
+	if p.maxVolumes == 0 {
+		return nil
+	}
+	shared := p.shared()
+	shared.volumesMutex.Lock()
+	defer shared.volumesMutex.Unlock()
+	count, err := p.countVolumes()
+	if err != nil {
+		return err
+	}
+	if count+shared.provisioningVolumes >= p.maxVolumes {
+		shared.refusedVolumes++
+		return fmt.Errorf("provisioner has %d volumes and %d being provisioned, its maximum is %d", count, shared.provisioningVolumes, p.maxVolumes)
+	}
+	shared.provisioningVolumes++
+	return nil
+}
+
+// releaseVolume stops counting a volume reserved with reserveVolume as being
+// provisioned, once it has been provisioned or failed to be.
+func (p *nfsProvisioner) releaseVolume() {
+	if p.maxVolumes == 0 {
+		return
+	}
+	shared := p.shared()
+	shared.volumesMutex.Lock()
+	defer shared.volumesMutex.Unlock()
+	shared.provisioningVolumes--
+}
+
+// countVolumes returns the number of PVs provisioned by this provisioner in
+// all the clusters it provisions for.
+func (p *nfsProvisioner) countVolumes() (int, error) {
+	volumes, err := p.listVolumes()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, volume := range volumes {
+		if provisioned, err := p.provisioned(&volume); err == nil && provisioned {
+			count++
+		}
+	}
+	return count, nil
+}
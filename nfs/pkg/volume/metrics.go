@@ -0,0 +1,111 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// MetricsServer is implemented by provisioners that serve metrics about the
+// volumes they provision over HTTP in the Prometheus text format.
+type MetricsServer interface {
+	ServeMetrics(w http.ResponseWriter, r *http.Request)
+}
+
+var _ MetricsServer = &nfsProvisioner{}
+
+// ServeMetrics writes the number of volumes the provisioner has provisioned,
+// its maxVolumes, the number of volumes it refused to provision because of
+// maxVolumes and the bytes used by each volume with a quota and, if
+// MonitorUsage runs, the number of volumes at or over each usage threshold and
+// of times volumes crossed it.
+func (p *nfsProvisioner) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	count, err := p.countVolumes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	usages, err := p.getVolumeUsages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.volumesMutex.Lock()
+	refused := p.refusedVolumes
+	p.volumesMutex.Unlock()
+	p.usageMutex.Lock()
+	over := make(map[int]int64, len(p.usageThresholds))
+	crossings := make(map[int]int64, len(p.usageThresholds))
+	for _, threshold := range p.usageThresholds {
+		over[threshold] = 0
+		for _, volumeThreshold := range p.volumeThresholds {
+			if volumeThreshold >= threshold {
+				over[threshold]++
+			}
+		}
+		crossings[threshold] = int64(p.thresholdCrossings[threshold])
+	}
+	p.usageMutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetric(w, "nfs_provisioner_volumes", "gauge", "Number of volumes provisioned by this provisioner.", count)
+	writeMetric(w, "nfs_provisioner_max_volumes", "gauge", "Maximum number of volumes this provisioner provisions, 0 for no limit.", p.maxVolumes)
+	writeMetric(w, "nfs_provisioner_max_volumes_refused_total", "counter", "Number of times provisioning a volume was refused because the provisioner had its maximum number of volumes.", refused)
+	if len(usages) > 0 {
+		writeVolumeMetric(w, "nfs_provisioner_volume_used_bytes", "gauge", "Bytes used by each volume, from its quota accounting.", usages)
+	}
+	if len(over) > 0 {
+		writeThresholdMetric(w, "nfs_provisioner_volumes_over_usage_threshold", "gauge", "Number of volumes used to at least each usage threshold, in percent of their capacity.", over)
+		writeThresholdMetric(w, "nfs_provisioner_volume_usage_threshold_crossings_total", "counter", "Number of times a volume crossed each usage threshold, in percent of its capacity.", crossings)
+	}
+}
+
+// writeMetric writes a metric without labels in the Prometheus text format.
+func writeMetric(w io.Writer, name, metricType, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, metricType, name, value)
+}
+
+// writeVolumeMetric writes a metric with a value per volume, labeled with the
+// volume's name, in the Prometheus text format.
+func writeVolumeMetric(w io.Writer, name, metricType, help string, values map[string]int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+	volumes := make([]string, 0, len(values))
+	for volume := range values {
+		volumes = append(volumes, volume)
+	}
+	sort.Strings(volumes)
+	for _, volume := range volumes {
+		fmt.Fprintf(w, "%s{volume=%q} %d\n", name, volume, values[volume])
+	}
+}
+
+// writeThresholdMetric writes a metric with a value per usage threshold,
+// labeled with the threshold, in the Prometheus text format.
+func writeThresholdMetric(w io.Writer, name, metricType, help string, values map[int]int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+	thresholds := make([]int, 0, len(values))
+	for threshold := range values {
+		thresholds = append(thresholds, threshold)
+	}
+	sort.Ints(thresholds)
+	for _, threshold := range thresholds {
+		fmt.Fprintf(w, "%s{threshold=\"%d\"} %d\n", name, threshold, values[threshold])
+	}
+}
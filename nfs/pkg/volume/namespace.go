@@ -0,0 +1,85 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// A PV annotation recording the namespace directory, in its export root,
+	// that its backing directory was created in, if any
+	annNamespaceDirectory = "Namespace_Directory"
+)
+
+// getNamespaceDirectory returns the directory of the given claim's namespace
+// to create its volume's backing directory in, relative to the export root,
+// or "" if volumes aren't grouped by namespace.
+func (p *nfsProvisioner) getNamespaceDirectory(claim *v1.PersistentVolumeClaim) string {
+	if !p.namespaceDirectories {
+		return ""
+	}
+	return claim.Namespace
+}
+
+// createNamespaceDirectory creates the given namespace directory in the given
+// export root, if it doesn't exist yet. It may be traversed by anybody so that
+// the permissions of the backing directories in it are what counts.
+func (p *nfsProvisioner) createNamespaceDirectory(root, namespace string) error {
+	path := path.Join(root, namespace)
+	if _, err := fileSystem.Stat(path); !os.IsNotExist(err) {
+		return err
+	}
+	perm := os.FileMode(0755)
+	if err := fileSystem.MkdirAll(path, perm); err != nil {
+		return err
+	}
+	// Due to umask, need to chmod
+	return fileSystem.Chmod(path, perm)
+}
+
+// checkNamespaceQuota returns an error if the total capacity of the volumes
+// in the given namespace directory would exceed namespaceQuota once the one of
+// the given name has the given size, whether it is being created or expanded.
+func (p *nfsProvisioner) checkNamespaceQuota(namespace, name string, size int64) error {
+	if p.namespaceQuota == 0 || namespace == "" {
+		return nil
+	}
+	volumes, err := p.listVolumes()
+	if err != nil {
+		return err
+	}
+	used := int64(0)
+	for _, volume := range volumes {
+		if volume.Name == name || volume.Annotations[annNamespaceDirectory] != namespace {
+			continue
+		}
+		if provisioned, err := p.provisioned(&volume); err != nil || !provisioned {
+			continue
+		}
+		capacity := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		used += capacity.Value()
+	}
+	if used+size > p.namespaceQuota {
+		return fmt.Errorf("namespace %s has volumes of %v bytes and its quota of %v bytes leaves no room for %v bytes more", namespace, used, p.namespaceQuota, size)
+	}
+	return nil
+}
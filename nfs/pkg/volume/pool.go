@@ -0,0 +1,197 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// Directory in exportDir the directories of the pool are persisted in
+	poolStateDir = ".pool"
+	// Prefix of the names of the directories of the pool
+	poolDirectoryPrefix = "pool-"
+
+	// A PV annotation for the name of its backing directory, if it isn't the
+	// PV's name because the directory was taken from the pool
+	annDirectory = "Directory"
+)
+
+// DirectoryPool is implemented by provisioners that can keep a pool of
+// directories created and exported ahead of claims, to provision volumes
+// for claims without waiting for that.
+type DirectoryPool interface {
+	// MaintainPool periodically creates and exports directories until the
+	// pool has the given size, until stopCh is closed.
+	MaintainPool(size int, period time.Duration, stopCh <-chan struct{})
+}
+
+var _ DirectoryPool = &nfsProvisioner{}
+
+// pooledDirectory is a directory of the pool, created in exportDir with the
+// permissions of the gid "none" and exported without squashing root.
+type pooledDirectory struct {
+	Name        string `json:"name"`
+	ExportBlock string `json:"exportBlock"`
+	ExportID    uint16 `json:"exportID"`
+}
+
+// MaintainPool loads the directories of the pool persisted in exportDir, then
+// periodically creates and exports directories until there are size of them.
+// Until MaintainPool is called, no directory is taken from the pool.
+func (p *nfsProvisioner) MaintainPool(size int, period time.Duration, stopCh <-chan struct{}) {
+	if err := p.loadPool(); err != nil {
+		glog.Errorf("Error loading directory pool: %v", err)
+	}
+	wait.Until(func() {
+		if err := p.fillPool(size); err != nil {
+			glog.Errorf("Error filling directory pool: %v", err)
+		}
+	}, period, stopCh)
+}
+
+// loadPool loads the directories of the pool persisted in exportDir, e.g.
+// before a restart, dropping those whose directory is gone.
+func (p *nfsProvisioner) loadPool() error {
+	stateDir := path.Join(p.exportDir, poolStateDir)
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(path.Join(stateDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	var pool []pooledDirectory
+	for _, file := range files {
+		read, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var directory pooledDirectory
+		if err := json.Unmarshal(read, &directory); err != nil {
+			return fmt.Errorf("error decoding pool state file %s: %v", file, err)
+		}
+		if _, err := fileSystem.Stat(path.Join(p.exportDir, directory.Name)); os.IsNotExist(err) {
+			glog.Warningf("Directory %s of the pool is gone, dropping it", directory.Name)
+			p.exporter.RemoveExportBlock(directory.ExportBlock, directory.ExportID)
+			os.Remove(file)
+			continue
+		}
+		pool = append(pool, directory)
+	}
+
+	p.poolMutex.Lock()
+	defer p.poolMutex.Unlock()
+	p.pool = pool
+	return nil
+}
+
+// fillPool creates and exports directories until the pool has size of them.
+func (p *nfsProvisioner) fillPool(size int) error {
+	for {
+		p.poolMutex.Lock()
+		n := len(p.pool)
+		p.poolMutex.Unlock()
+		if n >= size {
+			return nil
+		}
+
+		directory, err := p.createPooledDirectory()
+		if err != nil {
+			return err
+		}
+		p.poolMutex.Lock()
+		p.pool = append(p.pool, directory)
+		p.poolMutex.Unlock()
+	}
+}
+
+// createPooledDirectory creates and exports a directory for the pool and
+// persists it.
+func (p *nfsProvisioner) createPooledDirectory() (pooledDirectory, error) {
+	if err := p.checkEpoch(); err != nil {
+		return pooledDirectory{}, err
+	}
+	name := poolDirectoryPrefix + string(uuid.NewUUID())
+	if err := p.createDirectory(p.exportDir, name, "none"); err != nil {
+		return pooledDirectory{}, fmt.Errorf("error creating directory %s: %v", name, err)
+	}
+	block, exportID, err := p.createExport(p.exportDir, name, false)
+	if err != nil {
+		fileSystem.RemoveAll(path.Join(p.exportDir, name))
+		return pooledDirectory{}, err
+	}
+	directory := pooledDirectory{Name: name, ExportBlock: block, ExportID: exportID}
+
+	data, err := json.Marshal(directory)
+	if err == nil {
+		err = ioutil.WriteFile(p.getPoolFile(name), data, 0600)
+	}
+	if err != nil {
+		p.removeVolume(name, path.Join(p.exportDir, name), block, exportID, "", 0)
+		return pooledDirectory{}, fmt.Errorf("error persisting directory %s: %v", name, err)
+	}
+	return directory, nil
+}
+
+// takePooledDirectory takes a directory from the pool for a volume to be
+// created in the given export root with the given gid and rootSquash, if the
+// pool has one and its directories suit the volume. The directory is no longer
+// persisted as part of the pool.
+func (p *nfsProvisioner) takePooledDirectory(root, gid string, rootSquash bool) (pooledDirectory, bool) {
+	home := p.shared()
+	if root != home.exportDir || gid != "none" || rootSquash {
+		return pooledDirectory{}, false
+	}
+	home.poolMutex.Lock()
+	defer home.poolMutex.Unlock()
+	if len(home.pool) == 0 {
+		return pooledDirectory{}, false
+	}
+	directory := home.pool[0]
+	home.pool = home.pool[1:]
+	if err := os.Remove(home.getPoolFile(directory.Name)); err != nil {
+		glog.Warningf("Error removing state file of pooled directory %s: %v", directory.Name, err)
+	}
+	return directory, true
+}
+
+// getPoolFile returns the path of the file the named directory of the pool is
+// persisted in.
+func (p *nfsProvisioner) getPoolFile(name string) string {
+	return path.Join(p.exportDir, poolStateDir, name+".json")
+}
+
+// getDirectoryName returns the name of the given PV's backing directory in
+// its export root and namespace directory, if any.
+func getDirectoryName(volume *v1.PersistentVolume) string {
+	if directory, ok := volume.Annotations[annDirectory]; ok {
+		return directory
+	}
+	return volume.Name
+}
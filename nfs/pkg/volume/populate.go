@@ -0,0 +1,238 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// API group & kind of the NFSDataSource CRD
+	dataSourceGroup = "external-storage.k8s.io"
+	dataSourceKind  = "NFSDataSource"
+	// Path of the API group & version of the NFSDataSource CRD
+	dataSourcesPath = "/apis/external-storage.k8s.io/v1alpha1"
+	// Resource name of the NFSDataSource CRD
+	dataSourcesResource = "nfsdatasources"
+)
+
+var _ controller.Populator = &nfsProvisioner{}
+
+// dataSource is the spec of an NFSDataSource object, which describes where to
+// copy the initial contents of a volume from. Exactly one of Rsync and HTTP
+// must be set.
+type dataSource struct {
+	Rsync *rsyncDataSource `json:"rsync,omitempty"`
+	HTTP  *httpDataSource  `json:"http,omitempty"`
+}
+
+// rsyncDataSource is an rsync daemon source to copy from, either
+// "host::module/path" or "rsync://host/module/path". Local paths aren't
+// accepted, since the provisioner would copy whatever it can read.
+type rsyncDataSource struct {
+	Source string `json:"source"`
+}
+
+// httpDataSource is a file to download. If it is a tar archive, optionally
+// compressed, it is extracted into the volume.
+type httpDataSource struct {
+	URL string `json:"url"`
+}
+
+// SupportsDataSource returns whether the given kind is NFSDataSource or, to
+// clone it, PersistentVolumeClaim.
+func (p *nfsProvisioner) SupportsDataSource(apiGroup, kind string) bool {
+	return apiGroup == dataSourceGroup && kind == dataSourceKind || apiGroup == "" && kind == "PersistentVolumeClaim"
+}
+
+// populate fills the volume directory at path from what the given options'
+// claim refers to in its dataSourceRef: an NFSDataSource or a claim to clone.
+// The controller has checked that the claim may refer to it if it is in
+// another namespace.
+func (p *nfsProvisioner) populate(options controller.VolumeOptions, path string) error {
+	ref := options.DataSourceRef
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = options.PVC.Namespace
+	}
+
+	if ref.APIGroup == "" && ref.Kind == "PersistentVolumeClaim" {
+		capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+		return p.cloneVolume(namespace, ref.Name, capacity, path)
+	}
+
+	source, err := p.getDataSource(namespace, ref.Name)
+	if err != nil {
+		return err
+	}
+	return p.populateVolume(source, path)
+}
+
+// getDataSource gets the named NFSDataSource.
+func (p *nfsProvisioner) getDataSource(namespace, name string) (*dataSource, error) {
+	raw, err := p.client.Core().RESTClient().Get().AbsPath(dataSourcesPath, "namespaces", namespace, dataSourcesResource, name).DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("error getting NFSDataSource %s/%s: %v", namespace, name, err)
+	}
+	object := &struct {
+		Spec dataSource `json:"spec"`
+	}{}
+	if err := json.Unmarshal(raw, object); err != nil {
+		return nil, fmt.Errorf("error decoding NFSDataSource %s/%s: %v", namespace, name, err)
+	}
+	return &object.Spec, nil
+}
+
+// cloneVolume copies the contents of the volume of the named claim into the
+// volume directory at path, of the given capacity. Only volumes this
+// provisioner provisioned, so whose directories it has, can be cloned.
+func (p *nfsProvisioner) cloneVolume(namespace, name string, capacity resource.Quantity, path string) error {
+	claim, err := p.client.Core().PersistentVolumeClaims(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting claim %s/%s to clone: %v", namespace, name, err)
+	}
+	if claim.Spec.VolumeName == "" || claim.Status.Phase != v1.ClaimBound {
+		return fmt.Errorf("claim %s/%s to clone is not bound", namespace, name)
+	}
+	volume, err := p.client.Core().PersistentVolumes().Get(claim.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting volume %q of claim %s/%s to clone: %v", claim.Spec.VolumeName, namespace, name, err)
+	}
+	if volume.Annotations[annProvisionerID] != string(p.identity) {
+		return fmt.Errorf("this provisioner id %s didn't provision volume %q of claim %s/%s and so can't clone it", p.identity, volume.Name, namespace, name)
+	}
+	sourceCapacity := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	if sourceCapacity.Cmp(capacity) > 0 {
+		return fmt.Errorf("requested capacity %s is less than the capacity %s of claim %s/%s to clone", capacity.String(), sourceCapacity.String(), namespace, name)
+	}
+
+	out, err := cmdRunner.CombinedOutput("rsync", "-aHAX", p.getDirectory(volume)+"/", path)
+	if err != nil {
+		return fmt.Errorf("rsync from volume %q failed with error: %v, output: %s", volume.Name, err, out)
+	}
+	return nil
+}
+
+// populateVolume copies the contents of the given data source into the volume
+// directory at path. The source's host must be one of dataSourceHosts.
+func (p *nfsProvisioner) populateVolume(source *dataSource, path string) error {
+	switch {
+	case source.Rsync != nil && source.HTTP != nil:
+		return fmt.Errorf("only one of rsync and http may be set")
+	case source.Rsync != nil:
+		host, err := parseRsyncSource(source.Rsync.Source)
+		if err != nil {
+			return err
+		}
+		if !p.dataSourceHosts[strings.ToLower(host)] {
+			return fmt.Errorf("rsync host %q is not one of the data source hosts", host)
+		}
+		// Keep the volume's group, i.e. its gid, on the copied files and let
+		// the group write them, like files created by its users
+		out, err := cmdRunner.CombinedOutput("rsync", "-rlt", "--chmod=Dg+rwxs,Fg+rw", "--", source.Rsync.Source, path+"/")
+		if err != nil {
+			return fmt.Errorf("rsync from %s failed with error: %v, output: %s", source.Rsync.Source, err, out)
+		}
+		return nil
+	case source.HTTP != nil:
+		return p.download(source.HTTP.URL, path)
+	}
+	return fmt.Errorf("one of rsync and http must be set")
+}
+
+// parseRsyncSource returns the host of the given rsync daemon source, of the
+// form [user@]host::module/path or rsync://[user@]host[:port]/module/path, or
+// an error if it isn't one, e.g. a local path or an option.
+func parseRsyncSource(source string) (string, error) {
+	if source == "" {
+		return "", fmt.Errorf("rsync source is empty")
+	}
+	if strings.HasPrefix(source, "-") {
+		return "", fmt.Errorf("rsync source %q is not an rsync daemon source", source)
+	}
+	var host string
+	if strings.HasPrefix(source, "rsync://") {
+		u, err := url.Parse(source)
+		if err != nil {
+			return "", fmt.Errorf("error parsing rsync source: %v", err)
+		}
+		host = u.Hostname()
+	} else if i := strings.Index(source, "::"); i > 0 && !strings.Contains(source[:i], "/") {
+		host = source[:i]
+		if j := strings.LastIndex(host, "@"); j >= 0 {
+			host = host[j+1:]
+		}
+	}
+	if host == "" || strings.HasPrefix(host, "-") {
+		return "", fmt.Errorf("rsync source %q is not an rsync daemon source of the form host::module/path or rsync://host/module/path", source)
+	}
+	return host, nil
+}
+
+// download downloads the file at the given URL into the directory at path,
+// extracting it if it is a tar archive. The URL's host must be one of
+// dataSourceHosts, and redirects aren't followed, so that it can't lead
+// elsewhere.
+func (p *nfsProvisioner) download(rawURL, dir string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("error parsing http url: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("http url %s is not http or https", rawURL)
+	}
+	if !p.dataSourceHosts[strings.ToLower(u.Hostname())] {
+		return fmt.Errorf("http host %q is not one of the data source hosts", u.Hostname())
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return fmt.Errorf("http url %s has no file name", rawURL)
+	}
+	file := path.Join(dir, name)
+	out, err := cmdRunner.CombinedOutput("curl", "-fsS", "--proto", "=http,https", "-o", file, "--", rawURL)
+	if err != nil {
+		return fmt.Errorf("downloading %s failed with error: %v, output: %s", rawURL, err, out)
+	}
+	if !isTarArchive(name) {
+		return nil
+	}
+	out, err = cmdRunner.CombinedOutput("tar", "-xf", file, "-C", dir, "--no-same-owner")
+	if err != nil {
+		return fmt.Errorf("extracting %s failed with error: %v, output: %s", name, err, out)
+	}
+	return fileSystem.RemoveAll(file)
+}
+
+// isTarArchive returns whether the named file is a tar archive that tar can
+// extract, going by its extension.
+func isTarArchive(name string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
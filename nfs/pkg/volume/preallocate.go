@@ -0,0 +1,84 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Directory in each export root the reservations of preallocated volumes are
+// created in
+const preallocatedDir = ".preallocated"
+
+// preallocate sets aside the given number of bytes of the given export root's
+// filesystem for the volume backed by the given directory in it, by
+// fallocating a reservation file of that size outside the volume's directory.
+// The reservation is resized if it already exists, e.g. when the volume is
+// expanded.
+func preallocate(root, directory string, size int64) error {
+	reservation := getReservation(root, directory)
+	if err := os.MkdirAll(path.Dir(reservation), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(reservation, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := syscall.Fallocate(int(file.Fd()), 0, 0, size); err != nil {
+		return fmt.Errorf("error fallocating %d bytes for %s: %v", size, reservation, err)
+	}
+	// Shrink the reservation if the volume's capacity was lowered
+	return file.Truncate(size)
+}
+
+// releasePreallocation removes the reservation of the volume backed by the
+// given directory in the given export root, if it has one.
+func releasePreallocation(root, directory string) error {
+	err := os.Remove(getReservation(root, directory))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// isPreallocated returns whether the volume backed by the given directory in
+// the given export root has a reservation.
+func isPreallocated(root, directory string) bool {
+	_, err := os.Stat(getReservation(root, directory))
+	return err == nil
+}
+
+func getReservation(root, directory string) string {
+	return path.Join(root, preallocatedDir, directory)
+}
+
+// getRootAndDirectory returns the export root of the given PV and the path of
+// its backing directory in it.
+func (p *nfsProvisioner) getRootAndDirectory(volume *v1.PersistentVolume) (string, string) {
+	// PVs provisioned before export roots were recorded are all in exportDir
+	root, ok := volume.Annotations[annExportRoot]
+	if !ok {
+		root = p.exportDir
+	}
+	return root, path.Join(volume.Annotations[annNamespaceDirectory], getDirectoryName(volume))
+}
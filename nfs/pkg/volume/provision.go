@@ -20,27 +20,37 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"reflect"
 	"strconv"
 	"strings"
-	"syscall"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/helper"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/client-go/kubernetes"
+	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/pkg/api"
 	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
 	// Name of the file where an nfsProvisioner will store its identity
 	identityFile = "nfs-provisioner.identity"
 
+	// Name of the file an nfsProvisioner writes & reads back to self-test a
+	// new export
+	selfTestFile = ".nfs-provisioner-self-test"
+
 	// are we allowed to set this? else make up our own
 	annCreatedBy = "kubernetes.io/createdby"
 	createdBy    = "nfs-dynamic-provisioner"
@@ -70,32 +80,243 @@ const (
 	// A PV annotation for the identity of the nfsProvisioner that provisioned it
 	annProvisionerID = "Provisioner_Id"
 
+	// A PV annotation for the export root the PV's backing directory was
+	// created in, needed for deletion.
+	annExportRoot = "Export_Root"
+
+	// The node label whose value a provisioned PV's node affinity requires
+	nodeLabelKey = "kubernetes.io/hostname"
+
+	// A PVC annotation set by the scheduler, naming the node selected for the
+	// claim's first consumer when its class has delayed volume binding
+	annSelectedNode = "volume.kubernetes.io/selected-node"
+
+	// AnnParameterPrefix is the prefix of the claim annotations that override
+	// the StorageClass parameter named by the rest of the key, e.g.
+	// nfs-provisioner/gid, if allowed by parameterOverrides
+	AnnParameterPrefix = "nfs-provisioner/"
+
+	// AnnSkip is a PVC annotation set by an admin to "true" to hold the claim
+	// out of provisioning, e.g. pending a manual review, even though it
+	// requests one of the provisioner's classes
+	AnnSkip = "nfs-provisioner/skip"
+
 	podIPEnv     = "POD_IP"
 	serviceEnv   = "SERVICE_NAME"
 	namespaceEnv = "POD_NAMESPACE"
 	nodeEnv      = "NODE_NAME"
+	hostIPEnv    = "HOST_IP"
+)
+
+// overridableParameters are the StorageClass parameters that claims may be
+// allowed to override.
+var overridableParameters = []string{"gid", "rootSquash", "mountOptions", "expiresAfter"}
+
+const (
+	// PlacementMostFree places each volume in the export root with the most
+	// available space
+	PlacementMostFree = "most-free"
+	// PlacementRoundRobin places volumes in the export roots in turn, skipping
+	// those without enough available space
+	PlacementRoundRobin = "round-robin"
 )
 
+// Config is how NewNFSProvisioner provisions and exports volumes.
+type Config struct {
+	// OutOfCluster is whether the provisioner runs outside the cluster, in
+	// which case it can't look up the pod and service it serves NFS from.
+	OutOfCluster bool
+	// UseGanesha is whether volumes are exported by NFS-Ganesha, configured
+	// by GaneshaConfig, rather than by the kernel NFS server.
+	UseGanesha    bool
+	GaneshaConfig string
+	// EnableXfsQuota is whether each volume gets an xfs project quota of its
+	// capacity.
+	EnableXfsQuota bool
+	// ServerHostname, if set, is put in PVs as their NFS server instead of
+	// the server's IP.
+	ServerHostname string
+	// ServerInterface, HostNetwork and NodeAffinity determine the NFS server
+	// IP put in PVs and whether PVs get the node affinity of the provisioner's
+	// node.
+	ServerInterface string
+	HostNetwork     bool
+	NodeAffinity    bool
+	// ExtraExportDirs are export directories to create volumes in besides
+	// the provisioner's, chosen among according to Placement.
+	ExtraExportDirs []string
+	Placement       string
+	// ClassExportDirs maps storage classes to their own export directory,
+	// which all their volumes are created in.
+	ClassExportDirs map[string]string
+	// ExternalServer, of the form host:/path, if set, is an external NFS
+	// server whose export is mounted at the provisioner's export directory:
+	// PVs then point at subdirectories of the export on the external server
+	// and nothing is exported.
+	ExternalServer string
+	// SelfTest is whether each new export is mounted and written to before
+	// its PV is returned.
+	SelfTest bool
+	// ConsolidatedExport is whether the export directory is exported once
+	// and PVs point at subdirectories of that export instead of each having
+	// their own.
+	ConsolidatedExport bool
+	// ExportsDir, if set, is where the kernel NFS server's export of each
+	// volume is written to a file of its own instead of to /etc/exports.
+	ExportsDir string
+	// ExportfsBatchWindow, if not 0, is how often at most the kernel NFS
+	// server's export table is synced.
+	ExportfsBatchWindow time.Duration
+	// ExportTemplate, if set, is a file with the Go template to create export
+	// blocks from instead of the default ones.
+	ExportTemplate string
+	// PreProvisionHook, if set, is run with sh once each volume is created,
+	// before its PV is returned, and the volume is removed again if it fails.
+	PreProvisionHook string
+	// PostDeleteHook, if set, is run with sh after each volume is deleted.
+	PostDeleteHook string
+	// ParameterOverrides are the StorageClass parameters that claims may
+	// override with AnnParameterPrefix annotations.
+	ParameterOverrides []string
+	// NamespaceDirectories is whether volumes are created in a directory of
+	// their claim's namespace in the export root. If NamespaceQuota is not 0,
+	// the total capacity of the volumes in each is capped at that many bytes.
+	NamespaceDirectories bool
+	NamespaceQuota       int64
+	// MaxVolumes, if not 0, is how many volumes are provisioned at most.
+	MaxVolumes int
+	// QuotaProjectPoolSize, if EnableXfsQuota is set and it is not 0, is how
+	// many project ids are kept reserved and initialized ahead of volumes.
+	QuotaProjectPoolSize int
+	// DirectExport is whether the kernel NFS server's export table is synced
+	// by writing the etab instead of running exportfs.
+	DirectExport bool
+	// DrainTimeout, if not 0, is how long deleting a volume waits for the
+	// clients of its export to unmount it.
+	DrainTimeout time.Duration
+	// DataSourceHosts are the hosts NFSDataSources may copy from, i.e. the
+	// rsync daemons and HTTP(S) servers an admin trusts. If empty, volumes can
+	// only be populated by cloning claims.
+	DataSourceHosts []string
+	// ReadReplicaServer, if set, is the server volumes of classes with the
+	// parameter readReplica "true" get a read-only PV pointing at their copy
+	// on, once ReplicateReads has copied them.
+	ReadReplicaServer string
+	// WebhookURL, if set, is POSTed a JSON notification of each volume
+	// provisioned, deleted or resized, or that failed to be.
+	WebhookURL string
+}
+
 // NewNFSProvisioner creates a Provisioner that provisions NFS PVs backed by
-// the given directory.
-func NewNFSProvisioner(exportDir string, client kubernetes.Interface, outOfCluster bool, useGanesha bool, ganeshaConfig string, enableXfsQuota bool, serverHostname string) controller.Provisioner {
+// the given directory, and by any others the config adds, as the config says.
+func NewNFSProvisioner(exportDir string, client kubernetes.Interface, config Config) controller.Provisioner {
+	var externalHost, externalPath string
+	if config.ExternalServer != "" {
+		var err error
+		externalHost, externalPath, err = ParseExternalServer(config.ExternalServer)
+		if err != nil {
+			glog.Fatalf("Error parsing external server: %v", err)
+		}
+	}
+
+	var tmpl *template.Template
+	if config.ExportTemplate != "" {
+		var err error
+		tmpl, err = LoadExportTemplate(config.ExportTemplate)
+		if err != nil {
+			glog.Fatalf("Error loading export template: %v", err)
+		}
+	}
+
 	var exp exporter
-	if useGanesha {
-		exp = newGaneshaExporter(ganeshaConfig)
+	if config.ExternalServer != "" {
+		exp = newNoopExporter()
+	} else if config.UseGanesha {
+		exp = newGaneshaExporter(config.GaneshaConfig, tmpl)
 	} else {
-		exp = newKernelExporter()
+		exp = newKernelExporter(config.ExportsDir, config.ExportfsBatchWindow, tmpl, config.DirectExport)
+	}
+	if config.ConsolidatedExport && config.ExternalServer == "" {
+		exportsConfig := config.GaneshaConfig
+		if !config.UseGanesha && config.ExportsDir != "" {
+			exportsConfig = exp.(*kernelExporter).getFragment(exportDir)
+		} else if !config.UseGanesha {
+			exportsConfig = kernelExportsConfig
+		}
+		if err := ensureConsolidatedExport(exp, exportsConfig, exportDir); err != nil {
+			glog.Fatalf("Error creating consolidated export of %s: %v", exportDir, err)
+		}
+		exp = newNoopExporter()
 	}
 	var quotaer quotaer
 	var err error
-	if enableXfsQuota {
-		quotaer, err = newXfsQuotaer(exportDir)
+	if config.EnableXfsQuota {
+		quotaer, err = newXfsQuotaer(exportDir, config.QuotaProjectPoolSize)
 		if err != nil {
 			glog.Fatalf("Error creating xfs quotaer! %v", err)
 		}
 	} else {
 		quotaer = newDummyQuotaer()
 	}
-	return newNFSProvisionerInternal(exportDir, client, outOfCluster, exp, quotaer, serverHostname)
+	provisioner := newNFSProvisionerInternal(exportDir, client, config.OutOfCluster, exp, quotaer, config.ServerHostname)
+	provisioner.serverInterface = config.ServerInterface
+	provisioner.hostNetwork = config.HostNetwork
+	provisioner.nodeAffinity = config.NodeAffinity
+	for _, dir := range config.ExtraExportDirs {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			glog.Fatalf("extra export dir %s does not exist!", dir)
+		}
+		provisioner.exportRoots = append(provisioner.exportRoots, dir)
+	}
+	provisioner.placement = config.Placement
+	for class, dir := range config.ClassExportDirs {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			glog.Fatalf("export dir %s of class %s does not exist!", dir, class)
+		}
+	}
+	provisioner.classExportRoots = config.ClassExportDirs
+	provisioner.externalHost = externalHost
+	provisioner.externalPath = externalPath
+	provisioner.selfTest = config.SelfTest
+	provisioner.consolidatedExport = config.ConsolidatedExport
+	provisioner.preProvisionHook = config.PreProvisionHook
+	provisioner.postDeleteHook = config.PostDeleteHook
+	if err := ValidateParameterOverrides(config.ParameterOverrides); err != nil {
+		glog.Fatalf("Error validating parameter overrides: %v", err)
+	}
+	provisioner.parameterOverrides = make(map[string]bool, len(config.ParameterOverrides))
+	for _, parameter := range config.ParameterOverrides {
+		provisioner.parameterOverrides[strings.ToLower(parameter)] = true
+	}
+	provisioner.namespaceDirectories = config.NamespaceDirectories
+	provisioner.namespaceQuota = config.NamespaceQuota
+	provisioner.maxVolumes = config.MaxVolumes
+	provisioner.drainTimeout = config.DrainTimeout
+	provisioner.readReplicaServer = config.ReadReplicaServer
+	provisioner.dataSourceHosts = make(map[string]bool, len(config.DataSourceHosts))
+	for _, host := range config.DataSourceHosts {
+		provisioner.dataSourceHosts[strings.ToLower(host)] = true
+	}
+	if config.WebhookURL != "" {
+		provisioner.startWebhook(config.WebhookURL)
+	}
+	return provisioner
+}
+
+// getExternalPath returns the path on the external server of the given
+// directory created in exportDir.
+func (p *nfsProvisioner) getExternalPath(directory string) string {
+	return path.Join(p.externalPath, directory)
+}
+
+// ParseExternalServer parses an external NFS server export of the form
+// host:/path into its host and path.
+func ParseExternalServer(externalServer string) (string, string, error) {
+	parts := strings.SplitN(externalServer, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || !strings.HasPrefix(parts[1], "/") {
+		return "", "", fmt.Errorf("external server %q is not of the form host:/path", externalServer)
+	}
+	return parts[0], parts[1], nil
 }
 
 func newNFSProvisionerInternal(exportDir string, client kubernetes.Interface, outOfCluster bool, exporter exporter, quotaer quotaer, serverHostname string) *nfsProvisioner {
@@ -121,6 +342,8 @@ func newNFSProvisionerInternal(exportDir string, client kubernetes.Interface, ou
 
 	provisioner := &nfsProvisioner{
 		exportDir:      exportDir,
+		exportRoots:    []string{exportDir},
+		placement:      PlacementMostFree,
 		client:         client,
 		outOfCluster:   outOfCluster,
 		exporter:       exporter,
@@ -131,15 +354,112 @@ func newNFSProvisionerInternal(exportDir string, client kubernetes.Interface, ou
 		serviceEnv:     serviceEnv,
 		namespaceEnv:   namespaceEnv,
 		nodeEnv:        nodeEnv,
+		hostIPEnv:      hostIPEnv,
 	}
 
 	return provisioner
 }
 
 type nfsProvisioner struct {
-	// The directory to create PV-backing directories in
+	// The directory to create PV-backing directories in, and where the
+	// identity file is persisted
 	exportDir string
 
+	// The directories, exportDir first, any of which a PV-backing directory
+	// may be created in, e.g. the mount points of several disks
+	exportRoots []string
+
+	// How to choose the export root to create each PV-backing directory in
+	placement string
+
+	// Storage classes mapped to the one export root, instead of exportRoots,
+	// to create their PV-backing directories in, e.g. to serve a disk tier
+	classExportRoots map[string]string
+
+	// The host and path of the external NFS server export mounted at
+	// exportDir, if any. If set, provisioned PVs point at subdirectories of
+	// the export on the external server instead of this server
+	externalHost string
+	externalPath string
+
+	// Whether exportDir is exported once, with PVs pointing at subdirectories
+	// of the one export rather than each having their own
+	consolidatedExport bool
+
+	// Whether to mount each new export locally and check that a file can be
+	// written to and read back from it before returning the PV
+	selfTest bool
+
+	// Commands to run with sh after creating each volume, before returning
+	// its PV, and after deleting each volume, if any. Run with the
+	// environment variables VOLUME_NAME, VOLUME_PATH, VOLUME_SIZE,
+	// PVC_NAMESPACE and PVC_NAME describing the volume
+	preProvisionHook string
+	postDeleteHook   string
+
+	// The lowercased names of the StorageClass parameters that claims may
+	// override with AnnParameterPrefix annotations
+	parameterOverrides map[string]bool
+
+	// Whether to create each volume's backing directory in a directory of its
+	// claim's namespace, and the total capacity in bytes of the volumes in
+	// each such directory, 0 for no limit
+	namespaceDirectories bool
+	namespaceQuota       int64
+
+	// The maximum number of volumes to provision, 0 for no limit, the number
+	// being provisioned that aren't PVs yet, and the number refused because
+	// of the maximum
+	maxVolumes          int
+	provisioningVolumes int
+	refusedVolumes      int
+	volumesMutex        sync.Mutex
+
+	// The directories created and exported in exportDir ahead of claims, to
+	// be taken by volumes instead of creating their own, if MaintainPool runs
+	pool      []pooledDirectory
+	poolMutex sync.Mutex
+
+	// How long to wait, when deleting a volume, for the clients of its export
+	// to unmount it before removing it, 0 for not waiting
+	drainTimeout time.Duration
+
+	// The server a read-only copy of the volumes of classes with the
+	// parameter readReplica "true" is served from, if ReplicateReads runs
+	readReplicaServer string
+
+	// The hosts NFSDataSources may copy from, lowercased
+	dataSourceHosts map[string]bool
+
+	// The notifications queued for the webhook, nil if there is none
+	webhooks chan webhookNotification
+
+	// The usage thresholds in percent of capacity, ascending, the highest
+	// one each volume was last found at or over, and the number of times
+	// volumes crossed each, if MonitorUsage runs
+	usageThresholds    []int
+	volumeThresholds   map[string]int
+	thresholdCrossings map[int]int
+	usageMutex         sync.Mutex
+
+	// The epoch with which the provisioner took over the storage, if
+	// TakeEpoch was called, 0 if not
+	epoch      uint64
+	epochMutex sync.Mutex
+
+	// The error of the latest health probe of exportDir, if any
+	healthErr   error
+	healthMutex sync.RWMutex
+
+	// The recorder of events on claims & PVs, created when first needed
+	eventRecorder record.EventRecorder
+	recorderOnce  sync.Once
+
+	// The index in exportRoots to start from when next placing a volume
+	// round-robin
+	nextRoot  int
+	rootMutex sync.Mutex
+
 	// Client, needed for getting a service cluster IP to put as the NFS server of
 	// provisioned PVs
 	client kubernetes.Interface
@@ -154,10 +474,24 @@ type nfsProvisioner struct {
 	// The quotaer to use for setting per-share/directory/project quotas
 	quotaer quotaer
 
-	// The hostname for the NFS server to export from. Only applicable when
-	// running as a Docker container
+	// The hostname for the NFS server to export from. If set, it is put as the
+	// server of every provisioned PV regardless of where we are running
 	serverHostname string
 
+	// The network interface whose address to put as the server of provisioned
+	// PVs, if serverHostname is not set
+	serverInterface string
+
+	// Whether the provisioner is running with hostNetwork, in which case the
+	// node's primary IP is put as the server of provisioned PVs rather than
+	// the node name or a service cluster IP
+	hostNetwork bool
+
+	// Whether to stamp provisioned PVs with node affinity to the node the
+	// provisioner is running on, e.g. when running as a DaemonSet where each
+	// instance exports its own node's local storage
+	nodeAffinity bool
+
 	// Identity of this nfsProvisioner, generated & persisted to exportDir or
 	// recovered from there. Used to mark provisioned PVs
 	identity types.UID
@@ -169,19 +503,91 @@ type nfsProvisioner struct {
 	serviceEnv   string
 	namespaceEnv string
 	nodeEnv      string
+	hostIPEnv    string
+
+	// The provisioner of the cluster this one runs in, if this one provisions
+	// for the claims of another cluster, else the provisioners of the other
+	// clusters this one provisions for, if any
+	home     *nfsProvisioner
+	clusters []*nfsProvisioner
 }
 
 var _ controller.Provisioner = &nfsProvisioner{}
+var _ controller.Qualifier = &nfsProvisioner{}
+
+// ShouldProvision returns whether provisioning should be attempted for the
+// given claim. Nothing is provisioned for claims annotated with AnnSkip or
+// while the latest health probe failed. When stamping PVs with node affinity,
+// only the provisioner on the node selected for the claim's first consumer, if
+// any, may provision it.
+func (p *nfsProvisioner) ShouldProvision(claim *v1.PersistentVolumeClaim) bool {
+	if skip, _ := strconv.ParseBool(claim.Annotations[AnnSkip]); skip {
+		glog.V(4).Infof("Not provisioning for claim %s/%s: it is annotated with %s", claim.Namespace, claim.Name, AnnSkip)
+		return false
+	}
+	if err := p.getHealth(); err != nil {
+		p.recordUnhealthy(claim, err)
+		return false
+	}
+	if !p.nodeAffinity {
+		return true
+	}
+	selectedNode, found := claim.Annotations[annSelectedNode]
+	if !found {
+		return true
+	}
+	return selectedNode == os.Getenv(p.nodeEnv)
+}
+
+// getEventRecorder returns the recorder of events on claims & PVs, creating it
+// on first use. Events are dropped if there is no client, i.e. in standalone
+// mode.
+func (p *nfsProvisioner) getEventRecorder() record.EventRecorder {
+	p.recorderOnce.Do(func() {
+		if p.client == nil {
+			p.eventRecorder = &record.FakeRecorder{}
+			return
+		}
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: p.client.Core().Events(v1.NamespaceAll)})
+		p.eventRecorder = broadcaster.NewRecorder(api.Scheme, v1.EventSource{Component: fmt.Sprintf("%s %s", createdBy, string(p.identity))})
+	})
+	return p.eventRecorder
+}
 
 // Provision creates a volume i.e. the storage asset and returns a PV object for
 // the volume.
 func (p *nfsProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	pv, err := p.provision(options)
+	p.notify(webhookProvision, pv, &options, err)
+	return pv, err
+}
+
+func (p *nfsProvisioner) provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if err := p.reserveVolume(); err != nil {
+		return nil, fmt.Errorf("error reserving volume: %v", err)
+	}
+	defer p.releaseVolume()
+
+	annotations := make(map[string]string)
+
+	// Set the node affinity before creating the volume so that there is
+	// nothing to clean up if it fails
+	affinity, err := p.getNodeAffinity(options.AllowedTopologies)
+	if err != nil {
+		return nil, fmt.Errorf("error getting node affinity for volume: %v", err)
+	}
+	if affinity != nil {
+		if err := helper.StorageNodeAffinityToAlphaAnnotation(annotations, affinity); err != nil {
+			return nil, fmt.Errorf("error converting node affinity to alpha annotation: %v", err)
+		}
+	}
+
 	volume, err := p.createVolume(options)
 	if err != nil {
 		return nil, err
 	}
 
-	annotations := make(map[string]string)
 	annotations[annCreatedBy] = createdBy
 	annotations[annExportBlock] = volume.exportBlock
 	annotations[annExportID] = strconv.FormatUint(uint64(volume.exportID), 10)
@@ -194,6 +600,19 @@ func (p *nfsProvisioner) Provision(options controller.VolumeOptions) (*v1.Persis
 		annotations[MountOptionAnnotation] = volume.mountOptions
 	}
 	annotations[annProvisionerID] = string(p.identity)
+	annotations[annExportRoot] = volume.root
+	if volume.namespaceDir != "" {
+		annotations[annNamespaceDirectory] = volume.namespaceDir
+	}
+	if volume.directory != options.PVName {
+		annotations[annDirectory] = volume.directory
+	}
+	if volume.readReplica {
+		annotations[annReadReplica] = options.PVName + "-ro"
+	}
+	if volume.expiresAfter > 0 {
+		annotations[AnnExpiresAt] = time.Now().Add(volume.expiresAfter).UTC().Format(time.RFC3339)
+	}
 
 	pv := &v1.PersistentVolume{
 		ObjectMeta: metav1.ObjectMeta{
@@ -222,6 +641,7 @@ func (p *nfsProvisioner) Provision(options controller.VolumeOptions) (*v1.Persis
 
 type volume struct {
 	server       string
+	root         string
 	path         string
 	exportBlock  string
 	exportID     uint16
@@ -229,79 +649,249 @@ type volume struct {
 	projectID    uint16
 	supGroup     uint64
 	mountOptions string
+	namespaceDir string
+	directory    string
+	readReplica  bool
+	expiresAfter time.Duration
 }
 
 // createVolume creates a volume i.e. the storage asset. It creates a unique
-// directory under /export and exports it. Returns the server IP, the path, a
+// directory under one of the export roots and exports it. Returns the server IP, the path, a
 // zero/non-zero supplemental group, the block it added to either the ganesha
 // config or /etc/exports, and the exportID
 // TODO return values
 func (p *nfsProvisioner) createVolume(options controller.VolumeOptions) (volume, error) {
-	gid, rootSquash, mountOptions, err := p.validateOptions(options)
+	params, err := p.validateOptions(options)
 	if err != nil {
 		return volume{}, fmt.Errorf("error validating options for volume: %v", err)
 	}
 
+	if err := p.checkEpoch(); err != nil {
+		return volume{}, err
+	}
+
 	server, err := p.getServer()
 	if err != nil {
 		return volume{}, fmt.Errorf("error getting NFS server IP for volume: %v", err)
 	}
 
-	path := path.Join(p.exportDir, options.PVName)
-
-	err = p.createDirectory(options.PVName, gid)
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	root, err := p.getExportRoot(options.PVC, capacity.Value())
 	if err != nil {
-		return volume{}, fmt.Errorf("error creating directory for volume: %v", err)
+		return volume{}, fmt.Errorf("error choosing export root for volume: %v", err)
 	}
 
-	exportBlock, exportID, err := p.createExport(options.PVName, rootSquash)
-	if err != nil {
-		os.RemoveAll(path)
-		return volume{}, fmt.Errorf("error creating export for volume: %v", err)
+	namespaceDir := p.getNamespaceDirectory(options.PVC)
+	if namespaceDir != "" {
+		err = p.checkNamespaceQuota(namespaceDir, options.PVName, capacity.Value())
+		if err != nil {
+			return volume{}, err
+		}
+		err = p.createNamespaceDirectory(root, namespaceDir)
+		if err != nil {
+			return volume{}, fmt.Errorf("error creating namespace directory for volume: %v", err)
+		}
+	}
+	name := options.PVName
+
+	// A directory of the pool is already created and exported, so only
+	// volumes that need nothing more of them can take one
+	var exportBlock string
+	var exportID uint16
+	pooled, ok := pooledDirectory{}, false
+	if namespaceDir == "" && options.DataSourceRef == nil {
+		pooled, ok = p.takePooledDirectory(root, params.gid, params.rootSquash)
 	}
+	if ok {
+		name, exportBlock, exportID = pooled.Name, pooled.ExportBlock, pooled.ExportID
+	}
+	directory := path.Join(namespaceDir, name)
+
+	path := path.Join(root, directory)
+	exportedPath := path
+	if p.externalHost != "" {
+		exportedPath = p.getExternalPath(directory)
+	}
+
+	if !ok {
+		err = p.createDirectory(root, directory, params.gid)
+		if err != nil {
+			return volume{}, fmt.Errorf("error creating directory for volume: %v", err)
+		}
+	}
+
+	// Compression only applies to the data written after it's set, so it's
+	// set before the volume is populated
+	if params.compression != "" {
+		err = p.setCompression(options.PVC, root, path, params.compression)
+		if err != nil {
+			if ok {
+				p.removeVolume(name, path, exportBlock, exportID, "", 0)
+			} else {
+				fileSystem.RemoveAll(path)
+			}
+			return volume{}, fmt.Errorf("error setting compression for volume: %v", err)
+		}
+	}
+
+	if !ok {
+		if options.DataSourceRef != nil {
+			err = p.populate(options, path)
+			if err != nil {
+				fileSystem.RemoveAll(path)
+				return volume{}, fmt.Errorf("error populating volume: %v", err)
+			}
+		}
 
-	projectBlock, projectID, err := p.createQuota(options.PVName, options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)])
+		exportBlock, exportID, err = p.createExport(root, directory, params.rootSquash)
+		if err != nil {
+			fileSystem.RemoveAll(path)
+			return volume{}, fmt.Errorf("error creating export for volume: %v", err)
+		}
+	}
+
+	projectBlock, projectID, err := p.createQuota(root, directory, capacity)
 	if err != nil {
-		os.RemoveAll(path)
+		if ok {
+			p.removeVolume(name, path, exportBlock, exportID, "", 0)
+		} else {
+			fileSystem.RemoveAll(path)
+		}
 		return volume{}, fmt.Errorf("error creating quota for volume: %v", err)
 	}
 
+	if params.preallocate {
+		err = preallocate(root, directory, capacity.Value())
+		if err != nil {
+			releasePreallocation(root, directory)
+			p.removeVolume(name, path, exportBlock, exportID, projectBlock, projectID)
+			return volume{}, fmt.Errorf("error preallocating capacity for volume: %v", err)
+		}
+	}
+
+	if p.selfTest {
+		err = p.testExport(exportedPath, params.mountOptions, params.gid == "none")
+		if err != nil {
+			releasePreallocation(root, directory)
+			p.removeVolume(name, path, exportBlock, exportID, projectBlock, projectID)
+			return volume{}, fmt.Errorf("error self-testing export for volume: %v", err)
+		}
+	}
+
+	if p.preProvisionHook != "" {
+		err = runHook(p.preProvisionHook, map[string]string{
+			hookVolumeName:   options.PVName,
+			hookVolumePath:   path,
+			hookVolumeSize:   strconv.FormatInt(capacity.Value(), 10),
+			hookPVCNamespace: options.PVC.Namespace,
+			hookPVCName:      options.PVC.Name,
+		})
+		if err != nil {
+			releasePreallocation(root, directory)
+			p.removeVolume(name, path, exportBlock, exportID, projectBlock, projectID)
+			return volume{}, fmt.Errorf("error running pre-provision hook for volume: %v", err)
+		}
+	}
+
 	return volume{
 		server:       server,
-		path:         path,
+		root:         root,
+		path:         exportedPath,
 		exportBlock:  exportBlock,
 		exportID:     exportID,
 		projectBlock: projectBlock,
 		projectID:    projectID,
 		supGroup:     0,
-		mountOptions: mountOptions,
+		mountOptions: params.mountOptions,
+		namespaceDir: namespaceDir,
+		directory:    name,
+		readReplica:  params.readReplica,
+		expiresAfter: params.expiresAfter,
 	}, nil
 }
 
-func (p *nfsProvisioner) validateOptions(options controller.VolumeOptions) (string, bool, string, error) {
-	gid := "none"
-	rootSquash := false
-	mountOptions := ""
-	for k, v := range options.Parameters {
+// removeVolume undoes createVolume after the volume's directory, export and
+// quota were all created, on a best-effort basis.
+func (p *nfsProvisioner) removeVolume(name, path, exportBlock string, exportID uint16, projectBlock string, projectID uint16) {
+	p.quotaer.RemoveProject(projectBlock, projectID)
+	p.exporter.RemoveExportBlock(exportBlock, exportID)
+	p.exporter.Unexport(&v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{annExportID: strconv.FormatUint(uint64(exportID), 10)},
+		},
+	})
+	fileSystem.RemoveAll(path)
+}
+
+// volumeParameters are the StorageClass parameters of a volume, as overridden
+// by its claim, parsed by validateOptions.
+type volumeParameters struct {
+	gid          string
+	rootSquash   bool
+	mountOptions string
+	preallocate  bool
+	readReplica  bool
+	expiresAfter time.Duration
+	compression  string
+}
+
+func (p *nfsProvisioner) validateOptions(options controller.VolumeOptions) (volumeParameters, error) {
+	parameters, err := p.getParameters(options)
+	if err != nil {
+		return volumeParameters{}, err
+	}
+
+	params := volumeParameters{gid: "none"}
+	for k, v := range parameters {
 		switch strings.ToLower(k) {
 		case "gid":
 			if strings.ToLower(v) == "none" {
-				gid = "none"
+				params.gid = "none"
 			} else if i, err := strconv.ParseUint(v, 10, 64); err == nil && i != 0 {
-				gid = v
+				params.gid = v
 			} else {
-				return "", false, "", fmt.Errorf("invalid value for parameter gid: %v. valid values are: 'none' or a non-zero integer", v)
+				return volumeParameters{}, fmt.Errorf("invalid value for parameter gid: %v. valid values are: 'none' or a non-zero integer", v)
 			}
 		case "rootsquash":
+			if p.consolidatedExport {
+				return volumeParameters{}, fmt.Errorf("parameter rootSquash is not supported when all volumes share a consolidated export")
+			}
 			var err error
-			rootSquash, err = strconv.ParseBool(v)
+			params.rootSquash, err = strconv.ParseBool(v)
 			if err != nil {
-				return "", false, "", fmt.Errorf("invalid value for parameter rootSquash: %v. valid values are: 'true' or 'false'", v)
+				return volumeParameters{}, fmt.Errorf("invalid value for parameter rootSquash: %v. valid values are: 'true' or 'false'", v)
 			}
 		case "mountoptions":
-			mountOptions = v
+			params.mountOptions = v
+		case "preallocate":
+			var err error
+			params.preallocate, err = strconv.ParseBool(v)
+			if err != nil {
+				return volumeParameters{}, fmt.Errorf("invalid value for parameter preallocate: %v. valid values are: 'true' or 'false'", v)
+			}
+		case "readreplica":
+			if p.readReplicaServer == "" {
+				return volumeParameters{}, fmt.Errorf("parameter readReplica is not supported unless the provisioner has a read replica server")
+			}
+			var err error
+			params.readReplica, err = strconv.ParseBool(v)
+			if err != nil {
+				return volumeParameters{}, fmt.Errorf("invalid value for parameter readReplica: %v. valid values are: 'true' or 'false'", v)
+			}
+		case "expiresafter":
+			var err error
+			params.expiresAfter, err = time.ParseDuration(v)
+			if err != nil || params.expiresAfter <= 0 {
+				return volumeParameters{}, fmt.Errorf("invalid value for parameter expiresAfter: %v. valid values are positive durations like '72h'", v)
+			}
+		case "compression":
+			params.compression = strings.ToLower(v)
+			if params.compression != compressionZstd && params.compression != compressionLz4 && params.compression != compressionOff {
+				return volumeParameters{}, fmt.Errorf("invalid value for parameter compression: %v. valid values are: 'zstd', 'lz4' or 'off'", v)
+			}
 		default:
-			return "", false, "", fmt.Errorf("invalid parameter: %q", k)
+			return volumeParameters{}, fmt.Errorf("invalid parameter: %q", k)
 		}
 	}
 
@@ -309,31 +899,263 @@ func (p *nfsProvisioner) validateOptions(options controller.VolumeOptions) (stri
 	// pv.Labels MUST be set to match claim.spec.selector
 	// gid selector? with or without pv annotation?
 	if options.PVC.Spec.Selector != nil {
-		return "", false, "", fmt.Errorf("claim.Spec.Selector is not supported")
+		return volumeParameters{}, fmt.Errorf("claim.Spec.Selector is not supported")
 	}
 
-	var stat syscall.Statfs_t
-	if err := syscall.Statfs(p.exportDir, &stat); err != nil {
-		return "", false, "", fmt.Errorf("error calling statfs on %v: %v", p.exportDir, err)
+	var available int64
+	for _, root := range p.getExportRoots(options.PVC) {
+		rootAvailable, err := getAvailableBytes(root)
+		if err != nil {
+			return volumeParameters{}, err
+		}
+		if rootAvailable > available {
+			available = rootAvailable
+		}
 	}
 	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
 	requestBytes := capacity.Value()
-	available := int64(stat.Bavail) * int64(stat.Bsize)
 	if requestBytes > available {
-		return "", false, "", fmt.Errorf("insufficient available space %v bytes to satisfy claim for %v bytes", available, requestBytes)
+		return volumeParameters{}, fmt.Errorf("insufficient available space %v bytes to satisfy claim for %v bytes", available, requestBytes)
+	}
+
+	return params, nil
+}
+
+// getParameters returns the parameters of the given options' StorageClass,
+// overridden by the AnnParameterPrefix annotations of its claim. It returns an
+// error if the claim overrides a parameter not in parameterOverrides.
+func (p *nfsProvisioner) getParameters(options controller.VolumeOptions) (map[string]string, error) {
+	if options.PVC == nil {
+		return options.Parameters, nil
+	}
+	parameters := make(map[string]string, len(options.Parameters))
+	for k, v := range options.Parameters {
+		parameters[strings.ToLower(k)] = v
+	}
+	for _, parameter := range overridableParameters {
+		v, ok := options.PVC.Annotations[AnnParameterPrefix+parameter]
+		if !ok {
+			continue
+		}
+		if !p.parameterOverrides[strings.ToLower(parameter)] {
+			return nil, fmt.Errorf("parameter %s may not be overridden by claim annotation %s", parameter, AnnParameterPrefix+parameter)
+		}
+		glog.Infof("Overriding parameter %s of claim %s/%s with %q", parameter, options.PVC.Namespace, options.PVC.Name, v)
+		parameters[strings.ToLower(parameter)] = v
+	}
+	return parameters, nil
+}
+
+// ValidateParameterOverrides returns an error if any of the given names isn't
+// of a StorageClass parameter that claims may override.
+func ValidateParameterOverrides(parameterOverrides []string) error {
+	for _, name := range parameterOverrides {
+		found := false
+		for _, parameter := range overridableParameters {
+			if strings.EqualFold(name, parameter) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("parameter %q can't be overridden, valid parameters are %v", name, overridableParameters)
+		}
+	}
+	return nil
+}
+
+// getExportRoots returns the export roots a volume for the given claim may be
+// created in: the one its storage class is mapped to, if any, else all the
+// default ones.
+func (p *nfsProvisioner) getExportRoots(claim *v1.PersistentVolumeClaim) []string {
+	return p.getClassExportRoots(helper.GetPersistentVolumeClaimClass(claim))
+}
+
+// getClassExportRoots returns the export roots the volumes of the given
+// storage class may be created in.
+func (p *nfsProvisioner) getClassExportRoots(class string) []string {
+	if root, ok := p.classExportRoots[class]; ok {
+		return []string{root}
 	}
+	return p.exportRoots
+}
 
-	return gid, rootSquash, mountOptions, nil
+// getExportRoot returns the export root to create a volume for the given claim
+// in: the one its storage class is mapped to, if any, else one of the default
+// ones chosen according to the placement policy.
+func (p *nfsProvisioner) getExportRoot(claim *v1.PersistentVolumeClaim, requestBytes int64) (string, error) {
+	if root, ok := p.classExportRoots[helper.GetPersistentVolumeClaimClass(claim)]; ok {
+		return root, nil
+	}
+	return p.chooseExportRoot(requestBytes)
+}
+
+// chooseExportRoot chooses the export root to create a volume of the given
+// size in according to the placement policy, among those with enough
+// available space.
+func (p *nfsProvisioner) chooseExportRoot(requestBytes int64) (string, error) {
+	shared := p.shared()
+	shared.rootMutex.Lock()
+	defer shared.rootMutex.Unlock()
+
+	available := make([]int64, len(p.exportRoots))
+	for i, root := range p.exportRoots {
+		var err error
+		available[i], err = getAvailableBytes(root)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch p.placement {
+	case PlacementRoundRobin:
+		for j := 0; j < len(p.exportRoots); j++ {
+			i := (shared.nextRoot + j) % len(p.exportRoots)
+			if requestBytes <= available[i] {
+				shared.nextRoot = (i + 1) % len(p.exportRoots)
+				return p.exportRoots[i], nil
+			}
+		}
+	case PlacementMostFree, "":
+		best := -1
+		for i := range p.exportRoots {
+			if requestBytes <= available[i] && (best < 0 || available[i] > available[best]) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			return p.exportRoots[best], nil
+		}
+	default:
+		return "", fmt.Errorf("unknown placement %q", p.placement)
+	}
+
+	return "", fmt.Errorf("no export root of %v has enough available space to satisfy claim for %v bytes", p.exportRoots, requestBytes)
+}
+
+// getNodeAffinity returns the node affinity to stamp on a provisioned PV: the
+// given allowed topologies of its class, further restricted to the node the
+// provisioner is running on, identified by the node env, if nodeAffinity is
+// set. If the node is known it must satisfy the allowed topologies. Returns nil
+// if the PV should have no node affinity.
+func (p *nfsProvisioner) getNodeAffinity(allowedTopologies []v1.NodeSelectorTerm) (*v1.NodeAffinity, error) {
+	nodeName := os.Getenv(p.nodeEnv)
+	if p.nodeAffinity && nodeName == "" {
+		return nil, fmt.Errorf("node env %s must be set to set node affinity", p.nodeEnv)
+	}
+
+	var node *v1.Node
+	if p.nodeAffinity || (len(allowedTopologies) > 0 && nodeName != "") {
+		var err error
+		node, err = p.client.Core().Nodes().Get(nodeName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error getting node %s=%s: %v", p.nodeEnv, nodeName, err)
+		}
+	}
+
+	if node != nil && len(allowedTopologies) > 0 {
+		matches, err := nodeMatchesTerms(node, allowedTopologies)
+		if err != nil {
+			return nil, fmt.Errorf("error matching node %s against allowed topologies: %v", nodeName, err)
+		}
+		if !matches {
+			return nil, fmt.Errorf("node %s=%s does not satisfy the class's allowed topologies %v", p.nodeEnv, nodeName, allowedTopologies)
+		}
+	}
+
+	terms := make([]v1.NodeSelectorTerm, 0, len(allowedTopologies))
+	for _, term := range allowedTopologies {
+		expressions := make([]v1.NodeSelectorRequirement, len(term.MatchExpressions))
+		copy(expressions, term.MatchExpressions)
+		terms = append(terms, v1.NodeSelectorTerm{MatchExpressions: expressions})
+	}
+
+	if p.nodeAffinity {
+		nodeValue, found := node.Labels[nodeLabelKey]
+		if !found {
+			nodeValue = nodeName
+		}
+		requirement := v1.NodeSelectorRequirement{
+			Key:      nodeLabelKey,
+			Operator: v1.NodeSelectorOpIn,
+			Values:   []string{nodeValue},
+		}
+		if len(terms) == 0 {
+			terms = append(terms, v1.NodeSelectorTerm{})
+		}
+		for i := range terms {
+			terms[i].MatchExpressions = append(terms[i].MatchExpressions, requirement)
+		}
+	}
+
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	return &v1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+			NodeSelectorTerms: terms,
+		},
+	}, nil
+}
+
+// nodeMatchesTerms returns whether the given node's labels match any of the
+// given node selector terms.
+func nodeMatchesTerms(node *v1.Node, terms []v1.NodeSelectorTerm) (bool, error) {
+	for _, term := range terms {
+		selector, err := helper.NodeSelectorRequirementsAsSelector(term.MatchExpressions)
+		if err != nil {
+			return false, err
+		}
+		if selector.Matches(labels.Set(node.Labels)) {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 // getServer gets the server IP to put in a provisioned PV's spec.
 func (p *nfsProvisioner) getServer() (string, error) {
-	if p.outOfCluster {
-		if p.serverHostname != "" {
-			return p.serverHostname, nil
+	if p.serverHostname != "" {
+		glog.V(4).Infof("using server-hostname %s as NFS server IP", p.serverHostname)
+		return p.serverHostname, nil
+	}
+
+	if p.externalHost != "" {
+		glog.V(4).Infof("using external server %s as NFS server IP", p.externalHost)
+		return p.externalHost, nil
+	}
+
+	if p.serverInterface != "" {
+		ip, err := getInterfaceIP(p.serverInterface)
+		if err != nil {
+			return "", fmt.Errorf("error getting address of interface %s: %v", p.serverInterface, err)
+		}
+		glog.V(4).Infof("using interface %s address %s as NFS server IP", p.serverInterface, ip)
+		return ip, nil
+	}
+
+	if p.hostNetwork {
+		hostIP := os.Getenv(p.hostIPEnv)
+		if hostIP != "" {
+			glog.V(4).Infof("using host IP %s=%s as NFS server IP", p.hostIPEnv, hostIP)
+			return hostIP, nil
 		}
+		iface, err := getDefaultRouteInterface()
+		if err != nil {
+			return "", fmt.Errorf("host IP env %s is not set and error finding the default route interface: %v", p.hostIPEnv, err)
+		}
+		ip, err := getInterfaceIP(iface)
+		if err != nil {
+			return "", fmt.Errorf("error getting address of default route interface %s: %v", iface, err)
+		}
+		glog.V(4).Infof("using default route interface %s address %s as NFS server IP", iface, ip)
+		return ip, nil
+	}
+
+	if p.outOfCluster {
 		// TODO make this better
-		out, err := exec.Command("hostname", "-i").Output()
+		out, err := cmdRunner.Output("hostname", "-i")
 		if err != nil {
 			return "", fmt.Errorf("hostname -i failed with error: %v, output: %s", err, out)
 		}
@@ -412,12 +1234,13 @@ func (p *nfsProvisioner) getServer() (string, error) {
 	return service.Spec.ClusterIP, nil
 }
 
-// createDirectory creates the given directory in exportDir with appropriate
-// permissions and ownership according to the given gid parameter string.
-func (p *nfsProvisioner) createDirectory(directory, gid string) error {
+// createDirectory creates the given directory in the given export root with
+// appropriate permissions and ownership according to the given gid parameter
+// string.
+func (p *nfsProvisioner) createDirectory(root, directory, gid string) error {
 	// TODO quotas
-	path := path.Join(p.exportDir, directory)
-	if _, err := os.Stat(path); !os.IsNotExist(err) {
+	path := path.Join(root, directory)
+	if _, err := fileSystem.Stat(path); !os.IsNotExist(err) {
 		return fmt.Errorf("the path already exists")
 	}
 
@@ -426,22 +1249,20 @@ func (p *nfsProvisioner) createDirectory(directory, gid string) error {
 		// Execute permission is required for stat, which kubelet uses during unmount.
 		perm = os.FileMode(0071 | os.ModeSetgid)
 	}
-	if err := os.MkdirAll(path, perm); err != nil {
+	if err := fileSystem.MkdirAll(path, perm); err != nil {
 		return err
 	}
 	// Due to umask, need to chmod
-	if err := os.Chmod(path, perm); err != nil {
-		os.RemoveAll(path)
+	if err := fileSystem.Chmod(path, perm); err != nil {
+		fileSystem.RemoveAll(path)
 		return err
 	}
 
 	if gid != "none" {
 		groupID, _ := strconv.ParseUint(gid, 10, 64)
-		cmd := exec.Command("chgrp", strconv.FormatUint(groupID, 10), path)
-		out, err := cmd.CombinedOutput()
-		if err != nil {
-			os.RemoveAll(path)
-			return fmt.Errorf("chgrp failed with error: %v, output: %s", err, out)
+		if err := fileSystem.Chown(path, -1, int(groupID)); err != nil {
+			fileSystem.RemoveAll(path)
+			return fmt.Errorf("chgrp failed with error: %v", err)
 		}
 	}
 
@@ -450,8 +1271,8 @@ func (p *nfsProvisioner) createDirectory(directory, gid string) error {
 
 // createExport creates the export by adding a block to the appropriate config
 // file and exporting it
-func (p *nfsProvisioner) createExport(directory string, rootSquash bool) (string, uint16, error) {
-	path := path.Join(p.exportDir, directory)
+func (p *nfsProvisioner) createExport(root, directory string, rootSquash bool) (string, uint16, error) {
+	path := path.Join(root, directory)
 
 	block, exportID, err := p.exporter.AddExportBlock(path, rootSquash)
 	if err != nil {
@@ -467,10 +1288,70 @@ func (p *nfsProvisioner) createExport(directory string, rootSquash bool) (string
 	return block, exportID, nil
 }
 
+// testExport mounts the given exported path, from the external server if any,
+// else from this server via loopback, with the given comma-separated mount
+// options, and checks that it can be listed and, if write is set, that a file
+// can be written to and read back from it. This catches e.g. export or
+// permission misconfiguration at provision time rather than when a pod using
+// the PV fails to mount it.
+func (p *nfsProvisioner) testExport(exportedPath, mountOptions string, write bool) error {
+	server := "127.0.0.1"
+	if p.externalHost != "" {
+		server = p.externalHost
+	}
+	source := server + ":" + exportedPath
+
+	mountPoint, err := ioutil.TempDir("", "nfs-provisioner-self-test")
+	if err != nil {
+		return fmt.Errorf("error creating mount point: %v", err)
+	}
+	defer os.Remove(mountPoint)
+
+	args := []string{"-t", "nfs"}
+	if mountOptions != "" {
+		args = append(args, "-o", mountOptions)
+	}
+	args = append(args, source, mountPoint)
+	out, err := cmdRunner.CombinedOutput("mount", args...)
+	if err != nil {
+		return fmt.Errorf("mount %v failed with error: %v, output: %s", args, err, out)
+	}
+	defer func() {
+		if out, err := cmdRunner.CombinedOutput("umount", mountPoint); err != nil {
+			glog.Errorf("umount %s failed with error: %v, output: %s", mountPoint, err, out)
+		}
+	}()
+
+	if _, err := ioutil.ReadDir(mountPoint); err != nil {
+		return fmt.Errorf("error listing %s mounted at %s: %v", source, mountPoint, err)
+	}
+	if !write {
+		return nil
+	}
+
+	sentinel := path.Join(mountPoint, selfTestFile)
+	data := []byte(string(p.identity))
+	if err := ioutil.WriteFile(sentinel, data, 0600); err != nil {
+		return fmt.Errorf("error writing sentinel file to %s: %v", source, err)
+	}
+	read, err := ioutil.ReadFile(sentinel)
+	if err != nil {
+		return fmt.Errorf("error reading sentinel file from %s: %v", source, err)
+	}
+	if string(read) != string(data) {
+		return fmt.Errorf("read %q from sentinel file on %s but wrote %q", read, source, data)
+	}
+	if err := os.Remove(sentinel); err != nil {
+		return fmt.Errorf("error removing sentinel file from %s: %v", source, err)
+	}
+
+	return nil
+}
+
 // createQuota creates a quota for the directory by adding a project to
-// represent the directory and setting a quota on it
-func (p *nfsProvisioner) createQuota(directory string, capacity resource.Quantity) (string, uint16, error) {
-	path := path.Join(p.exportDir, directory)
+// represent the directory with a quota on it
+func (p *nfsProvisioner) createQuota(root, directory string, capacity resource.Quantity) (string, uint16, error) {
+	path := path.Join(root, directory)
 
 	limit := strconv.FormatInt(capacity.Value(), 10)
 
@@ -479,11 +1360,5 @@ func (p *nfsProvisioner) createQuota(directory string, capacity resource.Quantit
 		return "", 0, fmt.Errorf("error adding project for path %s: %v", path, err)
 	}
 
-	err = p.quotaer.SetQuota(projectID, path, limit)
-	if err != nil {
-		p.quotaer.RemoveProject(block, projectID)
-		return "", 0, fmt.Errorf("error setting quota for path %s: %v", path, err)
-	}
-
 	return block, projectID, nil
 }
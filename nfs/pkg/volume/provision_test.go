@@ -17,9 +17,14 @@ limitations under the License.
 package volume
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
 	"reflect"
 	"regexp"
 	"strconv"
@@ -27,13 +32,22 @@ import (
 	"sync"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/fs"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/runner"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/pkg/api/v1"
+	storage "k8s.io/client-go/pkg/apis/storage/v1"
+	storagebeta "k8s.io/client-go/pkg/apis/storage/v1beta1"
+	testclient "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 	utiltesting "k8s.io/client-go/util/testing"
 )
 
@@ -274,6 +288,24 @@ func TestValidateOptions(t *testing.T) {
 			expectedGid: "none",
 			expectError: false,
 		},
+		{
+			name: "compression parameter",
+			options: controller.VolumeOptions{
+				Parameters: map[string]string{"compression": "ZSTD"},
+				PVC:        newClaim(resource.MustParse("1Ki"), nil, nil),
+			},
+			expectedGid: "none",
+			expectError: false,
+		},
+		{
+			name: "bad compression parameter",
+			options: controller.VolumeOptions{
+				Parameters: map[string]string{"compression": "gzip"},
+				PVC:        newClaim(resource.MustParse("1Ki"), nil, nil),
+			},
+			expectedGid: "",
+			expectError: true,
+		},
 		// TODO implement options.ProvisionerSelector parsing
 		{
 			name: "non-nil selector",
@@ -297,10 +329,102 @@ func TestValidateOptions(t *testing.T) {
 	p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "")
 
 	for _, test := range tests {
-		gid, rootSquash, _, err := p.validateOptions(test.options)
+		params, err := p.validateOptions(test.options)
+
+		evaluate(t, test.name, test.expectError, err, test.expectedGid, params.gid, "gid")
+		evaluate(t, test.name, test.expectError, err, test.expectedRootSquash, params.rootSquash, "root squash")
+	}
+}
+
+func TestGetParameters(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name               string
+		parameters         map[string]string
+		annotations        map[string]string
+		parameterOverrides map[string]bool
+		expectedParameters map[string]string
+		expectError        bool
+	}{
+		{
+			name:               "no annotations",
+			parameters:         map[string]string{"gid": "1001", "rootSquash": "true"},
+			parameterOverrides: map[string]bool{"gid": true},
+			expectedParameters: map[string]string{"gid": "1001", "rootsquash": "true"},
+		},
+		{
+			name:               "allowed override",
+			parameters:         map[string]string{"gid": "1001", "rootSquash": "true"},
+			annotations:        map[string]string{"nfs-provisioner/gid": "2002"},
+			parameterOverrides: map[string]bool{"gid": true},
+			expectedParameters: map[string]string{"gid": "2002", "rootsquash": "true"},
+		},
+		{
+			name:               "allowed override of parameter the class doesn't set",
+			annotations:        map[string]string{"nfs-provisioner/mountOptions": "vers=4.1"},
+			parameterOverrides: map[string]bool{"mountoptions": true},
+			expectedParameters: map[string]string{"mountoptions": "vers=4.1"},
+		},
+		{
+			name:               "disallowed override",
+			parameters:         map[string]string{"rootSquash": "true"},
+			annotations:        map[string]string{"nfs-provisioner/rootSquash": "false"},
+			parameterOverrides: map[string]bool{"gid": true},
+			expectError:        true,
+		},
+		{
+			name:        "no overrides allowed",
+			annotations: map[string]string{"nfs-provisioner/gid": "2002"},
+			expectError: true,
+		},
+		{
+			name:               "unrelated annotation",
+			parameters:         map[string]string{"gid": "1001"},
+			annotations:        map[string]string{"nfs-provisioner/foo": "bar"},
+			expectedParameters: map[string]string{"gid": "1001"},
+		},
+	}
+
+	client := fake.NewSimpleClientset()
+	for _, test := range tests {
+		p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "")
+		p.parameterOverrides = test.parameterOverrides
+		claim := newClaim(resource.MustParse("1Ki"), nil, nil)
+		claim.Annotations = test.annotations
+		parameters, err := p.getParameters(controller.VolumeOptions{Parameters: test.parameters, PVC: claim})
+		if test.expectError {
+			parameters = nil
+		}
+		evaluate(t, test.name, test.expectError, err, test.expectedParameters, parameters, "parameters")
+	}
+}
+
+func TestValidateParameterOverrides(t *testing.T) {
+	tests := []struct {
+		name               string
+		parameterOverrides []string
+		expectError        bool
+	}{
+		{
+			name:               "none",
+			parameterOverrides: nil,
+		},
+		{
+			name:               "valid",
+			parameterOverrides: []string{"gid", "rootsquash", "mountOptions"},
+		},
+		{
+			name:               "invalid",
+			parameterOverrides: []string{"gid", "exportDir"},
+			expectError:        true,
+		},
+	}
 
-		evaluate(t, test.name, test.expectError, err, test.expectedGid, gid, "gid")
-		evaluate(t, test.name, test.expectError, err, test.expectedRootSquash, rootSquash, "root squash")
+	for _, test := range tests {
+		err := ValidateParameterOverrides(test.parameterOverrides)
+		evaluate(t, test.name, test.expectError, err, nil, nil, "error")
 	}
 }
 
@@ -360,7 +484,7 @@ func TestCreateDirectory(t *testing.T) {
 		path := p.exportDir + test.directory
 		defer os.RemoveAll(path)
 
-		err := p.createDirectory(test.directory, test.gid)
+		err := p.createDirectory(p.exportDir, test.directory, test.gid)
 
 		var gid uint32
 		var perm os.FileMode
@@ -381,6 +505,74 @@ func TestCreateDirectory(t *testing.T) {
 	}
 }
 
+func TestCreateDirectoryFakeFilesystem(t *testing.T) {
+	tests := []struct {
+		name         string
+		gid          string
+		fail         string
+		expectedFile *fs.FakeFile
+		expectError  bool
+	}{
+		{
+			name:         "gid none",
+			gid:          "none",
+			expectedFile: &fs.FakeFile{Mode: os.ModeDir | os.ModeSetgid | 0777},
+			expectError:  false,
+		},
+		{
+			name:         "gid 1001",
+			gid:          "1001",
+			expectedFile: &fs.FakeFile{Mode: os.ModeDir | os.ModeSetgid | 0071, GID: 1001},
+			expectError:  false,
+		},
+		{
+			name:         "chmod fails, rolled back",
+			gid:          "none",
+			fail:         "Chmod",
+			expectedFile: nil,
+			expectError:  true,
+		},
+		{
+			name:         "chown fails, rolled back",
+			gid:          "1001",
+			fail:         "Chown",
+			expectedFile: nil,
+			expectError:  true,
+		},
+	}
+
+	defer func(old fs.Filesystem) { fileSystem = old }(fileSystem)
+	for _, test := range tests {
+		fakeFs := fs.NewFake("/export")
+		fail := test.fail
+		fakeFs.Fail = func(op, path string) error {
+			if op == fail {
+				return errors.New("operation not permitted")
+			}
+			return nil
+		}
+		fileSystem = fakeFs
+
+		client := fake.NewSimpleClientset()
+		p := newNFSProvisionerInternal("/export/", client, false, &testExporter{}, newDummyQuotaer(), "")
+
+		err := p.createDirectory(p.exportDir, "pvc-1", test.gid)
+		if err != nil && !test.expectError {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("unexpected error creating directory: %v", err)
+		} else if err == nil && test.expectError {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected error creating directory but got none")
+		}
+
+		got := fakeFs.Get("/export/pvc-1")
+		if !reflect.DeepEqual(test.expectedFile, got) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected directory %+v but got %+v", test.expectedFile, got)
+		}
+	}
+}
+
 func TestAddToRemoveFromFile(t *testing.T) {
 	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
 	defer os.RemoveAll(tmpDir)
@@ -408,6 +600,46 @@ func TestAddToRemoveFromFile(t *testing.T) {
 	}
 }
 
+func TestExportTable(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	block1 := "\n/export/pvc-1 *(rw,insecure,no_root_squash,fsid=1)\n"
+	block2 := "\n/export/pvc-2 *(rw,insecure,no_root_squash,fsid=2)\n"
+	block3 := "\n/export/pvc-3 *(rw,insecure,no_root_squash,fsid=3)\n"
+
+	// A crash left a removal and an addition in the journal, and a last
+	// record cut short
+	conf := tmpDir + "/exports"
+	ioutil.WriteFile(conf, []byte(block1+block2), 0644)
+	ioutil.WriteFile(conf+journalExt, []byte(`{"op":"remove","block":"`+strings.Replace(block1, "\n", `\n`, -1)+`"}`+"\n"+`{"op":"add","block":"`+strings.Replace(block3, "\n", `\n`, -1)+`"}`+"\n"+`{"op":"add","blo`), 0600)
+
+	table, err := newExportTable(conf, &sync.Mutex{})
+	if err != nil {
+		t.Fatalf("unexpected error loading table: %v", err)
+	}
+	read, _ := ioutil.ReadFile(conf)
+	evaluate(t, "redo journal", false, nil, block2+block3, string(read), "config")
+	if _, err := os.Stat(conf + journalExt); !os.IsNotExist(err) {
+		t.Errorf("redo journal: expected journal to be cleared")
+	}
+
+	// The config was replaced, e.g. by a restart, and a block is added
+	ioutil.WriteFile(conf, []byte{}, 0644)
+	err = table.add(block1)
+	read, _ = ioutil.ReadFile(conf)
+	evaluate(t, "add", false, err, block2+block3+block1, string(read), "config")
+
+	err = table.remove(block2)
+	read, _ = ioutil.ReadFile(conf)
+	evaluate(t, "remove", false, err, block3+block1, string(read), "config")
+	fi, err := os.Stat(conf)
+	evaluate(t, "remove", false, err, os.FileMode(0644), fi.Mode().Perm(), "config permissions")
+	if _, err := os.Stat(conf + journalExt); !os.IsNotExist(err) {
+		t.Errorf("remove: expected journal to be cleared")
+	}
+}
+
 func TestGetExistingIDs(t *testing.T) {
 	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
 	defer os.RemoveAll(tmpDir)
@@ -479,7 +711,9 @@ func TestGetServer(t *testing.T) {
 		service        string
 		namespace      string
 		node           string
+		hostIP         string
 		serverHostname string
+		hostNetwork    bool
 		outOfCluster   bool
 		expectedServer string
 		expectError    bool
@@ -597,14 +831,28 @@ func TestGetServer(t *testing.T) {
 			expectError:    false,
 		},
 		{
-			name:           "server-hostname is ignored, valid node",
+			name:           "server-hostname takes precedence over valid node",
 			objs:           []runtime.Object{},
 			podIP:          "2.2.2.2",
 			service:        "",
 			namespace:      "",
 			node:           "127.0.0.1",
 			serverHostname: "foo",
-			expectedServer: "127.0.0.1",
+			expectedServer: "foo",
+			expectError:    false,
+		},
+		{
+			name: "server-hostname takes precedence over valid service",
+			objs: []runtime.Object{
+				newService("foo", "1.1.1.1"),
+				newEndpoints("foo", []string{"2.2.2.2"}, []endpointPort{{2049, v1.ProtocolTCP}, {20048, v1.ProtocolTCP}, {111, v1.ProtocolUDP}, {111, v1.ProtocolTCP}}),
+			},
+			podIP:          "2.2.2.2",
+			service:        "foo",
+			namespace:      "default",
+			node:           "",
+			serverHostname: "nfs.example.com",
+			expectedServer: "nfs.example.com",
 			expectError:    false,
 		},
 		{
@@ -619,6 +867,42 @@ func TestGetServer(t *testing.T) {
 			expectedServer: "foo",
 			expectError:    false,
 		},
+		{
+			name:           "host network, host IP takes precedence over node",
+			objs:           []runtime.Object{},
+			podIP:          "2.2.2.2",
+			service:        "",
+			namespace:      "",
+			node:           "127.0.0.1",
+			hostIP:         "3.3.3.3",
+			hostNetwork:    true,
+			expectedServer: "3.3.3.3",
+			expectError:    false,
+		},
+		{
+			name:           "host IP is ignored without host network",
+			objs:           []runtime.Object{},
+			podIP:          "2.2.2.2",
+			service:        "",
+			namespace:      "",
+			node:           "127.0.0.1",
+			hostIP:         "3.3.3.3",
+			expectedServer: "127.0.0.1",
+			expectError:    false,
+		},
+		{
+			name:           "server-hostname takes precedence over host network",
+			objs:           []runtime.Object{},
+			podIP:          "2.2.2.2",
+			service:        "",
+			namespace:      "",
+			node:           "",
+			hostIP:         "3.3.3.3",
+			serverHostname: "foo",
+			hostNetwork:    true,
+			expectedServer: "foo",
+			expectError:    false,
+		},
 	}
 	for _, test := range tests {
 		if test.podIP != "" {
@@ -633,9 +917,13 @@ func TestGetServer(t *testing.T) {
 		if test.node != "" {
 			os.Setenv(nodeEnv, test.node)
 		}
+		if test.hostIP != "" {
+			os.Setenv(hostIPEnv, test.hostIP)
+		}
 
 		client := fake.NewSimpleClientset(test.objs...)
 		p := newNFSProvisionerInternal(tmpDir+"/", client, test.outOfCluster, &testExporter{}, newDummyQuotaer(), test.serverHostname)
+		p.hostNetwork = test.hostNetwork
 
 		server, err := p.getServer()
 
@@ -645,89 +933,3320 @@ func TestGetServer(t *testing.T) {
 		os.Unsetenv(serviceEnv)
 		os.Unsetenv(namespaceEnv)
 		os.Unsetenv(nodeEnv)
+		os.Unsetenv(hostIPEnv)
 	}
 }
 
-func newClaim(capacity resource.Quantity, accessmodes []v1.PersistentVolumeAccessMode, selector *metav1.LabelSelector) *v1.PersistentVolumeClaim {
-	claim := &v1.PersistentVolumeClaim{
-		ObjectMeta: metav1.ObjectMeta{},
-		Spec: v1.PersistentVolumeClaimSpec{
-			AccessModes: accessmodes,
-			Resources: v1.ResourceRequirements{
-				Requests: v1.ResourceList{
-					v1.ResourceName(v1.ResourceStorage): capacity,
-				},
-			},
-			Selector: selector,
-		},
-		Status: v1.PersistentVolumeClaimStatus{},
-	}
-	return claim
-}
+func TestGetNodeAffinity(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
 
-func newService(name, clusterIP string) *v1.Service {
-	return &v1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: "default",
+	zoneA := v1.NodeSelectorTerm{
+		MatchExpressions: []v1.NodeSelectorRequirement{
+			{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"a"}},
 		},
-		Spec: v1.ServiceSpec{
-			ClusterIP: clusterIP,
+	}
+	zoneB := v1.NodeSelectorTerm{
+		MatchExpressions: []v1.NodeSelectorRequirement{
+			{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"b"}},
 		},
 	}
-}
-
-type endpointPort struct {
-	port     int32
-	protocol v1.Protocol
-}
+	host1 := v1.NodeSelectorRequirement{Key: nodeLabelKey, Operator: v1.NodeSelectorOpIn, Values: []string{"host-1"}}
+	node1 := v1.NodeSelectorRequirement{Key: nodeLabelKey, Operator: v1.NodeSelectorOpIn, Values: []string{"node-1"}}
 
-func newEndpoints(name string, ips []string, ports []endpointPort) *v1.Endpoints {
-	epAddresses := []v1.EndpointAddress{}
-	for _, ip := range ips {
-		epAddresses = append(epAddresses, v1.EndpointAddress{IP: ip, Hostname: "", NodeName: nil, TargetRef: nil})
-	}
-	epPorts := []v1.EndpointPort{}
-	for i, port := range ports {
-		epPorts = append(epPorts, v1.EndpointPort{Name: strconv.Itoa(i), Port: port.port, Protocol: port.protocol})
-	}
-	return &v1.Endpoints{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: "default",
+	tests := []struct {
+		name              string
+		objs              []runtime.Object
+		node              string
+		nodeAffinity      bool
+		allowedTopologies []v1.NodeSelectorTerm
+		expectedTerms     []v1.NodeSelectorTerm
+		expectError       bool
+	}{
+		{
+			name:          "no node affinity, no allowed topologies",
+			objs:          []runtime.Object{newNode("node-1", map[string]string{nodeLabelKey: "host-1"})},
+			node:          "node-1",
+			expectedTerms: nil,
+			expectError:   false,
 		},
-		Subsets: []v1.EndpointSubset{
-			{
-				Addresses:         epAddresses,
-				NotReadyAddresses: []v1.EndpointAddress{},
-				Ports:             epPorts,
+		{
+			name:          "node with hostname label",
+			objs:          []runtime.Object{newNode("node-1", map[string]string{nodeLabelKey: "host-1"})},
+			node:          "node-1",
+			nodeAffinity:  true,
+			expectedTerms: []v1.NodeSelectorTerm{{MatchExpressions: []v1.NodeSelectorRequirement{host1}}},
+			expectError:   false,
+		},
+		{
+			name:          "node without hostname label, should use node name",
+			objs:          []runtime.Object{newNode("node-1", nil)},
+			node:          "node-1",
+			nodeAffinity:  true,
+			expectedTerms: []v1.NodeSelectorTerm{{MatchExpressions: []v1.NodeSelectorRequirement{node1}}},
+			expectError:   false,
+		},
+		{
+			name:          "no node env",
+			objs:          []runtime.Object{newNode("node-1", nil)},
+			node:          "",
+			nodeAffinity:  true,
+			expectedTerms: nil,
+			expectError:   true,
+		},
+		{
+			name:          "node doesn't exist",
+			objs:          []runtime.Object{},
+			node:          "node-1",
+			nodeAffinity:  true,
+			expectedTerms: nil,
+			expectError:   true,
+		},
+		{
+			name:              "allowed topologies, no node env",
+			objs:              []runtime.Object{},
+			node:              "",
+			allowedTopologies: []v1.NodeSelectorTerm{zoneA, zoneB},
+			expectedTerms:     []v1.NodeSelectorTerm{zoneA, zoneB},
+			expectError:       false,
+		},
+		{
+			name:              "allowed topologies, node in allowed zone",
+			objs:              []runtime.Object{newNode("node-1", map[string]string{"zone": "b"})},
+			node:              "node-1",
+			allowedTopologies: []v1.NodeSelectorTerm{zoneA, zoneB},
+			expectedTerms:     []v1.NodeSelectorTerm{zoneA, zoneB},
+			expectError:       false,
+		},
+		{
+			name:              "allowed topologies, node in other zone",
+			objs:              []runtime.Object{newNode("node-1", map[string]string{"zone": "c"})},
+			node:              "node-1",
+			allowedTopologies: []v1.NodeSelectorTerm{zoneA, zoneB},
+			expectedTerms:     nil,
+			expectError:       true,
+		},
+		{
+			name:              "allowed topologies and node affinity",
+			objs:              []runtime.Object{newNode("node-1", map[string]string{"zone": "a", nodeLabelKey: "host-1"})},
+			node:              "node-1",
+			nodeAffinity:      true,
+			allowedTopologies: []v1.NodeSelectorTerm{zoneA},
+			expectedTerms: []v1.NodeSelectorTerm{
+				{MatchExpressions: []v1.NodeSelectorRequirement{zoneA.MatchExpressions[0], host1}},
 			},
+			expectError: false,
 		},
 	}
-}
-
-type testExporter struct {
-	config string
-}
+	for _, test := range tests {
+		if test.node != "" {
+			os.Setenv(nodeEnv, test.node)
+		}
 
-var _ exporter = &testExporter{}
+		client := fake.NewSimpleClientset(test.objs...)
+		p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "")
+		p.nodeAffinity = test.nodeAffinity
 
-func (e *testExporter) AddExportBlock(path string, _ bool) (string, uint16, error) {
-	return "\nExport_Id = 0;\n", 0, nil
-}
+		affinity, err := p.getNodeAffinity(test.allowedTopologies)
 
-func (e *testExporter) RemoveExportBlock(block string, exportID uint16) error {
-	return nil
-}
+		var terms []v1.NodeSelectorTerm
+		if affinity != nil {
+			terms = affinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+		}
+		evaluate(t, test.name, test.expectError, err, test.expectedTerms, terms, "node affinity terms")
 
-func (e *testExporter) Export(path string) error {
-	if strings.Contains(path, "FAIL_TO_EXPORT_ME") {
-		return errors.New("fake error")
+		os.Unsetenv(nodeEnv)
 	}
-	return nil
 }
 
-func (e *testExporter) Unexport(volume *v1.PersistentVolume) error {
+func TestShouldProvision(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name           string
+		nodeAffinity   bool
+		node           string
+		selectedNode   string
+		skip           string
+		healthErr      error
+		expectedShould bool
+	}{
+		{
+			name:           "skipped",
+			nodeAffinity:   false,
+			node:           "node-1",
+			selectedNode:   "",
+			skip:           "true",
+			expectedShould: false,
+		},
+		{
+			name:           "skip false",
+			nodeAffinity:   false,
+			node:           "node-1",
+			selectedNode:   "",
+			skip:           "false",
+			expectedShould: true,
+		},
+		{
+			name:           "node affinity, selected this node but skipped",
+			nodeAffinity:   true,
+			node:           "node-1",
+			selectedNode:   "node-1",
+			skip:           "true",
+			expectedShould: false,
+		},
+		{
+			name:           "unhealthy",
+			nodeAffinity:   false,
+			node:           "node-1",
+			selectedNode:   "",
+			healthErr:      errors.New("probe timed out"),
+			expectedShould: false,
+		},
+		{
+			name:           "no node affinity, selected other node",
+			nodeAffinity:   false,
+			node:           "node-1",
+			selectedNode:   "node-2",
+			expectedShould: true,
+		},
+		{
+			name:           "node affinity, no selected node",
+			nodeAffinity:   true,
+			node:           "node-1",
+			selectedNode:   "",
+			expectedShould: true,
+		},
+		{
+			name:           "node affinity, selected this node",
+			nodeAffinity:   true,
+			node:           "node-1",
+			selectedNode:   "node-1",
+			expectedShould: true,
+		},
+		{
+			name:           "node affinity, selected other node",
+			nodeAffinity:   true,
+			node:           "node-1",
+			selectedNode:   "node-2",
+			expectedShould: false,
+		},
+	}
+	for _, test := range tests {
+		os.Setenv(nodeEnv, test.node)
+
+		client := fake.NewSimpleClientset()
+		p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "")
+		p.nodeAffinity = test.nodeAffinity
+		p.healthErr = test.healthErr
+
+		claim := newClaim(resource.MustParse("1Ki"), nil, nil)
+		claim.Annotations = map[string]string{}
+		if test.selectedNode != "" {
+			claim.Annotations[annSelectedNode] = test.selectedNode
+		}
+		if test.skip != "" {
+			claim.Annotations[AnnSkip] = test.skip
+		}
+
+		should := p.ShouldProvision(claim)
+
+		evaluate(t, test.name, false, nil, test.expectedShould, should, "should provision")
+
+		os.Unsetenv(nodeEnv)
+	}
+}
+
+func TestGetDefaultRouteInterface(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name          string
+		routes        string
+		expectedIface string
+		expectError   bool
+	}{
+		{
+			name: "default route on eth1",
+			routes: "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+				"eth0\t0011A8C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\t0\t0\t0\n" +
+				"eth1\t00000000\t0101A8C0\t0003\t0\t0\t0\t00000000\t0\t0\t0\n",
+			expectedIface: "eth1",
+			expectError:   false,
+		},
+		{
+			name: "no default route",
+			routes: "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+				"eth0\t0011A8C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\t0\t0\t0\n",
+			expectedIface: "",
+			expectError:   true,
+		},
+	}
+
+	defer func(old string) { procNetRoute = old }(procNetRoute)
+	for i, test := range tests {
+		procNetRoute = tmpDir + "/route-" + strconv.Itoa(i)
+		err := ioutil.WriteFile(procNetRoute, []byte(test.routes), 0644)
+		if err != nil {
+			t.Errorf("Error writing file %s: %v", procNetRoute, err)
+		}
+
+		iface, err := getDefaultRouteInterface()
+
+		evaluate(t, test.name, test.expectError, err, test.expectedIface, iface, "interface")
+	}
+}
+
+func TestChooseExportRoot(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	available := map[string]int64{
+		"/export": 100,
+		"/disk1":  300,
+		"/disk2":  200,
+	}
+	defer func(old func(string) (int64, error)) { getAvailableBytes = old }(getAvailableBytes)
+	getAvailableBytes = func(path string) (int64, error) {
+		return available[path], nil
+	}
+
+	tests := []struct {
+		name          string
+		placement     string
+		requests      []int64
+		expectedRoots []string
+		expectError   bool
+	}{
+		{
+			name:          "most free",
+			placement:     PlacementMostFree,
+			requests:      []int64{50, 250},
+			expectedRoots: []string{"/disk1", "/disk1"},
+			expectError:   false,
+		},
+		{
+			name:          "round robin",
+			placement:     PlacementRoundRobin,
+			requests:      []int64{50, 50, 50, 50},
+			expectedRoots: []string{"/export", "/disk1", "/disk2", "/export"},
+			expectError:   false,
+		},
+		{
+			name:          "round robin skips roots without enough space",
+			placement:     PlacementRoundRobin,
+			requests:      []int64{150, 150, 250},
+			expectedRoots: []string{"/disk1", "/disk2", "/disk1"},
+			expectError:   false,
+		},
+		{
+			name:          "no root with enough space",
+			placement:     PlacementMostFree,
+			requests:      []int64{400},
+			expectedRoots: nil,
+			expectError:   true,
+		},
+		{
+			name:          "unknown placement",
+			placement:     "foo",
+			requests:      []int64{50},
+			expectedRoots: nil,
+			expectError:   true,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset()
+		p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "")
+		p.exportRoots = []string{"/export", "/disk1", "/disk2"}
+		p.placement = test.placement
+
+		var roots []string
+		var err error
+		for _, request := range test.requests {
+			var root string
+			root, err = p.chooseExportRoot(request)
+			if err != nil {
+				break
+			}
+			roots = append(roots, root)
+		}
+
+		evaluate(t, test.name, test.expectError, err, test.expectedRoots, roots, "export roots")
+	}
+}
+
+func TestGetStorageCapacities(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	defer func(old func(string) (int64, error)) { getAvailableBytes = old }(getAvailableBytes)
+	getAvailableBytes = func(path string) (int64, error) {
+		switch path {
+		case "/export":
+			return 1024, nil
+		case "/fast":
+			return 2048, nil
+		}
+		return 0, errors.New("fake error")
+	}
+
+	client := fake.NewSimpleClientset()
+	p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "")
+	p.exportRoots = []string{"/export", "/disk1"}
+	p.classExportRoots = map[string]string{"fast": "/fast"}
+
+	classes := []storage.StorageClass{
+		{ObjectMeta: metav1.ObjectMeta{Name: "default"}, Provisioner: "example.com/nfs"},
+		{ObjectMeta: metav1.ObjectMeta{Name: "fast"}, Provisioner: "example.com/nfs"},
+		{ObjectMeta: metav1.ObjectMeta{Name: "other"}, Provisioner: "example.com/other"},
+	}
+	topology := &metav1.LabelSelector{MatchLabels: map[string]string{nodeLabelKey: "node-1"}}
+	capacities := p.getStorageCapacities(classes, "example.com/nfs", topology)
+
+	expected := map[string]string{
+		p.getStorageCapacityName("default", "/export"): "1Ki",
+		p.getStorageCapacityName("default", "/disk1"):  "",
+		p.getStorageCapacityName("fast", "/fast"):      "2Ki",
+	}
+	got := map[string]string{}
+	for name, capacity := range capacities {
+		got[name] = ""
+		if capacity != nil {
+			got[name] = capacity.Capacity.String()
+			if capacity.NodeTopology != topology || capacity.Labels[capacityIdentityLabel] != string(p.identity) {
+				t.Errorf("expected storage capacity %q to have topology %v and identity %s but got %v and %v", name, topology, p.identity, capacity.NodeTopology, capacity.Labels)
+			}
+		}
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected storage capacities %v but got %v", expected, got)
+	}
+	if name := p.getStorageCapacityName("default", "/export"); !strings.HasPrefix(name, "default-") || name == p.getStorageCapacityName("default", "/disk1") {
+		t.Errorf("expected distinct storage capacity names prefixed with the class but got %q", name)
+	}
+}
+
+func TestGetExportRoot(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	defer func(old func(string) (int64, error)) { getAvailableBytes = old }(getAvailableBytes)
+	getAvailableBytes = func(path string) (int64, error) {
+		return 100, nil
+	}
+
+	tests := []struct {
+		name         string
+		class        string
+		expectedRoot string
+		expectError  bool
+	}{
+		{
+			name:         "mapped class",
+			class:        "fast",
+			expectedRoot: "/export-fast",
+			expectError:  false,
+		},
+		{
+			name:         "unmapped class",
+			class:        "slow",
+			expectedRoot: tmpDir + "/",
+			expectError:  false,
+		},
+		{
+			name:         "no class",
+			class:        "",
+			expectedRoot: tmpDir + "/",
+			expectError:  false,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset()
+		p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "")
+		p.classExportRoots = map[string]string{"fast": "/export-fast"}
+
+		claim := newClaim(resource.MustParse("1Ki"), nil, nil)
+		if test.class != "" {
+			claim.Annotations = map[string]string{v1.BetaStorageClassAnnotation: test.class}
+		}
+		root, err := p.getExportRoot(claim, 50)
+
+		evaluate(t, test.name, test.expectError, err, test.expectedRoot, root, "export root")
+	}
+}
+
+func TestClaimInUse(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name          string
+		objs          []runtime.Object
+		claimRef      *v1.ObjectReference
+		expectedInUse bool
+	}{
+		{
+			name:          "running pod uses claim",
+			objs:          []runtime.Object{newClaimPod("pod-1", "claim-1", v1.PodRunning)},
+			claimRef:      &v1.ObjectReference{Namespace: "default", Name: "claim-1"},
+			expectedInUse: true,
+		},
+		{
+			name:          "succeeded pod uses claim",
+			objs:          []runtime.Object{newClaimPod("pod-1", "claim-1", v1.PodSucceeded)},
+			claimRef:      &v1.ObjectReference{Namespace: "default", Name: "claim-1"},
+			expectedInUse: false,
+		},
+		{
+			name:          "running pod uses other claim",
+			objs:          []runtime.Object{newClaimPod("pod-1", "claim-2", v1.PodRunning)},
+			claimRef:      &v1.ObjectReference{Namespace: "default", Name: "claim-1"},
+			expectedInUse: false,
+		},
+		{
+			name:          "unbound volume",
+			objs:          []runtime.Object{newClaimPod("pod-1", "claim-1", v1.PodRunning)},
+			claimRef:      nil,
+			expectedInUse: false,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset(test.objs...)
+		p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "")
+		volume := &v1.PersistentVolume{Spec: v1.PersistentVolumeSpec{ClaimRef: test.claimRef}}
+
+		inUse, err := p.claimInUse(volume)
+
+		evaluate(t, test.name, false, err, test.expectedInUse, inUse, "in use")
+	}
+}
+
+func TestBlockSquashesRoot(t *testing.T) {
+	tests := []struct {
+		name     string
+		block    string
+		expected bool
+	}{
+		{
+			name:     "ganesha no root squash",
+			block:    (&ganeshaExportBlockCreator{}).CreateExportBlock("1", "/export/pvc-1", false),
+			expected: false,
+		},
+		{
+			name:     "ganesha root squash",
+			block:    (&ganeshaExportBlockCreator{}).CreateExportBlock("1", "/export/pvc-1", true),
+			expected: true,
+		},
+		{
+			name:     "kernel no root squash",
+			block:    (&kernelExportBlockCreator{}).CreateExportBlock("1", "/export/pvc-1", false),
+			expected: false,
+		},
+		{
+			name:     "kernel root squash",
+			block:    (&kernelExportBlockCreator{}).CreateExportBlock("1", "/export/pvc-1", true),
+			expected: true,
+		},
+	}
+	for _, test := range tests {
+		squash := blockSquashesRoot(test.block)
+
+		evaluate(t, test.name, false, nil, test.expected, squash, "root squash")
+	}
+}
+
+func TestParseExternalServer(t *testing.T) {
+	tests := []struct {
+		name           string
+		externalServer string
+		expectedHost   string
+		expectedPath   string
+		expectError    bool
+	}{
+		{
+			name:           "host and path",
+			externalServer: "filer.example.com:/vol/k8s",
+			expectedHost:   "filer.example.com",
+			expectedPath:   "/vol/k8s",
+			expectError:    false,
+		},
+		{
+			name:           "ip and root path",
+			externalServer: "1.1.1.1:/",
+			expectedHost:   "1.1.1.1",
+			expectedPath:   "/",
+			expectError:    false,
+		},
+		{
+			name:           "no path",
+			externalServer: "filer.example.com",
+			expectError:    true,
+		},
+		{
+			name:           "relative path",
+			externalServer: "filer.example.com:vol/k8s",
+			expectError:    true,
+		},
+		{
+			name:           "no host",
+			externalServer: ":/vol/k8s",
+			expectError:    true,
+		},
+	}
+	for _, test := range tests {
+		host, path, err := ParseExternalServer(test.externalServer)
+
+		evaluate(t, test.name, test.expectError, err, test.expectedHost, host, "host")
+		evaluate(t, test.name, test.expectError, err, test.expectedPath, path, "path")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name        string
+		exportDir   string
+		expectError bool
+	}{
+		{
+			name:        "writable export dir",
+			exportDir:   tmpDir,
+			expectError: false,
+		},
+		{
+			name:        "missing export dir",
+			exportDir:   tmpDir + "/missing",
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset()
+		p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "")
+		p.exportDir = test.exportDir
+
+		err := p.probe(time.Second)
+
+		evaluate(t, test.name, test.expectError, err, nil, nil, "probe")
+		if _, statErr := os.Stat(path.Join(test.exportDir, healthProbeFile)); !os.IsNotExist(statErr) {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected probe file to be removed")
+		}
+	}
+}
+
+func TestEnsureConsolidatedExport(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name           string
+		ebc            exportBlockCreator
+		config         string
+		expectedConfig string
+	}{
+		{
+			name:           "kernel, empty config",
+			ebc:            &kernelExportBlockCreator{},
+			config:         "",
+			expectedConfig: "\n/export *(rw,insecure,no_root_squash,fsid=1)\n",
+		},
+		{
+			name:           "kernel, config with only volume exports",
+			ebc:            &kernelExportBlockCreator{},
+			config:         "\n/export/pvc-1 *(rw,insecure,no_root_squash,fsid=1)\n",
+			expectedConfig: "\n/export/pvc-1 *(rw,insecure,no_root_squash,fsid=1)\n\n/export *(rw,insecure,no_root_squash,fsid=2)\n",
+		},
+		{
+			name:           "kernel, config already exports",
+			ebc:            &kernelExportBlockCreator{},
+			config:         "\n/export *(rw,insecure,no_root_squash,fsid=1)\n",
+			expectedConfig: "\n/export *(rw,insecure,no_root_squash,fsid=1)\n",
+		},
+		{
+			name:           "ganesha, config already exports",
+			ebc:            &ganeshaExportBlockCreator{},
+			config:         (&ganeshaExportBlockCreator{}).CreateExportBlock("1", "/export", false),
+			expectedConfig: (&ganeshaExportBlockCreator{}).CreateExportBlock("1", "/export", false),
+		},
+	}
+	for i, test := range tests {
+		conf := tmpDir + "/config-" + strconv.Itoa(i)
+		err := ioutil.WriteFile(conf, []byte(test.config), 0600)
+		if err != nil {
+			t.Errorf("Error writing file %s: %v", conf, err)
+		}
+		exp := &genericTestExporter{*newGenericExporter(test.ebc, conf, regexp.MustCompile("fsid=([0-9]+)"))}
+
+		err = ensureConsolidatedExport(exp, conf, "/export/")
+
+		read, _ := ioutil.ReadFile(conf)
+		evaluate(t, test.name, false, err, test.expectedConfig, string(read), "config")
+	}
+}
+
+func TestKernelExporterExportsDir(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	conf := tmpDir + "/exports"
+	err := ioutil.WriteFile(conf, []byte("\n/export/pvc-0 *(rw,insecure,no_root_squash,fsid=1)\n"), 0600)
+	if err != nil {
+		t.Errorf("Error writing file %s: %v", conf, err)
+	}
+	exportsDir := tmpDir + "/exports.d"
+	err = os.MkdirAll(exportsDir, 0755)
+	if err != nil {
+		t.Errorf("Error creating dir %s: %v", exportsDir, err)
+	}
+	err = ioutil.WriteFile(exportsDir+"/export-pvc-1.exports", []byte("\n/export/pvc-1 *(rw,insecure,no_root_squash,fsid=2)\n"), 0644)
+	if err != nil {
+		t.Errorf("Error writing file %s: %v", exportsDir, err)
+	}
+
+	re := regexp.MustCompile("fsid=([0-9]+)")
+	e := &kernelExporter{
+		genericExporter: *newGenericExporter(&kernelExportBlockCreator{}, conf, re),
+		exportsDir:      exportsDir,
+	}
+	e.exportIDs[2] = true
+
+	block, exportID, err := e.AddExportBlock("/export/pvc-2", false)
+	evaluate(t, "add export block", false, err, uint16(3), exportID, "export id")
+	read, _ := ioutil.ReadFile(exportsDir + "/export-pvc-2.exports")
+	evaluate(t, "add export block", false, err, block, string(read), "exports file")
+
+	err = e.RemoveExportBlock(block, exportID)
+	_, statErr := os.Stat(exportsDir + "/export-pvc-2.exports")
+	evaluate(t, "remove export block", false, err, true, os.IsNotExist(statErr), "exports file removed")
+
+	err = e.RemoveExportBlock("\n/export/pvc-0 *(rw,insecure,no_root_squash,fsid=1)\n", 1)
+	read, _ = ioutil.ReadFile(conf)
+	evaluate(t, "remove export block from config", false, err, "", string(read), "config")
+}
+
+func TestExportfsBatcher(t *testing.T) {
+	tests := []struct {
+		name         string
+		window       time.Duration
+		requests     int
+		err          error
+		expectedRuns int
+	}{
+		{
+			name:         "no window",
+			window:       0,
+			requests:     5,
+			expectedRuns: 5,
+		},
+		{
+			name:         "window",
+			window:       100 * time.Millisecond,
+			requests:     5,
+			expectedRuns: 1,
+		},
+		{
+			name:         "window, error",
+			window:       100 * time.Millisecond,
+			requests:     5,
+			err:          errors.New("fake error"),
+			expectedRuns: 1,
+		},
+	}
+	for _, test := range tests {
+		runs := 0
+		var mutex sync.Mutex
+		b := newExportfsBatcher(test.window, func() error {
+			mutex.Lock()
+			runs++
+			mutex.Unlock()
+			return test.err
+		})
+
+		errs := make(chan error, test.requests)
+		for i := 0; i < test.requests; i++ {
+			go func() {
+				errs <- b.run()
+			}()
+		}
+		for i := 0; i < test.requests; i++ {
+			err := <-errs
+			evaluate(t, test.name, test.err != nil, err, test.err, err, "error")
+		}
+
+		evaluate(t, test.name, false, nil, test.expectedRuns, runs, "exportfs runs")
+	}
+}
+
+func TestTemplateExportBlockCreator(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name          string
+		template      string
+		rootSquash    bool
+		expectedBlock string
+		expectError   bool
+	}{
+		{
+			name:          "kernel line",
+			template:      "{{.Path}} 10.0.0.0/8(rw,sync,{{.Squash}},fsid={{.ExportID}})\n",
+			rootSquash:    false,
+			expectedBlock: "\n/export/pvc-1 10.0.0.0/8(rw,sync,no_root_squash,fsid=1)\n",
+			expectError:   false,
+		},
+		{
+			name:          "kernel line, root squash",
+			template:      "{{.Path}} 10.0.0.0/8(rw,sync,{{.Squash}},fsid={{.ExportID}})",
+			rootSquash:    true,
+			expectedBlock: "\n/export/pvc-1 10.0.0.0/8(rw,sync,root_squash,fsid=1)\n",
+			expectError:   false,
+		},
+		{
+			name:          "conditional",
+			template:      "{{.Path}} *({{if .RootSquash}}ro{{else}}rw{{end}},fsid={{.ExportID}})",
+			rootSquash:    true,
+			expectedBlock: "\n/export/pvc-1 *(ro,fsid=1)\n",
+			expectError:   false,
+		},
+		{
+			name:        "bad template",
+			template:    "{{.Path",
+			expectError: true,
+		},
+		{
+			name:        "unknown field",
+			template:    "{{.Clients}}",
+			expectError: true,
+		},
+	}
+	for i, test := range tests {
+		file := tmpDir + "/template-" + strconv.Itoa(i)
+		err := ioutil.WriteFile(file, []byte(test.template), 0600)
+		if err != nil {
+			t.Errorf("Error writing file %s: %v", file, err)
+		}
+
+		var block string
+		exportTemplate, err := LoadExportTemplate(file)
+		if err == nil {
+			ebc := newTemplateExportBlockCreator(exportTemplate, &kernelExportBlockCreator{}, "root_squash")
+			block = ebc.CreateExportBlock("1", "/export/pvc-1", test.rootSquash)
+		}
+
+		evaluate(t, test.name, test.expectError, err, test.expectedBlock, block, "block")
+	}
+}
+
+func TestRepairExport(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	block := "\n" + tmpDir + "/pvc-1 *(rw,insecure,no_root_squash,fsid=7)\n"
+	tests := []struct {
+		name           string
+		config         string
+		directory      string
+		expectedConfig string
+		expectError    bool
+	}{
+		{
+			name:           "export block present",
+			config:         block,
+			directory:      "pvc-1",
+			expectedConfig: block,
+			expectError:    false,
+		},
+		{
+			name:           "export block missing",
+			config:         "",
+			directory:      "pvc-1",
+			expectedConfig: block,
+			expectError:    false,
+		},
+		{
+			name:           "directory missing",
+			config:         block,
+			directory:      "",
+			expectedConfig: block,
+			expectError:    true,
+		},
+	}
+	for i, test := range tests {
+		conf := tmpDir + "/config-" + strconv.Itoa(i)
+		err := ioutil.WriteFile(conf, []byte(test.config), 0600)
+		if err != nil {
+			t.Errorf("Error writing file %s: %v", conf, err)
+		}
+		if test.directory != "" {
+			os.MkdirAll(tmpDir+"/"+test.directory, 0755)
+		}
+
+		exp := &genericTestExporter{*newGenericExporter(&kernelExportBlockCreator{}, conf, regexp.MustCompile("fsid=([0-9]+)"))}
+		client := fake.NewSimpleClientset()
+		p := newNFSProvisionerInternal(tmpDir+"/", client, false, exp, newDummyQuotaer(), "")
+		volume := &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "pvc-1",
+				Annotations: map[string]string{
+					annExportBlock: block,
+					annExportID:    "7",
+				},
+			},
+		}
+
+		err = p.repairExport(exp, volume)
+
+		read, _ := ioutil.ReadFile(conf)
+		evaluate(t, test.name, test.expectError, err, test.expectedConfig, string(read), "config")
+		evaluate(t, test.name, test.expectError, err, true, exp.exportIDs[7] || test.expectError, "export id used")
+
+		os.RemoveAll(tmpDir + "/pvc-1")
+	}
+}
+
+func TestGetMissingExports(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name            string
+		config          string
+		fragments       map[string]string
+		live            string
+		expectedMissing []string
+	}{
+		{
+			name:            "all exported",
+			config:          "\n/export/pvc-1 *(rw,insecure,no_root_squash,fsid=1)\n",
+			live:            "# Version 1.1\n# Path Client(Flags) # IPs\n/export/pvc-1\t*(rw,insecure,no_root_squash,fsid=1)\n",
+			expectedMissing: []string{},
+		},
+		{
+			name:            "config export missing",
+			config:          "\n/export/pvc-1 *(rw,insecure,no_root_squash,fsid=1)\n\n/export/pvc-2 *(rw,insecure,no_root_squash,fsid=2)\n",
+			live:            "# Version 1.1\n# Path Client(Flags) # IPs\n/export/pvc-1\t*(rw,insecure,no_root_squash,fsid=1)\n",
+			expectedMissing: []string{"/export/pvc-2"},
+		},
+		{
+			name:            "exports dir export missing",
+			config:          "# comment\n",
+			fragments:       map[string]string{"export-pvc-3.exports": "\n/export/pvc-3 *(rw,insecure,no_root_squash,fsid=3)\n"},
+			live:            "# Version 1.1\n# Path Client(Flags) # IPs\n",
+			expectedMissing: []string{"/export/pvc-3"},
+		},
+		{
+			name:            "escaped path",
+			config:          "\n\"/export/pvc 4\" *(rw,insecure,no_root_squash,fsid=4)\n",
+			live:            "# Version 1.1\n# Path Client(Flags) # IPs\n/export/pvc\\0404\t*(rw,insecure,no_root_squash,fsid=4)\n",
+			expectedMissing: []string{},
+		},
+	}
+	defer func(old string) { procNFSExports = old }(procNFSExports)
+	for i, test := range tests {
+		dir := tmpDir + "/" + strconv.Itoa(i)
+		exportsDir := dir + "/exports.d"
+		os.MkdirAll(exportsDir, 0755)
+		conf := dir + "/exports"
+		ioutil.WriteFile(conf, []byte(test.config), 0600)
+		for name, fragment := range test.fragments {
+			ioutil.WriteFile(exportsDir+"/"+name, []byte(fragment), 0644)
+		}
+		procNFSExports = dir + "/proc-exports"
+		ioutil.WriteFile(procNFSExports, []byte(test.live), 0644)
+
+		e := &kernelExporter{
+			genericExporter: *newGenericExporter(&kernelExportBlockCreator{}, conf, regexp.MustCompile("fsid=([0-9]+)")),
+			exportsDir:      exportsDir,
+		}
+
+		missing, err := e.getMissingExports()
+
+		evaluate(t, test.name, false, err, test.expectedMissing, missing, "missing exports")
+	}
+}
+
+func TestVerifyExports(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	config := "\n/export/pvc-1 *(rw,insecure,no_root_squash,fsid=1)\n\n/export/pvc-2 *(rw,insecure,no_root_squash,fsid=2)\n"
+	allLive := "/export/pvc-1\t*(rw,insecure,no_root_squash,fsid=1)\n/export/pvc-2\t*(rw,insecure,no_root_squash,fsid=2)\n"
+	tests := []struct {
+		name             string
+		live             string
+		exportfsErr      error
+		exportfsLive     string
+		expectedMissing  []string
+		expectedCommands []string
+		expectError      bool
+	}{
+		{
+			name:             "nothing missing",
+			live:             allLive,
+			expectedMissing:  nil,
+			expectedCommands: []string{},
+		},
+		{
+			name:             "missing re-exported",
+			live:             "/export/pvc-1\t*(rw,insecure,no_root_squash,fsid=1)\n",
+			exportfsLive:     allLive,
+			expectedMissing:  []string{"/export/pvc-2"},
+			expectedCommands: []string{"exportfs -r"},
+		},
+		{
+			name:             "missing after re-export",
+			live:             "/export/pvc-1\t*(rw,insecure,no_root_squash,fsid=1)\n",
+			exportfsLive:     "/export/pvc-1\t*(rw,insecure,no_root_squash,fsid=1)\n",
+			expectedMissing:  []string{"/export/pvc-2"},
+			expectedCommands: []string{"exportfs -r"},
+			expectError:      true,
+		},
+		{
+			name:             "exportfs fails",
+			live:             "/export/pvc-1\t*(rw,insecure,no_root_squash,fsid=1)\n",
+			exportfsErr:      errors.New("exit status 1"),
+			expectedMissing:  []string{"/export/pvc-2"},
+			expectedCommands: []string{"exportfs -r"},
+			expectError:      true,
+		},
+	}
+	defer func(old string) { procNFSExports = old }(procNFSExports)
+	defer func(old runner.Runner) { cmdRunner = old }(cmdRunner)
+	for i, test := range tests {
+		dir := tmpDir + "/" + strconv.Itoa(i)
+		os.MkdirAll(dir, 0755)
+		conf := dir + "/exports"
+		ioutil.WriteFile(conf, []byte(config), 0600)
+		procNFSExports = dir + "/proc-exports"
+		ioutil.WriteFile(procNFSExports, []byte(test.live), 0644)
+
+		exportfsErr, exportfsLive := test.exportfsErr, test.exportfsLive
+		fake := &runner.Fake{
+			Run: func(name string, args ...string) ([]byte, error) {
+				if exportfsErr != nil {
+					return nil, exportfsErr
+				}
+				return nil, ioutil.WriteFile(procNFSExports, []byte(exportfsLive), 0644)
+			},
+		}
+		cmdRunner = fake
+
+		e := &kernelExporter{
+			genericExporter: *newGenericExporter(&kernelExportBlockCreator{}, conf, regexp.MustCompile("fsid=([0-9]+)")),
+		}
+
+		missing, err := e.VerifyExports()
+
+		evaluate(t, test.name, test.expectError, err, test.expectedMissing, missing, "missing exports")
+		evaluate(t, test.name, test.expectError, err, test.expectedCommands, fake.Commands(), "commands")
+	}
+}
+
+func TestGetVolumeUsages(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	defer func(old runner.Runner) { cmdRunner = old }(cmdRunner)
+	fakeRunner := &runner.Fake{
+		Run: func(name string, args ...string) ([]byte, error) {
+			return []byte("Project quota on /xfs (/dev/sdb)\n                 Blocks\nProject ID   Used   Soft   Hard Warn/Grace\n---------- --------------------------------\n#0              0      0      0  00 [------]\n#1            512      0   1024  00 [------]\n#2              8      0   2048  00 [------]\n"), nil
+		},
+	}
+	cmdRunner = fakeRunner
+	quotaer := &xfsQuotaer{
+		xfsPath:    "/xfs",
+		projectIDs: map[uint16]bool{1: true, 2: true},
+		mapMutex:   &sync.Mutex{},
+		fileMutex:  &sync.Mutex{},
+	}
+
+	client := fake.NewSimpleClientset()
+	p := newNFSProvisionerInternal(tmpDir, client, false, &testExporter{}, quotaer, "")
+	for _, v := range []struct {
+		name, provisionerID, projectID string
+	}{
+		{"pvc-1", string(p.identity), "1"},
+		{"pvc-2", "foo", "2"},
+		{"pvc-3", string(p.identity), "0"},
+	} {
+		client.Core().PersistentVolumes().Create(&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        v.name,
+				Annotations: map[string]string{annProvisionerID: v.provisionerID, annProjectID: v.projectID},
+			},
+		})
+	}
+
+	usages, err := p.getVolumeUsages()
+
+	evaluate(t, "get volume usages", false, err, map[string]int64{"pvc-1": 512 * 1024}, usages, "usages")
+	evaluate(t, "get volume usages", false, err, []string{"xfs_quota -x -c report -p -b -N -n /xfs"}, fakeRunner.Commands(), "commands")
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	rec := httptest.NewRecorder()
+	p.ServeMetrics(rec, req)
+	if metric := `nfs_provisioner_volume_used_bytes{volume="pvc-1"} 524288`; !strings.Contains(rec.Body.String(), metric+"\n") {
+		t.Errorf("expected metric %q in:\n%s", metric, rec.Body.String())
+	}
+}
+
+func TestCheckUsage(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	used := 512
+	defer func(old runner.Runner) { cmdRunner = old }(cmdRunner)
+	cmdRunner = &runner.Fake{
+		Run: func(name string, args ...string) ([]byte, error) {
+			return []byte(fmt.Sprintf("#1 %d 0 1024 00 [------]\n", used)), nil
+		},
+	}
+	quotaer := &xfsQuotaer{
+		xfsPath:    "/xfs",
+		projectIDs: map[uint16]bool{1: true},
+		mapMutex:   &sync.Mutex{},
+		fileMutex:  &sync.Mutex{},
+	}
+
+	client := fake.NewSimpleClientset()
+	p := newNFSProvisionerInternal(tmpDir, client, false, &testExporter{}, quotaer, "")
+	recorder := record.NewFakeRecorder(10)
+	p.recorderOnce.Do(func() { p.eventRecorder = recorder })
+	p.usageThresholds = []int{80, 95}
+	p.volumeThresholds = map[string]int{}
+	p.thresholdCrossings = map[int]int{}
+	client.Core().PersistentVolumeClaims(v1.NamespaceDefault).Create(&v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "claim-1", Namespace: v1.NamespaceDefault, UID: types.UID("uid-1")},
+	})
+	client.Core().PersistentVolumes().Create(&v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pvc-1",
+			Annotations: map[string]string{annProvisionerID: string(p.identity), annProjectID: "1"},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity: v1.ResourceList{v1.ResourceName(v1.ResourceStorage): resource.MustParse("600Ki")},
+			ClaimRef: &v1.ObjectReference{Name: "claim-1", Namespace: v1.NamespaceDefault, UID: types.UID("uid-1")},
+		},
+	})
+
+	tests := []struct {
+		name           string
+		used           int
+		expectedEvents int
+	}{
+		{name: "under thresholds", used: 300, expectedEvents: 0},
+		{name: "crosses first threshold", used: 512, expectedEvents: 1},
+		{name: "stays over first threshold", used: 520, expectedEvents: 0},
+		{name: "crosses second threshold", used: 590, expectedEvents: 1},
+		{name: "drops below thresholds", used: 100, expectedEvents: 0},
+		{name: "crosses first threshold again", used: 500, expectedEvents: 1},
+	}
+	for _, test := range tests {
+		used = test.used
+		p.checkUsage()
+		if events := len(recorder.Events); events != test.expectedEvents {
+			t.Errorf("test case: %s: expected %d events but got %d", test.name, test.expectedEvents, events)
+		}
+		for len(recorder.Events) > 0 {
+			if event := <-recorder.Events; !strings.HasPrefix(event, v1.EventTypeWarning+" VolumeUsageHigh") {
+				t.Errorf("test case: %s: unexpected event %q", test.name, event)
+			}
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	rec := httptest.NewRecorder()
+	p.ServeMetrics(rec, req)
+	for _, metric := range []string{
+		`nfs_provisioner_volumes_over_usage_threshold{threshold="80"} 1`,
+		`nfs_provisioner_volumes_over_usage_threshold{threshold="95"} 0`,
+		`nfs_provisioner_volume_usage_threshold_crossings_total{threshold="80"} 2`,
+		`nfs_provisioner_volume_usage_threshold_crossings_total{threshold="95"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), metric+"\n") {
+			t.Errorf("expected metric %q in:\n%s", metric, rec.Body.String())
+		}
+	}
+}
+
+func TestReportUsage(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	used := 512
+	defer func(old runner.Runner) { cmdRunner = old }(cmdRunner)
+	cmdRunner = &runner.Fake{
+		Run: func(name string, args ...string) ([]byte, error) {
+			if args[2] == "report -p -i -N -n" {
+				return []byte("#1 10 0 0 00 [------]\n"), nil
+			}
+			return []byte(fmt.Sprintf("#1 %d 0 1024 00 [------]\n", used)), nil
+		},
+	}
+	quotaer := &xfsQuotaer{
+		xfsPath:    "/xfs",
+		projectIDs: map[uint16]bool{1: true},
+		mapMutex:   &sync.Mutex{},
+		fileMutex:  &sync.Mutex{},
+	}
+
+	client := fake.NewSimpleClientset()
+	p := newNFSProvisionerInternal(tmpDir, client, false, &testExporter{}, quotaer, "")
+	client.Core().PersistentVolumes().Create(&v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pvc-1",
+			Annotations: map[string]string{annProvisionerID: string(p.identity), annProjectID: "1"},
+		},
+	})
+	client.Core().PersistentVolumes().Create(&v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pvc-2",
+			Annotations: map[string]string{annProvisionerID: string(p.identity), annProjectID: "0"},
+		},
+	})
+
+	tests := []struct {
+		name            string
+		used            int
+		expectedBytes   string
+		expectedUpdates int
+	}{
+		{name: "first report", used: 512, expectedBytes: "524288", expectedUpdates: 1},
+		{name: "unchanged", used: 512, expectedBytes: "524288", expectedUpdates: 0},
+		{name: "changed", used: 600, expectedBytes: "614400", expectedUpdates: 1},
+	}
+	for _, test := range tests {
+		used = test.used
+		client.ClearActions()
+		p.reportUsage()
+		updates := 0
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "update" {
+				updates++
+			}
+		}
+		if updates != test.expectedUpdates {
+			t.Errorf("test case: %s: expected %d updates but got %d", test.name, test.expectedUpdates, updates)
+		}
+		volume, _ := client.Core().PersistentVolumes().Get("pvc-1", metav1.GetOptions{})
+		if volume.Annotations[AnnUsedBytes] != test.expectedBytes || volume.Annotations[AnnUsedInodes] != "10" {
+			t.Errorf("test case: %s: expected used bytes %s and inodes 10 but got %s and %s", test.name, test.expectedBytes, volume.Annotations[AnnUsedBytes], volume.Annotations[AnnUsedInodes])
+		}
+		volume, _ = client.Core().PersistentVolumes().Get("pvc-2", metav1.GetOptions{})
+		if _, ok := volume.Annotations[AnnUsedBytes]; ok {
+			t.Errorf("test case: %s: expected no usage on volume without a quota", test.name)
+		}
+	}
+}
+
+func TestScrubVolumes(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	client := fake.NewSimpleClientset()
+	p := newNFSProvisionerInternal(tmpDir, client, false, &testExporter{}, newDummyQuotaer(), "")
+	recorder := record.NewFakeRecorder(10)
+	p.recorderOnce.Do(func() { p.eventRecorder = recorder })
+	for _, name := range []string{"pvc-1", "pvc-2"} {
+		client.Core().PersistentVolumes().Create(&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: map[string]string{annProvisionerID: string(p.identity), annExportRoot: tmpDir},
+			},
+		})
+	}
+	// pvc-1's data is readable, pvc-2's directory is missing
+	os.MkdirAll(path.Join(tmpDir, "pvc-1", "dir"), 0755)
+	ioutil.WriteFile(path.Join(tmpDir, "pvc-1", "dir", "file"), []byte("data"), 0644)
+
+	p.scrubVolumes(wait.NeverStop)
+	if events := len(recorder.Events); events != 1 {
+		t.Fatalf("expected 1 event but got %d", events)
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, v1.EventTypeWarning+" ScrubFailed Scrub found 1 unreadable") || !strings.Contains(event, "pvc-2") {
+		t.Errorf("unexpected event %q", event)
+	}
+}
+
+func TestSetCompression(t *testing.T) {
+	tests := []struct {
+		name             string
+		fstype           string
+		zfsCompression   string
+		compression      string
+		expectedCommands []string
+		expectedEvents   int
+	}{
+		{
+			name:             "btrfs zstd",
+			fstype:           "btrfs",
+			compression:      "zstd",
+			expectedCommands: []string{"stat -f -c %T /export", "btrfs property set /export/pvc-1 compression zstd"},
+		},
+		{
+			name:             "btrfs off",
+			fstype:           "btrfs",
+			compression:      "off",
+			expectedCommands: []string{"stat -f -c %T /export", "btrfs property set /export/pvc-1 compression none"},
+		},
+		{
+			name:             "btrfs lz4 is ignored",
+			fstype:           "btrfs",
+			compression:      "lz4",
+			expectedCommands: []string{"stat -f -c %T /export"},
+			expectedEvents:   1,
+		},
+		{
+			name:             "zfs dataset with the compression",
+			fstype:           "zfs",
+			zfsCompression:   "zstd-3",
+			compression:      "zstd",
+			expectedCommands: []string{"stat -f -c %T /export", "zfs get -H -o value compression /export"},
+		},
+		{
+			name:             "zfs dataset without the compression is ignored",
+			fstype:           "zfs",
+			zfsCompression:   "off",
+			compression:      "lz4",
+			expectedCommands: []string{"stat -f -c %T /export", "zfs get -H -o value compression /export"},
+			expectedEvents:   1,
+		},
+		{
+			name:             "xfs is ignored",
+			fstype:           "xfs",
+			compression:      "zstd",
+			expectedCommands: []string{"stat -f -c %T /export"},
+			expectedEvents:   1,
+		},
+	}
+	defer func(old runner.Runner) { cmdRunner = old }(cmdRunner)
+	for _, test := range tests {
+		fakeRunner := &runner.Fake{
+			Run: func(name string, args ...string) ([]byte, error) {
+				switch name {
+				case "stat":
+					return []byte(test.fstype + "\n"), nil
+				case "zfs":
+					return []byte(test.zfsCompression + "\n"), nil
+				}
+				return nil, nil
+			},
+		}
+		cmdRunner = fakeRunner
+		p := newNFSProvisionerInternal("/export", fake.NewSimpleClientset(), false, &testExporter{}, newDummyQuotaer(), "")
+		recorder := record.NewFakeRecorder(10)
+		p.recorderOnce.Do(func() { p.eventRecorder = recorder })
+		err := p.setCompression(newClaim(resource.MustParse("1Mi"), nil, nil), "/export", "/export/pvc-1", test.compression)
+		if err != nil {
+			t.Errorf("test case: %s: unexpected error: %v", test.name, err)
+		}
+		if commands := fakeRunner.Commands(); !reflect.DeepEqual(commands, test.expectedCommands) {
+			t.Errorf("test case: %s: expected commands %v but got %v", test.name, test.expectedCommands, commands)
+		}
+		if events := len(recorder.Events); events != test.expectedEvents {
+			t.Errorf("test case: %s: expected %d events but got %d", test.name, test.expectedEvents, events)
+		}
+	}
+}
+
+func TestMigrateVolume(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	defer func(old runner.Runner) { cmdRunner = old }(cmdRunner)
+	fakeRunner := &runner.Fake{}
+	cmdRunner = fakeRunner
+	defer func(old func(string) (int64, error)) { getAvailableBytes = old }(getAvailableBytes)
+	available := int64(1024)
+	getAvailableBytes = func(path string) (int64, error) {
+		return available, nil
+	}
+
+	oldRoot, newRoot := path.Join(tmpDir, "old"), path.Join(tmpDir, "new")
+	oldPath, newPath := path.Join(oldRoot, "pvc-1"), path.Join(newRoot, "pvc-1")
+	os.MkdirAll(oldPath, 0755)
+	ioutil.WriteFile(path.Join(oldPath, "data"), []byte("data"), 0644)
+	os.MkdirAll(newRoot, 0755)
+	client := fake.NewSimpleClientset()
+	// Like the API server, reject changing the PV's source
+	source := v1.PersistentVolumeSource{NFS: &v1.NFSVolumeSource{Path: oldPath}}
+	client.PrependReactor("update", "persistentvolumes", func(action testclient.Action) (bool, runtime.Object, error) {
+		volume := action.(testclient.UpdateAction).GetObject().(*v1.PersistentVolume)
+		if !reflect.DeepEqual(volume.Spec.PersistentVolumeSource, source) {
+			return true, nil, errors.New("spec.persistentvolumesource is immutable after creation")
+		}
+		return false, nil, nil
+	})
+	exporter := &restoringTestExporter{}
+	quotaer := &recordingTestQuotaer{nextID: 1}
+	p := newNFSProvisionerInternal(oldRoot, client, false, exporter, quotaer, "")
+	p.exportRoots = append(p.exportRoots, newRoot)
+	client.Core().PersistentVolumes().Create(&v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pvc-1",
+			Annotations: map[string]string{
+				annProvisionerID: string(p.identity),
+				annExportRoot:    oldRoot,
+				annExportBlock:   "\nExport_Id = 1;\n",
+				annExportID:      "1",
+				annProjectBlock:  "\n1:" + oldPath + ":1024\n",
+				annProjectID:     "1",
+				AnnMigrateTo:     newRoot,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity:               v1.ResourceList{v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Ki")},
+			ClaimRef:               &v1.ObjectReference{Name: "claim-1", Namespace: v1.NamespaceDefault},
+			PersistentVolumeSource: source,
+		},
+	})
+	client.Core().Pods(v1.NamespaceDefault).Create(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: v1.NamespaceDefault},
+		Spec: v1.PodSpec{
+			Volumes: []v1.Volume{{VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "claim-1"}}}},
+		},
+	})
+	staging := path.Join(newRoot, migratingDir, "pvc-1")
+	rsync := "rsync -aHAX --delete " + oldPath + "/ " + staging
+
+	// While in use, the volume is only copied ahead
+	volume, _ := client.Core().PersistentVolumes().Get("pvc-1", metav1.GetOptions{})
+	if err := p.migrateVolume(volume, newRoot); err != nil {
+		t.Fatalf("unexpected error migrating volume in use: %v", err)
+	}
+	if commands := fakeRunner.Commands(); !reflect.DeepEqual(commands, []string{rsync}) {
+		t.Errorf("expected commands %v but got %v", []string{rsync}, commands)
+	}
+	volume, _ = client.Core().PersistentVolumes().Get("pvc-1", metav1.GetOptions{})
+	if volume.Annotations[annExportRoot] != oldRoot {
+		t.Errorf("expected volume in use to stay in %s but got %s", oldRoot, volume.Annotations[annExportRoot])
+	}
+	if len(exporter.removed) != 0 {
+		t.Errorf("expected volume in use to stay exported but got removed exports %v", exporter.removed)
+	}
+
+	// Once it isn't, it's quiesced; if the final copy fails, it's exported
+	// again. The space the pre-copy takes up isn't counted against it
+	available = 0
+	client.Core().Pods(v1.NamespaceDefault).Delete("pod-1", &metav1.DeleteOptions{})
+	fakeRunner.Run = func(name string, args ...string) ([]byte, error) {
+		return nil, errors.New("rsync failed")
+	}
+	if err := p.migrateVolume(volume, newRoot); err == nil {
+		t.Errorf("expected error migrating volume when the copy fails but got none")
+	}
+	if expected := []uint16{1}; !reflect.DeepEqual(exporter.removed, expected) || !reflect.DeepEqual(exporter.restored, expected) {
+		t.Errorf("expected export %v removed and restored but got removed %v and restored %v", expected, exporter.removed, exporter.restored)
+	}
+
+	// If the copy can't be bind mounted where the volume is exported, the
+	// old directory is put back and exported again
+	fakeRunner.Run = func(name string, args ...string) ([]byte, error) {
+		if name == "mount" {
+			return nil, errors.New("mount failed")
+		}
+		return nil, nil
+	}
+	if err := p.migrateVolume(volume, newRoot); err == nil {
+		t.Errorf("expected error migrating volume when the bind mount fails but got none")
+	}
+	if expected := []uint16{1, 1}; !reflect.DeepEqual(exporter.removed, expected) || !reflect.DeepEqual(exporter.restored, expected) {
+		t.Errorf("expected export %v removed and restored but got removed %v and restored %v", expected, exporter.removed, exporter.restored)
+	}
+	if _, err := os.Stat(path.Join(oldPath, "data")); err != nil {
+		t.Errorf("expected old directory to be put back: %v", err)
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", newPath)
+	}
+
+	// Likewise if the copy's quota can't be created
+	fakeRunner.Run = nil
+	quotaer.err = errors.New("xfs_quota failed")
+	if err := p.migrateVolume(volume, newRoot); err == nil {
+		t.Errorf("expected error migrating volume when creating the quota fails but got none")
+	}
+	if expected := []uint16{1, 1, 1}; !reflect.DeepEqual(exporter.removed, expected) || !reflect.DeepEqual(exporter.restored, expected) {
+		t.Errorf("expected export %v removed and restored but got removed %v and restored %v", expected, exporter.removed, exporter.restored)
+	}
+	if _, err := os.Stat(path.Join(oldPath, "data")); err != nil {
+		t.Errorf("expected old directory to be put back: %v", err)
+	}
+	if len(quotaer.removed) != 0 {
+		t.Errorf("expected old quota project to be kept but got removed projects %v", quotaer.removed)
+	}
+	quotaer.err = nil
+
+	// Otherwise what changed is copied, the copy moved into place and bind
+	// mounted where the volume is exported, which its PV keeps pointing at
+	fakeRunner = &runner.Fake{}
+	cmdRunner = fakeRunner
+	mount := "mount --bind " + newPath + " " + oldPath
+	volume, _ = client.Core().PersistentVolumes().Get("pvc-1", metav1.GetOptions{})
+	if err := p.migrateVolume(volume, newRoot); err != nil {
+		t.Fatalf("unexpected error migrating volume: %v", err)
+	}
+	if expected := []string{rsync, mount}; !reflect.DeepEqual(fakeRunner.Commands(), expected) {
+		t.Errorf("expected commands %v but got %v", expected, fakeRunner.Commands())
+	}
+	if expected := []uint16{1, 1, 1, 1}; !reflect.DeepEqual(exporter.removed, expected) || !reflect.DeepEqual(exporter.restored, expected) {
+		t.Errorf("expected export %v removed and restored but got removed %v and restored %v", expected, exporter.removed, exporter.restored)
+	}
+	// The copy gets its own quota project, and the old one is removed
+	if expected := []string{newPath}; !reflect.DeepEqual(quotaer.added, expected) || !reflect.DeepEqual(quotaer.removed, []uint16{1}) {
+		t.Errorf("expected quota project added for %v and project 1 removed but got added %v and removed %v", expected, quotaer.added, quotaer.removed)
+	}
+	volume, _ = client.Core().PersistentVolumes().Get("pvc-1", metav1.GetOptions{})
+	if volume.Annotations[annProjectID] != "2" || volume.Annotations[annProjectBlock] != "\n2:"+newPath+":1024\n" {
+		t.Errorf("expected volume to record quota project 2 for %s but got annotations %v", newPath, volume.Annotations)
+	}
+	if !reflect.DeepEqual(volume.Spec.PersistentVolumeSource, source) {
+		t.Errorf("expected volume to keep its source %+v but got %+v", source, volume.Spec.PersistentVolumeSource)
+	}
+	if volume.Annotations[annExportRoot] != newRoot || volume.Annotations[annExportPath] != oldPath {
+		t.Errorf("expected volume in %s exported at %s but got annotations %v", newRoot, oldPath, volume.Annotations)
+	}
+	if p.getDirectory(volume) != newPath || p.getExportPath(volume) != oldPath {
+		t.Errorf("expected volume's directory %s exported at %s but got %s exported at %s", newPath, oldPath, p.getDirectory(volume), p.getExportPath(volume))
+	}
+	if _, ok := volume.Annotations[AnnMigrateTo]; ok {
+		t.Errorf("expected annotation %s to be removed", AnnMigrateTo)
+	}
+	if _, err := os.Stat(newPath); err != nil {
+		t.Errorf("expected migrated directory: %v", err)
+	}
+	for _, dir := range []string{staging, path.Join(oldRoot, migratedDir, "pvc-1")} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed", dir)
+		}
+	}
+	if mounts, err := readBindMounts(path.Join(oldRoot, bindMountsFile)); err != nil || !reflect.DeepEqual(mounts, map[string]string{oldPath: newPath}) {
+		t.Errorf("expected bind mount of %s at %s recorded but got %v, %v", newPath, oldPath, mounts, err)
+	}
+
+	// Migrating it back unmounts the copy and moves the directory back
+	fakeRunner = &runner.Fake{}
+	cmdRunner = fakeRunner
+	available = 1024
+	volume.Annotations[AnnMigrateTo] = oldRoot
+	if err := p.migrateVolume(volume, oldRoot); err != nil {
+		t.Fatalf("unexpected error migrating volume back: %v", err)
+	}
+	staging = path.Join(oldRoot, migratingDir, "pvc-1")
+	if expected := []string{"rsync -aHAX --delete " + newPath + "/ " + staging, "umount " + oldPath}; !reflect.DeepEqual(fakeRunner.Commands(), expected) {
+		t.Errorf("expected commands %v but got %v", expected, fakeRunner.Commands())
+	}
+	volume, _ = client.Core().PersistentVolumes().Get("pvc-1", metav1.GetOptions{})
+	if _, ok := volume.Annotations[annExportPath]; ok || volume.Annotations[annExportRoot] != oldRoot {
+		t.Errorf("expected volume back in %s exported from its directory but got annotations %v", oldRoot, volume.Annotations)
+	}
+	if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", newPath)
+	}
+	if mounts, err := readBindMounts(path.Join(oldRoot, bindMountsFile)); err != nil || len(mounts) != 0 {
+		t.Errorf("expected no bind mounts recorded but got %v, %v", mounts, err)
+	}
+	if volume.Annotations[annProjectID] != "3" || !reflect.DeepEqual(quotaer.removed, []uint16{1, 2}) {
+		t.Errorf("expected volume to record quota project 3 and project 2 removed but got annotations %v and removed %v", volume.Annotations, quotaer.removed)
+	}
+}
+
+func TestNotifyWebhook(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	received := make(chan webhookNotification, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification webhookNotification
+		if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+			t.Errorf("error decoding notification: %v", err)
+		}
+		received <- notification
+	}))
+	defer server.Close()
+
+	p := newNFSProvisionerInternal(tmpDir, fake.NewSimpleClientset(), false, &testExporter{}, newDummyQuotaer(), "")
+	p.startWebhook(server.URL)
+	defer close(p.webhooks)
+
+	// A failed provision, a deletion and a deletion ignored because another
+	// provisioner provisioned the volume
+	options := controller.VolumeOptions{
+		PVName:     "pvc-1",
+		PVC:        newClaim(resource.MustParse("1Ki"), nil, nil),
+		Parameters: map[string]string{"foo": "bar"},
+	}
+	if _, err := p.Provision(options); err == nil {
+		t.Errorf("expected provisioning with an invalid parameter to fail")
+	}
+	volume := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "pvc-2",
+			Annotations: map[string]string{
+				annProvisionerID: string(p.identity),
+				annExportBlock:   "",
+				annExportID:      "0",
+				annProjectBlock:  "",
+				annProjectID:     "0",
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity:               v1.ResourceList{v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Ki")},
+			ClaimRef:               &v1.ObjectReference{Name: "claim-2", Namespace: v1.NamespaceDefault},
+			PersistentVolumeSource: v1.PersistentVolumeSource{NFS: &v1.NFSVolumeSource{Server: "10.0.0.20", Path: path.Join(tmpDir, "pvc-2")}},
+		},
+	}
+	if err := p.Delete(volume); err != nil {
+		t.Errorf("unexpected error deleting volume: %v", err)
+	}
+	volume.Annotations[annProvisionerID] = "other"
+	p.Delete(volume)
+
+	expected := []webhookNotification{
+		{Operation: webhookProvision, Success: false, Volume: "pvc-1", Namespace: options.PVC.Namespace, Claim: options.PVC.Name, Capacity: 1024},
+		{Operation: webhookDelete, Success: true, Volume: "pvc-2", Namespace: v1.NamespaceDefault, Claim: "claim-2", Capacity: 1024, Server: "10.0.0.20", Path: path.Join(tmpDir, "pvc-2")},
+	}
+	for _, e := range expected {
+		var got webhookNotification
+		select {
+		case got = <-received:
+		case <-time.After(wait.ForeverTestTimeout):
+			t.Fatalf("timed out waiting for notification of %s of volume %q", e.Operation, e.Volume)
+		}
+		if (got.Error != "") == e.Success || got.Provisioner != string(p.identity) || got.Time.IsZero() {
+			t.Errorf("unexpected notification %+v", got)
+		}
+		got.Error, got.Provisioner, got.Time = "", "", time.Time{}
+		if !reflect.DeepEqual(got, e) {
+			t.Errorf("expected notification %+v but got %+v", e, got)
+		}
+	}
+	select {
+	case got := <-received:
+		t.Errorf("unexpected notification %+v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestGetEtabEntries(t *testing.T) {
+	tests := []struct {
+		name            string
+		config          string
+		expectedEntries []string
+		expectError     bool
+	}{
+		{
+			name:            "default export blocks",
+			config:          "\n/export/pvc-1 *(rw,insecure,no_root_squash,fsid=1)\n\n/export/pvc-2 *(rw,insecure,root_squash,fsid=2)\n",
+			expectedEntries: []string{"/export/pvc-1\t*(rw,insecure,no_root_squash,fsid=1)\n", "/export/pvc-2\t*(rw,insecure,root_squash,fsid=2)\n"},
+		},
+		{
+			name:            "several clients over continued lines",
+			config:          "# comment\n/export/pvc-1 10.0.0.0/8(rw,fsid=1) \\\n\t192.168.0.1(ro,fsid=1)\n",
+			expectedEntries: []string{"/export/pvc-1\t10.0.0.0/8(rw,fsid=1)\n", "/export/pvc-1\t192.168.0.1(ro,fsid=1)\n"},
+		},
+		{
+			name:            "quoted path and no client",
+			config:          "\"/export/my pvc\" (rw,fsid=1)\n/export/pvc-2\n",
+			expectedEntries: []string{"\"/export/my pvc\"\t*(rw,fsid=1)\n", "/export/pvc-2\t*()\n"},
+		},
+		{
+			name:        "unterminated quoted path",
+			config:      "\"/export/my pvc (rw,fsid=1)\n",
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		entries, err := getEtabEntries(test.config)
+
+		evaluate(t, test.name, test.expectError, err, test.expectedEntries, entries, "etab entries")
+	}
+}
+
+func TestWriteEtab(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	defer func(old string) { etabFile = old }(etabFile)
+	defer func(old []string) { rpcCacheFlushFiles = old }(rpcCacheFlushFiles)
+	etabFile = tmpDir + "/etab"
+	rpcCacheFlushFiles = []string{tmpDir + "/nfsd.export-flush", tmpDir + "/missing-flush"}
+	ioutil.WriteFile(rpcCacheFlushFiles[0], []byte{}, 0600)
+
+	conf := tmpDir + "/exports"
+	ioutil.WriteFile(conf, []byte("\n/export/pvc-1 *(rw,insecure,no_root_squash,fsid=1)\n"), 0600)
+	exportsDir := tmpDir + "/exports.d"
+	os.MkdirAll(exportsDir, 0755)
+	ioutil.WriteFile(exportsDir+"/export-pvc-2.exports", []byte("\n/export/pvc-2 *(rw,insecure,no_root_squash,fsid=2)\n"), 0644)
+	e := &kernelExporter{
+		genericExporter: *newGenericExporter(&kernelExportBlockCreator{}, conf, regexp.MustCompile("fsid=([0-9]+)")),
+		exportsDir:      exportsDir,
+		directExport:    true,
+	}
+
+	err := e.exportfs()
+
+	read, _ := ioutil.ReadFile(etabFile)
+	evaluate(t, "write etab", false, err, "/export/pvc-1\t*(rw,insecure,no_root_squash,fsid=1)\n/export/pvc-2\t*(rw,insecure,no_root_squash,fsid=2)\n", string(read), "etab")
+	flushed, _ := ioutil.ReadFile(rpcCacheFlushFiles[0])
+	evaluate(t, "write etab", false, err, true, len(flushed) > 0, "cache flushed")
+	if _, err := os.Stat(rpcCacheFlushFiles[1]); !os.IsNotExist(err) {
+		t.Errorf("write etab: expected missing cache flush file to be skipped")
+	}
+}
+
+func TestGetExportClients(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	defer func(old string) { rmtabFile = old }(rmtabFile)
+	rmtabFile = tmpDir + "/rmtab"
+	ioutil.WriteFile(rmtabFile, []byte("10.0.0.1:/export/pvc-1:0x00000001\n10.0.0.2:/export/pvc-1:0x00000000\nfe80::1:/export/pvc-1/:0x00000002\n10.0.0.1:/export/pvc-2:0x00000001\n"), 0644)
+
+	clients, err := getExportClients("/export/pvc-1")
+	evaluate(t, "mounted export", false, err, []string{"10.0.0.1", "fe80::1"}, clients, "clients")
+
+	clients, err = getExportClients("/export/pvc-3")
+	evaluate(t, "unmounted export", false, err, []string(nil), clients, "clients")
+}
+
+func TestDrainExport(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	defer func(old string) { rmtabFile = old }(rmtabFile)
+	defer func(old time.Duration) { drainPollInterval = old }(drainPollInterval)
+	rmtabFile = tmpDir + "/rmtab"
+	drainPollInterval = 10 * time.Millisecond
+	ioutil.WriteFile(rmtabFile, []byte("10.0.0.1:"+tmpDir+"/pvc-1:0x00000001\n"), 0644)
+
+	p := newNFSProvisionerInternal(tmpDir, fake.NewSimpleClientset(), false, &testExporter{}, newDummyQuotaer(), "")
+	p.drainTimeout = time.Minute
+	volume := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"}}
+
+	// The client unmounts the export after a while
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ioutil.WriteFile(rmtabFile, []byte("10.0.0.1:"+tmpDir+"/pvc-1:0x00000000\n"), 0644)
+	}()
+	start := time.Now()
+	p.drainExport(volume)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 10*time.Second {
+		t.Errorf("expected drain to wait for the client to unmount but it took %v", elapsed)
+	}
+
+	// The client never unmounts the export
+	ioutil.WriteFile(rmtabFile, []byte("10.0.0.1:"+tmpDir+"/pvc-1:0x00000001\n"), 0644)
+	p.drainTimeout = 50 * time.Millisecond
+	start = time.Now()
+	p.drainExport(volume)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected drain to give up after the drain timeout but it took %v", elapsed)
+	}
+}
+
+func TestTakeEpoch(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	stale := newNFSProvisionerInternal(tmpDir, fake.NewSimpleClientset(), false, &testExporter{}, newDummyQuotaer(), "")
+	if err := stale.checkEpoch(); err != nil {
+		t.Errorf("expected no error checking epoch before taking one but got: %v", err)
+	}
+	if err := stale.TakeEpoch(); err != nil {
+		t.Fatalf("Error taking epoch: %v", err)
+	}
+	if err := stale.checkEpoch(); err != nil {
+		t.Errorf("expected no error checking own epoch but got: %v", err)
+	}
+
+	// Another replica takes over the storage
+	current := newNFSProvisionerInternal(tmpDir, fake.NewSimpleClientset(), false, &testExporter{}, newDummyQuotaer(), "")
+	if err := current.TakeEpoch(); err != nil {
+		t.Fatalf("Error taking epoch: %v", err)
+	}
+	if current.epoch != stale.epoch+1 {
+		t.Errorf("expected epoch %d but got %d", stale.epoch+1, current.epoch)
+	}
+	if err := current.checkEpoch(); err != nil {
+		t.Errorf("expected no error checking own epoch but got: %v", err)
+	}
+	if err := stale.checkEpoch(); err == nil {
+		t.Errorf("expected error checking epoch of fenced off provisioner but got none")
+	}
+	if err := stale.Delete(&v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Annotations: map[string]string{annProvisionerID: string(stale.identity)}}}); err == nil {
+		t.Errorf("expected error deleting volume with fenced off provisioner but got none")
+	}
+}
+
+func TestReplicate(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	defer func(old runner.Runner) { cmdRunner = old }(cmdRunner)
+	fakeRunner := &runner.Fake{}
+	cmdRunner = fakeRunner
+
+	p := newNFSProvisionerInternal(tmpDir+"/", fake.NewSimpleClientset(), false, &testExporter{}, newDummyQuotaer(), "")
+	if err := p.replicate("rsync://standby/export"); err != nil {
+		t.Fatalf("Error replicating: %v", err)
+	}
+	expected := []string{"rsync -aHAX --delete --exclude /ganesha.log --exclude /" + healthProbeFile + " " + tmpDir + "/ rsync://standby/export"}
+	if commands := fakeRunner.Commands(); !reflect.DeepEqual(expected, commands) {
+		t.Errorf("expected commands %v but got %v", expected, commands)
+	}
+
+	// A fenced off provisioner doesn't replicate over the one that took over
+	p.epoch = 1
+	ioutil.WriteFile(path.Join(tmpDir, epochFile), []byte("2\n"), 0644)
+	if err := p.replicate("rsync://standby/export"); err == nil {
+		t.Errorf("expected error replicating with fenced off provisioner but got none")
+	}
+	if commands := fakeRunner.Commands(); len(commands) != 1 {
+		t.Errorf("expected no more commands but got %v", commands)
+	}
+}
+
+func TestReplicateReads(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	defer func(old runner.Runner) { cmdRunner = old }(cmdRunner)
+	fakeRunner := &runner.Fake{}
+	cmdRunner = fakeRunner
+
+	os.Setenv(podIPEnv, "1.1.1.1")
+	defer os.Unsetenv(podIPEnv)
+	client := fake.NewSimpleClientset()
+	p := newNFSProvisionerInternal(tmpDir, client, false, &testExporter{}, newDummyQuotaer(), "")
+	options := controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:     "pvc-1",
+		PVC:        newClaim(resource.MustParse("1Mi"), nil, nil),
+		Parameters: map[string]string{"readReplica": "true"},
+	}
+
+	// The parameter is refused without a read replica server
+	if _, err := p.Provision(options); err == nil {
+		t.Errorf("expected error provisioning volume without read replica server but got none")
+	}
+
+	p.readReplicaServer = "2.2.2.2"
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("unexpected error provisioning volume: %v", err)
+	}
+	evaluate(t, "provision", false, nil, "pvc-1-ro", pv.Annotations[annReadReplica], "read replica annotation")
+	pv.Spec.StorageClassName = "foo"
+	client.Core().PersistentVolumes().Create(pv)
+
+	if err := p.replicateReads("rsync://read-replica/export"); err != nil {
+		t.Fatalf("Error replicating reads: %v", err)
+	}
+	expected := []string{"rsync -aHAX --delete --delete-excluded --include /pvc-1/*** --exclude * " + tmpDir + "/ rsync://read-replica/export"}
+	if commands := fakeRunner.Commands(); !reflect.DeepEqual(expected, commands) {
+		t.Errorf("expected commands %v but got %v", expected, commands)
+	}
+	replica, err := client.Core().PersistentVolumes().Get("pvc-1-ro", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting read replica: %v", err)
+	}
+	evaluate(t, "replicate reads", false, nil, "2.2.2.2", replica.Spec.NFS.Server, "server")
+	evaluate(t, "replicate reads", false, nil, path.Join(tmpDir, ReadReplicaDir, "pvc-1"), replica.Spec.NFS.Path, "path")
+	evaluate(t, "replicate reads", false, nil, true, replica.Spec.NFS.ReadOnly, "read only")
+	evaluate(t, "replicate reads", false, nil, []v1.PersistentVolumeAccessMode{v1.ReadOnlyMany}, replica.Spec.AccessModes, "access modes")
+	evaluate(t, "replicate reads", false, nil, "foo", replica.Spec.StorageClassName, "class")
+	evaluate(t, "replicate reads", false, nil, "pvc-1", replica.Labels[LabelReadReplicaOf], "read replica of label")
+
+	// Deleting the volume deletes its read replica
+	if err := p.Delete(pv); err != nil {
+		t.Fatalf("unexpected error deleting volume: %v", err)
+	}
+	if _, err := client.Core().PersistentVolumes().Get("pvc-1-ro", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected read replica to be deleted along with its volume but it wasn't")
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// A Monday
+	from := time.Date(2017, time.April, 10, 2, 30, 15, 0, time.UTC)
+	tests := []struct {
+		name        string
+		spec        string
+		expectedErr bool
+		expected    time.Time
+	}{
+		{
+			name:     "nightly",
+			spec:     "0 2 * * *",
+			expected: time.Date(2017, time.April, 11, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "step",
+			spec:     "*/15 * * * *",
+			expected: time.Date(2017, time.April, 10, 2, 45, 0, 0, time.UTC),
+		},
+		{
+			name:     "list and range",
+			spec:     "0 1,3-5 * * *",
+			expected: time.Date(2017, time.April, 10, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "day of week 7 is sunday",
+			spec:     "0 0 * * 7",
+			expected: time.Date(2017, time.April, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "either day of month or day of week",
+			spec:     "0 0 1 * 3",
+			expected: time.Date(2017, time.April, 12, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "month",
+			spec:     "0 0 1 1 *",
+			expected: time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "never",
+			spec:     "0 0 30 2 *",
+			expected: time.Time{},
+		},
+		{
+			name:        "too few fields",
+			spec:        "0 2 * *",
+			expectedErr: true,
+		},
+		{
+			name:        "out of range",
+			spec:        "60 * * * *",
+			expectedErr: true,
+		},
+		{
+			name:        "zero step",
+			spec:        "*/0 * * * *",
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		schedule, err := parseSchedule(test.spec)
+		if err != nil {
+			evaluate(t, test.name, test.expectedErr, err, nil, nil, "schedule")
+			continue
+		}
+		evaluate(t, test.name, test.expectedErr, err, test.expected, schedule.next(from), "next time")
+	}
+}
+
+func TestSnapshotVolume(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	defer func(old runner.Runner) { cmdRunner = old }(cmdRunner)
+	fakeRunner := &runner.Fake{}
+	cmdRunner = fakeRunner
+
+	p := newNFSProvisionerInternal(tmpDir, fake.NewSimpleClientset(), false, &testExporter{}, newDummyQuotaer(), "")
+	volume := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "pvc-1",
+			Annotations:       map[string]string{annExportRoot: tmpDir},
+			CreationTimestamp: metav1.NewTime(time.Date(2017, time.April, 10, 1, 0, 0, 0, time.UTC)),
+		},
+	}
+	claim := newClaim(resource.MustParse("1Mi"), nil, nil)
+	claim.Annotations = map[string]string{AnnSnapshotSchedule: "0 2 * * *", AnnSnapshotRetention: "2"}
+	dir := path.Join(tmpDir, snapshotsDir, volume.Name)
+
+	// Nothing is due before the first scheduled time
+	if err := p.snapshotVolume(volume, claim, time.Date(2017, time.April, 10, 1, 59, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("unexpected error snapshotting volume: %v", err)
+	}
+	if commands := fakeRunner.Commands(); len(commands) != 0 {
+		t.Errorf("expected no commands but got %v", commands)
+	}
+
+	// Each snapshot after the first links to the previous one, and only the
+	// newest are kept
+	for day := 10; day <= 12; day++ {
+		if err := p.snapshotVolume(volume, claim, time.Date(2017, time.April, day, 2, 0, 5, 0, time.UTC)); err != nil {
+			t.Fatalf("unexpected error snapshotting volume: %v", err)
+		}
+		// Not due again the same day
+		if err := p.snapshotVolume(volume, claim, time.Date(2017, time.April, day, 3, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatalf("unexpected error snapshotting volume: %v", err)
+		}
+	}
+	expectedCommands := []string{
+		"rsync -aHAX " + p.getDirectory(volume) + "/ " + path.Join(dir, ".20170410-020005"),
+		"rsync -aHAX --link-dest=" + path.Join(dir, "20170410-020005") + " " + p.getDirectory(volume) + "/ " + path.Join(dir, ".20170411-020005"),
+		"rsync -aHAX --link-dest=" + path.Join(dir, "20170411-020005") + " " + p.getDirectory(volume) + "/ " + path.Join(dir, ".20170412-020005"),
+	}
+	if commands := fakeRunner.Commands(); !reflect.DeepEqual(expectedCommands, commands) {
+		t.Errorf("expected commands %v but got %v", expectedCommands, commands)
+	}
+	snapshots, err := listSnapshots(dir)
+	evaluate(t, "retention", false, err, []string{"20170411-020005", "20170412-020005"}, snapshots, "snapshots")
+
+	claim.Annotations[AnnSnapshotRetention] = "none"
+	if err := p.snapshotVolume(volume, claim, time.Date(2017, time.April, 13, 2, 0, 0, 0, time.UTC)); err == nil {
+		t.Errorf("expected error snapshotting volume with invalid retention but got none")
+	}
+
+	// Deleting the volume deletes its snapshots
+	if err := p.deleteSnapshots(volume); err != nil {
+		t.Fatalf("unexpected error deleting snapshots: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected snapshots to be deleted but got %v", err)
+	}
+}
+
+func TestExpireVolumes(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	os.Setenv(podIPEnv, "1.1.1.1")
+	defer os.Unsetenv(podIPEnv)
+	client := fake.NewSimpleClientset()
+	p := newNFSProvisionerInternal(tmpDir, client, false, &testExporter{}, newDummyQuotaer(), "")
+
+	pv, err := p.Provision(controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:     "pvc-1",
+		PVC:        newClaim(resource.MustParse("1Mi"), nil, nil),
+		Parameters: map[string]string{"expiresAfter": "72h"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error provisioning volume: %v", err)
+	}
+	expiresAt, err := time.Parse(time.RFC3339, pv.Annotations[AnnExpiresAt])
+	if err != nil || expiresAt.Before(time.Now().Add(71*time.Hour)) || expiresAt.After(time.Now().Add(72*time.Hour)) {
+		t.Errorf("expected annotation %s about 72h from now but got %q", AnnExpiresAt, pv.Annotations[AnnExpiresAt])
+	}
+
+	expired := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	newVolume := func(name string, phase v1.PersistentVolumePhase, policy v1.PersistentVolumeReclaimPolicy, expiresAt string, claimRef *v1.ObjectReference) *v1.PersistentVolume {
+		return &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: map[string]string{annProvisionerID: string(p.identity), annExportRoot: tmpDir, annExportBlock: "", annExportID: "0", annProjectBlock: "", annProjectID: "0", AnnExpiresAt: expiresAt},
+			},
+			Spec:   v1.PersistentVolumeSpec{PersistentVolumeReclaimPolicy: policy, ClaimRef: claimRef},
+			Status: v1.PersistentVolumeStatus{Phase: phase},
+		}
+	}
+	client.Core().PersistentVolumeClaims(v1.NamespaceDefault).Create(&v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "claim-2", Namespace: v1.NamespaceDefault, UID: types.UID("uid-2")},
+	})
+	client.Core().PersistentVolumeClaims(v1.NamespaceDefault).Create(&v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "claim-3", Namespace: v1.NamespaceDefault, UID: types.UID("uid-3")},
+	})
+	client.Core().PersistentVolumes().Create(newVolume("pvc-2", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, expired, &v1.ObjectReference{Name: "claim-2", Namespace: v1.NamespaceDefault, UID: types.UID("uid-2")}))
+	client.Core().PersistentVolumes().Create(newVolume("pvc-3", v1.VolumeBound, v1.PersistentVolumeReclaimDelete, pv.Annotations[AnnExpiresAt], &v1.ObjectReference{Name: "claim-3", Namespace: v1.NamespaceDefault, UID: types.UID("uid-3")}))
+	client.Core().PersistentVolumes().Create(newVolume("pvc-4", v1.VolumeReleased, v1.PersistentVolumeReclaimRetain, expired, nil))
+	client.Core().PersistentVolumes().Create(newVolume("pvc-5", v1.VolumeReleased, v1.PersistentVolumeReclaimDelete, expired, nil))
+	os.MkdirAll(path.Join(tmpDir, "pvc-4"), 0755)
+
+	p.expireVolumes(false)
+
+	// The expired bound volume's claim is deleted, the unexpired one's isn't
+	if _, err := client.Core().PersistentVolumeClaims(v1.NamespaceDefault).Get("claim-2", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected claim of expired volume to be deleted but it wasn't")
+	}
+	if _, err := client.Core().PersistentVolumeClaims(v1.NamespaceDefault).Get("claim-3", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected claim of unexpired volume to be kept but got %v", err)
+	}
+	// The expired retained volume keeps its data unless deleteRetained is set
+	if _, err := client.Core().PersistentVolumes().Get("pvc-4", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected expired retained volume to be kept but got %v", err)
+	}
+	if _, err := os.Stat(path.Join(tmpDir, "pvc-4")); err != nil {
+		t.Errorf("expected directory of expired retained volume to be kept but got %v", err)
+	}
+
+	p.expireVolumes(true)
+
+	// The expired released volume is then deleted by the provisioner only if
+	// the provision controller won't
+	if _, err := client.Core().PersistentVolumes().Get("pvc-4", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected expired retained volume to be deleted but it wasn't")
+	}
+	if _, err := os.Stat(path.Join(tmpDir, "pvc-4")); !os.IsNotExist(err) {
+		t.Errorf("expected directory of expired retained volume to be deleted but got %v", err)
+	}
+	if _, err := client.Core().PersistentVolumes().Get("pvc-5", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected expired volume with Delete reclaim policy to be left to the provision controller but got %v", err)
+	}
+}
+
+func TestPreallocate(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	os.Setenv(podIPEnv, "1.1.1.1")
+	defer os.Unsetenv(podIPEnv)
+	client := fake.NewSimpleClientset()
+	p := newNFSProvisionerInternal(tmpDir, client, false, &testExporter{}, newDummyQuotaer(), "")
+
+	pv, err := p.Provision(controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:     "pvc-1",
+		PVC:        newClaim(resource.MustParse("1Mi"), nil, nil),
+		Parameters: map[string]string{"preallocate": "true"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error provisioning volume: %v", err)
+	}
+	reservation := path.Join(tmpDir, preallocatedDir, "pvc-1")
+	fi, err := os.Stat(reservation)
+	if err != nil {
+		t.Fatalf("unexpected error getting info of reservation: %v", err)
+	}
+	evaluate(t, "provision", false, nil, int64(1024*1024), fi.Size(), "reservation size")
+
+	expanded, err := p.ExpandVolume(pv, resource.MustParse("2Mi"))
+	if err != nil {
+		t.Fatalf("unexpected error expanding volume: %v", err)
+	}
+	fi, err = os.Stat(reservation)
+	if err != nil {
+		t.Fatalf("unexpected error getting info of reservation: %v", err)
+	}
+	evaluate(t, "expand", false, nil, int64(2*1024*1024), fi.Size(), "reservation size")
+
+	if err := p.Delete(expanded); err != nil {
+		t.Fatalf("unexpected error deleting volume: %v", err)
+	}
+	if _, err := os.Stat(reservation); !os.IsNotExist(err) {
+		t.Errorf("expected reservation to be removed with the volume")
+	}
+}
+
+func TestExpandVolume(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	block := "\n1:" + tmpDir + "/pvc-1:1024\n"
+	tests := []struct {
+		name             string
+		provisionerID    string
+		quotaErr         error
+		fenced           bool
+		expectedBlock    string
+		expectedCommands []string
+		expectError      bool
+	}{
+		{
+			name:             "expand volume",
+			expectedBlock:    "\n1:" + tmpDir + "/pvc-1:2048\n",
+			expectedCommands: []string{"xfs_quota -x -c limit -p bhard=2048 1 /xfs"},
+		},
+		{
+			name:             "xfs_quota fails",
+			quotaErr:         errors.New("exit status 1"),
+			expectedBlock:    block,
+			expectedCommands: []string{"xfs_quota -x -c limit -p bhard=2048 1 /xfs"},
+			expectError:      true,
+		},
+		{
+			name:             "not this provisioner's volume",
+			provisionerID:    "foo",
+			expectedBlock:    block,
+			expectedCommands: []string{},
+			expectError:      true,
+		},
+		{
+			name:             "fenced off provisioner",
+			fenced:           true,
+			expectedBlock:    block,
+			expectedCommands: []string{},
+			expectError:      true,
+		},
+	}
+	defer func(old runner.Runner) { cmdRunner = old }(cmdRunner)
+	for _, test := range tests {
+		projectsFile := tmpDir + "/projects"
+		ioutil.WriteFile(projectsFile, []byte(block), 0600)
+		quotaer := &xfsQuotaer{
+			xfsPath:      "/xfs",
+			projectsFile: projectsFile,
+			projectIDs:   map[uint16]bool{1: true},
+			mapMutex:     &sync.Mutex{},
+			fileMutex:    &sync.Mutex{},
+		}
+		quotaErr := test.quotaErr
+		fakeRunner := &runner.Fake{
+			Run: func(name string, args ...string) ([]byte, error) {
+				return nil, quotaErr
+			},
+		}
+		cmdRunner = fakeRunner
+
+		client := fake.NewSimpleClientset()
+		p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, quotaer, "")
+		if test.fenced {
+			p.TakeEpoch()
+			newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, quotaer, "").TakeEpoch()
+		}
+		provisionerID := test.provisionerID
+		if provisionerID == "" {
+			provisionerID = string(p.identity)
+		}
+		volume := &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "pvc-1",
+				Annotations: map[string]string{
+					annProvisionerID: provisionerID,
+					annExportRoot:    tmpDir,
+					annProjectBlock:  block,
+					annProjectID:     "1",
+				},
+			},
+			Spec: v1.PersistentVolumeSpec{
+				Capacity: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Ki"),
+				},
+			},
+		}
+
+		expanded, err := p.ExpandVolume(volume, resource.MustParse("2Ki"))
+
+		read, _ := ioutil.ReadFile(projectsFile)
+		evaluate(t, test.name, false, nil, test.expectedBlock, string(read), "projects file")
+		evaluate(t, test.name, false, nil, test.expectedCommands, fakeRunner.Commands(), "commands")
+		if test.expectError {
+			evaluate(t, test.name, false, nil, true, err != nil, "error")
+			continue
+		}
+		capacity := expanded.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		evaluate(t, test.name, false, err, "2Ki", capacity.String(), "capacity")
+		evaluate(t, test.name, false, err, test.expectedBlock, expanded.Annotations[annProjectBlock], "project block")
+	}
+}
+
+func TestXfsQuotaerProjectPool(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	defer func(old runner.Runner) { cmdRunner = old }(cmdRunner)
+	fakeRunner := &runner.Fake{}
+	cmdRunner = fakeRunner
+
+	projectsFile := tmpDir + "/projects"
+	ioutil.WriteFile(projectsFile, []byte("\n1:"+tmpDir+"/pvc-1:1024\n"), 0600)
+	quotaer := &xfsQuotaer{
+		xfsPath:      "/xfs",
+		projectsFile: projectsFile,
+		projectIDs:   map[uint16]bool{1: true},
+		poolSize:     2,
+		mapMutex:     &sync.Mutex{},
+		fileMutex:    &sync.Mutex{},
+	}
+
+	err := quotaer.fillPool()
+	evaluate(t, "fill pool", false, err, []uint16{2, 3}, quotaer.pool, "pool")
+	evaluate(t, "fill pool", false, err, []string{"xfs_quota -x -c limit -p bhard=0 2 -c limit -p bhard=0 3 /xfs"}, fakeRunner.Commands(), "commands")
+
+	fakeRunner = &runner.Fake{}
+	cmdRunner = fakeRunner
+	block, projectID, err := quotaer.AddProject(tmpDir+"/pvc-2", "2048")
+	evaluate(t, "add project", false, err, uint16(2), projectID, "project id")
+	evaluate(t, "add project", false, err, "\n2:"+tmpDir+"/pvc-2:2048\n", block, "project block")
+	evaluate(t, "add project", false, err, []uint16{3}, quotaer.pool, "pool")
+	evaluate(t, "add project", false, err, []string{"xfs_quota -x -c project -s -p " + tmpDir + "/pvc-2 2 -c limit -p bhard=2048 2 /xfs"}, fakeRunner.Commands(), "commands")
+}
+
+func TestNamespaceDirectories(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name                 string
+		namespaceDirectories bool
+		pvName               string
+		namespace            string
+		expectedPath         string
+		expectedNamespaceDir string
+	}{
+		{
+			name:         "namespace directories disabled",
+			pvName:       "pvc-1",
+			namespace:    "tenant-a",
+			expectedPath: tmpDir + "/pvc-1",
+		},
+		{
+			name:                 "first volume of namespace",
+			namespaceDirectories: true,
+			pvName:               "pvc-2",
+			namespace:            "tenant-a",
+			expectedPath:         tmpDir + "/tenant-a/pvc-2",
+			expectedNamespaceDir: "tenant-a",
+		},
+		{
+			name:                 "second volume of namespace",
+			namespaceDirectories: true,
+			pvName:               "pvc-3",
+			namespace:            "tenant-a",
+			expectedPath:         tmpDir + "/tenant-a/pvc-3",
+			expectedNamespaceDir: "tenant-a",
+		},
+		{
+			name:                 "volume of another namespace",
+			namespaceDirectories: true,
+			pvName:               "pvc-4",
+			namespace:            "tenant-b",
+			expectedPath:         tmpDir + "/tenant-b/pvc-4",
+			expectedNamespaceDir: "tenant-b",
+		},
+	}
+
+	client := fake.NewSimpleClientset()
+	os.Setenv(podIPEnv, "1.1.1.1")
+	defer os.Unsetenv(podIPEnv)
+	for _, test := range tests {
+		p := newNFSProvisionerInternal(tmpDir, client, false, &testExporter{}, newDummyQuotaer(), "")
+		p.namespaceDirectories = test.namespaceDirectories
+		claim := newClaim(resource.MustParse("1Ki"), nil, nil)
+		claim.Namespace = test.namespace
+
+		pv, err := p.Provision(controller.VolumeOptions{
+			PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+			PVName:     test.pvName,
+			PVC:        claim,
+			Parameters: map[string]string{},
+		})
+		if err != nil {
+			t.Errorf("%s: unexpected error provisioning volume: %v", test.name, err)
+			continue
+		}
+
+		evaluate(t, test.name, false, nil, test.expectedPath, pv.Spec.NFS.Path, "path")
+		evaluate(t, test.name, false, nil, test.expectedPath, p.getDirectory(pv), "directory")
+		evaluate(t, test.name, false, nil, test.expectedNamespaceDir, pv.Annotations[annNamespaceDirectory], "namespace directory")
+		if _, err := os.Stat(test.expectedPath); err != nil {
+			t.Errorf("%s: error getting info of directory: %v", test.name, err)
+		}
+		if test.expectedNamespaceDir != "" {
+			fi, err := os.Stat(path.Join(tmpDir, test.expectedNamespaceDir))
+			if err != nil {
+				t.Errorf("%s: error getting info of namespace directory: %v", test.name, err)
+				continue
+			}
+			evaluate(t, test.name, false, nil, os.FileMode(0755), fi.Mode().Perm(), "namespace directory permissions")
+		}
+	}
+}
+
+func TestCheckNamespaceQuota(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name           string
+		namespaceQuota int64
+		namespace      string
+		pvName         string
+		size           int64
+		expectError    bool
+	}{
+		{
+			name:      "no quota",
+			namespace: "tenant-a",
+			pvName:    "pvc-3",
+			size:      1 << 40,
+		},
+		{
+			name:           "within quota",
+			namespaceQuota: 4096,
+			namespace:      "tenant-a",
+			pvName:         "pvc-3",
+			size:           2048,
+		},
+		{
+			name:           "exceeds quota",
+			namespaceQuota: 4096,
+			namespace:      "tenant-a",
+			pvName:         "pvc-3",
+			size:           2049,
+			expectError:    true,
+		},
+		{
+			name:           "expand volume within quota",
+			namespaceQuota: 4096,
+			namespace:      "tenant-a",
+			pvName:         "pvc-1",
+			size:           3072,
+		},
+		{
+			name:           "expand volume beyond quota",
+			namespaceQuota: 4096,
+			namespace:      "tenant-a",
+			pvName:         "pvc-1",
+			size:           3073,
+			expectError:    true,
+		},
+		{
+			name:           "other namespace",
+			namespaceQuota: 4096,
+			namespace:      "tenant-b",
+			pvName:         "pvc-3",
+			size:           4096,
+		},
+	}
+
+	client := fake.NewSimpleClientset()
+	p := newNFSProvisionerInternal(tmpDir, client, false, &testExporter{}, newDummyQuotaer(), "")
+	for _, v := range []struct {
+		name, namespace, provisionerID, capacity string
+	}{
+		{"pvc-1", "tenant-a", string(p.identity), "1Ki"},
+		{"pvc-2", "tenant-a", string(p.identity), "1Ki"},
+		{"pvc-4", "tenant-a", "foo", "1Ki"},
+		{"pvc-5", "tenant-b", "foo", "1Ki"},
+	} {
+		client.Core().PersistentVolumes().Create(&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: v.name,
+				Annotations: map[string]string{
+					annProvisionerID:      v.provisionerID,
+					annNamespaceDirectory: v.namespace,
+				},
+			},
+			Spec: v1.PersistentVolumeSpec{
+				Capacity: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse(v.capacity),
+				},
+			},
+		})
+	}
+
+	for _, test := range tests {
+		p.namespaceQuota = test.namespaceQuota
+
+		err := p.checkNamespaceQuota(test.namespace, test.pvName, test.size)
+
+		evaluate(t, test.name, false, nil, test.expectError, err != nil, "error")
+	}
+}
+
+func TestMaxVolumes(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name                string
+		maxVolumes          int
+		provisioningVolumes int
+		expectError         bool
+	}{
+		{
+			name:       "no limit",
+			maxVolumes: 0,
+		},
+		{
+			name:       "below limit",
+			maxVolumes: 3,
+		},
+		{
+			name:        "at limit",
+			maxVolumes:  2,
+			expectError: true,
+		},
+		{
+			name:                "at limit with volume being provisioned",
+			maxVolumes:          3,
+			provisioningVolumes: 1,
+			expectError:         true,
+		},
+	}
+
+	client := fake.NewSimpleClientset()
+	p := newNFSProvisionerInternal(tmpDir, client, false, &testExporter{}, newDummyQuotaer(), "")
+	for _, v := range []struct {
+		name, provisionerID string
+	}{
+		{"pvc-1", string(p.identity)},
+		{"pvc-2", string(p.identity)},
+		{"pvc-3", "foo"},
+	} {
+		client.Core().PersistentVolumes().Create(&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        v.name,
+				Annotations: map[string]string{annProvisionerID: v.provisionerID},
+			},
+		})
+	}
+
+	for _, test := range tests {
+		p.maxVolumes = test.maxVolumes
+		p.provisioningVolumes = test.provisioningVolumes
+		p.refusedVolumes = 0
+
+		err := p.reserveVolume()
+		if err == nil {
+			p.releaseVolume()
+		}
+
+		evaluate(t, test.name, false, nil, test.expectError, err != nil, "error")
+		evaluate(t, test.name, false, nil, test.provisioningVolumes, p.provisioningVolumes, "volumes being provisioned")
+
+		req, _ := http.NewRequest("GET", "/metrics", nil)
+		rec := httptest.NewRecorder()
+		p.ServeMetrics(rec, req)
+		refused := 0
+		if test.expectError {
+			refused = 1
+		}
+		for _, metric := range []string{
+			"nfs_provisioner_volumes 2",
+			"nfs_provisioner_max_volumes " + strconv.Itoa(test.maxVolumes),
+			"nfs_provisioner_max_volumes_refused_total " + strconv.Itoa(refused),
+		} {
+			if !strings.Contains(rec.Body.String(), metric+"\n") {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected metric %q in:\n%s", metric, rec.Body.String())
+			}
+		}
+	}
+}
+
+func TestForCluster(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	homeClient := fake.NewSimpleClientset()
+	p := newNFSProvisionerInternal(tmpDir, homeClient, false, &testExporter{}, newDummyQuotaer(), "")
+	p.nodeAffinity = true
+	clusterClient := fake.NewSimpleClientset()
+	cluster := p.ForCluster(clusterClient).(*nfsProvisioner)
+
+	for _, client := range []*fake.Clientset{homeClient, clusterClient} {
+		client.Core().PersistentVolumes().Create(&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pvc-1",
+				Annotations: map[string]string{annProvisionerID: string(p.identity)},
+			},
+		})
+	}
+
+	evaluate(t, "identity", false, nil, p.identity, cluster.identity, "identity")
+	evaluate(t, "node affinity", false, nil, false, cluster.nodeAffinity, "node affinity")
+
+	count, err := cluster.countVolumes()
+	evaluate(t, "count volumes of all clusters", false, err, 2, count, "volumes")
+
+	p.maxVolumes = 3
+	cluster.maxVolumes = 3
+	err = cluster.reserveVolume()
+	evaluate(t, "reserve volume below limit", false, nil, false, err != nil, "error")
+	err = p.reserveVolume()
+	evaluate(t, "reserve volume at limit with volume being provisioned for other cluster", false, nil, true, err != nil, "error")
+	cluster.releaseVolume()
+	evaluate(t, "release volume", false, nil, 0, p.provisioningVolumes, "volumes being provisioned")
+
+	p.setHealth(errors.New("probe timed out"))
+	should := cluster.ShouldProvision(newClaim(resource.MustParse("1Ki"), nil, nil))
+	evaluate(t, "share health", false, nil, false, should, "should provision")
+}
+
+func TestDirectoryPool(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	os.Setenv(podIPEnv, "1.1.1.1")
+	defer os.Unsetenv(podIPEnv)
+	p := newNFSProvisionerInternal(tmpDir, fake.NewSimpleClientset(), false, &testExporter{}, newDummyQuotaer(), "")
+	if err := p.loadPool(); err != nil {
+		t.Fatalf("unexpected error loading pool: %v", err)
+	}
+	if err := p.fillPool(2); err != nil {
+		t.Fatalf("unexpected error filling pool: %v", err)
+	}
+	evaluate(t, "fill pool", false, nil, 2, len(p.pool), "pool size")
+
+	// A restarted provisioner loads the pool, dropping directories that are gone
+	os.RemoveAll(path.Join(tmpDir, p.pool[1].Name))
+	p = newNFSProvisionerInternal(tmpDir, fake.NewSimpleClientset(), false, &testExporter{}, newDummyQuotaer(), "")
+	if err := p.loadPool(); err != nil {
+		t.Fatalf("unexpected error loading pool: %v", err)
+	}
+	evaluate(t, "load pool", false, nil, 1, len(p.pool), "pool size")
+	pooled := p.pool[0].Name
+
+	tests := []struct {
+		name              string
+		pvName            string
+		parameters        map[string]string
+		expectedDirectory string
+	}{
+		{
+			name:              "volume needing a gid",
+			pvName:            "pvc-1",
+			parameters:        map[string]string{"gid": "1001"},
+			expectedDirectory: "pvc-1",
+		},
+		{
+			name:              "volume taking pooled directory",
+			pvName:            "pvc-2",
+			parameters:        map[string]string{},
+			expectedDirectory: pooled,
+		},
+		{
+			name:              "pool empty",
+			pvName:            "pvc-3",
+			parameters:        map[string]string{},
+			expectedDirectory: "pvc-3",
+		},
+	}
+	for _, test := range tests {
+		pv, err := p.Provision(controller.VolumeOptions{
+			PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+			PVName:     test.pvName,
+			PVC:        newClaim(resource.MustParse("1Ki"), nil, nil),
+			Parameters: test.parameters,
+		})
+		if err != nil {
+			t.Errorf("%s: unexpected error provisioning volume: %v", test.name, err)
+			continue
+		}
+
+		expectedPath := path.Join(tmpDir, test.expectedDirectory)
+		evaluate(t, test.name, false, nil, expectedPath, pv.Spec.NFS.Path, "path")
+		evaluate(t, test.name, false, nil, expectedPath, p.getDirectory(pv), "directory")
+		if _, err := os.Stat(p.getPoolFile(test.expectedDirectory)); !os.IsNotExist(err) {
+			t.Errorf("%s: expected the directory to no longer be persisted as part of the pool", test.name)
+		}
+	}
+}
+
+func TestReconcileStorageClass(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name          string
+		existing      *storage.StorageClass
+		expectedClass *storage.StorageClass
+		expectError   bool
+	}{
+		{
+			name:          "create class",
+			expectedClass: newStorageClass("foo.bar/baz", "nfs", map[string]string{"gid": "1001"}, true),
+		},
+		{
+			name:          "class as configured",
+			existing:      newStorageClass("foo.bar/baz", "nfs", map[string]string{"gid": "1001"}, true),
+			expectedClass: newStorageClass("foo.bar/baz", "nfs", map[string]string{"gid": "1001"}, true),
+		},
+		{
+			name:          "recreate class with drifted parameters",
+			existing:      newStorageClass("foo.bar/baz", "nfs", map[string]string{"gid": "none"}, true),
+			expectedClass: newStorageClass("foo.bar/baz", "nfs", map[string]string{"gid": "1001"}, true),
+		},
+		{
+			name:          "make class default again",
+			existing:      newStorageClass("foo.bar/baz", "nfs", map[string]string{"gid": "1001"}, false),
+			expectedClass: newStorageClass("foo.bar/baz", "nfs", map[string]string{"gid": "1001"}, true),
+		},
+		{
+			name: "leave class created by admin",
+			existing: &storage.StorageClass{
+				ObjectMeta:  metav1.ObjectMeta{Name: "nfs"},
+				Provisioner: "abc.def/ghi",
+			},
+			expectedClass: &storage.StorageClass{
+				ObjectMeta:  metav1.ObjectMeta{Name: "nfs"},
+				Provisioner: "abc.def/ghi",
+			},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset()
+		if test.existing != nil {
+			client.StorageV1().StorageClasses().Create(test.existing)
+		}
+		p := newNFSProvisionerInternal(tmpDir, client, false, &testExporter{}, newDummyQuotaer(), "")
+
+		err := p.reconcileStorageClass(newStorageClass("foo.bar/baz", "nfs", map[string]string{"gid": "1001"}, true))
+
+		evaluate(t, test.name, false, nil, test.expectError, err != nil, "error")
+		class, err := client.StorageV1().StorageClasses().Get("nfs", metav1.GetOptions{})
+		evaluate(t, test.name, false, err, test.expectedClass, class, "class")
+	}
+}
+
+func TestPreProvisionHook(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name             string
+		hookErr          error
+		expectedCommands []string
+		expectedDir      bool
+		expectError      bool
+	}{
+		{
+			name:             "hook succeeds",
+			expectedCommands: []string{"env PVC_NAME=claim-1 PVC_NAMESPACE=default VOLUME_NAME=pvc-1 VOLUME_PATH=" + tmpDir + "/pvc-1 VOLUME_SIZE=1024 sh -c register-share"},
+			expectedDir:      true,
+			expectError:      false,
+		},
+		{
+			name:             "hook fails, volume removed",
+			hookErr:          errors.New("exit status 1"),
+			expectedCommands: []string{"env PVC_NAME=claim-1 PVC_NAMESPACE=default VOLUME_NAME=pvc-1 VOLUME_PATH=" + tmpDir + "/pvc-1 VOLUME_SIZE=1024 sh -c register-share"},
+			expectedDir:      false,
+			expectError:      true,
+		},
+	}
+
+	os.Setenv(podIPEnv, "1.1.1.1")
+	defer os.Unsetenv(podIPEnv)
+	defer func(old fs.Filesystem) { fileSystem = old }(fileSystem)
+	defer func(old runner.Runner) { cmdRunner = old }(cmdRunner)
+	for _, test := range tests {
+		fakeFs := fs.NewFake(tmpDir)
+		fakeFs.Available = map[string]int64{tmpDir: 1024 * 1024}
+		fileSystem = fakeFs
+		hookErr := test.hookErr
+		fakeRunner := &runner.Fake{
+			Run: func(name string, args ...string) ([]byte, error) {
+				return nil, hookErr
+			},
+		}
+		cmdRunner = fakeRunner
+
+		client := fake.NewSimpleClientset()
+		p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "")
+		p.preProvisionHook = "register-share"
+
+		claim := newClaim(resource.MustParse("1Ki"), []v1.PersistentVolumeAccessMode{v1.ReadWriteMany}, nil)
+		claim.Name = "claim-1"
+		claim.Namespace = "default"
+		_, err := p.createVolume(controller.VolumeOptions{
+			PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+			PVName:     "pvc-1",
+			PVC:        claim,
+			Parameters: map[string]string{},
+		})
+
+		evaluate(t, test.name, false, nil, test.expectError, err != nil, "error")
+		evaluate(t, test.name, false, nil, test.expectedCommands, fakeRunner.Commands(), "commands")
+		evaluate(t, test.name, false, nil, test.expectedDir, fakeFs.Get(tmpDir+"/pvc-1") != nil, "directory exists")
+	}
+}
+
+func TestPopulateVolume(t *testing.T) {
+	tests := []struct {
+		name             string
+		source           dataSource
+		runErr           error
+		expectedCommands []string
+		expectError      bool
+	}{
+		{
+			name:             "rsync",
+			source:           dataSource{Rsync: &rsyncDataSource{Source: "backup::data/"}},
+			expectedCommands: []string{"rsync -rlt --chmod=Dg+rwxs,Fg+rw -- backup::data/ /export/pvc-1/"},
+		},
+		{
+			name:             "rsync url",
+			source:           dataSource{Rsync: &rsyncDataSource{Source: "rsync://user@Backup:873/data/"}},
+			expectedCommands: []string{"rsync -rlt --chmod=Dg+rwxs,Fg+rw -- rsync://user@Backup:873/data/ /export/pvc-1/"},
+		},
+		{
+			name:             "rsync fails",
+			source:           dataSource{Rsync: &rsyncDataSource{Source: "backup::data/"}},
+			runErr:           errors.New("exit status 10"),
+			expectedCommands: []string{"rsync -rlt --chmod=Dg+rwxs,Fg+rw -- backup::data/ /export/pvc-1/"},
+			expectError:      true,
+		},
+		{
+			name:             "rsync local path",
+			source:           dataSource{Rsync: &rsyncDataSource{Source: "/export/"}},
+			expectedCommands: []string{},
+			expectError:      true,
+		},
+		{
+			name:             "rsync local path with ::",
+			source:           dataSource{Rsync: &rsyncDataSource{Source: "/etc/backup::data/"}},
+			expectedCommands: []string{},
+			expectError:      true,
+		},
+		{
+			name:             "rsync option",
+			source:           dataSource{Rsync: &rsyncDataSource{Source: "--rsh=sh -c id"}},
+			expectedCommands: []string{},
+			expectError:      true,
+		},
+		{
+			name:             "rsync host not allowed",
+			source:           dataSource{Rsync: &rsyncDataSource{Source: "other::data/"}},
+			expectedCommands: []string{},
+			expectError:      true,
+		},
+		{
+			name:             "rsync empty source",
+			source:           dataSource{Rsync: &rsyncDataSource{}},
+			expectedCommands: []string{},
+			expectError:      true,
+		},
+		{
+			name:             "http file",
+			source:           dataSource{HTTP: &httpDataSource{URL: "https://example.com/data/model.bin"}},
+			expectedCommands: []string{"curl -fsS --proto =http,https -o /export/pvc-1/model.bin -- https://example.com/data/model.bin"},
+		},
+		{
+			name:   "http tar archive",
+			source: dataSource{HTTP: &httpDataSource{URL: "https://example.com/data.tar.gz"}},
+			expectedCommands: []string{
+				"curl -fsS --proto =http,https -o /export/pvc-1/data.tar.gz -- https://example.com/data.tar.gz",
+				"tar -xf /export/pvc-1/data.tar.gz -C /export/pvc-1 --no-same-owner",
+			},
+		},
+		{
+			name:             "http host not allowed",
+			source:           dataSource{HTTP: &httpDataSource{URL: "http://169.254.169.254/latest/meta-data/iam"}},
+			expectedCommands: []string{},
+			expectError:      true,
+		},
+		{
+			name:             "http url without file name",
+			source:           dataSource{HTTP: &httpDataSource{URL: "https://example.com/"}},
+			expectedCommands: []string{},
+			expectError:      true,
+		},
+		{
+			name:             "ftp url",
+			source:           dataSource{HTTP: &httpDataSource{URL: "ftp://example.com/data.tar"}},
+			expectedCommands: []string{},
+			expectError:      true,
+		},
+		{
+			name:             "both rsync and http",
+			source:           dataSource{Rsync: &rsyncDataSource{Source: "backup::data/"}, HTTP: &httpDataSource{URL: "https://example.com/data.tar"}},
+			expectedCommands: []string{},
+			expectError:      true,
+		},
+		{
+			name:             "neither rsync nor http",
+			source:           dataSource{},
+			expectedCommands: []string{},
+			expectError:      true,
+		},
+	}
+	defer func(old fs.Filesystem) { fileSystem = old }(fileSystem)
+	defer func(old runner.Runner) { cmdRunner = old }(cmdRunner)
+	p := &nfsProvisioner{dataSourceHosts: map[string]bool{"backup": true, "example.com": true}}
+	for _, test := range tests {
+		fileSystem = fs.NewFake("/export")
+		runErr := test.runErr
+		fakeRunner := &runner.Fake{
+			Run: func(name string, args ...string) ([]byte, error) {
+				return nil, runErr
+			},
+		}
+		cmdRunner = fakeRunner
+
+		err := p.populateVolume(&test.source, "/export/pvc-1")
+
+		evaluate(t, test.name, false, nil, test.expectError, err != nil, "error")
+		evaluate(t, test.name, false, nil, test.expectedCommands, fakeRunner.Commands(), "commands")
+	}
+}
+
+func TestCloneVolume(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name             string
+		namespace        string
+		sourceBound      bool
+		sourceID         string
+		capacity         string
+		expectedCommands []string
+		expectError      bool
+	}{
+		{
+			name:             "clone claim",
+			namespace:        "default",
+			sourceBound:      true,
+			capacity:         "1Ki",
+			expectedCommands: []string{"rsync -aHAX " + tmpDir + "/pvc-1/ " + tmpDir + "/pvc-2"},
+		},
+		{
+			name:             "clone larger",
+			namespace:        "default",
+			sourceBound:      true,
+			capacity:         "2Ki",
+			expectedCommands: []string{"rsync -aHAX " + tmpDir + "/pvc-1/ " + tmpDir + "/pvc-2"},
+		},
+		{
+			name:             "clone claim in another namespace",
+			namespace:        "ns-2",
+			sourceBound:      true,
+			capacity:         "1Ki",
+			expectedCommands: []string{"rsync -aHAX " + tmpDir + "/pvc-1/ " + tmpDir + "/pvc-2"},
+		},
+		{
+			name:             "clone smaller",
+			namespace:        "default",
+			sourceBound:      true,
+			capacity:         "512",
+			expectedCommands: []string{},
+			expectError:      true,
+		},
+		{
+			name:             "claim not bound",
+			namespace:        "default",
+			capacity:         "1Ki",
+			expectedCommands: []string{},
+			expectError:      true,
+		},
+		{
+			name:             "claim's volume provisioned by another provisioner",
+			namespace:        "default",
+			sourceBound:      true,
+			sourceID:         "foo",
+			capacity:         "1Ki",
+			expectedCommands: []string{},
+			expectError:      true,
+		},
+	}
+	defer func(old runner.Runner) { cmdRunner = old }(cmdRunner)
+	for _, test := range tests {
+		fakeRunner := &runner.Fake{}
+		cmdRunner = fakeRunner
+
+		source := newClaim(resource.MustParse("1Ki"), []v1.PersistentVolumeAccessMode{v1.ReadWriteMany}, nil)
+		source.Name = "claim-1"
+		source.Namespace = test.namespace
+		if test.sourceBound {
+			source.Spec.VolumeName = "pvc-1"
+			source.Status.Phase = v1.ClaimBound
+		}
+		client := fake.NewSimpleClientset(source)
+		p := newNFSProvisionerInternal(tmpDir+"/", client, false, &testExporter{}, newDummyQuotaer(), "")
+		sourceID := test.sourceID
+		if sourceID == "" {
+			sourceID = string(p.identity)
+		}
+		client.Core().PersistentVolumes().Create(&v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "pvc-1",
+				Annotations: map[string]string{
+					annProvisionerID: sourceID,
+					annExportRoot:    tmpDir,
+				},
+			},
+			Spec: v1.PersistentVolumeSpec{
+				Capacity: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Ki"),
+				},
+			},
+		})
+
+		err := p.cloneVolume(test.namespace, "claim-1", resource.MustParse(test.capacity), tmpDir+"/pvc-2")
+
+		evaluate(t, test.name, false, nil, test.expectError, err != nil, "error")
+		evaluate(t, test.name, false, nil, test.expectedCommands, fakeRunner.Commands(), "commands")
+	}
+}
+
+func TestShareHandler(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	defer func(old fs.Filesystem) { fileSystem = old }(fileSystem)
+	fakeFs := fs.NewFake(tmpDir)
+	fakeFs.Available = map[string]int64{tmpDir: 2 * 1024 * 1024 * 1024}
+	fileSystem = fakeFs
+
+	p := newNFSProvisionerInternal(tmpDir+"/", nil, true, &testExporter{}, newDummyQuotaer(), "1.1.1.1")
+	handler, err := NewShareHandler(p, tmpDir+"/.shares")
+	if err != nil {
+		t.Fatalf("Error creating share handler: %v", err)
+	}
+
+	share := `{"name":"share-1","size":"1Gi","server":"1.1.1.1","path":"` + tmpDir + `/share-1"}` + "\n"
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "create share",
+			method:         "POST",
+			path:           "/shares",
+			body:           `{"name":"share-1","size":"1Gi"}`,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   share,
+		},
+		{
+			name:           "create existing share",
+			method:         "POST",
+			path:           "/shares",
+			body:           `{"name":"share-1","size":"1Gi"}`,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   "share \"share-1\" already exists\n",
+		},
+		{
+			name:           "create share with invalid name",
+			method:         "POST",
+			path:           "/shares",
+			body:           `{"name":"../share-2","size":"1Gi"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "get share",
+			method:         "GET",
+			path:           "/shares/share-1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   share,
+		},
+		{
+			name:           "list shares",
+			method:         "GET",
+			path:           "/shares",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "[" + strings.TrimSpace(share) + "]\n",
+		},
+		{
+			name:           "delete share",
+			method:         "DELETE",
+			path:           "/shares/share-1",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "get deleted share",
+			method:         "GET",
+			path:           "/shares/share-1",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "share \"share-1\" not found\n",
+		},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		evaluate(t, test.name, false, nil, test.expectedStatus, rec.Code, "status")
+		if test.expectedBody != "" {
+			evaluate(t, test.name, false, nil, test.expectedBody, rec.Body.String(), "body")
+		}
+	}
+}
+
+func TestController(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("nfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name            string
+		exportFailures  int
+		expectedVolumes []string
+		expectedReasons map[string]bool
+	}{
+		{
+			name:            "provision volume for claim",
+			exportFailures:  0,
+			expectedVolumes: []string{"pvc-uid-1"},
+			expectedReasons: map[string]bool{"Provisioning": true, "ProvisioningSucceeded": true},
+		},
+		{
+			name:            "retry provisioning after export fails",
+			exportFailures:  1,
+			expectedVolumes: []string{"pvc-uid-1"},
+			expectedReasons: map[string]bool{"Provisioning": true, "ProvisioningFailed": true, "ProvisioningSucceeded": true},
+		},
+		{
+			name:            "give up provisioning after export keeps failing",
+			exportFailures:  100,
+			expectedVolumes: []string{},
+			expectedReasons: map[string]bool{"Provisioning": true, "ProvisioningFailed": true},
+		},
+	}
+
+	os.Setenv(podIPEnv, "1.1.1.1")
+	defer os.Unsetenv(podIPEnv)
+	defer func(old fs.Filesystem) { fileSystem = old }(fileSystem)
+	for i, test := range tests {
+		exportDir := tmpDir + "/" + strconv.Itoa(i) + "/"
+		os.MkdirAll(exportDir, 0755)
+		fakeFs := fs.NewFake(exportDir)
+		fakeFs.Available = map[string]int64{path.Clean(exportDir): 1024 * 1024 * 1024}
+		fileSystem = fakeFs
+
+		client := fake.NewSimpleClientset(
+			&storagebeta.StorageClass{
+				ObjectMeta:  metav1.ObjectMeta{Name: "class-1"},
+				Provisioner: "example.com/nfs",
+			},
+			&v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "claim-1",
+					Namespace:       v1.NamespaceDefault,
+					UID:             types.UID("uid-1"),
+					ResourceVersion: "0",
+					Annotations:     map[string]string{v1.BetaStorageClassAnnotation: "class-1"},
+					SelfLink:        "/api/v1/namespaces/" + v1.NamespaceDefault + "/persistentvolumeclaims/claim-1",
+				},
+				Spec: newClaim(resource.MustParse("1Mi"), []v1.PersistentVolumeAccessMode{v1.ReadWriteMany}, nil).Spec,
+			},
+		)
+		p := newNFSProvisionerInternal(exportDir, client, false, &flakyExporter{failures: test.exportFailures}, newDummyQuotaer(), "")
+		recorder := record.NewFakeRecorder(1000)
+		ctrl := controller.NewProvisionController(
+			client,
+			"example.com/nfs",
+			p,
+			"v1.5.0",
+			controller.ResyncPeriod(100*time.Millisecond),
+			controller.ExponentialBackOffOnError(false),
+			controller.FailedProvisionThreshold(2),
+			controller.CreateProvisionedPVInterval(10*time.Millisecond),
+			controller.LeaseDuration(200*time.Millisecond),
+			controller.RenewDeadline(100*time.Millisecond),
+			controller.RetryPeriod(50*time.Millisecond),
+			controller.TermLimit(200*time.Millisecond),
+			controller.EventRecorder(recorder))
+		stopCh := make(chan struct{})
+		go ctrl.Run(stopCh)
+
+		volumes := []string{}
+		reasons := map[string]bool{}
+		wait.Poll(20*time.Millisecond, time.Second, func() (bool, error) {
+			pvList, err := client.Core().PersistentVolumes().List(metav1.ListOptions{})
+			if err != nil {
+				return false, err
+			}
+			volumes = []string{}
+			for _, pv := range pvList.Items {
+				volumes = append(volumes, pv.Name)
+			}
+			for len(recorder.Events) > 0 {
+				reasons[strings.Fields(<-recorder.Events)[1]] = true
+			}
+			return reflect.DeepEqual(test.expectedVolumes, volumes) && reflect.DeepEqual(test.expectedReasons, reasons), nil
+		})
+		close(stopCh)
+
+		evaluate(t, test.name, false, nil, test.expectedVolumes, volumes, "volumes")
+		evaluate(t, test.name, false, nil, test.expectedReasons, reasons, "event reasons")
+		for _, volume := range volumes {
+			if fileSystem.(*fs.Fake).Get(path.Join(exportDir, volume)) == nil {
+				t.Logf("test case: %s", test.name)
+				t.Errorf("expected directory of volume %s to exist", volume)
+			}
+		}
+	}
+}
+
+func newClaim(capacity resource.Quantity, accessmodes []v1.PersistentVolumeAccessMode, selector *metav1.LabelSelector) *v1.PersistentVolumeClaim {
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: accessmodes,
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): capacity,
+				},
+			},
+			Selector: selector,
+		},
+		Status: v1.PersistentVolumeClaimStatus{},
+	}
+	return claim
+}
+
+func newClaimPod(name, claimName string, phase v1.PodPhase) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: v1.PodSpec{
+			Volumes: []v1.Volume{
+				{
+					Name: "data",
+					VolumeSource: v1.VolumeSource{
+						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+					},
+				},
+			},
+		},
+		Status: v1.PodStatus{Phase: phase},
+	}
+}
+
+func newService(name, clusterIP string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: clusterIP,
+		},
+	}
+}
+
+func newNode(name string, labels map[string]string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+type endpointPort struct {
+	port     int32
+	protocol v1.Protocol
+}
+
+func newEndpoints(name string, ips []string, ports []endpointPort) *v1.Endpoints {
+	epAddresses := []v1.EndpointAddress{}
+	for _, ip := range ips {
+		epAddresses = append(epAddresses, v1.EndpointAddress{IP: ip, Hostname: "", NodeName: nil, TargetRef: nil})
+	}
+	epPorts := []v1.EndpointPort{}
+	for i, port := range ports {
+		epPorts = append(epPorts, v1.EndpointPort{Name: strconv.Itoa(i), Port: port.port, Protocol: port.protocol})
+	}
+	return &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Subsets: []v1.EndpointSubset{
+			{
+				Addresses:         epAddresses,
+				NotReadyAddresses: []v1.EndpointAddress{},
+				Ports:             epPorts,
+			},
+		},
+	}
+}
+
+type testExporter struct {
+	config string
+}
+
+var _ exporter = &testExporter{}
+
+func (e *testExporter) AddExportBlock(path string, _ bool) (string, uint16, error) {
+	return "\nExport_Id = 0;\n", 0, nil
+}
+
+func (e *testExporter) RemoveExportBlock(block string, exportID uint16) error {
+	return nil
+}
+
+func (e *testExporter) Export(path string) error {
+	if strings.Contains(path, "FAIL_TO_EXPORT_ME") {
+		return errors.New("fake error")
+	}
+	return nil
+}
+
+func (e *testExporter) Unexport(volume *v1.PersistentVolume) error {
+	return nil
+}
+
+// flakyExporter fails the given number of Exports before succeeding.
+type flakyExporter struct {
+	testExporter
+	failures int
+	mutex    sync.Mutex
+}
+
+var _ exporter = &flakyExporter{}
+
+func (e *flakyExporter) Export(path string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.failures > 0 {
+		e.failures--
+		return errors.New("fake error")
+	}
+	return nil
+}
+
+// restoringTestExporter records the IDs of the export blocks removed from and
+// restored to the config.
+type restoringTestExporter struct {
+	testExporter
+	removed  []uint16
+	restored []uint16
+}
+
+var _ exporter = &restoringTestExporter{}
+var _ exportBlockRestorer = &restoringTestExporter{}
+
+func (e *restoringTestExporter) RemoveExportBlock(block string, exportID uint16) error {
+	e.removed = append(e.removed, exportID)
+	return nil
+}
+
+func (e *restoringTestExporter) HasExportBlock(block string) (bool, error) {
+	return true, nil
+}
+
+func (e *restoringTestExporter) RestoreExportBlock(block string, exportID uint16) error {
+	e.restored = append(e.restored, exportID)
+	return nil
+}
+
+// recordingTestQuotaer records the projects added & removed, numbering them
+// from nextID.
+type recordingTestQuotaer struct {
+	dummyQuotaer
+	nextID  uint16
+	err     error
+	added   []string
+	removed []uint16
+}
+
+var _ quotaer = &recordingTestQuotaer{}
+
+func (q *recordingTestQuotaer) AddProject(directory, bhard string) (string, uint16, error) {
+	if q.err != nil {
+		return "", 0, q.err
+	}
+	q.nextID++
+	q.added = append(q.added, directory)
+	return "\n" + strconv.FormatUint(uint64(q.nextID), 10) + ":" + directory + ":" + bhard + "\n", q.nextID, nil
+}
+
+func (q *recordingTestQuotaer) RemoveProject(block string, projectID uint16) error {
+	q.removed = append(q.removed, projectID)
+	return nil
+}
+
+type genericTestExporter struct {
+	genericExporter
+}
+
+var _ exporter = &genericTestExporter{}
+
+func (e *genericTestExporter) Export(path string) error {
+	return nil
+}
+
+func (e *genericTestExporter) Unexport(volume *v1.PersistentVolume) error {
 	return nil
 }
 
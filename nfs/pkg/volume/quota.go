@@ -20,7 +20,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"regexp"
 	"strconv"
@@ -32,10 +31,19 @@ import (
 )
 
 type quotaer interface {
+	// AddProject adds a project for the given directory with the given quota
+	// limit and sets the limit
 	AddProject(string, string) (string, uint16, error)
 	RemoveProject(string, uint16) error
 	SetQuota(uint16, string, string) error
+	ResizeProject(string, uint16, string, string) (string, error)
 	UnsetQuota() error
+	// GetUsage returns the bytes used by each project, by id, from the quota
+	// accounting, without walking the projects' directories
+	GetUsage() (map[uint16]int64, error)
+	// GetInodeUsage returns the inodes used by each project, by id, from the
+	// quota accounting
+	GetInodeUsage() (map[uint16]int64, error)
 }
 
 type xfsQuotaer struct {
@@ -48,13 +56,22 @@ type xfsQuotaer struct {
 
 	projectIDs map[uint16]bool
 
+	// The number of project ids to keep reserved in projectIDs and
+	// initialized ahead of AddProject, so that it only has to assign one, and
+	// those reserved, guarded by mapMutex
+	poolSize    int
+	pool        []uint16
+	fillingPool bool
+
 	mapMutex  *sync.Mutex
 	fileMutex *sync.Mutex
 }
 
 var _ quotaer = &xfsQuotaer{}
 
-func newXfsQuotaer(xfsPath string) (*xfsQuotaer, error) {
+// newXfsQuotaer creates a quotaer of the given XFS filesystem that keeps
+// poolSize project ids reserved and initialized ahead of projects, if not 0.
+func newXfsQuotaer(xfsPath string, poolSize int) (*xfsQuotaer, error) {
 	if _, err := os.Stat(xfsPath); os.IsNotExist(err) {
 		return nil, fmt.Errorf("xfs path %s does not exist", xfsPath)
 	}
@@ -75,7 +92,7 @@ func newXfsQuotaer(xfsPath string) (*xfsQuotaer, error) {
 		return nil, fmt.Errorf("xfs path %s was not mounted with pquota nor prjquota", xfsPath)
 	}
 
-	_, err = exec.LookPath("xfs_quota")
+	_, err = cmdRunner.LookPath("xfs_quota")
 	if err != nil {
 		return nil, err
 	}
@@ -101,6 +118,7 @@ func newXfsQuotaer(xfsPath string) (*xfsQuotaer, error) {
 		xfsPath:      xfsPath,
 		projectsFile: projectsFile,
 		projectIDs:   projectIDs,
+		poolSize:     poolSize,
 		mapMutex:     &sync.Mutex{},
 		fileMutex:    &sync.Mutex{},
 	}
@@ -110,12 +128,16 @@ func newXfsQuotaer(xfsPath string) (*xfsQuotaer, error) {
 		return nil, fmt.Errorf("error restoring quotas from projects file %s: %v", projectsFile, err)
 	}
 
+	// Projects are still added without the pool if it can't be filled
+	if err := xfsQuotaer.fillPool(); err != nil {
+		glog.Errorf("Error filling project id pool: %v", err)
+	}
+
 	return xfsQuotaer, nil
 }
 
 func isXfs(xfsPath string) (bool, error) {
-	cmd := exec.Command("stat", "-f", "-c", "%T", xfsPath)
-	out, err := cmd.Output()
+	out, err := cmdRunner.Output("stat", "-f", "-c", "%T", xfsPath)
 	if err != nil {
 		return false, err
 	}
@@ -168,7 +190,7 @@ func (q *xfsQuotaer) restoreQuotas() error {
 }
 
 func (q *xfsQuotaer) AddProject(directory, bhard string) (string, uint16, error) {
-	projectID := generateID(q.mapMutex, q.projectIDs)
+	projectID := q.takeID()
 	projectIDStr := strconv.FormatUint(uint64(projectID), 10)
 
 	// Store project:directory mapping and also project's quota info
@@ -180,9 +202,8 @@ func (q *xfsQuotaer) AddProject(directory, bhard string) (string, uint16, error)
 		return "", 0, fmt.Errorf("error adding project block %s to projects file %s: %v", block, q.projectsFile, err)
 	}
 
-	// Specify the new project
-	cmd := exec.Command("xfs_quota", "-x", "-c", fmt.Sprintf("project -s -p %s %s", directory, projectIDStr), q.xfsPath)
-	out, err := cmd.CombinedOutput()
+	// Specify the new project and set its limit with the one xfs_quota
+	out, err := cmdRunner.CombinedOutput("xfs_quota", "-x", "-c", fmt.Sprintf("project -s -p %s %s", directory, projectIDStr), "-c", fmt.Sprintf("limit -p bhard=%s %s", bhard, projectIDStr), q.xfsPath)
 	if err != nil {
 		deleteID(q.mapMutex, q.projectIDs, projectID)
 		removeFromFile(q.fileMutex, q.projectsFile, block)
@@ -192,6 +213,69 @@ func (q *xfsQuotaer) AddProject(directory, bhard string) (string, uint16, error)
 	return block, projectID, nil
 }
 
+// takeID takes a project id from the pool, refilling it in the background
+// once it's empty, or generates one if the pool is empty.
+func (q *xfsQuotaer) takeID() uint16 {
+	q.mapMutex.Lock()
+	if len(q.pool) == 0 {
+		q.mapMutex.Unlock()
+		return generateID(q.mapMutex, q.projectIDs)
+	}
+	projectID := q.pool[0]
+	q.pool = q.pool[1:]
+	refill := len(q.pool) == 0 && !q.fillingPool
+	if refill {
+		q.fillingPool = true
+	}
+	q.mapMutex.Unlock()
+
+	if refill {
+		go func() {
+			if err := q.fillPool(); err != nil {
+				glog.Errorf("Error filling project id pool: %v", err)
+			}
+			q.mapMutex.Lock()
+			q.fillingPool = false
+			q.mapMutex.Unlock()
+		}()
+	}
+	return projectID
+}
+
+// fillPool reserves project ids until the pool has poolSize of them and
+// initializes them with a single xfs_quota, clearing any limit left on them
+// by a project that was removed.
+func (q *xfsQuotaer) fillPool() error {
+	q.mapMutex.Lock()
+	n := q.poolSize - len(q.pool)
+	q.mapMutex.Unlock()
+	if n <= 0 {
+		return nil
+	}
+
+	projectIDs := make([]uint16, 0, n)
+	args := []string{"-x"}
+	for i := 0; i < n; i++ {
+		projectID := generateID(q.mapMutex, q.projectIDs)
+		projectIDs = append(projectIDs, projectID)
+		args = append(args, "-c", fmt.Sprintf("limit -p bhard=0 %d", projectID))
+	}
+	args = append(args, q.xfsPath)
+
+	out, err := cmdRunner.CombinedOutput("xfs_quota", args...)
+	if err != nil {
+		for _, projectID := range projectIDs {
+			deleteID(q.mapMutex, q.projectIDs, projectID)
+		}
+		return fmt.Errorf("xfs_quota failed with error: %v, output: %s", err, out)
+	}
+
+	q.mapMutex.Lock()
+	q.pool = append(q.pool, projectIDs...)
+	q.mapMutex.Unlock()
+	return nil
+}
+
 func (q *xfsQuotaer) RemoveProject(block string, projectID uint16) error {
 	deleteID(q.mapMutex, q.projectIDs, projectID)
 	return removeFromFile(q.fileMutex, q.projectsFile, block)
@@ -203,8 +287,7 @@ func (q *xfsQuotaer) SetQuota(projectID uint16, directory, bhard string) error {
 	}
 	projectIDStr := strconv.FormatUint(uint64(projectID), 10)
 
-	cmd := exec.Command("xfs_quota", "-x", "-c", fmt.Sprintf("limit -p bhard=%s %s", bhard, projectIDStr), q.xfsPath)
-	out, err := cmd.CombinedOutput()
+	out, err := cmdRunner.CombinedOutput("xfs_quota", "-x", "-c", fmt.Sprintf("limit -p bhard=%s %s", bhard, projectIDStr), q.xfsPath)
 	if err != nil {
 		return fmt.Errorf("xfs_quota failed with error: %v, output: %s", err, out)
 	}
@@ -212,10 +295,72 @@ func (q *xfsQuotaer) SetQuota(projectID uint16, directory, bhard string) error {
 	return nil
 }
 
+// ResizeProject sets a new quota limit for the given project and records it in
+// the projects file, returning the project's new block.
+func (q *xfsQuotaer) ResizeProject(block string, projectID uint16, directory, bhard string) (string, error) {
+	if err := q.SetQuota(projectID, directory, bhard); err != nil {
+		return "", err
+	}
+
+	projectIDStr := strconv.FormatUint(uint64(projectID), 10)
+	newBlock := "\n" + projectIDStr + ":" + directory + ":" + bhard + "\n"
+	if err := removeFromFile(q.fileMutex, q.projectsFile, block); err != nil {
+		return "", fmt.Errorf("error removing project block %s from projects file %s: %v", block, q.projectsFile, err)
+	}
+	if err := addToFile(q.fileMutex, q.projectsFile, newBlock); err != nil {
+		return "", fmt.Errorf("error adding project block %s to projects file %s: %v", newBlock, q.projectsFile, err)
+	}
+
+	return newBlock, nil
+}
+
 func (q *xfsQuotaer) UnsetQuota() error {
 	return nil
 }
 
+// GetUsage reports the blocks used by every project with a single xfs_quota.
+func (q *xfsQuotaer) GetUsage() (map[uint16]int64, error) {
+	out, err := cmdRunner.CombinedOutput("xfs_quota", "-x", "-c", "report -p -b -N -n", q.xfsPath)
+	if err != nil {
+		return nil, fmt.Errorf("xfs_quota failed with error: %v, output: %s", err, out)
+	}
+	return parseQuotaReport(string(out), 1024)
+}
+
+// GetInodeUsage reports the inodes used by every project with a single
+// xfs_quota.
+func (q *xfsQuotaer) GetInodeUsage() (map[uint16]int64, error) {
+	out, err := cmdRunner.CombinedOutput("xfs_quota", "-x", "-c", "report -p -i -N -n", q.xfsPath)
+	if err != nil {
+		return nil, fmt.Errorf("xfs_quota failed with error: %v, output: %s", err, out)
+	}
+	return parseQuotaReport(string(out), 1)
+}
+
+// parseQuotaReport parses the output of xfs_quota's report -p -N -n with -b or
+// -i, a line per project of its id prefixed with #, then the 1KiB blocks or
+// the inodes it uses, its soft and hard limits and more. Each usage is
+// multiplied by unit.
+func parseQuotaReport(report string, unit int64) (map[uint16]int64, error) {
+	usage := map[uint16]int64{}
+	for _, line := range strings.Split(report, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		projectID, err := strconv.ParseUint(strings.TrimPrefix(fields[0], "#"), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing project id of quota report line %q: %v", line, err)
+		}
+		used, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing usage of quota report line %q: %v", line, err)
+		}
+		usage[uint16(projectID)] = used * unit
+	}
+	return usage, nil
+}
+
 type dummyQuotaer struct{}
 
 var _ quotaer = &dummyQuotaer{}
@@ -233,6 +378,15 @@ func (q *dummyQuotaer) RemoveProject(_ string, _ uint16) error {
 func (q *dummyQuotaer) SetQuota(_ uint16, _, _ string) error {
 	return nil
 }
+func (q *dummyQuotaer) ResizeProject(block string, _ uint16, _, _ string) (string, error) {
+	return block, nil
+}
 func (q *dummyQuotaer) UnsetQuota() error {
 	return nil
 }
+func (q *dummyQuotaer) GetUsage() (map[uint16]int64, error) {
+	return map[uint16]int64{}, nil
+}
+func (q *dummyQuotaer) GetInodeUsage() (map[uint16]int64, error) {
+	return map[uint16]int64{}, nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/helper"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// ReadReplicaDir is the directory in exportDir a provisioner serving a
+	// read replica receives the replicated volumes in and exports read-only
+	ReadReplicaDir = "read-replica"
+
+	// A PV annotation naming the read-only PV pointing at the read replica
+	// of the volume, set if its class has the parameter readReplica "true"
+	annReadReplica = "Read_Replica"
+
+	// A label of a read-only PV naming the PV it is the read replica of, for
+	// claims to select it by
+	LabelReadReplicaOf = "nfs-provisioner/read-replica-of"
+)
+
+// ReadReplicator is implemented by provisioners that can keep a read-only copy
+// of the volumes requesting one on another server, e.g. to spread the reads
+// of a dataset many pods mount between servers.
+type ReadReplicator interface {
+	// ReplicateReads periodically copies the data of the volumes requesting a
+	// read replica to target, until stopCh is closed.
+	ReplicateReads(target string, period time.Duration, stopCh <-chan struct{})
+}
+
+var _ ReadReplicator = &nfsProvisioner{}
+
+// ReplicateReads periodically rsyncs the backing directories of the PVs this
+// provisioner provisioned that are annotated with annReadReplica to target,
+// e.g. rsync://nfs-read-replica/export, deleting those of PVs deleted since,
+// then creates each PV's read-only PV pointing at readReplicaServer if it
+// doesn't exist yet, so that claims only bind the replica once it's synced.
+func (p *nfsProvisioner) ReplicateReads(target string, period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := p.replicateReads(target); err != nil {
+			glog.Errorf("Error replicating reads to %s: %v", target, err)
+		}
+	}, period, stopCh)
+}
+
+func (p *nfsProvisioner) replicateReads(target string) error {
+	if err := p.checkEpoch(); err != nil {
+		return err
+	}
+
+	home := p.shared()
+	replicated := make(map[*nfsProvisioner][]*v1.PersistentVolume)
+	count := 0
+	// Only the replicated directories and their parents, e.g. namespace
+	// directories, are included, and everything else is deleted from target
+	args := []string{"-aHAX", "--delete", "--delete-excluded"}
+	for _, cluster := range append([]*nfsProvisioner{home}, home.clusters...) {
+		volumes, err := cluster.client.Core().PersistentVolumes().List(metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("error listing PVs: %v", err)
+		}
+		for i := range volumes.Items {
+			volume := &volumes.Items[i]
+			if _, ok := volume.Annotations[annReadReplica]; !ok {
+				continue
+			}
+			if provisioned, err := p.provisioned(volume); err != nil || !provisioned {
+				continue
+			}
+			replicated[cluster] = append(replicated[cluster], volume)
+			count++
+			_, directory := p.getRootAndDirectory(volume)
+			for parent := path.Dir(directory); parent != "."; parent = path.Dir(parent) {
+				args = append(args, "--include", "/"+parent+"/")
+			}
+			args = append(args, "--include", "/"+directory+"/***")
+		}
+	}
+	args = append(args, "--exclude", "*", strings.TrimSuffix(p.exportDir, "/")+"/", target)
+
+	start := time.Now()
+	out, err := cmdRunner.CombinedOutput("rsync", args...)
+	if err != nil {
+		return fmt.Errorf("rsync failed with error: %v, output: %s", err, out)
+	}
+	glog.V(4).Infof("Replicated reads of %d volumes to %s in %v", count, target, time.Since(start))
+
+	for cluster, volumes := range replicated {
+		for _, volume := range volumes {
+			if err := cluster.createReadReplica(volume); err != nil {
+				glog.Errorf("Error creating read replica of volume %q: %v", volume.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// createReadReplica creates the read-only PV named by the given PV's
+// annReadReplica annotation, pointing at the copy of its backing directory on
+// readReplicaServer, unless it already exists. It gets the PV's class and
+// capacity but the ReadOnlyMany access mode and the Retain reclaim policy,
+// since the provisioner deletes it along with the PV.
+func (p *nfsProvisioner) createReadReplica(volume *v1.PersistentVolume) error {
+	name := volume.Annotations[annReadReplica]
+	if _, err := p.client.Core().PersistentVolumes().Get(name, metav1.GetOptions{}); err == nil {
+		return nil
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+
+	annotations := map[string]string{
+		annCreatedBy:     createdBy,
+		annProvisionerID: string(p.identity),
+	}
+	if mountOptions, ok := volume.Annotations[MountOptionAnnotation]; ok {
+		annotations[MountOptionAnnotation] = mountOptions
+	}
+	_, directory := p.getRootAndDirectory(volume)
+	replica := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{LabelReadReplicaOf: volume.Name},
+			Annotations: annotations,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimRetain,
+			AccessModes:                   []v1.PersistentVolumeAccessMode{v1.ReadOnlyMany},
+			Capacity:                      volume.Spec.Capacity,
+			StorageClassName:              helper.GetPersistentVolumeClass(volume),
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				NFS: &v1.NFSVolumeSource{
+					Server:   p.readReplicaServer,
+					Path:     path.Join(p.exportDir, ReadReplicaDir, directory),
+					ReadOnly: true,
+				},
+			},
+		},
+	}
+	if _, err := p.client.Core().PersistentVolumes().Create(replica); err != nil {
+		return err
+	}
+	glog.Infof("Created read replica %q of volume %q", name, volume.Name)
+	return nil
+}
+
+// deleteReadReplica deletes the read-only PV of the given PV, if it has one.
+// Its copy of the data is deleted from the read replica by the next
+// replication.
+func (p *nfsProvisioner) deleteReadReplica(volume *v1.PersistentVolume) error {
+	name, ok := volume.Annotations[annReadReplica]
+	if !ok {
+		return nil
+	}
+	err := p.client.Core().PersistentVolumes().Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
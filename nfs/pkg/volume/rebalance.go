@@ -0,0 +1,454 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// A PV annotation set by an admin to request that the PV's backing
+	// directory be migrated to the export root it names
+	AnnMigrateTo = "nfs-provisioner/migrate-to"
+
+	// A PV annotation set by the rebalancer when a requested migration fails
+	AnnMigrateError = "nfs-provisioner/migrate-error"
+
+	// Directory in each export root volumes being migrated to it are copied
+	// in, until they're moved to their own directory
+	migratingDir = ".migrating"
+
+	// Directory in each export root the old directories of volumes migrated
+	// from it are moved aside to, until they're removed
+	migratedDir = ".migrated"
+)
+
+// Rebalancer is implemented by provisioners that can migrate the volumes they
+// provisioned between their export roots.
+type Rebalancer interface {
+	// Rebalance periodically migrates the volumes that have been requested to
+	// be migrated, until stopCh is closed.
+	Rebalance(period time.Duration, stopCh <-chan struct{})
+}
+
+var _ Rebalancer = &nfsProvisioner{}
+
+// Rebalance periodically migrates each PV this provisioner provisioned that
+// is annotated with AnnMigrateTo to the export root it names, once no pod is
+// using the PV's claim. Until then, its data is copied to the root each
+// period, so that the pods are only kept from it while what changed since the
+// last copy is copied.
+func (p *nfsProvisioner) Rebalance(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(p.rebalance, period, stopCh)
+}
+
+func (p *nfsProvisioner) rebalance() {
+	volumes, err := p.client.Core().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		glog.Errorf("Error listing PVs to rebalance: %v", err)
+		return
+	}
+	for i := range volumes.Items {
+		volume := &volumes.Items[i]
+		root, ok := volume.Annotations[AnnMigrateTo]
+		if !ok {
+			continue
+		}
+		if provisioned, err := p.provisioned(volume); err != nil || !provisioned {
+			continue
+		}
+		if err := p.migrateVolume(volume, root); err != nil {
+			glog.Errorf("Error migrating volume %q to export root %s: %v", volume.Name, root, err)
+			p.setMigrateError(volume, err)
+		}
+	}
+}
+
+// migrateVolume quiesces the given PV by removing its export, copies its
+// backing directory to the given export root, and bind mounts the copy at the
+// path the volume is exported at, which its PV points at and which can't be
+// changed, then exports it again and removes the old directory. If the PV is
+// in use, it only copies the directory to migratingDir in the root, to be
+// moved into place once it isn't.
+func (p *nfsProvisioner) migrateVolume(volume *v1.PersistentVolume, root string) error {
+	oldRoot, ok := volume.Annotations[annExportRoot]
+	if !ok {
+		oldRoot = p.exportDir
+	}
+	if path.Clean(root) == path.Clean(oldRoot) {
+		return p.finishMigration(volume)
+	}
+	if !p.isExportRoot(root) {
+		return fmt.Errorf("%s is not one of this provisioner's export roots", root)
+	}
+
+	oldBlock, oldExportID, err := getBlockAndID(volume, annExportBlock, annExportID)
+	if err != nil {
+		return fmt.Errorf("error getting block &/or id from annotations: %v", err)
+	}
+	if oldBlock == "" {
+		// Exported by an external server or a consolidated export, which
+		// can't be removed to quiesce the volume alone
+		return fmt.Errorf("volume isn't exported on its own, so it can't be quiesced to be migrated")
+	}
+	restorer, ok := p.exporter.(exportBlockRestorer)
+	if !ok {
+		return fmt.Errorf("the exporter can't restore the volume's export if migrating it fails")
+	}
+	oldProjectBlock, oldProjectID, err := getBlockAndID(volume, annProjectBlock, annProjectID)
+	if err != nil {
+		return fmt.Errorf("error getting block &/or id from annotations: %v", err)
+	}
+
+	namespaceDir := volume.Annotations[annNamespaceDirectory]
+	directory := path.Join(namespaceDir, getDirectoryName(volume))
+	oldPath := path.Join(oldRoot, directory)
+	newPath := path.Join(root, directory)
+	exportPath := p.getExportPath(volume)
+	staging := path.Join(root, migratingDir, directory)
+	// A volume migrated back to the root it was created in is exported from
+	// its directory again, which is the mount point until then
+	if newPath != exportPath {
+		if _, err := fileSystem.Stat(newPath); !os.IsNotExist(err) {
+			return fmt.Errorf("the path %s already exists", newPath)
+		}
+	}
+
+	// The space is only checked before the first copy, since what's been
+	// copied already takes up the space the check is for
+	capacity := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	if _, err := fileSystem.Stat(staging); os.IsNotExist(err) {
+		available, err := getAvailableBytes(root)
+		if err != nil {
+			return err
+		}
+		if capacity.Value() > available {
+			return fmt.Errorf("insufficient available space %v bytes in export root %s for volume of %v bytes", available, root, capacity.Value())
+		}
+	}
+
+	inUse, err := p.claimInUse(volume)
+	if err != nil {
+		return fmt.Errorf("error determining if the volume is in use: %v", err)
+	}
+	if inUse {
+		// The data is copied ahead while the volume is in use, so that only
+		// what changes until it isn't is left to copy then
+		if err := copyForMigration(oldPath, staging); err != nil {
+			return fmt.Errorf("error pre-copying the volume while in use: %v", err)
+		}
+		glog.Infof("Volume %q is in use, pre-copied it to %s but not migrating it to export root %s until it isn't", volume.Name, staging, root)
+		return nil
+	}
+
+	if namespaceDir != "" {
+		if err := p.createNamespaceDirectory(root, namespaceDir); err != nil {
+			return fmt.Errorf("error creating namespace directory: %v", err)
+		}
+	}
+
+	clone, err := api.Scheme.DeepCopy(volume)
+	if err != nil {
+		return fmt.Errorf("error cloning volume: %v", err)
+	}
+	oldVolume := clone.(*v1.PersistentVolume)
+
+	if err := p.checkEpoch(); err != nil {
+		return err
+	}
+	// The volume is quiesced by removing its export before the final copy,
+	// so that a pod that started using it since it was checked can't write
+	// to it while it's copied and moved; what such a pod wrote before is
+	// copied. If migrating fails, the export is restored
+	glog.Infof("Migrating volume %q from %s to %s", volume.Name, oldPath, newPath)
+	if err := p.exporter.RemoveExportBlock(oldBlock, oldExportID); err != nil {
+		return fmt.Errorf("error removing the old export from the config file to quiesce the volume: %v", err)
+	}
+	if err := p.exporter.Unexport(oldVolume); err != nil {
+		p.resumeExport(restorer, oldVolume, oldBlock, oldExportID, exportPath)
+		return fmt.Errorf("error unexporting the old export to quiesce the volume: %v", err)
+	}
+
+	if err := copyForMigration(oldPath, staging); err != nil {
+		p.resumeExport(restorer, oldVolume, oldBlock, oldExportID, exportPath)
+		return err
+	}
+
+	m := &migration{p: p, volume: oldVolume, oldPath: oldPath, newPath: newPath, exportPath: exportPath, staging: staging,
+		trash: path.Join(oldRoot, migratedDir, directory)}
+	if err := p.checkEpoch(); err != nil {
+		m.undo(restorer, oldBlock, oldExportID)
+		return err
+	}
+	if err := m.swap(); err != nil {
+		m.undo(restorer, oldBlock, oldExportID)
+		return err
+	}
+	// The old directory's quota project is only removed once the PV records
+	// the new one, so that deleting the volume removes whichever it records
+	projectBlock, projectID, err := p.createQuota(root, directory, capacity)
+	if err != nil {
+		m.undo(restorer, oldBlock, oldExportID)
+		return fmt.Errorf("error creating quota for the new backing directory: %v", err)
+	}
+	p.resumeExport(restorer, oldVolume, oldBlock, oldExportID, exportPath)
+
+	volume.Annotations[annExportRoot] = root
+	volume.Annotations[annProjectBlock] = projectBlock
+	volume.Annotations[annProjectID] = strconv.FormatUint(uint64(projectID), 10)
+	if newPath == exportPath {
+		delete(volume.Annotations, annExportPath)
+	} else {
+		volume.Annotations[annExportPath] = exportPath
+	}
+	delete(volume.Annotations, AnnMigrateTo)
+	delete(volume.Annotations, AnnMigrateError)
+	if _, err := p.client.Core().PersistentVolumes().Update(volume); err != nil {
+		p.exporter.RemoveExportBlock(oldBlock, oldExportID)
+		p.exporter.Unexport(oldVolume)
+		p.quotaer.RemoveProject(projectBlock, projectID)
+		m.undo(restorer, oldBlock, oldExportID)
+		return fmt.Errorf("error updating PV to record its new backing directory %s: %v", newPath, err)
+	}
+
+	if err := p.quotaer.RemoveProject(oldProjectBlock, oldProjectID); err != nil {
+		return fmt.Errorf("migrated volume but error removing the old backing path's quota project: %v", err)
+	}
+
+	old := oldPath
+	if m.movedOld {
+		old = m.trash
+	}
+	if err := fileSystem.RemoveAll(old); err != nil {
+		return fmt.Errorf("migrated volume but error deleting the old backing path: %v", err)
+	}
+
+	if isPreallocated(oldRoot, directory) {
+		if err := preallocate(root, directory, capacity.Value()); err != nil {
+			return fmt.Errorf("migrated volume but error preallocating its capacity: %v", err)
+		}
+		if err := releasePreallocation(oldRoot, directory); err != nil {
+			return fmt.Errorf("migrated volume but error releasing its old preallocated capacity: %v", err)
+		}
+	}
+
+	glog.Infof("Migrated volume %q to export root %s", volume.Name, root)
+	return nil
+}
+
+// migration is the final step of migrating a quiesced volume whose data has
+// been copied to staging: swapping the copy in at the volume's export path in
+// place of the old directory, and undoing that if migrating fails.
+type migration struct {
+	p      *nfsProvisioner
+	volume *v1.PersistentVolume
+	// The old and new backing directories, and the path the volume is
+	// exported at, which is oldPath unless it was migrated before
+	oldPath    string
+	newPath    string
+	exportPath string
+	staging    string
+	// Where oldPath is moved aside to if it is exportPath, so that the new
+	// directory can be bind mounted there
+	trash string
+
+	// The steps of swap done so far
+	detached bool
+	movedOld bool
+	placed   bool
+	bound    bool
+}
+
+// swap moves the copy in staging to newPath and makes it the directory at
+// exportPath: by moving it there if newPath is exportPath, and otherwise by
+// bind mounting it there, after moving the old directory aside or unmounting
+// it.
+func (m *migration) swap() error {
+	if m.exportPath == m.oldPath {
+		if err := fileSystem.MkdirAll(path.Dir(m.trash), 0700); err != nil {
+			return fmt.Errorf("error creating directory for %s: %v", m.trash, err)
+		}
+		if err := fileSystem.Rename(m.oldPath, m.trash); err != nil {
+			return fmt.Errorf("error moving %s aside to %s: %v", m.oldPath, m.trash, err)
+		}
+		m.movedOld = true
+	} else {
+		if err := m.p.unbindMount(m.exportPath); err != nil {
+			return err
+		}
+		m.detached = true
+	}
+
+	if m.newPath == m.exportPath {
+		// The mount point is empty once unmounted
+		if err := fileSystem.RemoveAll(m.exportPath); err != nil {
+			return fmt.Errorf("error removing mount point %s: %v", m.exportPath, err)
+		}
+	}
+	if err := fileSystem.Rename(m.staging, m.newPath); err != nil {
+		return fmt.Errorf("error moving %s to %s: %v", m.staging, m.newPath, err)
+	}
+	m.placed = true
+	if m.newPath == m.exportPath {
+		return nil
+	}
+
+	if err := fileSystem.MkdirAll(m.exportPath, 0755); err != nil {
+		return fmt.Errorf("error creating mount point %s: %v", m.exportPath, err)
+	}
+	if err := m.p.bindMount(m.newPath, m.exportPath); err != nil {
+		return err
+	}
+	m.bound = true
+	return nil
+}
+
+// undo undoes what swap did and exports the volume from its old directory
+// again.
+func (m *migration) undo(restorer exportBlockRestorer, block string, exportID uint16) {
+	if m.bound {
+		if err := m.p.unbindMount(m.exportPath); err != nil {
+			glog.Errorf("Error undoing migration of volume %q: %v", m.volume.Name, err)
+			return
+		}
+	}
+	if m.placed {
+		// The copy is kept for the next attempt
+		if err := fileSystem.Rename(m.newPath, m.staging); err != nil {
+			fileSystem.RemoveAll(m.newPath)
+		}
+	}
+	if m.movedOld {
+		fileSystem.RemoveAll(m.oldPath)
+		if err := fileSystem.Rename(m.trash, m.oldPath); err != nil {
+			glog.Errorf("Error moving %s back to %s after failing to migrate volume %q: %v", m.trash, m.oldPath, m.volume.Name, err)
+			return
+		}
+	}
+	if m.detached {
+		fileSystem.MkdirAll(m.exportPath, 0755)
+		if err := m.p.bindMount(m.oldPath, m.exportPath); err != nil {
+			glog.Errorf("Error undoing migration of volume %q: %v", m.volume.Name, err)
+			return
+		}
+	}
+	m.p.resumeExport(restorer, m.volume, block, exportID, m.exportPath)
+}
+
+// resumeExport restores the given PV's export at its old path after
+// quiescing it for a migration that failed.
+func (p *nfsProvisioner) resumeExport(restorer exportBlockRestorer, volume *v1.PersistentVolume, block string, exportID uint16, oldPath string) {
+	if err := restorer.RestoreExportBlock(block, exportID); err != nil {
+		glog.Errorf("Error restoring export of volume %q after failing to migrate it: %v", volume.Name, err)
+		return
+	}
+	if err := p.exporter.Export(oldPath); err != nil {
+		glog.Errorf("Error exporting volume %q again after failing to migrate it: %v", volume.Name, err)
+	}
+}
+
+// copyForMigration copies the given backing directory to the given staging
+// directory, or only what changed if it was copied before, deleting what was
+// deleted since.
+func copyForMigration(oldPath, staging string) error {
+	if err := fileSystem.MkdirAll(staging, 0700); err != nil {
+		return fmt.Errorf("error creating staging directory for %s: %v", staging, err)
+	}
+	out, err := cmdRunner.CombinedOutput("rsync", "-aHAX", "--delete", oldPath+"/", staging)
+	if err != nil {
+		return fmt.Errorf("rsync failed with error: %v, output: %s", err, out)
+	}
+	return nil
+}
+
+// finishMigration removes the migration annotations from a PV already in the
+// requested export root.
+func (p *nfsProvisioner) finishMigration(volume *v1.PersistentVolume) error {
+	delete(volume.Annotations, AnnMigrateTo)
+	delete(volume.Annotations, AnnMigrateError)
+	_, err := p.client.Core().PersistentVolumes().Update(volume)
+	return err
+}
+
+func (p *nfsProvisioner) setMigrateError(volume *v1.PersistentVolume, migrateErr error) {
+	latest, err := p.client.Core().PersistentVolumes().Get(volume.Name, metav1.GetOptions{})
+	if err != nil {
+		glog.Errorf("Error getting volume %q to record migration error: %v", volume.Name, err)
+		return
+	}
+	latest.Annotations[AnnMigrateError] = migrateErr.Error()
+	if _, err := p.client.Core().PersistentVolumes().Update(latest); err != nil {
+		glog.Errorf("Error recording migration error on volume %q: %v", volume.Name, err)
+	}
+}
+
+// isExportRoot returns whether the given directory is one of the export roots
+// this provisioner creates volumes in.
+func (p *nfsProvisioner) isExportRoot(root string) bool {
+	for _, exportRoot := range p.exportRoots {
+		if path.Clean(exportRoot) == path.Clean(root) {
+			return true
+		}
+	}
+	for _, exportRoot := range p.classExportRoots {
+		if path.Clean(exportRoot) == path.Clean(root) {
+			return true
+		}
+	}
+	return false
+}
+
+// claimInUse returns whether any pod that hasn't terminated uses the claim
+// bound to the given PV.
+func (p *nfsProvisioner) claimInUse(volume *v1.PersistentVolume) (bool, error) {
+	claimRef := volume.Spec.ClaimRef
+	if claimRef == nil {
+		return false, nil
+	}
+	pods, err := p.client.Core().Pods(claimRef.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		for _, podVolume := range pod.Spec.Volumes {
+			if podVolume.PersistentVolumeClaim != nil && podVolume.PersistentVolumeClaim.ClaimName == claimRef.Name {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// blockSquashesRoot returns whether the given export block squashes root.
+func blockSquashesRoot(block string) bool {
+	return strings.Contains(block, "root_squash") && !strings.Contains(block, "no_root_squash") ||
+		strings.Contains(block, "root_id_squash")
+}
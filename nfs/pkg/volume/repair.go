@@ -0,0 +1,159 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// ExportRepairer is implemented by provisioners that can detect and repair
+// the exports of the volumes they provisioned.
+type ExportRepairer interface {
+	// RepairExports periodically checks and repairs the exports of the
+	// volumes, until stopCh is closed.
+	RepairExports(period time.Duration, stopCh <-chan struct{})
+	// RestoreExports checks and repairs the exports of the volumes once,
+	// e.g. when taking over serving them from another replica.
+	RestoreExports()
+}
+
+var _ ExportRepairer = &nfsProvisioner{}
+
+// RepairExports periodically checks each PV this provisioner provisioned for
+// conditions that make clients get stale file handles: its backing directory
+// missing or its export block, and so its persisted fsid, missing from the
+// config e.g. after the config was replaced by a restart. Missing export blocks
+// are restored from the PV and re-exported. Events on the PV describe what was
+// found and fixed.
+func (p *nfsProvisioner) RepairExports(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(p.repairExports, period, stopCh)
+}
+
+// RestoreExports checks and repairs the exports of each PV this provisioner
+// provisioned once, restoring the export blocks missing from the config, e.g.
+// one local to the server replica that just took over serving them, from the
+// PVs.
+func (p *nfsProvisioner) RestoreExports() {
+	p.repairExports()
+}
+
+func (p *nfsProvisioner) repairExports() {
+	restorer, ok := p.exporter.(exportBlockRestorer)
+	if !ok {
+		return
+	}
+
+	volumes, err := p.client.Core().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		glog.Errorf("Error listing PVs to repair exports of: %v", err)
+		return
+	}
+	for i := range volumes.Items {
+		volume := &volumes.Items[i]
+		if provisioned, err := p.provisioned(volume); err != nil || !provisioned {
+			continue
+		}
+		if err := p.repairExport(restorer, volume); err != nil {
+			glog.Errorf("Error repairing export of volume %q: %v", volume.Name, err)
+			p.getEventRecorder().Event(volume, v1.EventTypeWarning, "ExportRepairFailed", err.Error())
+		}
+	}
+}
+
+// repairExport checks the given PV's backing directory exists and restores
+// its export block, with the persisted fsid, if it is missing.
+func (p *nfsProvisioner) repairExport(restorer exportBlockRestorer, volume *v1.PersistentVolume) error {
+	directory := p.getDirectory(volume)
+	if _, err := fileSystem.Stat(directory); os.IsNotExist(err) {
+		return fmt.Errorf("backing directory %s is missing, clients will get stale file handles; the volume must be restored or deleted", directory)
+	}
+
+	block, exportID, err := getBlockAndID(volume, annExportBlock, annExportID)
+	if err != nil {
+		return fmt.Errorf("error getting block &/or id from annotations: %v", err)
+	}
+	if block == "" {
+		// Exported by an external server or a consolidated export
+		return nil
+	}
+
+	found, err := restorer.HasExportBlock(block)
+	if err != nil {
+		return fmt.Errorf("error checking for export block: %v", err)
+	}
+	if found {
+		return nil
+	}
+
+	if err := p.checkEpoch(); err != nil {
+		return err
+	}
+	if err := restorer.RestoreExportBlock(block, exportID); err != nil {
+		return fmt.Errorf("export block with fsid %d is missing and error restoring it: %v", exportID, err)
+	}
+	if err := p.exporter.Export(p.getExportPath(volume)); err != nil {
+		return fmt.Errorf("restored missing export block with fsid %d but error exporting it: %v", exportID, err)
+	}
+
+	msg := fmt.Sprintf("Restored missing export of %s with its persisted fsid %d", directory, exportID)
+	glog.Infof("Volume %q: %s", volume.Name, msg)
+	p.getEventRecorder().Event(volume, v1.EventTypeNormal, "ExportRepaired", msg)
+	return nil
+}
+
+// ExportVerifier is implemented by provisioners that can verify the NFS
+// server's live export table against their export config.
+type ExportVerifier interface {
+	// VerifyExports periodically verifies the live export table and repairs
+	// it, until stopCh is closed.
+	VerifyExports(period time.Duration, stopCh <-chan struct{})
+}
+
+var _ ExportVerifier = &nfsProvisioner{}
+
+// kernelExportVerifier is implemented by exporters whose exports can be
+// dropped from the live export table while remaining in their config.
+type kernelExportVerifier interface {
+	VerifyExports() ([]string, error)
+}
+
+// VerifyExports periodically compares the kernel NFS server's live export
+// table with /etc/exports and the exports dir, re-applying entries missing
+// from the former, e.g. because they were dropped when mountd restarted. Does
+// nothing if the provisioner isn't using the kernel NFS server.
+func (p *nfsProvisioner) VerifyExports(period time.Duration, stopCh <-chan struct{}) {
+	verifier, ok := p.exporter.(kernelExportVerifier)
+	if !ok {
+		glog.Infof("Exporter doesn't use the kernel NFS server, not verifying its export table")
+		return
+	}
+	wait.Until(func() {
+		missing, err := verifier.VerifyExports()
+		if err != nil {
+			glog.Errorf("Error verifying the kernel's export table: %v", err)
+		} else if len(missing) > 0 {
+			glog.Warningf("Re-exported paths %v that were missing from the kernel's export table", missing)
+		}
+	}, period, stopCh)
+}
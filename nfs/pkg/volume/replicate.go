@@ -0,0 +1,73 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// replicationExcludes are the files in exportDir that are not replicated,
+// since they are the state of the running server rather than of the volumes
+var replicationExcludes = []string{"/ganesha.log", "/" + healthProbeFile}
+
+// Replicator is implemented by provisioners that can replicate the data of
+// the volumes they provision to a standby, e.g. one with storage of its own
+// to take over with if they are lost.
+type Replicator interface {
+	// Replicate periodically copies the volumes' data to target, until
+	// stopCh is closed.
+	Replicate(target string, period time.Duration, stopCh <-chan struct{})
+}
+
+var _ Replicator = &nfsProvisioner{}
+
+// Replicate periodically rsyncs exportDir, i.e. the volumes' data along with
+// the export config, to target, e.g. rsync://nfs-standby/export, deleting
+// what was deleted since, so that a standby serving target can take over
+// having lost at most one period of changes. Each run is waited for before the
+// next period starts.
+func (p *nfsProvisioner) Replicate(target string, period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := p.replicate(target); err != nil {
+			glog.Errorf("Error replicating to %s: %v", target, err)
+		}
+	}, period, stopCh)
+}
+
+func (p *nfsProvisioner) replicate(target string) error {
+	if err := p.checkEpoch(); err != nil {
+		return err
+	}
+	args := []string{"-aHAX", "--delete"}
+	for _, exclude := range replicationExcludes {
+		args = append(args, "--exclude", exclude)
+	}
+	args = append(args, strings.TrimSuffix(p.exportDir, "/")+"/", target)
+
+	start := time.Now()
+	out, err := cmdRunner.CombinedOutput("rsync", args...)
+	if err != nil {
+		return fmt.Errorf("rsync failed with error: %v, output: %s", err, out)
+	}
+	glog.V(4).Infof("Replicated %s to %s in %v", p.exportDir, target, time.Since(start))
+	return nil
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How far ahead next looks for a time matching a schedule before giving up,
+// e.g. for one of February 30
+const scheduleHorizon = 5
+
+// The bounds of the values of each field of a schedule. Day of week 7 is
+// Sunday like 0
+var scheduleFieldBounds = []struct{ min, max uint }{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 7},  // day of week
+}
+
+// schedule is a cron schedule of the five standard fields: minute, hour, day
+// of month, month and day of week, each a bitset of the values it matches.
+// Like cron, a time matches the days if it matches either of day of month and
+// day of week, unless one of them is unrestricted, i.e. starts with '*'.
+type schedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+// parseSchedule parses a cron schedule like "0 2 * * *". Each field is '*',
+// a value, a range like "1-5", either optionally followed by a step like
+// "*/15", or a comma-separated list of those.
+func parseSchedule(spec string) (*schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(scheduleFieldBounds) {
+		return nil, fmt.Errorf("schedule %q doesn't have the 5 fields minute, hour, day of month, month and day of week", spec)
+	}
+	var bits [5]uint64
+	for i, field := range fields {
+		var err error
+		bits[i], err = parseScheduleField(field, scheduleFieldBounds[i].min, scheduleFieldBounds[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing schedule %q: %v", spec, err)
+		}
+	}
+	dow := bits[4]
+	if dow&(1<<7) != 0 {
+		dow |= 1
+	}
+	return &schedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     dow,
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func parseScheduleField(field string, min, max uint) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		values, step := part, uint64(1)
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.ParseUint(part[i+1:], 10, 8)
+			if err != nil || step == 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			values = part[:i]
+		}
+		low, high := uint64(min), uint64(max)
+		if values != "*" {
+			bounds := strings.SplitN(values, "-", 2)
+			var err error
+			low, err = strconv.ParseUint(bounds[0], 10, 8)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			high = low
+			if len(bounds) == 2 {
+				high, err = strconv.ParseUint(bounds[1], 10, 8)
+				if err != nil {
+					return 0, fmt.Errorf("invalid value in %q", part)
+				}
+			} else if step > 1 {
+				// A value with a step, like "5/15", steps to the maximum
+				high = uint64(max)
+			}
+		}
+		if low < uint64(min) || high > uint64(max) || low > high {
+			return 0, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first time after t, to the minute, that the schedule
+// matches, in t's location, or the zero time if none does within
+// scheduleHorizon years.
+func (s *schedule) next(t time.Time) time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, t.Location()).Add(time.Minute)
+	horizon := t.AddDate(scheduleHorizon, 0, 0)
+	for t.Before(horizon) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// ValidateSchedule returns an error if the given cron schedule can't be parsed.
+func ValidateSchedule(spec string) error {
+	_, err := parseSchedule(spec)
+	return err
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// The number of problems found in a volume listed in its ScrubFailed event,
+// the rest only being counted
+const maxScrubProblems = 5
+
+// Scrubber is implemented by provisioners that can check the data of the
+// volumes they provisioned is still readable.
+type Scrubber interface {
+	// ScrubVolumes scrubs the volumes each time the given cron schedule comes
+	// round, until stopCh is closed.
+	ScrubVolumes(schedule string, stopCh <-chan struct{})
+}
+
+var _ Scrubber = &nfsProvisioner{}
+
+// ScrubVolumes walks the backing directory of each PV this provisioner
+// provisioned each time the given cron schedule, in UTC, comes round, e.g.
+// "0 3 * * *" to scrub during the idle hours of the night, reading every file
+// in full, and records a ScrubFailed Warning event on each PV with files or
+// directories that can't be read, so that corruption is found before its users
+// hit it. On filesystems that checksum data, like btrfs and ZFS, reading also
+// verifies the checksums. A scrub that outlasts the schedule's next time isn't
+// interrupted; the times it outlasts are skipped.
+func (p *nfsProvisioner) ScrubVolumes(spec string, stopCh <-chan struct{}) {
+	schedule, err := parseSchedule(spec)
+	if err != nil {
+		glog.Errorf("Error parsing scrub schedule: %v", err)
+		return
+	}
+	next := schedule.next(time.Now().UTC())
+	wait.Until(func() {
+		if next.IsZero() || time.Now().Before(next) {
+			return
+		}
+		p.scrubVolumes(stopCh)
+		next = schedule.next(time.Now().UTC())
+	}, time.Minute, stopCh)
+}
+
+func (p *nfsProvisioner) scrubVolumes(stopCh <-chan struct{}) {
+	start := time.Now()
+	scrubbed, failed := 0, 0
+	for _, cluster := range append([]*nfsProvisioner{p}, p.clusters...) {
+		volumes, err := cluster.client.Core().PersistentVolumes().List(metav1.ListOptions{})
+		if err != nil {
+			glog.Errorf("Error listing PVs to scrub: %v", err)
+			return
+		}
+		for i := range volumes.Items {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+			volume := &volumes.Items[i]
+			if provisioned, err := p.provisioned(volume); err != nil || !provisioned {
+				continue
+			}
+			scrubbed++
+			problems := p.scrubVolume(volume)
+			if len(problems) == 0 {
+				continue
+			}
+			failed++
+			listed := problems
+			if len(listed) > maxScrubProblems {
+				listed = append(listed[:maxScrubProblems:maxScrubProblems], "...")
+			}
+			msg := fmt.Sprintf("Scrub found %d unreadable files or directories in the volume: %s", len(problems), strings.Join(listed, "; "))
+			glog.Errorf("Volume %q: %s", volume.Name, msg)
+			cluster.getEventRecorder().Event(volume, v1.EventTypeWarning, "ScrubFailed", msg)
+		}
+	}
+	glog.Infof("Scrubbed %d volumes in %v, %d with problems", scrubbed, time.Since(start), failed)
+}
+
+// scrubVolume reads every file in the backing directory of the given PV and
+// returns the problems doing so, one per file or directory that can't be read.
+func (p *nfsProvisioner) scrubVolume(volume *v1.PersistentVolume) []string {
+	var problems []string
+	filepath.Walk(p.getDirectory(volume), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			problems = append(problems, err.Error())
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if err := readFile(path); err != nil {
+			problems = append(problems, err.Error())
+		}
+		return nil
+	})
+	return problems
+}
+
+// readFile reads the file at the given path in full, discarding its data.
+func readFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := io.Copy(ioutil.Discard, file); err != nil {
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return nil
+}
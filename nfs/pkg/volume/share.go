@@ -0,0 +1,250 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Share is a volume created through the shares API instead of for a claim.
+type Share struct {
+	// Name of the share, a DNS-1123 subdomain
+	Name string `json:"name"`
+	// Size of the share, a resource quantity e.g. 1Gi
+	Size string `json:"size"`
+	// Parameters like those of a StorageClass, e.g. gid
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// Server and Path to mount the share from, set once it is created
+	Server string `json:"server,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+// shareHandler serves a REST API to create, delete and list shares with a
+// provisioner, without Kubernetes: the PV the provisioner returns for each
+// share is persisted as JSON in stateDir instead of created in a cluster.
+type shareHandler struct {
+	provisioner controller.Provisioner
+	stateDir    string
+	mutex       sync.Mutex
+}
+
+// NewShareHandler returns a handler of the shares API that creates and deletes
+// shares with the given provisioner, persisting them in stateDir:
+//
+//	GET    /shares        lists shares
+//	POST   /shares        creates the share in the body
+//	GET    /shares/<name> gets a share
+//	DELETE /shares/<name> deletes a share
+func NewShareHandler(provisioner controller.Provisioner, stateDir string) (http.Handler, error) {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating shares state dir %s: %v", stateDir, err)
+	}
+	return &shareHandler{provisioner: provisioner, stateDir: stateDir}, nil
+}
+
+func (h *shareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/shares"), "/")
+	switch {
+	case name == "" && r.Method == "GET":
+		h.list(w)
+	case name == "" && r.Method == "POST":
+		h.create(w, r)
+	case name != "" && r.Method == "GET":
+		h.get(w, name)
+	case name != "" && r.Method == "DELETE":
+		h.delete(w, name)
+	default:
+		http.Error(w, fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *shareHandler) list(w http.ResponseWriter) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	files, err := filepath.Glob(path.Join(h.stateDir, "*.json"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.Strings(files)
+	shares := []Share{}
+	for _, file := range files {
+		volume, err := readShareVolume(file)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		shares = append(shares, volumeToShare(volume))
+	}
+	writeJSON(w, http.StatusOK, shares)
+}
+
+func (h *shareHandler) create(w http.ResponseWriter, r *http.Request) {
+	var share Share
+	if err := json.NewDecoder(r.Body).Decode(&share); err != nil {
+		http.Error(w, fmt.Sprintf("error decoding share: %v", err), http.StatusBadRequest)
+		return
+	}
+	if errs := validation.IsDNS1123Subdomain(share.Name); len(errs) != 0 {
+		http.Error(w, fmt.Sprintf("invalid share name %q: %v", share.Name, errs), http.StatusBadRequest)
+		return
+	}
+	size, err := resource.ParseQuantity(share.Size)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid share size %q: %v", share.Size, err), http.StatusBadRequest)
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	file := h.getFile(share.Name)
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		http.Error(w, fmt.Sprintf("share %q already exists", share.Name), http.StatusConflict)
+		return
+	}
+
+	parameters := share.Parameters
+	if parameters == nil {
+		parameters = map[string]string{}
+	}
+	volume, err := h.provisioner.Provision(controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        share.Name,
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: share.Name},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{v1.ResourceName(v1.ResourceStorage): size},
+				},
+			},
+		},
+		Parameters: parameters,
+	})
+	if err != nil {
+		glog.Errorf("Error creating share %q: %v", share.Name, err)
+		http.Error(w, fmt.Sprintf("error creating share: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := writeShareVolume(file, volume); err != nil {
+		h.provisioner.Delete(volume)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	glog.Infof("Created share %q", share.Name)
+	writeJSON(w, http.StatusCreated, volumeToShare(volume))
+}
+
+func (h *shareHandler) get(w http.ResponseWriter, name string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	volume, err := readShareVolume(h.getFile(name))
+	if os.IsNotExist(err) {
+		http.Error(w, fmt.Sprintf("share %q not found", name), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, volumeToShare(volume))
+}
+
+func (h *shareHandler) delete(w http.ResponseWriter, name string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	file := h.getFile(name)
+	volume, err := readShareVolume(file)
+	if os.IsNotExist(err) {
+		http.Error(w, fmt.Sprintf("share %q not found", name), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.provisioner.Delete(volume); err != nil {
+		glog.Errorf("Error deleting share %q: %v", name, err)
+		http.Error(w, fmt.Sprintf("error deleting share: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := os.Remove(file); err != nil {
+		http.Error(w, fmt.Sprintf("deleted share but error removing its state file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	glog.Infof("Deleted share %q", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getFile returns the path of the file the PV of the named share is persisted
+// in. The name must not contain a path separator.
+func (h *shareHandler) getFile(name string) string {
+	return path.Join(h.stateDir, path.Base(name)+".json")
+}
+
+func readShareVolume(file string) (*v1.PersistentVolume, error) {
+	read, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	volume := &v1.PersistentVolume{}
+	if err := json.Unmarshal(read, volume); err != nil {
+		return nil, fmt.Errorf("error decoding share state file %s: %v", file, err)
+	}
+	return volume, nil
+}
+
+func writeShareVolume(file string, volume *v1.PersistentVolume) error {
+	data, err := json.Marshal(volume)
+	if err != nil {
+		return fmt.Errorf("error encoding share: %v", err)
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return fmt.Errorf("error writing share state file %s: %v", file, err)
+	}
+	return nil
+}
+
+func volumeToShare(volume *v1.PersistentVolume) Share {
+	size := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	share := Share{Name: volume.Name, Size: size.String()}
+	if nfs := volume.Spec.NFS; nfs != nil {
+		share.Server = nfs.Server
+		share.Path = nfs.Path
+	}
+	return share
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
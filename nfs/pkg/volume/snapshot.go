@@ -0,0 +1,212 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// A claim annotation with the cron schedule, in UTC, to snapshot the
+	// claim's volume on, e.g. "0 2 * * *" for every night at 2:00
+	AnnSnapshotSchedule = "nfs-provisioner/snapshot-schedule"
+
+	// A claim annotation with the number of snapshots of the claim's volume
+	// to keep, the oldest being deleted once there are more
+	AnnSnapshotRetention = "nfs-provisioner/snapshot-retention"
+
+	// The number of snapshots kept of a volume whose claim doesn't have
+	// AnnSnapshotRetention
+	defaultSnapshotRetention = 7
+
+	// Directory in each export root the snapshots of its volumes are taken
+	// in, each in a directory named after the time it was taken
+	snapshotsDir       = ".snapshots"
+	snapshotTimeFormat = "20060102-150405"
+)
+
+// Snapshotter is implemented by provisioners that can snapshot the volumes
+// they provisioned on the schedules their claims request.
+type Snapshotter interface {
+	// TakeSnapshots periodically snapshots the volumes whose snapshots are
+	// due, until stopCh is closed.
+	TakeSnapshots(period time.Duration, stopCh <-chan struct{})
+}
+
+var _ Snapshotter = &nfsProvisioner{}
+
+// TakeSnapshots periodically snapshots each PV this provisioner provisioned
+// whose claim is annotated with AnnSnapshotSchedule, if the schedule has come
+// round since its last snapshot, and deletes its snapshots beyond the claim's
+// AnnSnapshotRetention. The period should be at most a minute, the resolution
+// of schedules; if it is longer, or the provisioner was down, a missed
+// snapshot is taken late rather than not at all.
+func (p *nfsProvisioner) TakeSnapshots(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(p.takeSnapshots, period, stopCh)
+}
+
+func (p *nfsProvisioner) takeSnapshots() {
+	volumes, err := p.client.Core().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		glog.Errorf("Error listing PVs to snapshot: %v", err)
+		return
+	}
+	now := time.Now().UTC()
+	for i := range volumes.Items {
+		volume := &volumes.Items[i]
+		claimRef := volume.Spec.ClaimRef
+		if claimRef == nil || volume.Status.Phase != v1.VolumeBound {
+			continue
+		}
+		if provisioned, err := p.provisioned(volume); err != nil || !provisioned {
+			continue
+		}
+		claim, err := p.client.Core().PersistentVolumeClaims(claimRef.Namespace).Get(claimRef.Name, metav1.GetOptions{})
+		if err != nil {
+			glog.Errorf("Error getting claim %s/%s of volume %q to snapshot: %v", claimRef.Namespace, claimRef.Name, volume.Name, err)
+			continue
+		}
+		if claim.UID != claimRef.UID {
+			continue
+		}
+		if _, ok := claim.Annotations[AnnSnapshotSchedule]; !ok {
+			continue
+		}
+		if err := p.snapshotVolume(volume, claim, now); err != nil {
+			glog.Errorf("Error snapshotting volume %q: %v", volume.Name, err)
+			p.getEventRecorder().Event(claim, v1.EventTypeWarning, "SnapshotFailed", err.Error())
+		}
+	}
+}
+
+// snapshotVolume snapshots the given PV, of the given claim, if its claim's
+// schedule has come round by now since its last snapshot, or since it was
+// created if it has none. A snapshot is a copy of the volume's directory in
+// snapshotsDir in which the files unchanged since the previous snapshot are
+// hard links to it, so each takes only the space of what changed.
+func (p *nfsProvisioner) snapshotVolume(volume *v1.PersistentVolume, claim *v1.PersistentVolumeClaim, now time.Time) error {
+	schedule, err := parseSchedule(claim.Annotations[AnnSnapshotSchedule])
+	if err != nil {
+		return fmt.Errorf("invalid annotation %s: %v", AnnSnapshotSchedule, err)
+	}
+	retention := defaultSnapshotRetention
+	if value, ok := claim.Annotations[AnnSnapshotRetention]; ok {
+		retention, err = strconv.Atoi(value)
+		if err != nil || retention < 1 {
+			return fmt.Errorf("invalid annotation %s: %q is not a positive integer", AnnSnapshotRetention, value)
+		}
+	}
+
+	dir := p.getSnapshotsDirectory(volume)
+	snapshots, err := listSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	last := volume.CreationTimestamp.UTC()
+	if len(snapshots) > 0 {
+		last, _ = time.Parse(snapshotTimeFormat, snapshots[len(snapshots)-1])
+	}
+	if due := schedule.next(last); due.IsZero() || due.After(now) {
+		return p.pruneSnapshots(dir, snapshots, retention)
+	}
+
+	if err := p.checkEpoch(); err != nil {
+		return err
+	}
+	// The snapshot is taken under a hidden name, so that one left incomplete
+	// by a failure isn't taken for the latest
+	name := now.Format(snapshotTimeFormat)
+	incomplete := path.Join(dir, "."+name)
+	if err := os.MkdirAll(incomplete, 0700); err != nil {
+		return fmt.Errorf("error creating snapshot directory %s: %v", incomplete, err)
+	}
+	args := []string{"-aHAX"}
+	if len(snapshots) > 0 {
+		args = append(args, "--link-dest="+path.Join(dir, snapshots[len(snapshots)-1]))
+	}
+	args = append(args, p.getDirectory(volume)+"/", incomplete)
+	start := time.Now()
+	out, err := cmdRunner.CombinedOutput("rsync", args...)
+	if err != nil {
+		fileSystem.RemoveAll(incomplete)
+		return fmt.Errorf("rsync failed with error: %v, output: %s", err, out)
+	}
+	if err := os.Rename(incomplete, path.Join(dir, name)); err != nil {
+		fileSystem.RemoveAll(incomplete)
+		return fmt.Errorf("error renaming snapshot %s: %v", incomplete, err)
+	}
+	msg := fmt.Sprintf("Took snapshot %s of the volume in %v", path.Join(dir, name), time.Since(start))
+	glog.Infof("Volume %q: %s", volume.Name, msg)
+	p.getEventRecorder().Event(claim, v1.EventTypeNormal, "SnapshotTaken", msg)
+
+	return p.pruneSnapshots(dir, append(snapshots, name), retention)
+}
+
+// pruneSnapshots deletes the oldest of the given snapshots in dir until only
+// retention of them are left.
+func (p *nfsProvisioner) pruneSnapshots(dir string, snapshots []string, retention int) error {
+	for len(snapshots) > retention {
+		if err := fileSystem.RemoveAll(path.Join(dir, snapshots[0])); err != nil {
+			return fmt.Errorf("error deleting snapshot %s: %v", path.Join(dir, snapshots[0]), err)
+		}
+		glog.Infof("Deleted snapshot %s beyond the retention of %d", path.Join(dir, snapshots[0]), retention)
+		snapshots = snapshots[1:]
+	}
+	return nil
+}
+
+// listSnapshots returns the names of the snapshots in dir, oldest first.
+// Anything not named like a snapshot, e.g. one being taken, is left out.
+func listSnapshots(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error listing snapshots in %s: %v", dir, err)
+	}
+	var snapshots []string
+	for _, file := range files {
+		if _, err := time.Parse(snapshotTimeFormat, file.Name()); err == nil && file.IsDir() {
+			snapshots = append(snapshots, file.Name())
+		}
+	}
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// getSnapshotsDirectory returns the directory the snapshots of the given PV
+// are taken in, e.g. /export/.snapshots/pvc-1.
+func (p *nfsProvisioner) getSnapshotsDirectory(volume *v1.PersistentVolume) string {
+	root, directory := p.getRootAndDirectory(volume)
+	return path.Join(root, snapshotsDir, directory)
+}
+
+// deleteSnapshots deletes the snapshots of the given PV, if it has any.
+func (p *nfsProvisioner) deleteSnapshots(volume *v1.PersistentVolume) error {
+	return fileSystem.RemoveAll(p.getSnapshotsDirectory(volume))
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	storage "k8s.io/client-go/pkg/apis/storage/v1"
+)
+
+const (
+	// StorageClass annotations marking the class claims that don't request
+	// one get
+	annDefaultClassBeta = "storageclass.beta.kubernetes.io/is-default-class"
+	annDefaultClass     = "storageclass.kubernetes.io/is-default-class"
+)
+
+// StorageClassReconciler is implemented by provisioners that can create a
+// StorageClass for themselves and keep it as configured.
+type StorageClassReconciler interface {
+	// ReconcileStorageClass periodically creates the named StorageClass of
+	// the named provisioner with the given parameters, as the default class
+	// if isDefault is set, or updates it to match, until stopCh is closed.
+	ReconcileStorageClass(provisionerName, name string, parameters map[string]string, isDefault bool, period time.Duration, stopCh <-chan struct{})
+}
+
+var _ StorageClassReconciler = &nfsProvisioner{}
+
+// ReconcileStorageClass periodically creates the named StorageClass if it
+// doesn't exist, e.g. at startup or after it was deleted, and corrects it if
+// it has drifted from the given provisioner, parameters or default-ness. Only
+// classes annotated as created by the provisioner are corrected, so that one
+// created by an admin is never replaced.
+func (p *nfsProvisioner) ReconcileStorageClass(provisionerName, name string, parameters map[string]string, isDefault bool, period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := p.reconcileStorageClass(newStorageClass(provisionerName, name, parameters, isDefault)); err != nil {
+			glog.Errorf("Error reconciling storage class %s: %v", name, err)
+		}
+	}, period, stopCh)
+}
+
+// newStorageClass returns the StorageClass the provisioner creates for itself.
+func newStorageClass(provisionerName, name string, parameters map[string]string, isDefault bool) *storage.StorageClass {
+	return &storage.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Annotations: map[string]string{
+				annCreatedBy:        createdBy,
+				annDefaultClassBeta: strconv.FormatBool(isDefault),
+				annDefaultClass:     strconv.FormatBool(isDefault),
+			},
+		},
+		Provisioner: provisionerName,
+		Parameters:  parameters,
+	}
+}
+
+func (p *nfsProvisioner) reconcileStorageClass(class *storage.StorageClass) error {
+	classes := p.client.StorageV1().StorageClasses()
+	existing, err := classes.Get(class.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		glog.Infof("Creating storage class %s", class.Name)
+		_, err = classes.Create(class)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if existing.Annotations[annCreatedBy] != createdBy {
+		return fmt.Errorf("storage class exists but was not created by the provisioner, leaving it as it is")
+	}
+	if existing.Provisioner != class.Provisioner || !reflect.DeepEqual(existing.Parameters, class.Parameters) {
+		// The provisioner and parameters of a class can't be updated, and
+		// deleting it doesn't affect the volumes already provisioned
+		glog.Infof("Recreating storage class %s with provisioner %s and parameters %v", class.Name, class.Provisioner, class.Parameters)
+		if err := classes.Delete(class.Name, &metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+		_, err = classes.Create(class)
+		return err
+	}
+	updated := false
+	for k, v := range class.Annotations {
+		if existing.Annotations[k] != v {
+			existing.Annotations[k] = v
+			updated = true
+		}
+	}
+	if updated {
+		glog.Infof("Updating annotations of storage class %s", class.Name)
+		_, err = classes.Update(existing)
+	}
+	return err
+}
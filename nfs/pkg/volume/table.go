@@ -0,0 +1,170 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// The extension of the journal of an export config, in the config's directory
+const journalExt = ".journal"
+
+// exportTable is the in-memory copy of an export config, the authority on
+// the export blocks it has while the provisioner runs. Each change is recorded
+// in a journal before the config is replaced with the new table in one go, so
+// that a crash never leaves the config truncated or half-written, and a change
+// whose write was cut short by a crash is redone at startup.
+type exportTable struct {
+	config  string
+	journal string
+	content string
+
+	// Guards content and the files, shared with the readers of the config
+	mutex *sync.Mutex
+}
+
+// journalRecord is a change to an export table, one JSON object per line of
+// the journal.
+type journalRecord struct {
+	// "add" or "remove"
+	Op    string `json:"op"`
+	Block string `json:"block"`
+}
+
+// newExportTable loads the given config, redoing the changes left in its
+// journal by a crash, if any.
+func newExportTable(config string, mutex *sync.Mutex) (*exportTable, error) {
+	read, err := ioutil.ReadFile(config)
+	if err != nil {
+		return nil, err
+	}
+	t := &exportTable{
+		config:  config,
+		journal: config + journalExt,
+		content: string(read),
+		mutex:   mutex,
+	}
+
+	records, err := t.readJournal()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return t, nil
+	}
+	glog.Infof("Redoing %d changes to %s from its journal %s", len(records), config, t.journal)
+	for _, record := range records {
+		t.content = applyRecord(t.content, record)
+	}
+	if err := writeFileAtomic(t.config, []byte(t.content)); err != nil {
+		return nil, err
+	}
+	return t, os.Remove(t.journal)
+}
+
+// add adds the given block to the table, if it isn't there, and writes the
+// table to the config. A config that was replaced, e.g. by a restart, so gets
+// all the table's blocks back.
+func (t *exportTable) add(block string) error {
+	return t.change(journalRecord{Op: "add", Block: block})
+}
+
+// remove removes the given block from the table and config.
+func (t *exportTable) remove(block string) error {
+	return t.change(journalRecord{Op: "remove", Block: block})
+}
+
+// change journals the given change, then applies it to the table and replaces
+// the config with the table. The journal is cleared once the config is
+// replaced. If replacing it fails, the change is undone.
+func (t *exportTable) change(record journalRecord) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	journal, err := os.OpenFile(t.journal, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening journal %s: %v", t.journal, err)
+	}
+	_, err = journal.Write(append(line, '\n'))
+	if err == nil {
+		err = journal.Sync()
+	}
+	journal.Close()
+	if err != nil {
+		return fmt.Errorf("error writing journal %s: %v", t.journal, err)
+	}
+
+	content := applyRecord(t.content, record)
+	if err := writeFileAtomic(t.config, []byte(content)); err != nil {
+		os.Remove(t.journal)
+		return fmt.Errorf("error writing config %s: %v", t.config, err)
+	}
+	t.content = content
+	return os.Remove(t.journal)
+}
+
+// readJournal returns the changes in the journal, ignoring a last one cut
+// short by a crash.
+func (t *exportTable) readJournal() ([]journalRecord, error) {
+	file, err := os.Open(t.journal)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []journalRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			glog.Warningf("Ignoring malformed record %q in journal %s: %v", scanner.Text(), t.journal, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// applyRecord returns the given config content with the given change applied.
+// Adding a block already in the config leaves it as it is, so that redoing a
+// change is harmless.
+func applyRecord(content string, record journalRecord) string {
+	switch record.Op {
+	case "add":
+		if !strings.Contains(content, record.Block) {
+			content += record.Block
+		}
+	case "remove":
+		content = strings.Replace(content, record.Block, "", -1)
+	}
+	return content
+}
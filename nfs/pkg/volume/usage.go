@@ -0,0 +1,243 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// PV annotations with the bytes and inodes used by the volume, as read
+	// from the quota accounting, set if usage reporting is enabled
+	AnnUsedBytes  = "nfs-provisioner/used-bytes"
+	AnnUsedInodes = "nfs-provisioner/used-inodes"
+)
+
+// UsageMonitor is implemented by provisioners that can warn when the volumes
+// they provisioned are nearly full.
+type UsageMonitor interface {
+	// MonitorUsage periodically checks the usage of the volumes against the
+	// given thresholds, in percent of their capacity, until stopCh is closed.
+	MonitorUsage(thresholds []int, period time.Duration, stopCh <-chan struct{})
+}
+
+var _ UsageMonitor = &nfsProvisioner{}
+
+// UsageReporter is implemented by provisioners that can report the usage of
+// the volumes they provisioned on their PVs.
+type UsageReporter interface {
+	// ReportUsage periodically annotates the PVs with the usage of their
+	// volumes, until stopCh is closed.
+	ReportUsage(period time.Duration, stopCh <-chan struct{})
+}
+
+var _ UsageReporter = &nfsProvisioner{}
+
+// getVolumeUsages returns the bytes used by each volume provisioned by this
+// provisioner with a quota, by PV name, in all the clusters it provisions for.
+// Usage is read from the quota accounting, so volumes without a quota, e.g.
+// all of them if xfs quotas aren't enabled, are left out.
+func (p *nfsProvisioner) getVolumeUsages() (map[string]int64, error) {
+	usage, err := p.quotaer.GetUsage()
+	if err != nil {
+		return nil, err
+	}
+	if len(usage) == 0 {
+		return map[string]int64{}, nil
+	}
+
+	volumes, err := p.listVolumes()
+	if err != nil {
+		return nil, err
+	}
+	usages := map[string]int64{}
+	for _, volume := range volumes {
+		if provisioned, err := p.provisioned(&volume); err != nil || !provisioned {
+			continue
+		}
+		projectID, err := strconv.ParseUint(volume.Annotations[annProjectID], 10, 16)
+		if err != nil || projectID == 0 {
+			continue
+		}
+		if used, ok := usage[uint16(projectID)]; ok {
+			usages[volume.Name] = used
+		}
+	}
+	return usages, nil
+}
+
+// MonitorUsage periodically reads the bytes used by each volume this
+// provisioner provisioned with a quota and, when a volume crosses one of the
+// given thresholds in percent of its capacity, e.g. 80 and 95, records a
+// VolumeUsageHigh Warning event on its claim and counts the crossing for
+// ServeMetrics. A volume that drops below a threshold warns again when it next
+// crosses it.
+func (p *nfsProvisioner) MonitorUsage(thresholds []int, period time.Duration, stopCh <-chan struct{}) {
+	thresholds = append([]int(nil), thresholds...)
+	sort.Ints(thresholds)
+	p.usageMutex.Lock()
+	p.usageThresholds = thresholds
+	p.volumeThresholds = map[string]int{}
+	p.thresholdCrossings = map[int]int{}
+	p.usageMutex.Unlock()
+	wait.Until(p.checkUsage, period, stopCh)
+}
+
+func (p *nfsProvisioner) checkUsage() {
+	usage, err := p.quotaer.GetUsage()
+	if err != nil {
+		glog.Errorf("Error getting usage of volumes: %v", err)
+		return
+	}
+
+	volumeThresholds := map[string]int{}
+	for _, cluster := range append([]*nfsProvisioner{p}, p.clusters...) {
+		volumes, err := cluster.client.Core().PersistentVolumes().List(metav1.ListOptions{})
+		if err != nil {
+			glog.Errorf("Error listing PVs to check usage of: %v", err)
+			return
+		}
+		for i := range volumes.Items {
+			volume := &volumes.Items[i]
+			if provisioned, err := p.provisioned(volume); err != nil || !provisioned {
+				continue
+			}
+			projectID, err := strconv.ParseUint(volume.Annotations[annProjectID], 10, 16)
+			if err != nil || projectID == 0 {
+				continue
+			}
+			used, ok := usage[uint16(projectID)]
+			if !ok {
+				continue
+			}
+			capacity := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+			if capacity.Value() == 0 {
+				continue
+			}
+			percent := int(used * 100 / capacity.Value())
+			threshold := p.getUsageThreshold(percent)
+			volumeThresholds[volume.Name] = threshold
+
+			p.usageMutex.Lock()
+			previous := p.volumeThresholds[volume.Name]
+			if threshold > previous {
+				p.thresholdCrossings[threshold]++
+			}
+			p.usageMutex.Unlock()
+			if threshold > previous {
+				cluster.recordUsageHigh(volume, percent, threshold)
+			}
+		}
+	}
+
+	// Volumes deleted since are forgotten
+	p.usageMutex.Lock()
+	p.volumeThresholds = volumeThresholds
+	p.usageMutex.Unlock()
+}
+
+// getUsageThreshold returns the highest threshold the given usage in percent
+// is at or over, 0 if none.
+func (p *nfsProvisioner) getUsageThreshold(percent int) int {
+	p.usageMutex.Lock()
+	defer p.usageMutex.Unlock()
+	threshold := 0
+	for _, t := range p.usageThresholds {
+		if percent >= t {
+			threshold = t
+		}
+	}
+	return threshold
+}
+
+// recordUsageHigh records on the claim bound to the given PV, or on the PV if
+// it has none, that the volume is used to the given percent of its capacity,
+// over the given threshold.
+func (p *nfsProvisioner) recordUsageHigh(volume *v1.PersistentVolume, percent, threshold int) {
+	msg := fmt.Sprintf("Volume %q is %d%% full, over the usage threshold of %d%%", volume.Name, percent, threshold)
+	glog.Warning(msg)
+	if claimRef := volume.Spec.ClaimRef; claimRef != nil {
+		claim, err := p.client.Core().PersistentVolumeClaims(claimRef.Namespace).Get(claimRef.Name, metav1.GetOptions{})
+		if err == nil && claim.UID == claimRef.UID {
+			p.getEventRecorder().Event(claim, v1.EventTypeWarning, "VolumeUsageHigh", msg)
+			return
+		}
+	}
+	p.getEventRecorder().Event(volume, v1.EventTypeWarning, "VolumeUsageHigh", msg)
+}
+
+// ReportUsage periodically sets the AnnUsedBytes and AnnUsedInodes annotations
+// of each PV this provisioner provisioned with a quota to the bytes and inodes
+// its volume uses, read from the quota accounting, so that tooling and other
+// controllers can see them without scraping metrics. A PV is only updated if
+// its usage changed since it was last reported.
+func (p *nfsProvisioner) ReportUsage(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(p.reportUsage, period, stopCh)
+}
+
+func (p *nfsProvisioner) reportUsage() {
+	bytes, err := p.quotaer.GetUsage()
+	if err != nil {
+		glog.Errorf("Error getting usage of volumes: %v", err)
+		return
+	}
+	inodes, err := p.quotaer.GetInodeUsage()
+	if err != nil {
+		glog.Errorf("Error getting inode usage of volumes: %v", err)
+		return
+	}
+
+	for _, cluster := range append([]*nfsProvisioner{p}, p.clusters...) {
+		volumes, err := cluster.client.Core().PersistentVolumes().List(metav1.ListOptions{})
+		if err != nil {
+			glog.Errorf("Error listing PVs to report usage of: %v", err)
+			return
+		}
+		for i := range volumes.Items {
+			volume := &volumes.Items[i]
+			if provisioned, err := p.provisioned(volume); err != nil || !provisioned {
+				continue
+			}
+			projectID, err := strconv.ParseUint(volume.Annotations[annProjectID], 10, 16)
+			if err != nil || projectID == 0 {
+				continue
+			}
+			usedBytes, ok := bytes[uint16(projectID)]
+			if !ok {
+				continue
+			}
+			usedInodes := strconv.FormatInt(inodes[uint16(projectID)], 10)
+			if volume.Annotations[AnnUsedBytes] == strconv.FormatInt(usedBytes, 10) && volume.Annotations[AnnUsedInodes] == usedInodes {
+				continue
+			}
+			volume.Annotations[AnnUsedBytes] = strconv.FormatInt(usedBytes, 10)
+			volume.Annotations[AnnUsedInodes] = usedInodes
+			// A conflicting update is retried with the latest PV next period
+			if _, err := cluster.client.Core().PersistentVolumes().Update(volume); err != nil {
+				glog.Errorf("Error reporting usage on volume %q: %v", volume.Name, err)
+			}
+		}
+	}
+}
@@ -17,16 +17,35 @@ limitations under the License.
 package volume
 
 import (
+	"bufio"
 	"fmt"
 	"io/ioutil"
 	"math"
+	"net"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/fs"
+	"github.com/kubernetes-incubator/external-storage/nfs/pkg/runner"
 )
 
+// cmdRunner runs the external commands, e.g. exportfs and xfs_quota, the
+// provisioner depends on. A variable so tests can fake it.
+var cmdRunner = runner.New()
+
+// SetRunner replaces the runner of the external commands the provisioner
+// depends on, e.g. with one that injects faults.
+func SetRunner(r runner.Runner) {
+	cmdRunner = r
+}
+
+// fileSystem does the operations on volume directories, e.g. creating and
+// removing them. A variable so tests can fake it.
+var fileSystem = fs.New()
+
 // generateID generates a unique exportID to assign an export
 func generateID(mutex *sync.Mutex, ids map[uint16]bool) uint16 {
 	mutex.Lock()
@@ -95,20 +114,115 @@ func addToFile(mutex *sync.Mutex, path string, toAdd string) error {
 
 func removeFromFile(mutex *sync.Mutex, path string, toRemove string) error {
 	mutex.Lock()
+	defer mutex.Unlock()
 
 	read, err := ioutil.ReadFile(path)
 	if err != nil {
-		mutex.Unlock()
 		return err
 	}
 
 	removed := strings.Replace(string(read), toRemove, "", -1)
-	err = ioutil.WriteFile(path, []byte(removed), 0)
+	return writeFileAtomic(path, []byte(removed))
+}
+
+// writeFileAtomic replaces the given file with the given data by writing it to
+// a temporary file in the same directory and renaming that over the file, so
+// that the file is never left half-written. The file keeps its permissions.
+func writeFileAtomic(path string, data []byte) error {
+	perm := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 	if err != nil {
-		mutex.Unlock()
 		return err
 	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
 
-	mutex.Unlock()
-	return nil
+// procNetRoute is the file read to find the interface of the default route
+var procNetRoute = "/proc/net/route"
+
+// getDefaultRouteInterface returns the name of the interface the IPv4 default
+// route goes through, i.e. the node's primary interface when running with
+// hostNetwork.
+func getDefaultRouteInterface() (string, error) {
+	file, err := os.Open(procNetRoute)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] == "Iface" {
+			continue
+		}
+		if fields[1] == "00000000" {
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no default route found in %s", procNetRoute)
+}
+
+// getInterfaceIP returns the first IPv4 address of the given interface, or its
+// first global IPv6 address if it has no IPv4 address.
+func getInterfaceIP(name string) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			return ip4.String(), nil
+		}
+		if ipv6 == nil && ipNet.IP.IsGlobalUnicast() {
+			ipv6 = ipNet.IP
+		}
+	}
+	if ipv6 != nil {
+		return ipv6.String(), nil
+	}
+	return "", fmt.Errorf("interface %s has no usable address", name)
+}
+
+// getAvailableBytes returns the space available to unprivileged users in the
+// filesystem containing the given path. A variable so tests can fake it.
+var getAvailableBytes = func(path string) (int64, error) {
+	available, err := fileSystem.AvailableBytes(path)
+	if err != nil {
+		return 0, fmt.Errorf("error calling statfs on %v: %v", path, err)
+	}
+	return available, nil
 }
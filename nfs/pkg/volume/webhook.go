@@ -0,0 +1,160 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/helper"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// The number of notifications queued for the webhook before new ones are
+	// dropped, e.g. while it's down
+	webhookQueueSize = 1000
+
+	// How long to wait for the webhook to respond, and how many times to try
+	// to deliver each notification
+	webhookTimeout  = 10 * time.Second
+	webhookAttempts = 3
+)
+
+// The operations the webhook is notified of
+const (
+	webhookProvision = "provision"
+	webhookDelete    = "delete"
+	webhookResize    = "resize"
+)
+
+// webhookNotification is the JSON payload POSTed to the webhook for each
+// operation on a volume.
+type webhookNotification struct {
+	Operation    string    `json:"operation"`
+	Success      bool      `json:"success"`
+	Error        string    `json:"error,omitempty"`
+	Volume       string    `json:"volume"`
+	Namespace    string    `json:"namespace,omitempty"`
+	Claim        string    `json:"claim,omitempty"`
+	StorageClass string    `json:"storageClass,omitempty"`
+	Capacity     int64     `json:"capacity,omitempty"`
+	Server       string    `json:"server,omitempty"`
+	Path         string    `json:"path,omitempty"`
+	Time         time.Time `json:"time"`
+	Provisioner  string    `json:"provisioner"`
+}
+
+// startWebhook starts delivering the notifications queued by notify to the
+// given URL, one at a time and in order.
+func (p *nfsProvisioner) startWebhook(url string) {
+	p.webhooks = make(chan webhookNotification, webhookQueueSize)
+	go func() {
+		client := &http.Client{Timeout: webhookTimeout}
+		for notification := range p.webhooks {
+			if err := postWebhook(client, url, notification); err != nil {
+				glog.Errorf("Error notifying webhook of %s of volume %q: %v", notification.Operation, notification.Volume, err)
+			}
+		}
+	}()
+}
+
+// postWebhook POSTs the given notification to the given URL, retrying with
+// backoff until it responds with a 2xx status or webhookAttempts run out.
+func postWebhook(client *http.Client, url string, notification webhookNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = func() error {
+			resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				return fmt.Errorf("webhook responded with status %s", resp.Status)
+			}
+			return nil
+		}()
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// notify queues a notification of the given operation on the given PV, or on
+// the volume for the given claim if the PV is nil because provisioning failed,
+// for the webhook, if there is one. err is the operation's error, if it
+// failed; operations ignored because another provisioner is responsible for
+// the volume aren't notified. Notifications are dropped rather than blocking
+// the operation if the queue is full.
+func (p *nfsProvisioner) notify(operation string, volume *v1.PersistentVolume, options *controller.VolumeOptions, err error) {
+	if p.webhooks == nil {
+		return
+	}
+	if _, ok := err.(*controller.IgnoredError); ok {
+		return
+	}
+	notification := webhookNotification{
+		Operation:   operation,
+		Success:     err == nil,
+		Time:        time.Now().UTC(),
+		Provisioner: string(p.identity),
+	}
+	if err != nil {
+		notification.Error = err.Error()
+	}
+	if options != nil {
+		notification.Volume = options.PVName
+		notification.Namespace = options.PVC.Namespace
+		notification.Claim = options.PVC.Name
+		notification.StorageClass = helper.GetPersistentVolumeClaimClass(options.PVC)
+		capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+		notification.Capacity = capacity.Value()
+	}
+	if volume != nil {
+		notification.Volume = volume.Name
+		if claimRef := volume.Spec.ClaimRef; claimRef != nil {
+			notification.Namespace = claimRef.Namespace
+			notification.Claim = claimRef.Name
+		}
+		if class := helper.GetPersistentVolumeClass(volume); class != "" {
+			notification.StorageClass = class
+		}
+		capacity := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		notification.Capacity = capacity.Value()
+		if volume.Spec.NFS != nil {
+			notification.Server = volume.Spec.NFS.Server
+			notification.Path = volume.Spec.NFS.Path
+		}
+	}
+	select {
+	case p.webhooks <- notification:
+	default:
+		glog.Errorf("Webhook queue is full, dropping notification of %s of volume %q", operation, notification.Volume)
+	}
+}
@@ -0,0 +1,60 @@
+#!/bin/bash
+
+# Copyright 2017 The Kubernetes Authors.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# Runs the e2e suite against a throwaway kind cluster: builds the
+# nfs-provisioner image, loads it into the cluster's node and runs the suite
+# with the cluster's kubeconfig. The cluster is deleted afterwards unless
+# KEEP_CLUSTER is set. Requires docker, kind, kubectl and glide.
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+CLUSTER_NAME=${CLUSTER_NAME:-nfs-provisioner-e2e}
+KIND_NODE_IMAGE=${KIND_NODE_IMAGE:-}
+KEEP_CLUSTER=${KEEP_CLUSTER:-}
+
+NFS_ROOT=$(cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd)
+KUBECONFIG_FILE=$(mktemp /tmp/nfs-provisioner-e2e-kubeconfig.XXXXXX)
+
+cleanup() {
+	if [ -z "$KEEP_CLUSTER" ]; then
+		kind delete cluster --name "$CLUSTER_NAME"
+	fi
+	rm -f "$KUBECONFIG_FILE"
+}
+trap cleanup EXIT
+
+args=(--name "$CLUSTER_NAME" --kubeconfig "$KUBECONFIG_FILE" --wait 5m)
+if [ -n "$KIND_NODE_IMAGE" ]; then
+	args+=(--image "$KIND_NODE_IMAGE")
+fi
+kind create cluster "${args[@]}"
+
+# The node needs the NFS client to mount the volumes the suite provisions
+for node in $(kind get nodes --name "$CLUSTER_NAME"); do
+	docker exec "$node" sh -c 'apt-get -qq update && apt-get -qq install -y nfs-common'
+done
+
+# The suite runs quay.io/kubernetes_incubator/nfs-provisioner:latest with
+# imagePullPolicy IfNotPresent, so load the freshly built image under that name
+make -C "$NFS_ROOT" container REGISTRY=quay.io/kubernetes_incubator/ VERSION=latest
+kind load docker-image quay.io/kubernetes_incubator/nfs-provisioner:latest --name "$CLUSTER_NAME"
+
+cd "$NFS_ROOT/test/e2e"
+glide install -v
+cd "$NFS_ROOT"
+go test ./test/e2e -v -timeout 30m --kubeconfig="$KUBECONFIG_FILE" "$@"
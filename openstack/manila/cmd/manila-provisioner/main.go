@@ -0,0 +1,111 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/openstack/manila/pkg/provision"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	provisioner  = flag.String("provisioner", "example.com/manila", "Name of the provisioner. The provisioner will only provision volumes for claims that request a StorageClass with a provisioner field set equal to this name.")
+	master       = flag.String("master", "", "Master URL")
+	kubeconfig   = flag.String("kubeconfig", "", "Absolute path to the kubeconfig")
+	id           = flag.String("id", "", "Unique provisioner identity")
+	shareTimeout = flag.Duration("share-timeout", 5*time.Minute, "How long to wait for a created share to become available before giving up and deleting it.")
+)
+
+func main() {
+	flag.Parse()
+	flag.Set("logtostderr", "true")
+
+	// The OpenStack credentials are read from the environment variables the
+	// OpenStack command line clients use
+	credentials := provision.Credentials{
+		AuthURL:           os.Getenv("OS_AUTH_URL"),
+		Username:          os.Getenv("OS_USERNAME"),
+		Password:          os.Getenv("OS_PASSWORD"),
+		UserDomainName:    os.Getenv("OS_USER_DOMAIN_NAME"),
+		ProjectID:         os.Getenv("OS_PROJECT_ID"),
+		ProjectName:       os.Getenv("OS_PROJECT_NAME"),
+		ProjectDomainName: os.Getenv("OS_PROJECT_DOMAIN_NAME"),
+		Region:            os.Getenv("OS_REGION_NAME"),
+	}
+	if credentials.AuthURL == "" || credentials.Username == "" || credentials.Password == "" {
+		glog.Fatalf("OS_AUTH_URL, OS_USERNAME and OS_PASSWORD must be set")
+	}
+	if credentials.ProjectID == "" && credentials.ProjectName == "" {
+		glog.Fatalf("OS_PROJECT_ID or OS_PROJECT_NAME must be set")
+	}
+	if credentials.UserDomainName == "" {
+		credentials.UserDomainName = "Default"
+	}
+	if credentials.ProjectDomainName == "" {
+		credentials.ProjectDomainName = "Default"
+	}
+
+	var config *rest.Config
+	var err error
+	if *master != "" || *kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		glog.Fatalf("Failed to create config: %v", err)
+	}
+	prID := string(uuid.NewUUID())
+	if *id != "" {
+		prID = *id
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create client: %v", err)
+	}
+
+	// The controller needs to know what the server version is because out-of-tree
+	// provisioners aren't officially supported until 1.5
+	serverVersion, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		glog.Fatalf("Error getting server version: %v", err)
+	}
+
+	// Create the provisioner: it implements the Provisioner interface expected by
+	// the controller
+	manilaProvisioner := provision.NewManilaProvisioner(credentials, prID, *shareTimeout)
+
+	// Start the provision controller which will dynamically provision Manila
+	// NFS PVs
+	pc := controller.NewProvisionController(
+		clientset,
+		*provisioner,
+		manilaProvisioner,
+		serverVersion.GitVersion,
+	)
+
+	pc.Run(wait.NeverStop)
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// manilaAPIVersion is the Manila microversion requested, the first with
+	// the export locations API
+	manilaAPIVersion = "2.9"
+	// manilaServiceType is the service catalog type of Manila's v2 API
+	manilaServiceType = "sharev2"
+)
+
+// Credentials are the Keystone v3 password credentials used to authenticate
+// with OpenStack.
+type Credentials struct {
+	AuthURL           string
+	Username          string
+	Password          string
+	UserDomainName    string
+	ProjectID         string
+	ProjectName       string
+	ProjectDomainName string
+	Region            string
+}
+
+// share is a Manila share.
+type share struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	Status string `json:"status"`
+}
+
+type exportLocation struct {
+	Path      string `json:"path"`
+	Preferred bool   `json:"preferred"`
+}
+
+// manila is the subset of the Manila API the provisioner uses.
+type manila interface {
+	CreateShare(name string, sizeGB int, shareType, zone string) (*share, error)
+	GetShare(id string) (*share, error)
+	AllowAccess(id, cidr string) error
+	GetExportLocations(id string) ([]exportLocation, error)
+	DeleteShare(id string) error
+}
+
+// manilaClient calls the Manila v2 API, authenticating with Keystone v3.
+type manilaClient struct {
+	credentials Credentials
+	client      *http.Client
+
+	mutex    sync.Mutex
+	token    string
+	endpoint string
+}
+
+// newManilaClient creates a Manila client that authenticates with the given
+// credentials when it makes its first request.
+func newManilaClient(credentials Credentials) *manilaClient {
+	return &manilaClient{
+		credentials: credentials,
+		client:      &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+var _ manila = &manilaClient{}
+
+type catalogEntry struct {
+	Type      string `json:"type"`
+	Endpoints []struct {
+		Interface string `json:"interface"`
+		Region    string `json:"region"`
+		URL       string `json:"url"`
+	} `json:"endpoints"`
+}
+
+// authenticate gets a token and Manila's endpoint from Keystone.
+func (c *manilaClient) authenticate() (string, string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.token != "" {
+		return c.token, c.endpoint, nil
+	}
+
+	cred := c.credentials
+	project := map[string]interface{}{}
+	if cred.ProjectID != "" {
+		project["id"] = cred.ProjectID
+	} else {
+		project["name"] = cred.ProjectName
+		project["domain"] = map[string]string{"name": cred.ProjectDomainName}
+	}
+	body := map[string]interface{}{
+		"auth": map[string]interface{}{
+			"identity": map[string]interface{}{
+				"methods": []string{"password"},
+				"password": map[string]interface{}{
+					"user": map[string]interface{}{
+						"name":     cred.Username,
+						"password": cred.Password,
+						"domain":   map[string]string{"name": cred.UserDomainName},
+					},
+				},
+			},
+			"scope": map[string]interface{}{"project": project},
+		},
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := c.client.Post(strings.TrimSuffix(cred.AuthURL, "/")+"/auth/tokens", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return "", "", fmt.Errorf("error authenticating with keystone: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return "", "", fmt.Errorf("error authenticating with keystone: %s: %s", resp.Status, msg)
+	}
+
+	var tokenResp struct {
+		Token struct {
+			Catalog []catalogEntry `json:"catalog"`
+		} `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", "", fmt.Errorf("error decoding keystone token: %v", err)
+	}
+	endpoint := getEndpoint(tokenResp.Token.Catalog, manilaServiceType, cred.Region)
+	if endpoint == "" {
+		return "", "", fmt.Errorf("no public %s endpoint found in region %q", manilaServiceType, cred.Region)
+	}
+
+	c.token = resp.Header.Get("X-Subject-Token")
+	c.endpoint = endpoint
+	return c.token, c.endpoint, nil
+}
+
+// getEndpoint returns the URL of the public endpoint of the given service type
+// in the given region, or any region if it's empty.
+func getEndpoint(catalog []catalogEntry, serviceType, region string) string {
+	for _, entry := range catalog {
+		if entry.Type != serviceType {
+			continue
+		}
+		for _, endpoint := range entry.Endpoints {
+			if endpoint.Interface == "public" && (region == "" || endpoint.Region == region) {
+				return strings.TrimSuffix(endpoint.URL, "/")
+			}
+		}
+	}
+	return ""
+}
+
+// do calls Manila, authenticating again once if the token has expired, and
+// decodes the response into result if it's not nil.
+func (c *manilaClient) do(method, path string, body interface{}, expectedStatus int, result interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		token, endpoint, err := c.authenticate()
+		if err != nil {
+			return err
+		}
+		var reader io.Reader
+		if data != nil {
+			reader = bytes.NewReader(data)
+		}
+		req, err := http.NewRequest(method, endpoint+path, reader)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Auth-Token", token)
+		req.Header.Set("X-OpenStack-Manila-API-Version", manilaAPIVersion)
+		if data != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("error calling manila %s %s: %v", method, path, err)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			resp.Body.Close()
+			c.mutex.Lock()
+			c.token = ""
+			c.mutex.Unlock()
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != expectedStatus {
+			msg, _ := ioutil.ReadAll(resp.Body)
+			return &manilaError{status: resp.StatusCode, message: fmt.Sprintf("manila %s %s failed: %s: %s", method, path, resp.Status, msg)}
+		}
+		if result != nil {
+			return json.NewDecoder(resp.Body).Decode(result)
+		}
+		return nil
+	}
+}
+
+type manilaError struct {
+	status  int
+	message string
+}
+
+func (e *manilaError) Error() string {
+	return e.message
+}
+
+func isNotFound(err error) bool {
+	manilaErr, ok := err.(*manilaError)
+	return ok && manilaErr.status == http.StatusNotFound
+}
+
+func (c *manilaClient) CreateShare(name string, sizeGB int, shareType, zone string) (*share, error) {
+	request := map[string]interface{}{
+		"share_proto": "NFS",
+		"size":        sizeGB,
+		"name":        name,
+	}
+	if shareType != "" {
+		request["share_type"] = shareType
+	}
+	if zone != "" {
+		request["availability_zone"] = zone
+	}
+	var result struct {
+		Share share `json:"share"`
+	}
+	if err := c.do("POST", "/shares", map[string]interface{}{"share": request}, http.StatusOK, &result); err != nil {
+		return nil, err
+	}
+	return &result.Share, nil
+}
+
+func (c *manilaClient) GetShare(id string) (*share, error) {
+	var result struct {
+		Share share `json:"share"`
+	}
+	if err := c.do("GET", "/shares/"+id, nil, http.StatusOK, &result); err != nil {
+		return nil, err
+	}
+	return &result.Share, nil
+}
+
+func (c *manilaClient) AllowAccess(id, cidr string) error {
+	request := map[string]interface{}{
+		"allow_access": map[string]string{
+			"access_type":  "ip",
+			"access_to":    cidr,
+			"access_level": "rw",
+		},
+	}
+	return c.do("POST", "/shares/"+id+"/action", request, http.StatusOK, nil)
+}
+
+func (c *manilaClient) GetExportLocations(id string) ([]exportLocation, error) {
+	var result struct {
+		ExportLocations []exportLocation `json:"export_locations"`
+	}
+	if err := c.do("GET", "/shares/"+id+"/export_locations", nil, http.StatusOK, &result); err != nil {
+		return nil, err
+	}
+	return result.ExportLocations, nil
+}
+
+func (c *manilaClient) DeleteShare(id string) error {
+	err := c.do("DELETE", "/shares/"+id, nil, http.StatusAccepted, nil)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
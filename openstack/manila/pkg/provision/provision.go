@@ -0,0 +1,226 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	provisionerIDAnn = "manilaProvisionerIdentity"
+	shareIDAnn       = "manilaShareID"
+
+	sharePrefix = "kubernetes-dynamic-"
+)
+
+type manilaProvisionOptions struct {
+	shareType  string
+	zone       string
+	accessCIDR string
+}
+
+type manilaProvisioner struct {
+	manila manila
+	// Identity of this manilaProvisioner. Used to identify "this"
+	// provisioner's PVs.
+	identity string
+	// pollInterval and pollTimeout determine how often and how long to wait
+	// for a share to become available
+	pollInterval time.Duration
+	pollTimeout  time.Duration
+}
+
+// NewManilaProvisioner creates a new Manila provisioner creating shares with
+// the Manila API found in the service catalog of the given credentials.
+func NewManilaProvisioner(credentials Credentials, id string, shareTimeout time.Duration) controller.Provisioner {
+	return &manilaProvisioner{
+		manila:       newManilaClient(credentials),
+		identity:     id,
+		pollInterval: 3 * time.Second,
+		pollTimeout:  shareTimeout,
+	}
+}
+
+var _ controller.Provisioner = &manilaProvisioner{}
+
+// Provision creates an NFS share, allows the class's CIDR to access it, and
+// returns a PV object representing its export location.
+func (p *manilaProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	opts, err := parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	// manila sizes are in GB, round up
+	sizeGB := int((capacity.Value() + 1024*1024*1024 - 1) / (1024 * 1024 * 1024))
+	if sizeGB < 1 {
+		sizeGB = 1
+	}
+
+	created, err := p.manila.CreateShare(sharePrefix+options.PVName, sizeGB, opts.shareType, opts.zone)
+	if err != nil {
+		return nil, fmt.Errorf("error creating share: %v", err)
+	}
+	glog.Infof("created share %s for claim %s/%s, waiting for it to become available", created.ID, options.PVC.Namespace, options.PVC.Name)
+
+	server, path, err := p.exportShare(created.ID, opts)
+	if err != nil {
+		if deleteErr := p.manila.DeleteShare(created.ID); deleteErr != nil {
+			glog.Errorf("error deleting share %s after failing to export it: %v", created.ID, deleteErr)
+		}
+		return nil, err
+	}
+	glog.Infof("successfully exported share %s at %s:%s", created.ID, server, path)
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				provisionerIDAnn: p.identity,
+				shareIDAnn:       created.ID,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): capacity,
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				NFS: &v1.NFSVolumeSource{
+					Server:   server,
+					Path:     path,
+					ReadOnly: false,
+				},
+			},
+		},
+	}
+
+	return pv, nil
+}
+
+// exportShare waits for the share to become available, allows the class's
+// CIDR to access it, and returns the server and path of its export location.
+func (p *manilaProvisioner) exportShare(id string, opts *manilaProvisionOptions) (string, string, error) {
+	err := wait.Poll(p.pollInterval, p.pollTimeout, func() (bool, error) {
+		s, err := p.manila.GetShare(id)
+		if err != nil {
+			return false, err
+		}
+		switch s.Status {
+		case "available":
+			return true, nil
+		case "error":
+			return false, fmt.Errorf("share %s is in error state", id)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("error waiting for share %s to become available: %v", id, err)
+	}
+
+	if err := p.manila.AllowAccess(id, opts.accessCIDR); err != nil {
+		return "", "", fmt.Errorf("error allowing %s to access share %s: %v", opts.accessCIDR, id, err)
+	}
+
+	locations, err := p.manila.GetExportLocations(id)
+	if err != nil {
+		return "", "", fmt.Errorf("error getting export locations of share %s: %v", id, err)
+	}
+	return getExportServerPath(locations)
+}
+
+// getExportServerPath returns the server and path of the preferred export
+// location, or the first if none is preferred.
+func getExportServerPath(locations []exportLocation) (string, string, error) {
+	if len(locations) == 0 {
+		return "", "", errors.New("share has no export locations")
+	}
+	location := locations[0]
+	for _, l := range locations {
+		if l.Preferred {
+			location = l
+			break
+		}
+	}
+	// e.g. 10.0.0.1:/shares/share-1 or [fd00::1]:/shares/share-1
+	i := strings.LastIndex(location.Path, ":/")
+	if i < 1 {
+		return "", "", fmt.Errorf("export location %q is not of the form server:/path", location.Path)
+	}
+	return strings.Trim(location.Path[:i], "[]"), location.Path[i+1:], nil
+}
+
+// Delete removes the share that was created by Provision represented by the
+// given PV.
+func (p *manilaProvisioner) Delete(volume *v1.PersistentVolume) error {
+	ann, ok := volume.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != p.identity {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	id, ok := volume.Annotations[shareIDAnn]
+	if !ok {
+		return errors.New("share id annotation not found on PV")
+	}
+
+	if err := p.manila.DeleteShare(id); err != nil {
+		return fmt.Errorf("error deleting share %s: %v", id, err)
+	}
+	return nil
+}
+
+func parseParameters(parameters map[string]string) (*manilaProvisionOptions, error) {
+	opts := &manilaProvisionOptions{}
+
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "type":
+			opts.shareType = v
+		case "zone":
+			opts.zone = v
+		case "accesscidr":
+			opts.accessCIDR = v
+		default:
+			return nil, fmt.Errorf("invalid option %q", k)
+		}
+	}
+	// sanity check
+	if opts.accessCIDR == "" {
+		return nil, fmt.Errorf("missing accessCIDR")
+	}
+	if _, _, err := net.ParseCIDR(opts.accessCIDR); err != nil {
+		return nil, fmt.Errorf("invalid accessCIDR %q: %v", opts.accessCIDR, err)
+	}
+	return opts, nil
+}
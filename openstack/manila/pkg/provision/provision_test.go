@@ -0,0 +1,204 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// fakeOpenStack serves keystone's token API and manila's share API, keeping
+// shares in memory. Shares become available after one GET.
+type fakeOpenStack struct {
+	url    string
+	token  string
+	shares map[string]*share
+	access map[string]string
+	auths  int
+}
+
+func (f *fakeOpenStack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v3/auth/tokens" {
+		f.auths++
+		f.token = fmt.Sprintf("token-%d", f.auths)
+		w.Header().Set("X-Subject-Token", f.token)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": {"catalog": [{"type": "sharev2", "endpoints": [{"interface": "internal", "region": "r1", "url": "http://internal"}, {"interface": "public", "region": "r1", "url": "%s/manila/"}]}]}}`, f.url)
+		return
+	}
+	if r.Header.Get("X-Auth-Token") != f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/manila")
+	switch {
+	case r.Method == "POST" && path == "/shares":
+		var req struct {
+			Share share `json:"share"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		s := &share{ID: fmt.Sprintf("share-%d", len(f.shares)), Name: req.Share.Name, Size: req.Share.Size, Status: "creating"}
+		f.shares[s.ID] = s
+		json.NewEncoder(w).Encode(map[string]*share{"share": s})
+	case r.Method == "GET" && strings.HasSuffix(path, "/export_locations"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/shares/"), "/export_locations")
+		fmt.Fprintf(w, `{"export_locations": [{"path": "10.0.0.1:/shares/%s", "preferred": false}, {"path": "10.0.0.2:/shares/%s", "preferred": true}]}`, id, id)
+	case r.Method == "GET":
+		s, ok := f.shares[strings.TrimPrefix(path, "/shares/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]*share{"share": s})
+		s.Status = "available"
+	case r.Method == "POST" && strings.HasSuffix(path, "/action"):
+		var req struct {
+			AllowAccess map[string]string `json:"allow_access"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		f.access[strings.TrimSuffix(strings.TrimPrefix(path, "/shares/"), "/action")] = req.AllowAccess["access_to"]
+	case r.Method == "DELETE":
+		id := strings.TrimPrefix(path, "/shares/")
+		if _, ok := f.shares[id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.shares, id)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func TestGetExportServerPath(t *testing.T) {
+	tests := []struct {
+		name           string
+		locations      []exportLocation
+		expectError    bool
+		expectedServer string
+		expectedPath   string
+	}{
+		{
+			name:           "first",
+			locations:      []exportLocation{{Path: "10.0.0.1:/shares/a"}, {Path: "10.0.0.2:/shares/a"}},
+			expectedServer: "10.0.0.1",
+			expectedPath:   "/shares/a",
+		},
+		{
+			name:           "preferred",
+			locations:      []exportLocation{{Path: "10.0.0.1:/shares/a"}, {Path: "10.0.0.2:/shares/a", Preferred: true}},
+			expectedServer: "10.0.0.2",
+			expectedPath:   "/shares/a",
+		},
+		{
+			name:           "ipv6",
+			locations:      []exportLocation{{Path: "[fd00::1]:/shares/a"}},
+			expectedServer: "fd00::1",
+			expectedPath:   "/shares/a",
+		},
+		{
+			name:        "no locations",
+			expectError: true,
+		},
+		{
+			name:        "not nfs",
+			locations:   []exportLocation{{Path: "\\\\10.0.0.1\\share"}},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		server, path, err := getExportServerPath(test.locations)
+		if test.expectError != (err != nil) {
+			t.Errorf("test case %s: expected error %v but got %v", test.name, test.expectError, err)
+			continue
+		}
+		if server != test.expectedServer || path != test.expectedPath {
+			t.Errorf("test case %s: expected %s:%s but got %s:%s", test.name, test.expectedServer, test.expectedPath, server, path)
+		}
+	}
+}
+
+func TestProvisionDelete(t *testing.T) {
+	fakeOS := &fakeOpenStack{shares: map[string]*share{}, access: map[string]string{}}
+	server := httptest.NewServer(fakeOS)
+	defer server.Close()
+	fakeOS.url = server.URL
+
+	p := NewManilaProvisioner(Credentials{AuthURL: server.URL + "/v3", Region: "r1"}, "id", time.Second).(*manilaProvisioner)
+	p.pollInterval = time.Millisecond
+
+	options := controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        "pvc-1",
+		Parameters:                    map[string]string{"accessCIDR": "10.1.0.0/16", "type": "default"},
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "claim"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): resource.MustParse("1500Mi"),
+					},
+				},
+			},
+		},
+	}
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	id := pv.Annotations[shareIDAnn]
+	if s, ok := fakeOS.shares[id]; !ok || s.Size != 2 || s.Name != "kubernetes-dynamic-pvc-1" {
+		t.Errorf("expected share kubernetes-dynamic-pvc-1 of size 2 but got %+v", s)
+	}
+	if fakeOS.access[id] != "10.1.0.0/16" {
+		t.Errorf("expected access allowed to 10.1.0.0/16 but got %q", fakeOS.access[id])
+	}
+	if pv.Spec.NFS.Server != "10.0.0.2" || pv.Spec.NFS.Path != "/shares/"+id {
+		t.Errorf("unexpected nfs volume source %+v", pv.Spec.NFS)
+	}
+
+	// the token expires, the provisioner must authenticate again
+	fakeOS.token = "expired"
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if _, ok := fakeOS.shares[id]; ok {
+		t.Errorf("expected share %s to be deleted", id)
+	}
+	if fakeOS.auths != 2 {
+		t.Errorf("expected 2 authentications but got %d", fakeOS.auths)
+	}
+
+	// deleting a deleted share succeeds
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting deleted volume: %v", err)
+	}
+
+	options.Parameters = map[string]string{"accessCIDR": "10.1.0.0"}
+	if _, err := p.Provision(options); err == nil {
+		t.Errorf("expected error provisioning with invalid accessCIDR but got none")
+	}
+}
@@ -0,0 +1,23 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package api defines the gRPC protocol the plugin provisioner creates and
+// deletes volumes with plugins over. plugin.pb.go is generated from
+// plugin.proto with protoc-gen-go v1.2.0, the version of golang/protobuf that
+// is vendored, and the license header prepended.
+package api
+
+//go:generate protoc --go_out=plugins=grpc:. plugin.proto
@@ -0,0 +1,214 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/plugin/pkg/api"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// The storage class parameter naming the plugin to provision the class's
+	// volumes with
+	pluginParameter = "plugin"
+
+	// A PV annotation for the name of the plugin that provisioned it, the
+	// one to delete it with
+	annPlugin = "pluginProvisionerPlugin"
+
+	// The extension of the plugins' sockets in the plugin directory
+	socketExt = ".sock"
+)
+
+// NewPluginProvisioner creates a Provisioner that provisions and deletes
+// volumes by calling plugins over gRPC. A storage class names the plugin to
+// provision its volumes with in the parameter plugin, and the plugin is
+// loaded from its unix socket named after it in pluginDir, e.g.
+// /var/lib/provisioner-plugins/example.sock for the plugin example, on first
+// use. Each call fails after timeout if the plugin hasn't returned.
+func NewPluginProvisioner(pluginDir string, timeout time.Duration) controller.Provisioner {
+	return &pluginProvisioner{
+		pluginDir: pluginDir,
+		timeout:   timeout,
+		conns:     map[string]*grpc.ClientConn{},
+	}
+}
+
+type pluginProvisioner struct {
+	// The directory the plugins serve on unix sockets in
+	pluginDir string
+
+	timeout time.Duration
+
+	// The connections to the plugins loaded so far, by name. gRPC reconnects
+	// them if their plugins restart
+	mutex sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+var _ controller.Provisioner = &pluginProvisioner{}
+
+// Provision calls the plugin named by the class's parameter plugin to create a
+// volume and returns a PV object for it.
+func (p *pluginProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	plugin, ok := options.Parameters[pluginParameter]
+	if !ok {
+		return nil, fmt.Errorf("storage class has no parameter %s naming the plugin to provision with", pluginParameter)
+	}
+	client, err := p.getClient(plugin)
+	if err != nil {
+		return nil, err
+	}
+
+	parameters := map[string]string{}
+	for k, v := range options.Parameters {
+		if k != pluginParameter {
+			parameters[k] = v
+		}
+	}
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	req := &api.ProvisionRequest{
+		PvName:         options.PVName,
+		Parameters:     parameters,
+		CapacityBytes:  capacity.Value(),
+		ClaimNamespace: options.PVC.Namespace,
+		ClaimName:      options.PVC.Name,
+	}
+	for _, mode := range options.PVC.Spec.AccessModes {
+		req.AccessModes = append(req.AccessModes, string(mode))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	resp, err := client.Provision(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s failed to provision volume: %v", plugin, err)
+	}
+
+	var source v1.PersistentVolumeSource
+	if err := json.Unmarshal(resp.VolumeSource, &source); err != nil {
+		return nil, fmt.Errorf("plugin %s returned an invalid volume source: %v", plugin, err)
+	}
+	if resp.CapacityBytes != 0 {
+		capacity = *resource.NewQuantity(resp.CapacityBytes, resource.BinarySI)
+	}
+	annotations := map[string]string{}
+	for k, v := range resp.Annotations {
+		annotations[k] = v
+	}
+	annotations[annPlugin] = plugin
+
+	glog.Infof("Plugin %s provisioned volume %q", plugin, options.PVName)
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        options.PVName,
+			Annotations: annotations,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): capacity,
+			},
+			PersistentVolumeSource: source,
+		},
+	}, nil
+}
+
+// Delete calls the plugin that provisioned the given PV to delete its volume.
+// A volume the plugin doesn't find is taken to be deleted already.
+func (p *pluginProvisioner) Delete(volume *v1.PersistentVolume) error {
+	plugin, ok := volume.Annotations[annPlugin]
+	if !ok {
+		return fmt.Errorf("PV doesn't have an annotation %s", annPlugin)
+	}
+	client, err := p.getClient(plugin)
+	if err != nil {
+		return err
+	}
+
+	source, err := json.Marshal(volume.Spec.PersistentVolumeSource)
+	if err != nil {
+		return fmt.Errorf("error encoding volume source: %v", err)
+	}
+	req := &api.DeleteRequest{
+		PvName:       volume.Name,
+		VolumeSource: source,
+		Annotations:  volume.Annotations,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	if _, err := client.Delete(ctx, req); err != nil {
+		if status.Code(err) == codes.NotFound {
+			glog.Infof("Plugin %s didn't find volume %q, it was already deleted", plugin, volume.Name)
+			return nil
+		}
+		return fmt.Errorf("plugin %s failed to delete volume: %v", plugin, err)
+	}
+
+	glog.Infof("Plugin %s deleted volume %q", plugin, volume.Name)
+	return nil
+}
+
+// getClient returns a client of the named plugin, connecting to its socket if
+// it isn't already.
+func (p *pluginProvisioner) getClient(plugin string) (api.ProvisionerClient, error) {
+	if errs := validation.IsDNS1123Subdomain(plugin); len(errs) != 0 {
+		return nil, fmt.Errorf("invalid plugin name %q: %v", plugin, errs)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if conn, ok := p.conns[plugin]; ok {
+		return api.NewProvisionerClient(conn), nil
+	}
+
+	socket := path.Join(p.pluginDir, plugin+socketExt)
+	if _, err := os.Stat(socket); err != nil {
+		return nil, fmt.Errorf("plugin %s isn't loaded: error finding its socket: %v", plugin, err)
+	}
+	conn, err := grpc.Dial(socket, grpc.WithInsecure(), grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout("unix", addr, timeout)
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to plugin %s on %s: %v", plugin, socket, err)
+	}
+	glog.Infof("Loaded plugin %s from %s", plugin, socket)
+	p.conns[plugin] = conn
+	return api.NewProvisionerClient(conn), nil
+}
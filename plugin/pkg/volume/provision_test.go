@@ -0,0 +1,178 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/pkg/api/v1"
+	utiltesting "k8s.io/client-go/util/testing"
+)
+
+// fakeProvisioner provisions NFS volumes of the fake server 10.0.0.1 and
+// records what it was asked to provision and delete.
+type fakeProvisioner struct {
+	options controller.VolumeOptions
+	deleted []*v1.PersistentVolume
+	err     error
+}
+
+var _ controller.Provisioner = &fakeProvisioner{}
+
+func (p *fakeProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	p.options = options
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        options.PVName,
+			Annotations: map[string]string{"example.com/share": "share-1"},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): resource.MustParse("2Gi"),
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				NFS: &v1.NFSVolumeSource{Server: "10.0.0.1", Path: "/export/" + options.PVName},
+			},
+		},
+	}, nil
+}
+
+func (p *fakeProvisioner) Delete(volume *v1.PersistentVolume) error {
+	p.deleted = append(p.deleted, volume)
+	return p.err
+}
+
+func newClaim(size string) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim-1"},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse(size),
+				},
+			},
+		},
+	}
+}
+
+func TestProvisionDelete(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("pluginProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	plugin := &fakeProvisioner{}
+	socket := path.Join(tmpDir, "example"+socketExt)
+	go Serve(socket, NewServer(plugin))
+	wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		_, err := os.Stat(socket)
+		return err == nil, nil
+	})
+
+	p := NewPluginProvisioner(tmpDir, 5*time.Second)
+	options := controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        "pvc-1",
+		PVC:                           newClaim("1Gi"),
+		Parameters:                    map[string]string{"plugin": "example", "tier": "gold"},
+	}
+
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	if expected := map[string]string{"tier": "gold"}; !reflect.DeepEqual(expected, plugin.options.Parameters) {
+		t.Errorf("expected plugin to get parameters %v but got %v", expected, plugin.options.Parameters)
+	}
+	size := plugin.options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	if plugin.options.PVName != "pvc-1" || plugin.options.PVC.Name != "claim-1" || size.Value() != 1024*1024*1024 {
+		t.Errorf("expected plugin to provision pvc-1 of 1Gi for claim-1 but got %+v", plugin.options)
+	}
+	expectedSource := v1.PersistentVolumeSource{NFS: &v1.NFSVolumeSource{Server: "10.0.0.1", Path: "/export/pvc-1"}}
+	if !reflect.DeepEqual(expectedSource, pv.Spec.PersistentVolumeSource) {
+		t.Errorf("expected volume source %+v but got %+v", expectedSource, pv.Spec.PersistentVolumeSource)
+	}
+	if capacity := pv.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]; capacity.Value() != 2*1024*1024*1024 {
+		t.Errorf("expected capacity the plugin returned 2Gi but got %s", capacity.String())
+	}
+	if expected := map[string]string{"example.com/share": "share-1", annPlugin: "example"}; !reflect.DeepEqual(expected, pv.Annotations) {
+		t.Errorf("expected annotations %v but got %v", expected, pv.Annotations)
+	}
+	if pv.Spec.PersistentVolumeReclaimPolicy != v1.PersistentVolumeReclaimDelete || !reflect.DeepEqual(pv.Spec.AccessModes, options.PVC.Spec.AccessModes) {
+		t.Errorf("expected reclaim policy and access modes of the claim but got %+v", pv.Spec)
+	}
+
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if len(plugin.deleted) != 1 || plugin.deleted[0].Name != "pvc-1" || !reflect.DeepEqual(expectedSource, plugin.deleted[0].Spec.PersistentVolumeSource) {
+		t.Errorf("expected plugin to delete pvc-1 with source %+v but got %v", expectedSource, plugin.deleted)
+	}
+
+	plugin.err = fmt.Errorf("filer is down")
+	if _, err := p.Provision(options); err == nil {
+		t.Errorf("expected error provisioning volume when the plugin fails but got none")
+	}
+	if err := p.Delete(pv); err == nil {
+		t.Errorf("expected error deleting volume when the plugin fails but got none")
+	}
+}
+
+func TestPluginErrors(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("pluginProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name       string
+		parameters map[string]string
+	}{
+		{
+			name:       "no plugin parameter",
+			parameters: map[string]string{},
+		},
+		{
+			name:       "plugin not loaded",
+			parameters: map[string]string{"plugin": "missing"},
+		},
+		{
+			name:       "invalid plugin name",
+			parameters: map[string]string{"plugin": "../example"},
+		},
+	}
+	p := NewPluginProvisioner(tmpDir, time.Second)
+	for _, test := range tests {
+		options := controller.VolumeOptions{PVName: "pvc-1", PVC: newClaim("1Gi"), Parameters: test.parameters}
+		if _, err := p.Provision(options); err == nil {
+			t.Errorf("test case: %s: expected error provisioning volume but got none", test.name)
+		}
+	}
+
+	if err := p.Delete(&v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"}}); err == nil {
+		t.Errorf("expected error deleting volume without plugin annotation but got none")
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/plugin/pkg/api"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Serve serves the given plugin on the unix socket at path socket, e.g.
+// /var/lib/provisioner-plugins/example.sock for the plugin example, until it
+// fails. Plugins written in Go can serve a controller.Provisioner with
+// NewServer; others implement api.ProvisionerServer from plugin.proto.
+func Serve(socket string, server api.ProvisionerServer) error {
+	// A socket left over from a previous run would make listening fail
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing socket %s: %v", socket, err)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %v", socket, err)
+	}
+
+	s := grpc.NewServer()
+	api.RegisterProvisionerServer(s, server)
+	glog.Infof("Serving plugin on %s", socket)
+	return s.Serve(listener)
+}
+
+type provisionerServer struct {
+	provisioner controller.Provisioner
+}
+
+var _ api.ProvisionerServer = &provisionerServer{}
+
+// NewServer returns a plugin that provisions and deletes volumes with the
+// given Provisioner, so that a provisioner written against the library can be
+// run as a plugin. The claim it is given has only the namespace, name, access
+// modes and requested size of the real one.
+func NewServer(provisioner controller.Provisioner) api.ProvisionerServer {
+	return &provisionerServer{provisioner: provisioner}
+}
+
+func (s *provisionerServer) Provision(ctx context.Context, req *api.ProvisionRequest) (*api.ProvisionResponse, error) {
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: req.ClaimNamespace,
+			Name:      req.ClaimName,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): *resource.NewQuantity(req.CapacityBytes, resource.BinarySI),
+				},
+			},
+		},
+	}
+	for _, mode := range req.AccessModes {
+		claim.Spec.AccessModes = append(claim.Spec.AccessModes, v1.PersistentVolumeAccessMode(mode))
+	}
+	volume, err := s.provisioner.Provision(controller.VolumeOptions{
+		PVName:     req.PvName,
+		PVC:        claim,
+		Parameters: req.Parameters,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	source, err := json.Marshal(volume.Spec.PersistentVolumeSource)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error encoding volume source: %v", err)
+	}
+	capacity := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+	return &api.ProvisionResponse{
+		VolumeSource:  source,
+		CapacityBytes: capacity.Value(),
+		Annotations:   volume.Annotations,
+	}, nil
+}
+
+func (s *provisionerServer) Delete(ctx context.Context, req *api.DeleteRequest) (*api.DeleteResponse, error) {
+	volume := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        req.PvName,
+			Annotations: req.Annotations,
+		},
+	}
+	if err := json.Unmarshal(req.VolumeSource, &volume.Spec.PersistentVolumeSource); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid volume source: %v", err)
+	}
+	if err := s.provisioner.Delete(volume); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &api.DeleteResponse{}, nil
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Kodo's own status codes
+	statusNoSuchEntry  = 612
+	statusBucketExists = 614
+	statusNoSuchBucket = 631
+
+	formContentType = "application/x-www-form-urlencoded"
+	// listLimit is the most objects an rsf list returns
+	listLimit = "1000"
+	// lifecycleRuleName is the name of the bucket lifecycle rule created
+	lifecycleRuleName = "kubernetes"
+	// maxErrorBodyLength is how much of a non-JSON error body is reported
+	maxErrorBodyLength = 512
+)
+
+// KodoHosts are the Kodo API endpoints: uc manages buckets, rs objects and
+// rsf lists objects.
+type KodoHosts struct {
+	UC  string
+	RS  string
+	RSF string
+}
+
+// DefaultKodoHosts are the endpoints of Qiniu's public cloud.
+var DefaultKodoHosts = KodoHosts{
+	UC:  "https://uc.qbox.me",
+	RS:  "https://rs.qiniu.com",
+	RSF: "https://rsf.qiniu.com",
+}
+
+// kodoClient manages buckets with the Kodo API, authorizing requests with a
+// QBox token signed by an access key.
+type kodoClient struct {
+	accessKey string
+	secretKey []byte
+	hosts     KodoHosts
+	client    *http.Client
+}
+
+func newKodoClient(hosts KodoHosts, accessKey, secretKey string) *kodoClient {
+	return &kodoClient{
+		accessKey: accessKey,
+		secretKey: []byte(secretKey),
+		hosts:     hosts,
+		client:    &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// kodoError is the body of a Kodo error response
+type kodoError struct {
+	Error string `json:"error"`
+}
+
+// listResult is the body of an rsf list response
+type listResult struct {
+	Marker string `json:"marker"`
+	Items  []struct {
+		Key string `json:"key"`
+	} `json:"items"`
+}
+
+// sign returns the QBox token of the request: the access key and the HMAC-SHA1
+// of the path, query and, for forms, body.
+func (c *kodoClient) sign(req *http.Request, body string) string {
+	data := req.URL.EscapedPath()
+	if req.URL.RawQuery != "" {
+		data += "?" + req.URL.RawQuery
+	}
+	data += "\n"
+	if req.Header.Get("Content-Type") == formContentType {
+		data += body
+	}
+	mac := hmac.New(sha1.New, c.secretKey)
+	mac.Write([]byte(data))
+	return c.accessKey + ":" + base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// do sends a signed request to host and decodes the response body into out,
+// if not nil, if the status is 200 or one of ok.
+func (c *kodoClient) do(method, host, path string, query, form url.Values, out interface{}, ok ...int) (int, error) {
+	body := form.Encode()
+	u := strings.TrimSuffix(host, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, strings.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", formContentType)
+	}
+	req.Header.Set("Authorization", "QBox "+c.sign(req, body))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		if out != nil {
+			if err := json.Unmarshal(respBody, out); err != nil {
+				return resp.StatusCode, fmt.Errorf("error parsing response of %s %s: %v", method, path, err)
+			}
+		}
+		return resp.StatusCode, nil
+	}
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return resp.StatusCode, nil
+		}
+	}
+	kodoErr := &kodoError{}
+	if json.Unmarshal(respBody, kodoErr) == nil && kodoErr.Error != "" {
+		return resp.StatusCode, fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode, kodoErr.Error)
+	}
+	if len(respBody) > maxErrorBodyLength {
+		respBody = respBody[:maxErrorBodyLength]
+	}
+	return resp.StatusCode, fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode, respBody)
+}
+
+// createBucket creates the bucket in the region, e.g. z0. It is not an error
+// if the bucket already exists, e.g. when retrying: bucket names are global
+// so the following calls fail if it isn't ours.
+func (c *kodoClient) createBucket(bucket, region string) error {
+	_, err := c.do("POST", c.hosts.UC, "/mkbucketv3/"+bucket+"/region/"+region, nil, nil, nil, statusBucketExists)
+	return err
+}
+
+// setPrivate sets whether reading the bucket's objects needs a token.
+func (c *kodoClient) setPrivate(bucket string, private bool) error {
+	value := "0"
+	if private {
+		value = "1"
+	}
+	_, err := c.do("POST", c.hosts.UC, "/private", nil, url.Values{"bucket": {bucket}, "private": {value}}, nil)
+	return err
+}
+
+// addLifecycleRule adds a rule to the bucket moving its objects to low
+// frequency storage and/or deleting them the given number of days after
+// they're written. Zero days disables either.
+func (c *kodoClient) addLifecycleRule(bucket string, toLineAfterDays, deleteAfterDays int) error {
+	form := url.Values{
+		"bucket":             {bucket},
+		"name":               {lifecycleRuleName},
+		"prefix":             {""},
+		"to_line_after_days": {fmt.Sprint(toLineAfterDays)},
+		"delete_after_days":  {fmt.Sprint(deleteAfterDays)},
+	}
+	_, err := c.do("POST", c.hosts.UC, "/rules/add", nil, form, nil)
+	return err
+}
+
+// dropBucket deletes the bucket, which must be empty. It is not an error if
+// the bucket doesn't exist.
+func (c *kodoClient) dropBucket(bucket string) error {
+	_, err := c.do("POST", c.hosts.UC, "/drop/"+bucket, nil, nil, nil, statusNoSuchBucket)
+	return err
+}
+
+// deleteObjects deletes every object in the bucket. It is not an error if the
+// bucket doesn't exist.
+func (c *kodoClient) deleteObjects(bucket string) error {
+	marker := ""
+	for {
+		query := url.Values{"bucket": {bucket}, "limit": {listLimit}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		result := &listResult{}
+		status, err := c.do("POST", c.hosts.RSF, "/list", query, nil, result, statusNoSuchBucket)
+		if err != nil {
+			return err
+		}
+		if status == statusNoSuchBucket {
+			return nil
+		}
+		for _, item := range result.Items {
+			entry := base64.URLEncoding.EncodeToString([]byte(bucket + ":" + item.Key))
+			if _, err := c.do("POST", c.hosts.RS, "/delete/"+entry, nil, nil, nil, statusNoSuchEntry); err != nil {
+				return err
+			}
+		}
+		if result.Marker == "" {
+			return nil
+		}
+		marker = result.Marker
+	}
+}
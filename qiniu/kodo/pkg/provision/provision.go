@@ -0,0 +1,299 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/helper"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	provisionerIDAnn = "kodoProvisionerIdentity"
+
+	bucketPrefix = "kubernetes-dynamic-"
+
+	defaultRegion = "z0"
+	defaultDriver = "qiniu/kodo"
+
+	aclPrivate    = "private"
+	aclPublicRead = "public-read"
+
+	// the keys of the access key in the secrets
+	accessKeyKey = "accessKey"
+	secretKeyKey = "secretKey"
+
+	// the flexvolume options of the PVs
+	bucketOption = "bucket"
+	regionOption = "region"
+)
+
+type kodoProvisionOptions struct {
+	region          string
+	acl             string
+	toLineAfterDays int
+	deleteAfterDays int
+	secretName      string
+	secretNamespace string
+	driver          string
+}
+
+type kodoProvisioner struct {
+	// Kubernetes Client. Use to retrieve the access keys and manage the
+	// volumes' secrets
+	client kubernetes.Interface
+	// Identity of this kodoProvisioner. Used to identify "this" provisioner's
+	// PVs.
+	identity string
+	hosts    KodoHosts
+	// newKodoClient is replaced by tests
+	newKodoClient func(hosts KodoHosts, accessKey, secretKey string) *kodoClient
+}
+
+// NewKodoProvisioner creates a new Kodo provisioner using the Kodo API at the
+// given hosts.
+func NewKodoProvisioner(client kubernetes.Interface, id string, hosts KodoHosts) controller.Provisioner {
+	return &kodoProvisioner{
+		client:        client,
+		identity:      id,
+		hosts:         hosts,
+		newKodoClient: newKodoClient,
+	}
+}
+
+var _ controller.Provisioner = &kodoProvisioner{}
+
+// Provision creates a Kodo bucket with the class's ACL and lifecycle, and a
+// secret with the class's access key in the claim's namespace, and returns a
+// flexvolume PV for the Kodo FUSE mounter to mount it.
+func (p *kodoProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	opts, err := parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := p.getAccessKey(opts)
+	if err != nil {
+		return nil, err
+	}
+	kodo := p.newKodoClient(p.hosts, string(keys[accessKeyKey]), string(keys[secretKeyKey]))
+
+	bucket := bucketPrefix + options.PVName
+	if err := kodo.createBucket(bucket, opts.region); err != nil {
+		glog.Errorf("failed to create bucket %q in region %s: %v", bucket, opts.region, err)
+		return nil, err
+	}
+	if err := p.configureBucket(kodo, bucket, opts); err != nil {
+		glog.Errorf("failed to configure bucket %q: %v", bucket, err)
+		p.dropBucket(kodo, bucket)
+		return nil, err
+	}
+	glog.Infof("successfully created bucket %q in region %s", bucket, opts.region)
+
+	// flexvolume PVs can only refer to secrets in the pod's namespace
+	secretName := getSecretName(options.PVName)
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: options.PVC.Namespace,
+			Name:      secretName,
+		},
+		Data: keys,
+		Type: v1.SecretType(opts.driver),
+	}
+	if _, err := p.client.Core().Secrets(options.PVC.Namespace).Create(secret); err != nil && !apierrors.IsAlreadyExists(err) {
+		p.dropBucket(kodo, bucket)
+		return nil, fmt.Errorf("failed to create secret %s/%s: %v", options.PVC.Namespace, secretName, err)
+	}
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				provisionerIDAnn: p.identity,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			// buckets have no quota, the capacity is only nominal
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				FlexVolume: &v1.FlexVolumeSource{
+					Driver:    opts.driver,
+					SecretRef: &v1.LocalObjectReference{Name: secretName},
+					ReadOnly:  false,
+					Options: map[string]string{
+						bucketOption: bucket,
+						regionOption: opts.region,
+					},
+				},
+			},
+		},
+	}
+
+	return pv, nil
+}
+
+// configureBucket sets the bucket's ACL and lifecycle rule.
+func (p *kodoProvisioner) configureBucket(kodo *kodoClient, bucket string, opts *kodoProvisionOptions) error {
+	if err := kodo.setPrivate(bucket, opts.acl == aclPrivate); err != nil {
+		return err
+	}
+	if opts.toLineAfterDays > 0 || opts.deleteAfterDays > 0 {
+		if err := kodo.addLifecycleRule(bucket, opts.toLineAfterDays, opts.deleteAfterDays); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropBucket rolls back the creation of a bucket.
+func (p *kodoProvisioner) dropBucket(kodo *kodoClient, bucket string) {
+	if err := kodo.dropBucket(bucket); err != nil {
+		glog.Errorf("failed to roll back creation of bucket %q: %v", bucket, err)
+	}
+}
+
+// Delete removes the objects of the bucket that was created by Provision
+// represented by the given PV, the bucket, and the PV's secret.
+func (p *kodoProvisioner) Delete(volume *v1.PersistentVolume) error {
+	ann, ok := volume.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != p.identity {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	if volume.Spec.FlexVolume == nil {
+		return errors.New("PV is not a flexvolume")
+	}
+	bucket := volume.Spec.FlexVolume.Options[bucketOption]
+	if bucket == "" {
+		return fmt.Errorf("PV has no %s option", bucketOption)
+	}
+
+	class, err := p.client.StorageV1().StorageClasses().Get(helper.GetPersistentVolumeClass(volume), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	opts, err := parseParameters(class.Parameters)
+	if err != nil {
+		return err
+	}
+	keys, err := p.getAccessKey(opts)
+	if err != nil {
+		return err
+	}
+	kodo := p.newKodoClient(p.hosts, string(keys[accessKeyKey]), string(keys[secretKeyKey]))
+	if err := kodo.deleteObjects(bucket); err != nil {
+		return err
+	}
+	if err := kodo.dropBucket(bucket); err != nil {
+		return err
+	}
+
+	if volume.Spec.ClaimRef == nil || volume.Spec.FlexVolume.SecretRef == nil {
+		return nil
+	}
+	err = p.client.Core().Secrets(volume.Spec.ClaimRef.Namespace).Delete(volume.Spec.FlexVolume.SecretRef.Name, nil)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleted bucket %q but failed to delete secret: %v", bucket, err)
+	}
+
+	return nil
+}
+
+func getSecretName(pvName string) string {
+	return "kodo-" + pvName
+}
+
+// getAccessKey returns the access key from the class's secret.
+func (p *kodoProvisioner) getAccessKey(opts *kodoProvisionOptions) (map[string][]byte, error) {
+	secret, err := p.client.Core().Secrets(opts.secretNamespace).Get(opts.secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access key secret %s/%s: %v", opts.secretNamespace, opts.secretName, err)
+	}
+	accessKey, secretKey := secret.Data[accessKeyKey], secret.Data[secretKeyKey]
+	if len(accessKey) == 0 || len(secretKey) == 0 {
+		return nil, fmt.Errorf("access key secret %s/%s must have keys %s and %s", opts.secretNamespace, opts.secretName, accessKeyKey, secretKeyKey)
+	}
+	return map[string][]byte{
+		accessKeyKey: accessKey,
+		secretKeyKey: secretKey,
+	}, nil
+}
+
+func parseParameters(parameters map[string]string) (*kodoProvisionOptions, error) {
+	opts := &kodoProvisionOptions{
+		region:          defaultRegion,
+		acl:             aclPrivate,
+		secretNamespace: "default",
+		driver:          defaultDriver,
+	}
+
+	var err error
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "region":
+			opts.region = v
+		case "acl":
+			opts.acl = v
+		case "tolineafterdays":
+			opts.toLineAfterDays, err = strconv.Atoi(v)
+			if err != nil || opts.toLineAfterDays < 0 {
+				return nil, fmt.Errorf("invalid toLineAfterDays %q", v)
+			}
+		case "deleteafterdays":
+			opts.deleteAfterDays, err = strconv.Atoi(v)
+			if err != nil || opts.deleteAfterDays < 0 {
+				return nil, fmt.Errorf("invalid deleteAfterDays %q", v)
+			}
+		case "secretname":
+			opts.secretName = v
+		case "secretnamespace":
+			opts.secretNamespace = v
+		case "driver":
+			opts.driver = v
+		default:
+			return nil, fmt.Errorf("invalid option %q", k)
+		}
+	}
+	// sanity check
+	if opts.acl != aclPrivate && opts.acl != aclPublicRead {
+		return nil, fmt.Errorf("invalid acl %q, must be %s or %s", opts.acl, aclPrivate, aclPublicRead)
+	}
+	if opts.deleteAfterDays > 0 && opts.toLineAfterDays >= opts.deleteAfterDays {
+		return nil, fmt.Errorf("toLineAfterDays %d must be less than deleteAfterDays %d", opts.toLineAfterDays, opts.deleteAfterDays)
+	}
+	if opts.secretName == "" {
+		return nil, fmt.Errorf("missing secretName")
+	}
+	return opts, nil
+}
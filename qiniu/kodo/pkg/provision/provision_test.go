@@ -0,0 +1,238 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	storage "k8s.io/client-go/pkg/apis/storage/v1"
+)
+
+type fakeBucket struct {
+	region    string
+	private   bool
+	lifecycle string
+	objects   map[string]bool
+}
+
+// fakeKodo serves the uc, rs and rsf APIs from memory, checking requests are
+// signed by the test access key.
+type fakeKodo struct {
+	buckets map[string]*fakeBucket
+}
+
+func (f *fakeKodo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	client := newKodoClient(KodoHosts{}, "ak", "sk")
+	if r.Header.Get("Authorization") != "QBox "+client.sign(r, r.PostForm.Encode()) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch parts[0] {
+	case "mkbucketv3":
+		if _, ok := f.buckets[parts[1]]; ok {
+			w.WriteHeader(statusBucketExists)
+			return
+		}
+		f.buckets[parts[1]] = &fakeBucket{region: parts[3], objects: map[string]bool{}}
+	case "private":
+		f.buckets[r.PostForm.Get("bucket")].private = r.PostForm.Get("private") == "1"
+	case "rules":
+		f.buckets[r.PostForm.Get("bucket")].lifecycle = r.PostForm.Get("to_line_after_days") + "/" + r.PostForm.Get("delete_after_days")
+	case "list":
+		bucket, ok := f.buckets[r.Form.Get("bucket")]
+		if !ok {
+			w.WriteHeader(statusNoSuchBucket)
+			return
+		}
+		// list one key at a time to exercise paging
+		keys := []string{}
+		for k := range bucket.objects {
+			if k > r.Form.Get("marker") {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		result := map[string]interface{}{"items": []map[string]string{}}
+		if len(keys) > 0 {
+			result["items"] = []map[string]string{{"key": keys[0]}}
+		}
+		if len(keys) > 1 {
+			result["marker"] = keys[0]
+		}
+		json.NewEncoder(w).Encode(result)
+	case "delete":
+		entry, _ := base64.URLEncoding.DecodeString(parts[1])
+		bucketKey := strings.SplitN(string(entry), ":", 2)
+		delete(f.buckets[bucketKey[0]].objects, bucketKey[1])
+	case "drop":
+		bucket, ok := f.buckets[parts[1]]
+		if !ok {
+			w.WriteHeader(statusNoSuchBucket)
+			return
+		}
+		if len(bucket.objects) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(kodoError{Error: "bucket not empty"})
+			return
+		}
+		delete(f.buckets, parts[1])
+	}
+}
+
+func TestProvisionDelete(t *testing.T) {
+	kodo := &fakeKodo{buckets: map[string]*fakeBucket{}}
+	server := httptest.NewServer(kodo)
+	defer server.Close()
+
+	accessKeySecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kodo-access-key"},
+		Data:       map[string][]byte{accessKeyKey: []byte("ak"), secretKeyKey: []byte("sk")},
+	}
+	class := &storage.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "kodo"},
+		Parameters: map[string]string{
+			"region":          "z2",
+			"toLineAfterDays": "30",
+			"deleteAfterDays": "90",
+			"secretName":      "kodo-access-key",
+			"secretNamespace": "kube-system",
+		},
+	}
+	client := fake.NewSimpleClientset(accessKeySecret, class)
+	p := NewKodoProvisioner(client, "id", KodoHosts{UC: server.URL, RS: server.URL, RSF: server.URL})
+
+	options := controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        "pvc-1",
+		Parameters:                    class.Parameters,
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "claim"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Gi"),
+					},
+				},
+			},
+		},
+	}
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	bucket, ok := kodo.buckets["kubernetes-dynamic-pvc-1"]
+	if !ok {
+		t.Fatalf("expected bucket kubernetes-dynamic-pvc-1 to be created")
+	}
+	if bucket.region != "z2" || !bucket.private || bucket.lifecycle != "30/90" {
+		t.Errorf("expected private bucket in region z2 with lifecycle 30/90 but got %+v", bucket)
+	}
+	flex := pv.Spec.FlexVolume
+	if flex.Driver != defaultDriver || flex.Options[bucketOption] != "kubernetes-dynamic-pvc-1" || flex.Options[regionOption] != "z2" {
+		t.Errorf("expected %s flexvolume of bucket kubernetes-dynamic-pvc-1 in z2 but got %v", defaultDriver, flex)
+	}
+	secret, err := client.Core().Secrets("ns").Get(flex.SecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting volume secret: %v", err)
+	}
+	if string(secret.Type) != defaultDriver || string(secret.Data[secretKeyKey]) != "sk" {
+		t.Errorf("expected volume secret of type %s with the access key but got %v", defaultDriver, secret)
+	}
+
+	// written by a pod
+	bucket.objects["a"] = true
+	bucket.objects["b/c"] = true
+
+	pv.Annotations[v1.BetaStorageClassAnnotation] = "kodo"
+	pv.Spec.ClaimRef = &v1.ObjectReference{Namespace: "ns", Name: "claim"}
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if _, ok := kodo.buckets["kubernetes-dynamic-pvc-1"]; ok {
+		t.Errorf("expected bucket kubernetes-dynamic-pvc-1 to be deleted")
+	}
+	if _, err := client.Core().Secrets("ns").Get(flex.SecretRef.Name, metav1.GetOptions{}); err == nil {
+		t.Errorf("expected volume secret to be deleted")
+	}
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("expected deleting a deleted volume to succeed but got: %v", err)
+	}
+}
+
+func TestParseParameters(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		expectError bool
+	}{
+		{
+			name:       "minimal",
+			parameters: map[string]string{"secretName": "s"},
+		},
+		{
+			name:       "public with lifecycle",
+			parameters: map[string]string{"secretName": "s", "acl": "public-read", "toLineAfterDays": "30", "deleteAfterDays": "90"},
+		},
+		{
+			name:        "no secret",
+			parameters:  map[string]string{},
+			expectError: true,
+		},
+		{
+			name:        "invalid acl",
+			parameters:  map[string]string{"secretName": "s", "acl": "public-read-write"},
+			expectError: true,
+		},
+		{
+			name:        "moved to low frequency storage after deletion",
+			parameters:  map[string]string{"secretName": "s", "toLineAfterDays": "90", "deleteAfterDays": "30"},
+			expectError: true,
+		},
+		{
+			name:        "negative days",
+			parameters:  map[string]string{"secretName": "s", "deleteAfterDays": "-1"},
+			expectError: true,
+		},
+		{
+			name:        "unknown parameter",
+			parameters:  map[string]string{"secretName": "s", "foo": "bar"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		_, err := parseParameters(test.parameters)
+		if test.expectError && err == nil {
+			t.Errorf("Test %s: expected error but got none", test.name)
+		} else if !test.expectError && err != nil {
+			t.Errorf("Test %s: expected no error but got: %v", test.name, err)
+		}
+	}
+}
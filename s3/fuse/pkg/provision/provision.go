@@ -0,0 +1,287 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/helper"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	provisionerIDAnn = "s3FuseProvisionerIdentity"
+
+	bucketPrefix = "kubernetes-dynamic-"
+
+	defaultDriver = "s3/goofys"
+
+	// the keys of the credentials in the secrets
+	accessKeyIDKey     = "accessKeyID"
+	secretAccessKeyKey = "secretAccessKey"
+
+	// the flexvolume options of the PVs
+	bucketOption   = "bucket"
+	prefixOption   = "prefix"
+	endpointOption = "endpoint"
+	regionOption   = "region"
+)
+
+type s3ProvisionOptions struct {
+	endpoint        string
+	region          string
+	secretName      string
+	secretNamespace string
+	// bucket, if set, is the bucket to create a prefix per volume in instead
+	// of a bucket per volume
+	bucket string
+	driver string
+}
+
+type s3Provisioner struct {
+	// Kubernetes Client. Use to retrieve the credentials and manage the
+	// volumes' secrets
+	client kubernetes.Interface
+	// Identity of this s3Provisioner. Used to identify "this" provisioner's
+	// PVs.
+	identity string
+	// newS3Client is replaced by tests
+	newS3Client func(endpoint, region, accessKeyID, secretAccessKey string) *s3Client
+}
+
+// NewS3Provisioner creates a new S3 FUSE provisioner.
+func NewS3Provisioner(client kubernetes.Interface, id string) controller.Provisioner {
+	return &s3Provisioner{
+		client:      client,
+		identity:    id,
+		newS3Client: newS3Client,
+	}
+}
+
+var _ controller.Provisioner = &s3Provisioner{}
+
+// Provision creates a bucket, or a prefix in the class's bucket, and a secret
+// with the class's credentials in the claim's namespace, and returns a
+// flexvolume PV for a FUSE driver to mount it.
+func (p *s3Provisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	opts, err := parseParameters(options.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := p.getCredentials(opts)
+	if err != nil {
+		return nil, err
+	}
+	s3 := p.newS3Client(opts.endpoint, opts.region, string(credentials[accessKeyIDKey]), string(credentials[secretAccessKeyKey]))
+
+	flexOptions := map[string]string{
+		endpointOption: opts.endpoint,
+		regionOption:   opts.region,
+	}
+	// rollback undoes the bucket or prefix creation if creating the secret
+	// fails
+	var rollback func() error
+	if opts.bucket == "" {
+		bucket := bucketPrefix + options.PVName
+		if err := s3.createBucket(bucket); err != nil {
+			glog.Errorf("failed to create bucket %q at %s: %v", bucket, opts.endpoint, err)
+			return nil, err
+		}
+		glog.Infof("successfully created bucket %q at %s", bucket, opts.endpoint)
+		flexOptions[bucketOption] = bucket
+		rollback = func() error { return s3.deleteBucket(bucket) }
+	} else {
+		prefix := options.PVName
+		// a directory marker, so that s3fs too can mount the prefix before
+		// anything is written to it
+		if err := s3.putObject(opts.bucket, prefix+"/"); err != nil {
+			glog.Errorf("failed to create prefix %q in bucket %q at %s: %v", prefix, opts.bucket, opts.endpoint, err)
+			return nil, err
+		}
+		glog.Infof("successfully created prefix %q in bucket %q at %s", prefix, opts.bucket, opts.endpoint)
+		flexOptions[bucketOption] = opts.bucket
+		flexOptions[prefixOption] = prefix
+		rollback = func() error { return s3.deleteObjects(opts.bucket, prefix+"/") }
+	}
+
+	// flexvolume PVs can only refer to secrets in the pod's namespace
+	secretName := getSecretName(options.PVName)
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: options.PVC.Namespace,
+			Name:      secretName,
+		},
+		Data: credentials,
+		Type: v1.SecretType(opts.driver),
+	}
+	if _, err := p.client.Core().Secrets(options.PVC.Namespace).Create(secret); err != nil && !apierrors.IsAlreadyExists(err) {
+		if rollbackErr := rollback(); rollbackErr != nil {
+			glog.Errorf("failed to roll back volume in bucket %q after failing to create secret: %v", flexOptions[bucketOption], rollbackErr)
+		}
+		return nil, fmt.Errorf("failed to create secret %s/%s: %v", options.PVC.Namespace, secretName, err)
+	}
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+			Annotations: map[string]string{
+				provisionerIDAnn: p.identity,
+			},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			// object storage has no quota, the capacity is only nominal
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				FlexVolume: &v1.FlexVolumeSource{
+					Driver:    opts.driver,
+					SecretRef: &v1.LocalObjectReference{Name: secretName},
+					ReadOnly:  false,
+					Options:   flexOptions,
+				},
+			},
+		},
+	}
+
+	return pv, nil
+}
+
+// Delete removes the objects of the bucket or prefix that was created by
+// Provision represented by the given PV, the bucket itself if it was created
+// for the PV, and the PV's secret.
+func (p *s3Provisioner) Delete(volume *v1.PersistentVolume) error {
+	ann, ok := volume.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != p.identity {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	if volume.Spec.FlexVolume == nil {
+		return errors.New("PV is not a flexvolume")
+	}
+	flexOptions := volume.Spec.FlexVolume.Options
+	bucket, prefix := flexOptions[bucketOption], flexOptions[prefixOption]
+	if bucket == "" {
+		return fmt.Errorf("PV has no %s option", bucketOption)
+	}
+
+	class, err := p.client.StorageV1().StorageClasses().Get(helper.GetPersistentVolumeClass(volume), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	opts, err := parseParameters(class.Parameters)
+	if err != nil {
+		return err
+	}
+	credentials, err := p.getCredentials(opts)
+	if err != nil {
+		return err
+	}
+	// the PV records where its bucket is, in case the class has changed since
+	s3 := p.newS3Client(flexOptions[endpointOption], flexOptions[regionOption], string(credentials[accessKeyIDKey]), string(credentials[secretAccessKeyKey]))
+	if prefix != "" {
+		if err := s3.deleteObjects(bucket, prefix+"/"); err != nil {
+			return err
+		}
+	} else {
+		if err := s3.deleteObjects(bucket, ""); err != nil {
+			return err
+		}
+		if err := s3.deleteBucket(bucket); err != nil {
+			return err
+		}
+	}
+
+	if volume.Spec.ClaimRef == nil || volume.Spec.FlexVolume.SecretRef == nil {
+		return nil
+	}
+	err = p.client.Core().Secrets(volume.Spec.ClaimRef.Namespace).Delete(volume.Spec.FlexVolume.SecretRef.Name, nil)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleted bucket %q but failed to delete secret: %v", bucket, err)
+	}
+
+	return nil
+}
+
+func getSecretName(pvName string) string {
+	return "s3-" + pvName
+}
+
+// getCredentials returns the access key from the class's secret.
+func (p *s3Provisioner) getCredentials(opts *s3ProvisionOptions) (map[string][]byte, error) {
+	secret, err := p.client.Core().Secrets(opts.secretNamespace).Get(opts.secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials secret %s/%s: %v", opts.secretNamespace, opts.secretName, err)
+	}
+	accessKeyID, secretAccessKey := secret.Data[accessKeyIDKey], secret.Data[secretAccessKeyKey]
+	if len(accessKeyID) == 0 || len(secretAccessKey) == 0 {
+		return nil, fmt.Errorf("credentials secret %s/%s must have keys %s and %s", opts.secretNamespace, opts.secretName, accessKeyIDKey, secretAccessKeyKey)
+	}
+	return map[string][]byte{
+		accessKeyIDKey:     accessKeyID,
+		secretAccessKeyKey: secretAccessKey,
+	}, nil
+}
+
+func parseParameters(parameters map[string]string) (*s3ProvisionOptions, error) {
+	opts := &s3ProvisionOptions{
+		region:          defaultRegion,
+		secretNamespace: "default",
+		driver:          defaultDriver,
+	}
+
+	for k, v := range parameters {
+		switch strings.ToLower(k) {
+		case "endpoint":
+			opts.endpoint = v
+		case "region":
+			opts.region = v
+		case "secretname":
+			opts.secretName = v
+		case "secretnamespace":
+			opts.secretNamespace = v
+		case "bucket":
+			opts.bucket = v
+		case "driver":
+			opts.driver = v
+		default:
+			return nil, fmt.Errorf("invalid option %q", k)
+		}
+	}
+	// sanity check
+	if opts.endpoint == "" {
+		return nil, fmt.Errorf("missing endpoint")
+	}
+	if opts.secretName == "" {
+		return nil, fmt.Errorf("missing secretName")
+	}
+	return opts, nil
+}
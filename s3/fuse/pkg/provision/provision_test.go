@@ -0,0 +1,240 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	storage "k8s.io/client-go/pkg/apis/storage/v1"
+)
+
+// fakeS3 serves path-style bucket and object requests from memory, checking
+// requests are signed by accessKeyID.
+type fakeS3 struct {
+	accessKeyID string
+	// buckets maps bucket names to their object keys
+	buckets map[string]map[string]bool
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+f.accessKeyID+"/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, key := parts[0], ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	objects, exists := f.buckets[bucket]
+	if !exists && !(r.Method == "PUT" && key == "") {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "<Error><Code>NoSuchBucket</Code></Error>")
+		return
+	}
+	switch {
+	case r.Method == "PUT" && key == "":
+		if exists {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, "<Error><Code>BucketAlreadyOwnedByYou</Code></Error>")
+			return
+		}
+		f.buckets[bucket] = map[string]bool{}
+	case r.Method == "PUT":
+		objects[key] = true
+	case r.Method == "GET":
+		// list one key at a time to exercise paging
+		keys := []string{}
+		for k := range objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) && k > r.URL.Query().Get("marker") {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		fmt.Fprint(w, "<ListBucketResult>")
+		if len(keys) > 0 {
+			fmt.Fprintf(w, "<IsTruncated>%v</IsTruncated><Contents><Key>%s</Key></Contents>", len(keys) > 1, keys[0])
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+	case r.Method == "DELETE" && key == "":
+		if len(objects) > 0 {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, "<Error><Code>BucketNotEmpty</Code></Error>")
+			return
+		}
+		delete(f.buckets, bucket)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "DELETE":
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestProvisionDelete(t *testing.T) {
+	s3 := &fakeS3{accessKeyID: "id", buckets: map[string]map[string]bool{"shared": {}}}
+	server := httptest.NewServer(s3)
+	defer server.Close()
+
+	credentialsSecret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "s3-credentials"},
+		Data:       map[string][]byte{accessKeyIDKey: []byte("id"), secretAccessKeyKey: []byte("secret")},
+	}
+	bucketClass := &storage.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "s3-bucket"},
+		Parameters: map[string]string{"endpoint": server.URL, "secretName": "s3-credentials", "secretNamespace": "kube-system"},
+	}
+	prefixClass := &storage.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "s3-prefix"},
+		Parameters: map[string]string{"endpoint": server.URL, "secretName": "s3-credentials", "secretNamespace": "kube-system", "bucket": "shared", "driver": "s3/s3fs"},
+	}
+	client := fake.NewSimpleClientset(credentialsSecret, bucketClass, prefixClass)
+	p := NewS3Provisioner(client, "id")
+
+	tests := []struct {
+		name           string
+		class          *storage.StorageClass
+		expectedBucket string
+		expectedPrefix string
+		expectedDriver string
+		// expectedObject is the object Provision creates
+		expectedObject string
+	}{
+		{
+			name:           "bucket per volume",
+			class:          bucketClass,
+			expectedBucket: "kubernetes-dynamic-pvc-1",
+			expectedDriver: defaultDriver,
+		},
+		{
+			name:           "prefix per volume",
+			class:          prefixClass,
+			expectedBucket: "shared",
+			expectedPrefix: "pvc-1",
+			expectedDriver: "s3/s3fs",
+			expectedObject: "pvc-1/",
+		},
+	}
+	for _, test := range tests {
+		options := controller.VolumeOptions{
+			PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+			PVName:                        "pvc-1",
+			Parameters:                    test.class.Parameters,
+			PVC: &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "claim"},
+				Spec: v1.PersistentVolumeClaimSpec{
+					AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{
+							v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Gi"),
+						},
+					},
+				},
+			},
+		}
+		pv, err := p.Provision(options)
+		if err != nil {
+			t.Errorf("Test %s: error provisioning volume: %v", test.name, err)
+			continue
+		}
+		flex := pv.Spec.FlexVolume
+		if flex.Driver != test.expectedDriver || flex.Options[bucketOption] != test.expectedBucket || flex.Options[prefixOption] != test.expectedPrefix {
+			t.Errorf("Test %s: expected driver %q bucket %q prefix %q but got %v", test.name, test.expectedDriver, test.expectedBucket, test.expectedPrefix, flex)
+		}
+		objects, ok := s3.buckets[test.expectedBucket]
+		if !ok {
+			t.Errorf("Test %s: expected bucket %q to exist", test.name, test.expectedBucket)
+			continue
+		}
+		if test.expectedObject != "" && !objects[test.expectedObject] {
+			t.Errorf("Test %s: expected object %q to exist", test.name, test.expectedObject)
+		}
+		secret, err := client.Core().Secrets("ns").Get(flex.SecretRef.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("Test %s: error getting volume secret: %v", test.name, err)
+		} else if string(secret.Type) != test.expectedDriver || string(secret.Data[secretAccessKeyKey]) != "secret" {
+			t.Errorf("Test %s: expected volume secret of type %q with the credentials but got %v", test.name, test.expectedDriver, secret)
+		}
+
+		// written by a pod
+		objects["pvc-1/data"] = true
+		objects["pvc-10/data"] = true
+
+		pv.Annotations[v1.BetaStorageClassAnnotation] = test.class.Name
+		pv.Spec.ClaimRef = &v1.ObjectReference{Namespace: "ns", Name: "claim"}
+		if err := p.Delete(pv); err != nil {
+			t.Errorf("Test %s: error deleting volume: %v", test.name, err)
+		}
+		if test.expectedPrefix == "" {
+			if _, ok := s3.buckets[test.expectedBucket]; ok {
+				t.Errorf("Test %s: expected bucket %q to be deleted", test.name, test.expectedBucket)
+			}
+		} else {
+			if objects["pvc-1/"] || objects["pvc-1/data"] || !objects["pvc-10/data"] {
+				t.Errorf("Test %s: expected only the objects under prefix %q to be deleted but got %v", test.name, test.expectedPrefix, objects)
+			}
+		}
+		if _, err := client.Core().Secrets("ns").Get(flex.SecretRef.Name, metav1.GetOptions{}); err == nil {
+			t.Errorf("Test %s: expected volume secret to be deleted", test.name)
+		}
+	}
+}
+
+func TestParseParameters(t *testing.T) {
+	tests := []struct {
+		name        string
+		parameters  map[string]string
+		expectError bool
+	}{
+		{
+			name:       "minimal",
+			parameters: map[string]string{"endpoint": "https://s3.amazonaws.com", "secretName": "s"},
+		},
+		{
+			name:        "no endpoint",
+			parameters:  map[string]string{"secretName": "s"},
+			expectError: true,
+		},
+		{
+			name:        "no secret",
+			parameters:  map[string]string{"endpoint": "https://s3.amazonaws.com"},
+			expectError: true,
+		},
+		{
+			name:        "unknown parameter",
+			parameters:  map[string]string{"endpoint": "https://s3.amazonaws.com", "secretName": "s", "foo": "bar"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		_, err := parseParameters(test.parameters)
+		if test.expectError && err == nil {
+			t.Errorf("Test %s: expected error but got none", test.name)
+		} else if !test.expectError && err != nil {
+			t.Errorf("Test %s: expected no error but got: %v", test.name, err)
+		}
+	}
+}
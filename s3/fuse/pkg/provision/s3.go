@@ -0,0 +1,187 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// defaultRegion is the region of the classic S3 endpoint, in which buckets are
+// created without a location constraint
+const defaultRegion = "us-east-1"
+
+// s3Client creates and deletes buckets and objects on an S3-compatible
+// endpoint with the S3 REST API, using path-style URLs and signature version 4
+// so it works with e.g. Minio and Ceph RGW as well as AWS.
+type s3Client struct {
+	// endpoint is e.g. https://s3.amazonaws.com or http://minio:9000
+	endpoint string
+	region   string
+	signer   *v4.Signer
+	client   *http.Client
+}
+
+func newS3Client(endpoint, region, accessKeyID, secretAccessKey string) *s3Client {
+	return &s3Client{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		region:   region,
+		signer: v4.NewSigner(credentials.NewStaticCredentials(accessKeyID, secretAccessKey, ""), func(s *v4.Signer) {
+			s.DisableURIPathEscaping = true
+			// the request's own body keeps its Content-Length
+			s.DisableRequestBodyOverwrite = true
+		}),
+		client: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// s3Error is the body of an S3 error response
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// listBucketResult is the body of a list objects (version 1) response
+type listBucketResult struct {
+	IsTruncated bool   `xml:"IsTruncated"`
+	NextMarker  string `xml:"NextMarker"`
+	Contents    []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+}
+
+type createBucketConfiguration struct {
+	XMLName            xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CreateBucketConfiguration"`
+	LocationConstraint string   `xml:"LocationConstraint"`
+}
+
+// do sends a signed request for the given bucket and, if not empty, object
+// key, and returns the response body if the status is one of ok.
+func (c *s3Client) do(method, bucket, key string, query url.Values, body []byte, ok ...int) ([]byte, int, error) {
+	u, err := url.Parse(c.endpoint)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid endpoint %q: %v", c.endpoint, err)
+	}
+	u.Path = "/" + bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := c.signer.Sign(req, bytes.NewReader(body), "s3", c.region, time.Now()); err != nil {
+		return nil, 0, fmt.Errorf("error signing request: %v", err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return respBody, resp.StatusCode, nil
+		}
+	}
+	s3Err := &s3Error{}
+	if xml.Unmarshal(respBody, s3Err) == nil && s3Err.Code != "" {
+		return nil, resp.StatusCode, fmt.Errorf("%s %s failed: %s: %s", method, u.Path, s3Err.Code, s3Err.Message)
+	}
+	return nil, resp.StatusCode, fmt.Errorf("%s %s failed: %s", method, u.Path, resp.Status)
+}
+
+// createBucket creates the bucket in the client's region. It is not an error
+// if the bucket already exists and is ours, e.g. when retrying.
+func (c *s3Client) createBucket(bucket string) error {
+	var body []byte
+	if c.region != defaultRegion {
+		var err error
+		body, err = xml.Marshal(&createBucketConfiguration{LocationConstraint: c.region})
+		if err != nil {
+			return err
+		}
+	}
+	_, status, err := c.do("PUT", bucket, "", nil, body, http.StatusOK)
+	if err != nil && status == http.StatusConflict && strings.Contains(err.Error(), "BucketAlreadyOwnedByYou") {
+		return nil
+	}
+	return err
+}
+
+// deleteBucket deletes the bucket, which must be empty. It is not an error if
+// the bucket doesn't exist.
+func (c *s3Client) deleteBucket(bucket string) error {
+	_, _, err := c.do("DELETE", bucket, "", nil, nil, http.StatusNoContent, http.StatusOK, http.StatusNotFound)
+	return err
+}
+
+// putObject creates an empty object, e.g. a directory marker.
+func (c *s3Client) putObject(bucket, key string) error {
+	_, _, err := c.do("PUT", bucket, key, nil, nil, http.StatusOK)
+	return err
+}
+
+// deleteObjects deletes every object in the bucket whose key starts with
+// prefix. It is not an error if the bucket doesn't exist.
+func (c *s3Client) deleteObjects(bucket, prefix string) error {
+	marker := ""
+	for {
+		query := url.Values{"prefix": {prefix}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		body, status, err := c.do("GET", bucket, "", query, nil, http.StatusOK, http.StatusNotFound)
+		if err != nil {
+			return err
+		}
+		if status == http.StatusNotFound {
+			return nil
+		}
+		result := &listBucketResult{}
+		if err := xml.Unmarshal(body, result); err != nil {
+			return fmt.Errorf("error parsing list of bucket %s: %v", bucket, err)
+		}
+		for _, object := range result.Contents {
+			if _, _, err := c.do("DELETE", bucket, object.Key, nil, nil, http.StatusNoContent, http.StatusOK, http.StatusNotFound); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || len(result.Contents) == 0 {
+			return nil
+		}
+		// NextMarker is only returned when a delimiter is given
+		marker = result.NextMarker
+		if marker == "" {
+			marker = result.Contents[len(result.Contents)-1].Key
+		}
+	}
+}
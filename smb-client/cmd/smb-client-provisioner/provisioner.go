@@ -0,0 +1,240 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	provisionerNameKey = "PROVISIONER_NAME"
+
+	// the keys of the credentials in the secrets
+	usernameKey = "username"
+	passwordKey = "password"
+	domainKey   = "domain"
+)
+
+type smbProvisioner struct {
+	client kubernetes.Interface
+	// share is the UNC path of the share, e.g. //server/share
+	share string
+	// mountPath is where the share is mounted in the provisioner's container
+	mountPath string
+	// credentials are the username, password and optionally domain to mount
+	// the share with, copied to each volume's secret
+	credentials map[string][]byte
+	// driver is the flexvolume driver mounting the PVs
+	driver string
+	// mountOptions are the cifs mount options given to the driver
+	mountOptions string
+}
+
+var _ controller.Provisioner = &smbProvisioner{}
+
+// Provision creates a directory on the share, and a secret with the share's
+// credentials in the claim's namespace, and returns a flexvolume PV for the
+// directory.
+func (p *smbProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	glog.V(4).Infof("smb provisioner: VolumeOptions %v", options)
+
+	pvcNamespace := options.PVC.Namespace
+	pvcName := options.PVC.Name
+
+	dirName := strings.Join([]string{pvcNamespace, pvcName, options.PVName}, "-")
+
+	fullPath := filepath.Join(p.mountPath, dirName)
+	glog.V(4).Infof("creating path %s", fullPath)
+	if err := os.MkdirAll(fullPath, 0777); err != nil {
+		return nil, errors.New("unable to create directory to provision new pv: " + err.Error())
+	}
+	os.Chmod(fullPath, 0777)
+
+	// flexvolume PVs can only refer to secrets in the pod's namespace
+	secretName := getSecretName(options.PVName)
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: pvcNamespace,
+			Name:      secretName,
+		},
+		Data: p.credentials,
+		Type: v1.SecretType(p.driver),
+	}
+	if _, err := p.client.Core().Secrets(pvcNamespace).Create(secret); err != nil && !apierrors.IsAlreadyExists(err) {
+		os.RemoveAll(fullPath)
+		return nil, fmt.Errorf("unable to create secret %s/%s for new pv: %v", pvcNamespace, secretName, err)
+	}
+
+	flexOptions := map[string]string{
+		"networkPath": strings.TrimSuffix(p.share, "/") + "/" + dirName,
+	}
+	if p.mountOptions != "" {
+		flexOptions["mountOptions"] = p.mountOptions
+	}
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: options.PVName,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				FlexVolume: &v1.FlexVolumeSource{
+					Driver:    p.driver,
+					SecretRef: &v1.LocalObjectReference{Name: secretName},
+					ReadOnly:  false,
+					Options:   flexOptions,
+				},
+			},
+		},
+	}
+	return pv, nil
+}
+
+// Delete archives the directory of the given PV, like nfs-client does, and
+// deletes its secret.
+func (p *smbProvisioner) Delete(volume *v1.PersistentVolume) error {
+	if volume.Spec.FlexVolume == nil || volume.Spec.FlexVolume.Driver != p.driver {
+		return &controller.IgnoredError{Reason: "volume is not a flexvolume of driver " + p.driver}
+	}
+	networkPath := volume.Spec.FlexVolume.Options["networkPath"]
+	if !strings.HasPrefix(networkPath, strings.TrimSuffix(p.share, "/")+"/") {
+		return &controller.IgnoredError{Reason: fmt.Sprintf("volume's network path %s is not on share %s", networkPath, p.share)}
+	}
+
+	dirName := filepath.Base(networkPath)
+	oldPath := filepath.Join(p.mountPath, dirName)
+	archivePath := filepath.Join(p.mountPath, "archived-"+dirName)
+	glog.V(4).Infof("archiving path %s to %s", oldPath, archivePath)
+	if err := os.Rename(oldPath, archivePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if volume.Spec.ClaimRef == nil || volume.Spec.FlexVolume.SecretRef == nil {
+		return nil
+	}
+	err := p.client.Core().Secrets(volume.Spec.ClaimRef.Namespace).Delete(volume.Spec.FlexVolume.SecretRef.Name, nil)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("archived path %s but failed to delete secret: %v", oldPath, err)
+	}
+	return nil
+}
+
+func getSecretName(pvName string) string {
+	return "smb-" + pvName
+}
+
+// getCredentials returns the credentials from the secret "namespace/name".
+func getCredentials(client kubernetes.Interface, secretRef string) (map[string][]byte, error) {
+	parts := strings.Split(secretRef, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("secret %q is not of the form namespace/name", secretRef)
+	}
+	secret, err := client.Core().Secrets(parts[0]).Get(parts[1], metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(secret.Data[usernameKey]) == 0 {
+		return nil, fmt.Errorf("secret %s has no %s", secretRef, usernameKey)
+	}
+	credentials := map[string][]byte{}
+	for _, key := range []string{usernameKey, passwordKey, domainKey} {
+		if value, ok := secret.Data[key]; ok {
+			credentials[key] = value
+		}
+	}
+	return credentials, nil
+}
+
+func main() {
+	flag.Parse()
+	flag.Set("logtostderr", "true")
+
+	share := os.Getenv("SMB_SHARE")
+	if share == "" {
+		glog.Fatal("SMB_SHARE not set")
+	}
+	credentialsSecret := os.Getenv("SMB_CREDENTIALS_SECRET")
+	if credentialsSecret == "" {
+		glog.Fatal("SMB_CREDENTIALS_SECRET not set")
+	}
+	driver := os.Getenv("FLEX_DRIVER")
+	if driver == "" {
+		driver = "fstab/cifs"
+	}
+	provisionerName := os.Getenv(provisionerNameKey)
+	if provisionerName == "" {
+		glog.Fatalf("environment variable %s is not set! Please set it.", provisionerNameKey)
+	}
+
+	// Create an InClusterConfig and use it to create a client for the controller
+	// to use to communicate with Kubernetes
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		glog.Fatalf("Failed to create config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create client: %v", err)
+	}
+
+	credentials, err := getCredentials(clientset, credentialsSecret)
+	if err != nil {
+		glog.Fatalf("Failed to get SMB credentials: %v", err)
+	}
+
+	// The controller needs to know what the server version is because out-of-tree
+	// provisioners aren't officially supported until 1.5
+	serverVersion, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		glog.Fatalf("Error getting server version: %v", err)
+	}
+
+	smbProvisioner := &smbProvisioner{
+		client:       clientset,
+		share:        share,
+		mountPath:    "/persistentvolumes",
+		credentials:  credentials,
+		driver:       driver,
+		mountOptions: os.Getenv("SMB_MOUNT_OPTIONS"),
+	}
+	// Start the provision controller which will dynamically provision SMB
+	// flexvolume PVs
+	pc := controller.NewProvisionController(clientset, provisionerName, smbProvisioner, serverVersion.GitVersion)
+	pc.Run(wait.NeverStop)
+}
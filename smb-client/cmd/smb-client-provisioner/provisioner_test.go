@@ -0,0 +1,113 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+	utiltesting "k8s.io/client-go/util/testing"
+)
+
+func TestProvisionDelete(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("smbProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	credentials := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "smb-credentials"},
+		Data:       map[string][]byte{usernameKey: []byte("user"), passwordKey: []byte("password"), "other": []byte("x")},
+	}
+	client := fake.NewSimpleClientset(credentials)
+	creds, err := getCredentials(client, "kube-system/smb-credentials")
+	if err != nil {
+		t.Fatalf("Error getting credentials: %v", err)
+	}
+	expectedCreds := map[string][]byte{usernameKey: []byte("user"), passwordKey: []byte("password")}
+	if !reflect.DeepEqual(expectedCreds, creds) {
+		t.Errorf("expected credentials %v but got %v", expectedCreds, creds)
+	}
+	if _, err := getCredentials(client, "smb-credentials"); err == nil {
+		t.Errorf("expected error getting credentials without namespace but got none")
+	}
+
+	p := &smbProvisioner{
+		client:       client,
+		share:        "//server/share/",
+		mountPath:    tmpDir,
+		credentials:  creds,
+		driver:       "fstab/cifs",
+		mountOptions: "vers=3.0",
+	}
+	options := controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        "pvc-1",
+		PVC: &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "claim"},
+			Spec: v1.PersistentVolumeClaimSpec{
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{
+						v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Gi"),
+					},
+				},
+			},
+		},
+	}
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	expectedOptions := map[string]string{"networkPath": "//server/share/ns-claim-pvc-1", "mountOptions": "vers=3.0"}
+	if !reflect.DeepEqual(expectedOptions, pv.Spec.FlexVolume.Options) {
+		t.Errorf("expected flexvolume options %v but got %v", expectedOptions, pv.Spec.FlexVolume.Options)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "ns-claim-pvc-1")); err != nil {
+		t.Errorf("Error checking volume directory: %v", err)
+	}
+	secret, err := client.Core().Secrets("ns").Get(pv.Spec.FlexVolume.SecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting volume secret: %v", err)
+	}
+	if !reflect.DeepEqual(expectedCreds, secret.Data) || secret.Type != "fstab/cifs" {
+		t.Errorf("expected volume secret of type fstab/cifs with credentials %v but got %v", expectedCreds, secret)
+	}
+
+	pv.Spec.ClaimRef = &v1.ObjectReference{Namespace: "ns", Name: "claim"}
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "archived-ns-claim-pvc-1")); err != nil {
+		t.Errorf("Error checking archived volume directory: %v", err)
+	}
+	if _, err := client.Core().Secrets("ns").Get(pv.Spec.FlexVolume.SecretRef.Name, metav1.GetOptions{}); err == nil {
+		t.Errorf("expected volume secret to be deleted")
+	}
+
+	pv.Spec.FlexVolume.Options["networkPath"] = "//other/share/ns-claim-pvc-1"
+	if err := p.Delete(pv); err == nil {
+		t.Errorf("expected error deleting volume on another share but got none")
+	} else if _, ok := err.(*controller.IgnoredError); !ok {
+		t.Errorf("expected IgnoredError deleting volume on another share but got %v", err)
+	}
+}
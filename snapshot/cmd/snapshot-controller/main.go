@@ -0,0 +1,74 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/golang/glog"
+	snapshotclient "github.com/kubernetes-incubator/external-storage/snapshot/pkg/client"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/controller"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/volume"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/volume/hostpath"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	master       = flag.String("master", "", "Master URL")
+	kubeconfig   = flag.String("kubeconfig", "", "Absolute path to the kubeconfig")
+	resyncPeriod = flag.Duration("resync-period", time.Minute, "How often to retry taking the snapshots that failed.")
+	snapshotDir  = flag.String("hostpath-snapshot-dir", "/var/lib/hostpath-snapshots", "The directory to store snapshots of hostPath volumes in. It must be mounted at the same path in the controller's container as on the node.")
+)
+
+func main() {
+	flag.Parse()
+	flag.Set("logtostderr", "true")
+
+	var config *rest.Config
+	var err error
+	if *master != "" || *kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		glog.Fatalf("Failed to create config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create client: %v", err)
+	}
+	snapshots, err := snapshotclient.NewClient(config)
+	if err != nil {
+		glog.Fatalf("Failed to create snapshot client: %v", err)
+	}
+	if err := snapshotclient.CreateThirdPartyResources(clientset, snapshots); err != nil {
+		glog.Fatalf("Failed to create snapshot third party resources: %v", err)
+	}
+
+	// the controller only takes and deletes snapshots, restoring them is the
+	// snapshot provisioner's job
+	plugins := map[string]volume.Plugin{
+		"hostPath": hostpath.NewPlugin(*snapshotDir, ""),
+	}
+	snapshotter := controller.NewSnapshotter(clientset, snapshots, plugins)
+	controller.NewSnapshotController(snapshots.RESTClient(), snapshotter, *resyncPeriod).Run(wait.NeverStop)
+}
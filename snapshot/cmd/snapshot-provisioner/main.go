@@ -0,0 +1,95 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	snapshotclient "github.com/kubernetes-incubator/external-storage/snapshot/pkg/client"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/provision"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/volume"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/volume/hostpath"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	provisioner = flag.String("provisioner", "example.com/snapshot", "Name of the provisioner. The provisioner will only provision volumes for claims that request a StorageClass with a provisioner field set equal to this name.")
+	master      = flag.String("master", "", "Master URL")
+	kubeconfig  = flag.String("kubeconfig", "", "Absolute path to the kubeconfig")
+	id          = flag.String("id", "", "Unique provisioner identity")
+	snapshotDir = flag.String("hostpath-snapshot-dir", "/var/lib/hostpath-snapshots", "The directory the snapshot controller stores snapshots of hostPath volumes in. It must be mounted at the same path in the provisioner's container as on the node.")
+	restoreDir  = flag.String("hostpath-restore-dir", "/var/lib/hostpath-restored", "The directory to restore snapshots of hostPath volumes into. It must be mounted at the same path in the provisioner's container as on the node.")
+)
+
+func main() {
+	flag.Parse()
+	flag.Set("logtostderr", "true")
+
+	var config *rest.Config
+	var err error
+	if *master != "" || *kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		glog.Fatalf("Failed to create config: %v", err)
+	}
+	prID := string(uuid.NewUUID())
+	if *id != "" {
+		prID = *id
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create client: %v", err)
+	}
+	snapshots, err := snapshotclient.NewClient(config)
+	if err != nil {
+		glog.Fatalf("Failed to create snapshot client: %v", err)
+	}
+
+	// The controller needs to know what the server version is because out-of-tree
+	// provisioners aren't officially supported until 1.5
+	serverVersion, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		glog.Fatalf("Error getting server version: %v", err)
+	}
+
+	// Create the provisioner: it implements the Provisioner interface expected by
+	// the controller
+	plugins := map[string]volume.Plugin{
+		"hostPath": hostpath.NewPlugin(*snapshotDir, *restoreDir),
+	}
+	snapshotProvisioner := provision.NewSnapshotProvisioner(snapshots, plugins, prID)
+
+	// Start the provision controller which will dynamically provision PVs
+	// restored from snapshots
+	pc := controller.NewProvisionController(
+		clientset,
+		*provisioner,
+		snapshotProvisioner,
+		serverVersion.GitVersion,
+	)
+
+	pc.Run(wait.NeverStop)
+}
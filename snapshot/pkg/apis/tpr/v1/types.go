@@ -0,0 +1,231 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// VolumeSnapshotResourcePlural is the plural of VolumeSnapshot
+	VolumeSnapshotResourcePlural = "volumesnapshots"
+	// VolumeSnapshotContentResourcePlural is the plural of
+	// VolumeSnapshotContent
+	VolumeSnapshotContentResourcePlural = "volumesnapshotcontents"
+)
+
+// VolumeSnapshotConditionType is the type of a VolumeSnapshot condition
+type VolumeSnapshotConditionType string
+
+const (
+	// VolumeSnapshotConditionReady means the snapshot is taken and can be
+	// restored from
+	VolumeSnapshotConditionReady VolumeSnapshotConditionType = "Ready"
+	// VolumeSnapshotConditionError means taking the snapshot failed
+	VolumeSnapshotConditionError VolumeSnapshotConditionType = "Error"
+)
+
+// VolumeSnapshotCondition describes the state of a snapshot at a certain point.
+type VolumeSnapshotCondition struct {
+	// Type of the condition
+	Type VolumeSnapshotConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown
+	Status v1.ConditionStatus `json:"status"`
+	// The last time the condition transitioned from one status to another
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// The reason for the condition's last transition
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// A human readable message indicating details about the transition
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// VolumeSnapshotSpec is the desired state of a snapshot: a snapshot of the
+// volume bound to a claim in the snapshot's namespace.
+type VolumeSnapshotSpec struct {
+	// PersistentVolumeClaimName is the name of the claim whose volume to
+	// snapshot
+	PersistentVolumeClaimName string `json:"persistentVolumeClaimName"`
+	// SnapshotContentName is the name of the VolumeSnapshotContent the
+	// snapshot is bound to, set by the snapshot controller once the snapshot
+	// is taken
+	// +optional
+	SnapshotContentName string `json:"snapshotContentName,omitempty"`
+}
+
+// VolumeSnapshotStatus is the observed state of a snapshot
+type VolumeSnapshotStatus struct {
+	// Conditions are the latest observations of the snapshot's state
+	// +optional
+	Conditions []VolumeSnapshotCondition `json:"conditions,omitempty"`
+}
+
+// VolumeSnapshot is a user's request for a snapshot of a claim's volume, like
+// a PersistentVolumeClaim is a request for a volume.
+type VolumeSnapshot struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ObjectMeta `json:"metadata"`
+
+	Spec   VolumeSnapshotSpec   `json:"spec"`
+	Status VolumeSnapshotStatus `json:"status,omitempty"`
+}
+
+// VolumeSnapshotList is a list of VolumeSnapshots
+type VolumeSnapshotList struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ListMeta `json:"metadata"`
+
+	Items []VolumeSnapshot `json:"items"`
+}
+
+// HostPathVolumeSnapshotSource is a snapshot of a hostPath volume.
+type HostPathVolumeSnapshotSource struct {
+	// Path is the snapshot's archive on the host
+	Path string `json:"path"`
+}
+
+// VolumeSnapshotContentSource is the snapshot in the storage backend. Exactly
+// one of its members must be set.
+type VolumeSnapshotContentSource struct {
+	// HostPath is a snapshot of a hostPath volume
+	// +optional
+	HostPath *HostPathVolumeSnapshotSource `json:"hostPath,omitempty"`
+}
+
+// VolumeSnapshotContentSpec is the spec of a VolumeSnapshotContent
+type VolumeSnapshotContentSpec struct {
+	// Source is the snapshot in the storage backend
+	VolumeSnapshotContentSource `json:",inline"`
+	// VolumeSnapshotRef is the VolumeSnapshot the content is bound to
+	// +optional
+	VolumeSnapshotRef *v1.ObjectReference `json:"volumeSnapshotRef,omitempty"`
+	// PersistentVolumeRef is the PersistentVolume the snapshot was taken of
+	// +optional
+	PersistentVolumeRef *v1.ObjectReference `json:"persistentVolumeRef,omitempty"`
+}
+
+// VolumeSnapshotContent is a snapshot taken in the storage backend, like a
+// PersistentVolume is a volume.
+type VolumeSnapshotContent struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ObjectMeta `json:"metadata"`
+
+	Spec VolumeSnapshotContentSpec `json:"spec"`
+}
+
+// VolumeSnapshotContentList is a list of VolumeSnapshotContents
+type VolumeSnapshotContentList struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ListMeta `json:"metadata"`
+
+	Items []VolumeSnapshotContent `json:"items"`
+}
+
+// GetObjectKind is required to satisfy Object interface
+func (v *VolumeSnapshot) GetObjectKind() schema.ObjectKind {
+	return &v.TypeMeta
+}
+
+// GetObjectMeta is required to satisfy ObjectMetaAccessor interface
+func (v *VolumeSnapshot) GetObjectMeta() metav1.Object {
+	return &v.Metadata
+}
+
+// GetObjectKind is required to satisfy Object interface
+func (vl *VolumeSnapshotList) GetObjectKind() schema.ObjectKind {
+	return &vl.TypeMeta
+}
+
+// GetListMeta is required to satisfy ListMetaAccessor interface
+func (vl *VolumeSnapshotList) GetListMeta() metav1.List {
+	return &vl.Metadata
+}
+
+// GetObjectKind is required to satisfy Object interface
+func (v *VolumeSnapshotContent) GetObjectKind() schema.ObjectKind {
+	return &v.TypeMeta
+}
+
+// GetObjectMeta is required to satisfy ObjectMetaAccessor interface
+func (v *VolumeSnapshotContent) GetObjectMeta() metav1.Object {
+	return &v.Metadata
+}
+
+// GetObjectKind is required to satisfy Object interface
+func (vl *VolumeSnapshotContentList) GetObjectKind() schema.ObjectKind {
+	return &vl.TypeMeta
+}
+
+// GetListMeta is required to satisfy ListMetaAccessor interface
+func (vl *VolumeSnapshotContentList) GetListMeta() metav1.List {
+	return &vl.Metadata
+}
+
+// The code below is used only to work around a known problem with
+// third-party resources and ugorji. If/when these issues are resolved, the
+// code below should no longer be required.
+
+type volumeSnapshotListCopy VolumeSnapshotList
+type volumeSnapshotCopy VolumeSnapshot
+type volumeSnapshotContentListCopy VolumeSnapshotContentList
+type volumeSnapshotContentCopy VolumeSnapshotContent
+
+// UnmarshalJSON unmarshals a VolumeSnapshot with encoding/json
+func (v *VolumeSnapshot) UnmarshalJSON(data []byte) error {
+	tmp := volumeSnapshotCopy{}
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+	*v = VolumeSnapshot(tmp)
+	return nil
+}
+
+// UnmarshalJSON unmarshals a VolumeSnapshotList with encoding/json
+func (vl *VolumeSnapshotList) UnmarshalJSON(data []byte) error {
+	tmp := volumeSnapshotListCopy{}
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+	*vl = VolumeSnapshotList(tmp)
+	return nil
+}
+
+// UnmarshalJSON unmarshals a VolumeSnapshotContent with encoding/json
+func (v *VolumeSnapshotContent) UnmarshalJSON(data []byte) error {
+	tmp := volumeSnapshotContentCopy{}
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+	*v = VolumeSnapshotContent(tmp)
+	return nil
+}
+
+// UnmarshalJSON unmarshals a VolumeSnapshotContentList with encoding/json
+func (vl *VolumeSnapshotContentList) UnmarshalJSON(data []byte) error {
+	tmp := volumeSnapshotContentListCopy{}
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+	*vl = VolumeSnapshotContentList(tmp)
+	return nil
+}
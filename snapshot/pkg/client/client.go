@@ -0,0 +1,188 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	tprv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/tpr/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// the third party resources' names are <kind in kebab case>.<group>
+	volumeSnapshotTPRName        = "volume-snapshot." + tprv1.GroupName
+	volumeSnapshotContentTPRName = "volume-snapshot-content." + tprv1.GroupName
+
+	resourcePollInterval = time.Second
+	resourcePollTimeout  = 60 * time.Second
+)
+
+// Interface gets and modifies VolumeSnapshots and VolumeSnapshotContents.
+type Interface interface {
+	GetSnapshot(namespace, name string) (*tprv1.VolumeSnapshot, error)
+	UpdateSnapshot(snapshot *tprv1.VolumeSnapshot) (*tprv1.VolumeSnapshot, error)
+	GetContent(namespace, name string) (*tprv1.VolumeSnapshotContent, error)
+	CreateContent(content *tprv1.VolumeSnapshotContent) (*tprv1.VolumeSnapshotContent, error)
+	DeleteContent(namespace, name string) error
+}
+
+// Client is an Interface talking to the API server. VolumeSnapshotContents
+// live in the namespace of their VolumeSnapshot because third party resources
+// can't be cluster scoped.
+type Client struct {
+	rest *rest.RESTClient
+}
+
+var _ Interface = &Client{}
+
+// NewClient creates a client of the snapshot third party resources.
+func NewClient(config *rest.Config) (*Client, error) {
+	scheme := runtime.NewScheme()
+	if err := tprv1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	tprConfig := *config
+	tprConfig.GroupVersion = &tprv1.SchemeGroupVersion
+	tprConfig.APIPath = "/apis"
+	tprConfig.ContentType = runtime.ContentTypeJSON
+	tprConfig.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+
+	restClient, err := rest.RESTClientFor(&tprConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{rest: restClient}, nil
+}
+
+// RESTClient returns the client's REST client, e.g. to list and watch
+// snapshots with.
+func (c *Client) RESTClient() *rest.RESTClient {
+	return c.rest
+}
+
+// GetSnapshot gets a VolumeSnapshot.
+func (c *Client) GetSnapshot(namespace, name string) (*tprv1.VolumeSnapshot, error) {
+	snapshot := &tprv1.VolumeSnapshot{}
+	err := c.rest.Get().
+		Namespace(namespace).
+		Resource(tprv1.VolumeSnapshotResourcePlural).
+		Name(name).
+		Do().Into(snapshot)
+	return snapshot, err
+}
+
+// UpdateSnapshot updates a VolumeSnapshot, including its status: third party
+// resources have no status subresource.
+func (c *Client) UpdateSnapshot(snapshot *tprv1.VolumeSnapshot) (*tprv1.VolumeSnapshot, error) {
+	result := &tprv1.VolumeSnapshot{}
+	err := c.rest.Put().
+		Namespace(snapshot.Metadata.Namespace).
+		Resource(tprv1.VolumeSnapshotResourcePlural).
+		Name(snapshot.Metadata.Name).
+		Body(snapshot).
+		Do().Into(result)
+	return result, err
+}
+
+// GetContent gets a VolumeSnapshotContent.
+func (c *Client) GetContent(namespace, name string) (*tprv1.VolumeSnapshotContent, error) {
+	content := &tprv1.VolumeSnapshotContent{}
+	err := c.rest.Get().
+		Namespace(namespace).
+		Resource(tprv1.VolumeSnapshotContentResourcePlural).
+		Name(name).
+		Do().Into(content)
+	return content, err
+}
+
+// CreateContent creates a VolumeSnapshotContent.
+func (c *Client) CreateContent(content *tprv1.VolumeSnapshotContent) (*tprv1.VolumeSnapshotContent, error) {
+	result := &tprv1.VolumeSnapshotContent{}
+	err := c.rest.Post().
+		Namespace(content.Metadata.Namespace).
+		Resource(tprv1.VolumeSnapshotContentResourcePlural).
+		Body(content).
+		Do().Into(result)
+	return result, err
+}
+
+// DeleteContent deletes a VolumeSnapshotContent.
+func (c *Client) DeleteContent(namespace, name string) error {
+	return c.rest.Delete().
+		Namespace(namespace).
+		Resource(tprv1.VolumeSnapshotContentResourcePlural).
+		Name(name).
+		Do().Error()
+}
+
+// CreateThirdPartyResources registers the snapshot third party resources, if
+// they aren't already, and waits for the API server to serve them.
+func CreateThirdPartyResources(clientset kubernetes.Interface, c *Client) error {
+	tprs := map[string]string{
+		volumeSnapshotTPRName:        "A snapshot of the volume bound to a persistent volume claim",
+		volumeSnapshotContentTPRName: "A snapshot taken in a storage backend",
+	}
+	for name, description := range tprs {
+		tpr := &v1beta1.ThirdPartyResource{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Versions: []v1beta1.APIVersion{
+				{Name: tprv1.SchemeGroupVersion.Version},
+			},
+			Description: description,
+		}
+		_, err := clientset.Extensions().ThirdPartyResources().Create(tpr)
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("error creating third party resource %s: %v", name, err)
+		}
+		glog.Infof("third party resource %s is registered", name)
+	}
+
+	resources := []string{tprv1.VolumeSnapshotResourcePlural, tprv1.VolumeSnapshotContentResourcePlural}
+	for _, resource := range resources {
+		err := wait.Poll(resourcePollInterval, resourcePollTimeout, func() (bool, error) {
+			err := c.rest.Get().
+				Namespace(api.NamespaceDefault).
+				Resource(resource).
+				Do().Error()
+			if err != nil {
+				if apierrors.IsNotFound(err) {
+					return false, nil
+				}
+				return false, err
+			}
+			return true, nil
+		})
+		if err != nil {
+			return fmt.Errorf("error waiting for resource %s to be served: %v", resource, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"sync"
+
+	tprv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/tpr/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// FakeClient is an in-memory Interface for tests.
+type FakeClient struct {
+	mutex     sync.Mutex
+	Snapshots map[string]*tprv1.VolumeSnapshot
+	Contents  map[string]*tprv1.VolumeSnapshotContent
+}
+
+var _ Interface = &FakeClient{}
+
+// NewFakeClient returns a FakeClient with the given snapshots.
+func NewFakeClient(snapshots ...*tprv1.VolumeSnapshot) *FakeClient {
+	c := &FakeClient{
+		Snapshots: map[string]*tprv1.VolumeSnapshot{},
+		Contents:  map[string]*tprv1.VolumeSnapshotContent{},
+	}
+	for _, snapshot := range snapshots {
+		c.Snapshots[snapshot.Metadata.Namespace+"/"+snapshot.Metadata.Name] = snapshot
+	}
+	return c
+}
+
+// GetSnapshot gets a VolumeSnapshot.
+func (c *FakeClient) GetSnapshot(namespace, name string) (*tprv1.VolumeSnapshot, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	snapshot, ok := c.Snapshots[namespace+"/"+name]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Group: tprv1.GroupName, Resource: tprv1.VolumeSnapshotResourcePlural}, name)
+	}
+	clone := *snapshot
+	return &clone, nil
+}
+
+// UpdateSnapshot updates a VolumeSnapshot.
+func (c *FakeClient) UpdateSnapshot(snapshot *tprv1.VolumeSnapshot) (*tprv1.VolumeSnapshot, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := snapshot.Metadata.Namespace + "/" + snapshot.Metadata.Name
+	if _, ok := c.Snapshots[key]; !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Group: tprv1.GroupName, Resource: tprv1.VolumeSnapshotResourcePlural}, snapshot.Metadata.Name)
+	}
+	clone := *snapshot
+	c.Snapshots[key] = &clone
+	return snapshot, nil
+}
+
+// GetContent gets a VolumeSnapshotContent.
+func (c *FakeClient) GetContent(namespace, name string) (*tprv1.VolumeSnapshotContent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	content, ok := c.Contents[namespace+"/"+name]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Group: tprv1.GroupName, Resource: tprv1.VolumeSnapshotContentResourcePlural}, name)
+	}
+	clone := *content
+	return &clone, nil
+}
+
+// CreateContent creates a VolumeSnapshotContent.
+func (c *FakeClient) CreateContent(content *tprv1.VolumeSnapshotContent) (*tprv1.VolumeSnapshotContent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := content.Metadata.Namespace + "/" + content.Metadata.Name
+	if _, ok := c.Contents[key]; ok {
+		return nil, apierrors.NewAlreadyExists(schema.GroupResource{Group: tprv1.GroupName, Resource: tprv1.VolumeSnapshotContentResourcePlural}, content.Metadata.Name)
+	}
+	clone := *content
+	c.Contents[key] = &clone
+	return content, nil
+}
+
+// DeleteContent deletes a VolumeSnapshotContent.
+func (c *FakeClient) DeleteContent(namespace, name string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := namespace + "/" + name
+	if _, ok := c.Contents[key]; !ok {
+		return apierrors.NewNotFound(schema.GroupResource{Group: tprv1.GroupName, Resource: tprv1.VolumeSnapshotContentResourcePlural}, name)
+	}
+	delete(c.Contents, key)
+	return nil
+}
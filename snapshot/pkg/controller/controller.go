@@ -0,0 +1,135 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	tprv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/tpr/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// inProgressPollInterval is how often a deletion checks whether the snapshot
+// is still being taken
+const inProgressPollInterval = time.Second
+
+// SnapshotController watches VolumeSnapshots and has a Snapshotter take and
+// delete their snapshots.
+type SnapshotController struct {
+	snapshotter *Snapshotter
+	controller  cache.Controller
+
+	// inProgress are the keys of the snapshots being taken or deleted, so
+	// that resyncs don't start taking them again in parallel
+	inProgress      map[string]bool
+	inProgressMutex sync.Mutex
+}
+
+// NewSnapshotController creates a controller watching the VolumeSnapshots in
+// all namespaces with the given list watcher, e.g. a client.Client's REST
+// client, and retrying failed snapshots every resyncPeriod.
+func NewSnapshotController(getter cache.Getter, snapshotter *Snapshotter, resyncPeriod time.Duration) *SnapshotController {
+	c := &SnapshotController{
+		snapshotter: snapshotter,
+		inProgress:  map[string]bool{},
+	}
+	source := cache.NewListWatchFromClient(getter, tprv1.VolumeSnapshotResourcePlural, v1.NamespaceAll, fields.Everything())
+	_, c.controller = cache.NewInformer(
+		source,
+		&tprv1.VolumeSnapshot{},
+		resyncPeriod,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.onSnapshotAdd,
+			UpdateFunc: c.onSnapshotUpdate,
+			DeleteFunc: c.onSnapshotDelete,
+		},
+	)
+	return c
+}
+
+// Run starts the controller and blocks until stopCh is closed.
+func (c *SnapshotController) Run(stopCh <-chan struct{}) {
+	glog.Infof("Starting snapshot controller")
+	go c.controller.Run(stopCh)
+	<-stopCh
+	glog.Infof("Stopping snapshot controller")
+}
+
+func (c *SnapshotController) onSnapshotAdd(obj interface{}) {
+	snapshot, ok := obj.(*tprv1.VolumeSnapshot)
+	if !ok {
+		glog.Errorf("expected VolumeSnapshot but handler received %#v", obj)
+		return
+	}
+	if snapshot.Spec.SnapshotContentName != "" {
+		return
+	}
+	// the snapshotter modifies the snapshot, which is the informer's
+	clone := *snapshot
+	go c.run(&clone, c.snapshotter.CreateSnapshot, false)
+}
+
+func (c *SnapshotController) onSnapshotUpdate(oldObj, newObj interface{}) {
+	c.onSnapshotAdd(newObj)
+}
+
+func (c *SnapshotController) onSnapshotDelete(obj interface{}) {
+	if unknown, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = unknown.Obj
+	}
+	snapshot, ok := obj.(*tprv1.VolumeSnapshot)
+	if !ok {
+		glog.Errorf("expected VolumeSnapshot but handler received %#v", obj)
+		return
+	}
+	clone := *snapshot
+	go c.run(&clone, c.snapshotter.DeleteSnapshot, true)
+}
+
+// run runs the operation on the snapshot unless one is already running on it,
+// in which case it waits for it to finish if wait is set and else does
+// nothing. A failed creation is retried at the next resync.
+func (c *SnapshotController) run(snapshot *tprv1.VolumeSnapshot, operation func(*tprv1.VolumeSnapshot) error, wait bool) {
+	key := snapshot.Metadata.Namespace + "/" + snapshot.Metadata.Name
+	for {
+		c.inProgressMutex.Lock()
+		if !c.inProgress[key] {
+			c.inProgress[key] = true
+			c.inProgressMutex.Unlock()
+			break
+		}
+		c.inProgressMutex.Unlock()
+		if !wait {
+			return
+		}
+		time.Sleep(inProgressPollInterval)
+	}
+
+	defer func() {
+		c.inProgressMutex.Lock()
+		delete(c.inProgress, key)
+		c.inProgressMutex.Unlock()
+	}()
+
+	if err := operation(snapshot); err != nil {
+		glog.Errorf("error processing snapshot %s: %v", key, err)
+	}
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	tprv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/tpr/v1"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/client"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/volume"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Snapshotter takes the snapshots VolumeSnapshots request with the plugin of
+// their claims' volumes, and deletes them.
+type Snapshotter struct {
+	client    kubernetes.Interface
+	snapshots client.Interface
+	// plugins are the volume plugins by name, see volume.GetPluginName
+	plugins map[string]volume.Plugin
+}
+
+// NewSnapshotter creates a Snapshotter using the given plugins.
+func NewSnapshotter(client kubernetes.Interface, snapshots client.Interface, plugins map[string]volume.Plugin) *Snapshotter {
+	return &Snapshotter{
+		client:    client,
+		snapshots: snapshots,
+		plugins:   plugins,
+	}
+}
+
+// CreateSnapshot takes the snapshot, records it in a VolumeSnapshotContent,
+// and binds the VolumeSnapshot to it. It does nothing if the VolumeSnapshot is
+// already bound.
+func (s *Snapshotter) CreateSnapshot(snapshot *tprv1.VolumeSnapshot) error {
+	if snapshot.Spec.SnapshotContentName != "" {
+		return nil
+	}
+	namespace := snapshot.Metadata.Namespace
+	contentName := getContentName(snapshot)
+
+	// the content exists if binding failed after taking the snapshot
+	_, err := s.snapshots.GetContent(namespace, contentName)
+	if err == nil {
+		return s.bindSnapshot(snapshot, contentName)
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error getting snapshot content %s/%s: %v", namespace, contentName, err)
+	}
+
+	pv, err := s.getPV(snapshot)
+	if err != nil {
+		s.setError(snapshot, err)
+		return err
+	}
+	pluginName := volume.GetPluginName(pv)
+	plugin, ok := s.plugins[pluginName]
+	if !ok {
+		err := fmt.Errorf("volume %s of claim %s can't be snapshotted: no snapshot plugin for its volume type", pv.Name, snapshot.Spec.PersistentVolumeClaimName)
+		s.setError(snapshot, err)
+		return err
+	}
+
+	source, err := plugin.SnapshotCreate(pv)
+	if err != nil {
+		err = fmt.Errorf("error taking snapshot of volume %s: %v", pv.Name, err)
+		s.setError(snapshot, err)
+		return err
+	}
+	glog.Infof("took snapshot of volume %s for snapshot %s/%s", pv.Name, namespace, snapshot.Metadata.Name)
+
+	content := &tprv1.VolumeSnapshotContent{
+		Metadata: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      contentName,
+		},
+		Spec: tprv1.VolumeSnapshotContentSpec{
+			VolumeSnapshotContentSource: *source,
+			VolumeSnapshotRef: &v1.ObjectReference{
+				Kind:      "VolumeSnapshot",
+				Namespace: namespace,
+				Name:      snapshot.Metadata.Name,
+				UID:       snapshot.Metadata.UID,
+			},
+			PersistentVolumeRef: &v1.ObjectReference{
+				Kind: "PersistentVolume",
+				Name: pv.Name,
+				UID:  pv.UID,
+			},
+		},
+	}
+	if _, err := s.snapshots.CreateContent(content); err != nil {
+		if deleteErr := plugin.SnapshotDelete(source, pv); deleteErr != nil {
+			glog.Errorf("failed to delete snapshot of volume %s after failing to record it: %v", pv.Name, deleteErr)
+		}
+		return fmt.Errorf("error creating snapshot content %s/%s: %v", namespace, contentName, err)
+	}
+
+	return s.bindSnapshot(snapshot, contentName)
+}
+
+// DeleteSnapshot deletes the snapshot the VolumeSnapshot is bound to and its
+// VolumeSnapshotContent.
+func (s *Snapshotter) DeleteSnapshot(snapshot *tprv1.VolumeSnapshot) error {
+	namespace := snapshot.Metadata.Namespace
+	contentName := snapshot.Spec.SnapshotContentName
+	if contentName == "" {
+		// the snapshot may have been deleted before it was bound
+		contentName = getContentName(snapshot)
+	}
+	content, err := s.snapshots.GetContent(namespace, contentName)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("error getting snapshot content %s/%s: %v", namespace, contentName, err)
+	}
+
+	pluginName := volume.GetSnapshotPluginName(&content.Spec.VolumeSnapshotContentSource)
+	plugin, ok := s.plugins[pluginName]
+	if !ok {
+		return fmt.Errorf("snapshot content %s/%s can't be deleted: no snapshot plugin for its snapshot type", namespace, contentName)
+	}
+	// the volume may be gone, the snapshot needn't be
+	var pv *v1.PersistentVolume
+	if ref := content.Spec.PersistentVolumeRef; ref != nil {
+		pv, err = s.client.Core().PersistentVolumes().Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			pv = nil
+		}
+	}
+	if err := plugin.SnapshotDelete(&content.Spec.VolumeSnapshotContentSource, pv); err != nil {
+		return fmt.Errorf("error deleting snapshot of snapshot content %s/%s: %v", namespace, contentName, err)
+	}
+	if err := s.snapshots.DeleteContent(namespace, contentName); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleted snapshot but failed to delete snapshot content %s/%s: %v", namespace, contentName, err)
+	}
+	glog.Infof("deleted snapshot content %s/%s", namespace, contentName)
+	return nil
+}
+
+// getContentName returns the name of the VolumeSnapshotContent of the
+// snapshot, unique so that retries find the content of earlier attempts.
+func getContentName(snapshot *tprv1.VolumeSnapshot) string {
+	return "snapshotcontent-" + string(snapshot.Metadata.UID)
+}
+
+// getPV returns the PV bound to the snapshot's claim.
+func (s *Snapshotter) getPV(snapshot *tprv1.VolumeSnapshot) (*v1.PersistentVolume, error) {
+	namespace, claimName := snapshot.Metadata.Namespace, snapshot.Spec.PersistentVolumeClaimName
+	if claimName == "" {
+		return nil, fmt.Errorf("snapshot %s/%s has no persistentVolumeClaimName", namespace, snapshot.Metadata.Name)
+	}
+	claim, err := s.client.Core().PersistentVolumeClaims(namespace).Get(claimName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting claim %s/%s: %v", namespace, claimName, err)
+	}
+	if claim.Spec.VolumeName == "" {
+		return nil, fmt.Errorf("claim %s/%s is not bound", namespace, claimName)
+	}
+	pv, err := s.client.Core().PersistentVolumes().Get(claim.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting volume %s of claim %s/%s: %v", claim.Spec.VolumeName, namespace, claimName, err)
+	}
+	return pv, nil
+}
+
+// bindSnapshot binds the snapshot to its content and marks it ready.
+func (s *Snapshotter) bindSnapshot(snapshot *tprv1.VolumeSnapshot, contentName string) error {
+	snapshot.Spec.SnapshotContentName = contentName
+	snapshot.Status.Conditions = []tprv1.VolumeSnapshotCondition{
+		{
+			Type:               tprv1.VolumeSnapshotConditionReady,
+			Status:             v1.ConditionTrue,
+			LastTransitionTime: metav1.Now(),
+			Message:            "Snapshot is taken",
+		},
+	}
+	if _, err := s.snapshots.UpdateSnapshot(snapshot); err != nil {
+		return fmt.Errorf("error binding snapshot %s/%s to content %s: %v", snapshot.Metadata.Namespace, snapshot.Metadata.Name, contentName, err)
+	}
+	glog.Infof("snapshot %s/%s is bound to content %s", snapshot.Metadata.Namespace, snapshot.Metadata.Name, contentName)
+	return nil
+}
+
+// setError records in the snapshot's status why taking it failed.
+func (s *Snapshotter) setError(snapshot *tprv1.VolumeSnapshot, cause error) {
+	conditions := snapshot.Status.Conditions
+	if len(conditions) == 1 && conditions[0].Type == tprv1.VolumeSnapshotConditionError && conditions[0].Message == cause.Error() {
+		return
+	}
+	snapshot.Status.Conditions = []tprv1.VolumeSnapshotCondition{
+		{
+			Type:               tprv1.VolumeSnapshotConditionError,
+			Status:             v1.ConditionTrue,
+			LastTransitionTime: metav1.Now(),
+			Reason:             "SnapshotFailed",
+			Message:            cause.Error(),
+		},
+	}
+	if _, err := s.snapshots.UpdateSnapshot(snapshot); err != nil {
+		glog.Errorf("failed to record error of snapshot %s/%s: %v", snapshot.Metadata.Namespace, snapshot.Metadata.Name, err)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	tprv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/tpr/v1"
+	snapshotclient "github.com/kubernetes-incubator/external-storage/snapshot/pkg/client"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/volume"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// fakePlugin keeps snapshots of hostPath volumes in memory.
+type fakePlugin struct {
+	snapshots map[string]bool
+	failure   error
+}
+
+var _ volume.Plugin = &fakePlugin{}
+
+func (f *fakePlugin) SnapshotCreate(pv *v1.PersistentVolume) (*tprv1.VolumeSnapshotContentSource, error) {
+	if f.failure != nil {
+		return nil, f.failure
+	}
+	path := pv.Spec.HostPath.Path + ".tgz"
+	f.snapshots[path] = true
+	return &tprv1.VolumeSnapshotContentSource{HostPath: &tprv1.HostPathVolumeSnapshotSource{Path: path}}, nil
+}
+
+func (f *fakePlugin) SnapshotDelete(source *tprv1.VolumeSnapshotContentSource, pv *v1.PersistentVolume) error {
+	delete(f.snapshots, source.HostPath.Path)
+	return nil
+}
+
+func (f *fakePlugin) SnapshotRestore(content *tprv1.VolumeSnapshotContent, pvc *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (*v1.PersistentVolumeSource, map[string]string, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func (f *fakePlugin) VolumeDelete(pv *v1.PersistentVolume) error {
+	return errors.New("not implemented")
+}
+
+func newSnapshot(name, claimName string) *tprv1.VolumeSnapshot {
+	return &tprv1.VolumeSnapshot{
+		Metadata: metav1.ObjectMeta{Namespace: "ns", Name: name, UID: types.UID(name + "-uid")},
+		Spec:     tprv1.VolumeSnapshotSpec{PersistentVolumeClaimName: claimName},
+	}
+}
+
+func TestCreateDeleteSnapshot(t *testing.T) {
+	claims := []*v1.PersistentVolumeClaim{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "hostpath-claim"},
+			Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "hostpath-pv"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "nfs-claim"},
+			Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "nfs-pv"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "unbound-claim"},
+		},
+	}
+	pvs := []*v1.PersistentVolume{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "hostpath-pv"},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/data/hostpath-pv"}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "nfs-pv"},
+			Spec: v1.PersistentVolumeSpec{
+				PersistentVolumeSource: v1.PersistentVolumeSource{NFS: &v1.NFSVolumeSource{Server: "1.2.3.4", Path: "/nfs-pv"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		claimName     string
+		pluginFailure error
+		expectError   bool
+	}{
+		{
+			name:      "hostPath volume",
+			claimName: "hostpath-claim",
+		},
+		{
+			name:        "volume without plugin",
+			claimName:   "nfs-claim",
+			expectError: true,
+		},
+		{
+			name:        "unbound claim",
+			claimName:   "unbound-claim",
+			expectError: true,
+		},
+		{
+			name:        "missing claim",
+			claimName:   "missing-claim",
+			expectError: true,
+		},
+		{
+			name:          "plugin failure",
+			claimName:     "hostpath-claim",
+			pluginFailure: errors.New("disk full"),
+			expectError:   true,
+		},
+	}
+	for _, test := range tests {
+		client := fake.NewSimpleClientset()
+		for _, claim := range claims {
+			client.Core().PersistentVolumeClaims(claim.Namespace).Create(claim)
+		}
+		for _, pv := range pvs {
+			client.Core().PersistentVolumes().Create(pv)
+		}
+		snapshot := newSnapshot("snapshot", test.claimName)
+		snapshots := snapshotclient.NewFakeClient(snapshot)
+		plugin := &fakePlugin{snapshots: map[string]bool{}, failure: test.pluginFailure}
+		snapshotter := NewSnapshotter(client, snapshots, map[string]volume.Plugin{"hostPath": plugin})
+
+		err := snapshotter.CreateSnapshot(snapshot)
+		updated, _ := snapshots.GetSnapshot("ns", "snapshot")
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Test %s: expected error but got none", test.name)
+			}
+			if len(updated.Status.Conditions) != 1 || updated.Status.Conditions[0].Type != tprv1.VolumeSnapshotConditionError {
+				t.Errorf("Test %s: expected Error condition but got %v", test.name, updated.Status.Conditions)
+			}
+			if len(snapshots.Contents) != 0 || len(plugin.snapshots) != 0 {
+				t.Errorf("Test %s: expected no snapshot to be taken but got %v, %v", test.name, snapshots.Contents, plugin.snapshots)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %s: expected no error but got: %v", test.name, err)
+			continue
+		}
+		if updated.Spec.SnapshotContentName != "snapshotcontent-snapshot-uid" {
+			t.Errorf("Test %s: expected snapshot to be bound to snapshotcontent-snapshot-uid but got %q", test.name, updated.Spec.SnapshotContentName)
+		}
+		if len(updated.Status.Conditions) != 1 || updated.Status.Conditions[0].Type != tprv1.VolumeSnapshotConditionReady {
+			t.Errorf("Test %s: expected Ready condition but got %v", test.name, updated.Status.Conditions)
+		}
+		content, err := snapshots.GetContent("ns", "snapshotcontent-snapshot-uid")
+		if err != nil {
+			t.Errorf("Test %s: error getting snapshot content: %v", test.name, err)
+		} else if content.Spec.HostPath == nil || !plugin.snapshots[content.Spec.HostPath.Path] || content.Spec.PersistentVolumeRef.Name != "hostpath-pv" {
+			t.Errorf("Test %s: expected content of the snapshot of hostpath-pv but got %+v", test.name, content.Spec)
+		}
+
+		// creating a bound snapshot does nothing
+		if err := snapshotter.CreateSnapshot(updated); err != nil || len(plugin.snapshots) != 1 {
+			t.Errorf("Test %s: expected creating a bound snapshot to do nothing but got %v, %v", test.name, err, plugin.snapshots)
+		}
+
+		if err := snapshotter.DeleteSnapshot(updated); err != nil {
+			t.Errorf("Test %s: error deleting snapshot: %v", test.name, err)
+		}
+		if len(snapshots.Contents) != 0 || len(plugin.snapshots) != 0 {
+			t.Errorf("Test %s: expected snapshot to be deleted but got %v, %v", test.name, snapshots.Contents, plugin.snapshots)
+		}
+		if err := snapshotter.DeleteSnapshot(updated); err != nil {
+			t.Errorf("Test %s: expected deleting a deleted snapshot to succeed but got: %v", test.name, err)
+		}
+	}
+}
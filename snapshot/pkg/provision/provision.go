@@ -0,0 +1,139 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	snapshotclient "github.com/kubernetes-incubator/external-storage/snapshot/pkg/client"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/volume"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// SnapshotPVCAnnotation is the annotation of a claim naming the
+	// VolumeSnapshot in its namespace to restore into its volume
+	SnapshotPVCAnnotation = "snapshot.alpha.kubernetes.io/snapshot"
+
+	provisionerIDAnn = "snapshotProvisionerIdentity"
+	// pluginAnn is the name of the plugin that restored a PV, to delete it
+	pluginAnn = "snapshotProvisionerPlugin"
+)
+
+type snapshotProvisioner struct {
+	snapshots snapshotclient.Interface
+	// plugins are the volume plugins by name, see volume.GetPluginName
+	plugins map[string]volume.Plugin
+	// Identity of this snapshotProvisioner. Used to identify "this"
+	// provisioner's PVs.
+	identity string
+}
+
+// NewSnapshotProvisioner creates a provisioner restoring the VolumeSnapshots
+// claims name in their SnapshotPVCAnnotation into new volumes with the given
+// plugins.
+func NewSnapshotProvisioner(snapshots snapshotclient.Interface, plugins map[string]volume.Plugin, id string) controller.Provisioner {
+	return &snapshotProvisioner{
+		snapshots: snapshots,
+		plugins:   plugins,
+		identity:  id,
+	}
+}
+
+var _ controller.Provisioner = &snapshotProvisioner{}
+
+// Provision restores the claim's snapshot into a new volume with the plugin
+// that took the snapshot and returns a PV object representing it.
+func (p *snapshotProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	namespace := options.PVC.Namespace
+	snapshotName, ok := options.PVC.Annotations[SnapshotPVCAnnotation]
+	if !ok || snapshotName == "" {
+		return nil, fmt.Errorf("claim has no %s annotation naming the snapshot to restore", SnapshotPVCAnnotation)
+	}
+	snapshot, err := p.snapshots.GetSnapshot(namespace, snapshotName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting snapshot %s/%s: %v", namespace, snapshotName, err)
+	}
+	if snapshot.Spec.SnapshotContentName == "" {
+		return nil, fmt.Errorf("snapshot %s/%s is not taken yet", namespace, snapshotName)
+	}
+	content, err := p.snapshots.GetContent(namespace, snapshot.Spec.SnapshotContentName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting content %s of snapshot %s/%s: %v", snapshot.Spec.SnapshotContentName, namespace, snapshotName, err)
+	}
+
+	pluginName := volume.GetSnapshotPluginName(&content.Spec.VolumeSnapshotContentSource)
+	plugin, ok := p.plugins[pluginName]
+	if !ok {
+		return nil, fmt.Errorf("snapshot %s/%s can't be restored: no snapshot plugin for its snapshot type", namespace, snapshotName)
+	}
+	source, annotations, err := plugin.SnapshotRestore(content, options.PVC, options.PVName, options.Parameters)
+	if err != nil {
+		glog.Errorf("failed to restore snapshot %s/%s: %v", namespace, snapshotName, err)
+		return nil, err
+	}
+	glog.Infof("successfully restored snapshot %s/%s into volume %s", namespace, snapshotName, options.PVName)
+
+	pvAnnotations := map[string]string{}
+	for k, v := range annotations {
+		pvAnnotations[k] = v
+	}
+	pvAnnotations[provisionerIDAnn] = p.identity
+	pvAnnotations[pluginAnn] = pluginName
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        options.PVName,
+			Annotations: pvAnnotations,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
+			},
+			PersistentVolumeSource: *source,
+		},
+	}
+
+	return pv, nil
+}
+
+// Delete deletes the restored volume represented by the given PV with the
+// plugin that restored it.
+func (p *snapshotProvisioner) Delete(pv *v1.PersistentVolume) error {
+	ann, ok := pv.Annotations[provisionerIDAnn]
+	if !ok {
+		return errors.New("identity annotation not found on PV")
+	}
+	if ann != p.identity {
+		return &controller.IgnoredError{Reason: "identity annotation on PV does not match ours"}
+	}
+	pluginName := pv.Annotations[pluginAnn]
+	plugin, ok := p.plugins[pluginName]
+	if !ok {
+		return fmt.Errorf("PV can't be deleted: no snapshot plugin %q", pluginName)
+	}
+	return plugin.VolumeDelete(pv)
+}
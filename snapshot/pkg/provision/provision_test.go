@@ -0,0 +1,151 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provision
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	tprv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/tpr/v1"
+	snapshotclient "github.com/kubernetes-incubator/external-storage/snapshot/pkg/client"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/volume"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// fakePlugin restores hostPath snapshots into hostPath volumes in memory.
+type fakePlugin struct {
+	volumes map[string]string
+}
+
+var _ volume.Plugin = &fakePlugin{}
+
+func (f *fakePlugin) SnapshotCreate(pv *v1.PersistentVolume) (*tprv1.VolumeSnapshotContentSource, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakePlugin) SnapshotDelete(source *tprv1.VolumeSnapshotContentSource, pv *v1.PersistentVolume) error {
+	return errors.New("not implemented")
+}
+
+func (f *fakePlugin) SnapshotRestore(content *tprv1.VolumeSnapshotContent, pvc *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (*v1.PersistentVolumeSource, map[string]string, error) {
+	path := "/restored/" + pvName
+	f.volumes[path] = content.Spec.HostPath.Path
+	return &v1.PersistentVolumeSource{HostPath: &v1.HostPathVolumeSource{Path: path}}, map[string]string{"restoredFrom": content.Metadata.Name}, nil
+}
+
+func (f *fakePlugin) VolumeDelete(pv *v1.PersistentVolume) error {
+	delete(f.volumes, pv.Spec.HostPath.Path)
+	return nil
+}
+
+func TestProvisionDelete(t *testing.T) {
+	taken := &tprv1.VolumeSnapshot{
+		Metadata: metav1.ObjectMeta{Namespace: "ns", Name: "taken"},
+		Spec:     tprv1.VolumeSnapshotSpec{PersistentVolumeClaimName: "claim", SnapshotContentName: "content"},
+	}
+	pending := &tprv1.VolumeSnapshot{
+		Metadata: metav1.ObjectMeta{Namespace: "ns", Name: "pending"},
+		Spec:     tprv1.VolumeSnapshotSpec{PersistentVolumeClaimName: "claim"},
+	}
+	snapshots := snapshotclient.NewFakeClient(taken, pending)
+	snapshots.CreateContent(&tprv1.VolumeSnapshotContent{
+		Metadata: metav1.ObjectMeta{Namespace: "ns", Name: "content"},
+		Spec: tprv1.VolumeSnapshotContentSpec{
+			VolumeSnapshotContentSource: tprv1.VolumeSnapshotContentSource{
+				HostPath: &tprv1.HostPathVolumeSnapshotSource{Path: "/snapshots/1.tgz"},
+			},
+		},
+	})
+	plugin := &fakePlugin{volumes: map[string]string{}}
+	p := NewSnapshotProvisioner(snapshots, map[string]volume.Plugin{"hostPath": plugin}, "id")
+
+	tests := []struct {
+		name        string
+		snapshot    string
+		expectError bool
+	}{
+		{
+			name:     "taken snapshot",
+			snapshot: "taken",
+		},
+		{
+			name:        "pending snapshot",
+			snapshot:    "pending",
+			expectError: true,
+		},
+		{
+			name:        "missing snapshot",
+			snapshot:    "missing",
+			expectError: true,
+		},
+		{
+			name:        "no snapshot",
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		options := controller.VolumeOptions{
+			PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+			PVName:                        "pvc-1",
+			PVC: &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "restored"},
+				Spec: v1.PersistentVolumeClaimSpec{
+					AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{
+							v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Gi"),
+						},
+					},
+				},
+			},
+		}
+		if test.snapshot != "" {
+			options.PVC.Annotations = map[string]string{SnapshotPVCAnnotation: test.snapshot}
+		}
+		pv, err := p.Provision(options)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("Test %s: expected error but got none", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %s: expected no error but got: %v", test.name, err)
+			continue
+		}
+		if pv.Spec.HostPath == nil || plugin.volumes[pv.Spec.HostPath.Path] != "/snapshots/1.tgz" {
+			t.Errorf("Test %s: expected volume restored from /snapshots/1.tgz but got %v", test.name, pv.Spec.PersistentVolumeSource)
+		}
+		if pv.Annotations["restoredFrom"] != "content" {
+			t.Errorf("Test %s: expected the plugin's annotations on the PV but got %v", test.name, pv.Annotations)
+		}
+
+		if err := p.Delete(pv); err != nil {
+			t.Errorf("Test %s: error deleting volume: %v", test.name, err)
+		}
+		if len(plugin.volumes) != 0 {
+			t.Errorf("Test %s: expected volume to be deleted but got %v", test.name, plugin.volumes)
+		}
+		pv.Annotations[provisionerIDAnn] = "other"
+		if err := p.Delete(pv); err == nil {
+			t.Errorf("Test %s: expected error deleting another provisioner's volume but got none", test.name)
+		}
+	}
+}
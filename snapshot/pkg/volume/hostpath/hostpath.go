@@ -0,0 +1,109 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	tprv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/tpr/v1"
+	"github.com/kubernetes-incubator/external-storage/snapshot/pkg/volume"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// hostPathPlugin snapshots hostPath volumes by archiving their directory with
+// tar. The snapshot controller and provisioner must run on the volumes' node
+// with snapshotDir and restoreDir mounted at the same paths as on the host.
+type hostPathPlugin struct {
+	// snapshotDir is where the snapshot archives are stored
+	snapshotDir string
+	// restoreDir is where restored volumes' directories are created
+	restoreDir string
+}
+
+// NewPlugin creates a hostPath snapshot plugin storing snapshots in
+// snapshotDir and restoring them into directories in restoreDir.
+func NewPlugin(snapshotDir, restoreDir string) volume.Plugin {
+	return &hostPathPlugin{
+		snapshotDir: snapshotDir,
+		restoreDir:  restoreDir,
+	}
+}
+
+var _ volume.Plugin = &hostPathPlugin{}
+
+// SnapshotCreate archives the PV's directory.
+func (h *hostPathPlugin) SnapshotCreate(pv *v1.PersistentVolume) (*tprv1.VolumeSnapshotContentSource, error) {
+	if pv.Spec.HostPath == nil {
+		return nil, fmt.Errorf("PV %s is not a hostPath volume", pv.Name)
+	}
+	archive := path.Join(h.snapshotDir, string(uuid.NewUUID())+".tgz")
+	cmd := exec.Command("tar", "czf", archive, "-C", pv.Spec.HostPath.Path, ".")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(archive)
+		return nil, fmt.Errorf("error archiving %s: %v, output: %s", pv.Spec.HostPath.Path, err, out)
+	}
+	return &tprv1.VolumeSnapshotContentSource{
+		HostPath: &tprv1.HostPathVolumeSnapshotSource{Path: archive},
+	}, nil
+}
+
+// SnapshotDelete removes the snapshot's archive.
+func (h *hostPathPlugin) SnapshotDelete(source *tprv1.VolumeSnapshotContentSource, pv *v1.PersistentVolume) error {
+	if source.HostPath == nil {
+		return fmt.Errorf("snapshot is not a hostPath snapshot")
+	}
+	if err := os.Remove(source.HostPath.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SnapshotRestore extracts the snapshot's archive into a new directory.
+func (h *hostPathPlugin) SnapshotRestore(content *tprv1.VolumeSnapshotContent, pvc *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (*v1.PersistentVolumeSource, map[string]string, error) {
+	if content.Spec.HostPath == nil {
+		return nil, nil, fmt.Errorf("snapshot content %s is not a hostPath snapshot", content.Metadata.Name)
+	}
+	dir := path.Join(h.restoreDir, pvName)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, nil, err
+	}
+	cmd := exec.Command("tar", "xzf", content.Spec.HostPath.Path, "-C", dir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, fmt.Errorf("error extracting %s: %v, output: %s", content.Spec.HostPath.Path, err, out)
+	}
+	return &v1.PersistentVolumeSource{
+		HostPath: &v1.HostPathVolumeSource{Path: dir},
+	}, nil, nil
+}
+
+// VolumeDelete removes a restored volume's directory.
+func (h *hostPathPlugin) VolumeDelete(pv *v1.PersistentVolume) error {
+	if pv.Spec.HostPath == nil {
+		return fmt.Errorf("PV %s is not a hostPath volume", pv.Name)
+	}
+	dir := path.Clean(pv.Spec.HostPath.Path)
+	if !strings.HasPrefix(dir, path.Clean(h.restoreDir)+"/") {
+		return fmt.Errorf("PV %s's path %s is not in %s", pv.Name, dir, h.restoreDir)
+	}
+	return os.RemoveAll(dir)
+}
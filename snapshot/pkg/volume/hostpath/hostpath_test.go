@@ -0,0 +1,92 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	tprv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/tpr/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	utiltesting "k8s.io/client-go/util/testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("hostPathSnapshotTest")
+	defer os.RemoveAll(tmpDir)
+	for _, dir := range []string{"volume", "snapshots", "restored"} {
+		if err := os.Mkdir(path.Join(tmpDir, dir), 0755); err != nil {
+			t.Fatalf("Error creating %s: %v", dir, err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(tmpDir, "volume", "data"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Error writing volume data: %v", err)
+	}
+
+	p := NewPlugin(path.Join(tmpDir, "snapshots"), path.Join(tmpDir, "restored"))
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv"},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				HostPath: &v1.HostPathVolumeSource{Path: path.Join(tmpDir, "volume")},
+			},
+		},
+	}
+	source, err := p.SnapshotCreate(pv)
+	if err != nil {
+		t.Fatalf("Error creating snapshot: %v", err)
+	}
+
+	content := &tprv1.VolumeSnapshotContent{
+		Metadata: metav1.ObjectMeta{Name: "content"},
+		Spec:     tprv1.VolumeSnapshotContentSpec{VolumeSnapshotContentSource: *source},
+	}
+	restored, _, err := p.SnapshotRestore(content, &v1.PersistentVolumeClaim{}, "pvc-1", nil)
+	if err != nil {
+		t.Fatalf("Error restoring snapshot: %v", err)
+	}
+	expectedPath := path.Join(tmpDir, "restored", "pvc-1")
+	if restored.HostPath == nil || restored.HostPath.Path != expectedPath {
+		t.Fatalf("Expected hostPath volume %s but got %v", expectedPath, restored)
+	}
+	if data, err := ioutil.ReadFile(path.Join(expectedPath, "data")); err != nil || string(data) != "data" {
+		t.Errorf("Expected restored volume to have the snapshot's data but got %q, %v", data, err)
+	}
+
+	if err := p.VolumeDelete(&v1.PersistentVolume{Spec: v1.PersistentVolumeSpec{PersistentVolumeSource: *restored}}); err != nil {
+		t.Errorf("Error deleting restored volume: %v", err)
+	}
+	if _, err := os.Stat(expectedPath); !os.IsNotExist(err) {
+		t.Errorf("Expected restored volume to be deleted")
+	}
+	if err := p.VolumeDelete(pv); err == nil {
+		t.Errorf("Expected error deleting a volume that wasn't restored but got none")
+	}
+
+	if err := p.SnapshotDelete(source, pv); err != nil {
+		t.Errorf("Error deleting snapshot: %v", err)
+	}
+	if _, err := os.Stat(source.HostPath.Path); !os.IsNotExist(err) {
+		t.Errorf("Expected snapshot to be deleted")
+	}
+	if err := p.SnapshotDelete(source, pv); err != nil {
+		t.Errorf("Expected deleting a deleted snapshot to succeed but got: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	tprv1 "github.com/kubernetes-incubator/external-storage/snapshot/pkg/apis/tpr/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Plugin takes snapshots of the volumes of a storage backend, e.g. ZFS, Btrfs
+// or Ceph RBD, and restores them into new volumes. A backend offers snapshots
+// and restore-from-snapshot claims by implementing a Plugin and adding a case
+// for its volume and snapshot sources to GetPluginName and
+// GetSnapshotPluginName.
+type Plugin interface {
+	// SnapshotCreate takes a snapshot of the PV and returns the snapshot's
+	// source.
+	SnapshotCreate(pv *v1.PersistentVolume) (*tprv1.VolumeSnapshotContentSource, error)
+	// SnapshotDelete deletes the snapshot, which was taken of the PV, or nil
+	// if the PV has since been deleted. It is not an error if the snapshot
+	// doesn't exist.
+	SnapshotDelete(source *tprv1.VolumeSnapshotContentSource, pv *v1.PersistentVolume) error
+	// SnapshotRestore creates a new volume named pvName with the snapshot's
+	// content for the claim, and returns its source and any annotations to
+	// give its PV. parameters are the claim's class's.
+	SnapshotRestore(content *tprv1.VolumeSnapshotContent, pvc *v1.PersistentVolumeClaim, pvName string, parameters map[string]string) (*v1.PersistentVolumeSource, map[string]string, error)
+	// VolumeDelete deletes a volume created by SnapshotRestore. It is not an
+	// error if the volume doesn't exist.
+	VolumeDelete(pv *v1.PersistentVolume) error
+}
+
+// GetPluginName returns the name of the plugin snapshotting the PV, or "" if
+// there's none.
+func GetPluginName(pv *v1.PersistentVolume) string {
+	if pv.Spec.HostPath != nil {
+		return "hostPath"
+	}
+	return ""
+}
+
+// GetSnapshotPluginName returns the name of the plugin that took the
+// snapshot, or "" if there's none.
+func GetSnapshotPluginName(source *tprv1.VolumeSnapshotContentSource) string {
+	if source.HostPath != nil {
+		return "hostPath"
+	}
+	return ""
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/tmpfs/pkg/volume"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// The env variable the name of the node the provisioner runs on is read
+	// from, set with the downward API
+	nodeNameEnv = "NODE_NAME"
+
+	hostnameLabel = "kubernetes.io/hostname"
+)
+
+var (
+	provisioner    = flag.String("provisioner", "example.com/tmpfs", "Name of the provisioner. The provisioner will only provision volumes for claims that request a StorageClass with a provisioner field set equal to this name.")
+	master         = flag.String("master", "", "Master URL to build a client config from. Either this or kubeconfig needs to be set if the provisioner is being run out of cluster.")
+	kubeconfig     = flag.String("kubeconfig", "", "Absolute path to the kubeconfig file. Either this or master needs to be set if the provisioner is being run out of cluster.")
+	baseDir        = flag.String("base-dir", "/var/lib/tmpfs-provisioner", "The directory to mount volumes in. It must be mounted at the same path in the provisioner's container as on the node.")
+	capacity       = flag.String("capacity", "", "The most memory the node's volumes may use in total, e.g. 4Gi. Unlimited if empty.")
+	mountNamespace = flag.String("mount-namespace", "/proc/1/ns/mnt", "The mount namespace to mount volumes in, the node's with hostPID. The provisioner's own if empty.")
+)
+
+func main() {
+	flag.Parse()
+	flag.Set("logtostderr", "true")
+
+	nodeName := os.Getenv(nodeNameEnv)
+	if nodeName == "" {
+		glog.Fatalf("environment variable %s is not set! Please set it.", nodeNameEnv)
+	}
+	if _, err := os.Stat(*baseDir); os.IsNotExist(err) {
+		glog.Fatalf("base-dir %s does not exist!", *baseDir)
+	}
+	capacityBytes := int64(0)
+	if *capacity != "" {
+		quantity, err := resource.ParseQuantity(*capacity)
+		if err != nil {
+			glog.Fatalf("Invalid capacity %q: %v", *capacity, err)
+		}
+		capacityBytes = quantity.Value()
+	}
+
+	var config *rest.Config
+	var err error
+	if *master != "" || *kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags(*master, *kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		glog.Fatalf("Failed to create config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create client: %v", err)
+	}
+
+	// The controller needs to know what the server version is because out-of-tree
+	// provisioners aren't officially supported until 1.5
+	serverVersion, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		glog.Fatalf("Error getting server version: %v", err)
+	}
+
+	// PVs' node affinity selects the node by its hostname label, which is
+	// usually but not always its name
+	node, err := clientset.Core().Nodes().Get(nodeName, metav1.GetOptions{})
+	if err != nil {
+		glog.Fatalf("Error getting node %s: %v", nodeName, err)
+	}
+	hostname, ok := node.Labels[hostnameLabel]
+	if !ok {
+		hostname = nodeName
+	}
+
+	// The volumes already on the node count against its capacity, and need
+	// mounting again if the node rebooted
+	volumes, err := clientset.Core().PersistentVolumes().List(metav1.ListOptions{})
+	if err != nil {
+		glog.Fatalf("Error listing volumes: %v", err)
+	}
+	tmpfsProvisioner, err := volume.NewTmpfsProvisioner(*baseDir, nodeName, hostname, capacityBytes, volume.NewMounter(*mountNamespace), volumes.Items)
+	if err != nil {
+		glog.Fatalf("Error restoring volumes: %v", err)
+	}
+
+	// Start the provision controller which will dynamically provision tmpfs
+	// hostPath PVs
+	pc := controller.NewProvisionController(clientset, *provisioner, tmpfsProvisioner, serverVersion.GitVersion)
+	pc.Run(wait.NeverStop)
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// Mounter mounts and unmounts the tmpfs filesystems backing volumes on the
+// node.
+type Mounter interface {
+	// Mount mounts a tmpfs of the given size in bytes, writable by all, at
+	// the directory.
+	Mount(dir string, sizeBytes int64) error
+	// Unmount unmounts the directory.
+	Unmount(dir string) error
+	// IsMounted returns whether something is mounted at the directory.
+	IsMounted(dir string) (bool, error)
+}
+
+// nsenterMounter runs mount, umount and mountpoint in the mount namespace at
+// a path, e.g. the node's, so that the mounts are seen by the pods using the
+// volumes and not only by the provisioner's container.
+type nsenterMounter struct {
+	namespace string
+}
+
+// NewMounter returns a Mounter running the node's mount commands in the mount
+// namespace at the given path, e.g. /proc/1/ns/mnt with hostPID, or in the
+// provisioner's own mount namespace if the path is empty.
+func NewMounter(namespace string) Mounter {
+	return &nsenterMounter{namespace: namespace}
+}
+
+var _ Mounter = &nsenterMounter{}
+
+func (m *nsenterMounter) command(name string, args ...string) *exec.Cmd {
+	if m.namespace == "" {
+		return exec.Command(name, args...)
+	}
+	return exec.Command("nsenter", append([]string{"--mount=" + m.namespace, "--", name}, args...)...)
+}
+
+func (m *nsenterMounter) Mount(dir string, sizeBytes int64) error {
+	options := "size=" + strconv.FormatInt(sizeBytes, 10) + ",mode=0777"
+	if output, err := m.command("mount", "-t", "tmpfs", "-o", options, "tmpfs", dir).CombinedOutput(); err != nil {
+		return fmt.Errorf("error mounting tmpfs at %s: %v, output: %s", dir, err, output)
+	}
+	return nil
+}
+
+func (m *nsenterMounter) Unmount(dir string) error {
+	if output, err := m.command("umount", dir).CombinedOutput(); err != nil {
+		return fmt.Errorf("error unmounting %s: %v, output: %s", dir, err, output)
+	}
+	return nil
+}
+
+func (m *nsenterMounter) IsMounted(dir string) (bool, error) {
+	output, err := m.command("mountpoint", "-q", dir).CombinedOutput()
+	if err == nil {
+		return true, nil
+	}
+	// mountpoint exits with 1 or 32, depending on the version, if the
+	// directory isn't a mount point
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && (status.ExitStatus() == 1 || status.ExitStatus() == 32) {
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("error checking if %s is a mount point: %v, output: %s", dir, err, output)
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/helper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const (
+	// A PV annotation for the name of the node whose tmpfs provisioner
+	// provisioned it, the only one that can delete it
+	annProvisionerNode = "tmpfsProvisionerNode"
+
+	// This annotation is added to a PVC by the scheduler when the PVC's
+	// StorageClass has volumeBindingMode WaitForFirstConsumer. Its value is
+	// the name of the node the first pod consuming the PVC has been
+	// scheduled to.
+	annSelectedNode = "volume.kubernetes.io/selected-node"
+
+	// The node label the PVs' node affinity selects the node by
+	hostnameLabel = "kubernetes.io/hostname"
+)
+
+// NewTmpfsProvisioner creates a Provisioner that provisions hostPath PVs
+// backed by tmpfs filesystems, of the claims' sizes, mounted at directories
+// created in baseDir on the node named nodeName. The PVs have node affinity to
+// the node so that pods using them are scheduled to it. hostname is the
+// node's kubernetes.io/hostname label, usually its name. The volumes' total
+// size is limited to capacity bytes, if not 0.
+//
+// tmpfs filesystems don't survive reboots, so the given PVs provisioned on the
+// node whose directories aren't mounted are mounted again, empty, rather than
+// letting pods write to the node's disk.
+func NewTmpfsProvisioner(baseDir, nodeName, hostname string, capacity int64, mounter Mounter, volumes []v1.PersistentVolume) (controller.Provisioner, error) {
+	p := &tmpfsProvisioner{
+		baseDir:   baseDir,
+		nodeName:  nodeName,
+		hostname:  hostname,
+		capacity:  capacity,
+		mounter:   mounter,
+		allocated: map[string]int64{},
+	}
+	for _, volume := range volumes {
+		if volume.Annotations[annProvisionerNode] != nodeName || volume.Spec.HostPath == nil {
+			continue
+		}
+		size := volume.Spec.Capacity[v1.ResourceName(v1.ResourceStorage)]
+		if err := p.remount(volume.Spec.HostPath.Path, size.Value()); err != nil {
+			return nil, err
+		}
+		p.allocated[volume.Name] = size.Value()
+	}
+	return p, nil
+}
+
+type tmpfsProvisioner struct {
+	// The directory to create the mount points of volumes in. It must be
+	// mounted at the same path in the provisioner's container as on the node
+	baseDir string
+
+	// The name and kubernetes.io/hostname label of the node the provisioner
+	// is running on
+	nodeName string
+	hostname string
+
+	// The most bytes the volumes may have in total, 0 if unlimited
+	capacity int64
+
+	mounter Mounter
+
+	// The sizes of the volumes on the node, by PV name
+	mutex     sync.Mutex
+	allocated map[string]int64
+}
+
+var _ controller.Provisioner = &tmpfsProvisioner{}
+var _ controller.Qualifier = &tmpfsProvisioner{}
+
+// ShouldProvision returns false for claims that the scheduler has selected
+// another node for, so that only the provisioner on the selected node
+// provisions them, and for claims that don't fit in what's left of the node's
+// capacity, so that another node's provisioner does.
+func (p *tmpfsProvisioner) ShouldProvision(claim *v1.PersistentVolumeClaim) bool {
+	if selectedNode, found := claim.Annotations[annSelectedNode]; found {
+		return selectedNode == p.nodeName
+	}
+	size := claim.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.fits(size.Value())
+}
+
+// fits returns whether a volume of the size fits in what's left of the
+// capacity. The caller must hold the mutex.
+func (p *tmpfsProvisioner) fits(size int64) bool {
+	if p.capacity == 0 {
+		return true
+	}
+	total := size
+	for _, allocated := range p.allocated {
+		total += allocated
+	}
+	return total <= p.capacity
+}
+
+// remount mounts a tmpfs at the directory of a volume again if it isn't
+// mounted.
+func (p *tmpfsProvisioner) remount(directory string, size int64) error {
+	if err := os.MkdirAll(directory, 0777); err != nil {
+		return fmt.Errorf("error creating directory %s: %v", directory, err)
+	}
+	mounted, err := p.mounter.IsMounted(directory)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		return nil
+	}
+	if err := p.mounter.Mount(directory, size); err != nil {
+		return err
+	}
+	glog.Infof("Mounted tmpfs of %d bytes at %s again, its contents were lost", size, directory)
+	return nil
+}
+
+// Provision mounts a tmpfs of the claim's size at a new directory and returns
+// a PV object for it.
+func (p *tmpfsProvisioner) Provision(options controller.VolumeOptions) (*v1.PersistentVolume, error) {
+	if options.PVC.Spec.Selector != nil {
+		return nil, fmt.Errorf("claim Selector is not supported")
+	}
+	capacity := options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)]
+	size := capacity.Value()
+	if size <= 0 {
+		return nil, fmt.Errorf("claim must request a positive size")
+	}
+
+	// A PV name that's already allocated or on disk is a live volume, whose
+	// reservation must be neither counted twice nor released on error
+	directory := path.Join(p.baseDir, options.PVName)
+	p.mutex.Lock()
+	if _, ok := p.allocated[options.PVName]; ok {
+		p.mutex.Unlock()
+		return nil, fmt.Errorf("volume %q already exists", options.PVName)
+	}
+	if _, err := os.Stat(directory); !os.IsNotExist(err) {
+		p.mutex.Unlock()
+		return nil, fmt.Errorf("the path %s already exists", directory)
+	}
+	if !p.fits(size) {
+		p.mutex.Unlock()
+		return nil, fmt.Errorf("%s doesn't fit in what's left of node %s's capacity of %d bytes", capacity.String(), p.nodeName, p.capacity)
+	}
+	p.allocated[options.PVName] = size
+	p.mutex.Unlock()
+
+	pv, err := p.provision(options, directory, size)
+	if err != nil {
+		p.release(options.PVName)
+		return nil, err
+	}
+
+	glog.Infof("Mounted tmpfs of %d bytes at %s for volume %q on node %s", size, directory, options.PVName, p.nodeName)
+	return pv, nil
+}
+
+func (p *tmpfsProvisioner) provision(options controller.VolumeOptions, directory string, size int64) (*v1.PersistentVolume, error) {
+	if err := os.MkdirAll(directory, 0777); err != nil {
+		return nil, fmt.Errorf("error creating directory %s: %v", directory, err)
+	}
+	if err := p.mounter.Mount(directory, size); err != nil {
+		os.Remove(directory)
+		return nil, err
+	}
+
+	annotations := map[string]string{annProvisionerNode: p.nodeName}
+	affinity := &v1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+			NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{
+					MatchExpressions: []v1.NodeSelectorRequirement{
+						{
+							Key:      hostnameLabel,
+							Operator: v1.NodeSelectorOpIn,
+							Values:   []string{p.hostname},
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := helper.StorageNodeAffinityToAlphaAnnotation(annotations, affinity); err != nil {
+		p.unmount(directory)
+		return nil, fmt.Errorf("error converting node affinity to alpha annotation: %v", err)
+	}
+
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        options.PVName,
+			Annotations: annotations,
+		},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeReclaimPolicy: options.PersistentVolumeReclaimPolicy,
+			AccessModes:                   options.PVC.Spec.AccessModes,
+			Capacity: v1.ResourceList{
+				v1.ResourceName(v1.ResourceStorage): options.PVC.Spec.Resources.Requests[v1.ResourceName(v1.ResourceStorage)],
+			},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				HostPath: &v1.HostPathVolumeSource{
+					Path: directory,
+				},
+			},
+		},
+	}, nil
+}
+
+// unmount unmounts the tmpfs at the directory, discarding its contents, and
+// removes the directory.
+func (p *tmpfsProvisioner) unmount(directory string) error {
+	mounted, err := p.mounter.IsMounted(directory)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		if err := p.mounter.Unmount(directory); err != nil {
+			return err
+		}
+	}
+	// the directory is empty unless the tmpfs was lost and something wrote
+	// to the node's disk
+	if err := os.RemoveAll(directory); err != nil {
+		return fmt.Errorf("error removing directory %s: %v", directory, err)
+	}
+	return nil
+}
+
+// Delete unmounts the tmpfs that was mounted by Provision backing the given
+// PV, if it was mounted on this provisioner's node, and removes its
+// directory.
+func (p *tmpfsProvisioner) Delete(volume *v1.PersistentVolume) error {
+	node, ok := volume.Annotations[annProvisionerNode]
+	if !ok {
+		return fmt.Errorf("PV doesn't have an annotation %s", annProvisionerNode)
+	}
+	if node != p.nodeName {
+		return &controller.IgnoredError{Reason: fmt.Sprintf("volume %q is on node %s, not this provisioner's node %s", volume.Name, node, p.nodeName)}
+	}
+	if volume.Spec.HostPath == nil {
+		return fmt.Errorf("PV isn't a hostPath volume")
+	}
+
+	directory := volume.Spec.HostPath.Path
+	if path.Dir(directory) != path.Clean(p.baseDir) {
+		return fmt.Errorf("path %s isn't in base directory %s, refusing to delete it", directory, p.baseDir)
+	}
+	if _, err := os.Stat(directory); os.IsNotExist(err) {
+		p.release(volume.Name)
+		return nil
+	}
+	if err := p.unmount(directory); err != nil {
+		return err
+	}
+	p.release(volume.Name)
+
+	glog.Infof("Unmounted tmpfs at %s of volume %q on node %s", directory, volume.Name, p.nodeName)
+	return nil
+}
+
+// release returns the size of the volume to the node's capacity.
+func (p *tmpfsProvisioner) release(pvName string) {
+	p.mutex.Lock()
+	delete(p.allocated, pvName)
+	p.mutex.Unlock()
+}
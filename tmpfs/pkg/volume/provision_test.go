@@ -0,0 +1,262 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package volume
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/kubernetes-incubator/external-storage/lib/controller"
+	"github.com/kubernetes-incubator/external-storage/lib/helper"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/api/v1"
+	utiltesting "k8s.io/client-go/util/testing"
+)
+
+// fakeMounter records the sizes of the tmpfs filesystems mounted, by
+// directory.
+type fakeMounter struct {
+	mounts     map[string]int64
+	mountErr   error
+	mountCalls []string
+}
+
+var _ Mounter = &fakeMounter{}
+
+func newFakeMounter() *fakeMounter {
+	return &fakeMounter{mounts: map[string]int64{}}
+}
+
+func (m *fakeMounter) Mount(dir string, sizeBytes int64) error {
+	if m.mountErr != nil {
+		return m.mountErr
+	}
+	m.mounts[dir] = sizeBytes
+	m.mountCalls = append(m.mountCalls, dir)
+	return nil
+}
+
+func (m *fakeMounter) Unmount(dir string) error {
+	if _, ok := m.mounts[dir]; !ok {
+		return fmt.Errorf("%s not mounted", dir)
+	}
+	delete(m.mounts, dir)
+	return nil
+}
+
+func (m *fakeMounter) IsMounted(dir string) (bool, error) {
+	_, ok := m.mounts[dir]
+	return ok, nil
+}
+
+func newClaim(size string, annotations map[string]string) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse(size),
+				},
+			},
+		},
+	}
+}
+
+func TestShouldProvision(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        string
+		annotations map[string]string
+		expected    bool
+	}{
+		{
+			name:        "no selected node",
+			size:        "1Mi",
+			annotations: map[string]string{},
+			expected:    true,
+		},
+		{
+			name:        "this node selected",
+			size:        "1Mi",
+			annotations: map[string]string{annSelectedNode: "node-1"},
+			expected:    true,
+		},
+		{
+			name:        "other node selected",
+			size:        "1Mi",
+			annotations: map[string]string{annSelectedNode: "node-2"},
+			expected:    false,
+		},
+		{
+			name:        "fits in what's left",
+			size:        "2Mi",
+			annotations: map[string]string{},
+			expected:    true,
+		},
+		{
+			name:        "doesn't fit in what's left",
+			size:        "3Mi",
+			annotations: map[string]string{},
+			expected:    false,
+		},
+	}
+	p, err := NewTmpfsProvisioner("/tmp", "node-1", "node-1", 4*1024*1024, newFakeMounter(), nil)
+	if err != nil {
+		t.Fatalf("Error creating provisioner: %v", err)
+	}
+	p.(*tmpfsProvisioner).allocated["pvc-0"] = 2 * 1024 * 1024
+	for _, test := range tests {
+		claim := newClaim(test.size, test.annotations)
+		if got := p.(*tmpfsProvisioner).ShouldProvision(claim); got != test.expected {
+			t.Logf("test case: %s", test.name)
+			t.Errorf("expected should provision %v but got %v", test.expected, got)
+		}
+	}
+}
+
+func TestProvisionDelete(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("tmpfsProvisionTest")
+	defer os.RemoveAll(tmpDir)
+
+	mounter := newFakeMounter()
+	p, err := NewTmpfsProvisioner(tmpDir, "node-1", "host-1", 2*1024*1024, mounter, nil)
+	if err != nil {
+		t.Fatalf("Error creating provisioner: %v", err)
+	}
+	options := controller.VolumeOptions{
+		PersistentVolumeReclaimPolicy: v1.PersistentVolumeReclaimDelete,
+		PVName:                        "pvc-1",
+		PVC:                           newClaim("1Mi", nil),
+	}
+
+	pv, err := p.Provision(options)
+	if err != nil {
+		t.Fatalf("Error provisioning volume: %v", err)
+	}
+	directory := path.Join(tmpDir, "pvc-1")
+	if pv.Spec.HostPath == nil || pv.Spec.HostPath.Path != directory {
+		t.Errorf("expected hostPath %s but got %+v", directory, pv.Spec.PersistentVolumeSource)
+	}
+	if size, ok := mounter.mounts[directory]; !ok || size != 1024*1024 {
+		t.Errorf("expected tmpfs of %d bytes mounted at %s but got %v", 1024*1024, directory, mounter.mounts)
+	}
+	if pv.Annotations[annProvisionerNode] != "node-1" {
+		t.Errorf("expected annotation %s node-1 but got %q", annProvisionerNode, pv.Annotations[annProvisionerNode])
+	}
+	affinity, err := helper.GetStorageNodeAffinityFromAnnotation(pv.Annotations)
+	if err != nil {
+		t.Errorf("Error getting node affinity: %v", err)
+	} else {
+		expected := []v1.NodeSelectorRequirement{{Key: hostnameLabel, Operator: v1.NodeSelectorOpIn, Values: []string{"host-1"}}}
+		got := affinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("expected node affinity %v but got %v", expected, got)
+		}
+	}
+
+	if _, err := p.Provision(options); err == nil {
+		t.Errorf("expected error provisioning existing volume but got none")
+	}
+	if size := p.(*tmpfsProvisioner).allocated["pvc-1"]; size != 1024*1024 {
+		t.Errorf("expected size of existing volume to stay allocated but got %d", size)
+	}
+
+	tooBig := controller.VolumeOptions{PVName: "pvc-2", PVC: newClaim("2Mi", nil)}
+	if _, err := p.Provision(tooBig); err == nil {
+		t.Errorf("expected error provisioning volume exceeding capacity but got none")
+	}
+
+	mounter.mountErr = fmt.Errorf("mount failed")
+	failed := controller.VolumeOptions{PVName: "pvc-3", PVC: newClaim("1Mi", nil)}
+	if _, err := p.Provision(failed); err == nil {
+		t.Errorf("expected error provisioning volume failing to mount but got none")
+	}
+	if _, err := os.Stat(path.Join(tmpDir, "pvc-3")); !os.IsNotExist(err) {
+		t.Errorf("expected directory of volume failing to mount to be removed but got %v", err)
+	}
+	if _, ok := p.(*tmpfsProvisioner).allocated["pvc-3"]; ok {
+		t.Errorf("expected size of volume failing to mount to be released")
+	}
+	mounter.mountErr = nil
+
+	other, _ := NewTmpfsProvisioner(tmpDir, "node-2", "host-2", 0, newFakeMounter(), nil)
+	if err := other.Delete(pv); err == nil {
+		t.Errorf("expected error deleting volume of another node but got none")
+	} else if _, ok := err.(*controller.IgnoredError); !ok {
+		t.Errorf("expected IgnoredError deleting volume of another node but got %v", err)
+	}
+
+	if err := p.Delete(pv); err != nil {
+		t.Errorf("Error deleting volume: %v", err)
+	}
+	if _, ok := mounter.mounts[directory]; ok {
+		t.Errorf("expected tmpfs at %s to be unmounted", directory)
+	}
+	if _, err := os.Stat(directory); !os.IsNotExist(err) {
+		t.Errorf("expected directory %s to be removed but got %v", directory, err)
+	}
+	if len(p.(*tmpfsProvisioner).allocated) != 0 {
+		t.Errorf("expected size of deleted volume to be released but got %v", p.(*tmpfsProvisioner).allocated)
+	}
+}
+
+func TestRemount(t *testing.T) {
+	tmpDir := utiltesting.MkTmpdirOrDie("tmpfsRemountTest")
+	defer os.RemoveAll(tmpDir)
+
+	newPV := func(name, node string) v1.PersistentVolume {
+		return v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: map[string]string{annProvisionerNode: node},
+			},
+			Spec: v1.PersistentVolumeSpec{
+				Capacity: v1.ResourceList{
+					v1.ResourceName(v1.ResourceStorage): resource.MustParse("1Mi"),
+				},
+				PersistentVolumeSource: v1.PersistentVolumeSource{
+					HostPath: &v1.HostPathVolumeSource{Path: path.Join(tmpDir, name)},
+				},
+			},
+		}
+	}
+	mounter := newFakeMounter()
+	mounter.mounts[path.Join(tmpDir, "pvc-mounted")] = 1024 * 1024
+	volumes := []v1.PersistentVolume{
+		newPV("pvc-lost", "node-1"),
+		newPV("pvc-mounted", "node-1"),
+		newPV("pvc-other", "node-2"),
+	}
+
+	p, err := NewTmpfsProvisioner(tmpDir, "node-1", "host-1", 0, mounter, volumes)
+	if err != nil {
+		t.Fatalf("Error creating provisioner: %v", err)
+	}
+	expected := []string{path.Join(tmpDir, "pvc-lost")}
+	if !reflect.DeepEqual(expected, mounter.mountCalls) {
+		t.Errorf("expected mounted again %v but got %v", expected, mounter.mountCalls)
+	}
+	allocated := map[string]int64{"pvc-lost": 1024 * 1024, "pvc-mounted": 1024 * 1024}
+	if !reflect.DeepEqual(allocated, p.(*tmpfsProvisioner).allocated) {
+		t.Errorf("expected allocated %v but got %v", allocated, p.(*tmpfsProvisioner).allocated)
+	}
+}